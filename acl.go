@@ -0,0 +1,131 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"net"
+	"sort"
+	"time"
+)
+
+// aclEntry is an [acl] applying to a listener.
+type aclEntry struct {
+	name     string
+	user     string
+	database string
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+}
+
+// Whether the entry applies to a session of params, which are nil if the session
+// didn't start in the clear, as a cancel request or a session encrypted end to end.
+// Entries of a user or database don't apply to those, but see deny().
+func (e aclEntry) matches(params map[string]string) bool {
+	if e.user == "" && e.database == "" {
+		return true
+	}
+	if params == nil {
+		return false
+	}
+
+	return (e.user == "" || e.user == params["user"]) &&
+		(e.database == "" || e.database == params["database"])
+}
+
+// listenerACL is what [acl] sections make of the clients of a listener: those at an
+// address any of them denies are turned away, and if any of them allows some, so are
+// those at addresses none allows.  An ACL of a user or database only applies to their
+// sessions, so that, say, analysts may reach a listener from their subnet as the
+// analytics user alone.  Since sessions that don't start in the clear may be of any
+// user or database, they're denied by any entry denying their address, but only
+// allowed by entries of every user and database.
+type listenerACL struct {
+	entries []aclEntry
+
+	// Whether any entry allows addresses, so that those none allows are denied.
+	allowing bool
+}
+
+// Return the ACL of the named listener, or nil if no [acl] of c applies to it.
+func newListenerACL(listener string, c *Config) *listenerACL {
+	names := make([]string, 0, len(c.ACL))
+	for name := range c.ACL {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var acl listenerACL
+	for _, name := range names {
+		a := c.ACL[name]
+		applies := len(a.Listener) == 0
+		for _, l := range a.Listener {
+			applies = applies || l == listener
+		}
+		if !applies {
+			continue
+		}
+
+		acl.entries = append(acl.entries, aclEntry{name: name, user: a.User, database: a.Database,
+			allow: a.allow, deny: a.deny})
+		acl.allowing = acl.allowing || len(a.allow) > 0
+	}
+
+	if len(acl.entries) == 0 {
+		return nil
+	}
+	return &acl
+}
+
+// Return why a session of params from addr is denied, or the empty string if it's
+// accepted.
+func (a *listenerACL) deny(addr net.Addr, params map[string]string) string {
+	if a == nil {
+		return ""
+	}
+
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		host, _, _ := net.SplitHostPort(addr.String())
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return fmt.Sprintf("unknown address %s", addr)
+	}
+
+	allowed := !a.allowing
+	for _, e := range a.entries {
+		matches := e.matches(params)
+		if containsIP(e.deny, ip) && (matches || params == nil) {
+			return fmt.Sprintf("denied by acl %s", e.name)
+		}
+		allowed = allowed || (matches && containsIP(e.allow, ip))
+	}
+
+	if !allowed {
+		return "not allowed by any acl"
+	}
+	return ""
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Turn away a client of listener that an ACL denies, telling it why.
+func (s *server) denied(conn net.Conn, listener, reason string) {
+	s.sink.AddCounter("arbiter_denied_connections_total", metrics.Labels{"listener": listener}, 1)
+	s.logger.Printf("Denying client %s on the %s listener: %s", conn.RemoteAddr(), listener, reason)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeFatal(conn, sqlstateInvalidAuthorization, fmt.Sprintf("arbiter: host %s %s", conn.RemoteAddr(), reason))
+	conn.Close()
+}
@@ -0,0 +1,95 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestListenerACL(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{
+		"acl.apps.listener=primary", "acl.apps.allow=10.1.0.0/16",
+		"acl.analysts.listener=follower", "acl.analysts.allow=10.2.0.0/16", "acl.analysts.user=analytics",
+		"acl.quarantine.deny=10.1.99.0/24",
+		"acl.admin.listener=follower", "acl.admin.allow=192.0.2.1",
+		"acl.contractors.user=contractor", "acl.contractors.deny=10.1.50.0/24",
+	})
+	if err != nil {
+		t.Fatalf("Expected the ACLs to be parsed; instead got %v", err)
+	}
+
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000} }
+	app := map[string]string{"user": "app", "database": "app"}
+	analytics := map[string]string{"user": "analytics", "database": "app"}
+	contractor := map[string]string{"user": "contractor", "database": "app"}
+	// The user of a session that starts with an SSLRequest, to be encrypted end to end,
+	// isn't known.
+	ssl := startupParameters(sslRequest())
+
+	for _, tc := range []struct {
+		listener string
+		addr     string
+		params   map[string]string
+		denied   bool
+	}{
+		{"primary", "10.1.2.3", app, false},
+		{"primary", "10.1.99.1", app, true},
+		{"primary", "10.2.0.1", analytics, true},
+		{"primary", "10.1.2.3", ssl, false},
+		{"primary", "10.1.50.1", app, false},
+		{"primary", "10.1.50.1", contractor, true},
+		{"primary", "10.1.50.1", ssl, true},
+		{"follower", "10.2.0.1", analytics, false},
+		{"follower", "10.2.0.1", app, true},
+		{"follower", "10.2.0.1", ssl, true},
+		{"follower", "10.1.2.3", app, true},
+		{"follower", "192.0.2.1", app, false},
+		{"follower", "192.0.2.2", app, true},
+		{"bounded", "203.0.113.1", app, false},
+		{"bounded", "10.1.99.1", app, true},
+	} {
+		reason := newListenerACL(tc.listener, c).deny(addr(tc.addr), tc.params)
+		if (reason != "") != tc.denied {
+			t.Errorf("Expected %s on the %s listener with %v to be denied: %v; instead got '%s'",
+				tc.addr, tc.listener, tc.params, tc.denied, reason)
+		}
+	}
+
+	if acl := newListenerACL("primary", &Config{}); acl != nil {
+		t.Errorf("Expected no ACL without [acl]; instead got %+v", acl)
+	}
+
+	for _, sets := range [][]string{
+		{"acl.bad.allow=10.0.0.0/33"},
+		{"acl.bad.deny=nowhere"},
+		{"acl.bad.allow=10.0.0.0/8", "acl.bad.listener=nonexistent"},
+		{"acl.bad.user=app"},
+	} {
+		if _, err := LoadConfig("./config.ini", nil, sets); err == nil {
+			t.Errorf("Expected %v to be rejected", sets)
+		}
+	}
+}
+
+func TestDenied(t *testing.T) {
+	mem := metrics.NewMemory()
+	s := &server{metrics: mem, sink: mem, logger: log.Default()}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.denied(conn, "primary", "not allowed by any acl")
+
+	msg, err := io.ReadAll(client)
+	if err != nil || len(msg) == 0 || msg[0] != 'E' || !strings.Contains(string(msg), sqlstateInvalidAuthorization) {
+		t.Fatalf("Expected an ErrorResponse with %s; instead got %q, %v", sqlstateInvalidAuthorization, msg, err)
+	}
+
+	var out strings.Builder
+	mem.WritePrometheus(&out)
+	if !strings.Contains(out.String(), `arbiter_denied_connections_total{listener="primary"} 1`) {
+		t.Fatalf("Expected the denied connection to be counted; instead got:\n%s", out.String())
+	}
+}
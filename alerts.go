@@ -0,0 +1,328 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The conditions alerts are raised on; see [alerts] on.
+const (
+	alertPrimaryLost = "primary-lost"
+	alertSplitBrain  = "split-brain"
+	alertNoReplicas  = "no-replicas"
+)
+
+var alertKinds = []string{alertPrimaryLost, alertSplitBrain, alertNoReplicas}
+
+// How often the alerter evaluates the conditions it alerts on, besides on every state
+// transition.
+const alertInterval = 5 * time.Second
+
+// The default endpoint of the PagerDuty Events API.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// alert is a condition of a pool being raised, or resolved.
+type alert struct {
+	Kind    string
+	Cluster string
+	Summary string
+
+	// Whether the condition is being resolved, when it was first raised, how long it
+	// lasted, until it last cleared, and how many times it cleared and came back in
+	// the meantime.
+	Resolved bool
+	Since    time.Time
+	Lasted   time.Duration
+	Flaps    int
+}
+
+// Key identifies the alerts of the same condition, such as to deduplicate them.
+func (a alert) Key() string {
+	if a.Cluster == "" {
+		return "arbiter/" + a.Kind
+	}
+	return "arbiter/" + a.Cluster + "/" + a.Kind
+}
+
+// Text describes the alert to people.
+func (a alert) Text() string {
+	where := "arbiter"
+	if a.Cluster != "" {
+		where = "arbiter cluster " + a.Cluster
+	}
+	if !a.Resolved {
+		return fmt.Sprintf("%s: %s: %s", where, a.Kind, a.Summary)
+	}
+
+	text := fmt.Sprintf("%s: %s resolved after %s", where, a.Kind, a.Lasted.Round(time.Second))
+	if a.Flaps > 0 {
+		text += fmt.Sprintf(" (flapped: %d)", a.Flaps)
+	}
+	return text
+}
+
+// notifier delivers alerts somewhere on-call sees them.
+type notifier interface {
+	notify(a alert) error
+}
+
+// alertPool is a pool the alerter watches: that of [main], named "", or of a [cluster].
+type alertPool struct {
+	name        string
+	pool        *pool.Pool
+	distributed bool
+	multiWriter bool
+}
+
+// incident is a condition of a pool that was raised and isn't resolved yet.  cleared
+// is when the condition last cleared, if it's clear.
+type incident struct {
+	alert
+	cleared time.Time
+}
+
+// alerter raises alerts through Slack and PagerDuty when a pool loses its primary,
+// when more than one of its backends takes writes, and when none of its followers are
+// available.  A condition is raised once, and resolved once it has stayed clear for
+// cooldown; clearing and coming back in the meantime, as a flapping backend does, isn't
+// raised again.  Failed deliveries are retried up to retries times.
+type alerter struct {
+	s         *server
+	pools     []alertPool
+	notifiers map[string]notifier
+	on        map[string]bool
+	cooldown  time.Duration
+	retries   int
+	now       func() time.Time
+
+	// The incidents not resolved yet, by their alert's key.
+	incidents map[string]*incident
+}
+
+func newAlerter(s *server, c *Config) *alerter {
+	a := &alerter{
+		s:         s,
+		notifiers: make(map[string]notifier),
+		on:        make(map[string]bool),
+		cooldown:  c.Alerts.cooldown,
+		retries:   c.Alerts.Retries,
+		now:       time.Now,
+		incidents: make(map[string]*incident),
+	}
+	for _, kind := range c.Alerts.on {
+		a.on[kind] = true
+	}
+
+	client := &http.Client{Timeout: c.Alerts.timeout}
+	if c.Alerts.SlackWebhook != "" {
+		a.notifiers["Slack"] = &slackNotifier{url: c.Alerts.SlackWebhook, client: client}
+	}
+	if c.Alerts.PagerDutyRoutingKey != "" {
+		source, _ := os.Hostname()
+		a.notifiers["PagerDuty"] = &pagerDutyNotifier{url: c.Alerts.PagerDutyURL, key: c.Alerts.PagerDutyRoutingKey,
+			source: source, client: client}
+	}
+
+	a.pools = append(a.pools, alertPool{"", s.pool, c.Main.ClusterMode == clusterDistributed, c.Main.MultiWriter})
+	for name, p := range s.clusters {
+		a.pools = append(a.pools, alertPool{name, p, c.Cluster[name].ClusterMode == clusterDistributed, false})
+	}
+	sort.Slice(a.pools, func(i, j int) bool { return a.pools[i].name < a.pools[j].name })
+
+	return a
+}
+
+// Evaluate the conditions of the pools on every state transition, and every
+// alertInterval, until the process exits.
+func (a *alerter) run() {
+	changed := make(chan struct{}, 1)
+	for _, ap := range a.pools {
+		ap.pool.SubscribeFunc(func(pool.Event) {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	t := time.NewTicker(alertInterval)
+	defer t.Stop()
+	for {
+		for _, al := range a.evaluate() {
+			a.deliver(al)
+		}
+
+		select {
+		case <-changed:
+		case <-t.C:
+		}
+	}
+}
+
+// Evaluate the conditions of the pools, returning the alerts to deliver: those of
+// conditions newly raised, and of those resolved.
+func (a *alerter) evaluate() []alert {
+	var alerts []alert
+	now := a.now()
+	for _, ap := range a.pools {
+		raised := conditions(ap.pool.Backends(), ap.distributed, ap.multiWriter)
+		for _, kind := range alertKinds {
+			if !a.on[kind] {
+				continue
+			}
+
+			al := alert{Kind: kind, Cluster: ap.name, Summary: raised[kind]}
+			inc := a.incidents[al.Key()]
+			switch {
+			case al.Summary != "" && inc == nil:
+				al.Since = now
+				a.incidents[al.Key()] = &incident{alert: al}
+				alerts = append(alerts, al)
+			case al.Summary != "" && !inc.cleared.IsZero():
+				inc.cleared = time.Time{}
+				inc.Flaps++
+			case al.Summary == "" && inc != nil && inc.cleared.IsZero():
+				inc.cleared = now
+			case al.Summary == "" && inc != nil && now.Sub(inc.cleared) >= a.cooldown:
+				delete(a.incidents, al.Key())
+				inc.Resolved, inc.Lasted = true, inc.cleared.Sub(inc.Since)
+				alerts = append(alerts, inc.alert)
+			}
+		}
+	}
+
+	return alerts
+}
+
+// Return the conditions backends, those of a pool, are in, each with a summary: no
+// primary, more than one backend taking writes, or more than one in a write group with
+// multi-writer, and no follower available while there are backends to be followers.
+// None apply to distributed pools, whose backends all take writes.
+func conditions(backends []pool.BackendInfo, distributed, multiWriter bool) map[string]string {
+	raised := make(map[string]string)
+	if len(backends) == 0 || distributed {
+		return raised
+	}
+
+	writers := make(map[string][]string)
+	followers := 0
+	for _, b := range backends {
+		switch b.State {
+		case pool.READ_WRITE:
+			group := ""
+			if multiWriter {
+				group = b.WriteGroup
+			}
+			writers[group] = append(writers[group], b.Name)
+		case pool.READ_ONLY:
+			followers++
+		}
+	}
+
+	if len(writers) == 0 {
+		raised[alertPrimaryLost] = fmt.Sprintf("none of the %d backends takes writes", len(backends))
+	}
+	var split []string
+	for group, names := range writers {
+		if len(names) > 1 {
+			if group != "" {
+				split = append(split, fmt.Sprintf("%s of write group %s", strings.Join(names, ", "), group))
+			} else {
+				split = append(split, strings.Join(names, ", "))
+			}
+		}
+	}
+	if len(split) > 0 {
+		sort.Strings(split)
+		raised[alertSplitBrain] = strings.Join(split, "; ") + " take writes at once"
+	}
+	if len(backends) > 1 && followers == 0 {
+		raised[alertNoReplicas] = fmt.Sprintf("none of the %d backends is an available follower", len(backends))
+	}
+
+	return raised
+}
+
+// Deliver al through every notifier, retrying each on its own.
+func (a *alerter) deliver(al alert) {
+	for name, n := range a.notifiers {
+		backoff := minReportBackoff
+		for i := 0; ; i++ {
+			err := n.notify(al)
+			if err == nil {
+				break
+			}
+			if i >= a.retries {
+				a.s.logger.Printf("Could not send alert %s to %s; giving up: %s", al.Key(), name, err)
+				break
+			}
+
+			a.s.logger.Printf("Could not send alert %s to %s; retrying in %s: %s", al.Key(), name, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxReportBackoff {
+				backoff = maxReportBackoff
+			}
+		}
+	}
+
+	a.s.logger.Printf("Alert: %s", al.Text())
+}
+
+// slackNotifier posts alerts to a Slack incoming webhook.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *slackNotifier) notify(a alert) error {
+	icon := ":rotating_light:"
+	if a.Resolved {
+		icon = ":white_check_mark:"
+	}
+	return postJSON(n.client, n.url, "", map[string]string{"text": icon + " " + a.Text()})
+}
+
+// pagerDutyNotifier triggers and resolves PagerDuty incidents through the Events API
+// v2, deduplicated by the alert's key.
+type pagerDutyNotifier struct {
+	url    string
+	key    string
+	source string
+	client *http.Client
+}
+
+// pagerDutyEvent is what the Events API v2 takes.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary   string `json:"summary"`
+	Source    string `json:"source"`
+	Severity  string `json:"severity"`
+	Component string `json:"component,omitempty"`
+	Class     string `json:"class"`
+}
+
+func (n *pagerDutyNotifier) notify(a alert) error {
+	e := pagerDutyEvent{RoutingKey: n.key, EventAction: "resolve", DedupKey: a.Key()}
+	if !a.Resolved {
+		e.EventAction = "trigger"
+		e.Payload = &pagerDutyPayload{
+			Summary:   a.Text(),
+			Source:    n.source,
+			Severity:  "critical",
+			Component: a.Cluster,
+			Class:     a.Kind,
+		}
+	}
+	return postJSON(n.client, n.url, "", e)
+}
@@ -0,0 +1,146 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlertConditions(t *testing.T) {
+	backend := func(name string, state pool.State, group string) pool.BackendInfo {
+		return pool.BackendInfo{Name: name, State: state, WriteGroup: group}
+	}
+
+	for _, test := range []struct {
+		backends    []pool.BackendInfo
+		multiWriter bool
+		want        []string
+	}{
+		{[]pool.BackendInfo{backend("a", pool.READ_WRITE, ""), backend("b", pool.READ_ONLY, "")}, false, nil},
+		{[]pool.BackendInfo{backend("a", pool.UNAVAILABLE, ""), backend("b", pool.READ_ONLY, "")}, false,
+			[]string{alertPrimaryLost}},
+		{[]pool.BackendInfo{backend("a", pool.READ_WRITE, ""), backend("b", pool.READ_WRITE, "")}, false,
+			[]string{alertSplitBrain, alertNoReplicas}},
+		{[]pool.BackendInfo{backend("a", pool.READ_WRITE, "eu"), backend("b", pool.READ_WRITE, "us")}, true,
+			[]string{alertNoReplicas}},
+		{[]pool.BackendInfo{backend("a", pool.READ_WRITE, "eu"), backend("b", pool.READ_WRITE, "eu")}, true,
+			[]string{alertSplitBrain, alertNoReplicas}},
+		{[]pool.BackendInfo{backend("a", pool.READ_WRITE, "")}, false, nil},
+	} {
+		var got []string
+		raised := conditions(test.backends, false, test.multiWriter)
+		for _, kind := range alertKinds {
+			if raised[kind] != "" {
+				got = append(got, kind)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Expected %v of %+v; instead got %v", test.want, test.backends, raised)
+		}
+	}
+
+	if raised := conditions([]pool.BackendInfo{backend("a", pool.UNAVAILABLE, "")}, true, false); len(raised) != 0 {
+		t.Errorf("Expected no conditions of a distributed pool; instead got %v", raised)
+	}
+}
+
+func TestAlerterCooldown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &queueBackend{}
+	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+	p.Put(b)
+	p.CheckAll()
+
+	now := time.Now()
+	a := &alerter{
+		pools:     []alertPool{{name: "", pool: p}},
+		on:        map[string]bool{alertPrimaryLost: true},
+		cooldown:  15 * time.Minute,
+		now:       func() time.Time { return now },
+		incidents: make(map[string]*incident),
+	}
+	setPrimary := func(primary bool) {
+		b.mu.Lock()
+		b.primary = primary
+		b.mu.Unlock()
+		p.CheckAll()
+	}
+
+	alerts := a.evaluate()
+	if len(alerts) != 1 || alerts[0].Kind != alertPrimaryLost || alerts[0].Resolved {
+		t.Fatalf("Expected the primary to be alerted lost; instead got %+v", alerts)
+	}
+	if alerts := a.evaluate(); len(alerts) != 0 {
+		t.Errorf("Expected the alert not to be repeated; instead got %+v", alerts)
+	}
+
+	// Flapping within the cooldown alerts neither again nor resolves.
+	for _, primary := range []bool{true, false, true} {
+		now = now.Add(time.Minute)
+		setPrimary(primary)
+		if alerts := a.evaluate(); len(alerts) != 0 {
+			t.Fatalf("Expected no alerts while flapping; instead got %+v", alerts)
+		}
+	}
+
+	now = now.Add(15 * time.Minute)
+	alerts = a.evaluate()
+	if len(alerts) != 1 || !alerts[0].Resolved || alerts[0].Flaps != 1 || alerts[0].Lasted != 3*time.Minute {
+		t.Fatalf("Expected the alert to be resolved after the cooldown; instead got %+v", alerts)
+	}
+	if text := alerts[0].Text(); text != "arbiter: primary-lost resolved after 3m0s (flapped: 1)" {
+		t.Errorf("Expected the resolution to be described; instead got %q", text)
+	}
+}
+
+func TestAlertNotifiers(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]map[string]any)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body map[string]any
+		json.NewDecoder(req.Body).Decode(&body)
+		mu.Lock()
+		bodies[req.URL.Path] = body
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c, err := LoadConfig("./config.ini", nil, []string{"alerts.slack-webhook=" + srv.URL + "/slack",
+		"alerts.pagerduty-routing-key=key", "alerts.pagerduty-url=" + srv.URL + "/pagerduty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var logs strings.Builder
+	s := &server{pool: pool.New(ctx), logger: log.New(&logs, "", 0)}
+	a := newAlerter(s, c)
+
+	a.deliver(alert{Kind: alertSplitBrain, Cluster: "billing", Summary: "a, b take writes at once"})
+	if text := bodies["/slack"]["text"]; text != ":rotating_light: arbiter cluster billing: split-brain: a, b take writes at once" {
+		t.Errorf("Expected the alert to be posted to Slack; instead got %v", text)
+	}
+	pd := bodies["/pagerduty"]
+	if pd["routing_key"] != "key" || pd["event_action"] != "trigger" || pd["dedup_key"] != "arbiter/billing/split-brain" {
+		t.Errorf("Expected a PagerDuty incident to be triggered; instead got %v", pd)
+	}
+
+	a.deliver(alert{Kind: alertSplitBrain, Cluster: "billing", Resolved: true})
+	if pd := bodies["/pagerduty"]; pd["event_action"] != "resolve" || pd["dedup_key"] != "arbiter/billing/split-brain" {
+		t.Errorf("Expected the PagerDuty incident to be resolved; instead got %v", pd)
+	}
+
+	if _, err := LoadConfig("./config.ini", nil, []string{"alerts.on=primary-gone"}); err == nil {
+		t.Errorf("Expected an unknown condition to be rejected")
+	}
+}
@@ -0,0 +1,217 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The header the admin API takes the name of the operator making a request from.
+const operatorHeader = "X-Arbiter-Operator"
+
+// The states of an operation.
+const (
+	opPending  = "pending"
+	opDone     = "done"
+	opFailed   = "failed"
+	opRejected = "rejected"
+	opExpired  = "expired"
+)
+
+var (
+	errUnknownOperation = errors.New("unknown operation")
+	errNotPending       = errors.New("operation is not pending")
+	errSelfApproval     = errors.New("operations must be approved by another operator")
+	errNoOperator       = errors.New("the operator is required in approval mode")
+)
+
+// operation is a destructive action awaiting approval, or one that was decided.
+type operation struct {
+	ID          string    `json:"id"`
+	Action      string    `json:"action"`
+	Target      string    `json:"target"`
+	State       string    `json:"state"`
+	RequestedBy string    `json:"requested_by"`
+	Requested   time.Time `json:"requested"`
+	Expires     time.Time `json:"expires"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+	Decided     time.Time `json:"decided,omitempty"`
+	Error       string    `json:"error,omitempty"`
+
+	run func() error
+}
+
+// approvals holds destructive actions, such as draining a backend, until an operator
+// other than the one who requested them approves them, within a TTL.  Every request
+// and decision is logged for audit, and kept for /operations.
+type approvals struct {
+	ttl    time.Duration
+	logger pool.Logger
+
+	mu     sync.Mutex
+	ops    []*operation
+	nextID int
+}
+
+func newApprovals(ttl time.Duration, logger pool.Logger) *approvals {
+	return &approvals{ttl: ttl, logger: logger}
+}
+
+// Request action on target on behalf of operator, to be run once approved.
+func (a *approvals) request(operator, action, target string, run func() error) operation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nextID++
+	now := time.Now()
+	op := &operation{
+		ID:          fmt.Sprintf("%d", a.nextID),
+		Action:      action,
+		Target:      target,
+		State:       opPending,
+		RequestedBy: operator,
+		Requested:   now,
+		Expires:     now.Add(a.ttl),
+		run:         run,
+	}
+	a.ops = append(a.ops, op)
+
+	a.logger.Printf("Audit: %s requested %s of %s as operation %s, pending approval until %s",
+		operator, action, target, op.ID, op.Expires.Format(time.RFC3339))
+
+	return *op
+}
+
+// Approve, or reject, the operation id on behalf of operator, running it if approved.
+func (a *approvals) decide(id, operator string, approve bool) (operation, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire()
+
+	var op *operation
+	for _, o := range a.ops {
+		if o.ID == id {
+			op = o
+		}
+	}
+
+	switch {
+	case op == nil:
+		return operation{}, errUnknownOperation
+	case op.State != opPending:
+		return *op, errNotPending
+	case approve && operator == op.RequestedBy:
+		return *op, errSelfApproval
+	}
+
+	op.DecidedBy, op.Decided = operator, time.Now()
+	if !approve {
+		op.State = opRejected
+		a.logger.Printf("Audit: %s rejected operation %s, %s of %s requested by %s",
+			operator, op.ID, op.Action, op.Target, op.RequestedBy)
+		return *op, nil
+	}
+
+	op.State = opDone
+	if err := op.run(); err != nil {
+		op.State, op.Error = opFailed, err.Error()
+	}
+	a.logger.Printf("Audit: %s approved operation %s, %s of %s requested by %s: %s",
+		operator, op.ID, op.Action, op.Target, op.RequestedBy, op.State)
+
+	return *op, nil
+}
+
+// Mark pending operations past their TTL expired.  a must be locked.
+func (a *approvals) expire() {
+	now := time.Now()
+	for _, op := range a.ops {
+		if op.State == opPending && now.After(op.Expires) {
+			op.State = opExpired
+			a.logger.Printf("Audit: operation %s, %s of %s requested by %s, expired unapproved",
+				op.ID, op.Action, op.Target, op.RequestedBy)
+		}
+	}
+}
+
+// Return a snapshot of all operations.
+func (a *approvals) list() []operation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire()
+
+	ret := make([]operation, 0, len(a.ops))
+	for _, op := range a.ops {
+		ret = append(ret, *op)
+	}
+
+	return ret
+}
+
+// Serve /operations, listing operations, and /operations/approve and
+// /operations/reject, POST only, which decide the operation given in the id parameter
+// on behalf of the operator named in the X-Arbiter-Operator header.
+func (s *server) handleOperations(w http.ResponseWriter, req *http.Request) {
+	if s.approvals == nil {
+		http.Error(w, "approval mode is off", http.StatusNotFound)
+		return
+	}
+
+	if req.URL.Path == "/operations" {
+		writeJSON(w, http.StatusOK, s.approvals.list())
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator := strings.TrimSpace(req.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+		return
+	}
+
+	var approve bool
+	switch req.URL.Path {
+	case "/operations/approve":
+		approve = true
+	case "/operations/reject":
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	op, err := s.approvals.decide(req.FormValue("id"), operator, approve)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, op)
+	case errUnknownOperation:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errSelfApproval:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, fmt.Sprintf("%s (%s)", err, op.State), http.StatusConflict)
+	}
+}
+
+// Write v as indented JSON with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
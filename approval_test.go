@@ -0,0 +1,75 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovals(t *testing.T) {
+	s := &server{pool: pool.New(context.Background()), approvals: newApprovals(time.Minute, log.Default())}
+	s.pool.PutNamed("pg1", &queueBackend{})
+
+	do := func(path, operator string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		if operator != "" {
+			req.Header.Set(operatorHeader, operator)
+		}
+
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(path, "/operations") {
+			s.handleOperations(w, req)
+		} else {
+			s.handleDrain(w, req)
+		}
+		return w
+	}
+
+	if w := do("/drain?backend=pg1", "alice"); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the drain to be held for approval; instead got %d", w.Code)
+	}
+
+	if b := s.pool.Backends()[0]; b.Draining {
+		t.Fatalf("Expected pg1 not to be drained before approval")
+	}
+
+	for _, c := range []struct {
+		path, operator string
+		code           int
+	}{
+		{"/operations/approve?id=1", "", http.StatusBadRequest},
+		{"/operations/approve?id=1", "alice", http.StatusForbidden},
+		{"/operations/approve?id=2", "bob", http.StatusNotFound},
+		{"/operations/approve?id=1", "bob", http.StatusOK},
+		{"/operations/reject?id=1", "carol", http.StatusConflict},
+	} {
+		if w := do(c.path, c.operator); w.Code != c.code {
+			t.Errorf("%s by %q: Expected %d; instead got %d: %s", c.path, c.operator, c.code, w.Code, w.Body)
+		}
+	}
+
+	if b := s.pool.Backends()[0]; !b.Draining {
+		t.Fatalf("Expected pg1 to be drained once approved")
+	}
+
+	if ops := s.approvals.list(); len(ops) != 1 || ops[0].State != opDone || ops[0].DecidedBy != "bob" {
+		t.Fatalf("Expected the approval to be recorded; instead got %+v", ops)
+	}
+}
+
+func TestApprovalExpiry(t *testing.T) {
+	a := newApprovals(-time.Second, log.Default())
+	a.request("alice", "drain", "pg1", func() error {
+		t.Fatalf("Expected an expired operation not to run")
+		return nil
+	})
+
+	if _, err := a.decide("1", "bob", true); err != errNotPending {
+		t.Fatalf("Expected the operation to have expired; instead got %v", err)
+	}
+}
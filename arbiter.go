@@ -1,18 +1,32 @@
-package main
+package arbiter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
 	"github.com/solvip/arbiter/pool"
+	"github.com/solvip/arbiter/tracing"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// How long sessions wait, at startup, for the backends configured to be checked once,
+// queued in the listen backlog, rather than being turned away while every backend is
+// still unavailable.
+const startupCheckTimeout = 5 * time.Second
+
 type connectionHandler func(net.Conn)
 
 type server struct {
@@ -23,6 +37,128 @@ type server struct {
 
 	// Current number of connections
 	nconns AtomicInt
+
+	// Current number of connections to backends
+	nbackends AtomicInt
+
+	// Bytes of proxy buffers currently allocated
+	buffered AtomicInt
+
+	// Clients turned away because of limits
+	rejected AtomicInt
+
+	// Clients waiting for a backend
+	queued AtomicInt
+
+	// Clients turned away because the pool is degraded
+	refused AtomicInt
+
+	// Sessions mirrored to the shadow, and those that stopped being mirrored because
+	// it failed or fell behind
+	mirrored       AtomicInt
+	mirrorFailures AtomicInt
+
+	limits Limits
+
+	// What clients are accepted on, by listener, and what stops the monitors of the
+	// pool; see shutdown().  The HTTP interface is served on httpListener, and the
+	// Control service on grpcListener, if it's served.
+	listeners    []namedListener
+	stopPool     context.CancelFunc
+	httpListener net.Listener
+	grpcListener net.Listener
+
+	// The sockets passed by systemd, or handed over by the arbiter this one took over
+	// from, that no listener has taken yet, and the pipe to tell the latter on once
+	// serving; see takeOver().
+	inherited []namedListener
+	takeover  *os.File
+
+	// The systemd arbiter notifies; nil unless started by systemd with Type=notify.
+	systemd *systemd
+
+	// Sheds new clients when arbiter is overloaded; nil if no threshold is set.
+	overload *overloadMonitor
+
+	// Limits the rate of new clients; nil if no rate is set.
+	throttle *connThrottle
+
+	// Holds destructive admin actions for approval; nil unless approval mode is on.
+	approvals *approvals
+
+	// Admin actions taken on many backends in the background; see handleBatches().
+	batches batches
+
+	// The sessions being proxied, and where they're captured to, if anywhere; see
+	// handleCapture().
+	live       liveSessions
+	captureDir string
+
+	// The zones clients are in, by their address, and the one arbiter is in, for
+	// those in none; see [main] client-zone and zone.
+	clientZones clientZones
+	zone        string
+
+	// What sessions that followers may serve stick to a follower by, if anything; see
+	// [main] sticky-reads.
+	stickyReads string
+
+	// The shadow the sessions on the primary are mirrored to, if any, and the share of
+	// them that are; see mirror.
+	shadow      string
+	shadowShare float64
+
+	// The pools of the other clusters listeners may route to, by name; see
+	// startClusters().
+	clusters map[string]*pool.Pool
+
+	// The state of the pool declared by the configuration file or through /config.
+	declared *declaration
+
+	// Keep the backends in sync with service registries; see [consul].
+	discoveries []*discovery
+
+	// Elects the instance of arbiter that serves sessions; nil unless [consul]
+	// leader-key is set.
+	election *election
+
+	// Records the history of backend states; nil unless [history] has a path.
+	history *history
+
+	// Counts the queries proxied to each backend, and turns them into signals for
+	// autoscalers; see [autoscale].
+	load      *readLoad
+	autoscale *autoscaler
+
+	// Static labels attached to all metrics, and to those of individual backends.
+	labels           metrics.Labels
+	perBackendLabels *backendLabels
+
+	// Measurements reported by the pool, served on /metrics, and where arbiter reports
+	// its own, with the static labels attached.
+	metrics *metrics.Memory
+	sink    metrics.Sink
+
+	// Where measurements are pushed as well, if [metrics] statsd is set.
+	statsd *metrics.Statsd
+
+	// Where spans of connecting clients are reported, and the exporter behind it if
+	// [tracing] endpoint is set.
+	tracer tracing.Tracer
+	otlp   *tracing.OTLP
+
+	// Where sessions, and the pool, log.
+	logger pool.Logger
+
+	// Writes a report when arbiter exits on a fatal error or a panic; nil unless
+	// [daemon] crash-report is set.
+	crash *crashReporter
+
+	// The last failovers of the pools, for the status page.
+	failovers failoverLog
+
+	// What's logged and measured, as raised at runtime through /verbosity.
+	verbosity *verbosity
 }
 
 type AtomicInt int64
@@ -35,56 +171,490 @@ func (i *AtomicInt) Get() int64 {
 	return atomic.LoadInt64((*int64)(i))
 }
 
-func main() {
+// TryAdd adds n unless that would take i above max, returning whether it did.
+// A max of 0 means unlimited.
+func (i *AtomicInt) TryAdd(n, max int64) bool {
+	for {
+		cur := i.Get()
+		if max > 0 && cur+n > max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64((*int64)(i), cur, cur+n) {
+			return true
+		}
+	}
+}
+
+// Main runs arbiter as the arbiter command does, with its flags and commands taken from
+// the command line, exiting once it's stopped.
+func Main() {
 	httpAddr := flag.String("p", "127.0.0.1:6060", "Enable the HTTP status interface")
 	cfgPath := flag.String("f", "/etc/arbiter/config.ini",
 		"The path to the arbiter configuration file")
+	var sets stringsFlag
+	flag.Var(&sets, "set", "Override a configuration variable, as section[.subsection].variable=value; may be repeated")
+	printVars := flag.Bool("config-vars", false,
+		"Print the environment variables and flags that override the configuration, and exit")
+	historyOf := flag.String("history", "",
+		"Print the recorded history of a backend, or of all backends if 'all', and exit")
+	historyFrom := flag.String("from", "", "The start of -history, e.g. '2006-01-02 15:04'; an hour before -to by default")
+	historyTo := flag.String("to", "", "The end of -history; now by default")
+	simulateHistory := flag.Bool("simulate", false,
+		"Replay the history recorded between -from and -to through the configured routing, print what arbiter would have done, and exit")
+	importFrom := flag.String("import", "",
+		"Print an arbiter configuration translated from an HAProxy or pgbouncer configuration file, and exit")
+	detach := flag.Bool("daemon", false, "Run in the background, detached from the terminal; see [daemon]")
+	serviceCmd := flag.String("service", "",
+		"On Windows, install, uninstall, start or stop arbiter as a service with the given -f, -p and -set, and exit")
+	serviceName := flag.String("service-name", "arbiter", "The name of the Windows service")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: arbiter [flags] [command [args]]\n\nFlags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output())
+		printCommands(flag.CommandLine.Output())
+	}
 	flag.Parse()
 
-	c, err := ConfigFromFile(*cfgPath)
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(*httpAddr, flag.Args(), os.Stdout))
+	}
+
+	if *serviceCmd != "" {
+		path, err := filepath.Abs(*cfgPath)
+		if err != nil {
+			log.Fatalf("Could not resolve %s: %s", *cfgPath, err)
+		}
+		args := []string{"-f", path, "-p", *httpAddr, "-service-name", *serviceName}
+		for _, set := range sets {
+			args = append(args, "-set", set)
+		}
+		if err := controlService(*serviceCmd, *serviceName, args); err != nil {
+			log.Fatalf("Could not %s the service: %s", *serviceCmd, err)
+		}
+		return
+	}
+	stop := make(chan os.Signal, 1)
+	if err := runAsService(*serviceName, stop); err != nil {
+		log.Fatalf("Could not run as a service: %s", err)
+	}
+
+	if *printVars {
+		fmt.Print(configVarsUsage())
+		return
+	}
+
+	if *importFrom != "" {
+		if err := importConfig(*importFrom, os.Stdout); err != nil {
+			log.Fatalf("Could not import %s: %s", *importFrom, err)
+		}
+		return
+	}
+
+	c, err := LoadConfig(*cfgPath, os.Environ(), sets)
 	if err != nil {
 		log.Fatalf("Could not load configuration file: %s", err)
 	}
 
+	if *historyOf != "" {
+		if err := queryHistory(c, *historyOf, *historyFrom, *historyTo); err != nil {
+			log.Fatalf("Could not query history: %s", err)
+		}
+		return
+	}
+
+	if *simulateHistory {
+		if err := simulate(c, *historyFrom, *historyTo, os.Stdout); err != nil {
+			log.Fatalf("Could not simulate: %s", err)
+		}
+		return
+	}
+
+	if *detach && os.Getenv(daemonEnv) == "" {
+		if err := daemonize(); err != nil {
+			log.Fatalf("Could not start in the background: %s", err)
+		}
+		return
+	}
+
+	setUmask(c.Daemon.umask)
+	out, err := logOutput(c)
+	if err != nil {
+		log.Fatalf("Could not open the log file: %s", err)
+	}
+	log.SetOutput(out)
+
 	s := &server{
-		pool: pool.New(),
+		systemd: newSystemd(),
+		limits: Limits{
+			MaxSessions:      c.Limits.MaxSessions,
+			MaxBackendConns:  c.Limits.MaxBackendConns,
+			MaxBufferedBytes: c.Limits.MaxBufferedBytes,
+
+			IdleTimeout:              c.Limits.idleTimeout,
+			IdleInTransactionTimeout: c.Limits.idleInTransactionTimeout,
+			ClientKeepAlive:          c.Limits.clientKeepAlive,
+			Linger:                   c.Limits.linger,
+			MaxSessionLifetime:       c.Limits.maxSessionLifetime,
+
+			ConnectionRate:        c.Limits.ConnectionRate,
+			ConnectionBurst:       c.Limits.ConnectionBurst,
+			ClientConnectionRate:  c.Limits.ConnectionRatePerClient,
+			ClientConnectionBurst: c.Limits.ConnectionBurstPerClient,
+			AcceptQueue:           c.Limits.AcceptQueue,
+		},
+		labels:           c.Metrics.labels,
+		perBackendLabels: newBackendLabels(),
+		metrics:          metrics.NewMemory(),
+		tracer:           tracing.Nop{},
+		logger:           log.Default(),
+	}
+
+	s.crash = newCrashReporter(*cfgPath, c)
+	defer s.crashOnPanic()
+
+	s.verbosity = newVerbosity(c.Main.logLevel)
+	l := newLogger(c, out, &s.verbosity.level)
+	if l != nil {
+		// The standard logger, and so s.logger, now logs records at INFO to l.
+		slog.SetDefault(l)
+	} else {
+		l = slog.New(pool.NewLineHandler(s.logger, &s.verbosity.level))
+	}
+
+	for name, b := range c.Backend {
+		s.perBackendLabels.set(name, b.labels)
+	}
+	if c.Metrics.Statsd != "" {
+		if s.statsd, err = metrics.NewStatsd(c.Metrics.Statsd, c.Metrics.StatsdPrefix, c.Metrics.statsdInterval); err != nil {
+			s.fatalf("Could not push metrics to %s: %s", c.Metrics.Statsd, err)
+		}
+		log.Printf("Pushing metrics to statsd at %s every %s", c.Metrics.Statsd, c.Metrics.statsdInterval)
+	}
+	s.sink = labelingSink{s.measured(), s.labels, s.perBackendLabels}
+	opts, err := routingOptions(c)
+	if err != nil {
+		s.fatalf("Could not load the scorer: %s", err)
+	}
+	if c.Tracing.Endpoint != "" {
+		if s.otlp, err = tracing.NewOTLP(c.Tracing.Endpoint, c.Tracing.ServiceName, c.Tracing.sampleRate,
+			c.Tracing.interval); err != nil {
+			s.fatalf("Could not export spans to %s: %s", c.Tracing.Endpoint, err)
+		}
+		s.tracer = s.otlp
+		opts = append(opts, pool.WithTracer(s.otlp))
+		log.Printf("Exporting spans to %s every %s", c.Tracing.Endpoint, c.Tracing.interval)
+	}
+	opts = append(opts, pool.WithCheckTimeout(c.Health.queryTimeout), pool.WithCheckJitter(c.Health.jitter),
+		pool.WithCheckBackoff(c.Health.checkBackoff), pool.WithCheckConcurrency(c.Health.CheckConcurrency),
+		pool.WithFlapThresholds(c.Health.DownAfter, c.Health.UpAfter),
+		pool.WithTransientGrace(c.Health.transientGrace),
+		pool.WithDegradeOn(c.Health.degradeOn...),
+		pool.WithCheckHistory(c.Health.CheckHistory, c.Health.checkWindows...),
+		pool.WithStickiness(c.Main.stickyTTL),
+		pool.WithFailoverPolicy(c.Main.failover),
+		pool.WithRebalance(c.Autoscale.RebalancePercent, c.Autoscale.RebalanceRate),
+		pool.WithMaxDials(c.Limits.MaxBackendDials),
+		pool.WithMaxConnections(c.Limits.MaxConnectionsPerBackend))
+	var ctx context.Context
+	ctx, s.stopPool = context.WithCancel(context.Background())
+	if err := s.startClusters(ctx, c, opts, l); err != nil {
+		s.fatalf("Could not add backend: %s", err)
+	}
+
+	opts = append(opts, pool.WithMetrics(s.sink), pool.WithStructuredLogger(l))
+	if c.Main.Fallback != "" {
+		opts = append(opts, pool.WithFallback(
+			pool.NewPostgresBackendWithSettings([]string{c.Main.Fallback}, c.Health.settings)))
+	}
+	if c.Main.FailoverJournal != "" {
+		opts = append(opts, pool.WithFailoverJournal(c.Main.FailoverJournal))
+	}
+	opts = append(opts, primaryOptions(c.Main.ClusterMode, c.Main.PrimaryArbitration)...)
+	var consul *consulWatcher
+	if c.Consul.Service != "" || c.Consul.Register != "" || c.Consul.LeaderKey != "" || c.Consul.ObserversPrefix != "" {
+		consul = newConsulWatcher(s, c)
+	}
+	var obs *observers
+	if c.Consul.ObserversPrefix != "" {
+		obs = newObservers(consul, c)
+		opts = append(opts, pool.WithObservers(obs, c.Consul.Observers))
+	}
+	if c.Fence.URL != "" || c.Fence.Exec != "" {
+		opts = append(opts, pool.WithFenceHook(newFenceHook(c).fence))
+	}
+	if c.Health.AnomalyThreshold > 0 {
+		opts = append(opts, pool.WithAnomalyDetector(
+			pool.NewEWMADetector(anomalyAlpha, c.Health.AnomalyThreshold, c.Health.AnomalyWarmup)))
+	}
+	s.pool = pool.New(ctx, opts...)
+	if obs != nil {
+		log.Printf("Sharing observations of the backends under %s in Consul", obs.prefix)
+		go obs.run()
+	}
+	if s.crash != nil {
+		go s.crash.record(s.pool)
+	}
+	s.failovers.record("", s.pool)
+	for name, p := range s.clusters {
+		s.failovers.record(name, p)
+	}
+
+	// Prefix events with the labels, so that logs from a fleet can be told apart.
+	if len(s.labels) > 0 {
+		log.SetPrefix(s.labels.String() + " ")
+	}
+
+	s.pool.CheckArchiver(c.Health.CheckArchiver)
+	s.pool.EnableHeartbeat(c.Health.HeartbeatTable, c.Health.HeartbeatCreate)
+	s.pool.PublishTopology(c.Health.TopologyTable, c.Health.TopologyCreate)
+	s.pool.EnableFencing(c.Health.FencingTable, c.Health.FencingCreate)
+	s.pool.ProbeChecksums(c.Health.ChecksumQuery, c.Health.checksumInterval)
+
+	for name, class := range c.Class {
+		pc := pool.Class{PrimaryOnly: class.PrimaryOnly, MaxLag: class.maxLag,
+			FollowersOnly: class.FollowersOnly, QuorumOnly: class.QuorumOnly, PreferQuorum: class.PreferQuorum,
+			Balance: class.balance, WriteGroup: class.WriteGroup}
+		s.pool.DefineClass(name, pc)
+		for _, p := range s.clusters {
+			p.DefineClass(name, pc)
+		}
 	}
 
 	for _, addr := range c.Main.Backends {
-		s.pool.Put(pool.NewPostgresBackend(addr, c.Health.Username, c.Health.Password, c.Health.Database))
+		if err := s.pool.Put(pool.NewBackend([]string{addr}, c.Health.settings)); err != nil {
+			s.fatalf("Could not add backend %s: %s", addr, err)
+		}
+	}
+
+	for name, b := range c.Backend {
+		if err := s.pool.PutNamed(name, pool.NewBackend(b.Address, b.settings)); err != nil {
+			s.fatalf("Could not add backend %s: %s", name, err)
+		}
+		s.pool.SetPromotionInfo(name, b.promotion)
+		s.pool.Expect(name, b.expect)
+		s.pool.LimitConnections(name, b.MaxConnections)
+		s.pool.SetWeight(name, b.weight)
+	}
+
+	s.declared = newDeclaration(c)
+	if c.Consul.LeaderKey != "" {
+		log.Printf("Electing the leader with the lock on %s in Consul", c.Consul.LeaderKey)
+		s.election = newElection(consul, c)
+		s.election.campaign()
+		go s.election.run()
 	}
+	go s.reloadOnHangup(*cfgPath, sets, c)
+
+	s.load = newReadLoad()
+	s.autoscale = newAutoscaler(s, c)
+	go s.autoscale.run()
+
+	if c.Admin.RequireApproval {
+		s.approvals = newApprovals(c.Admin.approvalTTL, s.logger)
+	}
+	s.captureDir = c.Admin.CaptureDir
+	s.clientZones, s.zone = c.Main.clientZones, c.Main.Zone
+	s.stickyReads = c.Main.StickyReads
+	s.shadow, s.shadowShare = c.Shadow.Address, c.Shadow.Share
+
+	go newWatchdog(s, c.Watchdog.limits).run()
+
+	s.throttle = newConnThrottle(s.limits)
+
+	if c.Limits.overload.enabled() {
+		s.overload = newOverloadMonitor(c.Limits.overload)
+		go s.overload.run()
+	}
+
+	if c.Report.URL != "" {
+		log.Printf("Reporting to %s every %s", c.Report.URL, c.Report.interval)
+		go newReporter(s, c.Report.URL, c.Report.Token, c.Report.interval).run()
+	}
+
+	if c.Consul.Service != "" {
+		log.Printf("Discovering backends from the Consul service %s", c.Consul.Service)
+		go consul.run()
+	}
+	if len(c.DNS.SRV) > 0 || len(c.DNS.Host) > 0 {
+		log.Printf("Discovering backends from DNS every %s", c.DNS.interval)
+		go newDNSWatcher(s, c).run()
+	}
+	if c.Aurora.Endpoint != "" {
+		log.Printf("Discovering backends from the Aurora cluster %s every %s", c.Aurora.Endpoint, c.Aurora.interval)
+		go newAuroraWatcher(s, c).run()
+	}
+	if len(c.Replication.Seed) > 0 {
+		log.Printf("Discovering backends from the replication topology of %s every %s",
+			strings.Join(c.Replication.Seed, ", "), c.Replication.interval)
+		go newReplicationWatcher(s, c).run()
+	}
+	if c.Kubernetes.Service != "" {
+		kw, err := newKubernetesWatcher(s, c)
+		if err != nil {
+			s.fatalf("Could not connect to Kubernetes: %s", err)
+		}
+		log.Printf("Discovering backends from the Kubernetes service %s/%s", kw.namespace, kw.service)
+		go kw.run(context.Background())
+	}
+	if c.Consul.Register != "" {
+		go consul.register(c.Consul.Register+"-primary", "strong", c.Main.Primary, c.Consul.Advertise, *httpAddr)
+		go consul.register(c.Consul.Register+"-replica", "eventual", c.Main.Follower, c.Consul.Advertise, *httpAddr)
+	}
+
+	if c.History.Path != "" {
+		if s.history, err = openHistory(c.History.Path, c.History.interval, c.History.retention); err != nil {
+			s.fatalf("Could not open the history database: %s", err)
+		}
+		go s.history.run(s.pool, s.logger)
+	}
+
+	if c.Hooks.URL != "" || c.Hooks.Exec != "" {
+		log.Printf("Firing hooks on %s", strings.Join(c.Hooks.on, ", "))
+		go newHookRunner(s, c).run()
+	}
+
+	if c.Bus.URL != "" {
+		bus, err := newEventBus(s, c.Bus.URL, c.Bus.Topic, c.Bus.Format)
+		if err != nil {
+			s.fatalf("Could not start the event bus: %s", err)
+		}
+		log.Printf("Publishing events to %s as %s", c.Bus.Topic, c.Bus.Format)
+		go bus.run()
+	}
+
+	// Everything is bound before privileges are dropped, since listeners may be on
+	// privileged ports.
+	if s.inherited, err = inheritListeners(listenFDsStart); err != nil {
+		s.fatalf("Could not start Arbiter: %s", err)
+	}
+	if s.inherited == nil {
+		if s.inherited, s.takeover, err = takeOver(listenFDsStart); err != nil {
+			s.fatalf("Could not start Arbiter: %s", err)
+		}
+	}
+	if s.httpListener = s.inherit("http", *httpAddr); s.httpListener == nil {
+		if s.httpListener, err = net.Listen("tcp", *httpAddr); err != nil {
+			s.fatalf("Could not start the HTTP server: %s", err)
+		}
+	}
+	if addr := c.Admin.GRPCAddress; addr != "" {
+		if s.grpcListener = s.inherit("grpc", addr); s.grpcListener == nil {
+			if s.grpcListener, err = net.Listen("tcp", addr); err != nil {
+				s.fatalf("Could not start the gRPC server: %s", err)
+			}
+		}
+	}
+	listeners := make(map[string]net.Listener)
+	for name, l := range c.Listener {
+		if listeners[name], err = s.listen(name, l.Address); err != nil {
+			s.fatalf("Could not start Arbiter: %s", err)
+		}
+	}
+	follower, err := s.listen("follower", c.Main.Follower)
+	if err != nil {
+		s.fatalf("Could not start Arbiter: %s", err)
+	}
+	primary, err := s.listen("primary", c.Main.Primary)
+	if err != nil {
+		s.fatalf("Could not start Arbiter: %s", err)
+	}
+	s.closeInherited()
+	predecessor := 0
+	if s.takeover != nil {
+		predecessor = os.Getppid()
+	}
+	if err := daemonStarted(c, predecessor); err != nil {
+		s.fatalf("Could not start Arbiter: %s", err)
+	}
+	for name, ln := range listeners {
+		s.listeners = append(s.listeners, namedListener{name, ln})
+	}
+	s.listeners = append(s.listeners, namedListener{"follower", follower}, namedListener{"primary", primary})
 
 	go func() {
 		log.Printf("Starting HTTP server; listening on %s", *httpAddr)
-		http.HandleFunc("/stats", s.handleStats)
-		log.Fatal(http.ListenAndServe(*httpAddr, nil))
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.handleStatus)
+		mux.HandleFunc("/stats", s.handleStats)
+		mux.HandleFunc("/metrics", s.handleMetrics)
+		mux.HandleFunc("/drain", s.handleDrain)
+		mux.HandleFunc("/resume", s.handleDrain)
+		mux.HandleFunc("/cordon", s.handleDrain)
+		mux.HandleFunc("/uncordon", s.handleDrain)
+		mux.HandleFunc("/check", s.handleCheck)
+		mux.HandleFunc("/backends", s.handleBackends)
+		mux.HandleFunc("/backends/", s.handleBackend)
+		mux.HandleFunc("/connstring", s.handleConnString)
+		mux.HandleFunc("/resolve", s.handleResolve)
+		mux.HandleFunc("/sessions", s.handleSessions)
+		mux.HandleFunc("/capture", s.handleCapture)
+		mux.HandleFunc("/topology", s.handleTopology)
+		mux.HandleFunc("/promotion", s.handlePromotion)
+		mux.HandleFunc("/events", s.handleEvents)
+		mux.HandleFunc("/history", s.handleHistory)
+		mux.HandleFunc("/config", s.handleConfig)
+		mux.HandleFunc("/verbosity", s.handleVerbosity)
+		mux.HandleFunc("/health", s.handleHealth)
+		mux.HandleFunc("/operations", s.handleOperations)
+		mux.HandleFunc("/operations/", s.handleOperations)
+		mux.HandleFunc("/batches", s.handleBatches)
+		mux.HandleFunc("/batches/", s.handleBatches)
+		mux.HandleFunc("/autoscale", s.autoscale.handleSignals)
+		mux.HandleFunc("/provision", s.autoscale.handleProvision)
+		if c.Admin.Debug {
+			s.handleDebug(mux)
+		}
+		// The listener is closed on handing over to a new arbiter; see restartOnSignal().
+		if err := http.Serve(s.httpListener, mux); !errors.Is(err, net.ErrClosed) {
+			s.fatalf("HTTP server failed: %s", err)
+		}
 	}()
+	if s.grpcListener != nil {
+		log.Printf("Starting gRPC server; listening on %s", c.Admin.GRPCAddress)
+		go s.serveControl(s.grpcListener)
+	}
 
-	go func() {
-		log.Printf("Starting follower listener; listening on %s", c.Main.Follower)
-		if err := s.startListener(c.Main.Follower, pool.READ_ONLY); err != nil {
-			log.Fatalf("Could not start Arbiter: %s", err)
+	ctx, cancel := context.WithTimeout(context.Background(), startupCheckTimeout)
+	if err := s.pool.WaitForChecks(ctx); err != nil {
+		log.Printf("Not every backend was checked within %s; starting anyway", startupCheckTimeout)
+	}
+	cancel()
+
+	// Once checked, so that backends not checked yet aren't alerted on.
+	if c.Alerts.SlackWebhook != "" || c.Alerts.PagerDutyRoutingKey != "" {
+		log.Printf("Alerting on %s", strings.Join(c.Alerts.on, ", "))
+		go newAlerter(s, c).run()
+	}
+	if c.Promotion.Mode != promotionOff {
+		log.Printf("Promoting a follower once the primary is down for %s, with %s", c.Promotion.confirm, c.Promotion.Mode)
+		go newPromoter(s, c).run()
+	}
+
+	for name, l := range c.Listener {
+		r := s.newRoute(name, l.Class, l.Cluster, l.degraded, l.tls, l.proxy, c)
+		if l.Cluster != "" {
+			log.Printf("Starting %s listener; listening on %s with class %s of cluster %s", name, l.Address, r.class, l.Cluster)
+		} else {
+			log.Printf("Starting %s listener; listening on %s with class %s", name, l.Address, r.class)
 		}
-	}()
+		go s.serve(listeners[name], r)
+	}
+
+	log.Printf("Starting follower listener; listening on %s", c.Main.Follower)
+	go s.serve(follower, s.newRoute("follower", "eventual", "", c.Main.degraded, c.Main.tls, c.Main.proxy, c))
 
 	log.Printf("Starting primary listener; listening on %s", c.Main.Primary)
-	if err := s.startListener(c.Main.Primary, pool.READ_WRITE); err != nil {
-		log.Fatalf("Could not start Arbiter: %s", err)
-	}
+	go s.serve(primary, s.newRoute("primary", "strong", "", c.Main.degraded, c.Main.tls, c.Main.proxy, c))
 
-	return
+	s.tookOver()
+	go s.notifyReady()
+	go s.pingWatchdog()
+	go s.restartOnSignal(c.Limits.shutdownTimeout)
+	s.stopOnSignal(stop, c.Limits.shutdownTimeout)
 }
 
 func (s *server) handleStats(w http.ResponseWriter, req *http.Request) {
-	curStats := struct {
-		TransferredBytes    int64 `json:"transferred_bytes"`
-		NumberOfConnections int64 `json:"connections"`
-	}{
-		s.transferred.Get(),
-		s.nconns.Get(),
-	}
-
-	b, err := json.MarshalIndent(curStats, "", "  ")
+	b, err := json.MarshalIndent(s.stats(), "", "  ")
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 	} else {
@@ -92,82 +662,392 @@ func (s *server) handleStats(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (s *server) startListener(addr string, state pool.State) error {
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+// Drain, cordon or resume the backends given in the backend parameter, which may be
+// repeated, as the path says; POST only.  /cordon drains them once the optional grace
+// parameter runs out, and /uncordon resumes them.  In approval mode, draining, now or
+// after a grace, is only requested, and answered with the pending operation; see
+// approvals.
+func (s *server) handleDrain(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
+	req.ParseForm()
+	names := req.Form["backend"]
+	if len(names) == 0 {
+		http.Error(w, "backend is required", http.StatusBadRequest)
+		return
+	}
+
+	s.drain(w, req, names, strings.TrimPrefix(req.URL.Path, "/"))
+}
+
+// Drain, cordon, or resume the backends named or addressed names, as action says,
+// answering req as handleDrain().
+func (s *server) drain(w http.ResponseWriter, req *http.Request, names []string, action string) {
+	var grace time.Duration
+	if g := req.FormValue("grace"); g != "" && action == "cordon" {
+		var err error
+		if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+			http.Error(w, fmt.Sprintf("invalid grace '%s'", g), http.StatusBadRequest)
+			return
+		}
+	}
+
+	op, err := s.drainAs(strings.TrimSpace(req.Header.Get(operatorHeader)), names, action, grace)
+	switch {
+	case err == errNoOperator:
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case op != nil:
+		writeJSON(w, http.StatusAccepted, op)
+	}
+}
+
+// Drain, cordon after grace, or resume the backends named or addressed names, as
+// action (drain, cordon, uncordon or resume) says, on behalf of operator.  None are if
+// any is unknown.  In approval mode, draining, now or after a grace, is only
+// requested, returning the pending operation, and operator is required for it.
+func (s *server) drainAs(operator string, names []string, action string, grace time.Duration) (*operation, error) {
+	op := s.pool.Drain
+	switch action {
+	case "resume", "uncordon":
+		op = s.pool.Resume
+	case "cordon":
+		op = func(name string) error { return s.pool.Cordon(name, grace) }
+	}
+
+	for _, name := range names {
+		known := false
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			known = b.Name == name || b.Addr == name
+			return !known
+		})
+
+		if !known {
+			return nil, fmt.Errorf("%s: %w", name, pool.ErrUnknownBackend)
+		}
+	}
+
+	run := func() error {
+		for _, name := range names {
+			if err := op(name); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	if s.approvals != nil && (action == "drain" || action == "cordon" && grace > 0) {
+		if operator == "" {
+			return nil, errNoOperator
+		}
+
+		pending := s.approvals.request(operator, action, strings.Join(names, ","), run)
+		return &pending, nil
+	}
+
+	return nil, run()
+}
+
+// Listen for clients of the listener name on addr, or on the socket systemd passed for
+// it, if any; see inherit().
+func (s *server) listen(name, addr string) (net.Listener, error) {
+	if ln := s.inherit(name, addr); ln != nil {
+		if tcp, ok := ln.(*net.TCPListener); ok {
+			return keepAliveListener{tcp, s.limits.ClientKeepAlive}, nil
+		}
+		return ln, nil
+	}
+
+	lc := net.ListenConfig{KeepAlive: s.limits.ClientKeepAlive}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// Accept clients on ln, routing them by r, until ln is closed.
+func (s *server) serve(ln net.Listener, r *route) {
 	for {
 		clientConn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			log.Printf("Error accepting client: %s", err)
+			s.logger.Printf("Error accepting client: %s", err)
+			continue
+		}
+		clientConn = r.client(clientConn)
+
+		if !s.leading() {
+			s.standby(clientConn)
+			continue
+		}
+
+		if resource := s.overloaded(); resource != "" {
+			s.shed(clientConn, r.listener, resource)
+			continue
+		}
+
+		wait, ok := s.throttle.admit()
+		if !ok {
+			s.throttled(clientConn, r.listener, throttleOverall)
+			continue
+		}
+
+		if !s.nconns.TryAdd(1, s.limits.MaxSessions) {
+			s.reject(clientConn, "too many client sessions")
+			continue
+		}
+
+		if !s.buffered.TryAdd(2*proxyBufferSize, s.limits.MaxBufferedBytes) {
+			s.nconns.Add(-1)
+			s.reject(clientConn, "proxy buffer budget exhausted")
 			continue
 		}
 
 		go func() {
-			s.nconns.Add(1)
+			defer s.crashOnPanic()
 			defer clientConn.Close()
 			defer s.nconns.Add(-1)
+			defer s.buffered.Add(-2 * proxyBufferSize)
 
-			var err error
-			var backend pool.Backend
+			// Clients over the overall rate wait their turn in the accept queue.
+			if wait > 0 {
+				time.Sleep(wait)
+			}
 
-			switch state {
-			case pool.READ_ONLY:
-				backend, err = s.pool.GetForRead()
-			case pool.READ_WRITE:
-				backend, err = s.pool.GetForWrite()
-			default:
-				panic("Unknown state")
+			if !s.nbackends.TryAdd(1, s.limits.MaxBackendConns) {
+				s.reject(clientConn, "too many backend connections")
+				return
 			}
+			defer s.nbackends.Add(-1)
 
-			if err != nil {
-				log.Printf("Couldn't retrieve a backend: %s", err)
+			conn := net.Conn(clientConn)
+			if r.proxy.accept {
+				var err error
+				if conn, err = acceptProxyHeader(clientConn); err != nil {
+					s.logger.Printf("Couldn't read the PROXY protocol header of %s: %s", clientConn.RemoteAddr(), err)
+					return
+				}
+				conn = r.client(conn)
+			}
+			// Clients are told apart by the address the PROXY protocol header gives.
+			if !s.throttle.admitClient(conn.RemoteAddr()) {
+				s.throttled(conn, r.listener, throttleClient)
+				return
+			}
+			if r.tls != nil {
+				tlsConn, err := terminateTLS(conn, r.tls)
+				if err != nil {
+					s.logger.Printf("Couldn't negotiate TLS with %s: %s", conn.RemoteAddr(), err)
+					return
+				}
+				conn = tlsConn
+			}
+
+			// Clients of MySQL clusters have no startup packet, and so no parameters to
+			// be routed by.
+			var packet []byte
+			var params map[string]string
+			client := conn
+			if !r.mysql {
+				var err error
+				if packet, _, err = readStartup(conn); err != nil {
+					s.logger.Printf("Couldn't read the startup packet of %s: %s", conn.RemoteAddr(), err)
+					return
+				}
+				params = startupParameters(packet)
+				client = newReplayConn(conn, packet)
+			}
+
+			// The span of connecting the client to a backend, which ends once it is, or
+			// the client is turned away, continuing the client's trace if it gives its
+			// traceparent.
+			ctx := context.Background()
+			if traceparent := startupParameter(params, traceparentParameter); traceparent != "" {
+				ctx = tracing.WithTraceparent(ctx, traceparent)
+			}
+			ctx, span := s.tracer.Start(ctx, "arbiter.accept")
+			span.SetAttribute("arbiter.listener", r.listener)
+			span.SetAttribute("client.address", conn.RemoteAddr().String())
+			connected := sync.OnceFunc(span.End)
+			defer connected()
+			span.SetAttribute("db.user", params["user"])
+			span.SetAttribute("db.namespace", params["database"])
+			if reason := r.acl.deny(conn.RemoteAddr(), params); reason != "" {
+				s.denied(conn, r.listener, reason)
 				return
 			}
 
-			backendConn, err := backend.Connect(5 * time.Second)
+			// Cancel requests of sessions we don't know the key of, such as those
+			// encrypted end to end, are routed as any other session.
+			if sess := s.live.keyed(cancelKey(packet)); sess != nil {
+				if err := s.forwardCancel(conn, sess, packet, r); err != nil {
+					s.logger.Printf("Couldn't forward the cancel request of %s: %s", conn.RemoteAddr(), err)
+				}
+				return
+			}
+
+			route := r.match(params)
+			ctx = pool.StickTo(ctx, stickyKey(s.stickyReads, conn.RemoteAddr(), params))
+			lsn, err := minLSN(params)
+			if err != nil {
+				s.logger.Printf("Turning away %s: %s: %s", conn.RemoteAddr(), minLSNParameter, err)
+				conn.SetWriteDeadline(time.Now().Add(time.Second))
+				writeFatal(conn, sqlstateInvalidParameterValue, "arbiter: "+minLSNParameter+": "+err.Error())
+				return
+			}
+			ctx = pool.AtLeast(ctx, lsn)
+			frontend, lease, mode, err := s.acquire(ctx, client, route)
 			if err != nil {
-				log.Printf("Couldn't connect to backend: %s", err)
+				span.SetError(err)
+				s.logger.Printf("Couldn't connect to backend: %s", err)
 				return
 			}
-			defer backendConn.Close()
+			s.routed(conn, route, lease.Name(), mode)
+			span.SetAttribute("arbiter.backend", lease.Name())
+			span.SetAttribute("arbiter.mode", mode.String())
+			connected()
 
-			err = s.proxy(clientConn, backendConn)
-			if err != io.EOF {
-				log.Printf("Error writing to or reading from backend: %s", err)
-				backend.Fail()
+			if route.proxy.send != "" {
+				if err := writeProxyHeader(lease, route.proxy.send, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+					s.logger.Printf("Couldn't send the PROXY protocol header to %s: %s", lease.Name(), err)
+					lease.Release(err)
+					return
+				}
+			}
+
+			if route.fenced {
+				if frontend, err = fenceStartup(frontend, s.pool.Epoch()); err != nil {
+					s.logger.Printf("Couldn't read the startup packet of %s: %s", conn.RemoteAddr(), err)
+					lease.Release(nil)
+					return
+				}
 			}
+
+			err = s.proxy(frontend, lease, lease.Drained(), mode)
+			switch {
+			case err == io.EOF || err == errDrained:
+				err = nil
+			case lease.Severed():
+				s.logger.Printf("Closed session of %s: still on %s past the failover grace", conn.RemoteAddr(), lease.Name())
+				err = nil
+			case err == errIdleTimeout || errors.Is(err, errClientGone):
+				s.logger.Printf("Closing session of %s: %s", conn.RemoteAddr(), err)
+				err = nil
+			default:
+				s.logger.Printf("Error writing to or reading from backend: %s", err)
+				lease.Backend().Fail()
+			}
+			lease.Release(err)
 		}()
 	}
 }
 
+// Queue a client until a backend is available, returning the client's connection to
+// proxy from, since its startup packet has been read to learn its user.  A mysqlClient
+// has none, and is queued as a user of its own.
+func (s *server) enqueue(conn net.Conn, queue *sessionQueue, mode pool.Mode) (net.Conn, *pool.Lease, error) {
+	var packet []byte
+	var user string
+	if _, ok := conn.(mysqlClient); !ok {
+		var err error
+		if packet, user, err = readStartup(conn); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	s.queued.Add(1)
+	lease, err := queue.wait(user)
+	s.queued.Add(-1)
+
+	switch err {
+	case errQueueFull:
+		s.reject(conn, "session queue is full")
+	case pool.ErrNoneAvailable:
+		err = s.refuse(conn, mode)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	if packet == nil {
+		return conn, lease, nil
+	}
+
+	return newReplayConn(conn, packet), lease, nil
+}
+
 // Proxy frontend <-> backend.
-// err will be the first error encountered reading from- or writing to backend.
-func (s *server) proxy(frontend, backend net.Conn) (err error) {
-	errch := make(chan error)
+// err will be the first error encountered reading from- or writing to backend, or
+// errDrained if the session was handed off after drained was closed, or once it
+// outlived the maximum session lifetime.  Unless mode is
+// HEALTHY, the client is told of it once it has connected.
+//
+// When one side finishes sending, the other is half-closed, and what it still sends is
+// passed on for up to the linger limit, so that the end of a session, such as the
+// answer to a Terminate or the last notifications of a LISTEN, isn't cut off; the
+// session then ends with io.EOF.  Likewise, a client handed off is half-closed, and what
+// it still sends discarded for up to the linger limit, so that one sending its next
+// query before reading that it's to reconnect reads it, rather than being reset.
+func (s *server) proxy(frontend, backend net.Conn, drained <-chan struct{}, mode pool.Mode) (err error) {
+	sess := newSession(frontend, backend, s.limits)
+	sess.mirror = s.mirrorOf(backend)
+	defer sess.mirror.close()
+	if l, ok := backend.(*pool.Lease); ok && s.load != nil {
+		sess.queries = s.load.counter(l.Name())
+	}
+	if mode != pool.HEALTHY {
+		sess.announce = parameterStatus(modeParameter, mode.String())
+	}
+	backendName := backend.RemoteAddr().String()
+	if l, ok := backend.(*pool.Lease); ok {
+		backendName = l.Name()
+	}
+	defer s.live.add(frontend.RemoteAddr().String(), backendName, sess)()
+
+	errch := make(chan error, 2)
+	done := make(chan struct{})
+	defer close(done)
+
+	var expired <-chan time.Time
+	if s.limits.MaxSessionLifetime > 0 {
+		t := time.NewTimer(s.limits.MaxSessionLifetime)
+		defer t.Stop()
+		expired = t.C
+	}
 
-	// Proxy frontend -> backend
 	go func() {
-		var n int
-		var rerr, werr error
+		select {
+		case <-drained:
+			sess.drain()
+		case <-expired:
+			sess.expire()
+		case <-done:
+		}
+	}()
 
-		buf := make([]byte, 4096)
+	// Proxy frontend -> backend
+	go func() {
+		buf := make([]byte, proxyBufferSize)
 		for {
-			backend.SetWriteDeadline(time.Time{})
-			n, rerr = frontend.Read(buf)
+			n, rerr := frontend.Read(buf)
 			s.transferred.Add(int64(n))
 			if n > 0 {
-				backend.SetWriteDeadline(time.Now().Add(1 * time.Second))
-				n, werr = backend.Write(buf[0:n])
-				if werr != nil {
+				sess.count(&sess.bytesIn, &sess.packetsIn, n)
+				if werr := sess.fromFrontend(buf[0:n]); werr != nil {
 					errch <- werr
 					break
 				}
 			}
 
+			if rerr == io.EOF && s.limits.Linger > 0 && closeWrite(backend) == nil {
+				errch <- errHalfClosed
+				break
+			}
 			if rerr != nil {
+				errch <- sess.clientFailed(rerr)
 				break
 			}
 		}
@@ -175,22 +1055,24 @@ func (s *server) proxy(frontend, backend net.Conn) (err error) {
 
 	// Proxy backend -> frontend
 	go func() {
-		var n int
-		var rerr, werr error
-
-		buf := make([]byte, 4096)
+		buf := make([]byte, proxyBufferSize)
 		for {
-			frontend.SetWriteDeadline(time.Time{})
-			n, rerr = backend.Read(buf)
+			n, rerr := backend.Read(buf)
 			s.transferred.Add(int64(n))
 			if n > 0 {
-				frontend.SetWriteDeadline(time.Now().Add(1 * time.Second))
-				n, werr = frontend.Write(buf[0:n])
-				if werr != nil {
+				sess.count(&sess.bytesOut, &sess.packetsOut, n)
+				if werr := sess.fromBackend(buf[0:n]); werr == errDrained {
+					errch <- werr
+					break
+				} else if werr != nil {
 					break
 				}
 			}
 
+			if rerr == io.EOF && s.limits.Linger > 0 && closeWrite(frontend) == nil {
+				errch <- errHalfClosed
+				break
+			}
 			if rerr != nil {
 				errch <- rerr
 				break
@@ -199,6 +1081,24 @@ func (s *server) proxy(frontend, backend net.Conn) (err error) {
 	}()
 
 	err = <-errch
+	if err == errHalfClosed {
+		t := time.NewTimer(s.limits.Linger)
+		select {
+		case err = <-errch:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+	if err == errHalfClosed {
+		err = io.EOF
+	}
+	if sess.wasHandedOff() {
+		if s.limits.Linger > 0 && closeWrite(frontend) == nil {
+			frontend.SetReadDeadline(time.Now().Add(s.limits.Linger))
+			io.Copy(io.Discard, frontend)
+		}
+		return errDrained
+	}
 
 	return err
 }
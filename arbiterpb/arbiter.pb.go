@@ -0,0 +1,1325 @@
+// The schema of the events arbiter publishes to Kafka or NATS with format = protobuf,
+// see [bus] in config.ini, and of the Control service it serves on [admin]
+// grpc-address.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: arbiter.proto
+
+package arbiterpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type State int32
+
+const (
+	State_UNAVAILABLE State = 0
+	State_READ_ONLY   State = 1
+	State_READ_WRITE  State = 2
+)
+
+// Enum value maps for State.
+var (
+	State_name = map[int32]string{
+		0: "UNAVAILABLE",
+		1: "READ_ONLY",
+		2: "READ_WRITE",
+	}
+	State_value = map[string]int32{
+		"UNAVAILABLE": 0,
+		"READ_ONLY":   1,
+		"READ_WRITE":  2,
+	}
+)
+
+func (x State) Enum() *State {
+	p := new(State)
+	*p = x
+	return p
+}
+
+func (x State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (State) Descriptor() protoreflect.EnumDescriptor {
+	return file_arbiter_proto_enumTypes[0].Descriptor()
+}
+
+func (State) Type() protoreflect.EnumType {
+	return &file_arbiter_proto_enumTypes[0]
+}
+
+func (x State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use State.Descriptor instead.
+func (State) EnumDescriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{0}
+}
+
+// A state transition of a backend, a violation of its expected role, or its detection
+// as a duplicate.
+type Event struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Name         string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Addr         string                 `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	From         State                  `protobuf:"varint,3,opt,name=from,proto3,enum=arbiter.State" json:"from,omitempty"`
+	To           State                  `protobuf:"varint,4,opt,name=to,proto3,enum=arbiter.State" json:"to,omitempty"`
+	TimeUnixNano int64                  `protobuf:"varint,5,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	// Set on the transition of a backend to primary that completes a failover.
+	Failover *Failover `protobuf:"bytes,6,opt,name=failover,proto3" json:"failover,omitempty"`
+	// Set if the role of the backend contradicts the one configured; from and to are
+	// then both its role.
+	Violation string `protobuf:"bytes,7,opt,name=violation,proto3" json:"violation,omitempty"`
+	// The labels of [metrics].
+	Labels map[string]string `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Set if the backend is the same server as the backend of this name, registered
+	// under another address; from and to are then both its state.
+	DuplicateOf string `protobuf:"bytes,9,opt,name=duplicate_of,json=duplicateOf,proto3" json:"duplicate_of,omitempty"`
+	// Set if the backend's health checks fail with an error of this kind (auth,
+	// permission, timeout, network or protocol) that doesn't take it out of routing;
+	// from and to are then both its state.
+	Degraded string `protobuf:"bytes,10,opt,name=degraded,proto3" json:"degraded,omitempty"`
+	// Set if the event is about arbiter itself rather than a backend, as when its
+	// watchdog finds it past one of its own limits, or back within them; name is then
+	// arbiter's.
+	SelfHealth string `protobuf:"bytes,11,opt,name=self_health,json=selfHealth,proto3" json:"self_health,omitempty"`
+	// Set if a series of the backend went anomalous, deviating from its baseline before
+	// any hard threshold trips; from and to are then both its state.
+	Anomaly       *Anomaly `protobuf:"bytes,12,opt,name=anomaly,proto3" json:"anomaly,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_arbiter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Event) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *Event) GetFrom() State {
+	if x != nil {
+		return x.From
+	}
+	return State_UNAVAILABLE
+}
+
+func (x *Event) GetTo() State {
+	if x != nil {
+		return x.To
+	}
+	return State_UNAVAILABLE
+}
+
+func (x *Event) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+func (x *Event) GetFailover() *Failover {
+	if x != nil {
+		return x.Failover
+	}
+	return nil
+}
+
+func (x *Event) GetViolation() string {
+	if x != nil {
+		return x.Violation
+	}
+	return ""
+}
+
+func (x *Event) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Event) GetDuplicateOf() string {
+	if x != nil {
+		return x.DuplicateOf
+	}
+	return ""
+}
+
+func (x *Event) GetDegraded() string {
+	if x != nil {
+		return x.Degraded
+	}
+	return ""
+}
+
+func (x *Event) GetSelfHealth() string {
+	if x != nil {
+		return x.SelfHealth
+	}
+	return ""
+}
+
+func (x *Event) GetAnomaly() *Anomaly {
+	if x != nil {
+		return x.Anomaly
+	}
+	return nil
+}
+
+// A measurement found anomalous.
+type Anomaly struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// latency or lag, in seconds.
+	Series   string  `protobuf:"bytes,1,opt,name=series,proto3" json:"series,omitempty"`
+	Value    float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Baseline float64 `protobuf:"fixed64,3,opt,name=baseline,proto3" json:"baseline,omitempty"`
+	// How far the value is from the baseline, in standard deviations.
+	Deviation     float64 `protobuf:"fixed64,4,opt,name=deviation,proto3" json:"deviation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Anomaly) Reset() {
+	*x = Anomaly{}
+	mi := &file_arbiter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Anomaly) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Anomaly) ProtoMessage() {}
+
+func (x *Anomaly) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Anomaly.ProtoReflect.Descriptor instead.
+func (*Anomaly) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Anomaly) GetSeries() string {
+	if x != nil {
+		return x.Series
+	}
+	return ""
+}
+
+func (x *Anomaly) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Anomaly) GetBaseline() float64 {
+	if x != nil {
+		return x.Baseline
+	}
+	return 0
+}
+
+func (x *Anomaly) GetDeviation() float64 {
+	if x != nil {
+		return x.Deviation
+	}
+	return 0
+}
+
+// The potential data loss of a failover.
+type Failover struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	From         string                 `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To           string                 `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	TimeUnixNano int64                  `protobuf:"varint,3,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	// Whether the WAL positions of both backends were known; the loss is unknown
+	// otherwise.
+	Known         bool   `protobuf:"varint,4,opt,name=known,proto3" json:"known,omitempty"`
+	LossBytes     uint64 `protobuf:"varint,5,opt,name=loss_bytes,json=lossBytes,proto3" json:"loss_bytes,omitempty"`
+	LossNanos     int64  `protobuf:"varint,6,opt,name=loss_nanos,json=lossNanos,proto3" json:"loss_nanos,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Failover) Reset() {
+	*x = Failover{}
+	mi := &file_arbiter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Failover) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Failover) ProtoMessage() {}
+
+func (x *Failover) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Failover.ProtoReflect.Descriptor instead.
+func (*Failover) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Failover) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Failover) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Failover) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+func (x *Failover) GetKnown() bool {
+	if x != nil {
+		return x.Known
+	}
+	return false
+}
+
+func (x *Failover) GetLossBytes() uint64 {
+	if x != nil {
+		return x.LossBytes
+	}
+	return 0
+}
+
+func (x *Failover) GetLossNanos() int64 {
+	if x != nil {
+		return x.LossNanos
+	}
+	return 0
+}
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The [cluster] watched; the backends in [main] if empty.
+	Cluster       string `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_arbiter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WatchRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+type BackendState struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Addr     string                 `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	State    State                  `protobuf:"varint,3,opt,name=state,proto3,enum=arbiter.State" json:"state,omitempty"`
+	LagNanos int64                  `protobuf:"varint,4,opt,name=lag_nanos,json=lagNanos,proto3" json:"lag_nanos,omitempty"`
+	Draining bool                   `protobuf:"varint,5,opt,name=draining,proto3" json:"draining,omitempty"`
+	Cordoned bool                   `protobuf:"varint,6,opt,name=cordoned,proto3" json:"cordoned,omitempty"`
+	Leases   int64                  `protobuf:"varint,7,opt,name=leases,proto3" json:"leases,omitempty"`
+	Weight   float64                `protobuf:"fixed64,8,opt,name=weight,proto3" json:"weight,omitempty"`
+	// Set once the backend is removed; the rest is as it was last sent.
+	Removed       bool  `protobuf:"varint,9,opt,name=removed,proto3" json:"removed,omitempty"`
+	TimeUnixNano  int64 `protobuf:"varint,10,opt,name=time_unix_nano,json=timeUnixNano,proto3" json:"time_unix_nano,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BackendState) Reset() {
+	*x = BackendState{}
+	mi := &file_arbiter_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackendState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackendState) ProtoMessage() {}
+
+func (x *BackendState) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackendState.ProtoReflect.Descriptor instead.
+func (*BackendState) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BackendState) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *BackendState) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *BackendState) GetState() State {
+	if x != nil {
+		return x.State
+	}
+	return State_UNAVAILABLE
+}
+
+func (x *BackendState) GetLagNanos() int64 {
+	if x != nil {
+		return x.LagNanos
+	}
+	return 0
+}
+
+func (x *BackendState) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *BackendState) GetCordoned() bool {
+	if x != nil {
+		return x.Cordoned
+	}
+	return false
+}
+
+func (x *BackendState) GetLeases() int64 {
+	if x != nil {
+		return x.Leases
+	}
+	return 0
+}
+
+func (x *BackendState) GetWeight() float64 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *BackendState) GetRemoved() bool {
+	if x != nil {
+		return x.Removed
+	}
+	return false
+}
+
+func (x *BackendState) GetTimeUnixNano() int64 {
+	if x != nil {
+		return x.TimeUnixNano
+	}
+	return 0
+}
+
+type AddBackendRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Named after its first address if empty.
+	Name    string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Address []string          `protobuf:"bytes,2,rep,name=address,proto3" json:"address,omitempty"`
+	Labels  map[string]string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Drained bool              `protobuf:"varint,4,opt,name=drained,proto3" json:"drained,omitempty"`
+	// How the backend ranks for promotion; priority is 1 if left out.
+	Priority    *int32 `protobuf:"varint,5,opt,name=priority,proto3,oneof" json:"priority,omitempty"`
+	Zone        string `protobuf:"bytes,6,opt,name=zone,proto3" json:"zone,omitempty"`
+	Synchronous bool   `protobuf:"varint,7,opt,name=synchronous,proto3" json:"synchronous,omitempty"`
+	WriteGroup  string `protobuf:"bytes,8,opt,name=write_group,json=writeGroup,proto3" json:"write_group,omitempty"`
+	// The default if zero, and no cap if negative.
+	MaxConnections int32 `protobuf:"varint,9,opt,name=max_connections,json=maxConnections,proto3" json:"max_connections,omitempty"`
+	// 1 if left out.
+	Weight        *float64 `protobuf:"fixed64,10,opt,name=weight,proto3,oneof" json:"weight,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddBackendRequest) Reset() {
+	*x = AddBackendRequest{}
+	mi := &file_arbiter_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddBackendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddBackendRequest) ProtoMessage() {}
+
+func (x *AddBackendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddBackendRequest.ProtoReflect.Descriptor instead.
+func (*AddBackendRequest) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AddBackendRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *AddBackendRequest) GetAddress() []string {
+	if x != nil {
+		return x.Address
+	}
+	return nil
+}
+
+func (x *AddBackendRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *AddBackendRequest) GetDrained() bool {
+	if x != nil {
+		return x.Drained
+	}
+	return false
+}
+
+func (x *AddBackendRequest) GetPriority() int32 {
+	if x != nil && x.Priority != nil {
+		return *x.Priority
+	}
+	return 0
+}
+
+func (x *AddBackendRequest) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *AddBackendRequest) GetSynchronous() bool {
+	if x != nil {
+		return x.Synchronous
+	}
+	return false
+}
+
+func (x *AddBackendRequest) GetWriteGroup() string {
+	if x != nil {
+		return x.WriteGroup
+	}
+	return ""
+}
+
+func (x *AddBackendRequest) GetMaxConnections() int32 {
+	if x != nil {
+		return x.MaxConnections
+	}
+	return 0
+}
+
+func (x *AddBackendRequest) GetWeight() float64 {
+	if x != nil && x.Weight != nil {
+		return *x.Weight
+	}
+	return 0
+}
+
+type RemoveBackendRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The name or address of the backend.
+	Backend       string `protobuf:"bytes,1,opt,name=backend,proto3" json:"backend,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveBackendRequest) Reset() {
+	*x = RemoveBackendRequest{}
+	mi := &file_arbiter_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveBackendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveBackendRequest) ProtoMessage() {}
+
+func (x *RemoveBackendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveBackendRequest.ProtoReflect.Descriptor instead.
+func (*RemoveBackendRequest) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RemoveBackendRequest) GetBackend() string {
+	if x != nil {
+		return x.Backend
+	}
+	return ""
+}
+
+type DrainRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The names or addresses of the backends.
+	Backend []string `protobuf:"bytes,1,rep,name=backend,proto3" json:"backend,omitempty"`
+	// drain, cordon, uncordon or resume; drain if empty.
+	Action string `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	// How long cordoned backends take new sessions before they're drained.
+	GraceNanos    int64 `protobuf:"varint,3,opt,name=grace_nanos,json=graceNanos,proto3" json:"grace_nanos,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DrainRequest) Reset() {
+	*x = DrainRequest{}
+	mi := &file_arbiter_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DrainRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DrainRequest) ProtoMessage() {}
+
+func (x *DrainRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DrainRequest.ProtoReflect.Descriptor instead.
+func (*DrainRequest) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DrainRequest) GetBackend() []string {
+	if x != nil {
+		return x.Backend
+	}
+	return nil
+}
+
+func (x *DrainRequest) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *DrainRequest) GetGraceNanos() int64 {
+	if x != nil {
+		return x.GraceNanos
+	}
+	return 0
+}
+
+type ChangeReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The changes made; none if they await approval.
+	Changes []*Change `protobuf:"bytes,1,rep,name=changes,proto3" json:"changes,omitempty"`
+	// Set in approval mode if the changes are pending, to be approved on /operations.
+	Pending       *Operation `protobuf:"bytes,2,opt,name=pending,proto3" json:"pending,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangeReply) Reset() {
+	*x = ChangeReply{}
+	mi := &file_arbiter_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangeReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeReply) ProtoMessage() {}
+
+func (x *ChangeReply) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeReply.ProtoReflect.Descriptor instead.
+func (*ChangeReply) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ChangeReply) GetChanges() []*Change {
+	if x != nil {
+		return x.Changes
+	}
+	return nil
+}
+
+func (x *ChangeReply) GetPending() *Operation {
+	if x != nil {
+		return x.Pending
+	}
+	return nil
+}
+
+type Change struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Action        string                 `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"`
+	Target        string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Detail        string                 `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Change) Reset() {
+	*x = Change{}
+	mi := &file_arbiter_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Change) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Change) ProtoMessage() {}
+
+func (x *Change) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Change.ProtoReflect.Descriptor instead.
+func (*Change) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Change) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Change) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Change) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+type Operation struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Action          string                 `protobuf:"bytes,2,opt,name=action,proto3" json:"action,omitempty"`
+	Target          string                 `protobuf:"bytes,3,opt,name=target,proto3" json:"target,omitempty"`
+	RequestedBy     string                 `protobuf:"bytes,4,opt,name=requested_by,json=requestedBy,proto3" json:"requested_by,omitempty"`
+	ExpiresUnixNano int64                  `protobuf:"varint,5,opt,name=expires_unix_nano,json=expiresUnixNano,proto3" json:"expires_unix_nano,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Operation) Reset() {
+	*x = Operation{}
+	mi := &file_arbiter_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Operation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Operation) ProtoMessage() {}
+
+func (x *Operation) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Operation.ProtoReflect.Descriptor instead.
+func (*Operation) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Operation) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Operation) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *Operation) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *Operation) GetRequestedBy() string {
+	if x != nil {
+		return x.RequestedBy
+	}
+	return ""
+}
+
+func (x *Operation) GetExpiresUnixNano() int64 {
+	if x != nil {
+		return x.ExpiresUnixNano
+	}
+	return 0
+}
+
+type DialRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The consistency class; eventual if empty.
+	Class string `protobuf:"bytes,1,opt,name=class,proto3" json:"class,omitempty"`
+	// The [cluster]; the backends in [main] if empty.
+	Cluster string `protobuf:"bytes,2,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	// The client, as host:port, which tells its zone, and, with the user and database
+	// it'd start its session with, what it sticks to a follower by; see [main]
+	// client-zone and sticky-reads.
+	ClientAddr    string `protobuf:"bytes,3,opt,name=client_addr,json=clientAddr,proto3" json:"client_addr,omitempty"`
+	User          string `protobuf:"bytes,4,opt,name=user,proto3" json:"user,omitempty"`
+	Database      string `protobuf:"bytes,5,opt,name=database,proto3" json:"database,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DialRequest) Reset() {
+	*x = DialRequest{}
+	mi := &file_arbiter_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DialRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DialRequest) ProtoMessage() {}
+
+func (x *DialRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DialRequest.ProtoReflect.Descriptor instead.
+func (*DialRequest) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DialRequest) GetClass() string {
+	if x != nil {
+		return x.Class
+	}
+	return ""
+}
+
+func (x *DialRequest) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+func (x *DialRequest) GetClientAddr() string {
+	if x != nil {
+		return x.ClientAddr
+	}
+	return ""
+}
+
+func (x *DialRequest) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+func (x *DialRequest) GetDatabase() string {
+	if x != nil {
+		return x.Database
+	}
+	return ""
+}
+
+type DialDecision struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The backend the session would be routed to; empty if there's none.
+	Backend string `protobuf:"bytes,1,opt,name=backend,proto3" json:"backend,omitempty"`
+	Addr    string `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
+	// HEALTHY, or the degraded mode of the pool, such as NO_PRIMARY, whose handling is
+	// up to the listener; see [main].
+	Mode string `protobuf:"bytes,3,opt,name=mode,proto3" json:"mode,omitempty"`
+	// The zone of the client, whose backends are preferred.
+	Zone string `protobuf:"bytes,4,opt,name=zone,proto3" json:"zone,omitempty"`
+	// The backends the class may be routed to right now, closest first.
+	Candidates    []string `protobuf:"bytes,5,rep,name=candidates,proto3" json:"candidates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DialDecision) Reset() {
+	*x = DialDecision{}
+	mi := &file_arbiter_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DialDecision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DialDecision) ProtoMessage() {}
+
+func (x *DialDecision) ProtoReflect() protoreflect.Message {
+	mi := &file_arbiter_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DialDecision.ProtoReflect.Descriptor instead.
+func (*DialDecision) Descriptor() ([]byte, []int) {
+	return file_arbiter_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *DialDecision) GetBackend() string {
+	if x != nil {
+		return x.Backend
+	}
+	return ""
+}
+
+func (x *DialDecision) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *DialDecision) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *DialDecision) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *DialDecision) GetCandidates() []string {
+	if x != nil {
+		return x.Candidates
+	}
+	return nil
+}
+
+var File_arbiter_proto protoreflect.FileDescriptor
+
+const file_arbiter_proto_rawDesc = "" +
+	"\n" +
+	"\rarbiter.proto\x12\aarbiter\"\xe1\x03\n" +
+	"\x05Event\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04addr\x18\x02 \x01(\tR\x04addr\x12\"\n" +
+	"\x04from\x18\x03 \x01(\x0e2\x0e.arbiter.StateR\x04from\x12\x1e\n" +
+	"\x02to\x18\x04 \x01(\x0e2\x0e.arbiter.StateR\x02to\x12$\n" +
+	"\x0etime_unix_nano\x18\x05 \x01(\x03R\ftimeUnixNano\x12-\n" +
+	"\bfailover\x18\x06 \x01(\v2\x11.arbiter.FailoverR\bfailover\x12\x1c\n" +
+	"\tviolation\x18\a \x01(\tR\tviolation\x122\n" +
+	"\x06labels\x18\b \x03(\v2\x1a.arbiter.Event.LabelsEntryR\x06labels\x12!\n" +
+	"\fduplicate_of\x18\t \x01(\tR\vduplicateOf\x12\x1a\n" +
+	"\bdegraded\x18\n" +
+	" \x01(\tR\bdegraded\x12\x1f\n" +
+	"\vself_health\x18\v \x01(\tR\n" +
+	"selfHealth\x12*\n" +
+	"\aanomaly\x18\f \x01(\v2\x10.arbiter.AnomalyR\aanomaly\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"q\n" +
+	"\aAnomaly\x12\x16\n" +
+	"\x06series\x18\x01 \x01(\tR\x06series\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value\x12\x1a\n" +
+	"\bbaseline\x18\x03 \x01(\x01R\bbaseline\x12\x1c\n" +
+	"\tdeviation\x18\x04 \x01(\x01R\tdeviation\"\xa8\x01\n" +
+	"\bFailover\x12\x12\n" +
+	"\x04from\x18\x01 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x02 \x01(\tR\x02to\x12$\n" +
+	"\x0etime_unix_nano\x18\x03 \x01(\x03R\ftimeUnixNano\x12\x14\n" +
+	"\x05known\x18\x04 \x01(\bR\x05known\x12\x1d\n" +
+	"\n" +
+	"loss_bytes\x18\x05 \x01(\x04R\tlossBytes\x12\x1d\n" +
+	"\n" +
+	"loss_nanos\x18\x06 \x01(\x03R\tlossNanos\"(\n" +
+	"\fWatchRequest\x12\x18\n" +
+	"\acluster\x18\x01 \x01(\tR\acluster\"\xa1\x02\n" +
+	"\fBackendState\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04addr\x18\x02 \x01(\tR\x04addr\x12$\n" +
+	"\x05state\x18\x03 \x01(\x0e2\x0e.arbiter.StateR\x05state\x12\x1b\n" +
+	"\tlag_nanos\x18\x04 \x01(\x03R\blagNanos\x12\x1a\n" +
+	"\bdraining\x18\x05 \x01(\bR\bdraining\x12\x1a\n" +
+	"\bcordoned\x18\x06 \x01(\bR\bcordoned\x12\x16\n" +
+	"\x06leases\x18\a \x01(\x03R\x06leases\x12\x16\n" +
+	"\x06weight\x18\b \x01(\x01R\x06weight\x12\x18\n" +
+	"\aremoved\x18\t \x01(\bR\aremoved\x12$\n" +
+	"\x0etime_unix_nano\x18\n" +
+	" \x01(\x03R\ftimeUnixNano\"\xac\x03\n" +
+	"\x11AddBackendRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aaddress\x18\x02 \x03(\tR\aaddress\x12>\n" +
+	"\x06labels\x18\x03 \x03(\v2&.arbiter.AddBackendRequest.LabelsEntryR\x06labels\x12\x18\n" +
+	"\adrained\x18\x04 \x01(\bR\adrained\x12\x1f\n" +
+	"\bpriority\x18\x05 \x01(\x05H\x00R\bpriority\x88\x01\x01\x12\x12\n" +
+	"\x04zone\x18\x06 \x01(\tR\x04zone\x12 \n" +
+	"\vsynchronous\x18\a \x01(\bR\vsynchronous\x12\x1f\n" +
+	"\vwrite_group\x18\b \x01(\tR\n" +
+	"writeGroup\x12'\n" +
+	"\x0fmax_connections\x18\t \x01(\x05R\x0emaxConnections\x12\x1b\n" +
+	"\x06weight\x18\n" +
+	" \x01(\x01H\x01R\x06weight\x88\x01\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\v\n" +
+	"\t_priorityB\t\n" +
+	"\a_weight\"0\n" +
+	"\x14RemoveBackendRequest\x12\x18\n" +
+	"\abackend\x18\x01 \x01(\tR\abackend\"a\n" +
+	"\fDrainRequest\x12\x18\n" +
+	"\abackend\x18\x01 \x03(\tR\abackend\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x1f\n" +
+	"\vgrace_nanos\x18\x03 \x01(\x03R\n" +
+	"graceNanos\"f\n" +
+	"\vChangeReply\x12)\n" +
+	"\achanges\x18\x01 \x03(\v2\x0f.arbiter.ChangeR\achanges\x12,\n" +
+	"\apending\x18\x02 \x01(\v2\x12.arbiter.OperationR\apending\"P\n" +
+	"\x06Change\x12\x16\n" +
+	"\x06action\x18\x01 \x01(\tR\x06action\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12\x16\n" +
+	"\x06detail\x18\x03 \x01(\tR\x06detail\"\x9a\x01\n" +
+	"\tOperation\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06action\x18\x02 \x01(\tR\x06action\x12\x16\n" +
+	"\x06target\x18\x03 \x01(\tR\x06target\x12!\n" +
+	"\frequested_by\x18\x04 \x01(\tR\vrequestedBy\x12*\n" +
+	"\x11expires_unix_nano\x18\x05 \x01(\x03R\x0fexpiresUnixNano\"\x8e\x01\n" +
+	"\vDialRequest\x12\x14\n" +
+	"\x05class\x18\x01 \x01(\tR\x05class\x12\x18\n" +
+	"\acluster\x18\x02 \x01(\tR\acluster\x12\x1f\n" +
+	"\vclient_addr\x18\x03 \x01(\tR\n" +
+	"clientAddr\x12\x12\n" +
+	"\x04user\x18\x04 \x01(\tR\x04user\x12\x1a\n" +
+	"\bdatabase\x18\x05 \x01(\tR\bdatabase\"\x84\x01\n" +
+	"\fDialDecision\x12\x18\n" +
+	"\abackend\x18\x01 \x01(\tR\abackend\x12\x12\n" +
+	"\x04addr\x18\x02 \x01(\tR\x04addr\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\tR\x04mode\x12\x12\n" +
+	"\x04zone\x18\x04 \x01(\tR\x04zone\x12\x1e\n" +
+	"\n" +
+	"candidates\x18\x05 \x03(\tR\n" +
+	"candidates*7\n" +
+	"\x05State\x12\x0f\n" +
+	"\vUNAVAILABLE\x10\x00\x12\r\n" +
+	"\tREAD_ONLY\x10\x01\x12\x0e\n" +
+	"\n" +
+	"READ_WRITE\x10\x022\xb3\x02\n" +
+	"\aControl\x127\n" +
+	"\x05Watch\x12\x15.arbiter.WatchRequest\x1a\x15.arbiter.BackendState0\x01\x12>\n" +
+	"\n" +
+	"AddBackend\x12\x1a.arbiter.AddBackendRequest\x1a\x14.arbiter.ChangeReply\x12D\n" +
+	"\rRemoveBackend\x12\x1d.arbiter.RemoveBackendRequest\x1a\x14.arbiter.ChangeReply\x124\n" +
+	"\x05Drain\x12\x15.arbiter.DrainRequest\x1a\x14.arbiter.ChangeReply\x123\n" +
+	"\x04Dial\x12\x14.arbiter.DialRequest\x1a\x15.arbiter.DialDecisionB%Z#github.com/solvip/arbiter/arbiterpbb\x06proto3"
+
+var (
+	file_arbiter_proto_rawDescOnce sync.Once
+	file_arbiter_proto_rawDescData []byte
+)
+
+func file_arbiter_proto_rawDescGZIP() []byte {
+	file_arbiter_proto_rawDescOnce.Do(func() {
+		file_arbiter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_arbiter_proto_rawDesc), len(file_arbiter_proto_rawDesc)))
+	})
+	return file_arbiter_proto_rawDescData
+}
+
+var file_arbiter_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_arbiter_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_arbiter_proto_goTypes = []any{
+	(State)(0),                   // 0: arbiter.State
+	(*Event)(nil),                // 1: arbiter.Event
+	(*Anomaly)(nil),              // 2: arbiter.Anomaly
+	(*Failover)(nil),             // 3: arbiter.Failover
+	(*WatchRequest)(nil),         // 4: arbiter.WatchRequest
+	(*BackendState)(nil),         // 5: arbiter.BackendState
+	(*AddBackendRequest)(nil),    // 6: arbiter.AddBackendRequest
+	(*RemoveBackendRequest)(nil), // 7: arbiter.RemoveBackendRequest
+	(*DrainRequest)(nil),         // 8: arbiter.DrainRequest
+	(*ChangeReply)(nil),          // 9: arbiter.ChangeReply
+	(*Change)(nil),               // 10: arbiter.Change
+	(*Operation)(nil),            // 11: arbiter.Operation
+	(*DialRequest)(nil),          // 12: arbiter.DialRequest
+	(*DialDecision)(nil),         // 13: arbiter.DialDecision
+	nil,                          // 14: arbiter.Event.LabelsEntry
+	nil,                          // 15: arbiter.AddBackendRequest.LabelsEntry
+}
+var file_arbiter_proto_depIdxs = []int32{
+	0,  // 0: arbiter.Event.from:type_name -> arbiter.State
+	0,  // 1: arbiter.Event.to:type_name -> arbiter.State
+	3,  // 2: arbiter.Event.failover:type_name -> arbiter.Failover
+	14, // 3: arbiter.Event.labels:type_name -> arbiter.Event.LabelsEntry
+	2,  // 4: arbiter.Event.anomaly:type_name -> arbiter.Anomaly
+	0,  // 5: arbiter.BackendState.state:type_name -> arbiter.State
+	15, // 6: arbiter.AddBackendRequest.labels:type_name -> arbiter.AddBackendRequest.LabelsEntry
+	10, // 7: arbiter.ChangeReply.changes:type_name -> arbiter.Change
+	11, // 8: arbiter.ChangeReply.pending:type_name -> arbiter.Operation
+	4,  // 9: arbiter.Control.Watch:input_type -> arbiter.WatchRequest
+	6,  // 10: arbiter.Control.AddBackend:input_type -> arbiter.AddBackendRequest
+	7,  // 11: arbiter.Control.RemoveBackend:input_type -> arbiter.RemoveBackendRequest
+	8,  // 12: arbiter.Control.Drain:input_type -> arbiter.DrainRequest
+	12, // 13: arbiter.Control.Dial:input_type -> arbiter.DialRequest
+	5,  // 14: arbiter.Control.Watch:output_type -> arbiter.BackendState
+	9,  // 15: arbiter.Control.AddBackend:output_type -> arbiter.ChangeReply
+	9,  // 16: arbiter.Control.RemoveBackend:output_type -> arbiter.ChangeReply
+	9,  // 17: arbiter.Control.Drain:output_type -> arbiter.ChangeReply
+	13, // 18: arbiter.Control.Dial:output_type -> arbiter.DialDecision
+	14, // [14:19] is the sub-list for method output_type
+	9,  // [9:14] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_arbiter_proto_init() }
+func file_arbiter_proto_init() {
+	if File_arbiter_proto != nil {
+		return
+	}
+	file_arbiter_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_arbiter_proto_rawDesc), len(file_arbiter_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_arbiter_proto_goTypes,
+		DependencyIndexes: file_arbiter_proto_depIdxs,
+		EnumInfos:         file_arbiter_proto_enumTypes,
+		MessageInfos:      file_arbiter_proto_msgTypes,
+	}.Build()
+	File_arbiter_proto = out.File
+	file_arbiter_proto_goTypes = nil
+	file_arbiter_proto_depIdxs = nil
+}
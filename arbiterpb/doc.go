@@ -0,0 +1,5 @@
+// Package arbiterpb holds the messages of arbiter.proto, the events arbiter publishes
+// to a bus with format = protobuf, and the client and server of its Control service.
+package arbiterpb
+
+//go:generate go run ./internal/protogen
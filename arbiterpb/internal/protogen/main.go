@@ -0,0 +1,75 @@
+// protogen generates the code of arbiterpb from arbiter.proto, as go generate runs it
+// in arbiterpb.  It compiles arbiter.proto with protocompile rather than protoc, which
+// isn't a Go program, so that the code can be generated again with nothing but Go, and
+// runs the plugins at the versions below over it.
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// The plugins run, as go run takes them.
+var plugins = []string{
+	"google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.11",
+	"google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1",
+}
+
+// The root of the module, relative to arbiterpb, where arbiter.proto is, and what the
+// plugins name the files they generate relative to.
+const (
+	root   = ".."
+	module = "github.com/solvip/arbiter"
+)
+
+func main() {
+	compiler := protocompile.Compiler{
+		Resolver:       &protocompile.SourceResolver{ImportPaths: []string{root}},
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	files, err := compiler.Compile(context.Background(), "arbiter.proto")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req, err := proto.Marshal(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"arbiter.proto"},
+		Parameter:      proto.String("module=" + module),
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(files[0])},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, plugin := range plugins {
+		cmd := exec.Command("go", "run", plugin)
+		cmd.Stdin = bytes.NewReader(req)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			log.Fatalf("%s: %s", plugin, err)
+		}
+
+		var resp pluginpb.CodeGeneratorResponse
+		if err := proto.Unmarshal(out, &resp); err != nil {
+			log.Fatalf("%s: %s", plugin, err)
+		}
+		if resp.Error != nil {
+			log.Fatalf("%s: %s", plugin, resp.GetError())
+		}
+		for _, f := range resp.File {
+			if err := os.WriteFile(filepath.Join(root, f.GetName()), []byte(f.GetContent()), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// Package arbitertest provides fake Postgres servers for testing routing policies with
+// pool, or with arbiter in front of them, without running real databases.
+//
+// A Server speaks enough of the Postgres wire protocol for lib/pq and most clients to
+// connect to it, and answers the queries arbiter health checks backends with from the
+// role and WAL position it's given, so that tests can make a primary fail, promote a
+// follower, or have one fall behind, and see how clients are routed:
+//
+//	primary := arbitertest.NewServer()
+//	defer primary.Close()
+//	follower := arbitertest.NewFollower(primary)
+//	defer follower.Close()
+//
+//	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+//	p.Put(primary.Backend())
+//	p.Put(follower.Backend())
+//	p.CheckAll()
+//
+//	primary.Stop()
+//	follower.Promote()
+//	p.CheckAll()
+//
+// The queries of clients, as opposed to those of health checks, are recorded, and
+// answered as Answer() says, or with no rows.
+package arbitertest
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The LSN servers start at, that of a freshly initialized cluster.
+const initialLSN = 0x1000028
+
+// Server is a fake Postgres server listening on the loopback interface.  Its methods
+// are safe for concurrent use.
+type Server struct {
+	// The system identifier, shared by a primary and its followers.
+	sysid   int64
+	started time.Time
+
+	mu       sync.Mutex
+	addr     string
+	ln       net.Listener
+	conns    map[net.Conn]struct{}
+	recovery bool
+	upstream *Server
+	lsn      uint64
+	received uint64
+	delay    time.Duration
+	answers  map[string]result
+	queries  []string
+
+	// The process ID of the last session, which the next one gets the successor of.
+	pid int32
+
+	// Signalled when a cancel request names the session of the process ID.
+	cancels map[int32]chan struct{}
+}
+
+// NewServer starts a Server that's a primary, at the initial WAL position of a new
+// cluster.  It panics if it can't listen, as httptest.NewServer does.
+func NewServer() *Server {
+	s := &Server{
+		sysid:   time.Now().UnixNano(),
+		started: time.Now(),
+		conns:   make(map[net.Conn]struct{}),
+		lsn:     initialLSN,
+		answers: make(map[string]result),
+		cancels: make(map[int32]chan struct{}),
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("arbitertest: failed to listen: %s", err))
+	}
+	s.addr = ln.Addr().String()
+	s.serve(ln)
+
+	return s
+}
+
+// NewFollower starts a Server that streams from upstream, and has replayed all of it.
+func NewFollower(upstream *Server) *Server {
+	s := NewServer()
+	s.Follow(upstream)
+
+	return s
+}
+
+// Addr returns the address the server listens on, as host:port.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addr
+}
+
+// Backend returns a backend of pool monitoring the server, as the user arbiter.
+func (s *Server) Backend() pool.Backend {
+	return pool.NewPostgresBackend(s.Addr(), pool.WithCredentials("arbiter", "", "postgres"))
+}
+
+// Follow makes the server a follower of upstream, in recovery and streaming from it,
+// having replayed all of it, as after pg_rewind and a restart.
+func (s *Server) Follow(upstream *Server) {
+	upstream.mu.Lock()
+	lsn, sysid := upstream.lsn, upstream.sysid
+	upstream.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sysid = sysid
+	s.recovery = true
+	s.upstream = upstream
+	s.lsn, s.received = lsn, lsn
+}
+
+// Promote takes the server out of recovery, as pg_promote() does, which is also how
+// pool promotes it.
+func (s *Server) Promote() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recovery = false
+	s.upstream = nil
+}
+
+// SetLSN sets the WAL position of the server: the insert position of a primary, or the
+// replay position of a follower, which has received up to there too unless it's
+// already received more.  Moving that of the primary ahead of those of its followers
+// makes them lag.
+func (s *Server) SetLSN(lsn uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lsn = lsn
+	s.received = max(s.received, lsn)
+}
+
+// LSN returns the WAL position of the server; see SetLSN().
+func (s *Server) LSN() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lsn
+}
+
+// SetDelay makes the server take d to answer each query, as a distant or overloaded one
+// would, e.g. to exceed the timeout of health checks.  Clients may cancel the query
+// meanwhile, as they would a long-running one.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delay = d
+}
+
+// Answer makes the server answer query with rows of text values of columns, rather
+// than with no rows.  Queries are compared without regard to case, whitespace or a
+// trailing semicolon.
+func (s *Server) Answer(query string, columns []string, rows ...[]string) {
+	r := result{tag: fmt.Sprintf("SELECT %d", len(rows))}
+	for _, name := range columns {
+		r.columns = append(r.columns, column{name, oidText})
+	}
+	for _, row := range rows {
+		values := make([]*string, len(row))
+		for i := range row {
+			values[i] = &row[i]
+		}
+		r.rows = append(r.rows, values)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.answers[normalize(query)] = r
+}
+
+// Queries returns the queries clients ran on the server, in order, leaving out those
+// of health checks.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.queries...)
+}
+
+// Sessions returns the number of open connections to the server, including those of
+// health checks.
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Stop takes the server down, closing its connections and refusing new ones, as a
+// crashed server would, until it's started again.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ln == nil {
+		return
+	}
+	s.ln.Close()
+	s.ln = nil
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Start starts a stopped server again, at the same address.  It panics if it can't
+// listen there.
+func (s *Server) Start() {
+	s.mu.Lock()
+	running := s.ln != nil
+	addr := s.addr
+	s.mu.Unlock()
+	if running {
+		return
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(fmt.Sprintf("arbitertest: failed to listen on %s: %s", addr, err))
+	}
+	s.serve(ln)
+}
+
+// Cancel the query of the session of pid, if it's running one.
+func (s *Server) cancel(pid int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.cancels[pid] <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the server for good.
+func (s *Server) Close() {
+	s.Stop()
+}
+
+// Accept sessions on ln until it's closed.
+func (s *Server) serve(ln net.Listener) {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			if s.ln != ln {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.conns[conn] = struct{}{}
+			s.pid++
+			sess := newSession(s, conn, s.pid)
+			s.cancels[sess.pid] = sess.canceled
+			s.mu.Unlock()
+
+			go func() {
+				defer func() {
+					s.mu.Lock()
+					delete(s.conns, conn)
+					delete(s.cancels, sess.pid)
+					s.mu.Unlock()
+					conn.Close()
+				}()
+				sess.run()
+			}()
+		}
+	}()
+}
+
+// Return what the server answers query with.  Unless it's executed, rather than
+// described, it takes no time, has no effect and isn't recorded.  A query delayed by
+// SetDelay fails once canceled is signalled, as one canceled by the client would.
+func (s *Server) answer(query string, execute bool, canceled <-chan struct{}) result {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	if delay > 0 && execute {
+		// Cancel requests sent before the query started are too late.
+		select {
+		case <-canceled:
+		default:
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-canceled:
+			return failure(sqlstateQueryCanceled, "canceling statement due to user request")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := normalize(query)
+	switch {
+	case q == "":
+		return result{empty: true}
+
+	// The queries of health checks; see pool.NewPostgresBackend().
+	case q == "select pg_is_in_recovery()":
+		return single("pg_is_in_recovery", oidBool, boolText(s.recovery))
+	case strings.Contains(q, "pg_last_wal_replay_lsn()") && strings.Contains(q, "clock_timestamp()"):
+		r := single("pg_current_wal_lsn", oidLSN, pool.FormatLSN(s.lsn))
+		r.columns = append(r.columns, column{"clock_timestamp", oidTimestamptz})
+		r.rows[0] = append(r.rows[0], text(timestamp(time.Now())))
+		return r
+	case q == "select pg_last_wal_receive_lsn()":
+		if !s.recovery {
+			return single("pg_last_wal_receive_lsn", oidLSN, "")
+		}
+		return single("pg_last_wal_receive_lsn", oidLSN, pool.FormatLSN(s.received))
+	case strings.Contains(q, "from pg_stat_wal_receiver"):
+		r := result{columns: []column{{"sender_host", oidText}, {"sender_port", oidInt4}}, tag: "SELECT 0"}
+		if s.upstream != nil {
+			host, port, _ := net.SplitHostPort(s.upstream.addr)
+			r.rows = [][]*string{{text(host), text(port)}}
+			r.tag = "SELECT 1"
+		}
+		return r
+	case q == "show synchronous_standby_names":
+		r := single("synchronous_standby_names", oidText, "")
+		r.rows[0][0] = text("")
+		return r
+	case strings.Contains(q, "from pg_stat_replication"):
+		return result{tag: "SELECT 0"}
+	case strings.Contains(q, "from pg_control_system()"):
+		_, port, _ := net.SplitHostPort(s.addr)
+		r := single("system_identifier", oidInt8, fmt.Sprint(s.sysid))
+		r.columns = append(r.columns, column{"current_setting", oidText}, column{"pg_postmaster_start_time", oidTimestamptz})
+		r.rows[0] = append(r.rows[0], text(port), text(timestamp(s.started)))
+		return r
+	case strings.HasPrefix(q, "select pg_promote("):
+		if !s.recovery {
+			return failure(sqlstateNotInRecovery, "recovery is not in progress")
+		}
+		if execute {
+			s.recovery, s.upstream = false, nil
+		}
+		return single("pg_promote", oidBool, "t")
+	}
+
+	if execute {
+		s.queries = append(s.queries, strings.TrimSpace(query))
+	}
+	if r, ok := s.answers[q]; ok {
+		return r
+	}
+
+	switch q {
+	case "begin", "start transaction":
+		return result{tag: "BEGIN", tx: txIn}
+	case "commit", "end":
+		return result{tag: "COMMIT", tx: txIdle}
+	case "rollback", "abort":
+		return result{tag: "ROLLBACK", tx: txIdle}
+	}
+
+	return result{tag: "SELECT 0"}
+}
+
+// Return query in lower case, with its whitespace collapsed and without a trailing
+// semicolon.
+func normalize(query string) string {
+	q := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	return strings.TrimSpace(strings.TrimSuffix(q, ";"))
+}
+
+func boolText(b bool) string {
+	if b {
+		return "t"
+	}
+	return "f"
+}
+
+// Format t as Postgres does a timestamptz.
+func timestamp(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05.999999-07")
+}
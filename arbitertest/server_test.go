@@ -0,0 +1,137 @@
+package arbitertest
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// classDialer connects lib/pq to a backend of a pool that satisfies a class.
+type classDialer struct {
+	p     *pool.Pool
+	class string
+}
+
+func (d classDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, time.Second)
+}
+
+func (d classDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.p.DialClass(ctx, d.class)
+}
+
+func open(t *testing.T, p *pool.Pool, class string) *sql.DB {
+	connector, err := pq.NewConnector("postgres://app@arbiter/app?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector.Dialer(classDialer{p, class})
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func states(p *pool.Pool) map[string]pool.State {
+	states := make(map[string]pool.State)
+	for _, b := range p.Backends() {
+		states[b.Name] = b.State
+	}
+	return states
+}
+
+func TestFailover(t *testing.T) {
+	primary := NewServer()
+	defer primary.Close()
+	follower := NewFollower(primary)
+	defer follower.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+	p.Put(primary.Backend())
+	p.Put(follower.Backend())
+	p.DefineClass("follower", pool.Class{FollowersOnly: true})
+	p.CheckAll()
+
+	want := map[string]pool.State{primary.Addr(): pool.READ_WRITE, follower.Addr(): pool.READ_ONLY}
+	if got := states(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v; instead got %v", want, got)
+	}
+
+	// Writes go to the primary, and reads to the follower, over both query protocols.
+	primary.Answer("insert into t values ($1)", nil)
+	if _, err := open(t, p, "strong").Exec("insert into t values ($1)", 1); err != nil {
+		t.Fatal(err)
+	}
+	follower.Answer("select name from t", []string{"name"}, []string{"a"}, []string{"b"})
+	rows, err := open(t, p, "follower").Query("select name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("Expected the follower's rows; instead got %v", names)
+	}
+	if got := primary.Queries(); !reflect.DeepEqual(got, []string{"insert into t values ($1)"}) {
+		t.Fatalf("Expected the primary to get the write alone; instead got %v", got)
+	}
+	if got := follower.Queries(); !reflect.DeepEqual(got, []string{"select name from t"}) {
+		t.Fatalf("Expected the follower to get the read alone; instead got %v", got)
+	}
+
+	// The follower falls 16MB behind, or further as the pool extrapolates the position
+	// of the primary.  Check twice, for the follower to be compared against the primary's
+	// new position whichever is checked first.
+	primary.SetLSN(primary.LSN() + 16<<20)
+	p.CheckAll()
+	p.CheckAll()
+	for _, b := range p.Backends() {
+		if b.Name == follower.Addr() && b.LagBytes < 16<<20 {
+			t.Fatalf("Expected the follower to lag by at least 16MB; instead got %d bytes", b.LagBytes)
+		}
+	}
+
+	// Once the primary is down, the follower is promoted through the pool.
+	primary.Stop()
+	p.CheckAll()
+	if err := p.Promote(ctx, follower.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	p.CheckAll()
+
+	want = map[string]pool.State{primary.Addr(): pool.UNAVAILABLE, follower.Addr(): pool.READ_WRITE}
+	if got := states(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v; instead got %v", want, got)
+	}
+	if b, err := p.GetForWrite(); err != nil || b.Addr() != follower.Addr() {
+		t.Fatalf("Expected writes to go to the promoted follower; instead got %v", err)
+	}
+
+	// The old primary comes back as a follower of the new one.
+	primary.Follow(follower)
+	primary.Start()
+	p.CheckAll()
+	want = map[string]pool.State{primary.Addr(): pool.READ_ONLY, follower.Addr(): pool.READ_WRITE}
+	if got := states(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v; instead got %v", want, got)
+	}
+}
@@ -0,0 +1,418 @@
+package arbitertest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// The codes of the untyped requests a client may start with.
+const (
+	protocolVersion3  = 196608
+	sslRequestCode    = 80877103
+	gssencRequestCode = 80877104
+	cancelRequestCode = 80877102
+)
+
+// The OIDs of the types of the columns the server answers with.
+const (
+	oidBool        = 16
+	oidInt8        = 20
+	oidInt4        = 23
+	oidText        = 25
+	oidTimestamptz = 1184
+	oidLSN         = 3220
+)
+
+// SQLSTATE codes the server fails queries with.
+const (
+	sqlstateProtocolViolation = "08P01"
+	sqlstateNotInRecovery     = "55000"
+	sqlstateQueryCanceled     = "57014"
+)
+
+// The transaction status of a session, as ReadyForQuery reports it.
+const (
+	txIdle = 'I'
+	txIn   = 'T'
+)
+
+type column struct {
+	name string
+	oid  uint32
+}
+
+// result is what a query is answered with.
+type result struct {
+	// Set for an empty query, which is answered with EmptyQueryResponse.
+	empty bool
+
+	columns []column
+	rows    [][]*string
+	tag     string
+
+	// The transaction status the query leaves the session in, if it changes it.
+	tx byte
+
+	// The error the query fails with, if any.
+	code    string
+	message string
+}
+
+// Return a result of one row, of one column of the type oid, which is NULL if value
+// is empty.
+func single(name string, oid uint32, value string) result {
+	r := result{columns: []column{{name, oid}}, rows: [][]*string{{text(value)}}, tag: "SELECT 1"}
+	if value == "" {
+		r.rows[0][0] = nil
+	}
+	return r
+}
+
+// Return a result failing with the SQLSTATE code.
+func failure(code, message string) result {
+	return result{code: code, message: message}
+}
+
+func text(s string) *string {
+	return &s
+}
+
+// session is a connection to a Server.
+type session struct {
+	s    *Server
+	conn net.Conn
+	r    *bufio.Reader
+	pid  int32
+
+	// The transaction status of the session.
+	tx byte
+
+	// The prepared statements and portals of the extended query protocol, by name.
+	statements map[string]string
+	portals    map[string]portal
+
+	// Set once a message of the extended query protocol failed, until the next Sync.
+	failed bool
+
+	// Signalled by a cancel request naming the session; see Server.cancel().
+	canceled chan struct{}
+
+	out []byte
+}
+
+// portal is a statement bound to the values of its parameters, which the server's
+// answers don't depend on.
+type portal struct {
+	query string
+}
+
+func newSession(s *Server, conn net.Conn, pid int32) *session {
+	return &session{
+		s:          s,
+		conn:       conn,
+		r:          bufio.NewReader(conn),
+		pid:        pid,
+		tx:         txIdle,
+		statements: make(map[string]string),
+		portals:    make(map[string]portal),
+		canceled:   make(chan struct{}, 1),
+	}
+}
+
+// Serve the session until the client terminates it or the connection breaks.
+func (c *session) run() {
+	if !c.startup() {
+		return
+	}
+
+	for {
+		typ, body, err := c.read()
+		if err != nil || typ == 'X' {
+			return
+		}
+		if err := c.handle(typ, body); err != nil {
+			return
+		}
+	}
+}
+
+// Read the startup packet, declining encryption, and trust the client.  It returns
+// false if the session is over, as after a cancel request, which cancels the query of
+// the session it names if its secret key is right.
+func (c *session) startup() bool {
+	for {
+		_, body, err := c.readUntyped()
+		if err != nil || len(body) < 4 {
+			return false
+		}
+
+		switch binary.BigEndian.Uint32(body) {
+		case sslRequestCode, gssencRequestCode:
+			if _, err := c.conn.Write([]byte("N")); err != nil {
+				return false
+			}
+			continue
+		case protocolVersion3:
+		case cancelRequestCode:
+			if len(body) >= 12 {
+				pid, secret := binary.BigEndian.Uint32(body[4:]), binary.BigEndian.Uint32(body[8:])
+				if secret == pid*7919 {
+					c.s.cancel(int32(pid))
+				}
+			}
+			return false
+		default:
+			// Protocols we don't speak.
+			return false
+		}
+
+		c.msg('R', u32(0))
+		for _, p := range [][2]string{
+			{"server_version", "16.0 (arbitertest)"},
+			{"server_encoding", "UTF8"},
+			{"client_encoding", "UTF8"},
+			{"DateStyle", "ISO, MDY"},
+			{"integer_datetimes", "on"},
+			{"standard_conforming_strings", "on"},
+		} {
+			c.msg('S', cstr(p[0]), cstr(p[1]))
+		}
+		c.msg('K', u32(uint32(c.pid)), u32(uint32(c.pid)*7919))
+		c.msg('Z', []byte{c.tx})
+		return c.flush() == nil
+	}
+}
+
+// Handle a message of the client.
+func (c *session) handle(typ byte, body []byte) error {
+	if c.failed && typ != 'S' {
+		return nil
+	}
+
+	switch typ {
+	case 'Q':
+		query, _, _ := cutString(body)
+		c.respond(c.s.answer(query, true, c.canceled), true)
+		c.msg('Z', []byte{c.tx})
+		return c.flush()
+
+	case 'P':
+		name, rest, ok := cutString(body)
+		query, _, ok2 := cutString(rest)
+		if !ok || !ok2 {
+			return c.fail(sqlstateProtocolViolation, "invalid Parse message")
+		}
+		c.statements[name] = query
+		c.msg('1')
+
+	case 'B':
+		p, name, err := c.bind(body)
+		if err != nil {
+			return c.fail(sqlstateProtocolViolation, err.Error())
+		}
+		c.portals[name] = p
+		c.msg('2')
+
+	case 'D':
+		if len(body) < 1 {
+			return c.fail(sqlstateProtocolViolation, "invalid Describe message")
+		}
+		name, _, _ := cutString(body[1:])
+		if body[0] == 'S' {
+			query := c.statements[name]
+			n := strings.Count(query, "$")
+			params := u16(uint16(n))
+			for range n {
+				params = append(params, u32(oidText)...)
+			}
+			c.msg('t', params)
+			c.describe(c.s.answer(query, false, c.canceled))
+		} else {
+			c.describe(c.answerPortal(name, false))
+		}
+
+	case 'E':
+		name, _, _ := cutString(body)
+		c.respond(c.answerPortal(name, true), false)
+
+	case 'C':
+		c.msg('3')
+
+	case 'S':
+		c.failed = false
+		c.msg('Z', []byte{c.tx})
+		return c.flush()
+
+	case 'H':
+		return c.flush()
+
+	default:
+		return c.fail(sqlstateProtocolViolation, fmt.Sprintf("unexpected message type 0x%02x", typ))
+	}
+
+	return nil
+}
+
+// Return what the query of the portal name is answered with, if it's executed rather
+// than described.
+func (c *session) answerPortal(name string, execute bool) result {
+	p, ok := c.portals[name]
+	if !ok {
+		return failure(sqlstateProtocolViolation, fmt.Sprintf("portal \"%s\" does not exist", name))
+	}
+	return c.s.answer(p.query, execute, c.canceled)
+}
+
+// Parse a Bind message, returning the portal and its name.
+func (c *session) bind(body []byte) (p portal, name string, err error) {
+	name, rest, ok := cutString(body)
+	stmt, _, ok2 := cutString(rest)
+	if !ok || !ok2 {
+		return p, "", errors.New("invalid Bind message")
+	}
+	query, ok := c.statements[stmt]
+	if !ok {
+		return p, "", fmt.Errorf("prepared statement \"%s\" does not exist", stmt)
+	}
+
+	return portal{query}, name, nil
+}
+
+// Send the RowDescription of r, or NoData if it has no columns.
+func (c *session) describe(r result) {
+	if len(r.columns) == 0 || r.code != "" {
+		c.msg('n')
+		return
+	}
+	c.rowDescription(r)
+}
+
+// Send r, with its RowDescription unless it's been described already.
+func (c *session) respond(r result, simple bool) {
+	switch {
+	case r.code != "":
+		c.error(r.code, r.message)
+		if !simple {
+			c.failed = true
+		}
+		return
+	case r.empty:
+		c.msg('I')
+		return
+	}
+
+	if simple && len(r.columns) > 0 {
+		c.rowDescription(r)
+	}
+	for _, row := range r.rows {
+		values := u16(uint16(len(row)))
+		for _, v := range row {
+			if v == nil {
+				values = append(values, 0xff, 0xff, 0xff, 0xff)
+				continue
+			}
+			values = append(append(values, u32(uint32(len(*v)))...), *v...)
+		}
+		c.msg('D', values)
+	}
+	c.msg('C', cstr(r.tag))
+	if r.tx != 0 {
+		c.tx = r.tx
+	}
+}
+
+func (c *session) rowDescription(r result) {
+	fields := u16(uint16(len(r.columns)))
+	for _, col := range r.columns {
+		fields = append(fields, cstr(col.name)...)
+		fields = append(fields, u32(0)...)
+		fields = append(fields, u16(0)...)
+		fields = append(fields, u32(col.oid)...)
+		fields = append(fields, 0xff, 0xff)
+		fields = append(fields, 0xff, 0xff, 0xff, 0xff)
+		fields = append(fields, u16(0)...)
+	}
+	c.msg('T', fields)
+}
+
+func (c *session) error(code, message string) {
+	c.msg('E', []byte("SERROR\x00VERROR\x00C"), cstr(code), []byte("M"), cstr(message), []byte{0})
+}
+
+// Fail the message being handled, skipping what the client sends until a Sync.
+func (c *session) fail(code, message string) error {
+	c.error(code, message)
+	c.failed = true
+	return nil
+}
+
+// Queue a message of type typ for the client.
+func (c *session) msg(typ byte, fields ...[]byte) {
+	n := 4
+	for _, f := range fields {
+		n += len(f)
+	}
+	c.out = append(append(c.out, typ), u32(uint32(n))...)
+	for _, f := range fields {
+		c.out = append(c.out, f...)
+	}
+}
+
+// Send the queued messages.
+func (c *session) flush() error {
+	_, err := c.conn.Write(c.out)
+	c.out = c.out[:0]
+	return err
+}
+
+// Read a typed message.
+func (c *session) read() (byte, []byte, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	_, body, err := c.readUntyped()
+	return typ, body, err
+}
+
+// Read the length and the body of a message.
+func (c *session) readUntyped() (int, []byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(c.r, n[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(n[:]))
+	if size < 4 || size > 1<<24 {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+
+	body := make([]byte, size-4)
+	_, err := io.ReadFull(c.r, body)
+	return size, body, err
+}
+
+// Return the NUL-terminated string b starts with, and the rest of b.
+func cutString(b []byte) (string, []byte, bool) {
+	i := strings.IndexByte(string(b), 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(b[:i]), b[i+1:], true
+}
+
+func cstr(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func u16(n uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, n)
+}
+
+func u32(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
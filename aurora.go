@@ -0,0 +1,124 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"strings"
+	"time"
+)
+
+// auroraWatcher keeps the backends in sync with the instances of an Amazon Aurora
+// cluster, as aurora_replica_status() lists them.  Each instance is a backend named
+// after it, at its own endpoint, rather than behind the cluster endpoint, whose DNS
+// flips to the new writer on a failover only once its TTL expires: health checks see
+// the new writer as soon as it takes writes.  The topology is read through the cluster
+// endpoint, then the reader endpoint, and then the instances last discovered, so that
+// it's followed while either endpoint resolves to an instance that's gone.
+type auroraWatcher struct {
+	*discovery
+	endpoints []string
+	interval  time.Duration
+
+	// The domain and port of the endpoints of the instances.
+	domain, port string
+
+	// The writer last discovered.
+	writer string
+
+	replicas func(addrs []string) ([]pool.AuroraReplica, error)
+}
+
+// Return the endpoints of the instances of the Aurora cluster whose cluster or reader
+// endpoint is endpoint, e.g. db.cluster-c9akciq32.eu-west-1.rds.amazonaws.com:5432,
+// as the cluster endpoint, the reader endpoint, and the domain and port of the
+// endpoints of its instances.
+func auroraEndpoints(endpoint string) (cluster, reader, domain, port string, err error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", "", "", "", err
+	}
+
+	labels := strings.SplitN(host, ".", 3)
+	if len(labels) < 3 {
+		return "", "", "", "", fmt.Errorf("expected the cluster endpoint of an Aurora cluster; got %s", host)
+	}
+	id, rest := labels[1], labels[2]
+	switch {
+	case strings.HasPrefix(id, "cluster-ro-"):
+		id = strings.TrimPrefix(id, "cluster-ro-")
+	case strings.HasPrefix(id, "cluster-"):
+		id = strings.TrimPrefix(id, "cluster-")
+	default:
+		return "", "", "", "", fmt.Errorf("expected the cluster endpoint of an Aurora cluster; got %s", host)
+	}
+
+	cluster = net.JoinHostPort(labels[0]+".cluster-"+id+"."+rest, port)
+	reader = net.JoinHostPort(labels[0]+".cluster-ro-"+id+"."+rest, port)
+	return cluster, reader, id + "." + rest, port, nil
+}
+
+func newAuroraWatcher(s *server, c *Config) *auroraWatcher {
+	cluster, reader, domain, port, _ := auroraEndpoints(c.Aurora.Endpoint)
+	return &auroraWatcher{
+		discovery: newDiscovery(s, "Aurora"),
+		endpoints: []string{cluster, reader},
+		interval:  c.Aurora.interval,
+		domain:    domain,
+		port:      port,
+		replicas: func(addrs []string) ([]pool.AuroraReplica, error) {
+			b := pool.NewPostgresBackendWithSettings(addrs, c.Health.settings)
+			defer b.Close()
+
+			if _, err := b.Ping(); err != nil {
+				return nil, err
+			}
+			return b.AuroraReplicas()
+		},
+	}
+}
+
+// Discover the instances every interval until the process exits.
+func (aw *auroraWatcher) run() {
+	for {
+		if err := aw.discover(); err != nil {
+			aw.s.logger.Printf("Aurora: could not sync: %s", err)
+		}
+		time.Sleep(aw.interval)
+	}
+}
+
+// Read the topology of the cluster, and reconcile the declared state with it.  While
+// it can't be read, the instances last discovered are kept.
+func (aw *auroraWatcher) discover() error {
+	addrs := aw.endpoints
+	aw.mu.Lock()
+	for _, b := range aw.discovered {
+		addrs = append(addrs[:len(addrs):len(addrs)], b.Address[0])
+	}
+	aw.mu.Unlock()
+
+	replicas, err := aw.replicas(addrs)
+	if err != nil {
+		aw.s.logger.Printf("Aurora: could not read the topology; keeping the instances last discovered: %s", err)
+		return nil
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("no instances listed")
+	}
+
+	discovered := make(map[string]*desiredBackend)
+	for _, r := range replicas {
+		discovered[r.ServerID] = &desiredBackend{
+			Address: []string{net.JoinHostPort(r.ServerID+"."+aw.domain, aw.port)},
+		}
+		if r.Writer && r.ServerID != aw.writer {
+			if aw.writer != "" {
+				aw.s.logger.Printf("Aurora: the writer is now %s, was %s", r.ServerID, aw.writer)
+			}
+			aw.writer = r.ServerID
+		}
+	}
+
+	return aw.update(discovered)
+}
@@ -0,0 +1,77 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuroraWatcher(t *testing.T) {
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared:         &declaration{state: desiredState{Backends: map[string]*desiredBackend{}}},
+	}
+
+	c := &Config{}
+	c.Aurora.Endpoint = "db.cluster-ro-c9akciq32.eu-west-1.rds.amazonaws.com:5432"
+	c.Aurora.interval = time.Second
+	aw := newAuroraWatcher(s, c)
+
+	replicas := []pool.AuroraReplica{{ServerID: "db-1", Writer: true}, {ServerID: "db-2"}}
+	var topologyErr error
+	var tried []string
+	aw.replicas = func(addrs []string) ([]pool.AuroraReplica, error) {
+		tried = addrs
+		return replicas, topologyErr
+	}
+
+	backends := func() string {
+		var names []string
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			names = append(names, b.Name+"="+b.Addr)
+			return true
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	discover := func(want string) {
+		t.Helper()
+		if err := aw.discover(); err != nil {
+			t.Fatal(err)
+		}
+		if got := backends(); got != want {
+			t.Fatalf("Expected backends %s; instead got %s", want, got)
+		}
+	}
+
+	discover("db-1=db-1.c9akciq32.eu-west-1.rds.amazonaws.com:5432,db-2=db-2.c9akciq32.eu-west-1.rds.amazonaws.com:5432")
+	if tried[0] != "db.cluster-c9akciq32.eu-west-1.rds.amazonaws.com:5432" ||
+		tried[1] != "db.cluster-ro-c9akciq32.eu-west-1.rds.amazonaws.com:5432" {
+		t.Errorf("Expected the topology read through the cluster endpoint, then the reader endpoint; instead got %v", tried)
+	}
+
+	// On a failover, the old writer is replaced, and the instances are tried too, in case
+	// the endpoints resolve to it still.
+	replicas = []pool.AuroraReplica{{ServerID: "db-2", Writer: true}, {ServerID: "db-3"}}
+	discover("db-2=db-2.c9akciq32.eu-west-1.rds.amazonaws.com:5432,db-3=db-3.c9akciq32.eu-west-1.rds.amazonaws.com:5432")
+	if len(tried) != 4 || aw.writer != "db-2" {
+		t.Errorf("Expected the instances tried after the endpoints, and db-2 the writer; instead got %v, %s", tried, aw.writer)
+	}
+
+	// While the topology can't be read, the instances are kept.
+	topologyErr = errors.New("connection refused")
+	discover("db-2=db-2.c9akciq32.eu-west-1.rds.amazonaws.com:5432,db-3=db-3.c9akciq32.eu-west-1.rds.amazonaws.com:5432")
+
+	for _, endpoint := range []string{"db.c9akciq32.eu-west-1.rds.amazonaws.com:5432", "localhost:5432", "db.cluster-x.example.com"} {
+		if _, _, _, _, err := auroraEndpoints(endpoint); err == nil {
+			t.Errorf("Expected %s to be rejected as a cluster endpoint", endpoint)
+		}
+	}
+}
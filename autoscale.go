@@ -0,0 +1,241 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readLoad counts the queries proxied to each backend.  Sessions count against the
+// counter of their backend without taking a lock; rates are taken by sample().
+type readLoad struct {
+	sync.Mutex
+	counts map[string]*AtomicInt
+
+	// The counts at the last sample, and when it was taken.
+	last map[string]int64
+	at   time.Time
+}
+
+func newReadLoad() *readLoad {
+	return &readLoad{counts: make(map[string]*AtomicInt), last: make(map[string]int64)}
+}
+
+// Return the counter of the queries proxied to the backend name.
+func (l *readLoad) counter(name string) *AtomicInt {
+	l.Lock()
+	defer l.Unlock()
+
+	c := l.counts[name]
+	if c == nil {
+		c = new(AtomicInt)
+		l.counts[name] = c
+	}
+
+	return c
+}
+
+// Forget the counter of the backend name, which was removed.
+func (l *readLoad) forget(name string) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.counts, name)
+	delete(l.last, name)
+}
+
+// Return the queries per second proxied to each backend since the last sample, which
+// is taken at now.  The first sample has no rates.
+func (l *readLoad) sample(now time.Time) map[string]float64 {
+	l.Lock()
+	defer l.Unlock()
+
+	rates := make(map[string]float64)
+	elapsed := now.Sub(l.at).Seconds()
+	for name, c := range l.counts {
+		n := c.Get()
+		if !l.at.IsZero() && elapsed > 0 {
+			rates[name] = float64(n-l.last[name]) / elapsed
+		}
+		l.last[name] = n
+	}
+	l.at = now
+
+	return rates
+}
+
+// autoscaler turns the read load of the followers into signals for autoscalers: how
+// many queries each serves, how much headroom they have left together, and whether
+// they're saturated.  It also registers the replicas they provision, so that they're
+// routed to, slow starting, the moment they come online.
+type autoscaler struct {
+	s *server
+
+	// The queries per second a follower is taken to serve, and the share of that past
+	// which the followers are saturated; signals are taken every interval.
+	replicaQPS        float64
+	saturationPercent float64
+	interval          time.Duration
+
+	// Where the signals are POSTed, if anywhere.
+	webhook string
+	token   string
+	client  *http.Client
+
+	// How long provisioned replicas slow start for.
+	slowStart time.Duration
+
+	// The last signals taken.
+	mu   sync.Mutex
+	last scaleSignals
+}
+
+// scaleSignals is the JSON document served on /autoscale and POSTed to the webhook.
+// The capacity and headroom are zero unless replica-qps is set.
+type scaleSignals struct {
+	Time        time.Time      `json:"time"`
+	Labels      metrics.Labels `json:"labels,omitempty"`
+	Replicas    []replicaLoad  `json:"replicas"`
+	ReadQPS     float64        `json:"read_qps"`
+	CapacityQPS float64        `json:"capacity_qps"`
+	HeadroomQPS float64        `json:"headroom_qps"`
+	Saturated   bool           `json:"saturated"`
+}
+
+// replicaLoad is the load of a backend other than the primary.  Warmth is the share of
+// reads it takes while it's slow starting; see pool.SlowStart().
+type replicaLoad struct {
+	Name   string     `json:"name"`
+	State  pool.State `json:"state"`
+	QPS    float64    `json:"qps"`
+	Warmth float64    `json:"warmth"`
+}
+
+func newAutoscaler(s *server, c *Config) *autoscaler {
+	return &autoscaler{
+		s:                 s,
+		replicaQPS:        c.Autoscale.ReplicaQPS,
+		saturationPercent: c.Autoscale.SaturationPercent,
+		interval:          c.Autoscale.interval,
+		webhook:           c.Autoscale.Webhook,
+		token:             c.Autoscale.Token,
+		client:            &http.Client{Timeout: 10 * time.Second},
+		slowStart:         c.Autoscale.slowStart,
+	}
+}
+
+// Take signals every interval until the process exits, POSTing them to the webhook.
+func (a *autoscaler) run() {
+	for now := range time.Tick(a.interval) {
+		sig := a.sample(now)
+		if a.webhook == "" {
+			continue
+		}
+
+		if err := postJSON(a.client, a.webhook, a.token, sig); err != nil {
+			a.s.logger.Printf("Could not send autoscaling signals to %s: %s", a.webhook, err)
+		}
+	}
+}
+
+// Take the signals at now, reporting them as metrics.
+func (a *autoscaler) sample(now time.Time) scaleSignals {
+	rates := a.s.load.sample(now)
+	sig := scaleSignals{Time: now, Labels: a.s.labels, Replicas: []replicaLoad{}}
+
+	var capacity float64
+	for _, b := range a.s.pool.Backends() {
+		qps := rates[b.Name]
+		a.s.sink.SetGauge("arbiter_backend_queries_per_second", metrics.Labels{"backend": b.Name}, qps)
+		if b.State == pool.READ_WRITE {
+			continue
+		}
+
+		sig.Replicas = append(sig.Replicas, replicaLoad{Name: b.Name, State: b.State, QPS: qps, Warmth: b.Warmth})
+		if b.State != pool.READ_ONLY {
+			continue
+		}
+
+		sig.ReadQPS += qps
+		if !b.Draining && !b.Cordoned && !b.Diverged && b.DuplicateOf == "" {
+			capacity += a.replicaQPS * b.Warmth
+		}
+	}
+
+	a.s.sink.SetGauge("arbiter_read_queries_per_second", nil, sig.ReadQPS)
+	if a.replicaQPS > 0 {
+		sig.CapacityQPS = capacity
+		sig.HeadroomQPS = capacity*a.saturationPercent/100 - sig.ReadQPS
+		sig.Saturated = sig.HeadroomQPS < 0
+		a.s.sink.SetGauge("arbiter_read_capacity_qps", nil, sig.CapacityQPS)
+		a.s.sink.SetGauge("arbiter_read_headroom_qps", nil, sig.HeadroomQPS)
+		a.s.sink.SetGauge("arbiter_read_saturated", nil, boolToFloat(sig.Saturated))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if sig.Saturated != a.last.Saturated {
+		if sig.Saturated {
+			a.s.logger.Printf("Followers saturated: serving %.0f of %.0f queries per second", sig.ReadQPS, sig.CapacityQPS)
+		} else {
+			a.s.logger.Printf("Followers no longer saturated: serving %.0f of %.0f queries per second", sig.ReadQPS, sig.CapacityQPS)
+		}
+	}
+	a.last = sig
+
+	return sig
+}
+
+// Serve /autoscale, the last signals taken.
+func (a *autoscaler) handleSignals(w http.ResponseWriter, req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, a.last)
+}
+
+// Register the replica at the address parameter under the name parameter as it's being
+// provisioned; POST only.  It's health checked right away, so it's routed to the moment
+// it comes online, slow starting, and it's declared like a backend PUT to /config.
+func (a *autoscaler) handleProvision(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, addr := req.FormValue("name"), req.FormValue("address")
+	if name == "" || addr == "" {
+		http.Error(w, "name and address are required", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		http.Error(w, fmt.Sprintf("invalid address '%s': %s", addr, err), http.StatusBadRequest)
+		return
+	}
+
+	d := a.s.declared
+	d.Lock()
+	defer d.Unlock()
+
+	if d.state.Backends[name] != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", name, pool.ErrDuplicateBackend), http.StatusConflict)
+		return
+	}
+
+	if err := a.s.pool.PutNamed(name, pool.NewBackend([]string{addr}, d.settings)); err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", name, err), http.StatusConflict)
+		return
+	}
+	a.s.pool.SlowStart(name, a.slowStart)
+	a.s.logger.Printf("Provisioning %s at %s", name, addr)
+
+	priority := 1
+	b := &desiredBackend{Address: []string{addr}, Priority: &priority, settings: d.settings}
+	d.state.Backends[name] = b
+	writeJSON(w, http.StatusCreated, b)
+}
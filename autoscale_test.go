@@ -0,0 +1,75 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAutoscale(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	s := &server{
+		pool:     pool.New(context.Background(), pool.WithManualChecks(clock)),
+		load:     newReadLoad(),
+		sink:     metrics.NewMemory(),
+		logger:   log.Default(),
+		declared: &declaration{state: desiredState{Backends: make(map[string]*desiredBackend)}},
+	}
+	s.pool.PutNamed("pg1", &replayBackend{name: "pg1", sample: &sample{State: "primary"}, now: clock})
+	s.pool.PutNamed("pg2", &replayBackend{name: "pg2", sample: &sample{State: "follower"}, now: clock})
+	s.pool.Check("pg1")
+	s.pool.Check("pg2")
+
+	c := &Config{}
+	c.Autoscale.ReplicaQPS, c.Autoscale.SaturationPercent = 100, 80
+	a := newAutoscaler(s, c)
+	a.sample(now)
+
+	// Queries are counted by sessions.
+	sess := &session{queries: s.load.counter("pg2")}
+	sess.frontendMsg('Q', nil)
+	s.load.counter("pg2").Add(899)
+	s.load.counter("pg1").Add(50)
+
+	sig := a.sample(now.Add(10 * time.Second))
+	if sig.ReadQPS != 90 || sig.CapacityQPS != 100 || sig.HeadroomQPS != -10 || !sig.Saturated {
+		t.Errorf("Expected pg2 to serve 90 of its 100 queries per second, saturated; instead got %+v", sig)
+	}
+	if len(sig.Replicas) != 1 || sig.Replicas[0].Name != "pg2" || sig.Replicas[0].QPS != 90 {
+		t.Errorf("Expected pg2 to be the only replica; instead got %+v", sig.Replicas)
+	}
+
+	provision := func(query string) int {
+		w := httptest.NewRecorder()
+		a.handleProvision(w, httptest.NewRequest("POST", "/provision?"+query, nil))
+		return w.Code
+	}
+
+	if code := provision("name=pg3&address=127.0.0.1:1"); code != http.StatusCreated {
+		t.Fatalf("Expected pg3 to be provisioned; instead got %d", code)
+	}
+	for query, want := range map[string]int{
+		"name=pg3&address=127.0.0.1:2": http.StatusConflict,
+		"name=pg4&address=127.0.0.1:1": http.StatusConflict,
+		"name=pg4":                     http.StatusBadRequest,
+		"name=pg4&address=pg4":         http.StatusBadRequest,
+	} {
+		if code := provision(query); code != want {
+			t.Errorf("Expected %s to be answered with %d; instead got %d", query, want, code)
+		}
+	}
+
+	if s.declared.state.Backends["pg3"] == nil {
+		t.Errorf("Expected pg3 to be declared")
+	}
+	if sig := a.sample(now.Add(20 * time.Second)); len(sig.Replicas) != 2 || sig.Replicas[1].State != pool.UNAVAILABLE {
+		t.Errorf("Expected pg3 to be a replica yet to come online; instead got %+v", sig.Replicas)
+	}
+}
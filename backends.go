@@ -0,0 +1,176 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// addedBackend is a backend POSTed to /backends: a desiredBackend, and its name.
+type addedBackend struct {
+	Name string `json:"name"`
+	desiredBackend
+}
+
+// Serve /backends.  GET lists the backends as on /stats, and POST adds the backend in
+// the body to the declared state, answering as PUT /config; see handleConfig().  A
+// backend without a name is named after its first address.
+func (s *server) handleBackends(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.stats().Backends)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b addedBackend
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		http.Error(w, fmt.Sprintf("invalid backend: %s", err), http.StatusBadRequest)
+		return
+	}
+	if b.Name == "" && len(b.Address) > 0 {
+		b.Name = b.Address[0]
+	}
+
+	ds := s.declared.copyState()
+	if ds.Backends[b.Name] != nil {
+		http.Error(w, fmt.Sprintf("backend %s already exists", b.Name), http.StatusConflict)
+		return
+	}
+	ds.Backends[b.Name] = &b.desiredBackend
+
+	if err := ds.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.reconcile(w, req, ds)
+}
+
+// Serve /backends/{name or addr}: DELETE removes the backend from the declared state,
+// answering as PUT /config, and POSTing to its drain, cordon, uncordon, resume and check
+// subresources does as /drain, /cordon, /uncordon, /resume and /check do.  Its health
+// subresource is served by handleBackendHealth().
+func (s *server) handleBackend(w http.ResponseWriter, req *http.Request) {
+	key, sub, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/backends/"), "/")
+
+	switch {
+	case sub == "health":
+		s.handleBackendHealth(w, req)
+
+	case sub == "drain" || sub == "resume" || sub == "cordon" || sub == "uncordon":
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.drain(w, req, []string{key}, sub)
+
+	case sub == "check":
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.check(w, []string{key})
+
+	case sub == "" && req.Method == http.MethodDelete:
+		ds := s.declared.copyState()
+		name := ds.find(key)
+		if name == "" {
+			http.Error(w, fmt.Sprintf("%s: not a declared backend", key), http.StatusNotFound)
+			return
+		}
+		delete(ds.Backends, name)
+		s.reconcile(w, req, ds)
+
+	case sub == "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// Serve /check; POST only.  The backends given in the backend parameter, which may be
+// repeated, or every backend without one, are health checked right away rather than
+// at their next scheduled check, as when a failover is known to have just happened,
+// and answered with as on GET /backends once they are.
+func (s *server) handleCheck(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req.ParseForm()
+	s.check(w, req.Form["backend"])
+}
+
+// Check the backends named or addressed names, or every backend if there are none,
+// answering as handleCheck().
+func (s *server) check(w http.ResponseWriter, names []string) {
+	if len(names) == 0 {
+		s.pool.CheckAll()
+		writeJSON(w, http.StatusOK, s.stats().Backends)
+		return
+	}
+
+	for _, name := range names {
+		if err := s.pool.Check(name); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", name, err), http.StatusNotFound)
+			return
+		}
+	}
+
+	var checked []backendStats
+	for _, b := range s.stats().Backends {
+		for _, name := range names {
+			if b.Name == name || b.Addr == name {
+				checked = append(checked, b)
+				break
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, checked)
+}
+
+// Return a copy of the declared state, whose backends can be added and removed without
+// changing it.
+func (d *declaration) copyState() desiredState {
+	d.Lock()
+	defer d.Unlock()
+
+	ds := desiredState{
+		Backends: make(map[string]*desiredBackend, len(d.state.Backends)),
+		Classes:  make(map[string]*desiredClass, len(d.state.Classes)),
+	}
+	for name, b := range d.state.Backends {
+		ds.Backends[name] = b
+	}
+	for name, c := range d.state.Classes {
+		ds.Classes[name] = c
+	}
+
+	return ds
+}
+
+// Return the name of the backend of ds named or addressed key, or "" if there's none.
+func (ds desiredState) find(key string) string {
+	if ds.Backends[key] != nil {
+		return key
+	}
+
+	for name, b := range ds.Backends {
+		for _, addr := range b.Address {
+			if addr == key {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
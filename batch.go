@@ -0,0 +1,382 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The states of a batch.
+const (
+	batchRunning  = "running"
+	batchDone     = "done"
+	batchFailed   = "failed"
+	batchCanceled = "canceled"
+)
+
+// The actions a batch takes on each backend it selects, through the declared state, as
+// PUT /config would.
+const (
+	batchDrain  = "drain"
+	batchResume = "resume"
+	batchLimit  = "limit"
+	batchWeight = "weight"
+	batchRemove = "remove"
+)
+
+var errBatchOver = errors.New("batch is over")
+
+// batchSelector selects the declared backends a batch acts on: those matching every
+// field given.
+type batchSelector struct {
+	Zone   string         `json:"zone,omitempty"`
+	Labels metrics.Labels `json:"labels,omitempty"`
+
+	// primary, follower or unavailable; see role().
+	Role string `json:"role,omitempty"`
+}
+
+// batchRequest is the body POSTed to /batches.
+type batchRequest struct {
+	Action   string        `json:"action"`
+	Selector batchSelector `json:"selector"`
+
+	// What the limit action caps the sessions of each backend at; see
+	// desiredBackend.MaxConnections.
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	// What the weight action sets the weight of each backend to; see
+	// desiredBackend.Weight.
+	Weight float64 `json:"weight,omitempty"`
+
+	// How long to wait between backends, so that a fleet is changed gradually; none
+	// by default.
+	Interval string `json:"interval,omitempty"`
+}
+
+// batch is an admin action taken on many backends in the background, one at a time.
+type batch struct {
+	ID string `json:"id"`
+	batchRequest
+	State       string    `json:"state"`
+	RequestedBy string    `json:"requested_by"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished,omitempty"`
+
+	// The backends selected, and how many of them were acted on so far.
+	Backends []string `json:"backends"`
+	Done     int      `json:"done"`
+
+	// The backends the action failed on, with why; the others are still acted on.
+	Errors []string `json:"errors,omitempty"`
+
+	interval time.Duration
+	cancel   chan struct{}
+}
+
+// batches are those started since arbiter was, kept for /batches.
+type batches struct {
+	mu     sync.Mutex
+	list   []*batch
+	nextID int
+}
+
+// Return a snapshot of the batch id, or nil if there's none.
+func (bs *batches) get(id string) *batch {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, b := range bs.list {
+		if b.ID == id {
+			snapshot := *b
+			return &snapshot
+		}
+	}
+
+	return nil
+}
+
+// Return a snapshot of all batches.
+func (bs *batches) all() []batch {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	ret := make([]batch, 0, len(bs.list))
+	for _, b := range bs.list {
+		ret = append(ret, *b)
+	}
+
+	return ret
+}
+
+// Cancel the batch id, leaving the backends acted on so far as they are.
+func (bs *batches) cancel(id string) (batch, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, b := range bs.list {
+		if b.ID != id {
+			continue
+		}
+		if b.State != batchRunning {
+			return *b, errBatchOver
+		}
+
+		b.State, b.Finished = batchCanceled, time.Now()
+		close(b.cancel)
+		return *b, nil
+	}
+
+	return batch{}, errUnknownOperation
+}
+
+// Whether the backend declared as b, and in the pool as info if it's there, matches sel.
+func (sel batchSelector) matches(b *desiredBackend, info *pool.BackendInfo) bool {
+	if sel.Zone != "" && b.Zone != sel.Zone {
+		return false
+	}
+	for k, v := range sel.Labels {
+		if b.Labels[k] != v {
+			return false
+		}
+	}
+	if sel.Role != "" && (info == nil || role(*info) != sel.Role) {
+		return false
+	}
+
+	return true
+}
+
+func (sel batchSelector) String() string {
+	var parts []string
+	if sel.Zone != "" {
+		parts = append(parts, "zone="+sel.Zone)
+	}
+	if len(sel.Labels) > 0 {
+		parts = append(parts, "labels="+sel.Labels.String())
+	}
+	if sel.Role != "" {
+		parts = append(parts, "role="+sel.Role)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Return the names of the declared backends sel selects, sorted.
+func (s *server) selectBackends(sel batchSelector) []string {
+	infos := make(map[string]pool.BackendInfo)
+	for _, info := range s.pool.Backends() {
+		infos[info.Name] = info
+	}
+
+	names := []string{}
+	for name, b := range s.declared.copyState().Backends {
+		var info *pool.BackendInfo
+		if i, ok := infos[name]; ok {
+			info = &i
+		}
+		if sel.matches(b, info) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Start b on behalf of operator, in the background.
+func (s *server) startBatch(b *batch, operator string) batch {
+	s.batches.mu.Lock()
+	s.batches.nextID++
+	b.ID = fmt.Sprintf("%d", s.batches.nextID)
+	b.State, b.RequestedBy, b.Started = batchRunning, operator, time.Now()
+	b.cancel = make(chan struct{})
+	s.batches.list = append(s.batches.list, b)
+	snapshot := *b
+	s.batches.mu.Unlock()
+
+	s.logger.Printf("Audit: %s started batch %s, %s of %d backends selected by %s",
+		operator, b.ID, b.Action, len(b.Backends), b.Selector)
+	go s.runBatch(b)
+
+	return snapshot
+}
+
+// Take the action of b on each of its backends in turn, until they're all done or b is
+// canceled.
+func (s *server) runBatch(b *batch) {
+	for i, name := range b.Backends {
+		if i > 0 && b.interval > 0 {
+			select {
+			case <-b.cancel:
+			case <-time.After(b.interval):
+			}
+		}
+
+		select {
+		case <-b.cancel:
+			s.logger.Printf("Audit: batch %s canceled after %d of %d backends", b.ID, i, len(b.Backends))
+			return
+		default:
+		}
+
+		err := s.batchStep(b, name)
+
+		s.batches.mu.Lock()
+		b.Done++
+		if err != nil {
+			b.Errors = append(b.Errors, fmt.Sprintf("%s: %s", name, err))
+		}
+		s.batches.mu.Unlock()
+	}
+
+	s.batches.mu.Lock()
+	defer s.batches.mu.Unlock()
+	if b.State != batchRunning {
+		return
+	}
+
+	b.State, b.Finished = batchDone, time.Now()
+	if len(b.Errors) > 0 {
+		b.State = batchFailed
+	}
+	s.logger.Printf("Audit: batch %s, %s of %d backends, %s", b.ID, b.Action, len(b.Backends), b.State)
+}
+
+// Take the action of b on the backend named name.
+func (s *server) batchStep(b *batch, name string) error {
+	ds := s.declared.copyState()
+	have := ds.Backends[name]
+	if have == nil {
+		return fmt.Errorf("no longer declared")
+	}
+
+	want := *have
+	switch b.Action {
+	case batchDrain:
+		want.Drained = true
+	case batchResume:
+		want.Drained = false
+	case batchLimit:
+		want.MaxConnections = b.MaxConnections
+	case batchWeight:
+		want.Weight = &b.Weight
+	}
+	ds.Backends[name] = &want
+	if b.Action == batchRemove {
+		delete(ds.Backends, name)
+	}
+
+	_, err := s.apply(ds)
+	return err
+}
+
+// Serve /batches and /batches/{id}.  GET lists the batches, or shows one with its
+// progress, and DELETE cancels it, leaving the backends acted on so far as they are.
+// POST /batches starts a batchRequest, answering with the batch, taking its action on
+// each declared backend the selector matches; drain, resume, limit (the sessions of
+// each backend at max_connections), weight (each backend at weight) or remove.  The operator must name themselves in the
+// X-Arbiter-Operator header to start or cancel a batch.  In approval mode, a batch that
+// drains or removes backends is only requested, and answered with the pending
+// operation; see approvals.
+func (s *server) handleBatches(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/batches"), "/")
+
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		writeJSON(w, http.StatusOK, s.batches.all())
+		return
+	case req.Method == http.MethodGet:
+		if b := s.batches.get(id); b != nil {
+			writeJSON(w, http.StatusOK, b)
+		} else {
+			http.Error(w, errUnknownOperation.Error(), http.StatusNotFound)
+		}
+		return
+	case req.Method == http.MethodPost && id == "", req.Method == http.MethodDelete && id != "":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator := strings.TrimSpace(req.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		b, err := s.batches.cancel(id)
+		switch err {
+		case nil:
+			s.logger.Printf("Audit: %s canceled batch %s", operator, id)
+			writeJSON(w, http.StatusOK, b)
+		case errUnknownOperation:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, fmt.Sprintf("%s (%s)", err, b.State), http.StatusConflict)
+		}
+		return
+	}
+
+	b := &batch{}
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b.batchRequest); err != nil {
+		http.Error(w, fmt.Sprintf("invalid batch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	switch b.Action {
+	case batchDrain, batchResume, batchLimit, batchWeight, batchRemove:
+	default:
+		http.Error(w, fmt.Sprintf("invalid action '%s'; expected drain, resume, limit, weight or remove", b.Action),
+			http.StatusBadRequest)
+		return
+	}
+	switch sel := b.Selector; {
+	case sel.Zone == "" && len(sel.Labels) == 0 && sel.Role == "":
+		http.Error(w, "a selector is required", http.StatusBadRequest)
+		return
+	case sel.Role != "" && sel.Role != "primary" && sel.Role != "follower" && sel.Role != "unavailable":
+		http.Error(w, fmt.Sprintf("invalid role '%s'; expected primary, follower or unavailable", sel.Role),
+			http.StatusBadRequest)
+		return
+	}
+	if b.MaxConnections < -1 {
+		http.Error(w, "max_connections must be -1 or more", http.StatusBadRequest)
+		return
+	}
+	if b.Weight < 0 {
+		http.Error(w, pool.ErrInvalidWeight.Error(), http.StatusBadRequest)
+		return
+	}
+	if b.Interval != "" {
+		var err error
+		if b.interval, err = time.ParseDuration(b.Interval); err != nil || b.interval < 0 {
+			http.Error(w, fmt.Sprintf("invalid interval '%s'", b.Interval), http.StatusBadRequest)
+			return
+		}
+	}
+
+	b.Backends = s.selectBackends(b.Selector)
+
+	if s.approvals != nil && (b.Action == batchDrain || b.Action == batchRemove) {
+		writeJSON(w, http.StatusAccepted, s.approvals.request(operator, "batch "+b.Action,
+			fmt.Sprintf("%s (%s)", b.Selector, strings.Join(b.Backends, ",")),
+			func() error {
+				s.startBatch(b, operator)
+				return nil
+			}))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, s.startBatch(b, operator))
+}
@@ -0,0 +1,98 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatches(t *testing.T) {
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared: &declaration{state: desiredState{Backends: map[string]*desiredBackend{
+			"pg1": {Address: []string{"127.0.0.1:5432"}, Zone: "a"},
+			"pg2": {Address: []string{"127.0.0.1:5433"}, Zone: "b"},
+			"pg3": {Address: []string{"127.0.0.1:5434"}, Zone: "a"},
+		}}},
+	}
+	for _, name := range []string{"pg1", "pg2", "pg3"} {
+		s.pool.PutNamed(name, &queueBackend{})
+	}
+
+	do := func(method, path, operator, body string) (batch, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if operator != "" {
+			req.Header.Set(operatorHeader, operator)
+		}
+
+		w := httptest.NewRecorder()
+		s.handleBatches(w, req)
+
+		var b batch
+		json.Unmarshal(w.Body.Bytes(), &b)
+		return b, w
+	}
+
+	for _, c := range []struct {
+		operator, body string
+		code           int
+	}{
+		{"", `{"action": "drain", "selector": {"zone": "a"}}`, http.StatusBadRequest},
+		{"alice", `{"action": "explode", "selector": {"zone": "a"}}`, http.StatusBadRequest},
+		{"alice", `{"action": "drain", "selector": {}}`, http.StatusBadRequest},
+		{"alice", `{"action": "drain", "selector": {"role": "leader"}}`, http.StatusBadRequest},
+	} {
+		if _, w := do("POST", "/batches", c.operator, c.body); w.Code != c.code {
+			t.Errorf("%s by %q: Expected %d; instead got %d: %s", c.body, c.operator, c.code, w.Code, w.Body)
+		}
+	}
+
+	b, w := do("POST", "/batches", "alice", `{"action": "drain", "selector": {"zone": "a"}}`)
+	if w.Code != http.StatusAccepted || strings.Join(b.Backends, ",") != "pg1,pg3" {
+		t.Fatalf("Expected a batch draining pg1 and pg3; instead got %d: %s", w.Code, w.Body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for b.State == batchRunning && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		b, _ = do("GET", "/batches/"+b.ID, "", "")
+	}
+	if b.State != batchDone || b.Done != 2 {
+		t.Fatalf("Expected the batch to be done; instead got %+v", b)
+	}
+	for _, info := range s.pool.Backends() {
+		if info.Draining != (info.Name != "pg2") {
+			t.Errorf("Expected only the backends in zone a to be drained; instead got %+v", info)
+		}
+	}
+
+	b, _ = do("POST", "/batches", "alice", `{"action": "weight", "weight": 0, "selector": {"zone": "b"}}`)
+	for deadline := time.Now().Add(5 * time.Second); b.State == batchRunning && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		b, _ = do("GET", "/batches/"+b.ID, "", "")
+	}
+	for _, info := range s.pool.Backends() {
+		if info.Weight != 0 && info.Name == "pg2" {
+			t.Errorf("Expected the weight of pg2 to be 0; instead got %+v", info)
+		}
+	}
+
+	b, _ = do("POST", "/batches", "alice", `{"action": "limit", "max_connections": 5, "selector": {"zone": "a"}, "interval": "1h"}`)
+	if _, w := do("DELETE", "/batches/"+b.ID, "bob", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected the batch to be canceled; instead got %d: %s", w.Code, w.Body)
+	}
+	if _, w := do("DELETE", "/batches/"+b.ID, "bob", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected a canceled batch not to be canceled again; instead got %d", w.Code)
+	}
+	if b, _ = do("GET", "/batches/"+b.ID, "", ""); b.State != batchCanceled || b.Done > 1 {
+		t.Errorf("Expected the batch to be canceled before its second backend; instead got %+v", b)
+	}
+}
@@ -0,0 +1,245 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/solvip/arbiter/arbiterpb"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The encodings of events published to a bus.
+const (
+	busJSON     = "json"
+	busProtobuf = "protobuf"
+)
+
+// busOutput delivers encoded events to a topic of a message broker.  key identifies the
+// backend, so that brokers that partition keep the events of each backend in order.
+type busOutput interface {
+	publish(topic, key string, payload []byte) error
+}
+
+// eventBus publishes every state transition, failovers and role violations included,
+// to a topic of Kafka or NATS, so that event-driven automation can subscribe to them
+// instead of polling arbiter.  Events are JSON reports like those of the reporter, or
+// the Event message of arbiter.proto.
+type eventBus struct {
+	s      *server
+	out    busOutput
+	topic  string
+	format string
+}
+
+// Return a bus publishing to rawurl: nats://[user:password@]host:port[,host:port...],
+// or tls:// for NATS over TLS; kafka://[user:password@]host:port[,host:port...] of Kafka
+// brokers, or kafka+tls:// for TLS, authenticating with SASL/PLAIN given a user; or
+// kafka+http[s]://host:port of a Kafka REST proxy.
+func newEventBus(s *server, rawurl, topic, format string) (*eventBus, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &eventBus{s: s, topic: topic, format: format}
+	switch u.Scheme {
+	case "nats", "tls":
+		b.out, err = newNATSOutput(u)
+	case "kafka", "kafka+tls":
+		b.out = newKafkaOutput(u)
+	case "kafka+http", "kafka+https":
+		u.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+		b.out = &kafkaRESTOutput{
+			url:    strings.TrimSuffix(u.String(), "/"),
+			binary: format == busProtobuf,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bus '%s'; expected nats://, tls://, kafka://, kafka+tls:// or kafka+http[s]://", rawurl)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Publish events until the process exits.  While the broker is unreachable, events
+// queue up in the pool's subscription buffer.
+func (b *eventBus) run() {
+	events := b.s.pool.Subscribe()
+
+	backoff := minReportBackoff
+	for e := range events {
+		payload, err := b.encode(e)
+		if err != nil {
+			b.s.logger.Printf("Could not encode event for the bus: %s", err)
+			continue
+		}
+
+		for {
+			err := b.out.publish(b.topic, e.Name, payload)
+			if err == nil {
+				backoff = minReportBackoff
+				break
+			}
+
+			b.s.logger.Printf("Could not publish to %s; retrying in %s: %s", b.topic, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxReportBackoff {
+				backoff = maxReportBackoff
+			}
+		}
+	}
+}
+
+func (b *eventBus) encode(e pool.Event) ([]byte, error) {
+	if b.format == busProtobuf {
+		return marshalEvent(e, b.s.labels)
+	}
+
+	return json.Marshal(report{Kind: "event", Time: time.Now(), Labels: b.s.labels, Event: &e})
+}
+
+// natsOutput publishes to NATS.  The client connects in the background and reconnects
+// whenever the connection fails, buffering what's published meanwhile.
+type natsOutput struct {
+	conn *nats.Conn
+}
+
+func newNATSOutput(u *url.URL) (*natsOutput, error) {
+	var servers []string
+	for _, host := range strings.Split(u.Host, ",") {
+		servers = append(servers, u.Scheme+"://"+host)
+	}
+
+	opts := []nats.Option{
+		nats.Name("arbiter"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+	}
+	if password, ok := u.User.Password(); ok {
+		opts = append(opts, nats.UserInfo(u.User.Username(), password))
+	}
+
+	conn, err := nats.Connect(strings.Join(servers, ","), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsOutput{conn: conn}, nil
+}
+
+func (n *natsOutput) publish(subject, key string, payload []byte) error {
+	return n.conn.Publish(subject, payload)
+}
+
+// kafkaOutput publishes to the partition of the topic the key hashes to, as Kafka's own
+// producers do, once all in-sync replicas have it.
+type kafkaOutput struct {
+	w *kafka.Writer
+}
+
+func newKafkaOutput(u *url.URL) *kafkaOutput {
+	transport := &kafka.Transport{}
+	if u.Scheme == "kafka+tls" {
+		transport.TLS = &tls.Config{}
+	}
+	if password, ok := u.User.Password(); ok {
+		transport.SASL = plain.Mechanism{Username: u.User.Username(), Password: password}
+	}
+
+	return &kafkaOutput{w: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    1,
+		WriteTimeout: 10 * time.Second,
+		Transport:    transport,
+	}}
+}
+
+func (k *kafkaOutput) publish(topic, key string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return k.w.WriteMessages(ctx, kafka.Message{Topic: topic, Key: []byte(key), Value: payload})
+}
+
+// kafkaRESTOutput publishes to Kafka through a Kafka REST proxy, embedding JSON events
+// as is and protobuf ones base64-encoded.
+type kafkaRESTOutput struct {
+	url    string
+	binary bool
+	client *http.Client
+}
+
+func (k *kafkaRESTOutput) publish(topic, key string, payload []byte) error {
+	type record struct {
+		Key   interface{} `json:"key"`
+		Value interface{} `json:"value"`
+	}
+
+	contentType := "application/vnd.kafka.json.v2+json"
+	rec := record{Key: key, Value: json.RawMessage(payload)}
+	if k.binary {
+		contentType = "application/vnd.kafka.binary.v2+json"
+		rec = record{
+			Key:   base64.StdEncoding.EncodeToString([]byte(key)),
+			Value: base64.StdEncoding.EncodeToString(payload),
+		}
+	}
+
+	body, err := json.Marshal(map[string][]record{"records": {rec}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", k.url+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Kafka REST proxy responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Encode e with labels as the Event message of arbiter.proto, with the labels in order
+// of name.
+func marshalEvent(e pool.Event, labels metrics.Labels) ([]byte, error) {
+	m := &arbiterpb.Event{Name: e.Name, Addr: e.Addr, From: arbiterpb.State(e.From), To: arbiterpb.State(e.To),
+		TimeUnixNano: e.Time.UnixNano(), Violation: e.Violation, Labels: labels, DuplicateOf: e.DuplicateOf,
+		Degraded: e.Degraded, SelfHealth: e.SelfHealth}
+	if f := e.Failover; f != nil {
+		m.Failover = &arbiterpb.Failover{From: f.From, To: f.To, TimeUnixNano: f.Time.UnixNano(), Known: f.Known,
+			LossBytes: f.LossBytes, LossNanos: int64(f.Loss)}
+	}
+	if a := e.Anomaly; a != nil {
+		m.Anomaly = &arbiterpb.Anomaly{Series: a.Series, Value: a.Value, Baseline: a.Baseline, Deviation: a.Deviation}
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
@@ -0,0 +1,192 @@
+package arbiter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/segmentio/kafka-go"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveNATS accepts a connection to l, as a NATS server would, and sends what's published
+// on it to published until it's closed, or until it has published n messages.
+func serveNATS(t *testing.T, l net.Listener, n int, published chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+	r := bufio.NewReader(conn)
+	for n > 0 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		switch op, args, _ := strings.Cut(strings.TrimSpace(line), " "); op {
+		case "CONNECT":
+			if !strings.Contains(args, `"user":"arbiter"`) {
+				t.Errorf("Expected the user to be sent; instead got %q", args)
+			}
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case "PUB":
+			payload, _ := r.ReadString('\n')
+			published <- args + " " + strings.TrimSpace(payload)
+			n--
+		}
+	}
+}
+
+func TestNATSOutput(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	published := make(chan string, 3)
+	go func() {
+		// Drop the first connection after a message, which the client reconnects on.
+		serveNATS(t, l, 1, published)
+		serveNATS(t, l, 2, published)
+	}()
+
+	n, err := newNATSOutput(&url.URL{Scheme: "nats", Host: l.Addr().String(), User: url.UserPassword("arbiter", "pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.conn.Close()
+
+	for i, want := range []string{"arbiter.events 2 {}", "arbiter.events 2 ab", "arbiter.events 2 cd"} {
+		// What's published while reconnecting is buffered until the client is back.
+		for i == 1 && n.conn.IsConnected() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := n.publish("arbiter.events", "pg1", []byte(want[len(want)-2:])); err != nil {
+			t.Fatalf("Expected to publish; instead got %v", err)
+		}
+
+		select {
+		case got := <-published:
+			if got != want {
+				t.Errorf("Expected %q; instead got %q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %q to be published", want)
+		}
+	}
+}
+
+func TestKafkaRESTOutput(t *testing.T) {
+	var path, contentType string
+	var body struct {
+		Records []struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		} `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path, contentType = req.URL.Path, req.Header.Get("Content-Type")
+		json.NewDecoder(req.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	k := &kafkaRESTOutput{url: srv.URL, client: srv.Client()}
+	if err := k.publish("arbiter.events", "pg1", []byte(`{"kind":"event"}`)); err != nil {
+		t.Fatalf("Expected to publish; instead got %v", err)
+	}
+
+	if path != "/topics/arbiter.events" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("Expected a JSON record for arbiter.events; instead got %s as %s", path, contentType)
+	}
+	if len(body.Records) != 1 || body.Records[0].Key != "pg1" || string(body.Records[0].Value) != `{"kind":"event"}` {
+		t.Errorf("Expected the event keyed by backend; instead got %+v", body)
+	}
+
+	k.binary = true
+	if err := k.publish("arbiter.events", "pg1", []byte{1, 2}); err != nil {
+		t.Fatalf("Expected to publish; instead got %v", err)
+	}
+	if contentType != "application/vnd.kafka.binary.v2+json" || body.Records[0].Key != "cGcx" {
+		t.Errorf("Expected a base64-encoded record; instead got %s, %+v", contentType, body)
+	}
+}
+
+func TestMarshalEvent(t *testing.T) {
+	e := pool.Event{Name: "pg1", From: pool.READ_ONLY, To: pool.READ_WRITE, Time: time.Unix(0, 1)}
+	got, err := marshalEvent(e, metrics.Labels{"dc": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x0a, 3, 'p', 'g', '1', // name
+		0x18, 1, // from
+		0x20, 2, // to
+		0x28, 1, // time_unix_nano
+		0x42, 7, 0x0a, 2, 'd', 'c', 0x12, 1, 'a', // labels
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %x; instead got %x", want, got)
+	}
+
+	e = pool.Event{Name: "pg1", Time: time.Unix(0, 1), Anomaly: &pool.Anomaly{Series: "lag", Value: 1}}
+	if got, err = marshalEvent(e, nil); err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{
+		0x0a, 3, 'p', 'g', '1', // name
+		0x28, 1, // time_unix_nano
+		0x62, 14, 0x0a, 3, 'l', 'a', 'g', 0x11, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // anomaly
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %x; instead got %x", want, got)
+	}
+}
+
+func TestNewEventBus(t *testing.T) {
+	for _, c := range []struct {
+		url string
+		ok  bool
+	}{
+		{"nats://user:pw@nats:4222", true},
+		{"kafka+https://rest:8082/", true},
+		{"kafka+tls://user:pw@b1:9093,b2:9093", true},
+		{"kafka://broker:9092", true},
+		{"http://rest:8082", false},
+	} {
+		b, err := newEventBus(&server{}, c.url, "arbiter.events", busJSON)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: Expected ok=%v; instead got %v", c.url, c.ok, err)
+		}
+		if b == nil {
+			continue
+		}
+		if k, ok := b.out.(*kafkaRESTOutput); ok && k.url != "https://rest:8082" {
+			t.Errorf("%s: Expected the proxy at https://rest:8082; instead got %s", c.url, k.url)
+		}
+		if k, ok := b.out.(*kafkaOutput); ok && strings.HasPrefix(c.url, "kafka+tls") {
+			if addr := k.w.Addr.String(); addr != "b1:9093,b2:9093" {
+				t.Errorf("%s: Expected the brokers b1:9093,b2:9093; instead got %s", c.url, addr)
+			}
+			transport := k.w.Transport.(*kafka.Transport)
+			if transport.TLS == nil || transport.SASL == nil {
+				t.Errorf("%s: Expected TLS and SASL; instead got %+v", c.url, transport)
+			}
+		}
+		if n, ok := b.out.(*natsOutput); ok {
+			n.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"time"
+)
+
+// The length of a CancelRequest with the 4-byte secret key of protocol 3.0: its own,
+// its code, and the backend's process ID and secret key.  Longer keys, of protocol 3.2,
+// are told apart by their first four bytes.
+const cancelRequestLength = 16
+
+// Return the process ID and secret key that packet, a CancelRequest, cancels the
+// queries of a session with, or nil if it's another first packet.
+func cancelKey(packet []byte) []byte {
+	if len(packet) < cancelRequestLength || binary.BigEndian.Uint32(packet[4:8]) != cancelRequestCode {
+		return nil
+	}
+
+	return packet[8:cancelRequestLength]
+}
+
+// Return the session whose backend gave key in its BackendKeyData, or nil.
+func (l *liveSessions) keyed(key []byte) *session {
+	if key == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ls := range l.m {
+		ls.sess.mu.Lock()
+		found := bytes.Equal(ls.sess.key, key)
+		ls.sess.mu.Unlock()
+		if found {
+			return ls.sess
+		}
+	}
+
+	return nil
+}
+
+// Pass packet, a CancelRequest that the client of sess sent on a connection of its
+// own, on to the backend of sess: routed as any other session, it could reach another
+// backend, which would ignore it.  r is the route of the listener it came on.
+func (s *server) forwardCancel(conn net.Conn, sess *session, packet []byte, r *route) error {
+	var backend net.Conn
+	if l, ok := sess.backend.(*pool.Lease); ok {
+		c, err := l.Backend().Connect(5 * time.Second)
+		if err != nil {
+			return err
+		}
+		backend = c
+	} else {
+		c, err := net.DialTimeout("tcp", sess.backend.RemoteAddr().String(), 5*time.Second)
+		if err != nil {
+			return err
+		}
+		backend = c
+	}
+	defer backend.Close()
+
+	backend.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if r.proxy.send != "" {
+		if err := writeProxyHeader(backend, r.proxy.send, conn.RemoteAddr(), conn.LocalAddr()); err != nil {
+			return err
+		}
+	}
+
+	_, err := backend.Write(packet)
+	return err
+}
@@ -0,0 +1,80 @@
+package arbiter
+
+import (
+	"bytes"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestForwardCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	backendConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	client, frontend := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	s := &server{}
+	go func() {
+		done <- s.proxy(frontend, backendConn, nil, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	roundTrip(t, "frontend", client, backend, startup("user", "app"))
+	roundTrip(t, "backend", backend, client, msg('K', u32(4242), u32(0xdeadbeef)))
+
+	cancel := append(append(u32(16), u32(cancelRequestCode)...), append(u32(4242), u32(0xdeadbeef)...)...)
+	if s.live.keyed(cancelKey(append(u32(16), u32(cancelRequestCode)...))) != nil {
+		t.Errorf("Expected a truncated cancel request to name no session")
+	}
+	if s.live.keyed(cancelKey(startup("user", "app"))) != nil {
+		t.Errorf("Expected a startup packet to name no session")
+	}
+	sess := s.live.keyed(cancelKey(cancel))
+	if sess == nil {
+		t.Fatalf("Expected the session to be found by its key")
+	}
+
+	// The cancel request reaches the session's backend on a connection of its own.
+	cancelClient, cancelConn := tcpPipe(t)
+	defer cancelClient.Close()
+	defer cancelConn.Close()
+	r := &route{proxy: proxyConfig{send: proxyV1}}
+	if err := s.forwardCancel(cancelConn, sess, cancel, r); err != nil {
+		t.Fatalf("Expected the cancel request to be forwarded; instead got %v", err)
+	}
+
+	forwarded, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forwarded.Close()
+	forwarded.SetReadDeadline(time.Now().Add(time.Second))
+	got, err := io.ReadAll(forwarded)
+	if err != nil || !bytes.HasPrefix(got, []byte("PROXY TCP4 127.0.0.1 127.0.0.1 ")) || !bytes.HasSuffix(got, cancel) {
+		t.Errorf("Expected the PROXY header and the cancel request; instead got %q, %v", got, err)
+	}
+
+	backend.Close()
+	<-done
+	if s.live.keyed(cancelKey(cancel)) != nil {
+		t.Errorf("Expected the session's key to be forgotten once it ended")
+	}
+}
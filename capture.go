@@ -0,0 +1,434 @@
+package arbiter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bytes of each message body kept while capturing, enough for most statements;
+// longer ones are recorded truncated.
+const captureBodyLimit = 4096
+
+// How long a session is captured for, unless the duration parameter says otherwise.
+const defaultCaptureDuration = time.Minute
+
+var errUnknownSession = errors.New("no such session")
+
+// liveSessions are the sessions being proxied, by client address, so that operators
+// can find one on /sessions and capture it with /capture.
+type liveSessions struct {
+	mu sync.Mutex
+	m  map[string]*liveSession
+}
+
+// liveSession is a session being proxied, as listed on /sessions.
+type liveSession struct {
+	Client    string    `json:"client"`
+	Backend   string    `json:"backend"`
+	Started   time.Time `json:"started"`
+	Capturing bool      `json:"capturing"`
+
+	// The bytes the client sent and was sent, the reads they took, and when either side
+	// last sent anything.
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	PacketsIn    int64     `json:"packets_in"`
+	PacketsOut   int64     `json:"packets_out"`
+	LastActivity time.Time `json:"last_activity"`
+
+	sess *session
+}
+
+// Register sess, proxying for the client at addr to backend, returning a function that
+// deregisters it.
+func (l *liveSessions) add(addr, backend string, sess *session) (remove func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.m == nil {
+		l.m = make(map[string]*liveSession)
+	}
+	l.m[addr] = &liveSession{Client: addr, Backend: backend, Started: time.Now(), sess: sess}
+
+	return func() {
+		l.mu.Lock()
+		delete(l.m, addr)
+		l.mu.Unlock()
+
+		sess.mu.Lock()
+		sess.stopCapture("the session ended")
+		sess.mu.Unlock()
+	}
+}
+
+// Return the session of the client at addr, or nil.
+func (l *liveSessions) get(addr string) *session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ls := l.m[addr]; ls != nil {
+		return ls.sess
+	}
+	return nil
+}
+
+// Return a snapshot of the sessions, oldest first.
+func (l *liveSessions) list() []liveSession {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ret := make([]liveSession, 0, len(l.m))
+	for _, ls := range l.m {
+		ls.sess.mu.Lock()
+		ls.Capturing = ls.sess.capture != nil
+		ls.sess.mu.Unlock()
+		ls.BytesIn, ls.BytesOut = ls.sess.bytesIn.Get(), ls.sess.bytesOut.Get()
+		ls.PacketsIn, ls.PacketsOut = ls.sess.packetsIn.Get(), ls.sess.packetsOut.Get()
+		ls.LastActivity = ls.Started
+		if ns := ls.sess.lastActive.Load(); ns != 0 {
+			ls.LastActivity = time.Unix(0, ns)
+		}
+		ret = append(ret, *ls)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Started.Before(ret[j].Started) })
+
+	return ret
+}
+
+// capture records the message flow of a session to a file for protocol debugging:
+// the direction, type and length of every message, and of the few that carry them, the
+// statement with its literals scrubbed, the statement name, the command tag, SQLSTATE
+// or transaction status.  Parameter values, rows and the text of errors and notices are
+// never recorded.
+type capture struct {
+	client string
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	start  time.Time
+	until  time.Time
+	logger pool.Logger
+}
+
+// Start capturing to a new file in dir the session of the client at addr, for d.
+func newCapture(dir, addr string, d time.Duration, logger pool.Logger) (*capture, error) {
+	now := time.Now()
+	name := fmt.Sprintf("capture-%s-%s.log", strings.NewReplacer(":", "_", "[", "", "]", "").Replace(addr),
+		now.Format("20060102T150405"))
+	path := filepath.Join(dir, name)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &capture{client: addr, path: path, f: f, w: bufio.NewWriter(f), start: now, until: now.Add(d), logger: logger}
+	fmt.Fprintf(c.w, "# Session of %s captured from %s until %s, literals scrubbed.\n",
+		addr, now.Format(time.RFC3339), c.until.Format(time.RFC3339))
+
+	return c, nil
+}
+
+// Record a message of type typ sent in direction dir, as scanned by sc, returning false
+// once the capture is over.  backslashQuote is as for scrubSQL.
+func (c *capture) record(dir string, typ byte, sc *msgScanner, backslashQuote bool) bool {
+	now := time.Now()
+	if now.After(c.until) {
+		return false
+	}
+
+	names := frontendMsgNames
+	if dir == "B>" {
+		names = backendMsgNames
+	}
+	name := names[typ]
+	if name == "" {
+		name = fmt.Sprintf("%q", typ)
+	}
+
+	fmt.Fprintf(c.w, "%10.3fms %s %-20s %8d", float64(now.Sub(c.start))/float64(time.Millisecond), dir, name, sc.size)
+	if detail := captureDetail(dir, typ, sc.kept, backslashQuote); detail != "" {
+		fmt.Fprintf(c.w, "  %s", detail)
+	}
+	c.w.WriteString("\n")
+
+	return true
+}
+
+// Note something about the session in the capture.
+func (c *capture) note(format string, args ...interface{}) {
+	fmt.Fprintf(c.w, "# "+format+"\n", args...)
+}
+
+func (c *capture) close() error {
+	c.w.Flush()
+	return c.f.Close()
+}
+
+// Stop capturing the session, noting why, if it's being captured.  s must be locked.
+func (s *session) stopCapture(why string) {
+	if s.capture == nil {
+		return
+	}
+
+	c := s.capture
+	s.capture = nil
+	s.fscan.keep, s.bscan.keep = 0, 0
+
+	c.note("Capture ended: %s.", why)
+	if err := c.close(); err != nil {
+		c.logger.Printf("Capture of the session of %s to %s failed: %s", c.client, c.path, err)
+		return
+	}
+	c.logger.Printf("Audit: capture of the session of %s to %s ended: %s", c.client, c.path, why)
+}
+
+// Record the message just scanned by sc, sent in direction dir, if the session is being
+// captured.  s must be locked.
+func (s *session) record(dir string, typ byte, sc *msgScanner) {
+	if s.capture != nil && !s.capture.record(dir, typ, sc, s.backslashQuote) {
+		s.stopCapture("its time was up")
+	}
+}
+
+// The names of the messages a frontend sends, and those a backend sends.
+var (
+	frontendMsgNames = map[byte]string{
+		0: "Untyped", 'B': "Bind", 'C': "Close", 'd': "CopyData", 'c': "CopyDone",
+		'f': "CopyFail", 'D': "Describe", 'E': "Execute", 'H': "Flush", 'F': "FunctionCall",
+		'P': "Parse", 'p': "PasswordMessage", 'Q': "Query", 'S': "Sync", 'X': "Terminate",
+	}
+	backendMsgNames = map[byte]string{
+		0: "Untyped", 'R': "Authentication", 'K': "BackendKeyData", '2': "BindComplete",
+		'3': "CloseComplete", 'C': "CommandComplete", 'd': "CopyData", 'c': "CopyDone",
+		'G': "CopyInResponse", 'H': "CopyOutResponse", 'W': "CopyBothResponse",
+		'D': "DataRow", 'I': "EmptyQueryResponse", 'E': "ErrorResponse",
+		'V': "FunctionCallResponse", 'v': "NegotiateProtocolVersion", 'n': "NoData",
+		'N': "NoticeResponse", 'A': "NotificationResponse", 't': "ParameterDescription",
+		'S': "ParameterStatus", '1': "ParseComplete", 's': "PortalSuspended",
+		'Z': "ReadyForQuery", 'T': "RowDescription",
+	}
+)
+
+// Return what's recorded of a message of type typ sent in direction dir besides its
+// type and length, given the start of its body.
+func captureDetail(dir string, typ byte, body []byte, backslashQuote bool) string {
+	fields := bytes.Split(body, []byte{0})
+	field := func(i int) string {
+		if i < len(fields) {
+			return string(fields[i])
+		}
+		return ""
+	}
+
+	if dir == "F>" {
+		switch typ {
+		case 'Q':
+			return scrubSQL(field(0), backslashQuote)
+		case 'P':
+			return fmt.Sprintf("statement %q: %s", field(0), scrubSQL(field(1), backslashQuote))
+		case 'B':
+			return fmt.Sprintf("statement %q", field(1))
+		}
+		return ""
+	}
+
+	switch typ {
+	case 'C':
+		return field(0)
+	case 'Z':
+		return string(body)
+	case 'E', 'N':
+		// Fields are a type byte and a string; only the SQLSTATE is recorded.
+		for _, f := range fields {
+			if len(f) > 1 && f[0] == 'C' {
+				return "SQLSTATE " + string(f[1:])
+			}
+		}
+	case 'S':
+		// Parameter names, but not their values, which may identify the client.
+		return field(0)
+	case 'R':
+		if len(body) >= 4 {
+			return fmt.Sprintf("method %d", binary.BigEndian.Uint32(body))
+		}
+	}
+	return ""
+}
+
+// Return q with its string, numeric and dollar-quoted literals replaced by ?, so that
+// statements can be recorded without the values in them.  backslashQuote is set if the
+// session has standard_conforming_strings off, under which a backslash escapes the next
+// character in '...' literals as it does in E'...' ones.
+func scrubSQL(q string, backslashQuote bool) string {
+	var b strings.Builder
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == '\'' || (c == 'E' || c == 'e') && i+1 < len(q) && q[i+1] == '\'' && !identChar(q, i-1):
+			// A string literal, in which '' is a quote.
+			escapes := backslashQuote || c != '\''
+			if c != '\'' {
+				i++
+			}
+			for i++; i < len(q); i++ {
+				if q[i] == '\\' && escapes {
+					i++
+				} else if q[i] == '\'' {
+					if i+1 < len(q) && q[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			i++
+			b.WriteByte('?')
+
+		case c == '$' && !identChar(q, i-1):
+			// A positional parameter, left as is, or a dollar-quoted literal.
+			j := i + 1
+			for j < len(q) && identChar(q, j) {
+				j++
+			}
+			if j < len(q) && q[j] == '$' && (j == i+1 || !isDigit(q[i+1])) {
+				tag := q[i : j+1]
+				end := strings.Index(q[j+1:], tag)
+				if end < 0 {
+					i = len(q)
+				} else {
+					i = j + 1 + end + len(tag)
+				}
+				b.WriteByte('?')
+				continue
+			}
+			b.WriteString(q[i:j])
+			i = j
+
+		case isDigit(c) && !identChar(q, i-1):
+			for i < len(q) && (isDigit(q[i]) || q[i] == '.' || q[i] == 'e' || q[i] == 'E' || q[i] == '_') {
+				i++
+			}
+			b.WriteByte('?')
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// Whether q[i] may be part of an identifier; false out of range.
+func identChar(q string, i int) bool {
+	if i < 0 || i >= len(q) {
+		return false
+	}
+
+	c := q[i]
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Serve /sessions, the sessions being proxied.
+func (s *server) handleSessions(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.live.list())
+}
+
+// Capture the session of the client given in the client parameter, as listed on
+// /sessions, for the duration parameter, a minute by default, to a file in the capture
+// directory; POST only.  The operator must name themselves in the X-Arbiter-Operator
+// header, and the capture is logged for audit; in approval mode, it's only requested,
+// and answered with the pending operation.
+func (s *server) handleCapture(w http.ResponseWriter, req *http.Request) {
+	if s.captureDir == "" {
+		http.Error(w, "session capture is off", http.StatusNotFound)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator := strings.TrimSpace(req.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+		return
+	}
+
+	client := req.FormValue("client")
+	if _, _, err := net.SplitHostPort(client); err != nil {
+		http.Error(w, fmt.Sprintf("invalid client '%s': %s", client, err), http.StatusBadRequest)
+		return
+	}
+
+	d := defaultCaptureDuration
+	if v := req.FormValue("duration"); v != "" {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration '%s'", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if s.live.get(client) == nil {
+		http.Error(w, fmt.Sprintf("%s: %s", client, errUnknownSession), http.StatusNotFound)
+		return
+	}
+
+	var path string
+	run := func() error {
+		sess := s.live.get(client)
+		if sess == nil {
+			return errUnknownSession
+		}
+
+		c, err := newCapture(s.captureDir, client, d, s.logger)
+		if err != nil {
+			return err
+		}
+
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+
+		sess.stopCapture("another capture started")
+		sess.capture = c
+		sess.fscan.keep, sess.bscan.keep = captureBodyLimit, captureBodyLimit
+		if sess.opaque {
+			c.note("The session is encrypted end to end; nothing can be captured.")
+		}
+		path = c.path
+
+		s.logger.Printf("Audit: %s started capturing the session of %s to %s for %s", operator, client, c.path, d)
+		return nil
+	}
+
+	if s.approvals != nil {
+		writeJSON(w, http.StatusAccepted, s.approvals.request(operator, "capture", client, run))
+		return
+	}
+
+	if err := run(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"client": client, "path": path})
+}
@@ -0,0 +1,107 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrubSQL(t *testing.T) {
+	for q, want := range map[string]string{
+		"select 1": "select ?",
+		"select * from t1 where name = 'O''Brien'":       "select * from t1 where name = ?",
+		"select * from t where a = E'it\\'s' and b = $1": "select * from t where a = ? and b = $1",
+		"select $$secret$$, $tag$x$y$tag$ from t":        "select ?, ? from t",
+		"insert into t values (1.5e3, -42, 'x')":         "insert into t values (?, -?, ?)",
+		"select col_2 from t where e = 'x'":              "select col_2 from t where e = ?",
+	} {
+		if got := scrubSQL(q, false); got != want {
+			t.Errorf("%s: Expected %q; instead got %q", q, want, got)
+		}
+	}
+
+	// With standard_conforming_strings off, a backslash escapes a quote in any literal.
+	q := `select * from t where a = 'it\'s secret' and b = 1`
+	if got, want := scrubSQL(q, true), "select * from t where a = ? and b = ?"; got != want {
+		t.Errorf("%s: Expected %q; instead got %q", q, want, got)
+	}
+	if got := scrubSQL(q, false); !strings.Contains(got, "secret") {
+		t.Errorf("%s: Expected the backslash to be literal with standard_conforming_strings on; instead got %q", q, got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	client, frontend := tcpPipe(t)
+	backendConn, backend := tcpPipe(t)
+	defer client.Close()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	s := &server{captureDir: dir, logger: log.New(io.Discard, "", 0)}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.proxy(frontend, backendConn, nil, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	for len(s.live.list()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	capture := func(operator string) int {
+		req := httptest.NewRequest("POST", "/capture?client="+client.LocalAddr().String(), nil)
+		if operator != "" {
+			req.Header.Set(operatorHeader, operator)
+		}
+		w := httptest.NewRecorder()
+		s.handleCapture(w, req)
+		return w.Code
+	}
+	if code := capture(""); code != http.StatusBadRequest {
+		t.Errorf("Expected the operator to be required; instead got %d", code)
+	}
+	if code := capture("alice"); code != http.StatusCreated {
+		t.Fatalf("Expected the capture to start; instead got %d", code)
+	}
+	if l := s.live.list(); len(l) != 1 || !l[0].Capturing {
+		t.Errorf("Expected the session to be listed as captured; instead got %+v", l)
+	}
+
+	roundTrip(t, "frontend", client, backend, startup("user", "app", "database", "app"))
+	roundTrip(t, "backend", backend, client, msg('S', cstr("standard_conforming_strings"), cstr("off")))
+	roundTrip(t, "backend", backend, client, msg('Z', []byte("I")))
+	roundTrip(t, "frontend", client, backend, msg('Q', cstr("select * from users where email = 'a@example.com'")))
+	roundTrip(t, "frontend", client, backend, msg('Q', cstr(`select * from users where name = 'O\'Brien, a@example.com'`)))
+	roundTrip(t, "backend", backend, client, msg('D', u16(1), u32(13), []byte("a@example.com")))
+	roundTrip(t, "backend", backend, client, msg('C', cstr("SELECT 1")))
+	backend.Close()
+	<-done
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "capture-*.log"))
+	if len(paths) != 1 {
+		t.Fatalf("Expected a capture file; instead got %v", paths)
+	}
+	b, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(b)
+	for _, want := range []string{"F> Query", "select * from users where email = ?",
+		"select * from users where name = ?", "B> DataRow", "SELECT 1", "Capture ended: the session ended"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected the capture to contain %q; instead got\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "example.com") {
+		t.Errorf("Expected the capture to be scrubbed; instead got\n%s", got)
+	}
+}
@@ -0,0 +1,284 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// How long a subcommand waits for the running arbiter to answer.
+const commandTimeout = 30 * time.Second
+
+// command is a subcommand of arbiter that calls the HTTP interface of a running one,
+// so that operators needn't remember its endpoints during an incident.
+type command struct {
+	usage string
+	help  string
+	run   func(c *adminClient, args []string) error
+}
+
+// The subcommands, by name; see runCommand().
+var commands = map[string]command{
+	"status": {"status", "List the backends and their state",
+		func(c *adminClient, args []string) error { return c.backends(http.MethodGet, "/backends", nil) }},
+	"check": {"check [backend...]", "Health check the backends, or every backend, right away",
+		func(c *adminClient, args []string) error {
+			var form url.Values
+			if len(args) > 0 {
+				form = url.Values{"backend": args}
+			}
+			return c.backends(http.MethodPost, "/check", form)
+		}},
+	"add": {"add address [name]", "Add a backend, named after its address unless given a name",
+		func(c *adminClient, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return errUsage
+			}
+			b := addedBackend{desiredBackend: desiredBackend{Address: []string{args[0]}}}
+			if len(args) == 2 {
+				b.Name = args[1]
+			}
+			return c.do(http.MethodPost, "/backends", b)
+		}},
+	"remove": {"remove backend", "Remove a backend",
+		func(c *adminClient, args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+			return c.do(http.MethodDelete, "/backends/"+url.PathEscape(args[0]), nil)
+		}},
+	"drain":    {"drain backend...", "Drain backends", drainCommand("drain")},
+	"resume":   {"resume backend...", "Resume drained backends", drainCommand("resume")},
+	"cordon":   {"cordon backend... [grace]", "Drain backends once grace, such as 5m, runs out", drainCommand("cordon")},
+	"uncordon": {"uncordon backend...", "Resume cordoned backends", drainCommand("uncordon")},
+	"promote-check": {"promote-check", "List the followers in the order they'd be promoted in",
+		func(c *adminClient, args []string) error { return c.candidates() }},
+	"operations": {"operations", "List the operations pending approval, and those decided",
+		func(c *adminClient, args []string) error { return c.do(http.MethodGet, "/operations", nil) }},
+	"approve": {"approve id", "Approve a pending operation", decideCommand("approve")},
+	"reject":  {"reject id", "Reject a pending operation", decideCommand("reject")},
+}
+
+// errUsage is returned by commands given the wrong arguments.
+var errUsage = errors.New("invalid arguments")
+
+// Return the command POSTing backends to /action, as drain does; cordon takes a grace
+// duration after them.
+func drainCommand(action string) func(c *adminClient, args []string) error {
+	return func(c *adminClient, args []string) error {
+		form := url.Values{}
+		if n := len(args); action == "cordon" && n > 1 {
+			if _, err := time.ParseDuration(args[n-1]); err == nil {
+				form.Set("grace", args[n-1])
+				args = args[:n-1]
+			}
+		}
+		if len(args) == 0 {
+			return errUsage
+		}
+		form["backend"] = args
+
+		return c.do(http.MethodPost, "/"+action+"?"+form.Encode(), nil)
+	}
+}
+
+// Return the command deciding a pending operation, as decision says.
+func decideCommand(decision string) func(c *adminClient, args []string) error {
+	return func(c *adminClient, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		return c.do(http.MethodPost, "/operations/"+decision+"?id="+url.QueryEscape(args[0]), nil)
+	}
+}
+
+// Run the subcommand args[0] with the rest of args against the HTTP interface at addr,
+// writing what it answers to out, and return the exit status: 0 on success, 1 if the
+// call failed, and 2 if the subcommand is unknown or its arguments invalid.
+func runCommand(addr string, args []string, out io.Writer) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printCommands(os.Stderr)
+		return 2
+	}
+
+	c := &adminClient{
+		base:     "http://" + addr,
+		client:   &http.Client{Timeout: commandTimeout},
+		operator: os.Getenv("ARBITER_OPERATOR"),
+		out:      out,
+	}
+	if c.operator == "" {
+		c.operator = os.Getenv("USER")
+	}
+
+	switch err := cmd.run(c, args[1:]); {
+	case err == errUsage:
+		fmt.Fprintf(os.Stderr, "Usage: arbiter [-p addr] %s\n", cmd.usage)
+		return 2
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "arbiter %s: %s\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+// Print the subcommands and what they do to w.
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Commands, which call the HTTP interface of the arbiter at -p:")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, name := range []string{"status", "check", "add", "remove", "drain", "resume", "cordon", "uncordon",
+		"promote-check", "operations", "approve", "reject"} {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].help)
+	}
+	tw.Flush()
+}
+
+// adminClient calls the HTTP interface of a running arbiter on behalf of operator, as
+// named in the X-Arbiter-Operator header, which approval mode requires; it's
+// $ARBITER_OPERATOR, or else $USER.
+type adminClient struct {
+	base     string
+	client   *http.Client
+	operator string
+	out      io.Writer
+}
+
+// Call path with method and body, if not nil, encoded as JSON, returning what's
+// answered and its status, or an error with what arbiter said unless it succeeded.
+func (c *adminClient) call(method, path string, body interface{}) ([]byte, int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.operator != "" {
+		req.Header.Set(operatorHeader, c.operator)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, resp.StatusCode, fmt.Errorf("%s", strings.TrimSpace(string(b)))
+	}
+
+	return b, resp.StatusCode, nil
+}
+
+// Call path as call() does, and print what's answered, or, for operations held for
+// approval, how to approve them.
+func (c *adminClient) do(method, path string, body interface{}) error {
+	b, code, err := c.call(method, path, body)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusAccepted {
+		var op operation
+		if json.Unmarshal(b, &op) == nil && op.ID != "" {
+			fmt.Fprintf(c.out, "Operation %s, %s %s, awaits approval until %s: arbiter approve %s\n", op.ID, op.Action,
+				op.Target, op.Expires.Format(time.RFC3339), op.ID)
+			return nil
+		}
+	}
+
+	if len(bytes.TrimSpace(b)) > 0 {
+		c.out.Write(b)
+		if b[len(b)-1] != '\n' {
+			fmt.Fprintln(c.out)
+		}
+	}
+	return nil
+}
+
+// Call path, which answers with backends as /backends does, with form unless it's
+// empty, and print them as a table.
+func (c *adminClient) backends(method, path string, form url.Values) error {
+	if len(form) > 0 {
+		path += "?" + form.Encode()
+	}
+	b, _, err := c.call(method, path, nil)
+	if err != nil {
+		return err
+	}
+	var backends []backendStats
+	if err := json.Unmarshal(b, &backends); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDR\tSTATE\tLATENCY\tLAG\tLEASES\tWEIGHT\tNOTES")
+	for _, b := range backends {
+		var notes []string
+		for _, note := range []struct {
+			name string
+			ok   bool
+		}{{"draining", b.Draining}, {"cordoned", b.Cordoned}, {"diverged", b.Diverged}, {"superseded", b.Superseded},
+			{"unfenced", b.Unfenced}, {"outvoted", b.Outvoted}, {"archive failing", b.ArchiveFailing}} {
+			if note.ok {
+				notes = append(notes, note.name)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%g\t%s\n", b.Name, b.Addr, b.State, b.Latency, b.Lag, b.Leases,
+			b.Weight, strings.Join(notes, ", "))
+	}
+
+	return tw.Flush()
+}
+
+// Print the promotion candidates as a table.
+func (c *adminClient) candidates() error {
+	b, _, err := c.call(http.MethodGet, "/promotion", nil)
+	if err != nil {
+		return err
+	}
+	var ranked []candidateStats
+	if err := json.Unmarshal(b, &ranked); err != nil {
+		return err
+	}
+	if len(ranked) == 0 {
+		fmt.Fprintln(c.out, "No follower could be promoted.")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tNAME\tADDR\tPRIORITY\tZONE\tSYNCHRONOUS\tLSN\tLAG")
+	for i, cand := range ranked {
+		lsn := "unknown"
+		if cand.LSNKnown {
+			lsn = fmt.Sprintf("%X/%X", cand.LSN>>32, uint32(cand.LSN))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%t\t%s\t%s\n", i+1, cand.Name, cand.Addr, cand.Priority, cand.Zone,
+			cand.Synchronous, lsn, cand.Lag)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,61 @@
+package arbiter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	var calls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", func(w http.ResponseWriter, req *http.Request) {
+		calls = append(calls, req.Method+" "+req.URL.String())
+		writeJSON(w, http.StatusOK, []backendStats{
+			{Name: "pg1", Addr: "10.0.0.1:5432", State: "READ_WRITE", Latency: "1ms", Lag: "0s", Weight: 1},
+			{Name: "pg2", Addr: "10.0.0.2:5432", State: "READ_ONLY", Latency: "2ms", Lag: "1s", Weight: 1, Cordoned: true},
+		})
+	})
+	mux.HandleFunc("/cordon", func(w http.ResponseWriter, req *http.Request) {
+		calls = append(calls, req.Method+" "+req.URL.String()+" by "+req.Header.Get(operatorHeader))
+		writeJSON(w, http.StatusAccepted, operation{ID: "7", Action: "cordon", Target: "pg1,pg2",
+			Expires: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "pg9: unknown backend", http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	t.Setenv("ARBITER_OPERATOR", "alice")
+
+	run := func(args ...string) (int, string) {
+		var out strings.Builder
+		code := runCommand(addr, args, &out)
+		return code, out.String()
+	}
+
+	code, out := run("status")
+	if code != 0 || !strings.Contains(out, "pg2   10.0.0.2:5432  READ_ONLY   2ms      1s   0       1       cordoned") {
+		t.Errorf("Expected the backends as a table; instead got %d, %q", code, out)
+	}
+
+	code, out = run("cordon", "pg1", "pg2", "10m")
+	if code != 0 || out != "Operation 7, cordon pg1,pg2, awaits approval until 2026-01-02T03:04:05Z: arbiter approve 7\n" {
+		t.Errorf("Expected the operation to await approval; instead got %d, %q", code, out)
+	}
+	if want := "POST /cordon?backend=pg1&backend=pg2&grace=10m by alice"; calls[len(calls)-1] != want {
+		t.Errorf("Expected %q; instead got %q", want, calls[len(calls)-1])
+	}
+
+	if code, _ := run("drain", "pg9"); code != 1 {
+		t.Errorf("Expected a refused call to exit with 1; instead got %d", code)
+	}
+	for _, args := range [][]string{{"drain"}, {"add"}, {"failover"}} {
+		if code, _ := run(args...); code != 2 {
+			t.Errorf("%v: Expected to exit with 2; instead got %d", args, code)
+		}
+	}
+}
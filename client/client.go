@@ -0,0 +1,190 @@
+// client implements read/write splitting over arbiter's primary and follower listeners.
+//
+// Applications open one *sql.DB against the primary listener and one against the
+// follower listener, and let DB decide which to use:
+//
+//	db := client.New(primary, replica)
+//	rows, err := db.QueryRead(ctx, "select * from users where id = $1", id)
+//	_, err = db.ExecWrite(ctx, "update users set name = $1 where id = $2", name, id)
+//
+// Reads that must observe the caller's own writes can be forced to the primary with
+// WithPrimary(ctx).
+package client
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+type primaryKey struct{}
+
+// WithPrimary returns a context that routes reads made with it to the primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// Policy controls how DB retries and falls back on failure.
+type Policy struct {
+	// The number of times an operation is retried after the first attempt.
+	Retries int
+
+	// The delay before the first retry; doubled on every subsequent retry.
+	Backoff time.Duration
+
+	// Retry reads that failed on the replica against the primary.
+	FallbackToPrimary bool
+}
+
+// DefaultPolicy retries twice, starting at 100ms, and falls back to the primary.
+var DefaultPolicy = Policy{
+	Retries:           2,
+	Backoff:           100 * time.Millisecond,
+	FallbackToPrimary: true,
+}
+
+// DB wraps the primary and replica handles.
+type DB struct {
+	primary *sql.DB
+	replica *sql.DB
+
+	Policy Policy
+}
+
+// New returns a DB using DefaultPolicy.
+func New(primary, replica *sql.DB) *DB {
+	return &DB{
+		primary: primary,
+		replica: replica,
+		Policy:  DefaultPolicy,
+	}
+}
+
+// Open opens the primary and replica handles with the same driver.
+func Open(driverName, primaryDSN, replicaDSN string) (*DB, error) {
+	primary, err := sql.Open(driverName, primaryDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	replica, err := sql.Open(driverName, replicaDSN)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+
+	return New(primary, replica), nil
+}
+
+// Primary returns the handle connected to the primary listener.
+func (db *DB) Primary() *sql.DB {
+	return db.primary
+}
+
+// Replica returns the handle connected to the follower listener.
+func (db *DB) Replica() *sql.DB {
+	return db.replica
+}
+
+// Close closes both handles.
+func (db *DB) Close() error {
+	perr := db.primary.Close()
+	if err := db.replica.Close(); err != nil {
+		return err
+	}
+	return perr
+}
+
+// QueryRead runs a query on the replica, unless ctx was created with WithPrimary.
+// Connection errors are retried according to the policy, on the primary if
+// FallbackToPrimary is set.
+func (db *DB) QueryRead(ctx context.Context, query string, args ...interface{}) (rows *sql.Rows, err error) {
+	h := db.replica
+	if usePrimary(ctx) {
+		h = db.primary
+	}
+
+	err = db.retry(ctx, isConnError, func() error {
+		rows, err = h.QueryContext(ctx, query, args...)
+		if err != nil && db.Policy.FallbackToPrimary {
+			h = db.primary
+		}
+		return err
+	})
+
+	return rows, err
+}
+
+// ExecWrite executes a statement on the primary.  Since a statement may have been
+// executed even though its connection failed, it's only retried if it was never sent:
+// if no connection could be established, including when arbiter accepts it and closes
+// it for want of a primary, or if the driver reports driver.ErrBadConn, which drivers
+// only do for statements they didn't send.
+func (db *DB) ExecWrite(ctx context.Context, query string, args ...interface{}) (res sql.Result, err error) {
+	err = db.retry(ctx, isUnsent, func() error {
+		conn, err := db.primary.Conn(ctx)
+		if err != nil {
+			return unsentError{err}
+		}
+		defer conn.Close()
+
+		res, err = conn.ExecContext(ctx, query, args...)
+		return err
+	})
+
+	if u, ok := err.(unsentError); ok {
+		err = u.err
+	}
+	return res, err
+}
+
+func (db *DB) retry(ctx context.Context, retryable func(error) bool, f func() error) (err error) {
+	backoff := db.Policy.Backoff
+	for i := 0; ; i++ {
+		if err = f(); err == nil || i >= db.Policy.Retries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Whether err was caused by the connection rather than by the statement.
+// Arbiter closes client connections when no backend is available, which drivers
+// report as EOF or a bad connection.
+func isConnError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var nerr net.Error
+	return errors.As(err, &nerr)
+}
+
+// unsentError is the error of a connection that failed before a statement was sent.
+type unsentError struct {
+	err error
+}
+
+func (e unsentError) Error() string {
+	return e.err.Error()
+}
+
+func isUnsent(err error) bool {
+	_, ok := err.(unsentError)
+	return ok || errors.Is(err, driver.ErrBadConn)
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQueryRead(t *testing.T) {
+	db, err := Open("fake", "primary", "down")
+	if err != nil {
+		t.Fatalf("Expected to open the handles, instead got: %v", err)
+	}
+	defer db.Close()
+	db.Policy.Backoff = 0
+
+	rows, err := db.QueryRead(context.Background(), "select")
+	if err != nil {
+		t.Fatalf("Expected the read to fall back to the primary, instead got: %v", err)
+	}
+	rows.Close()
+
+	db.Policy.FallbackToPrimary = false
+	if _, err = db.QueryRead(context.Background(), "select"); err == nil {
+		t.Fatalf("Expected the read to fail without falling back to the primary")
+	}
+
+	rows, err = db.QueryRead(WithPrimary(context.Background()), "select")
+	if err != nil {
+		t.Fatalf("Expected WithPrimary to route the read to the primary, instead got: %v", err)
+	}
+	rows.Close()
+}
+
+func TestExecWriteWithoutPrimary(t *testing.T) {
+	// Arbiter accepts clients while it has no primary, and closes them.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+
+	dsn := "postgres://app@" + ln.Addr().String() + "/app?sslmode=disable"
+	db, err := Open("postgres", dsn, dsn)
+	if err != nil {
+		t.Fatalf("Expected to open the handles, instead got: %v", err)
+	}
+	defer db.Close()
+	db.Policy.Backoff = 0
+
+	if _, err := db.ExecWrite(context.Background(), "insert into t values (1)"); err == nil {
+		t.Fatalf("Expected the write to fail without a primary")
+	}
+	if n := accepted.Load(); n != int64(db.Policy.Retries+1) {
+		t.Errorf("Expected the write to be retried %d times; instead it was tried %d times", db.Policy.Retries, n)
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	opErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	for _, err := range []error{
+		io.EOF,
+		driver.ErrBadConn,
+		opErr,
+		fmt.Errorf("query: %w", opErr),
+		fmt.Errorf("query: %w", io.ErrUnexpectedEOF),
+	} {
+		if !isConnError(err) {
+			t.Errorf("Expected %v to be a connection error", err)
+		}
+	}
+
+	if isConnError(errors.New("syntax error")) {
+		t.Errorf("Expected a query error not to be a connection error")
+	}
+}
+
+// fake is a database/sql driver whose connections fail if the DSN is "down".
+type fake struct{}
+
+type fakeConn struct{ down bool }
+
+type fakeRows struct{}
+
+func init() {
+	sql.Register("fake", fake{})
+}
+
+func (fake) Open(name string) (driver.Conn, error) { return &fakeConn{down: name == "down"}, nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	if c.down {
+		return nil, io.EOF
+	}
+	return fakeRows{}, nil
+}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
@@ -0,0 +1,99 @@
+package arbiter
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// How a listener handles clients asking for TLS.
+const (
+	// Pass SSLRequest on to the backend, which negotiates TLS with the client itself.
+	tlsPassthrough = "passthrough"
+
+	// Answer SSLRequest, and encrypt the client's connection to arbiter with its own
+	// certificate; the session to the backend is in the clear.
+	tlsTerminate = "terminate"
+)
+
+// TLSSettings configure how a listener handles clients asking for TLS.
+type TLSSettings struct {
+	TLS     string `gcfg:"tls"`
+	TLSCert string `gcfg:"tls-cert"`
+	TLSKey  string `gcfg:"tls-key"`
+}
+
+// Return s with the settings it leaves out taken from defaults.
+func (s TLSSettings) inherit(defaults TLSSettings) TLSSettings {
+	if s.TLS == "" {
+		s.TLS = defaults.TLS
+	}
+	if s.TLSCert == "" {
+		s.TLSCert = defaults.TLSCert
+	}
+	if s.TLSKey == "" {
+		s.TLSKey = defaults.TLSKey
+	}
+
+	return s
+}
+
+// Return the configuration to terminate TLS with, or nil if it's passed through,
+// checking that s is valid.
+func (s TLSSettings) config() (*tls.Config, error) {
+	switch s.TLS {
+	case "", tlsPassthrough:
+		return nil, nil
+	case tlsTerminate:
+	default:
+		return nil, fmt.Errorf("invalid tls '%s'; expected %s or %s", s.TLS, tlsPassthrough, tlsTerminate)
+	}
+
+	if s.TLSCert == "" || s.TLSKey == "" {
+		return nil, fmt.Errorf("tls = %s requires tls-cert and tls-key", tlsTerminate)
+	}
+	cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Terminate TLS on conn if the client asks for it with SSLRequest, returning the
+// connection to proxy from.  GSSENCRequest is declined, so that the client can go on to
+// ask for TLS instead, and clients that ask for neither are proxied in the clear.
+func terminateTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for {
+		packet := make([]byte, 8)
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return nil, err
+		}
+
+		switch binary.BigEndian.Uint32(packet[4:8]) {
+		case gssencRequestCode:
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+
+		case sslRequestCode:
+			if _, err := conn.Write([]byte{'S'}); err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Server(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				return nil, err
+			}
+			return tlsConn, nil
+
+		default:
+			return newReplayConn(conn, packet), nil
+		}
+	}
+}
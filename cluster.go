@@ -0,0 +1,69 @@
+package arbiter
+
+import (
+	"context"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"log/slog"
+)
+
+// Start a pool for each [cluster] of c, bound to ctx, monitoring its backends with
+// opts, the options of the pool of the backends in [main], and the settings and
+// failover policy the cluster overrides.  Its metrics are labeled, and its records
+// logged, with cluster=name.  Clusters are only routed to by the listeners bound to
+// them; the backends of [main] are the ones the admin interface manages.
+func (s *server) startClusters(ctx context.Context, c *Config, opts []pool.Option, l *slog.Logger) error {
+	for name, cl := range c.Cluster {
+		labels := s.labels.With(metrics.Labels{"cluster": name})
+		clusterOpts := append(opts[:len(opts):len(opts)],
+			pool.WithFailoverPolicy(cl.failover),
+			pool.WithMetrics(labelingSink{s.measured(), labels, s.perBackendLabels}),
+			pool.WithStructuredLogger(l.With("cluster", name)))
+		clusterOpts = append(clusterOpts, primaryOptions(cl.ClusterMode, cl.PrimaryArbitration)...)
+		if cl.FailoverJournal != "" {
+			clusterOpts = append(clusterOpts, pool.WithFailoverJournal(cl.FailoverJournal))
+		}
+		p := pool.New(ctx, clusterOpts...)
+
+		for _, addr := range cl.Backend {
+			var b pool.Backend
+			if cl.Engine == engineMySQL {
+				b = pool.NewCheckedBackend(addr, pool.NewMySQLChecker(addr, mysqlSettings(cl.settings)), cl.settings.Dialer)
+			} else {
+				b = pool.NewBackend([]string{addr}, cl.settings)
+			}
+			if err := p.Put(b); err != nil {
+				return fmt.Errorf("cluster %s: %s: %s", name, addr, err)
+			}
+		}
+
+		if s.clusters == nil {
+			s.clusters = make(map[string]*pool.Pool)
+		}
+		s.clusters[name] = p
+	}
+
+	return nil
+}
+
+// Return the settings of the MySQL checks of a cluster, which take what applies to them
+// of the settings of its Postgres checks.
+func mysqlSettings(s pool.PostgresSettings) pool.MySQLSettings {
+	return pool.MySQLSettings{
+		User:           s.User,
+		Password:       s.Password,
+		ConnectTimeout: s.ConnectTimeout,
+		Dialer:         s.Dialer,
+		Credentials:    s.Credentials,
+	}
+}
+
+// Return the pool of the named cluster, or that of the backends in [main] if name is
+// empty.
+func (s *server) cluster(name string) *pool.Pool {
+	if name == "" {
+		return s.pool
+	}
+	return s.clusters[name]
+}
@@ -0,0 +1,11 @@
+// arbiter routes PostgreSQL clients to the primary or a follower of a streaming
+// replication cluster; see the arbiter package, and config.ini for its configuration.
+package main
+
+import (
+	"github.com/solvip/arbiter"
+)
+
+func main() {
+	arbiter.Main()
+}
@@ -1,10 +1,21 @@
-package main
+package arbiter
 
 import (
+	"crypto/tls"
 	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
 	"gopkg.in/gcfg.v1"
+	"log/slog"
+	"math"
 	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type ConfigError interface {
@@ -15,57 +26,2121 @@ func newConfigError(format string, args ...interface{}) ConfigError {
 	return fmt.Errorf(format, args...)
 }
 
+// What becomes of the sessions on the previous primary on a failover; see [main]
+// failover.
+const (
+	failoverSever = "sever"
+	failoverDrain = "drain"
+)
+
+// How the primary is chosen among backends taking writes; see [main]
+// primary-arbitration.
+const (
+	arbitrationLatest = "latest"
+	arbitrationWAL    = "wal"
+)
+
+// Whether backends replicate from a primary, or are nodes of a distributed database
+// that all take writes; see [main] cluster-mode.
+const (
+	clusterReplication = "replication"
+	clusterDistributed = "distributed"
+)
+
+// What the backends of a [cluster] run; see its engine.
+const (
+	enginePostgres = "postgres"
+	engineMySQL    = "mysql"
+)
+
+// How arbiter promotes a follower once the primary of [main] is confirmed down; see
+// [promotion] mode.
+const (
+	promotionOff       = "off"
+	promotionPgPromote = "pg_promote"
+	promotionCommand   = "command"
+)
+
+// The liveness query of distributed backends if [health] liveness-query is left out.
+const defaultLivenessQuery = "select 1"
+
+// How long the queries of a check may take by default, so that a backend that hangs is
+// taken out of routing rather than waited on forever.
+const defaultQueryTimeout = 10 * time.Second
+
+// The port of backend addresses given without one, unless a port setting says
+// otherwise.
+const defaultPostgresPort = "5432"
+
+// The weight of each measurement in the baselines of [health] anomaly-threshold.
+const anomalyAlpha = 0.05
+
+// CheckSettings control how backends are health checked.  They're given in [health]
+// and can be overridden for individual backends in [backend "name"]; settings left out
+// of a backend section are inherited from [health].
+type CheckSettings struct {
+	Username string
+	Password string
+	Database string
+
+	// Where the password is read from instead, so that it isn't in the configuration
+	// or arbiter's command line: a file, of which the first line is the password, or
+	// an environment variable.  Without a password from either, it's looked up in
+	// Passfile, a libpq password file; see pool.PostgresSettings.Passfile.
+	PasswordFile string `gcfg:"password-file"`
+	PasswordEnv  string `gcfg:"password-env"`
+	Passfile     string `gcfg:"passfile"`
+
+	// The path of the Vault secrets engine issuing the credential to check with, in
+	// place of the above; see [vault].
+	VaultPath string `gcfg:"vault-path"`
+
+	// The port of addresses given without one; 5432 if empty.
+	Port string
+
+	// Further parameters of the monitoring connection, as key=value; see
+	// pool.PostgresSettings.Options.
+	Option []string
+
+	// A second credential to check with, should the first stop working, as during a
+	// password rotation.
+	SecondaryUsername string `gcfg:"secondary-username"`
+	SecondaryPassword string `gcfg:"secondary-password"`
+
+	// The databases clients are routed to, if Database is a dedicated one for
+	// monitoring; they're checked to exist and accept connections.
+	RoutingDatabase []string `gcfg:"routing-database"`
+
+	// Further queries to check, as "query" or "query => expected"; see
+	// pool.CheckQuery.
+	Check []string
+
+	// How often to check, the timeout for connecting, and that of the queries of a
+	// check; see pool.PostgresSettings.QueryTimeout.
+	Interval     string
+	Timeout      string
+	QueryTimeout string `gcfg:"query-timeout"`
+
+	// How long the monitoring connection is used before it's replaced; see
+	// pool.PostgresSettings.ConnLifetime.
+	ConnectionLifetime string `gcfg:"connection-lifetime"`
+
+	// What checks that the nodes of a distributed database are live, rather than
+	// their role; see pool.PostgresSettings.LivenessQuery.
+	LivenessQuery string `gcfg:"liveness-query"`
+
+	// The REST API of the Patroni agent of the backend, which its role and WAL
+	// positions are checked with rather than SQL; see pool.PostgresSettings.Patroni.
+	Patroni string
+
+	// Reach the backend through a tunnel, for both checks and sessions; see
+	// tunnelDialer().
+	Tunnel           string
+	TunnelKey        string `gcfg:"tunnel-key"`
+	TunnelKnownHosts string `gcfg:"tunnel-known-hosts"`
+
+	// TLS on the monitoring connection, as libpq's settings of the same names; see
+	// pool.PostgresSettings.
+	SSLMode     string `gcfg:"sslmode"`
+	SSLRootCert string `gcfg:"sslrootcert"`
+	SSLCert     string `gcfg:"sslcert"`
+	SSLKey      string `gcfg:"sslkey"`
+}
+
+// Return s with the settings it leaves out taken from defaults.
+func (s CheckSettings) inherit(defaults CheckSettings) CheckSettings {
+	if s.Username == "" {
+		s.Username = defaults.Username
+	}
+	// The password is inherited only if no source of one is given, lest one inherited
+	// take precedence over another given.
+	if s.Password == "" && s.PasswordFile == "" && s.PasswordEnv == "" {
+		s.Password, s.PasswordFile, s.PasswordEnv = defaults.Password, defaults.PasswordFile, defaults.PasswordEnv
+	}
+	if s.Passfile == "" {
+		s.Passfile = defaults.Passfile
+	}
+	if s.VaultPath == "" {
+		s.VaultPath = defaults.VaultPath
+	}
+	if s.Port == "" {
+		s.Port = defaults.Port
+	}
+	if len(s.Option) == 0 {
+		s.Option = defaults.Option
+	}
+	if s.SecondaryUsername == "" {
+		s.SecondaryUsername = defaults.SecondaryUsername
+	}
+	if s.SecondaryPassword == "" {
+		s.SecondaryPassword = defaults.SecondaryPassword
+	}
+	if s.Database == "" {
+		s.Database = defaults.Database
+	}
+	if len(s.RoutingDatabase) == 0 {
+		s.RoutingDatabase = defaults.RoutingDatabase
+	}
+	if len(s.Check) == 0 {
+		s.Check = defaults.Check
+	}
+	if s.Interval == "" {
+		s.Interval = defaults.Interval
+	}
+	if s.Timeout == "" {
+		s.Timeout = defaults.Timeout
+	}
+	if s.QueryTimeout == "" {
+		s.QueryTimeout = defaults.QueryTimeout
+	}
+	if s.ConnectionLifetime == "" {
+		s.ConnectionLifetime = defaults.ConnectionLifetime
+	}
+	if s.LivenessQuery == "" {
+		s.LivenessQuery = defaults.LivenessQuery
+	}
+	if s.Patroni == "" {
+		s.Patroni = defaults.Patroni
+	}
+	if s.Tunnel == "" {
+		s.Tunnel = defaults.Tunnel
+	}
+	if s.TunnelKey == "" {
+		s.TunnelKey = defaults.TunnelKey
+	}
+	if s.TunnelKnownHosts == "" {
+		s.TunnelKnownHosts = defaults.TunnelKnownHosts
+	}
+	if s.SSLMode == "" {
+		s.SSLMode = defaults.SSLMode
+	}
+	if s.SSLRootCert == "" {
+		s.SSLRootCert = defaults.SSLRootCert
+	}
+	if s.SSLCert == "" {
+		s.SSLCert = defaults.SSLCert
+	}
+	if s.SSLKey == "" {
+		s.SSLKey = defaults.SSLKey
+	}
+
+	return s
+}
+
+// Convert s to the settings of a Postgres backend, as postgres(), checked with the
+// credentials Vault issues if s has a vault-path.
+func (c *Config) checkSettings(s CheckSettings, distributed bool) (pool.PostgresSettings, error) {
+	ps, err := s.postgres(distributed)
+	if err != nil || s.VaultPath == "" {
+		return ps, err
+	}
+	if c.Vault.Address == "" {
+		return ps, fmt.Errorf("vault-path needs [vault] address")
+	}
+
+	creds := c.Vault.credentials[s.VaultPath]
+	if creds == nil {
+		creds = pool.NewVaultCredentials(c.Vault.Address, s.VaultPath, c.vaultToken)
+		if c.Vault.credentials == nil {
+			c.Vault.credentials = make(map[string]*pool.VaultCredentials)
+		}
+		c.Vault.credentials[s.VaultPath] = creds
+	}
+	ps.Credentials = creds
+
+	return ps, nil
+}
+
+// Return the Vault token, from [vault] token-file, read anew so that one renewed by
+// Vault Agent is picked up, or else from $VAULT_TOKEN.
+func (c *Config) vaultToken() (string, error) {
+	if c.Vault.TokenFile == "" {
+		token, ok := os.LookupEnv("VAULT_TOKEN")
+		if !ok {
+			return "", fmt.Errorf("neither [vault] token-file nor VAULT_TOKEN is set")
+		}
+		return token, nil
+	}
+
+	b, err := os.ReadFile(c.Vault.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// The connection parameters that settings of their own give, rather than options.
+var reservedOptions = map[string]bool{
+	"host": true, "hostaddr": true, "port": true, "user": true, "password": true, "dbname": true,
+	"passfile": true, "connect_timeout": true,
+	"sslmode": true, "sslrootcert": true, "sslcert": true, "sslkey": true,
+}
+
+// Return addr, with port, or the default port if it's empty, if addr has none.
+func withPort(addr, port string) (string, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr, nil
+	}
+
+	if port == "" {
+		port = defaultPostgresPort
+	}
+
+	addr = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), port)
+	_, _, err := net.SplitHostPort(addr)
+	return addr, err
+}
+
+// Return the password of s, from whichever of its sources is given.
+func (s CheckSettings) password() (string, error) {
+	given := 0
+	for _, source := range []string{s.Password, s.PasswordFile, s.PasswordEnv} {
+		if source != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", fmt.Errorf("only one of password, password-file and password-env may be given")
+	}
+
+	switch {
+	case s.PasswordFile != "":
+		b, err := os.ReadFile(s.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("password-file: %s", err)
+		}
+		password, _, _ := strings.Cut(string(b), "\n")
+		return strings.TrimSuffix(password, "\r"), nil
+	case s.PasswordEnv != "":
+		password, ok := os.LookupEnv(s.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("password-env: %s isn't set", s.PasswordEnv)
+		}
+		return password, nil
+	}
+
+	return s.Password, nil
+}
+
+// Convert s to the settings of a Postgres backend, of a distributed database or not;
+// LivenessQuery only applies to the former.
+func (s CheckSettings) postgres(distributed bool) (ps pool.PostgresSettings, err error) {
+	ps = pool.PostgresSettings{
+		User:     s.Username,
+		Database: s.Database,
+		Passfile: s.Passfile,
+
+		RoutingDatabases: s.RoutingDatabase,
+		Patroni:          s.Patroni,
+
+		SecondaryUser:     s.SecondaryUsername,
+		SecondaryPassword: s.SecondaryPassword,
+
+		SSLMode:     s.SSLMode,
+		SSLRootCert: s.SSLRootCert,
+		SSLCert:     s.SSLCert,
+		SSLKey:      s.SSLKey,
+	}
+
+	switch s.SSLMode {
+	case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return ps, fmt.Errorf("invalid sslmode '%s'", s.SSLMode)
+	}
+	if (s.SSLCert == "") != (s.SSLKey == "") {
+		return ps, fmt.Errorf("sslcert and sslkey must be given together")
+	}
+
+	if ps.Password, err = s.password(); err != nil {
+		return ps, err
+	}
+
+	if s.Port != "" {
+		if n, err := strconv.Atoi(s.Port); err != nil || n < 1 || n > 65535 {
+			return ps, fmt.Errorf("invalid port '%s'", s.Port)
+		}
+	}
+
+	for _, option := range s.Option {
+		key, value, ok := strings.Cut(option, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case !ok || key == "":
+			return ps, fmt.Errorf("invalid option '%s'; expected key=value", option)
+		case reservedOptions[key]:
+			return ps, fmt.Errorf("option %s is given by a setting of its own", key)
+		}
+		if ps.Options == nil {
+			ps.Options = make(map[string]string)
+		}
+		ps.Options[key] = value
+	}
+
+	for _, check := range s.Check {
+		q := pool.CheckQuery{Query: check}
+		if i := strings.LastIndex(check, "=>"); i >= 0 {
+			q = pool.CheckQuery{Query: strings.TrimSpace(check[:i]), Expect: strings.TrimSpace(check[i+2:])}
+		}
+		if q.Query == "" {
+			return ps, fmt.Errorf("invalid check '%s'", check)
+		}
+		ps.Checks = append(ps.Checks, q)
+	}
+
+	if s.Interval != "" {
+		if ps.CheckInterval, err = time.ParseDuration(s.Interval); err != nil {
+			return ps, fmt.Errorf("invalid interval: %s", err)
+		}
+	}
+
+	if s.Timeout != "" {
+		if ps.ConnectTimeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return ps, fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+
+	ps.QueryTimeout = defaultQueryTimeout
+	if s.QueryTimeout != "" {
+		if ps.QueryTimeout, err = time.ParseDuration(s.QueryTimeout); err != nil || ps.QueryTimeout < 0 {
+			return ps, fmt.Errorf("invalid query-timeout '%s'", s.QueryTimeout)
+		}
+	}
+
+	if s.ConnectionLifetime != "" {
+		if ps.ConnLifetime, err = time.ParseDuration(s.ConnectionLifetime); err != nil || ps.ConnLifetime < 0 {
+			return ps, fmt.Errorf("invalid connection-lifetime '%s'", s.ConnectionLifetime)
+		}
+	}
+
+	if s.Patroni != "" {
+		if u, err := url.Parse(s.Patroni); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return ps, fmt.Errorf("invalid patroni '%s'; expected http://host:port or https://host:port", s.Patroni)
+		}
+	}
+
+	if distributed {
+		ps.LivenessQuery = s.LivenessQuery
+		if ps.LivenessQuery == "" {
+			ps.LivenessQuery = defaultLivenessQuery
+		}
+	}
+
+	if s.Tunnel != "" {
+		if ps.Dialer, err = tunnelDialer(s.Tunnel, s.TunnelKey, s.TunnelKnownHosts); err != nil {
+			return ps, fmt.Errorf("invalid tunnel: %s", err)
+		}
+	}
+
+	return ps, nil
+}
+
 type Config struct {
 	Main struct {
 		Primary  string
 		Follower string
 		Backends []string
+
+		// Where sessions are routed while no backends are configured; without it,
+		// arbiter refuses to start with none.
+		Fallback string
+
+		// Backends given as a libpq connection string, or a service in a libpq
+		// service file, whose hosts are added to Backends.  The user, password,
+		// database and timeout in it are defaults for [health].
+		ConnString  string `gcfg:"connstring"`
+		Service     string
+		ServiceFile string `gcfg:"service-file"`
+
+		// Backends within this percentage of the closest one, or this duration,
+		// whichever is wider, are routed to in turn rather than the closest alone.
+		LatencyBandPercent float64 `gcfg:"latency-band-percent"`
+		LatencyBand        string  `gcfg:"latency-band"`
+		latencyBand        time.Duration
+
+		// Backends are routed by a moving average of their round trips, weighing each
+		// check this much, or by the 95th percentile of the last latency-window ones;
+		// see pool.WithLatencySmoothing().
+		LatencySmoothing float64 `gcfg:"latency-smoothing"`
+		LatencyWindow    int     `gcfg:"latency-window"`
+
+		// Whether several backends legitimately take writes, each for its write group,
+		// and the group the primary listener writes to; see pool.WithMultiWriter().
+		MultiWriter bool   `gcfg:"multi-writer"`
+		WriteGroup  string `gcfg:"write-group"`
+
+		// Followers further behind the primary than this aren't routed to by the
+		// follower listener, nor by the eventual class.
+		MaxLag string `gcfg:"max-lag"`
+		maxLag time.Duration
+
+		// A Go plugin or WASM module routing sessions that followers may serve; see
+		// loadScorer().
+		Scorer string
+
+		// Or expressions deciding which backends such sessions may be routed to, and
+		// which of those is preferred; see expr and routeVarTypes.  Zone is the zone
+		// arbiter runs in, for comparing with those of backends, and preferred for
+		// clients in none of the ClientZone blocks.
+		RouteIf    string `gcfg:"route-if"`
+		RouteScore string `gcfg:"route-score"`
+		Zone       string
+		routeIf    *expr
+		routeScore *expr
+
+		// The zones clients are in, as a CIDR block of their addresses followed by the
+		// zone, so that their sessions that followers may serve are routed to backends
+		// in the same zone where there are any; see pool.InZone().
+		ClientZone  []string `gcfg:"client-zone"`
+		clientZones clientZones
+
+		// Whether sessions that followers may serve stick to the follower the last
+		// session of the same client, or of the same user and database, was routed to,
+		// and for how long after it; see pool.StickTo().
+		StickyReads string `gcfg:"sticky-reads"`
+		StickyTTL   string `gcfg:"sticky-ttl"`
+		stickyTTL   time.Duration
+
+		// Where the pool's knowledge of the primary is kept across restarts; see
+		// pool.WithFailoverJournal.
+		FailoverJournal string `gcfg:"failover-journal"`
+
+		// Whether the sessions on the previous primary are severed or drained when the
+		// primary changes, and how long drained ones have before they're severed; see
+		// pool.WithFailoverPolicy().
+		Failover      string
+		FailoverGrace string `gcfg:"failover-grace"`
+		failover      pool.FailoverPolicy
+
+		// Whether a backend taking writes replaces the primary as the latest to, or only
+		// if its WAL is ahead; see pool.WithWALArbitration().
+		PrimaryArbitration string `gcfg:"primary-arbitration"`
+
+		// Whether the backends replicate from a primary, or are the nodes of a
+		// distributed database, such as CockroachDB, that all take writes; see
+		// pool.WithDistributed().
+		ClusterMode string `gcfg:"cluster-mode"`
+
+		// Log records as text or json, from log-level up, rather than lines to the
+		// standard logger; see newLogger().
+		LogFormat string `gcfg:"log-format"`
+		LogLevel  string `gcfg:"log-level"`
+		logLevel  slog.Level
+
+		// How the listeners handle the degraded modes of the pool.
+		DegradedSettings
+		degraded degradedPolicy
+
+		// How the listeners handle clients asking for TLS; nil tls passes it through.
+		TLSSettings
+		tls *tls.Config
+
+		// Whether the listeners take the PROXY protocol header, and which version is
+		// sent to backends.
+		ProxySettings
+		proxy proxyConfig
 	}
 
 	Health struct {
-		Username string
-		Password string
-		Database string
+		CheckSettings
+
+		// Parsed from CheckSettings.
+		settings pool.PostgresSettings
+
+		// Delay each check by a random duration of up to Jitter.  The checks of a
+		// backend that's down are spaced out up to CheckBackoff; see
+		// pool.WithCheckBackoff().
+		Jitter       string
+		CheckBackoff string `gcfg:"check-backoff"`
+
+		// Parsed from CheckSettings.QueryTimeout, Jitter and CheckBackoff; the pool's
+		// check timeout, for backends that don't set their own.
+		queryTimeout time.Duration
+		jitter       time.Duration
+		checkBackoff time.Duration
+
+		// How many backends are checked at once; see pool.WithCheckConcurrency().
+		CheckConcurrency int `gcfg:"check-concurrency"`
+
+		// The checks in a row that must fail to take a backend out of routing, and
+		// succeed to put it back; see pool.WithFlapThresholds().
+		DownAfter int `gcfg:"down-after"`
+		UpAfter   int `gcfg:"up-after"`
+
+		// How long a backend that's starting up, shutting down or recovering is held
+		// out of routing in its state before it's failed; see pool.WithTransientGrace().
+		TransientGrace string `gcfg:"transient-grace"`
+		transientGrace time.Duration
+
+		// The kinds of check errors that keep a backend in routing, flagged as
+		// degraded, rather than failing it; see pool.WithDegradeOn().
+		DegradeOn []string `gcfg:"degrade-on"`
+		degradeOn []pool.ErrorKind
+
+		// Check pg_stat_archiver on the primary.
+		CheckArchiver bool `gcfg:"check-archiver"`
+
+		// Write a heartbeat into this table on the primary and read it on followers.
+		HeartbeatTable  string `gcfg:"heartbeat-table"`
+		HeartbeatCreate bool   `gcfg:"heartbeat-create"`
+
+		// Publish the view of the backends into this table on the primary.
+		TopologyTable  string `gcfg:"topology-table"`
+		TopologyCreate bool   `gcfg:"topology-create"`
+
+		// Issue a fencing epoch for each primary in this table on the primary, and
+		// start the sessions of write listeners with it.
+		FencingTable  string `gcfg:"fencing-table"`
+		FencingCreate bool   `gcfg:"fencing-create"`
+
+		// Flag measurements of backends this many standard deviations above their
+		// moving average, once there are AnomalyWarmup of them; see
+		// pool.NewEWMADetector().
+		AnomalyThreshold float64 `gcfg:"anomaly-threshold"`
+		AnomalyWarmup    int     `gcfg:"anomaly-warmup"`
+
+		// Keep the outcomes of this many checks of each backend, and summarize them
+		// over each check window; see pool.WithCheckHistory().
+		CheckHistory int      `gcfg:"check-history"`
+		CheckWindow  []string `gcfg:"check-window"`
+		checkWindows []time.Duration
+
+		// Compare the result of a checksum query between the primary and followers.
+		ChecksumQuery    string `gcfg:"checksum-query"`
+		ChecksumInterval string `gcfg:"checksum-interval"`
+
+		// Parsed from ChecksumInterval.
+		checksumInterval time.Duration
 	}
-}
 
-func ConfigFromFile(filename string) (c *Config, err error) {
-	c = &Config{}
+	// Named backends, in addition to Main.Backends.  The name identifies the backend
+	// in stats and logs even if its address changes.  A backend may have several
+	// addresses, in order of preference.
+	Backend map[string]*struct {
+		Address []string
 
-	if err := gcfg.ReadFileInto(c, filename); err != nil {
-		return nil, err
+		// Overrides of the settings in [health].
+		CheckSettings
+		settings pool.PostgresSettings
+
+		// Labels attached to the backend's metrics, as name=value.
+		Label  []string
+		labels metrics.Labels
+
+		// How the backend ranks for promotion; see pool.PromotionInfo.  Priority
+		// is 1 if left out.
+		Priority    string
+		Zone        string
+		Synchronous bool
+		WriteGroup  string `gcfg:"write-group"`
+		promotion   pool.PromotionInfo
+
+		// The relative cost of routing sessions to the backend, such as of transfer
+		// across zones, for routing expressions to weigh; see routeVarTypes.
+		Cost float64
+
+		// Caps the sessions of the backend, overriding [limits]
+		// max-connections-per-backend; -1 lifts the cap.
+		MaxConnections int `gcfg:"max-connections"`
+
+		// The share of sessions balanced onto the backend; see pool.SetWeight().
+		// Weight is 1 if left out.
+		Weight string
+		weight float64
+
+		// The role the backend should have, primary or follower, and whether to stop
+		// routing writes to it while it's a primary it should never be; see
+		// pool.Expectation.
+		ExpectedRole string `gcfg:"expected-role"`
+		EnforceRole  bool   `gcfg:"enforce-role"`
+		expect       pool.Expectation
 	}
 
-	_, _, err = net.SplitHostPort(c.Main.Primary)
-	if err != nil {
-		return nil, newConfigError("Main.Primary: %s", c.Main.Primary, err)
+	Metrics struct {
+		// Labels attached to all metrics and events, as name=value.
+		Label  []string
+		labels metrics.Labels
+
+		// The statsd server measurements are pushed to, with their labels as Datadog
+		// tags, every statsd-interval; see metrics.Statsd.
+		Statsd         string
+		StatsdPrefix   string `gcfg:"statsd-prefix"`
+		StatsdInterval string `gcfg:"statsd-interval"`
+		statsdInterval time.Duration
 	}
 
-	_, _, err = net.SplitHostPort(c.Main.Follower)
-	if err != nil {
-		return nil, newConfigError("Main.Follower: %s", c.Main.Follower, err)
+	// The OpenTelemetry collector that spans of health checks, dials and connecting
+	// clients are exported to over OTLP/HTTP, as service-name, every interval; see
+	// tracing.OTLP.
+	Tracing struct {
+		Endpoint    string
+		ServiceName string `gcfg:"service-name"`
+		SampleRate  string `gcfg:"sample-rate"`
+		Interval    string
+		sampleRate  float64
+		interval    time.Duration
 	}
 
-	c.Main.Backends = strings.Split(c.Main.Backends[0], ",")
-	if len(c.Main.Backends) < 1 {
-		return nil, newConfigError("Main.Backends contains no backend definitions")
+	// Limits on arbiter's own resource usage, past which it raises an alarm; see
+	// watchdog.
+	Watchdog struct {
+		Interval            string
+		MaxGoroutines       int     `gcfg:"max-goroutines"`
+		MaxHeapBytes        int64   `gcfg:"max-heap-bytes"`
+		MaxOpenFilesPercent float64 `gcfg:"max-open-files-percent"`
+		MaxSchedulerLatency string  `gcfg:"max-scheduler-latency"`
+		FreeMemory          bool    `gcfg:"free-memory"`
+		ExitAfter           string  `gcfg:"exit-after"`
+		limits              watchdogLimits
 	}
 
-	for i := range c.Main.Backends {
-		c.Main.Backends[i] = strings.TrimSpace(c.Main.Backends[i])
-		_, _, err = net.SplitHostPort(c.Main.Backends[i])
-		if err != nil {
-			return nil, newConfigError("Invalid backend '%s': %s", c.Main.Backends[i], err)
-		}
+	// How arbiter runs as a daemon; see daemonStarted().
+	Daemon struct {
+		PIDFile string `gcfg:"pidfile"`
+		Umask   string
+		User    string
+		Group   string
+		LogFile string `gcfg:"log-file"`
+		umask   int
+
+		// Where a report is written when arbiter exits on a fatal error or a panic;
+		// see crashReport.
+		CrashReport string `gcfg:"crash-report"`
 	}
 
-	if c.Health.Username == "" {
-		return nil, newConfigError("No health-check username defined")
+	// The Vault server issuing the credentials of backends checked with a vault-path,
+	// and the file its token is read from, or else $VAULT_TOKEN; see
+	// pool.VaultCredentials.
+	Vault struct {
+		Address   string
+		TokenFile string `gcfg:"token-file"`
+
+		// The credentials of each vault-path, shared by the backends checked with it.
+		credentials map[string]*pool.VaultCredentials
 	}
 
-	if c.Health.Database == "" {
-		return nil, newConfigError("No health-check database defined")
+	// The admin HTTP API.
+	Admin struct {
+		// Hold destructive actions, such as draining backends, until another operator
+		// approves them within the TTL.
+		RequireApproval bool   `gcfg:"require-approval"`
+		ApprovalTTL     string `gcfg:"approval-ttl"`
+		approvalTTL     time.Duration
+
+		// Where sessions are captured to on request; see handleCapture().
+		CaptureDir string `gcfg:"capture-dir"`
+
+		// Serve profiles and runtime counters under /debug/; see handleDebug().
+		Debug bool
+
+		// Where the Control service of arbiter.proto is served, if anywhere; see
+		// controlServer.
+		GRPCAddress string `gcfg:"grpc-address"`
 	}
 
-	return c, nil
+	// Push state to a central collector.
+	Report struct {
+		URL      string
+		Token    string
+		Interval string
+		interval time.Duration
+	}
+
+	// Where state transitions are published for event-driven automation; see
+	// eventBus.
+	Bus struct {
+		URL    string
+		Topic  string
+		Format string
+	}
+
+	// The URL and script fired on primary changes, backends going down and
+	// recovering; see hookRunner.
+	Hooks struct {
+		URL     string
+		Token   string
+		Exec    string
+		On      []string
+		on      []string
+		Timeout string
+		timeout time.Duration
+		Retries int
+	}
+
+	// The URL and script that fence off the previous primary of [main] before writes
+	// are routed to another; see fenceHook.
+	Fence struct {
+		URL     string
+		Token   string
+		Exec    string
+		Timeout string
+		timeout time.Duration
+	}
+
+	// Where alerts are sent when pools lose their primary, split brain or run out of
+	// followers, and how often; see alerter.
+	Alerts struct {
+		SlackWebhook        string `gcfg:"slack-webhook"`
+		PagerDutyRoutingKey string `gcfg:"pagerduty-routing-key"`
+		PagerDutyURL        string `gcfg:"pagerduty-url"`
+		On                  []string
+		on                  []string
+		Cooldown            string
+		cooldown            time.Duration
+		Timeout             string
+		timeout             time.Duration
+		Retries             int
+	}
+
+	// Whether and how arbiter promotes the best follower of [main] once its primary is
+	// confirmed down, and the interlocks it's promoted behind; see promoter.
+	Promotion struct {
+		Mode     string
+		Command  string
+		Fence    string
+		Confirm  string
+		confirm  time.Duration
+		Timeout  string
+		timeout  time.Duration
+		Cooldown string
+		cooldown time.Duration
+	}
+
+	// The backend the sessions on the primary are mirrored to, and the share of them
+	// that are, from 0 to 1; see mirror.
+	Shadow struct {
+		Address string
+		Share   float64
+	}
+
+	// The Consul service backends are discovered from, and the names arbiter's
+	// listeners are registered under; see consulWatcher.
+	Consul struct {
+		Address    string
+		Token      string
+		Service    string
+		Tag        string
+		Datacenter string
+		Register   string
+		Advertise  string
+
+		// The key locked by the instance of arbiter elected to serve sessions, and the
+		// TTL of the session it holds the lock with; see election.
+		LeaderKey string `gcfg:"leader-key"`
+		LeaderTTL string `gcfg:"leader-ttl"`
+		leaderTTL time.Duration
+
+		// The prefix of the keys instances of arbiter share what they see of the
+		// backends under, with sessions of leader-ttl, and how many instances there
+		// are; see observers.
+		ObserversPrefix string `gcfg:"observers-prefix"`
+		Observers       int
+	}
+
+	// The DNS names backends are discovered from; see dnsWatcher.
+	DNS struct {
+		SRV      []string
+		Host     []string
+		Interval string
+		interval time.Duration
+	}
+
+	// The Aurora cluster whose instances are discovered as backends; see
+	// auroraWatcher.
+	Aurora struct {
+		Endpoint string
+		Interval string
+		interval time.Duration
+	}
+
+	// The servers, such as the primary, whose replication topology backends are
+	// discovered from; see replicationWatcher.
+	Replication struct {
+		Seed     []string
+		Interval string
+		interval time.Duration
+	}
+
+	// The Kubernetes Service backends are discovered from; see kubernetesWatcher.
+	Kubernetes struct {
+		Service    string
+		Namespace  string
+		Port       string
+		Kubeconfig string
+	}
+
+	// Where the history of backend states is kept; see history.
+	History struct {
+		Path      string
+		Interval  string
+		Retention string
+		interval  time.Duration
+		retention time.Duration
+	}
+
+	// Signals for autoscalers of followers; see autoscaler.
+	Autoscale struct {
+		ReplicaQPS        float64 `gcfg:"replica-qps"`
+		SaturationPercent float64 `gcfg:"saturation-percent"`
+		Webhook           string
+		Token             string
+		Interval          string
+		SlowStart         string `gcfg:"slow-start"`
+		interval          time.Duration
+		slowStart         time.Duration
+
+		// The share of the sessions of followers recycled when a follower comes
+		// online, and how many a second; see pool.WithRebalance().
+		RebalancePercent float64 `gcfg:"rebalance-percent"`
+		RebalanceRate    float64 `gcfg:"rebalance-rate"`
+	}
+
+	// Resource caps; zero means unlimited.
+	Limits struct {
+		MaxSessions      int64 `gcfg:"max-sessions"`
+		MaxBackendConns  int64 `gcfg:"max-backend-connections"`
+		MaxBackendDials  int   `gcfg:"max-backend-dials"`
+		MaxBufferedBytes int64 `gcfg:"max-buffered-bytes"`
+
+		// Caps the sessions of each backend, unless [backend] max-connections
+		// overrides it; see pool.WithMaxConnections().
+		MaxConnectionsPerBackend int `gcfg:"max-connections-per-backend"`
+
+		// How long clients may leave sessions idle, or idle in a transaction, and
+		// how often keepalives are sent to them.
+		IdleTimeout              string `gcfg:"idle-timeout"`
+		IdleInTransactionTimeout string `gcfg:"idle-in-transaction-timeout"`
+		ClientKeepAlive          string `gcfg:"client-keepalive"`
+		idleTimeout              time.Duration
+		idleInTransactionTimeout time.Duration
+		clientKeepAlive          time.Duration
+
+		// How long a session may last.
+		MaxSessionLifetime string `gcfg:"max-session-lifetime"`
+		maxSessionLifetime time.Duration
+
+		// How long the rest of a session is passed on once one side of it finishes
+		// sending.
+		Linger string
+		linger time.Duration
+
+		// How long arbiter waits for sessions to finish when it's stopped; see
+		// shutdown().
+		ShutdownTimeout string `gcfg:"shutdown-timeout"`
+		shutdownTimeout time.Duration
+
+		// Shed new clients while arbiter's own usage is above these.
+		ShedCPUPercent       float64 `gcfg:"shed-cpu-percent"`
+		ShedOpenFilesPercent float64 `gcfg:"shed-open-files-percent"`
+		ShedMemoryBytes      int64   `gcfg:"shed-memory-bytes"`
+		overload             OverloadLimits
+
+		// Sessions for the primary queued while it's absent, and for how long.
+		MaxQueuedSessions int64  `gcfg:"max-queued-sessions"`
+		QueueTimeout      string `gcfg:"queue-timeout"`
+		queueTimeout      time.Duration
+
+		// New clients admitted per second, overall and from each client address, and
+		// the clients over the overall rate that wait their turn; see connThrottle.
+		ConnectionRate           float64 `gcfg:"connection-rate"`
+		ConnectionBurst          int     `gcfg:"connection-burst"`
+		ConnectionRatePerClient  float64 `gcfg:"connection-rate-per-client"`
+		ConnectionBurstPerClient int     `gcfg:"connection-burst-per-client"`
+		AcceptQueue              int     `gcfg:"accept-queue"`
+	}
+
+	// Other clusters that listeners may route to, by name, each monitored as a pool of
+	// its own with the settings of [health] and the failover policy of [main], unless it
+	// overrides them; see startClusters().
+	Cluster map[string]*struct {
+		Backend []string
+
+		// What the backends run: postgres, or mysql for MySQL or MariaDB, which are
+		// checked with a pool.NewMySQLChecker() and proxied without being parsed; see
+		// mysqlClient.
+		Engine string
+
+		// Overrides of the settings in [health], such as the credentials to check with.
+		CheckSettings
+		settings pool.PostgresSettings
+
+		// Overrides of the settings in [main].  failover and failover-grace are taken
+		// from [main] together, unless either is given.
+		Failover           string
+		FailoverGrace      string `gcfg:"failover-grace"`
+		failover           pool.FailoverPolicy
+		PrimaryArbitration string `gcfg:"primary-arbitration"`
+		ClusterMode        string `gcfg:"cluster-mode"`
+
+		// Where the cluster's pool keeps its knowledge of the primary across restarts,
+		// as [main] failover-journal does for the backends in [main].
+		FailoverJournal string `gcfg:"failover-journal"`
+	}
+
+	// The client addresses the listeners accept sessions from, of any users or
+	// databases, or of some; see listenerACL.
+	ACL map[string]*struct {
+		Listener []string
+		Allow    []string
+		Deny     []string
+		User     string
+		Database string
+
+		// Parsed from Allow and Deny.
+		allow []*net.IPNet
+		deny  []*net.IPNet
+	}
+
+	// Sessions of some users or databases routed with another class, or to another
+	// cluster, than by their listener; see routingRule.
+	Rule map[string]*struct {
+		User     string
+		Database string
+		Class    string
+		Cluster  string
+	}
+
+	// Named consistency classes, in addition to the built-in strong and eventual.
+	Class map[string]*struct {
+		PrimaryOnly   bool   `gcfg:"primary-only"`
+		MaxLag        string `gcfg:"max-lag"`
+		FollowersOnly bool   `gcfg:"followers-only"`
+		QuorumOnly    bool   `gcfg:"quorum-only"`
+		PreferQuorum  bool   `gcfg:"prefer-quorum"`
+		Balance       string
+		WriteGroup    string `gcfg:"write-group"`
+
+		// Parsed from MaxLag and Balance.
+		maxLag  time.Duration
+		balance pool.Balance
+	}
+
+	// Additional listeners, each bound to a consistency class, of the backends in [main]
+	// or of a [cluster].
+	Listener map[string]*struct {
+		Address string
+		Class   string
+		Cluster string
+
+		// Overrides of the settings in [main].
+		DegradedSettings
+		degraded degradedPolicy
+		TLSSettings
+		tls *tls.Config
+		ProxySettings
+		proxy proxyConfig
+	}
+}
+
+func ConfigFromFile(filename string) (c *Config, err error) {
+	return LoadConfig(filename, nil, nil)
+}
+
+// LoadConfig reads the configuration file, and then applies the overrides found in
+// environ, which is in the form returned by os.Environ(), followed by those in sets,
+// which are given as section[.subsection].variable=value.
+func LoadConfig(filename string, environ []string, sets []string) (c *Config, err error) {
+	c = &Config{}
+
+	if err := gcfg.ReadFileInto(c, filename); err != nil {
+		return nil, err
+	}
+
+	fromFlags, err := flagOverrides(sets)
+	if err != nil {
+		return nil, newConfigError("%s", err)
+	}
+
+	if overrides := append(envOverrides(environ), fromFlags...); len(overrides) > 0 {
+		if err := gcfg.ReadStringInto(c, renderOverrides(overrides)); err != nil {
+			return nil, newConfigError("Invalid override: %s", err)
+		}
+	}
+
+	_, _, err = net.SplitHostPort(c.Main.Primary)
+	if err != nil {
+		return nil, newConfigError("Main.Primary: %s", err)
+	}
+
+	_, _, err = net.SplitHostPort(c.Main.Follower)
+	if err != nil {
+		return nil, newConfigError("Main.Follower: %s", err)
+	}
+
+	if err := c.bootstrap(); err != nil {
+		return nil, newConfigError("Main: %s", err)
+	}
+
+	// The backend each address is given for, so that none is monitored twice.
+	seen := make(map[string]string)
+
+	var backends []string
+	for _, addr := range strings.Split(strings.Join(c.Main.Backends, ","), ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+
+		given := addr
+		if addr, err = withPort(given, c.Health.Port); err != nil {
+			return nil, newConfigError("Invalid backend '%s': %s", given, err)
+		}
+		if _, ok := seen[addr]; ok {
+			return nil, newConfigError("Backend '%s' is given more than once", addr)
+		}
+		seen[addr] = addr
+		backends = append(backends, addr)
+	}
+	c.Main.Backends = backends
+
+	if c.Main.Fallback != "" {
+		_, _, err = net.SplitHostPort(c.Main.Fallback)
+		if err != nil {
+			return nil, newConfigError("Main.Fallback: %s", err)
+		}
+	} else if len(c.Main.Backends) == 0 && len(c.Backend) == 0 && !c.discovers() {
+		return nil, newConfigError("No backends configured, and no fallback")
+	}
+
+	for name, b := range c.Backend {
+		if len(b.Address) == 0 {
+			return nil, newConfigError("Backend %s has no address", name)
+		}
+
+		port := b.Port
+		if port == "" {
+			port = c.Health.Port
+		}
+		for i, addr := range b.Address {
+			if b.Address[i], err = withPort(addr, port); err != nil {
+				return nil, newConfigError("Invalid backend %s '%s': %s", name, addr, err)
+			}
+			addr = b.Address[i]
+			if other, ok := seen[addr]; ok {
+				return nil, newConfigError("Backend %s: address %s is also given for %s", name, addr, other)
+			}
+			seen[addr] = name
+		}
+
+		if b.labels, err = parseLabels(b.Label); err != nil {
+			return nil, newConfigError("Backend %s: %s", name, err)
+		}
+
+		if b.Cost < 0 {
+			return nil, newConfigError("Backend %s: negative cost %v", name, b.Cost)
+		}
+		if b.MaxConnections < -1 {
+			return nil, newConfigError("Backend %s: max-connections %d is neither -1 nor positive", name, b.MaxConnections)
+		}
+
+		b.promotion = pool.PromotionInfo{Priority: 1, Zone: b.Zone, Synchronous: b.Synchronous,
+			WriteGroup: b.WriteGroup}
+		if b.Priority != "" {
+			if b.promotion.Priority, err = strconv.Atoi(b.Priority); err != nil {
+				return nil, newConfigError("Backend %s: invalid priority '%s'", name, b.Priority)
+			}
+		}
+
+		b.weight = 1
+		if b.Weight != "" {
+			if b.weight, err = strconv.ParseFloat(b.Weight, 64); err != nil || b.weight < 0 {
+				return nil, newConfigError("Backend %s: invalid weight '%s'", name, b.Weight)
+			}
+		}
+
+		switch b.ExpectedRole {
+		case "":
+		case "primary":
+			b.expect.Role = pool.READ_WRITE
+		case "follower":
+			b.expect.Role = pool.READ_ONLY
+		default:
+			return nil, newConfigError("Backend %s: invalid expected-role '%s'; expected primary or follower",
+				name, b.ExpectedRole)
+		}
+		b.expect.Enforce = b.EnforceRole
+	}
+
+	if c.Metrics.labels, err = parseLabels(c.Metrics.Label); err != nil {
+		return nil, newConfigError("Metrics: %s", err)
+	}
+	c.Metrics.statsdInterval = time.Second
+	if c.Metrics.StatsdInterval != "" {
+		c.Metrics.statsdInterval, err = time.ParseDuration(c.Metrics.StatsdInterval)
+		if err != nil || c.Metrics.statsdInterval <= 0 {
+			return nil, newConfigError("Metrics.statsd-interval: expected a positive duration; got '%s'",
+				c.Metrics.StatsdInterval)
+		}
+	}
+	if c.Metrics.Statsd != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Statsd); err != nil {
+			return nil, newConfigError("Metrics.statsd: %s", err)
+		}
+	}
+
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = "arbiter"
+	}
+	c.Tracing.sampleRate = 1
+	if c.Tracing.SampleRate != "" {
+		c.Tracing.sampleRate, err = strconv.ParseFloat(c.Tracing.SampleRate, 64)
+		if err != nil || c.Tracing.sampleRate < 0 || c.Tracing.sampleRate > 1 {
+			return nil, newConfigError("Tracing.sample-rate: expected a number between 0 and 1; got '%s'",
+				c.Tracing.SampleRate)
+		}
+	}
+	c.Tracing.interval = 5 * time.Second
+	if c.Tracing.Interval != "" {
+		c.Tracing.interval, err = time.ParseDuration(c.Tracing.Interval)
+		if err != nil || c.Tracing.interval <= 0 {
+			return nil, newConfigError("Tracing.interval: expected a positive duration; got '%s'",
+				c.Tracing.Interval)
+		}
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, newConfigError("Tracing.endpoint: expected an http or https URL; got '%s'",
+				c.Tracing.Endpoint)
+		}
+	}
+
+	if c.Vault.Address != "" {
+		if u, err := url.Parse(c.Vault.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, newConfigError("Vault.address: expected an http or https URL; got '%s'", c.Vault.Address)
+		}
+	}
+
+	if c.Health.Username == "" && c.Health.VaultPath == "" {
+		return nil, newConfigError("No health-check username defined")
+	}
+
+	if c.Health.Database == "" {
+		return nil, newConfigError("No health-check database defined")
+	}
+
+	if err = checkClusterMode(c.Main.ClusterMode, c.Main.PrimaryArbitration, c.Main.MultiWriter); err != nil {
+		return nil, newConfigError("Main.%s", err)
+	}
+	distributed := c.Main.ClusterMode == clusterDistributed
+
+	if c.Health.settings, err = c.checkSettings(c.Health.CheckSettings, distributed); err != nil {
+		return nil, newConfigError("Health: %s", err)
+	}
+	c.Health.queryTimeout = c.Health.settings.QueryTimeout
+
+	for name, b := range c.Backend {
+		b.settings, err = c.checkSettings(b.CheckSettings.inherit(c.Health.CheckSettings), distributed)
+		if err != nil {
+			return nil, newConfigError("Backend %s: %s", name, err)
+		}
+		if b.settings.Patroni != "" && len(b.Address) > 1 {
+			return nil, newConfigError("Backend %s: patroni doesn't apply to a backend with several addresses", name)
+		}
+	}
+
+	c.Report.interval = 10 * time.Second
+	if c.Report.Interval != "" {
+		c.Report.interval, err = time.ParseDuration(c.Report.Interval)
+		if err != nil {
+			return nil, newConfigError("Report.interval: %s", err)
+		}
+	}
+
+	c.History.interval, c.History.retention = 10*time.Second, 30*24*time.Hour
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"interval", c.History.Interval, &c.History.interval},
+		{"retention", c.History.Retention, &c.History.retention},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, newConfigError("History.%s: %s", d.name, err)
+		}
+		if *d.dst <= 0 {
+			return nil, newConfigError("History.%s must be positive", d.name)
+		}
+	}
+
+	if c.Autoscale.ReplicaQPS < 0 {
+		return nil, newConfigError("Autoscale.replica-qps can't be negative")
+	}
+	if c.Autoscale.SaturationPercent == 0 {
+		c.Autoscale.SaturationPercent = 80
+	}
+	if c.Autoscale.SaturationPercent < 0 {
+		return nil, newConfigError("Autoscale.saturation-percent can't be negative")
+	}
+
+	c.Autoscale.interval, c.Autoscale.slowStart = 10*time.Second, 30*time.Second
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"interval", c.Autoscale.Interval, &c.Autoscale.interval},
+		{"slow-start", c.Autoscale.SlowStart, &c.Autoscale.slowStart},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, newConfigError("Autoscale.%s: %s", d.name, err)
+		}
+		if *d.dst < 0 {
+			return nil, newConfigError("Autoscale.%s can't be negative", d.name)
+		}
+	}
+	if c.Autoscale.interval == 0 {
+		return nil, newConfigError("Autoscale.interval must be positive")
+	}
+	if c.Autoscale.RebalancePercent < 0 || c.Autoscale.RebalancePercent > 100 {
+		return nil, newConfigError("Autoscale.rebalance-percent must be between 0 and 100")
+	}
+	if c.Autoscale.RebalanceRate == 0 {
+		c.Autoscale.RebalanceRate = 10
+	}
+	if c.Autoscale.RebalanceRate < 0 {
+		return nil, newConfigError("Autoscale.rebalance-rate can't be negative")
+	}
+
+	if c.Bus.URL != "" {
+		scheme, _, _ := strings.Cut(c.Bus.URL, "://")
+		switch scheme {
+		case "nats", "tls", "kafka", "kafka+tls", "kafka+http", "kafka+https":
+		default:
+			return nil, newConfigError("Bus.url: expected nats://, tls://, kafka://, kafka+tls://, kafka+http:// or kafka+https://")
+		}
+		if c.Bus.Topic == "" {
+			c.Bus.Topic = "arbiter.events"
+		}
+		switch c.Bus.Format {
+		case "":
+			c.Bus.Format = busJSON
+		case busJSON, busProtobuf:
+		default:
+			return nil, newConfigError("Bus.format: expected json or protobuf")
+		}
+	}
+
+	if c.Hooks.URL != "" && !strings.HasPrefix(c.Hooks.URL, "http://") && !strings.HasPrefix(c.Hooks.URL, "https://") {
+		return nil, newConfigError("Hooks.url: expected http:// or https://")
+	}
+	c.Hooks.on = hookKinds
+	if len(c.Hooks.On) > 0 {
+		c.Hooks.on = nil
+	}
+	for _, kind := range c.Hooks.On {
+		kind = strings.TrimSpace(kind)
+		valid := false
+		for _, known := range hookKinds {
+			valid = valid || kind == known
+		}
+		if !valid {
+			return nil, newConfigError("Hooks.on: expected %s; got '%s'", strings.Join(hookKinds, ", "), kind)
+		}
+		c.Hooks.on = append(c.Hooks.on, kind)
+	}
+	c.Hooks.timeout = 10 * time.Second
+	if c.Hooks.Timeout != "" {
+		if c.Hooks.timeout, err = time.ParseDuration(c.Hooks.Timeout); err != nil || c.Hooks.timeout <= 0 {
+			return nil, newConfigError("Hooks.timeout: expected a positive duration; got '%s'", c.Hooks.Timeout)
+		}
+	}
+	if c.Hooks.Retries < 0 {
+		return nil, newConfigError("Hooks.retries can't be negative")
+	}
+
+	if c.Fence.URL != "" && !strings.HasPrefix(c.Fence.URL, "http://") && !strings.HasPrefix(c.Fence.URL, "https://") {
+		return nil, newConfigError("Fence.url: expected http:// or https://")
+	}
+	c.Fence.timeout = 10 * time.Second
+	if c.Fence.Timeout != "" {
+		if c.Fence.timeout, err = time.ParseDuration(c.Fence.Timeout); err != nil || c.Fence.timeout <= 0 {
+			return nil, newConfigError("Fence.timeout: expected a positive duration; got '%s'", c.Fence.Timeout)
+		}
+	}
+
+	for key, url := range map[string]string{"slack-webhook": c.Alerts.SlackWebhook, "pagerduty-url": c.Alerts.PagerDutyURL} {
+		if url != "" && !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, newConfigError("Alerts.%s: expected http:// or https://", key)
+		}
+	}
+	if c.Alerts.PagerDutyURL == "" {
+		c.Alerts.PagerDutyURL = pagerDutyEventsURL
+	}
+	c.Alerts.on = alertKinds
+	if len(c.Alerts.On) > 0 {
+		c.Alerts.on = nil
+	}
+	for _, kind := range c.Alerts.On {
+		kind = strings.TrimSpace(kind)
+		valid := false
+		for _, known := range alertKinds {
+			valid = valid || kind == known
+		}
+		if !valid {
+			return nil, newConfigError("Alerts.on: expected %s; got '%s'", strings.Join(alertKinds, ", "), kind)
+		}
+		c.Alerts.on = append(c.Alerts.on, kind)
+	}
+	c.Alerts.cooldown = 15 * time.Minute
+	if c.Alerts.Cooldown != "" {
+		if c.Alerts.cooldown, err = time.ParseDuration(c.Alerts.Cooldown); err != nil || c.Alerts.cooldown < 0 {
+			return nil, newConfigError("Alerts.cooldown: expected a duration; got '%s'", c.Alerts.Cooldown)
+		}
+	}
+	c.Alerts.timeout = 10 * time.Second
+	if c.Alerts.Timeout != "" {
+		if c.Alerts.timeout, err = time.ParseDuration(c.Alerts.Timeout); err != nil || c.Alerts.timeout <= 0 {
+			return nil, newConfigError("Alerts.timeout: expected a positive duration; got '%s'", c.Alerts.Timeout)
+		}
+	}
+	if c.Alerts.Retries < 0 {
+		return nil, newConfigError("Alerts.retries can't be negative")
+	}
+
+	switch c.Promotion.Mode {
+	case "":
+		c.Promotion.Mode = promotionOff
+	case promotionOff, promotionPgPromote:
+	case promotionCommand:
+		if c.Promotion.Command == "" {
+			return nil, newConfigError("Promotion.command is required with mode = command")
+		}
+	default:
+		return nil, newConfigError("Promotion.mode: expected off, pg_promote or command; got '%s'", c.Promotion.Mode)
+	}
+	if c.Promotion.Mode != promotionOff && (c.Main.ClusterMode == clusterDistributed || c.Main.MultiWriter) {
+		return nil, newConfigError("Promotion.mode: backends of [main] are all primaries with cluster-mode = distributed or multi-writer")
+	}
+	c.Promotion.confirm = 30 * time.Second
+	if c.Promotion.Confirm != "" {
+		if c.Promotion.confirm, err = time.ParseDuration(c.Promotion.Confirm); err != nil || c.Promotion.confirm <= 0 {
+			return nil, newConfigError("Promotion.confirm: expected a positive duration; got '%s'", c.Promotion.Confirm)
+		}
+	}
+	c.Promotion.timeout = time.Minute
+	if c.Promotion.Timeout != "" {
+		if c.Promotion.timeout, err = time.ParseDuration(c.Promotion.Timeout); err != nil || c.Promotion.timeout <= 0 {
+			return nil, newConfigError("Promotion.timeout: expected a positive duration; got '%s'", c.Promotion.Timeout)
+		}
+	}
+	c.Promotion.cooldown = 10 * time.Minute
+	if c.Promotion.Cooldown != "" {
+		if c.Promotion.cooldown, err = time.ParseDuration(c.Promotion.Cooldown); err != nil || c.Promotion.cooldown < 0 {
+			return nil, newConfigError("Promotion.cooldown: expected a duration; got '%s'", c.Promotion.Cooldown)
+		}
+	}
+
+	if c.Shadow.Address != "" {
+		if _, _, err = net.SplitHostPort(c.Shadow.Address); err != nil {
+			return nil, newConfigError("Shadow.address: %s", err)
+		}
+	}
+	if c.Shadow.Share == 0 {
+		c.Shadow.Share = 1
+	}
+	if c.Shadow.Share < 0 || c.Shadow.Share > 1 {
+		return nil, newConfigError("Shadow.share must be from 0 to 1")
+	}
+
+	c.Consul.leaderTTL = 15 * time.Second
+	if c.Consul.LeaderTTL != "" {
+		// Consul takes TTLs from 10s to a day.
+		c.Consul.leaderTTL, err = time.ParseDuration(c.Consul.LeaderTTL)
+		if err != nil || c.Consul.leaderTTL < 10*time.Second || c.Consul.leaderTTL > 24*time.Hour {
+			return nil, newConfigError("Consul.leader-ttl: expected a duration from 10s to 24h; got '%s'", c.Consul.LeaderTTL)
+		}
+	}
+
+	if c.Consul.ObserversPrefix != "" && c.Consul.Observers < 1 {
+		return nil, newConfigError("Consul.observers: expected the number of instances sharing observers-prefix")
+	}
+
+	if c.Consul.Service != "" || c.Consul.Register != "" || c.Consul.LeaderKey != "" || c.Consul.ObserversPrefix != "" {
+		if c.Consul.Address == "" {
+			c.Consul.Address = "http://127.0.0.1:8500"
+		}
+		if !strings.HasPrefix(c.Consul.Address, "http://") && !strings.HasPrefix(c.Consul.Address, "https://") {
+			return nil, newConfigError("Consul.address: expected http:// or https://")
+		}
+		c.Consul.Address = strings.TrimSuffix(c.Consul.Address, "/")
+	}
+
+	c.DNS.interval = 30 * time.Second
+	if c.DNS.Interval != "" {
+		if c.DNS.interval, err = time.ParseDuration(c.DNS.Interval); err != nil || c.DNS.interval <= 0 {
+			return nil, newConfigError("DNS.interval: expected a positive duration; got '%s'", c.DNS.Interval)
+		}
+	}
+	for _, host := range c.DNS.Host {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			return nil, newConfigError("DNS.host: %s", err)
+		}
+	}
+
+	c.Aurora.interval = 30 * time.Second
+	if c.Aurora.Interval != "" {
+		if c.Aurora.interval, err = time.ParseDuration(c.Aurora.Interval); err != nil || c.Aurora.interval <= 0 {
+			return nil, newConfigError("Aurora.interval: expected a positive duration; got '%s'", c.Aurora.Interval)
+		}
+	}
+	if c.Aurora.Endpoint != "" {
+		if _, _, _, _, err := auroraEndpoints(c.Aurora.Endpoint); err != nil {
+			return nil, newConfigError("Aurora.endpoint: %s", err)
+		}
+	}
+
+	c.Replication.interval = 30 * time.Second
+	if c.Replication.Interval != "" {
+		if c.Replication.interval, err = time.ParseDuration(c.Replication.Interval); err != nil || c.Replication.interval <= 0 {
+			return nil, newConfigError("Replication.interval: expected a positive duration; got '%s'", c.Replication.Interval)
+		}
+	}
+	for _, seed := range c.Replication.Seed {
+		if _, _, err := net.SplitHostPort(seed); err != nil {
+			return nil, newConfigError("Replication.seed: %s", err)
+		}
+	}
+
+	if !c.Main.MultiWriter && c.Main.WriteGroup != "" {
+		return nil, newConfigError("Main.write-group requires multi-writer")
+	}
+	for name, b := range c.Backend {
+		if !c.Main.MultiWriter && b.WriteGroup != "" {
+			return nil, newConfigError("Backend %s: write-group requires multi-writer in [main]", name)
+		}
+	}
+	for name, class := range c.Class {
+		if class.WriteGroup == "" {
+			continue
+		}
+		if !c.Main.MultiWriter {
+			return nil, newConfigError("Class %s: write-group requires multi-writer in [main]", name)
+		}
+		if !class.PrimaryOnly || class.FollowersOnly {
+			return nil, newConfigError("Class %s: write-group requires primary-only", name)
+		}
+	}
+
+	if c.Main.LatencyBandPercent < 0 {
+		return nil, newConfigError("Main.latency-band-percent can't be negative")
+	}
+
+	if c.Main.LatencySmoothing == 0 {
+		c.Main.LatencySmoothing = 0.3
+	}
+	if c.Main.LatencySmoothing < 0 || c.Main.LatencySmoothing > 1 {
+		return nil, newConfigError("Main.latency-smoothing must be between 0 and 1")
+	}
+	if c.Main.LatencyWindow < 0 {
+		return nil, newConfigError("Main.latency-window can't be negative")
+	}
+
+	if c.Main.LatencyBand != "" {
+		c.Main.latencyBand, err = time.ParseDuration(c.Main.LatencyBand)
+		if err != nil {
+			return nil, newConfigError("Main.latency-band: %s", err)
+		}
+	}
+
+	if c.Main.MaxLag != "" {
+		if c.Main.maxLag, err = time.ParseDuration(c.Main.MaxLag); err != nil {
+			return nil, newConfigError("Main.max-lag: %s", err)
+		}
+	}
+
+	if c.Main.failover, err = failoverPolicy(c.Main.Failover, c.Main.FailoverGrace); err != nil {
+		return nil, newConfigError("Main.%s", err)
+	}
+	if err = checkArbitration(c.Main.PrimaryArbitration, c.Main.MultiWriter); err != nil {
+		return nil, newConfigError("Main.%s", err)
+	}
+
+	if c.Main.RouteIf != "" {
+		if c.Main.routeIf, err = compileExpr(c.Main.RouteIf, routeVarTypes); err == nil && c.Main.routeIf.typ != exprBool {
+			err = fmt.Errorf("expected a bool, not a %s", c.Main.routeIf.typ)
+		}
+		if err != nil {
+			return nil, newConfigError("Main.route-if: %s", err)
+		}
+	}
+
+	if c.Main.RouteScore != "" {
+		if c.Main.routeScore, err = compileExpr(c.Main.RouteScore, routeVarTypes); err == nil && c.Main.routeScore.typ != exprNumber {
+			err = fmt.Errorf("expected a number, not a %s", c.Main.routeScore.typ)
+		}
+		if err != nil {
+			return nil, newConfigError("Main.route-score: %s", err)
+		}
+	}
+
+	if c.Main.clientZones, err = parseClientZones(c.Main.ClientZone); err != nil {
+		return nil, newConfigError("Main.client-zone: %s", err)
+	}
+
+	switch c.Main.StickyReads {
+	case "":
+	case stickyClient, stickyUser:
+		if c.Main.StickyTTL == "" {
+			c.Main.StickyTTL = "5m"
+		}
+		if c.Main.stickyTTL, err = time.ParseDuration(c.Main.StickyTTL); err != nil {
+			return nil, newConfigError("Main.sticky-ttl: %s", err)
+		}
+		if c.Main.stickyTTL <= 0 {
+			return nil, newConfigError("Main.sticky-ttl must be positive")
+		}
+	default:
+		return nil, newConfigError("Main.sticky-reads: expected %s or %s, not %q", stickyClient, stickyUser,
+			c.Main.StickyReads)
+	}
+
+	switch c.Main.LogFormat {
+	case "", logText, logJSON:
+	default:
+		return nil, newConfigError("Main.log-format: expected %s or %s; got '%s'", logText, logJSON, c.Main.LogFormat)
+	}
+	if c.Main.LogLevel != "" {
+		if err := c.Main.logLevel.UnmarshalText([]byte(c.Main.LogLevel)); err != nil {
+			return nil, newConfigError("Main.log-level: %s", err)
+		}
+	}
+
+	if c.Main.Scorer != "" && (c.Main.RouteIf != "" || c.Main.RouteScore != "") {
+		return nil, newConfigError("Main: scorer can't be combined with route-if or route-score")
+	}
+
+	w := &c.Watchdog
+	w.limits = watchdogLimits{
+		interval:         10 * time.Second,
+		goroutines:       w.MaxGoroutines,
+		heapBytes:        w.MaxHeapBytes,
+		openFilesPercent: w.MaxOpenFilesPercent,
+		freeMemory:       w.FreeMemory,
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"interval", w.Interval, &w.limits.interval},
+		{"max-scheduler-latency", w.MaxSchedulerLatency, &w.limits.schedulerLatency},
+		{"exit-after", w.ExitAfter, &w.limits.exitAfter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.value); err != nil || *d.dst < 0 {
+			return nil, newConfigError("Watchdog.%s: expected a duration; got '%s'", d.name, d.value)
+		}
+	}
+	if w.limits.interval <= 0 {
+		return nil, newConfigError("Watchdog.interval must be positive")
+	}
+	if w.MaxGoroutines < 0 || w.MaxHeapBytes < 0 || w.MaxOpenFilesPercent < 0 {
+		return nil, newConfigError("Watchdog: limits can't be negative")
+	}
+
+	c.Daemon.umask = -1
+	if c.Daemon.Umask != "" {
+		mask, err := strconv.ParseUint(c.Daemon.Umask, 8, 32)
+		if err != nil || mask > 0777 {
+			return nil, newConfigError("Daemon.umask: expected an octal mode such as 027; got '%s'", c.Daemon.Umask)
+		}
+		c.Daemon.umask = int(mask)
+	}
+	if runtime.GOOS == "windows" && (c.Daemon.Umask != "" || c.Daemon.User != "" || c.Daemon.Group != "") {
+		return nil, newConfigError("Daemon: umask, user and group aren't supported on Windows")
+	}
+
+	if c.Admin.GRPCAddress != "" {
+		if _, _, err = net.SplitHostPort(c.Admin.GRPCAddress); err != nil {
+			return nil, newConfigError("Admin.grpc-address: %s", err)
+		}
+	}
+
+	c.Admin.approvalTTL = 15 * time.Minute
+	if c.Admin.ApprovalTTL != "" {
+		c.Admin.approvalTTL, err = time.ParseDuration(c.Admin.ApprovalTTL)
+		if err != nil {
+			return nil, newConfigError("Admin.approval-ttl: %s", err)
+		}
+	}
+
+	if c.Limits.MaxConnectionsPerBackend < 0 {
+		return nil, newConfigError("Limits.max-connections-per-backend: negative %d", c.Limits.MaxConnectionsPerBackend)
+	}
+
+	if c.Limits.ConnectionBurst, err = connectionBurst("", c.Limits.ConnectionRate, c.Limits.ConnectionBurst); err != nil {
+		return nil, newConfigError("Limits.%s", err)
+	}
+	if c.Limits.ConnectionBurstPerClient, err = connectionBurst("-per-client", c.Limits.ConnectionRatePerClient, c.Limits.ConnectionBurstPerClient); err != nil {
+		return nil, newConfigError("Limits.%s", err)
+	}
+	if c.Limits.AcceptQueue < 0 {
+		return nil, newConfigError("Limits.accept-queue: negative %d", c.Limits.AcceptQueue)
+	}
+
+	c.Limits.queueTimeout = 10 * time.Second
+	if c.Limits.QueueTimeout != "" {
+		c.Limits.queueTimeout, err = time.ParseDuration(c.Limits.QueueTimeout)
+		if err != nil {
+			return nil, newConfigError("Limits.queue-timeout: %s", err)
+		}
+	}
+
+	c.Limits.linger = 5 * time.Second
+	c.Limits.shutdownTimeout = 30 * time.Second
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"idle-timeout", c.Limits.IdleTimeout, &c.Limits.idleTimeout},
+		{"idle-in-transaction-timeout", c.Limits.IdleInTransactionTimeout, &c.Limits.idleInTransactionTimeout},
+		{"client-keepalive", c.Limits.ClientKeepAlive, &c.Limits.clientKeepAlive},
+		{"max-session-lifetime", c.Limits.MaxSessionLifetime, &c.Limits.maxSessionLifetime},
+		{"linger", c.Limits.Linger, &c.Limits.linger},
+		{"shutdown-timeout", c.Limits.ShutdownTimeout, &c.Limits.shutdownTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, newConfigError("Limits.%s: %s", d.name, err)
+		}
+	}
+
+	c.Limits.overload = OverloadLimits{
+		CPUPercent:       c.Limits.ShedCPUPercent,
+		OpenFilesPercent: c.Limits.ShedOpenFilesPercent,
+		MemoryBytes:      c.Limits.ShedMemoryBytes,
+	}
+
+	c.Health.checkBackoff = 30 * time.Second
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"jitter", c.Health.Jitter, &c.Health.jitter},
+		{"check-backoff", c.Health.CheckBackoff, &c.Health.checkBackoff},
+		{"transient-grace", c.Health.TransientGrace, &c.Health.transientGrace},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			return nil, newConfigError("Health.%s: %s", d.name, err)
+		}
+	}
+
+	if c.Health.CheckConcurrency < 0 {
+		return nil, newConfigError("Health.check-concurrency: expected a positive number; got %d", c.Health.CheckConcurrency)
+	}
+
+	if c.Health.DownAfter < 0 || c.Health.UpAfter < 0 {
+		return nil, newConfigError("Health: down-after and up-after can't be negative")
+	}
+
+	for _, kind := range c.Health.DegradeOn {
+		k := pool.ErrorKind(strings.TrimSpace(kind))
+		valid := false
+		for _, known := range pool.ErrorKinds {
+			valid = valid || k == known
+		}
+		if !valid {
+			return nil, newConfigError("Health.degrade-on: unknown kind of error '%s'", kind)
+		}
+		c.Health.degradeOn = append(c.Health.degradeOn, k)
+	}
+
+	if c.Health.AnomalyThreshold < 0 {
+		return nil, newConfigError("Health.anomaly-threshold can't be negative")
+	}
+	if c.Health.AnomalyWarmup == 0 {
+		c.Health.AnomalyWarmup = 30
+	}
+	if c.Health.AnomalyWarmup < 0 {
+		return nil, newConfigError("Health.anomaly-warmup can't be negative")
+	}
+
+	if c.Health.CheckHistory == 0 {
+		c.Health.CheckHistory = 3600
+	}
+	if c.Health.CheckHistory < 0 {
+		return nil, newConfigError("Health.check-history can't be negative")
+	}
+	if len(c.Health.CheckWindow) == 0 {
+		c.Health.CheckWindow = []string{"1m", "5m", "1h"}
+	}
+	for _, s := range c.Health.CheckWindow {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, newConfigError("Health.check-window: invalid duration '%s'", s)
+		}
+		c.Health.checkWindows = append(c.Health.checkWindows, d)
+	}
+
+	c.Health.checksumInterval = time.Minute
+	if c.Health.ChecksumInterval != "" {
+		c.Health.checksumInterval, err = time.ParseDuration(c.Health.ChecksumInterval)
+		if err != nil {
+			return nil, newConfigError("Health.checksum-interval: %s", err)
+		}
+	}
+
+	for name, class := range c.Class {
+		if class.balance, err = parseBalance(class.Balance); err != nil {
+			return nil, newConfigError("Class %s: %s", name, err)
+		}
+
+		if class.MaxLag == "" {
+			continue
+		}
+
+		class.maxLag, err = time.ParseDuration(class.MaxLag)
+		if err != nil {
+			return nil, newConfigError("Class %s: invalid max-lag: %s", name, err)
+		}
+	}
+
+	// Sessions are queued while the primary is absent if queueing is enabled.
+	defaults := DegradedSettings{
+		NoPrimary:   behaviorReject,
+		NoReplicas:  behaviorFallback,
+		StaleView:   behaviorFallback,
+		Saturated:   behaviorReject,
+		FewReplicas: behaviorFallback,
+		ShedReads:   0.5,
+	}
+	if c.Limits.MaxQueuedSessions > 0 {
+		defaults.NoPrimary = behaviorQueue
+		defaults.Saturated = behaviorQueue
+	}
+
+	listenerDefaults := c.Main.DegradedSettings.inherit(defaults)
+	if c.Main.degraded, err = listenerDefaults.policy(); err != nil {
+		return nil, newConfigError("Main: %s", err)
+	}
+
+	if c.Main.tls, err = c.Main.TLSSettings.config(); err != nil {
+		return nil, newConfigError("Main: %s", err)
+	}
+	if c.Main.proxy, err = c.Main.ProxySettings.config(); err != nil {
+		return nil, newConfigError("Main: %s", err)
+	}
+
+	for name, l := range c.Listener {
+		if l.degraded, err = l.DegradedSettings.inherit(listenerDefaults).policy(); err != nil {
+			return nil, newConfigError("Listener %s: %s", name, err)
+		}
+		if l.tls, err = l.TLSSettings.inherit(c.Main.TLSSettings).config(); err != nil {
+			return nil, newConfigError("Listener %s: %s", name, err)
+		}
+		if l.proxy, err = l.ProxySettings.inherit(c.Main.ProxySettings).config(); err != nil {
+			return nil, newConfigError("Listener %s: %s", name, err)
+		}
+
+		_, _, err = net.SplitHostPort(l.Address)
+		if err != nil {
+			return nil, newConfigError("Listener %s: %s", name, err)
+		}
+
+		if _, ok := c.Class[l.Class]; !ok && l.Class != "strong" && l.Class != "eventual" {
+			return nil, newConfigError("Listener %s: unknown class '%s'", name, l.Class)
+		}
+
+		if _, ok := c.Cluster[l.Cluster]; !ok && l.Cluster != "" {
+			return nil, newConfigError("Listener %s: unknown cluster '%s'", name, l.Cluster)
+		}
+		// MySQL clients ask for TLS within the protocol, so it's passed through.
+		if c.mysql(l.Cluster) && l.tls != nil {
+			return nil, newConfigError("Listener %s: tls doesn't apply to a listener of a MySQL cluster", name)
+		}
+	}
+
+	for name, a := range c.ACL {
+		if len(a.Allow) == 0 && len(a.Deny) == 0 {
+			return nil, newConfigError("ACL %s: allow or deny is required", name)
+		}
+		for _, l := range a.Listener {
+			if _, ok := c.Listener[l]; !ok && l != "primary" && l != "follower" {
+				return nil, newConfigError("ACL %s: unknown listener '%s'", name, l)
+			}
+		}
+		if a.allow, err = parseCIDRs(a.Allow); err != nil {
+			return nil, newConfigError("ACL %s: allow: %s", name, err)
+		}
+		if a.deny, err = parseCIDRs(a.Deny); err != nil {
+			return nil, newConfigError("ACL %s: deny: %s", name, err)
+		}
+	}
+
+	for name, r := range c.Rule {
+		if r.User == "" && r.Database == "" {
+			return nil, newConfigError("Rule %s: user or database is required", name)
+		}
+		if r.Class == "" && r.Cluster == "" {
+			return nil, newConfigError("Rule %s: class or cluster is required", name)
+		}
+		if _, ok := c.Class[r.Class]; !ok && r.Class != "" && r.Class != "strong" && r.Class != "eventual" {
+			return nil, newConfigError("Rule %s: unknown class '%s'", name, r.Class)
+		}
+		if _, ok := c.Cluster[r.Cluster]; !ok && r.Cluster != "" {
+			return nil, newConfigError("Rule %s: unknown cluster '%s'", name, r.Cluster)
+		}
+		if c.mysql(r.Cluster) {
+			return nil, newConfigError("Rule %s: cluster %s is a MySQL cluster, which Postgres sessions can't be routed to", name, r.Cluster)
+		}
+	}
+
+	for name, cl := range c.Cluster {
+		if len(cl.Backend) == 0 {
+			return nil, newConfigError("Cluster %s: no backend", name)
+		}
+		for _, addr := range cl.Backend {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return nil, newConfigError("Cluster %s: %s", name, err)
+			}
+		}
+
+		switch cl.Engine {
+		case "", enginePostgres:
+		case engineMySQL:
+			if cl.Patroni != "" {
+				return nil, newConfigError("Cluster %s: patroni doesn't apply to a MySQL cluster", name)
+			}
+			if cl.ClusterMode == clusterDistributed {
+				return nil, newConfigError("Cluster %s: cluster-mode %s doesn't apply to a MySQL cluster", name, cl.ClusterMode)
+			}
+		default:
+			return nil, newConfigError("Cluster %s: invalid engine '%s'; expected %s or %s", name, cl.Engine, enginePostgres, engineMySQL)
+		}
+
+		if cl.ClusterMode == "" {
+			cl.ClusterMode = c.Main.ClusterMode
+		}
+		distributed := cl.ClusterMode == clusterDistributed
+		if cl.settings, err = c.checkSettings(cl.CheckSettings.inherit(c.Health.CheckSettings), distributed); err != nil {
+			return nil, newConfigError("Cluster %s: %s", name, err)
+		}
+
+		cl.failover = c.Main.failover
+		if cl.Failover != "" || cl.FailoverGrace != "" {
+			if cl.failover, err = failoverPolicy(cl.Failover, cl.FailoverGrace); err != nil {
+				return nil, newConfigError("Cluster %s: %s", name, err)
+			}
+		}
+		if cl.PrimaryArbitration == "" {
+			cl.PrimaryArbitration = c.Main.PrimaryArbitration
+		}
+		if err = checkArbitration(cl.PrimaryArbitration, c.Main.MultiWriter); err != nil {
+			return nil, newConfigError("Cluster %s: %s", name, err)
+		}
+		if err = checkClusterMode(cl.ClusterMode, cl.PrimaryArbitration, c.Main.MultiWriter); err != nil {
+			return nil, newConfigError("Cluster %s: %s", name, err)
+		}
+
+		if cl.FailoverJournal != "" && cl.FailoverJournal == c.Main.FailoverJournal {
+			return nil, newConfigError("Cluster %s: failover-journal is that of [main]", name)
+		}
+	}
+
+	return c, nil
+}
+
+// Whether the named cluster is of MySQL or MariaDB servers.
+func (c *Config) mysql(cluster string) bool {
+	cl, ok := c.Cluster[cluster]
+	return ok && cl.Engine == engineMySQL
+}
+
+// Whether backends are discovered from a service registry or DNS, in which case none
+// need be configured.
+func (c *Config) discovers() bool {
+	return c.Consul.Service != "" || c.Kubernetes.Service != "" || len(c.DNS.SRV) > 0 || len(c.DNS.Host) > 0 ||
+		c.Aurora.Endpoint != "" || len(c.Replication.Seed) > 0
+}
+
+// Add the backends given by Main.ConnString or Main.Service to Main.Backends, taking the
+// settings [health] leaves out from it.
+func (c *Config) bootstrap() error {
+	if c.Main.Service != "" {
+		if c.Main.ConnString != "" {
+			return fmt.Errorf("connstring and service are mutually exclusive")
+		}
+
+		file := c.Main.ServiceFile
+		if file == "" {
+			file = os.Getenv("PGSERVICEFILE")
+		}
+		if file == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			file = filepath.Join(home, ".pg_service.conf")
+		}
+
+		var err error
+		if c.Main.ConnString, err = pool.LookupService(file, c.Main.Service); err != nil {
+			return err
+		}
+	}
+
+	if c.Main.ConnString == "" {
+		return nil
+	}
+
+	addrs, ps, err := pool.ParseConnString(c.Main.ConnString)
+	if err != nil {
+		return fmt.Errorf("connstring: %s", err)
+	}
+	c.Main.Backends = append(c.Main.Backends, addrs...)
+
+	c.Health.CheckSettings = c.Health.CheckSettings.inherit(CheckSettings{
+		Username: ps.User,
+		Password: ps.Password,
+		Database: ps.Database,
+		Passfile: ps.Passfile,
+
+		SSLMode:     ps.SSLMode,
+		SSLRootCert: ps.SSLRootCert,
+		SSLCert:     ps.SSLCert,
+		SSLKey:      ps.SSLKey,
+	})
+	// Monitoring a dedicated database, the one of the connection string is still
+	// checked.
+	if ps.Database != "" && c.Health.Database != ps.Database && len(c.Health.RoutingDatabase) == 0 {
+		c.Health.RoutingDatabase = []string{ps.Database}
+	}
+	if c.Health.Timeout == "" && ps.ConnectTimeout > 0 {
+		c.Health.Timeout = ps.ConnectTimeout.String()
+	}
+
+	return nil
+}
+
+// Parse addresses in CIDR notation, such as 10.0.0.0/8, or single IP addresses.
+func parseCIDRs(addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if ip := net.ParseIP(addr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s'", addr)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// Validate a connection rate of [limits] and its burst, the keys of which end in
+// suffix, returning the burst, which is the rate rounded up by default.
+func connectionBurst(suffix string, rate float64, burst int) (int, error) {
+	switch {
+	case rate < 0:
+		return 0, fmt.Errorf("connection-rate%s: negative %g", suffix, rate)
+	case burst < 0:
+		return 0, fmt.Errorf("connection-burst%s: negative %d", suffix, burst)
+	case burst == 0 && rate > 0:
+		burst = int(math.Ceil(rate))
+	}
+
+	return burst, nil
+}
+
+// Resolve the failover and failover-grace settings of [main] or of a [cluster] to a
+// failover policy.
+func failoverPolicy(failover, grace string) (fp pool.FailoverPolicy, err error) {
+	switch failover {
+	case "", failoverSever:
+		if grace != "" {
+			return fp, fmt.Errorf("failover-grace requires failover = %s", failoverDrain)
+		}
+	case failoverDrain:
+		fp.Drain = true
+		if grace != "" {
+			if fp.Grace, err = time.ParseDuration(grace); err != nil {
+				return fp, fmt.Errorf("failover-grace: %s", err)
+			}
+			if fp.Grace < 0 {
+				return fp, fmt.Errorf("failover-grace can't be negative")
+			}
+		}
+	default:
+		return fp, fmt.Errorf("failover: expected %s or %s; got '%s'", failoverSever, failoverDrain, failover)
+	}
+
+	return fp, nil
+}
+
+// Check the primary-arbitration setting of [main] or of a [cluster].
+func checkArbitration(arbitration string, multiWriter bool) error {
+	switch arbitration {
+	case "", arbitrationLatest:
+	case arbitrationWAL:
+		if multiWriter {
+			return fmt.Errorf("primary-arbitration = %s doesn't apply with multi-writer", arbitrationWAL)
+		}
+	default:
+		return fmt.Errorf("primary-arbitration: expected %s or %s; got '%s'", arbitrationLatest, arbitrationWAL, arbitration)
+	}
+
+	return nil
+}
+
+// Check the cluster-mode setting of [main] or of a [cluster], whose backends have no
+// primary to arbitrate, nor write groups, when they're distributed.
+func checkClusterMode(mode, arbitration string, multiWriter bool) error {
+	switch mode {
+	case "", clusterReplication:
+	case clusterDistributed:
+		if multiWriter {
+			return fmt.Errorf("cluster-mode = %s doesn't apply with multi-writer", clusterDistributed)
+		}
+		if arbitration == arbitrationWAL {
+			return fmt.Errorf("primary-arbitration = %s doesn't apply with cluster-mode = %s", arbitrationWAL, clusterDistributed)
+		}
+	default:
+		return fmt.Errorf("cluster-mode: expected %s or %s; got '%s'", clusterReplication, clusterDistributed, mode)
+	}
+
+	return nil
+}
+
+// Parse the balance setting of a class: round-robin, least-latency,
+// least-connections, or empty for the pool's strategy.
+func parseBalance(s string) (pool.Balance, error) {
+	switch s {
+	case "":
+		return pool.BalanceDefault, nil
+	case "round-robin":
+		return pool.BalanceRoundRobin, nil
+	case "least-latency":
+		return pool.BalanceLeastLatency, nil
+	case "least-connections":
+		return pool.BalanceLeastConnections, nil
+	}
+
+	return pool.BalanceDefault, fmt.Errorf("invalid balance '%s'; expected round-robin, least-latency or least-connections", s)
 }
@@ -1,8 +1,13 @@
-package main
+package arbiter
 
 import (
+	"github.com/solvip/arbiter/pool"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigFromFile(t *testing.T) {
@@ -15,8 +20,536 @@ func TestConfigFromFile(t *testing.T) {
 		t.Errorf("Expected ConfigFromFile to return nil config in error cases; instead got %v", c)
 	}
 
-	_, err = ConfigFromFile("./config.ini")
+	c, err = ConfigFromFile("./config.ini")
 	if err != nil {
-		t.Errorf("Expected config.ini to be successfully parsed; instead got %v", err)
+		t.Fatalf("Expected config.ini to be successfully parsed; instead got %v", err)
+	}
+
+	if b := c.Backend["pg3"]; b == nil || b.settings.CheckInterval != 5*time.Second || b.settings.User != "arbiter" {
+		t.Errorf("Expected pg3 to override the interval and inherit the username; instead got %+v", b)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"health.secondary-password=rotated"})
+	if b := c.Backend["pg3"]; err != nil || b == nil || b.settings.SecondaryPassword != "rotated" {
+		t.Errorf("Expected pg3 to inherit the secondary password; instead got %+v, %v", b, err)
+	}
+
+	if a := c.Autoscale; a.SaturationPercent != 80 || a.interval != 10*time.Second || a.slowStart != 30*time.Second {
+		t.Errorf("Expected the autoscaling defaults; instead got %+v", a)
+	}
+
+	if c.Limits.linger != 5*time.Second {
+		t.Errorf("Expected sessions to linger for 5s by default; instead got %s", c.Limits.linger)
+	}
+	if c.Limits.shutdownTimeout != 30*time.Second {
+		t.Errorf("Expected a shutdown timeout of 30s by default; instead got %s", c.Limits.shutdownTimeout)
+	}
+
+	if class := c.Class["bounded-1s"]; class == nil || class.maxLag != time.Second {
+		t.Errorf("Expected the bounded-1s class to have a max-lag of 1s; instead got %v", class)
+	}
+
+	if c, err := LoadConfig("./config.ini", nil, []string{"main.max-lag=5s"}); err != nil || c.Main.maxLag != 5*time.Second {
+		t.Errorf("Expected a max-lag of 5s; instead got %v", err)
+	}
+
+	if l := c.Listener["bounded"]; l == nil || l.degraded.behavior[pool.NO_REPLICAS] != "reject" || l.degraded.behavior[pool.NO_PRIMARY] != "queue" {
+		t.Errorf("Expected the bounded listener to reject without replicas and inherit queueing; instead got %+v", l)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"limits.connection-rate=2.5", "limits.connection-rate-per-client=10", "limits.connection-burst-per-client=20"})
+	if err != nil || c.Limits.ConnectionBurst != 3 || c.Limits.ConnectionBurstPerClient != 20 {
+		t.Errorf("Expected the burst to default to the rate rounded up; instead got %+v, %v", c.Limits, err)
+	}
+	if _, err := LoadConfig("./config.ini", nil, []string{"limits.connection-rate-per-client=-1"}); err == nil {
+		t.Errorf("Expected a negative connection rate to be rejected")
+	}
+
+	if c.Health.queryTimeout != 10*time.Second || c.Backend["pg3"].settings.QueryTimeout != 10*time.Second {
+		t.Errorf("Expected a query timeout of 10s by default; instead got %s", c.Health.queryTimeout)
+	}
+	c, err = LoadConfig("./config.ini", nil, []string{"health.query-timeout=2s", "backend.pg3.query-timeout=30s"})
+	if err != nil || c.Health.queryTimeout != 2*time.Second || c.Backend["pg3"].settings.QueryTimeout != 30*time.Second {
+		t.Errorf("Expected pg3 to override the query timeout; instead got %v", err)
+	}
+	if _, err := LoadConfig("./config.ini", nil, []string{"backend.pg3.query-timeout=-1s"}); err == nil {
+		t.Errorf("Expected a negative query timeout to be rejected")
+	}
+
+	if c.Tracing.ServiceName != "arbiter" || c.Tracing.sampleRate != 1 || c.Tracing.interval != 5*time.Second {
+		t.Errorf("Expected the tracing defaults; instead got %+v", c.Tracing)
+	}
+	for _, set := range []string{"tracing.sample-rate=1.5", "tracing.interval=0s", "tracing.endpoint=127.0.0.1:4318"} {
+		if _, err := LoadConfig("./config.ini", nil, []string{set}); err == nil {
+			t.Errorf("Expected %s to be rejected", set)
+		}
+	}
+}
+
+func TestPasswordSources(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("p@ss:w/rd\nignored\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ARBITER_TEST_PASSWORD", "from-env")
+
+	c, err := LoadConfig("./config.ini", nil, []string{"backend.pg3.password-file=" + file,
+		"backend.pg3.passfile=/etc/arbiter/pgpass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Backend["pg3"].settings; s.Password != "p@ss:w/rd" || s.Passfile != "/etc/arbiter/pgpass" {
+		t.Errorf("Expected pg3's password from its file; instead got %+v", s)
+	}
+	if c.Health.settings.Password != "arbiter" || c.Health.settings.Passfile != "" {
+		t.Errorf("Expected [health] to keep its own password; instead got %+v", c.Health.settings)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"health.password=", "health.password-env=ARBITER_TEST_PASSWORD"})
+	if err != nil || c.Health.settings.Password != "from-env" || c.Backend["pg3"].settings.Password != "from-env" {
+		t.Errorf("Expected the password from the environment to be inherited; instead got %v", err)
+	}
+
+	for _, sets := range [][]string{
+		{"health.password-env=ARBITER_TEST_PASSWORD"},
+		{"health.password=", "health.password-env=ARBITER_TEST_UNSET"},
+		{"backend.pg3.password-file=/nonexistent"},
+	} {
+		if _, err := LoadConfig("./config.ini", nil, sets); err == nil {
+			t.Errorf("Expected %v to be rejected", sets)
+		}
+	}
+}
+
+func TestBackendNames(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{"backend.pg4.address=db4.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Backend["pg3"].Address, []string{"10.0.0.3:5432", "203.0.113.3:5432"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected pg3 under its name at its addresses; instead got %v", got)
+	}
+	if got, want := c.Backend["pg4"].Address, []string{"db4.internal:5432"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected pg4 under its name at its address; instead got %v", got)
+	}
+
+	// A name must have an address, and an address only one name.
+	for _, sets := range [][]string{
+		{"backend.pg4.username=app"},
+		{"backend.pg4.address=10.0.0.3:5432"},
+	} {
+		if _, err := LoadConfig("./config.ini", nil, sets); err == nil {
+			t.Errorf("Expected %v to be rejected", sets)
+		}
+	}
+}
+
+func TestPerBackendConnection(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{"main.backends=10.0.0.1, 10.0.0.2:5433",
+		"health.port=6432", "health.option=application_name=arbiter",
+		"backend.pg3.address=10.0.0.9", "backend.pg3.port=5434", "backend.pg3.username=standby_monitor",
+		"backend.pg3.option=statement_timeout=5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := c.Main.Backends, []string{"10.0.0.1:6432", "10.0.0.2:5433"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected addresses without a port to get [health]'s; instead got %v", got)
+	}
+	pg3 := c.Backend["pg3"]
+	if got, want := pg3.Address, []string{"10.0.0.9:5434"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected pg3's address to get its own port; instead got %v", got)
+	}
+	want := map[string]string{"statement_timeout": "5s"}
+	if pg3.settings.User != "standby_monitor" || !reflect.DeepEqual(pg3.settings.Options, want) {
+		t.Errorf("Expected pg3's own user and options; instead got %+v", pg3.settings)
+	}
+	if got := c.Health.settings.Options["application_name"]; got != "arbiter" {
+		t.Errorf("Expected [health]'s options; instead got %v", c.Health.settings.Options)
+	}
+
+	for _, set := range []string{"health.option=sslmode=require", "health.option=noequals", "backend.pg3.port=http"} {
+		if _, err := LoadConfig("./config.ini", nil, []string{set}); err == nil {
+			t.Errorf("Expected %s to be rejected", set)
+		}
+	}
+}
+
+func TestVaultCredentials(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(token, []byte("s.token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig("./config.ini", nil, []string{"vault.address=https://vault.example.com:8200",
+		"vault.token-file=" + token, "health.vault-path=database/creds/arbiter",
+		"backend.pg3.vault-path=database/creds/pg3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, pg3 := c.Health.settings.Credentials, c.Backend["pg3"].settings.Credentials
+	if health == nil || pg3 == nil || health == pg3 || len(c.Vault.credentials) != 2 {
+		t.Errorf("Expected a provider of credentials for each vault-path; instead got %v, %v", health, pg3)
+	}
+	if got, err := c.vaultToken(); err != nil || got != "s.token" {
+		t.Errorf("Expected the token from its file; instead got '%s', %v", got, err)
+	}
+
+	for _, sets := range [][]string{
+		{"health.vault-path=database/creds/arbiter"},
+		{"vault.address=vault.example.com:8200"},
+	} {
+		if _, err := LoadConfig("./config.ini", nil, sets); err == nil {
+			t.Errorf("Expected %v to be rejected", sets)
+		}
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	environ := []string{
+		"ARBITER_HEALTH_PASSWORD=from-env",
+		"ARBITER_HEALTH_USERNAME=from-env",
+		"ARBITER_BACKEND_PG3_ADDRESS=10.0.0.4:5432, 10.0.0.5:5432",
+		"PATH=/bin",
+	}
+	sets := []string{"health.username=from-flag", "limits.max-sessions=5"}
+
+	c, err := LoadConfig("./config.ini", environ, sets)
+	if err != nil {
+		t.Fatalf("Expected config.ini to be successfully parsed; instead got %v", err)
+	}
+
+	if c.Health.Password != "from-env" || c.Health.Username != "from-flag" {
+		t.Errorf("Expected flags to take precedence over the environment; instead got %+v", c.Health)
+	}
+
+	if addrs := c.Backend["pg3"].Address; len(addrs) != 2 || addrs[0] != "10.0.0.4:5432" {
+		t.Errorf("Expected the addresses of pg3 to be replaced; instead got %v", addrs)
+	}
+
+	if c.Limits.MaxSessions != 5 {
+		t.Errorf("Expected max-sessions to be overridden; instead got %d", c.Limits.MaxSessions)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.nonexisting=1"}); err == nil {
+		t.Errorf("Expected an unknown variable to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.tunnel=socks5://proxy:1080"})
+	if err != nil || c.Backend["pg3"].settings.Dialer == nil || c.Health.settings.Dialer != nil {
+		t.Errorf("Expected pg3 alone to be reached through a tunnel; instead got %v", err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.expected-role=follower", "backend.pg3.enforce-role=true"})
+	if err != nil || c.Backend["pg3"].expect != (pool.Expectation{Role: pool.READ_ONLY, Enforce: true}) {
+		t.Errorf("Expected pg3 to be asserted a follower; instead got %v", err)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.expected-role=leader"}); err == nil {
+		t.Errorf("Expected an unknown role to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.tunnel=ftp://proxy:21"}); err == nil {
+		t.Errorf("Expected an unsupported tunnel to be rejected")
+	}
+
+	// pg3 is still configured.
+	c, err = LoadConfig("./config.ini", nil, []string{"main.backends="})
+	if err != nil || len(c.Main.Backends) != 0 {
+		t.Errorf("Expected named backends alone to be accepted; instead got %v", err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.backends=", "main.connstring=host=pg5,pg6 port=5433"})
+	if err != nil || len(c.Main.Backends) != 2 || c.Main.Backends[1] != "pg6:5433" {
+		t.Errorf("Expected the backends of the connection string; instead got %v, %v", c, err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.connstring=host=pg5 dbname=app"})
+	if err != nil || len(c.Health.settings.RoutingDatabases) != 1 || c.Health.settings.RoutingDatabases[0] != "app" {
+		t.Errorf("Expected the database of the connection string to be checked; instead got %v, %v", c, err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{
+		"health.check=show default_transaction_read_only => off, select 1 from sentinel"})
+	want := []pool.CheckQuery{{Query: "show default_transaction_read_only", Expect: "off"}, {Query: "select 1 from sentinel"}}
+	if err != nil || !reflect.DeepEqual(c.Health.settings.Checks, want) || !reflect.DeepEqual(c.Backend["pg3"].settings.Checks, want) {
+		t.Errorf("Expected the checks to be parsed and inherited; instead got %v", err)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.check= => off"}); err == nil {
+		t.Errorf("Expected a check without a query to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.fallback=nowhere"}); err == nil {
+		t.Errorf("Expected an invalid fallback to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.route-if=lag < 2s && zone == 'a'", "main.route-score=-rtt"})
+	if err != nil || c.Main.routeIf == nil || c.Main.routeScore == nil {
+		t.Errorf("Expected the routing expressions to be compiled; instead got %v", err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"class.bounded-1s.balance=round-robin"})
+	if err != nil || c.Class["bounded-1s"].balance != pool.BalanceRoundRobin {
+		t.Errorf("Expected the class to balance round-robin; instead got %v", err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"class.bounded-1s.balance=least-connections"})
+	if err != nil || c.Class["bounded-1s"].balance != pool.BalanceLeastConnections {
+		t.Errorf("Expected the class to balance by least connections; instead got %v", err)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"class.bounded-1s.balance=random"}); err == nil {
+		t.Errorf("Expected an invalid balance to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"cluster.billing.backend=10.1.0.1:5432", "listener.bounded.cluster=billing"})
+	if err != nil || c.Listener["bounded"].Cluster != "billing" || len(c.Cluster["billing"].Backend) != 1 {
+		t.Errorf("Expected the bounded listener to route to the billing cluster; instead got %v", err)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"listener.bounded.cluster=billing"}); err == nil {
+		t.Errorf("Expected a listener of an unknown cluster to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"cluster.billing.backend=10.1.0.1"}); err == nil {
+		t.Errorf("Expected a cluster backend without a port to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.failover=drain", "main.primary-arbitration=wal",
+		"cluster.billing.backend=10.1.0.1:5432", "cluster.billing.username=billing", "cluster.billing.failover=sever"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl := c.Cluster["billing"]; cl.settings.User != "billing" || cl.settings.Database != c.Health.Database ||
+		cl.failover != (pool.FailoverPolicy{}) || cl.PrimaryArbitration != arbitrationWAL {
+		t.Errorf("Expected the cluster to override the credentials and failover, and inherit the rest; instead got %+v", cl)
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"cluster.billing.backend=10.1.0.1:5432", "cluster.billing.failover-grace=10s"}); err == nil {
+		t.Errorf("Expected a cluster's failover-grace without failover = drain to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"health.connection-lifetime=1h"})
+	if err != nil || c.Health.settings.ConnLifetime != time.Hour || c.Health.checkBackoff != 30*time.Second {
+		t.Errorf("Expected monitoring connections replaced hourly, and checks backed off up to 30s; instead got %v", err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.connection-lifetime=-1h"}); err == nil {
+		t.Errorf("Expected a negative connection-lifetime to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"cluster.billing.backend=10.1.0.1:5432", "cluster.billing.patroni=http://:8008"})
+	if err != nil || c.Cluster["billing"].settings.Patroni != "http://:8008" || c.Health.settings.Patroni != "" {
+		t.Errorf("Expected the cluster checked through Patroni; instead got %v", err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.patroni=http://:8008"}); err == nil {
+		t.Errorf("Expected Patroni for a backend with several addresses to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.patroni=10.0.0.1:8008"}); err == nil {
+		t.Errorf("Expected a Patroni REST API that isn't an http URL to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"cluster.inventory.backend=10.2.0.1:3306", "cluster.inventory.engine=mysql",
+		"listener.bounded.cluster=inventory"})
+	if err != nil || !c.mysql("inventory") || c.mysql("") {
+		t.Errorf("Expected the inventory cluster to be of MySQL servers; instead got %v", err)
+	}
+	for _, overrides := range [][]string{
+		{"cluster.inventory.engine=oracle"},
+		{"cluster.inventory.engine=mysql", "cluster.inventory.patroni=http://:8008"},
+		{"cluster.inventory.engine=mysql", "rule.app.database=app", "rule.app.cluster=inventory"},
+	} {
+		overrides = append(overrides, "cluster.inventory.backend=10.2.0.1:3306")
+		if _, err = LoadConfig("./config.ini", nil, overrides); err == nil {
+			t.Errorf("Expected %v to be rejected", overrides)
+		}
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"consul.leader-key=arbiter/leader"})
+	if err != nil || c.Consul.Address != "http://127.0.0.1:8500" || c.Consul.leaderTTL != 15*time.Second {
+		t.Errorf("Expected a leader elected through the local agent with a TTL of 15s; instead got %v", err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"consul.leader-key=arbiter/leader", "consul.leader-ttl=5s"}); err == nil {
+		t.Errorf("Expected a leader-ttl below Consul's minimum to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"consul.observers-prefix=arbiter/observers"}); err == nil {
+		t.Errorf("Expected observers-prefix without the number of observers to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.route-if=lag"}); err == nil {
+		t.Errorf("Expected a route-if that isn't a bool to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.backends=pg1:5432, pg1:5432"}); err == nil {
+		t.Errorf("Expected a backend given twice to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.backends=10.0.0.3:5432"}); err == nil {
+		t.Errorf("Expected the address of a named backend to be rejected as another backend")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.log-level=verbose"}); err == nil {
+		t.Errorf("Expected an unknown log level to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.degrade-on=permissions"}); err == nil {
+		t.Errorf("Expected an unknown kind of error to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"health.check-window=15m"})
+	if err != nil || c.Health.CheckHistory != 3600 || len(c.Health.checkWindows) != 1 || c.Health.checkWindows[0] != 15*time.Minute {
+		t.Errorf("Expected a history of 3600 checks summarized over 15m; instead got %d, %v, %v", c.Health.CheckHistory, c.Health.checkWindows, err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"health.check-window=-1m"}); err == nil {
+		t.Errorf("Expected a negative check window to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"daemon.umask=027"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Daemon.umask != 027 {
+		t.Errorf("Expected the umask 027; instead got %o", c.Daemon.umask)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"daemon.umask=999"}); err == nil {
+		t.Errorf("Expected a umask that isn't octal to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.write-group=eu"}); err == nil {
+		t.Errorf("Expected a write group without multi-writer to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.multi-writer=true", "backend.pg3.write-group=eu"}); err != nil {
+		t.Errorf("Expected a write group with multi-writer to be accepted; instead got %s", err)
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.failover=drain", "main.failover-grace=30s"})
+	if err != nil || c.Main.failover != (pool.FailoverPolicy{Drain: true, Grace: 30 * time.Second}) {
+		t.Errorf("Expected sessions to be drained for 30s on a failover; instead got %v", err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.failover-grace=30s"}); err == nil {
+		t.Errorf("Expected a failover grace without draining to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.failover=wait"}); err == nil {
+		t.Errorf("Expected an unknown failover policy to be rejected")
+	}
+	c, err = LoadConfig("./config.ini", nil, []string{"hooks.on=down,recovery"})
+	if err != nil || !reflect.DeepEqual(c.Hooks.on, []string{hookDown, hookRecovery}) {
+		t.Errorf("Expected hooks on down and recovery; instead got %v, %v", c.Hooks.on, err)
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"hooks.on=up"}); err == nil {
+		t.Errorf("Expected an unknown hook to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.primary-arbitration=oldest"}); err == nil {
+		t.Errorf("Expected an unknown primary arbitration to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"main.primary-arbitration=wal", "main.multi-writer=true"}); err == nil {
+		t.Errorf("Expected WAL arbitration with multi-writer to be rejected")
+	}
+
+	c, err = LoadConfig("./config.ini", nil, []string{"main.cluster-mode=distributed", "backend.pg3.liveness-query=select ok",
+		"cluster.billing.backend=10.1.0.1:5432", "cluster.billing.cluster-mode=replication"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Health.settings.LivenessQuery != defaultLivenessQuery || c.Backend["pg3"].settings.LivenessQuery != "select ok" ||
+		c.Cluster["billing"].settings.LivenessQuery != "" {
+		t.Errorf("Expected distributed backends checked for liveness, and the replicated cluster for its role; instead got %q, %q and %q",
+			c.Health.settings.LivenessQuery, c.Backend["pg3"].settings.LivenessQuery, c.Cluster["billing"].settings.LivenessQuery)
+	}
+	for _, overrides := range [][]string{
+		{"main.cluster-mode=sharded"},
+		{"main.cluster-mode=distributed", "main.multi-writer=true"},
+		{"main.cluster-mode=distributed", "main.primary-arbitration=wal"},
+	} {
+		if _, err = LoadConfig("./config.ini", nil, overrides); err == nil {
+			t.Errorf("Expected %v to be rejected", overrides)
+		}
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"autoscale.rebalance-percent=120"}); err == nil {
+		t.Errorf("Expected a rebalance-percent over 100 to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"backend.pg3.weight=-1"}); err == nil {
+		t.Errorf("Expected a negative weight to be rejected")
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"limits.max-connections-per-backend=-1"}); err == nil {
+		t.Errorf("Expected a negative max-connections-per-backend to be rejected")
+	}
+}
+
+func TestClientZones(t *testing.T) {
+	zones, err := parseClientZones([]string{"10.0.0.0/8 us-east-1", "10.1.0.0/16 eu-west-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, want := range map[string]string{"10.1.2.3": "eu-west-1", "10.2.0.1": "us-east-1", "192.168.0.1": ""} {
+		if got := zones.lookup(&net.TCPAddr{IP: net.ParseIP(ip), Port: 5432}); got != want {
+			t.Errorf("%s: Expected zone %q; instead got %q", ip, want, got)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/8", "10.0.0.0 us-east-1", "10.0.0.0/8 us east"} {
+		if _, err := parseClientZones([]string{bad}); err == nil {
+			t.Errorf("%s: Expected to be rejected", bad)
+		}
+	}
+}
+
+func TestStickyReads(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{"main.sticky-reads=user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Main.stickyTTL != 5*time.Minute {
+		t.Errorf("Expected sticky-ttl to default to 5m; instead got %s", c.Main.stickyTTL)
+	}
+	for _, bad := range []string{"main.sticky-reads=ip", "main.sticky-ttl=0s"} {
+		if _, err := LoadConfig("./config.ini", nil, []string{"main.sticky-reads=client", bad}); err == nil {
+			t.Errorf("%s: Expected to be rejected", bad)
+		}
+	}
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 50123}
+	params := map[string]string{"user": "reports", "database": "sales"}
+	for _, test := range []struct {
+		by     string
+		params map[string]string
+		want   string
+	}{
+		{stickyClient, params, "10.1.2.3"},
+		{stickyUser, params, "reports\x00sales"},
+		{stickyUser, nil, ""},
+		{"", params, ""},
+	} {
+		if got := stickyKey(test.by, addr, test.params); got != test.want {
+			t.Errorf("%q: Expected key %q; instead got %q", test.by, test.want, got)
+		}
+	}
+}
+
+func TestTLSSettings(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{"health.sslmode=verify-full", "health.sslrootcert=/etc/ca.pem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Backend["pg3"].settings; s.SSLMode != "verify-full" || s.SSLRootCert != "/etc/ca.pem" {
+		t.Errorf("Expected pg3 to inherit the TLS settings of [health]; instead got %+v", s)
+	}
+
+	if _, err := LoadConfig("./config.ini", nil, []string{"health.sslmode=verify"}); err == nil {
+		t.Errorf("Expected an invalid sslmode to be rejected")
+	}
+	if _, err := LoadConfig("./config.ini", nil, []string{"health.sslcert=/etc/arbiter.crt"}); err == nil {
+		t.Errorf("Expected a client certificate without a key to be rejected")
+	}
+
+	if _, err := LoadConfig("./config.ini", nil, []string{"main.tls=verify"}); err == nil {
+		t.Errorf("Expected an invalid tls to be rejected")
+	}
+	if _, err := LoadConfig("./config.ini", nil, []string{"listener.bounded.tls=terminate"}); err == nil {
+		t.Errorf("Expected terminating TLS without a certificate to be rejected")
 	}
 }
@@ -0,0 +1,279 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Bounds of the delay between attempts to reach Consul after a failure.
+const (
+	minConsulBackoff = time.Second
+	maxConsulBackoff = time.Minute
+)
+
+// How long a blocking query of Consul waits for the service to change.
+const consulWait = 5 * time.Minute
+
+// errConsulNotFound is returned for what Consul doesn't have, such as a session that
+// expired.
+var errConsulNotFound = errors.New("not found in Consul")
+
+// consulWatcher keeps the backends in sync with the passing instances of a Consul
+// service, with blocking queries, adding them as they register and removing them as
+// they deregister or fail their Consul checks.  It can also register the listeners of
+// arbiter in Consul, so that clients discover them there.
+type consulWatcher struct {
+	*discovery
+	addr    string
+	token   string
+	service string
+	tag     string
+	dc      string
+	client  *http.Client
+}
+
+func newConsulWatcher(s *server, c *Config) *consulWatcher {
+	return &consulWatcher{
+		discovery: newDiscovery(s, "Consul"),
+		addr:      c.Consul.Address,
+		token:     c.Consul.Token,
+		service:   c.Consul.Service,
+		tag:       c.Consul.Tag,
+		dc:        c.Consul.Datacenter,
+		client:    &http.Client{Timeout: consulWait + 30*time.Second},
+	}
+}
+
+// consulEntry is an instance of a service, as listed by /v1/health/service.
+type consulEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Port    int
+	}
+}
+
+// Watch the service until the process exits.
+func (cw *consulWatcher) run() {
+	index := "0"
+	backoff := minConsulBackoff
+	for {
+		entries, next, err := cw.fetch(index)
+		if err == nil {
+			err = cw.sync(entries)
+		}
+		if err != nil {
+			cw.s.logger.Printf("Consul: could not sync with service %s; retrying in %s: %s", cw.service, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxConsulBackoff {
+				backoff = maxConsulBackoff
+			}
+			continue
+		}
+		backoff = minConsulBackoff
+
+		// The index going backwards means Consul's state was reset, and the watch
+		// starts over, without hammering Consul should it keep doing so.
+		i, _ := strconv.ParseUint(index, 10, 64)
+		if n, _ := strconv.ParseUint(next, 10, 64); n == 0 || n < i {
+			next = "0"
+			time.Sleep(minConsulBackoff)
+		}
+		index = next
+	}
+}
+
+// List the passing instances of the service, blocking until its index moves past
+// index.  Returns the index they're at.
+func (cw *consulWatcher) fetch(index string) ([]consulEntry, string, error) {
+	q := url.Values{"passing": {"1"}, "index": {index}, "wait": {consulWait.String()}}
+	if cw.tag != "" {
+		q.Set("tag", cw.tag)
+	}
+	if cw.dc != "" {
+		q.Set("dc", cw.dc)
+	}
+
+	req, err := http.NewRequest("GET", cw.addr+"/v1/health/service/"+url.PathEscape(cw.service)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if cw.token != "" {
+		req.Header.Set("X-Consul-Token", cw.token)
+	}
+
+	resp, err := cw.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("Consul responded with %s", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, "", err
+	}
+
+	return entries, resp.Header.Get("X-Consul-Index"), nil
+}
+
+// Reconcile the declared state with the instances of the service: each is a backend
+// named after its node, or its service ID if the node runs several, at the service's
+// address, or else the node's.
+func (cw *consulWatcher) sync(entries []consulEntry) error {
+	discovered := make(map[string]*desiredBackend)
+	for _, e := range entries {
+		name := e.Node.Node
+		if discovered[name] != nil {
+			name = e.Service.ID
+		}
+
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		discovered[name] = &desiredBackend{Address: []string{net.JoinHostPort(host, strconv.Itoa(e.Service.Port))}}
+	}
+
+	return cw.update(discovered)
+}
+
+// consulRegistration is a service registered with the local Consul agent.
+type consulRegistration struct {
+	ID      string
+	Name    string
+	Address string `json:",omitempty"`
+	Port    int
+	Check   consulCheck
+}
+
+type consulCheck struct {
+	HTTP                           string
+	Interval                       string
+	DeregisterCriticalServiceAfter string
+}
+
+// Register the listener of class at listen in Consul as the service name, checked on
+// the HTTP interface at httpAddr, retrying until it succeeds.  advertise is the host
+// clients are to connect to, and Consul checks on if httpAddr listens on all
+// addresses; the agent's address if empty.
+func (cw *consulWatcher) register(name, class, listen, advertise, httpAddr string) {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		cw.s.logger.Printf("Consul: not registering %s: %s", name, err)
+		return
+	}
+	p, _ := strconv.Atoi(port)
+
+	if host, port, err := net.SplitHostPort(httpAddr); err == nil && advertise != "" {
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			httpAddr = net.JoinHostPort(advertise, port)
+		}
+	}
+
+	check := &url.URL{Scheme: "http", Host: httpAddr, Path: "/health", RawQuery: url.Values{"class": {class}}.Encode()}
+	reg := consulRegistration{
+		ID:      name,
+		Name:    name,
+		Address: advertise,
+		Port:    p,
+		Check: consulCheck{
+			HTTP:                           check.String(),
+			Interval:                       "5s",
+			DeregisterCriticalServiceAfter: "10m",
+		},
+	}
+	body, err := json.Marshal(reg)
+	if err != nil {
+		cw.s.logger.Printf("Consul: not registering %s: %s", name, err)
+		return
+	}
+
+	for backoff := minConsulBackoff; ; {
+		err := cw.put("/v1/agent/service/register", body)
+		if err == nil {
+			cw.s.logger.Printf("Consul: registered %s on port %d", name, p)
+			return
+		}
+
+		cw.s.logger.Printf("Consul: could not register %s; retrying in %s: %s", name, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxConsulBackoff {
+			backoff = maxConsulBackoff
+		}
+	}
+}
+
+// Create a session named name that expires unless renewed within ttl, and then
+// releases or deletes the keys it holds, as behavior says.
+func (cw *consulWatcher) createSession(ctx context.Context, name string, ttl time.Duration, behavior string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"Name":      name,
+		"TTL":       ttl.String(),
+		"Behavior":  behavior,
+		"LockDelay": "0s",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cw.call(ctx, "PUT", "/v1/session/create", body)
+	if err != nil {
+		return "", err
+	}
+
+	var created struct{ ID string }
+	if err = json.Unmarshal(resp, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (cw *consulWatcher) put(path string, body []byte) error {
+	_, err := cw.call(context.Background(), "PUT", path, body)
+	return err
+}
+
+// Send a request of method to path of the Consul HTTP API within ctx, returning the
+// body of the response.  What Consul doesn't have is errConsulNotFound.
+func (cw *consulWatcher) call(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, cw.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cw.token != "" {
+		req.Header.Set("X-Consul-Token", cw.token)
+	}
+
+	resp, err := cw.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errConsulNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("Consul responded with %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,125 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestConsulWatcher(t *testing.T) {
+	priority := 1
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared: &declaration{state: desiredState{Backends: map[string]*desiredBackend{
+			"pg1": {Address: []string{"127.0.0.1:5432"}, Priority: &priority, check: &CheckSettings{}},
+		}}},
+	}
+	s.pool.PutNamed("pg1", &queueBackend{})
+
+	var instances string
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/health/service/postgres" || req.FormValue("passing") != "1" ||
+			req.FormValue("tag") != "prod" || req.Header.Get("X-Consul-Token") != "secret" {
+			http.Error(w, "unexpected request "+req.URL.String(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "42")
+		w.Write([]byte(instances))
+	}))
+	defer consul.Close()
+
+	c := &Config{}
+	c.Consul.Address, c.Consul.Token, c.Consul.Service, c.Consul.Tag = consul.URL, "secret", "postgres", "prod"
+	cw := newConsulWatcher(s, c)
+
+	backends := func() string {
+		var names []string
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			names = append(names, b.Name+"="+b.Addr)
+			return true
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	sync := func() {
+		t.Helper()
+		entries, index, err := cw.fetch("0")
+		if err != nil {
+			t.Fatalf("Expected to fetch the service; instead got %s", err)
+		}
+		if index != "42" {
+			t.Fatalf("Expected index 42; instead got %s", index)
+		}
+		if err := cw.sync(entries); err != nil {
+			t.Fatalf("Expected to sync; instead got %s", err)
+		}
+	}
+
+	// pg1 is declared by the configuration file, and isn't taken over.
+	instances = `[
+		{"Node": {"Node": "pg1", "Address": "10.0.0.1"}, "Service": {"ID": "postgres", "Port": 5432}},
+		{"Node": {"Node": "pg2", "Address": "10.0.0.2"}, "Service": {"ID": "postgres", "Address": "127.0.0.1", "Port": 5433}}
+	]`
+	sync()
+	if got, want := backends(), "pg1=127.0.0.1:5432,pg2=127.0.0.1:5433"; got != want {
+		t.Fatalf("Expected backends %s; instead got %s", want, got)
+	}
+
+	// Discovered backends survive a reload of the configuration file.
+	ds := desiredState{Backends: map[string]*desiredBackend{
+		"pg1": {Address: []string{"127.0.0.1:5432"}, Priority: &priority, check: &CheckSettings{}},
+	}}
+	cw.keep(ds)
+	if _, err := s.apply(ds); err != nil {
+		t.Fatalf("Expected to apply the reloaded state; instead got %s", err)
+	}
+	if got, want := backends(), "pg1=127.0.0.1:5432,pg2=127.0.0.1:5433"; got != want {
+		t.Fatalf("Expected backends %s after a reload; instead got %s", want, got)
+	}
+
+	// Readdressed, then deregistered.
+	instances = `[{"Node": {"Node": "pg2", "Address": "127.0.0.1"}, "Service": {"ID": "postgres", "Port": 5434}}]`
+	sync()
+	if got, want := backends(), "pg1=127.0.0.1:5432,pg2=127.0.0.1:5434"; got != want {
+		t.Fatalf("Expected backends %s; instead got %s", want, got)
+	}
+
+	instances = `[]`
+	sync()
+	if got, want := backends(), "pg1=127.0.0.1:5432"; got != want {
+		t.Fatalf("Expected backends %s; instead got %s", want, got)
+	}
+}
+
+func TestConsulRegister(t *testing.T) {
+	var got consulRegistration
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "PUT" || req.URL.Path != "/v1/agent/service/register" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer consul.Close()
+
+	c := &Config{}
+	c.Consul.Address = consul.URL
+	cw := newConsulWatcher(&server{logger: log.Default()}, c)
+	cw.register("arbiter-replica", "eventual", "0.0.0.0:5433", "10.0.0.5", "0.0.0.0:6060")
+
+	if got.Name != "arbiter-replica" || got.Port != 5433 || got.Address != "10.0.0.5" {
+		t.Fatalf("Expected arbiter-replica at 10.0.0.5:5433; instead got %+v", got)
+	}
+	if want := "http://10.0.0.5:6060/health?class=eventual"; got.Check.HTTP != want {
+		t.Fatalf("Expected the check %s; instead got %s", want, got.Check.HTTP)
+	}
+}
@@ -0,0 +1,286 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"github.com/solvip/arbiter/arbiterpb"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// The metadata the Control service takes the name of the operator making a call from,
+// as the HTTP interface does from the X-Arbiter-Operator header.
+const operatorMetadata = "x-arbiter-operator"
+
+// How often Watch looks for changes besides state transitions, such as to lag and
+// leases.
+const watchInterval = time.Second
+
+// controlServer is the Control service of arbiter.proto, for orchestration tooling.
+// The calls that change the pool do as the HTTP interface does, in approval mode too.
+type controlServer struct {
+	arbiterpb.UnimplementedControlServer
+	s *server
+}
+
+// Serve the Control service on ln until it's closed.
+func (s *server) serveControl(ln net.Listener) {
+	srv := grpc.NewServer()
+	arbiterpb.RegisterControlServer(srv, controlServer{s: s})
+
+	// The listener is closed on handing over to a new arbiter; see restartOnSignal().
+	if err := srv.Serve(ln); !errors.Is(err, net.ErrClosed) {
+		s.fatalf("gRPC server failed: %s", err)
+	}
+}
+
+// Watch sends the state of every backend of the cluster req names on stream, and then
+// that of each backend whose state changes, on every state transition and every
+// watchInterval, until the call is cancelled.
+func (cs controlServer) Watch(req *arbiterpb.WatchRequest, stream grpc.ServerStreamingServer[arbiterpb.BackendState]) error {
+	p := cs.s.cluster(req.Cluster)
+	if p == nil {
+		return status.Errorf(codes.NotFound, "unknown cluster %s", req.Cluster)
+	}
+
+	changed := make(chan struct{}, 1)
+	stop := p.SubscribeFunc(func(pool.Event) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	t := time.NewTicker(watchInterval)
+	defer t.Stop()
+
+	// The states last sent, by backend, without when.
+	sent := make(map[string]*arbiterpb.BackendState)
+	send := func(st *arbiterpb.BackendState, now time.Time) error {
+		st = proto.Clone(st).(*arbiterpb.BackendState)
+		st.TimeUnixNano = now.UnixNano()
+		return stream.Send(st)
+	}
+	for {
+		now := time.Now()
+		seen := make(map[string]bool)
+		for _, b := range p.Backends() {
+			seen[b.Name] = true
+			st := &arbiterpb.BackendState{Name: b.Name, Addr: b.Addr, State: arbiterpb.State(b.State),
+				LagNanos: int64(b.Lag), Draining: b.Draining, Cordoned: b.Cordoned, Leases: b.Leases,
+				Weight: b.Weight}
+			if proto.Equal(sent[b.Name], st) {
+				continue
+			}
+
+			sent[b.Name] = st
+			if err := send(st, now); err != nil {
+				return err
+			}
+		}
+		for name, st := range sent {
+			if seen[name] {
+				continue
+			}
+
+			delete(sent, name)
+			st.Removed = true
+			if err := send(st, now); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-changed:
+		case <-t.C:
+		}
+	}
+}
+
+// AddBackend adds the backend req to the declared state, as POST /backends does.
+func (cs controlServer) AddBackend(ctx context.Context, req *arbiterpb.AddBackendRequest) (*arbiterpb.ChangeReply, error) {
+	b := &addedBackend{Name: req.Name, desiredBackend: desiredBackend{Address: req.Address, Drained: req.Drained,
+		Zone: req.Zone, Synchronous: req.Synchronous, WriteGroup: req.WriteGroup,
+		MaxConnections: int(req.MaxConnections), Weight: req.Weight}}
+	if len(req.Labels) > 0 {
+		b.Labels = metrics.Labels(req.Labels)
+	}
+	if req.Priority != nil {
+		priority := int(*req.Priority)
+		b.Priority = &priority
+	}
+	if b.Name == "" && len(b.Address) > 0 {
+		b.Name = b.Address[0]
+	}
+
+	ds := cs.s.declared.copyState()
+	if ds.Backends[b.Name] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "backend %s already exists", b.Name)
+	}
+	ds.Backends[b.Name] = &b.desiredBackend
+
+	if err := ds.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return cs.reconcile(ctx, ds)
+}
+
+// RemoveBackend removes a backend from the declared state, as DELETE /backends/{name}
+// does.
+func (cs controlServer) RemoveBackend(ctx context.Context, req *arbiterpb.RemoveBackendRequest) (*arbiterpb.ChangeReply, error) {
+	key := req.Backend
+	ds := cs.s.declared.copyState()
+	name := ds.find(key)
+	if name == "" {
+		return nil, status.Errorf(codes.NotFound, "%s: not a declared backend", key)
+	}
+	delete(ds.Backends, name)
+
+	return cs.reconcile(ctx, ds)
+}
+
+// Reconcile the pool with ds, which is valid, on behalf of the operator of ctx; see
+// applyAs().
+func (cs controlServer) reconcile(ctx context.Context, ds desiredState) (*arbiterpb.ChangeReply, error) {
+	changes, op, err := cs.s.applyAs(operatorOf(ctx), ds)
+	switch {
+	case err == errNoOperator:
+		return nil, status.Error(codes.InvalidArgument, operatorMetadata+" is required")
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return changeReply(changes, op), nil
+}
+
+// Drain drains, cordons, uncordons or resumes backends, as POST /drain and the like do.
+func (cs controlServer) Drain(ctx context.Context, req *arbiterpb.DrainRequest) (*arbiterpb.ChangeReply, error) {
+	action, grace := req.Action, time.Duration(req.GraceNanos)
+	if action == "" {
+		action = actionDrain
+	}
+	switch {
+	case action != actionDrain && action != actionResume && action != "cordon" && action != "uncordon":
+		return nil, status.Errorf(codes.InvalidArgument, "unknown action '%s'", action)
+	case len(req.Backend) == 0:
+		return nil, status.Error(codes.InvalidArgument, "backend is required")
+	case grace < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid grace '%s'", grace)
+	}
+
+	op, err := cs.s.drainAs(operatorOf(ctx), req.Backend, action, grace)
+	switch {
+	case err == errNoOperator:
+		return nil, status.Error(codes.InvalidArgument, operatorMetadata+" is required")
+	case errors.Is(err, pool.ErrUnknownBackend):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	case op != nil:
+		return changeReply(nil, op), nil
+	}
+
+	var changes []change
+	for _, name := range req.Backend {
+		c := change{Action: action, Target: name}
+		if action == "cordon" && grace > 0 {
+			c.Detail = "draining in " + grace.String()
+		}
+		changes = append(changes, c)
+	}
+
+	return changeReply(changes, nil), nil
+}
+
+// Return the ChangeReply of changes, or of op pending approval.
+func changeReply(changes []change, op *operation) *arbiterpb.ChangeReply {
+	reply := &arbiterpb.ChangeReply{}
+	for _, c := range changes {
+		reply.Changes = append(reply.Changes, &arbiterpb.Change{Action: c.Action, Target: c.Target, Detail: c.Detail})
+	}
+	if op != nil {
+		reply.Pending = &arbiterpb.Operation{Id: op.ID, Action: op.Action, Target: op.Target,
+			RequestedBy: op.RequestedBy, ExpiresUnixNano: op.Expires.UnixNano()}
+	}
+
+	return reply
+}
+
+// Dial answers where a session would be routed to right now, and why, without routing
+// one: the backend of the class the pool routes it to, as the listeners do, before
+// they handle degraded modes; see acquire().  It's only peeked at, so that asking
+// doesn't shift where sessions are balanced to.
+func (cs controlServer) Dial(ctx context.Context, req *arbiterpb.DialRequest) (*arbiterpb.DialDecision, error) {
+	s := cs.s
+	class := req.Class
+	if class == "" {
+		class = "eventual"
+	}
+
+	p := s.cluster(req.Cluster)
+	if p == nil {
+		return nil, status.Errorf(codes.NotFound, "unknown cluster %s", req.Cluster)
+	}
+
+	var addr net.Addr
+	if req.ClientAddr != "" {
+		ap, err := netip.ParseAddrPort(req.ClientAddr)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid client_addr '%s'", req.ClientAddr)
+		}
+		addr = net.TCPAddrFromAddrPort(ap)
+	}
+
+	d := &arbiterpb.DialDecision{Mode: pool.HEALTHY.String(), Zone: s.clientZones.lookup(addr)}
+	if d.Zone == "" {
+		d.Zone = s.zone
+	}
+	if p.Stale() {
+		d.Mode = pool.STALE_VIEW.String()
+	}
+
+	key := stickyKey(s.stickyReads, addr, map[string]string{"user": req.User, "database": req.Database})
+	name, err := p.Peek(pool.StickTo(pool.InZone(ctx, d.Zone), key), class)
+	var degraded *pool.DegradedError
+	switch {
+	case errors.As(err, &degraded):
+		d.Mode = degraded.Mode.String()
+	case err == pool.ErrUnknownClass:
+		return nil, status.Errorf(codes.NotFound, "%s: %s", class, err)
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	d.Candidates, _ = p.Candidates(class)
+	p.ForEach(func(b pool.BackendInfo) bool {
+		if b.Name == name {
+			d.Backend, d.Addr = b.Name, b.Addr
+		}
+		return d.Backend == ""
+	})
+
+	return d, nil
+}
+
+// Return the operator named in the metadata of ctx, or "".
+func operatorOf(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(operatorMetadata); len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+
+	return ""
+}
@@ -0,0 +1,137 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/arbiterpb"
+	"github.com/solvip/arbiter/pool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestControlService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	priority := 1
+	s := &server{
+		pool:             pool.New(ctx, pool.WithManualChecks(time.Now)),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared: &declaration{state: desiredState{Backends: map[string]*desiredBackend{
+			"pg1": {Address: []string{"127.0.0.1:5432"}, Priority: &priority},
+		}}},
+	}
+	pg1 := &queueBackend{}
+	s.pool.PutNamed("pg1", pg1)
+	s.pool.CheckAll()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.serveControl(ln)
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	control := arbiterpb.NewControlClient(conn)
+
+	stream, err := control.Watch(ctx, &arbiterpb.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	await := func(what string, ok func(st *arbiterpb.BackendState) bool) {
+		for {
+			st, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Expected %s to be watched; instead got %v", what, err)
+			}
+			if ok(st) {
+				return
+			}
+		}
+	}
+	await("pg1", func(st *arbiterpb.BackendState) bool {
+		return st.Name == "pg1" && st.State == arbiterpb.State_UNAVAILABLE && st.TimeUnixNano != 0
+	})
+
+	dial := func(class string) *arbiterpb.DialDecision {
+		d, err := control.Dial(ctx, &arbiterpb.DialRequest{Class: class})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	if d := dial("strong"); d.Backend != "" || d.Mode != "NO_PRIMARY" {
+		t.Errorf("Expected no primary to route to; instead got %v", d)
+	}
+
+	pg1.promote()
+	s.pool.CheckAll()
+	await("the promotion of pg1", func(st *arbiterpb.BackendState) bool {
+		return st.Name == "pg1" && st.State == arbiterpb.State_READ_WRITE
+	})
+	if d := dial("strong"); d.Backend != "pg1" || d.Addr != "127.0.0.1:5432" || d.Mode != "HEALTHY" || len(d.Candidates) != 1 {
+		t.Errorf("Expected to be routed to pg1; instead got %v", d)
+	}
+
+	zero, weight := int32(0), 0.5
+	reply, err := control.AddBackend(ctx, &arbiterpb.AddBackendRequest{Address: []string{"127.0.0.1:1"},
+		Labels: map[string]string{"dc": "eu"}, Priority: &zero, Weight: &weight, MaxConnections: -1})
+	if err != nil || len(reply.Changes) == 0 || reply.Changes[0].Action != actionAdd {
+		t.Fatalf("Expected 127.0.0.1:1 to be added; instead got %v, %v", reply, err)
+	}
+	added := s.declared.copyState().Backends["127.0.0.1:1"]
+	if added == nil || added.Labels["dc"] != "eu" || added.Priority == nil || *added.Priority != 0 ||
+		added.Weight == nil || *added.Weight != weight || added.MaxConnections != -1 {
+		t.Errorf("Expected the backend to be declared as requested; instead got %+v", added)
+	}
+	await("the added backend", func(st *arbiterpb.BackendState) bool { return st.Name == "127.0.0.1:1" && !st.Removed })
+	_, err = control.AddBackend(ctx, &arbiterpb.AddBackendRequest{Name: "127.0.0.1:1", Address: []string{"127.0.0.1:2"}})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Expected the backend to exist already; instead got %v", err)
+	}
+
+	reply, err = control.RemoveBackend(ctx, &arbiterpb.RemoveBackendRequest{Backend: "127.0.0.1:1"})
+	if err != nil || len(reply.Changes) != 1 || reply.Changes[0].Action != actionRemove {
+		t.Fatalf("Expected 127.0.0.1:1 to be removed; instead got %v, %v", reply, err)
+	}
+	await("the removal", func(st *arbiterpb.BackendState) bool { return st.Name == "127.0.0.1:1" && st.Removed })
+
+	reply, err = control.Drain(ctx, &arbiterpb.DrainRequest{Backend: []string{"pg1"}, Action: "cordon",
+		GraceNanos: int64(time.Hour)})
+	if err != nil || len(reply.Changes) != 1 || reply.Changes[0].Detail != "draining in 1h0m0s" {
+		t.Fatalf("Expected pg1 to be cordoned; instead got %v, %v", reply, err)
+	}
+	await("pg1 cordoned", func(st *arbiterpb.BackendState) bool { return st.Name == "pg1" && st.Cordoned && !st.Draining })
+	if d := dial("strong"); d.Backend != "" || d.Mode != "NO_PRIMARY" {
+		t.Errorf("Expected the cordoned primary not to be routed to; instead got %v", d)
+	}
+	_, err = control.Drain(ctx, &arbiterpb.DrainRequest{Backend: []string{"pg9"}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected pg9 not to be found; instead got %v", err)
+	}
+
+	// In approval mode, draining is only requested, on behalf of the operator.
+	s.approvals = newApprovals(time.Minute, log.Default())
+	_, err = control.Drain(ctx, &arbiterpb.DrainRequest{Backend: []string{"pg1"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected the operator to be required; instead got %v", err)
+	}
+	reply, err = control.Drain(metadata.AppendToOutgoingContext(ctx, operatorMetadata, "alice"),
+		&arbiterpb.DrainRequest{Backend: []string{"pg1"}})
+	if err != nil || reply.Pending == nil || reply.Pending.Action != actionDrain || reply.Pending.RequestedBy != "alice" ||
+		len(reply.Changes) != 0 {
+		t.Errorf("Expected the drain to await approval; instead got %v, %v", reply, err)
+	}
+}
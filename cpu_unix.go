@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package arbiter
+
+import (
+	"syscall"
+	"time"
+)
+
+// Return the CPU time used by the process so far; -1 if unknown.
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return -1
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
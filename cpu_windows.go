@@ -0,0 +1,10 @@
+package arbiter
+
+import (
+	"time"
+)
+
+// CPU time isn't tracked on Windows.
+func cpuTime() time.Duration {
+	return -1
+}
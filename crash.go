@@ -0,0 +1,204 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// What arbiter exits with, so that supervisors can tell why it did; see [daemon].
+const (
+	exitStopped  = 0 // stopped, as on SIGTERM
+	exitFatal    = 1 // couldn't start, or failed for good
+	exitPanic    = 2 // panicked, as the Go runtime exits with
+	exitWatchdog = 3 // breached [watchdog] limits for exit-after
+)
+
+// The events kept for crash reports.
+const crashEvents = 100
+
+// How long a crash report waits for the stats, since the pool may be what failed.
+const crashStatsTimeout = 5 * time.Second
+
+// crashReport is the JSON document written to [daemon] crash-report when arbiter exits
+// on a fatal error or a panic.
+type crashReport struct {
+	Time      time.Time      `json:"time"`
+	Reason    string         `json:"reason"`
+	ExitCode  int            `json:"exit_code"`
+	PID       int            `json:"pid"`
+	GoVersion string         `json:"go_version"`
+	Labels    metrics.Labels `json:"labels,omitempty"`
+	Config    crashConfig    `json:"config"`
+
+	// Nil if they couldn't be taken in time.
+	Stats *stats `json:"stats"`
+
+	// The last state transitions of the backends, oldest first.
+	Events []pool.Event `json:"events"`
+
+	// The stacks of all goroutines.
+	Goroutines string `json:"goroutines"`
+}
+
+// crashConfig summarizes the configuration arbiter runs with, without credentials: its
+// listeners, and the declared state of the pool, as on /config.
+type crashConfig struct {
+	Path      string                   `json:"path"`
+	Primary   string                   `json:"primary"`
+	Follower  string                   `json:"follower"`
+	Listeners map[string]crashListener `json:"listeners,omitempty"`
+	Declared  *desiredState            `json:"declared,omitempty"`
+}
+
+type crashListener struct {
+	Address string `json:"address"`
+	Class   string `json:"class"`
+}
+
+// crashReporter writes crash reports to path, keeping the events they include.
+type crashReporter struct {
+	path   string
+	config crashConfig
+
+	mu     sync.Mutex
+	events []pool.Event
+}
+
+// Return a crashReporter writing to c's [daemon] crash-report, or nil if it has none.
+// path is where c was loaded from.
+func newCrashReporter(path string, c *Config) *crashReporter {
+	if c.Daemon.CrashReport == "" {
+		return nil
+	}
+
+	cc := crashConfig{Path: path, Primary: c.Main.Primary, Follower: c.Main.Follower}
+	for name, l := range c.Listener {
+		if cc.Listeners == nil {
+			cc.Listeners = make(map[string]crashListener)
+		}
+		cc.Listeners[name] = crashListener{Address: l.Address, Class: l.Class}
+	}
+
+	return &crashReporter{path: c.Daemon.CrashReport, config: cc}
+}
+
+// Keep the last state transitions of the backends of p until the process exits.
+func (cr *crashReporter) record(p *pool.Pool) {
+	for e := range p.Subscribe() {
+		cr.add(e)
+	}
+}
+
+func (cr *crashReporter) add(e pool.Event) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.events = append(cr.events, e)
+	if len(cr.events) > crashEvents {
+		cr.events = cr.events[len(cr.events)-crashEvents:]
+	}
+}
+
+// Write the report of arbiter exiting with code for reason, replacing any earlier one.
+func (cr *crashReporter) write(s *server, reason string, code int) error {
+	rep := crashReport{
+		Time:       time.Now(),
+		Reason:     reason,
+		ExitCode:   code,
+		PID:        os.Getpid(),
+		GoVersion:  runtime.Version(),
+		Labels:     s.labels,
+		Config:     cr.config,
+		Goroutines: goroutines(),
+	}
+
+	if s.declared != nil {
+		ds := s.declared.copyState()
+		rep.Config.Declared = &ds
+	}
+
+	cr.mu.Lock()
+	rep.Events = append([]pool.Event{}, cr.events...)
+	cr.mu.Unlock()
+
+	if s.pool != nil {
+		taken := make(chan stats, 1)
+		go func() { taken <- s.stats() }()
+		select {
+		case st := <-taken:
+			rep.Stats = &st
+		case <-time.After(crashStatsTimeout):
+		}
+	}
+
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cr.path), ".crash-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cr.path)
+}
+
+// Return the stacks of all goroutines.
+func goroutines() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// Log a fatal error, write a crash report if [daemon] crash-report is set, and exit
+// with exitFatal.
+func (s *server) fatalf(format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	s.logger.Printf("%s", reason)
+	s.crashed(reason, exitFatal)
+}
+
+// Write a crash report of arbiter exiting with code for reason, if [daemon]
+// crash-report is set, and exit.
+func (s *server) crashed(reason string, code int) {
+	if s.crash != nil {
+		if err := s.crash.write(s, reason, code); err != nil {
+			s.logger.Printf("Could not write a crash report to %s: %s", s.crash.path, err)
+		} else {
+			s.logger.Printf("Wrote a crash report to %s", s.crash.path)
+		}
+	}
+
+	exitDaemon(code)
+}
+
+// Deferred by goroutines arbiter starts, so that a panic is logged and reported as a
+// crash before exiting with exitPanic.
+func (s *server) crashOnPanic() {
+	if r := recover(); r != nil {
+		s.logger.Printf("Panic: %v\n%s", r, debug.Stack())
+		s.crashed(fmt.Sprintf("panic: %v", r), exitPanic)
+	}
+}
@@ -0,0 +1,67 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrashReport(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{"health.password=hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newCrashReporter("./config.ini", c) != nil {
+		t.Fatalf("Expected no crash reports without a crash-report")
+	}
+
+	path := filepath.Join(t.TempDir(), "crash.json")
+	c.Daemon.CrashReport = path
+	s := &server{pool: pool.New(context.Background()), declared: newDeclaration(c), logger: log.Default()}
+	s.crash = newCrashReporter("./config.ini", c)
+
+	for i := 0; i < crashEvents+5; i++ {
+		s.crash.add(pool.Event{Name: "pg1", From: pool.UNAVAILABLE, To: pool.READ_ONLY})
+	}
+	s.crash.add(pool.Event{Name: "pg2", From: pool.READ_ONLY, To: pool.UNAVAILABLE})
+
+	if err := s.crash.write(s, "testing", exitFatal); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatalf("Expected no credentials in the crash report")
+	}
+
+	// States are only marshaled, so the events are read back by name.
+	var rep struct {
+		crashReport
+		Events []struct{ Name string } `json:"events"`
+	}
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Reason != "testing" || rep.ExitCode != exitFatal || rep.PID != os.Getpid() {
+		t.Errorf("Expected the reason, exit code and pid; instead got %+v", rep)
+	}
+	if rep.Config.Primary != c.Main.Primary || rep.Config.Declared == nil || len(rep.Config.Declared.Backends) == 0 {
+		t.Errorf("Expected a summary of the configuration; instead got %+v", rep.Config)
+	}
+	if len(rep.Events) != crashEvents || rep.Events[len(rep.Events)-1].Name != "pg2" {
+		t.Errorf("Expected the last %d events; instead got %d", crashEvents, len(rep.Events))
+	}
+	if rep.Stats == nil {
+		t.Errorf("Expected the stats")
+	}
+	if !strings.Contains(rep.Goroutines, "TestCrashReport") {
+		t.Errorf("Expected the stacks of all goroutines; instead got %q", rep.Goroutines)
+	}
+}
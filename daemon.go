@@ -0,0 +1,61 @@
+package arbiter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Set in the environment of the process started by arbiter -daemon, so that it doesn't
+// detach again.
+const daemonEnv = "ARBITER_DAEMON"
+
+// The pid file written by daemonStarted(), removed by exitDaemon().
+var pidFile string
+
+// Return where arbiter logs: [daemon] log-file, appended to, or else stderr.
+func logOutput(c *Config) (io.Writer, error) {
+	if c.Daemon.LogFile == "" {
+		return os.Stderr, nil
+	}
+
+	return os.OpenFile(c.Daemon.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+}
+
+// Finish starting up as a daemon once arbiter is listening: write the pid file, which
+// is removed once arbiter stops (see stopOnSignal()), and drop privileges to [daemon]
+// user and group.  predecessor is the process of the arbiter handing over to this one,
+// if any, whose pid file is taken over.
+func daemonStarted(c *Config, predecessor int) error {
+	if c.Daemon.PIDFile != "" {
+		if err := writePIDFile(c.Daemon.PIDFile, predecessor); err != nil {
+			return err
+		}
+		pidFile = c.Daemon.PIDFile
+	}
+
+	return dropPrivileges(c.Daemon.User, c.Daemon.Group)
+}
+
+// Write the process ID to path, unless it holds that of another running process other
+// than predecessor.
+func writePIDFile(path string, predecessor int) error {
+	if b, err := os.ReadFile(path); err == nil {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		if pid > 0 && pid != os.Getpid() && pid != predecessor && processRunning(pid) {
+			return fmt.Errorf("arbiter is already running as process %d, per %s", pid, path)
+		}
+	}
+
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Exit with code, removing the pid file.
+func exitDaemon(code int) {
+	if pidFile != "" {
+		os.Remove(pidFile)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,38 @@
+package arbiter
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arbiter.pid")
+
+	if err := writePIDFile(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), strconv.Itoa(os.Getpid()); got != want {
+		t.Fatalf("Expected the pid file to hold %s; instead got %s", want, got)
+	}
+
+	// A stale pid file is taken over, but not one of a running process.
+	os.WriteFile(path, []byte("999999999\n"), 0644)
+	if err := writePIDFile(path, 0); err != nil {
+		t.Fatalf("Expected a stale pid file to be taken over; instead got %s", err)
+	}
+
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+	if err := writePIDFile(path, 0); err == nil {
+		t.Fatalf("Expected the pid file of a running process to be refused")
+	}
+	if err := writePIDFile(path, os.Getppid()); err != nil {
+		t.Fatalf("Expected the pid file of the arbiter handing over to be taken over; instead got %s", err)
+	}
+}
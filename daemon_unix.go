@@ -0,0 +1,145 @@
+//go:build !windows
+// +build !windows
+
+package arbiter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Start arbiter again in the background, in a session of its own and without a
+// terminal, with the same arguments, and return once it has started.
+func daemonize() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Started arbiter in the background as process %d\n", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// Hand over to a new arbiter, started from arbiter's executable, as upgraded, with the
+// same arguments, once arbiter is sent SIGUSR2, and then stop as on SIGTERM, letting
+// the sessions being proxied finish for up to timeout; see handOver().  The pid file,
+// which the new arbiter has written, is kept, and systemd is told the new arbiter is
+// its main process.  Arbiter carries on if the new one fails to start.
+func (s *server) restartOnSignal(timeout time.Duration) {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+
+	for range usr2 {
+		exe, err := os.Executable()
+		if err != nil {
+			s.logger.Printf("Could not hand over to a new arbiter: %s", err)
+			continue
+		}
+
+		s.logger.Printf("Handing over to a new arbiter started from %s", exe)
+		p, err := s.handOver(exe, os.Args[1:])
+		if err != nil {
+			s.logger.Printf("Could not hand over to a new arbiter: %s", err)
+			continue
+		}
+
+		pid := strconv.Itoa(p.Pid)
+		s.systemd.notify("MAINPID="+pid, "STATUS=Handed over to process "+pid)
+		s.logger.Printf("Handed over to process %d; waiting up to %s for %d sessions to finish", p.Pid, timeout, s.nconns.Get())
+		s.httpListener.Close()
+		if s.grpcListener != nil {
+			s.grpcListener.Close()
+		}
+		pidFile = ""
+		if s.shutdown(timeout) {
+			s.logger.Printf("Stopped")
+		} else {
+			s.logger.Printf("Stopped with %d sessions still open", s.nconns.Get())
+		}
+
+		exitDaemon(exitStopped)
+	}
+}
+
+func setUmask(mask int) {
+	if mask >= 0 {
+		syscall.Umask(mask)
+	}
+}
+
+// Switch to running as the user name, and the group, or else the user's primary
+// group, for good; a no-op if both are empty.
+func dropPrivileges(name, group string) error {
+	if name == "" && group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	// Nothing is left to drop if arbiter runs as them already, as the arbiter handed
+	// over to by one that dropped them does.
+	if (uid < 0 || uid == os.Getuid()) && gid == os.Getgid() && os.Geteuid() != 0 {
+		return nil
+	}
+
+	// The group first, since changing it takes privileges the user may not have.
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("could not drop supplementary groups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("could not switch to group %d: %s", gid, err)
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("could not switch to user %d: %s", uid, err)
+		}
+	}
+
+	log.Printf("Running as user %d and group %d", os.Getuid(), os.Getgid())
+	return nil
+}
+
+// Whether the process pid exists.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Windows services are only run on Windows.
+func runAsService(name string, stop chan<- os.Signal) error {
+	return nil
+}
+
+func controlService(command, name string, args []string) error {
+	return errors.New("-service is only supported on Windows; see -daemon")
+}
@@ -0,0 +1,153 @@
+package arbiter
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log"
+	"os"
+	"time"
+)
+
+func daemonize() error {
+	return errors.New("-daemon isn't supported on Windows; see -service")
+}
+
+// There's no SIGUSR2 to hand over to a new arbiter on; services are upgraded by
+// restarting them.
+func (s *server) restartOnSignal(timeout time.Duration) {}
+
+// The umask is a Unix concept.
+func setUmask(mask int) {}
+
+// Privileges aren't dropped on Windows; run the service as the account it's to run as.
+func dropPrivileges(name, group string) error {
+	if name != "" || group != "" {
+		return errors.New("dropping privileges isn't supported on Windows")
+	}
+	return nil
+}
+
+// Whether the process pid exists.
+func processRunning(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == 259 // STILL_ACTIVE
+}
+
+// windowsService answers the service control manager while arbiter runs, stopping it
+// as on SIGTERM once it's asked to stop; see stopOnSignal().  The service is stopped
+// once arbiter exits.
+type windowsService struct {
+	stop chan<- os.Signal
+}
+
+func (w windowsService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for r := range req {
+		switch r.Cmd {
+		case svc.Interrogate:
+			status <- r.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			log.Printf("Stopping the %s service", args[0])
+			status <- svc.Status{State: svc.StopPending}
+			select {
+			case w.stop <- os.Interrupt:
+			default:
+			}
+		}
+	}
+
+	return false, 0
+}
+
+// If arbiter was started by the service control manager, answer it as the service
+// name in the background, sending to stop once it stops the service.
+func runAsService(name string, stop chan<- os.Signal) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return err
+	}
+
+	go func() {
+		if err := svc.Run(name, windowsService{stop}); err != nil {
+			log.Printf("The %s service failed: %s", name, err)
+			exitDaemon(exitFatal)
+		}
+	}()
+
+	return nil
+}
+
+// Install, uninstall, start or stop the service name, which is installed to run
+// arbiter with args.
+func controlService(command, name string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if command == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		s, err := m.CreateService(name, exe, mgr.Config{
+			DisplayName: "Arbiter",
+			Description: "Connection proxy for PostgreSQL",
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return err
+		}
+		s.Close()
+
+		fmt.Printf("Installed the %s service\n", name)
+		return nil
+	}
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("could not open the %s service: %s", name, err)
+	}
+	defer s.Close()
+
+	switch command {
+	case "uninstall":
+		err = s.Delete()
+	case "start":
+		err = s.Start()
+	case "stop":
+		var st svc.Status
+		if st, err = s.Control(svc.Stop); err != nil {
+			break
+		}
+		for deadline := time.Now().Add(30 * time.Second); st.State != svc.Stopped; {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("the %s service didn't stop in time", name)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if st, err = s.Query(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown -service command '%s'; expected install, uninstall, start or stop", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The %s service: %s done\n", name, command)
+	return nil
+}
@@ -0,0 +1,83 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// runtimeStats is what /debug/runtime serves: arbiter's goroutines, connections and
+// memory, to tell leaks from load in long-running deployments.
+type runtimeStats struct {
+	Goroutines int `json:"goroutines"`
+
+	// The monitors of the pools and their backends, one each unless monitors leaked.
+	Monitors int `json:"monitors"`
+	Backends int `json:"backends"`
+
+	Sessions           int64 `json:"sessions"`
+	QueuedSessions     int64 `json:"queued_sessions"`
+	BackendConnections int64 `json:"backend_connections"`
+	BufferedBytes      int64 `json:"buffered_bytes"`
+
+	// -1 where the platform doesn't tell.
+	OpenFiles    int64 `json:"open_files"`
+	MaxOpenFiles int64 `json:"max_open_files"`
+
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	SysBytes       uint64    `json:"sys_bytes"`
+	GCCycles       uint32    `json:"gc_cycles"`
+	LastGC         time.Time `json:"last_gc"`
+}
+
+// Serve net/http/pprof's profiles under /debug/pprof/, and runtime counters on
+// /debug/runtime, on mux; see [admin] debug.
+func (s *server) handleDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", s.handleRuntime)
+}
+
+func (s *server) handleRuntime(w http.ResponseWriter, req *http.Request) {
+	b, err := json.MarshalIndent(s.runtimeStats(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+	} else {
+		w.Write(b)
+	}
+}
+
+func (s *server) runtimeStats() runtimeStats {
+	stats := runtimeStats{
+		Goroutines:         runtime.NumGoroutine(),
+		Sessions:           s.nconns.Get(),
+		QueuedSessions:     s.queued.Get(),
+		BackendConnections: s.nbackends.Get(),
+		BufferedBytes:      s.buffered.Get(),
+	}
+	stats.OpenFiles, stats.MaxOpenFiles = descriptorUsage()
+
+	if s.pool != nil {
+		stats.Monitors, stats.Backends = s.pool.Monitors(), len(s.pool.Backends())
+	}
+	for _, p := range s.clusters {
+		stats.Monitors += p.Monitors()
+		stats.Backends += len(p.Backends())
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats.HeapAllocBytes, stats.HeapObjects, stats.SysBytes = ms.HeapAlloc, ms.HeapObjects, ms.Sys
+	stats.GCCycles = ms.NumGC
+	if ms.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(ms.LastGC))
+	}
+
+	return stats
+}
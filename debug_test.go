@@ -0,0 +1,47 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleDebug(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &server{pool: pool.New(ctx, pool.WithManualChecks(time.Now))}
+	s.pool.Put(pool.NewBackend([]string{"127.0.0.1:1"}, pool.PostgresSettings{}))
+	s.nconns.Add(3)
+
+	mux := http.NewServeMux()
+	s.handleDebug(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var stats runtimeStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Expected the runtime counters as JSON; instead got %v", err)
+	}
+	if stats.Goroutines == 0 || stats.Monitors != 1 || stats.Backends != 1 || stats.Sessions != 3 || stats.HeapAllocBytes == 0 {
+		t.Errorf("Expected the runtime counters; instead got %+v", stats)
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the goroutine profile; instead got %s", resp.Status)
+	}
+}
@@ -0,0 +1,585 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// desiredState is a full description of the pool, as PUT to /config to have arbiter
+// reconcile the pool with it, and as served by GET /config.
+type desiredState struct {
+	Backends map[string]*desiredBackend `json:"backends"`
+
+	// Classes left out are left as they are, since listeners may be routing by them.
+	Classes map[string]*desiredClass `json:"classes,omitempty"`
+}
+
+type desiredBackend struct {
+	// The addresses of the backend, in order of preference.
+	Address []string       `json:"address"`
+	Labels  metrics.Labels `json:"labels,omitempty"`
+	Drained bool           `json:"drained,omitempty"`
+
+	// How the backend ranks for promotion; see pool.PromotionInfo.  Priority is 1 if
+	// left out.
+	Priority    *int   `json:"priority,omitempty"`
+	Zone        string `json:"zone,omitempty"`
+	Synchronous bool   `json:"synchronous,omitempty"`
+	WriteGroup  string `json:"write_group,omitempty"`
+
+	// Caps the sessions of the backend; see pool.LimitConnections().  The default if
+	// zero, and no cap if negative.
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	// The share of sessions balanced onto the backend; see pool.SetWeight().  1 if
+	// left out.
+	Weight *float64 `json:"weight,omitempty"`
+
+	// The settings the backend is monitored with; those of [health] for backends
+	// declared through /config.  check is what they were parsed from, if the
+	// backend was declared by the configuration file.
+	settings pool.PostgresSettings
+	check    *CheckSettings
+}
+
+// Whether the configuration file changed the health check settings of the backend, or
+// the password, which a password-file or password-env changes without it.
+func (b *desiredBackend) reconfigured(have *desiredBackend) bool {
+	return b.check != nil && have.check != nil &&
+		(!reflect.DeepEqual(*b.check, *have.check) || b.settings.Password != have.settings.Password)
+}
+
+type desiredClass struct {
+	PrimaryOnly   bool   `json:"primary_only,omitempty"`
+	MaxLag        string `json:"max_lag,omitempty"`
+	FollowersOnly bool   `json:"followers_only,omitempty"`
+	QuorumOnly    bool   `json:"quorum_only,omitempty"`
+	PreferQuorum  bool   `json:"prefer_quorum,omitempty"`
+	Balance       string `json:"balance,omitempty"`
+	WriteGroup    string `json:"write_group,omitempty"`
+	maxLag        time.Duration
+	balance       pool.Balance
+}
+
+func (c *desiredClass) class() pool.Class {
+	return pool.Class{PrimaryOnly: c.PrimaryOnly, MaxLag: c.maxLag, FollowersOnly: c.FollowersOnly,
+		QuorumOnly: c.QuorumOnly, PreferQuorum: c.PreferQuorum, Balance: c.balance, WriteGroup: c.WriteGroup}
+}
+
+// declaration is the state last declared, by the configuration file or through
+// PUT /config, which the pool is reconciled with.
+type declaration struct {
+	sync.Mutex
+	state desiredState
+
+	// The settings of backends declared through /config.
+	settings pool.PostgresSettings
+}
+
+// Return the state declared by the configuration file c.
+func newDeclaration(c *Config) *declaration {
+	d := &declaration{
+		state: desiredState{
+			Backends: make(map[string]*desiredBackend),
+			Classes:  make(map[string]*desiredClass),
+		},
+		settings: c.Health.settings,
+	}
+
+	for _, addr := range c.Main.Backends {
+		d.state.Backends[addr] = &desiredBackend{Address: []string{addr}, settings: c.Health.settings,
+			check: &c.Health.CheckSettings}
+	}
+
+	for name, b := range c.Backend {
+		priority, weight := b.promotion.Priority, b.weight
+		check := b.CheckSettings.inherit(c.Health.CheckSettings)
+		d.state.Backends[name] = &desiredBackend{
+			Address:     b.Address,
+			Labels:      b.labels,
+			Priority:    &priority,
+			Zone:        b.promotion.Zone,
+			Synchronous: b.promotion.Synchronous,
+			WriteGroup:  b.promotion.WriteGroup,
+			settings:    b.settings,
+			check:       &check,
+
+			MaxConnections: b.MaxConnections,
+			Weight:         &weight,
+		}
+	}
+
+	for name, class := range c.Class {
+		d.state.Classes[name] = &desiredClass{
+			PrimaryOnly:   class.PrimaryOnly,
+			MaxLag:        class.MaxLag,
+			FollowersOnly: class.FollowersOnly,
+			QuorumOnly:    class.QuorumOnly,
+			PreferQuorum:  class.PreferQuorum,
+			Balance:       class.Balance,
+			WriteGroup:    class.WriteGroup,
+			maxLag:        class.maxLag,
+			balance:       class.balance,
+		}
+	}
+
+	return d
+}
+
+// Check ds, filling in the defaults it leaves out.
+func (ds *desiredState) validate() error {
+	seen := make(map[string]string)
+	for name, b := range ds.Backends {
+		if b == nil || len(b.Address) == 0 {
+			return fmt.Errorf("backend %s has no address", name)
+		}
+
+		for _, addr := range b.Address {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid backend %s '%s': %s", name, addr, err)
+			}
+			if other, ok := seen[addr]; ok {
+				return fmt.Errorf("backends %s and %s share the address %s", other, name, addr)
+			}
+			seen[addr] = name
+		}
+
+		for label := range b.Labels {
+			if !labelNameRe.MatchString(label) {
+				return fmt.Errorf("backend %s: invalid label name '%s'", name, label)
+			}
+		}
+
+		if b.Priority == nil {
+			priority := 1
+			b.Priority = &priority
+		}
+		if b.Weight == nil {
+			weight := 1.0
+			b.Weight = &weight
+		}
+		if *b.Weight < 0 {
+			return fmt.Errorf("backend %s: %s", name, pool.ErrInvalidWeight)
+		}
+	}
+
+	for name, class := range ds.Classes {
+		if class == nil {
+			return fmt.Errorf("class %s is empty", name)
+		}
+
+		if class.MaxLag != "" {
+			var err error
+			if class.maxLag, err = time.ParseDuration(class.MaxLag); err != nil {
+				return fmt.Errorf("class %s: invalid max_lag '%s'", name, class.MaxLag)
+			}
+		}
+
+		var err error
+		if class.balance, err = parseBalance(class.Balance); err != nil {
+			return fmt.Errorf("class %s: %s", name, err)
+		}
+		if class.WriteGroup != "" && !class.PrimaryOnly {
+			return fmt.Errorf("class %s: write_group requires primary_only", name)
+		}
+	}
+
+	return nil
+}
+
+// The actions of a change.  Removing and draining backends are destructive, and held for
+// approval in approval mode.
+const (
+	actionAdd         = "add"
+	actionRemove      = "remove"
+	actionReaddress   = "readdress"
+	actionReconfigure = "reconfigure"
+	actionLabel       = "label"
+	actionPromotion   = "promotion"
+	actionDrain       = "drain"
+	actionResume      = "resume"
+	actionDefine      = "define"
+	actionLimit       = "limit"
+	actionWeight      = "weight"
+)
+
+// change is a step of reconciling the pool with a desired state.
+type change struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+
+	run func() error
+}
+
+func (c change) destructive() bool {
+	return c.Action == actionRemove || c.Action == actionDrain
+}
+
+// Return the changes that reconcile the pool with ds, in order of backend and then
+// class names.  Backends are added, removed, drained and resumed according to the
+// pool, and changed according to the declared state.  d must be locked.
+func (s *server) plan(d *declaration, ds desiredState) []change {
+	var changes []change
+
+	present := make(map[string]bool)
+	draining := make(map[string]bool)
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		present[b.Name] = true
+		draining[b.Name] = b.Draining
+		return true
+	})
+
+	var names []string
+	for name := range present {
+		if ds.Backends[name] == nil {
+			names = append(names, name)
+		}
+	}
+	for name := range ds.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		name, want, have := name, ds.Backends[name], d.state.Backends[name]
+
+		switch {
+		case want == nil:
+			changes = append(changes, change{Action: actionRemove, Target: name, run: func() error {
+				s.perBackendLabels.set(name, nil)
+				if s.load != nil {
+					s.load.forget(name)
+				}
+				return s.pool.Remove(name)
+			}})
+			continue
+
+		case !present[name]:
+			settings := d.settings
+			if want.check != nil {
+				settings = want.settings
+			}
+			changes = append(changes, change{Action: actionAdd, Target: name,
+				Detail: strings.Join(want.Address, ","), run: func() error {
+					return s.pool.PutNamed(name, pool.NewBackend(want.Address, settings))
+				}})
+			have = &desiredBackend{}
+
+		case have == nil:
+			// Registered, but not as declared, as when an apply failed half-way.
+			have = &desiredBackend{settings: d.settings}
+			fallthrough
+
+		case !sameAddresses(want.Address, have.Address) || want.reconfigured(have):
+			action, settings := actionReaddress, have.settings
+			if want.reconfigured(have) {
+				action, settings = actionReconfigure, want.settings
+			}
+			changes = append(changes, change{Action: action, Target: name,
+				Detail: strings.Join(want.Address, ","), run: func() error {
+					if len(want.Address) == 1 && action == actionReaddress {
+						return s.pool.UpdateAddress(name, want.Address[0])
+					}
+
+					// The pool only readdresses backends with a single address, and
+					// can't change how a backend is checked, so the backend is replaced.
+					if err := s.pool.Remove(name); err != nil {
+						return err
+					}
+					err := s.pool.PutNamed(name, pool.NewBackend(want.Address, settings))
+					if err != nil {
+						return err
+					}
+					if want.Drained {
+						s.pool.Drain(name)
+					}
+					s.pool.LimitConnections(name, want.MaxConnections)
+					s.pool.SetWeight(name, want.weight())
+					return s.pool.SetPromotionInfo(name, want.promotion())
+				}})
+		}
+
+		if want.Labels.String() != have.Labels.String() {
+			changes = append(changes, change{Action: actionLabel, Target: name,
+				Detail: want.Labels.String(), run: func() error {
+					s.perBackendLabels.set(name, want.Labels)
+					return nil
+				}})
+		}
+
+		if want.promotion() != have.promotion() {
+			changes = append(changes, change{Action: actionPromotion, Target: name,
+				Detail: fmt.Sprintf("%+v", want.promotion()), run: func() error {
+					return s.pool.SetPromotionInfo(name, want.promotion())
+				}})
+		}
+
+		if want.MaxConnections != have.MaxConnections {
+			changes = append(changes, change{Action: actionLimit, Target: name,
+				Detail: strconv.Itoa(want.MaxConnections), run: func() error {
+					return s.pool.LimitConnections(name, want.MaxConnections)
+				}})
+		}
+
+		if want.weight() != have.weight() {
+			changes = append(changes, change{Action: actionWeight, Target: name,
+				Detail: strconv.FormatFloat(want.weight(), 'g', -1, 64), run: func() error {
+					return s.pool.SetWeight(name, want.weight())
+				}})
+		}
+
+		switch {
+		case want.Drained && !draining[name]:
+			changes = append(changes, change{Action: actionDrain, Target: name, run: func() error {
+				return s.pool.Drain(name)
+			}})
+		case !want.Drained && draining[name]:
+			changes = append(changes, change{Action: actionResume, Target: name, run: func() error {
+				return s.pool.Resume(name)
+			}})
+		}
+	}
+
+	names = names[:0]
+	for name := range ds.Classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want, have := ds.Classes[name], d.state.Classes[name]
+		if have != nil && want.class() == have.class() {
+			continue
+		}
+
+		class := want.class()
+		changes = append(changes, change{Action: actionDefine, Target: name,
+			Detail: fmt.Sprintf("%+v", class), run: func() error {
+				s.pool.DefineClass(name, class)
+				for _, p := range s.clusters {
+					p.DefineClass(name, class)
+				}
+				return nil
+			}})
+	}
+
+	return changes
+}
+
+// Reconcile the pool with ds, returning the changes made.  Applying a state that's
+// already in effect changes nothing.  Should a change fail, those after it aren't made,
+// and the declared state is left as it was, so that applying ds again picks up where
+// this left off.
+func (s *server) apply(ds desiredState) ([]change, error) {
+	d := s.declared
+	d.Lock()
+	defer d.Unlock()
+
+	changes := s.plan(d, ds)
+	for i, c := range changes {
+		s.logger.Printf("Config: %s", strings.TrimSpace(c.Action+" "+c.Target+" "+c.Detail))
+		if err := c.run(); err != nil {
+			return changes[:i], fmt.Errorf("%s %s: %s", c.Action, c.Target, err)
+		}
+	}
+
+	// Backends keep the settings they were added with, unless declared by the
+	// configuration file, whose settings are now in effect.
+	for name, b := range ds.Backends {
+		if b.check != nil {
+			continue
+		}
+
+		b.settings = d.settings
+		if have := d.state.Backends[name]; have != nil {
+			b.settings, b.check = have.settings, have.check
+		}
+	}
+
+	if ds.Classes == nil {
+		ds.Classes = make(map[string]*desiredClass)
+	}
+	for name, class := range d.state.Classes {
+		if ds.Classes[name] == nil {
+			ds.Classes[name] = class
+		}
+	}
+	d.state = ds
+
+	return changes, nil
+}
+
+// Serve /config.  GET returns the declared state, and PUT reconciles the pool with the
+// desired state in the body, answering with the changes made; with dry_run=true, with
+// those that would be made.  In approval mode, a state that removes or drains backends
+// is only requested, and answered with the pending operation; see approvals.
+func (s *server) handleConfig(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.declared.Lock()
+		defer s.declared.Unlock()
+		writeJSON(w, http.StatusOK, s.declared.state)
+		return
+	case http.MethodPut:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ds desiredState
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ds); err != nil {
+		http.Error(w, fmt.Sprintf("invalid desired state: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := ds.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.reconcile(w, req, ds)
+}
+
+// Reconcile the pool with ds, which is valid, answering req as handleConfig().
+func (s *server) reconcile(w http.ResponseWriter, req *http.Request, ds desiredState) {
+	s.declared.Lock()
+	changes := s.plan(s.declared, ds)
+	s.declared.Unlock()
+
+	if dryRun, _ := strconv.ParseBool(req.FormValue("dry_run")); dryRun {
+		writeJSON(w, http.StatusOK, changeList(changes))
+		return
+	}
+
+	var wait time.Duration
+	if v := req.FormValue("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			http.Error(w, fmt.Sprintf("invalid wait '%s'", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, op, err := s.applyAs(strings.TrimSpace(req.Header.Get(operatorHeader)), ds)
+	switch {
+	case err == errNoOperator:
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case op != nil:
+		writeJSON(w, http.StatusAccepted, op)
+		return
+	}
+	if wait > 0 {
+		s.waitForChecks(changes, wait)
+	}
+
+	writeJSON(w, http.StatusOK, changeList(changes))
+}
+
+// Reconcile the pool with ds, which is valid, on behalf of operator, returning the
+// changes made.  In approval mode, a state that removes or drains backends is only
+// requested, returning the pending operation, and operator is required for it.
+func (s *server) applyAs(operator string, ds desiredState) ([]change, *operation, error) {
+	s.declared.Lock()
+	changes := s.plan(s.declared, ds)
+	s.declared.Unlock()
+
+	var destructive []string
+	for _, c := range changes {
+		if c.destructive() {
+			destructive = append(destructive, c.Action+" "+c.Target)
+		}
+	}
+
+	if s.approvals != nil && len(destructive) > 0 {
+		if operator == "" {
+			return nil, nil, errNoOperator
+		}
+
+		op := s.approvals.request(operator, "config", strings.Join(destructive, ","), func() error {
+			_, err := s.apply(ds)
+			return err
+		})
+		return nil, &op, nil
+	}
+
+	changes, err := s.apply(ds)
+	return changes, nil, err
+}
+
+// Wait up to d for the backends that changes registered anew, as added or replaced, to
+// be health checked once, so that they're routable, or known not to be, once answered.
+func (s *server) waitForChecks(changes []change, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	for _, c := range changes {
+		switch c.Action {
+		case actionAdd, actionReaddress, actionReconfigure:
+			if err := s.pool.WaitForCheck(ctx, c.Target); errors.Is(err, context.DeadlineExceeded) {
+				s.logger.Printf("%s was not checked within %s", c.Target, d)
+				return
+			}
+		}
+	}
+}
+
+// Never render an empty list of changes as null, so that clients can tell that nothing
+// needed to change.
+func changeList(changes []change) []change {
+	if changes == nil {
+		return []change{}
+	}
+
+	return changes
+}
+
+// The promotion info declared for b.
+func (b *desiredBackend) promotion() pool.PromotionInfo {
+	info := pool.PromotionInfo{Priority: 1, Zone: b.Zone, Synchronous: b.Synchronous, WriteGroup: b.WriteGroup}
+	if b.Priority != nil {
+		info.Priority = *b.Priority
+	}
+
+	return info
+}
+
+// The weight declared for b.
+func (b *desiredBackend) weight() float64 {
+	if b.Weight == nil {
+		return 1
+	}
+
+	return *b.Weight
+}
+
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
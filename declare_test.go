@@ -0,0 +1,199 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyConfig(t *testing.T) {
+	priority := 1
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared: &declaration{state: desiredState{Backends: map[string]*desiredBackend{
+			"pg1": {Address: []string{"127.0.0.1:5432"}, Priority: &priority},
+		}}},
+	}
+	s.pool.PutNamed("pg1", &queueBackend{})
+
+	put := func(query, operator, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/config"+query, strings.NewReader(body))
+		if operator != "" {
+			req.Header.Set(operatorHeader, operator)
+		}
+
+		w := httptest.NewRecorder()
+		s.handleConfig(w, req)
+		return w
+	}
+
+	actions := func(w *httptest.ResponseRecorder) string {
+		var changes []change
+		if err := json.Unmarshal(w.Body.Bytes(), &changes); err != nil {
+			t.Fatalf("Expected a list of changes; instead got %d: %s", w.Code, w.Body)
+		}
+
+		var ret []string
+		for _, c := range changes {
+			ret = append(ret, c.Action+" "+c.Target)
+		}
+		return strings.Join(ret, ",")
+	}
+
+	desired := `{
+		"backends": {
+			"pg1": {"address": ["127.0.0.1:5432"], "labels": {"dc": "a"}},
+			"pg2": {"address": ["127.0.0.1:1"], "priority": 2, "zone": "b"}
+		},
+		"classes": {"fresh": {"max_lag": "1s"}}
+	}`
+
+	w := put("", "", desired)
+	if got, want := actions(w), "label pg1,add pg2,promotion pg2,define fresh"; got != want {
+		t.Fatalf("Expected changes %q; instead got %q", want, got)
+	}
+
+	if got := s.perBackendLabels.get("pg1").String(); got != `{dc="a"}` {
+		t.Fatalf("Expected pg1 to be labeled; instead got %s", got)
+	}
+
+	if _, err := s.pool.GetForClass("fresh"); err == pool.ErrUnknownClass {
+		t.Fatalf("Expected the class to be defined")
+	}
+
+	if w := put("", "", desired); w.Code != http.StatusOK || actions(w) != "" {
+		t.Fatalf("Expected applying the same state again to change nothing; instead got %d: %s", w.Code, w.Body)
+	}
+
+	removal := `{"backends": {"pg1": {"address": ["127.0.0.1:5432"], "labels": {"dc": "a"}}}}`
+	if w := put("?dry_run=true", "", removal); actions(w) != "remove pg2" {
+		t.Fatalf("Expected a plan to remove pg2; instead got %s", w.Body)
+	}
+
+	if n := len(s.pool.Backends()); n != 2 {
+		t.Fatalf("Expected a dry run to change nothing; instead there are %d backends", n)
+	}
+
+	s.approvals = newApprovals(time.Minute, log.Default())
+	if w := put("", "", removal); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected the operator to be required in approval mode; instead got %d", w.Code)
+	}
+	if w := put("", "alice", removal); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the removal to be held for approval; instead got %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := s.approvals.decide("1", "bob", true); err != nil {
+		t.Fatalf("Expected the removal to be approved; instead got %v", err)
+	}
+
+	if bs := s.pool.Backends(); len(bs) != 1 || bs[0].Name != "pg1" {
+		t.Fatalf("Expected only pg1 to be left; instead got %+v", bs)
+	}
+
+	if w := put("", "", `{"backends": {"pg1": {"address": ["nope"]}}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected an invalid state to be refused; instead got %d", w.Code)
+	}
+}
+
+func TestBackendsAPI(t *testing.T) {
+	priority := 1
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared: &declaration{state: desiredState{Backends: map[string]*desiredBackend{
+			"pg1": {Address: []string{"127.0.0.1:5432"}, Priority: &priority},
+		}}},
+	}
+	pg1 := &queueBackend{}
+	s.pool.PutNamed("pg1", pg1)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		if req.URL.Path == "/backends" {
+			s.handleBackends(w, req)
+		} else {
+			s.handleBackend(w, req)
+		}
+		return w
+	}
+
+	if w := do("POST", "/backends?wait=soon", `{"name": "pg2", "address": ["127.0.0.1:1"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid wait to be rejected; instead got %d: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/backends?wait=5s", `{"name": "pg2", "address": ["127.0.0.1:1"]}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"add"`) {
+		t.Fatalf("Expected pg2 to be added; instead got %d: %s", w.Code, w.Body)
+	}
+	// Nothing listens on pg2's address; it was answered once its check failed.
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		if b.Name == "pg2" && b.LastError == "" {
+			t.Errorf("Expected pg2 to have been checked")
+		}
+		return true
+	})
+	if w := do("POST", "/backends", `{"name": "pg2", "address": ["127.0.0.1:2"]}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected pg2 to exist already; instead got %d: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/backends", `{"address": ["127.0.0.1:5432"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the address of pg1 to be rejected; instead got %d: %s", w.Code, w.Body)
+	}
+
+	var backends []backendStats
+	if err := json.Unmarshal(do("GET", "/backends", "").Body.Bytes(), &backends); err != nil || len(backends) != 2 {
+		t.Errorf("Expected pg1 and pg2 to be listed; instead got %v, %v", backends, err)
+	}
+
+	// A promotion is noticed as soon as pg1 is checked.
+	pg1.promote()
+	var checked []backendStats
+	if w := do("POST", "/backends/pg1/check", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected pg1 to be checked; instead got %d: %s", w.Code, w.Body)
+	} else if err := json.Unmarshal(w.Body.Bytes(), &checked); err != nil || len(checked) != 1 || checked[0].State != pool.READ_WRITE.String() {
+		t.Errorf("Expected pg1 to be checked as the primary; instead got %v, %v", checked, err)
+	}
+	if w := do("POST", "/backends/pg3/check", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected pg3 not to be found; instead got %d: %s", w.Code, w.Body)
+	}
+
+	if w := do("POST", "/backends/pg1/drain", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected pg1 to be drained; instead got %d: %s", w.Code, w.Body)
+	}
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		if b.Name == "pg1" && !b.Draining {
+			t.Errorf("Expected pg1 to be draining")
+		}
+		return true
+	})
+
+	if w := do("POST", "/backends/127.0.0.1:1/cordon?grace=1h", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected pg2 to be cordoned; instead got %d: %s", w.Code, w.Body)
+	}
+	if w := do("POST", "/backends/pg2/cordon?grace=soon", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid grace to be rejected; instead got %d: %s", w.Code, w.Body)
+	}
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		if b.Name == "pg2" && (!b.Cordoned || b.Draining) {
+			t.Errorf("Expected pg2 to be cordoned, and not yet draining")
+		}
+		return true
+	})
+
+	if w := do("DELETE", "/backends/127.0.0.1:1", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"remove"`) {
+		t.Errorf("Expected pg2 to be removed by address; instead got %d: %s", w.Code, w.Body)
+	}
+	if w := do("DELETE", "/backends/pg3", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected pg3 not to be found; instead got %d: %s", w.Code, w.Body)
+	}
+	if _, ok := s.declared.state.Backends["pg2"]; ok {
+		t.Errorf("Expected pg2 to be removed from the declared state")
+	}
+}
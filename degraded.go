@@ -0,0 +1,293 @@
+package arbiter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// How a listener handles a degraded mode of the pool.
+const (
+	// Hold the session until the mode passes, up to the queue timeout.
+	behaviorQueue = "queue"
+
+	// Route the session to any available backend, or, for STALE_VIEW, according to
+	// the last known state.
+	behaviorFallback = "fallback"
+
+	// Turn the session away.
+	behaviorReject = "reject"
+
+	// Turn a share of the sessions away, and route the rest as usual; only for
+	// few-replicas.
+	behaviorShed = "shed"
+)
+
+// The parameter a session routed in a degraded mode is told of it by.
+const modeParameter = "arbiter.mode"
+
+// SQLSTATE codes sent to clients turned away because the pool is degraded.
+var degradedSQLState = map[pool.Mode]string{
+	pool.NO_PRIMARY:   sqlstateCannotConnectNow,
+	pool.NO_REPLICAS:  sqlstateCannotConnectNow,
+	pool.STALE_VIEW:   sqlstateUnableToConnect,
+	pool.SATURATED:    sqlstateTooManyConnections,
+	pool.FEW_REPLICAS: sqlstateCannotConnectNow,
+}
+
+// errRefused ends a session turned away because the pool is degraded.
+var errRefused = errors.New("refused in degraded mode")
+
+// DegradedSettings configure how a listener handles the degraded modes of the pool.
+// They're given in [main] for the primary and follower listeners, and inherited by
+// [listener "name"] sections that leave them out.
+type DegradedSettings struct {
+	NoPrimary  string `gcfg:"no-primary"`
+	NoReplicas string `gcfg:"no-replicas"`
+	StaleView  string `gcfg:"stale-view"`
+	Saturated  string
+
+	// Sessions of a class that followers may serve are handled by FewReplicas while
+	// fewer than MinReplicas followers satisfy it; with shed, ShedReads of them, from
+	// 0 to 1, are turned away.  The default MinReplicas of zero never does.
+	MinReplicas int     `gcfg:"min-replicas"`
+	FewReplicas string  `gcfg:"few-replicas"`
+	ShedReads   float64 `gcfg:"shed-reads"`
+}
+
+// Return s with the settings it leaves out taken from defaults.
+func (s DegradedSettings) inherit(defaults DegradedSettings) DegradedSettings {
+	if s.NoPrimary == "" {
+		s.NoPrimary = defaults.NoPrimary
+	}
+	if s.NoReplicas == "" {
+		s.NoReplicas = defaults.NoReplicas
+	}
+	if s.StaleView == "" {
+		s.StaleView = defaults.StaleView
+	}
+	if s.Saturated == "" {
+		s.Saturated = defaults.Saturated
+	}
+	if s.MinReplicas == 0 {
+		s.MinReplicas = defaults.MinReplicas
+	}
+	if s.FewReplicas == "" {
+		s.FewReplicas = defaults.FewReplicas
+	}
+	if s.ShedReads == 0 {
+		s.ShedReads = defaults.ShedReads
+	}
+
+	return s
+}
+
+// degradedPolicy is how a listener handles the degraded modes of the pool: a behavior
+// for each mode, and the followers below which its sessions are in FEW_REPLICAS.
+type degradedPolicy struct {
+	behavior    map[pool.Mode]string
+	minReplicas int
+	shedReads   float64
+}
+
+// Convert s to a policy, checking that it's valid.
+func (s DegradedSettings) policy() (degradedPolicy, error) {
+	ret := degradedPolicy{
+		behavior: map[pool.Mode]string{
+			pool.NO_PRIMARY:   s.NoPrimary,
+			pool.NO_REPLICAS:  s.NoReplicas,
+			pool.STALE_VIEW:   s.StaleView,
+			pool.SATURATED:    s.Saturated,
+			pool.FEW_REPLICAS: s.FewReplicas,
+		},
+		minReplicas: s.MinReplicas,
+		shedReads:   s.ShedReads,
+	}
+
+	for mode, b := range ret.behavior {
+		switch {
+		case b == behaviorQueue && (mode == pool.STALE_VIEW || mode == pool.FEW_REPLICAS):
+			return degradedPolicy{}, fmt.Errorf("%s can't be %s", modeSetting(mode), b)
+		case b == behaviorFallback && mode == pool.SATURATED:
+			return degradedPolicy{}, fmt.Errorf("saturated can't be %s", b)
+		case b == behaviorShed && mode == pool.FEW_REPLICAS:
+		case b != behaviorQueue && b != behaviorFallback && b != behaviorReject:
+			return degradedPolicy{}, fmt.Errorf("invalid behavior '%s' for %s", b, mode)
+		}
+	}
+	if s.MinReplicas < 0 {
+		return degradedPolicy{}, errors.New("min-replicas can't be negative")
+	}
+	if s.ShedReads < 0 || s.ShedReads > 1 {
+		return degradedPolicy{}, errors.New("shed-reads must be from 0 to 1")
+	}
+
+	return ret, nil
+}
+
+// Return the setting of the behavior for mode.
+func modeSetting(mode pool.Mode) string {
+	return strings.ReplaceAll(strings.ToLower(mode.String()), "_", "-")
+}
+
+// Whether sessions of class may only be routed to the primary.
+func primaryOnly(class string, c *Config) bool {
+	return class == "strong" || c.Class[class] != nil && c.Class[class].PrimaryOnly
+}
+
+// route is how a listener routes its sessions.
+type route struct {
+	listener string
+	class    string
+	degradedPolicy
+
+	// The pool of the cluster the listener is bound to; see cluster().
+	pool *pool.Pool
+
+	// The configuration to terminate TLS with, or nil to pass it through.
+	tls *tls.Config
+
+	// Whether clients come with the PROXY protocol header, and sessions go with one.
+	proxy proxyConfig
+
+	// Nil unless some mode is handled by queueing.
+	queue *sessionQueue
+
+	// Whether sessions are started with the fencing epoch; see fenceStartup().
+	fenced bool
+
+	// The routes of sessions of some users or databases; see routeByStartup().
+	rules []routingRule
+
+	// The clients the listener accepts; nil if it accepts any.
+	acl *listenerACL
+
+	// Whether the cluster is of MySQL servers, whose sessions are passed on without
+	// reading a startup packet; see mysqlClient.
+	mysql bool
+}
+
+// Return the route of the named listener bound to class of cluster, or of the backends
+// in [main] if it's empty, handling degraded modes according to policy, and
+// terminating TLS with tlsConfig unless it's nil, and taking and sending the PROXY
+// protocol header according to proxy.  Only sessions of the backends in [main] are
+// fenced.  Sessions matching a [rule] are routed by it instead.
+func (s *server) newRoute(listener, class, cluster string, policy degradedPolicy, tlsConfig *tls.Config,
+	proxy proxyConfig, c *Config) *route {
+	r := s.routeTo(listener, class, cluster, policy, tlsConfig, proxy, c)
+	r.rules = s.routingRules(r, class, cluster, c)
+	r.acl = newListenerACL(listener, c)
+
+	return r
+}
+
+// newRoute, without the rules.  Sessions of primary-only classes are never held to
+// min-replicas.
+func (s *server) routeTo(listener, class, cluster string, policy degradedPolicy, tlsConfig *tls.Config,
+	proxy proxyConfig, c *Config) *route {
+	if primaryOnly(class, c) {
+		policy.minReplicas = 0
+	}
+	r := &route{listener: listener, class: class, degradedPolicy: policy, pool: s.cluster(cluster), tls: tlsConfig,
+		proxy: proxy, fenced: cluster == "" && fenced(class, c), mysql: c.mysql(cluster)}
+
+	for _, b := range policy.behavior {
+		if b == behaviorQueue && r.queue == nil {
+			r.queue = newSessionQueue(r.pool, class, c.Limits.MaxQueuedSessions, c.Limits.queueTimeout)
+			go r.queue.run()
+		}
+	}
+
+	return r
+}
+
+// Lease a backend for a client according to r, within the trace of ctx, handling the
+// degraded mode of the pool if any.  Returns the client's connection to proxy from, and the mode the session was
+// routed in.
+func (s *server) acquire(ctx context.Context, conn net.Conn, r *route) (net.Conn, *pool.Lease, pool.Mode, error) {
+	mode := pool.HEALTHY
+	if r.pool.Stale() {
+		mode = pool.STALE_VIEW
+		if r.behavior[mode] == behaviorReject {
+			return nil, nil, mode, s.refuse(conn, mode)
+		}
+	}
+
+	zone := s.clientZones.lookup(conn.RemoteAddr())
+	if zone == "" {
+		zone = s.zone
+	}
+
+	if r.minReplicas > 0 {
+		if n, err := r.pool.Followers(r.class); err == nil && n < r.minReplicas {
+			mode = pool.FEW_REPLICAS
+			switch r.behavior[mode] {
+			case behaviorFallback:
+				lease, err := s.dial(ctx, r.pool, "strong", zone)
+				var degraded *pool.DegradedError
+				if errors.As(err, &degraded) {
+					err = s.refuse(conn, mode)
+				}
+				return conn, lease, mode, err
+			case behaviorShed:
+				if rand.Float64() >= r.shedReads {
+					break
+				}
+				fallthrough
+			default:
+				return nil, nil, mode, s.refuse(conn, mode)
+			}
+		}
+	}
+
+	lease, err := s.dial(ctx, r.pool, r.class, zone)
+
+	var degraded *pool.DegradedError
+	if !errors.As(err, &degraded) {
+		return conn, lease, mode, err
+	}
+
+	mode = degraded.Mode
+	switch r.behavior[mode] {
+	case behaviorQueue:
+		conn, lease, err = s.enqueue(conn, r.queue, mode)
+	case behaviorFallback:
+		if lease, err = s.dial(ctx, r.pool, "eventual", zone); errors.As(err, &degraded) {
+			err = s.refuse(conn, mode)
+		}
+	default:
+		err = s.refuse(conn, mode)
+	}
+
+	return conn, lease, mode, err
+}
+
+// Lease a backend of p that satisfies class, preferring those in zone if it isn't
+// empty, within the trace of ctx.
+func (s *server) dial(ctx context.Context, p *pool.Pool, class, zone string) (*pool.Lease, error) {
+	ctx, cancel := context.WithTimeout(pool.InZone(ctx, zone), 5*time.Second)
+	defer cancel()
+
+	return p.Acquire(ctx, class)
+}
+
+// Turn a client away because the pool is in a degraded mode, telling it which.
+func (s *server) refuse(conn net.Conn, mode pool.Mode) error {
+	s.refused.Add(1)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	msg := "arbiter: no backend available"
+	if mode == pool.FEW_REPLICAS {
+		msg = "arbiter: too few replicas available"
+	}
+	writeFatalHint(conn, degradedSQLState[mode], msg,
+		fmt.Sprintf("%s=%s; retry shortly", modeParameter, mode))
+
+	return fmt.Errorf("%w (%s)", errRefused, mode)
+}
@@ -0,0 +1,85 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFewReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &queueBackend{primary: true}
+	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+	p.Put(b)
+	p.CheckAll()
+	s := &server{pool: p}
+
+	acquire := func(r *route) (string, pool.Mode, error) {
+		client, frontend := net.Pipe()
+		defer client.Close()
+		told := make(chan string)
+		go func() {
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			b, _ := io.ReadAll(client)
+			told <- string(b)
+		}()
+
+		_, lease, mode, err := s.acquire(ctx, frontend, r)
+		if lease != nil {
+			lease.Release(nil)
+		}
+		frontend.Close()
+		return <-told, mode, err
+	}
+
+	for _, test := range []struct {
+		behavior  string
+		shedReads float64
+		refused   bool
+	}{
+		{behaviorFallback, 0, false},
+		{behaviorReject, 0, true},
+		{behaviorShed, 1, true},
+		{behaviorShed, 0, false},
+	} {
+		policy, err := DegradedSettings{NoPrimary: behaviorReject, NoReplicas: behaviorFallback, StaleView: behaviorFallback,
+			Saturated: behaviorReject, MinReplicas: 1, FewReplicas: test.behavior, ShedReads: test.shedReads}.policy()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		told, mode, err := acquire(&route{class: "eventual", degradedPolicy: policy, pool: p})
+		if mode != pool.FEW_REPLICAS {
+			t.Errorf("Expected %s to route in FEW_REPLICAS; instead got %s", test.behavior, mode)
+		}
+		if refused := errors.Is(err, errRefused); refused != test.refused {
+			t.Errorf("Expected %s with shed-reads %v to refuse: %v; instead got %v", test.behavior, test.shedReads,
+				test.refused, err)
+		}
+		if test.refused && !strings.Contains(told, "too few replicas") {
+			t.Errorf("Expected the client to be told of too few replicas; instead got %q", told)
+		}
+	}
+
+	// Primary-only classes aren't held to min-replicas.
+	c, err := LoadConfig("./config.ini", nil, []string{"main.min-replicas=1", "main.few-replicas=reject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.routeTo("primary", "strong", "", c.Main.degraded, nil, proxyConfig{}, c)
+	if _, mode, err := acquire(r); mode != pool.HEALTHY || err != nil {
+		t.Errorf("Expected strong to route as usual; instead got %s, %v", mode, err)
+	}
+
+	for _, set := range []string{"main.few-replicas=queue", "main.shed-reads=1.5", "main.min-replicas=-1"} {
+		if _, err := LoadConfig("./config.ini", nil, []string{set}); err == nil {
+			t.Errorf("Expected %s to be rejected", set)
+		}
+	}
+}
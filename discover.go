@@ -0,0 +1,82 @@
+package arbiter
+
+import (
+	"sync"
+)
+
+// discovery keeps the backends discovered from a service registry, such as Consul or
+// Kubernetes, in the declared state: they're added, readdressed and removed as the
+// registry says, and kept across reloads of the configuration file.  Backends declared
+// otherwise under the same name take precedence.
+type discovery struct {
+	s *server
+
+	// The registry, as named in logs.
+	source string
+
+	// Guards discovered, the backends last discovered, by name.
+	mu         sync.Mutex
+	discovered map[string]*desiredBackend
+}
+
+func newDiscovery(s *server, source string) *discovery {
+	d := &discovery{s: s, source: source}
+	s.discoveries = append(s.discoveries, d)
+	return d
+}
+
+// Reconcile the declared state with backends, those the registry lists now.
+func (d *discovery) update(backends map[string]*desiredBackend) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ds := d.s.declared.copyState()
+	d.merge(ds, backends)
+	if err := ds.validate(); err != nil {
+		return err
+	}
+
+	if _, err := d.s.apply(ds); err != nil {
+		return err
+	}
+	d.discovered = backends
+
+	return nil
+}
+
+// Replace the backends last discovered in ds with backends, keeping the labels and
+// drain state of those whose address didn't change.  d must be locked.
+func (d *discovery) merge(ds desiredState, backends map[string]*desiredBackend) {
+	for name := range d.discovered {
+		if backends[name] == nil {
+			delete(ds.Backends, name)
+		}
+	}
+
+	for name, b := range backends {
+		have := ds.Backends[name]
+		switch {
+		case have == nil:
+			ds.Backends[name] = b
+		case d.discovered[name] == nil:
+			d.s.logger.Printf("%s: not adding %s at %s; a backend is already declared under that name",
+				d.source, name, b.Address[0])
+			delete(backends, name)
+		case have.Address[0] != b.Address[0]:
+			ds.Backends[name] = b
+		}
+	}
+}
+
+// Add the backends discovered last to ds, the state declared by the configuration
+// file as it's reloaded, so that they aren't removed until the registry drops them.
+func (d *discovery) keep(ds desiredState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for name, b := range d.discovered {
+		if ds.Backends[name] == nil {
+			ds.Backends[name] = b
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package arbiter
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a DNS lookup may take.
+const dnsTimeout = 10 * time.Second
+
+// dnsWatcher keeps the backends in sync with DNS names, resolved again every
+// interval: the targets of SRV records, and the addresses of host names, so that
+// backends whose addresses change behind stable names are followed.
+type dnsWatcher struct {
+	*discovery
+	srv      []string
+	hosts    []string
+	interval time.Duration
+
+	// The backends each name resolved to last, kept while it fails to resolve, so that
+	// a DNS outage doesn't empty the pool.
+	last map[string]map[string]*desiredBackend
+
+	lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+func newDNSWatcher(s *server, c *Config) *dnsWatcher {
+	return &dnsWatcher{
+		discovery: newDiscovery(s, "DNS"),
+		srv:       c.DNS.SRV,
+		hosts:     c.DNS.Host,
+		interval:  c.DNS.interval,
+		last:      make(map[string]map[string]*desiredBackend),
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return srvs, err
+		},
+		lookupHost: net.DefaultResolver.LookupHost,
+	}
+}
+
+// Resolve the names every interval until the process exits.
+func (dw *dnsWatcher) run() {
+	for {
+		if err := dw.resolve(); err != nil {
+			dw.s.logger.Printf("DNS: could not sync: %s", err)
+		}
+		time.Sleep(dw.interval)
+	}
+}
+
+// Resolve the names, and reconcile the declared state with what they resolve to.
+// Each target of an SRV record is a backend named after it.  A host name is a backend
+// of its own name if it has a single address, and one named host/address for each of
+// its addresses otherwise.
+func (dw *dnsWatcher) resolve() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	for _, name := range dw.srv {
+		srvs, err := dw.lookupSRV(ctx, name)
+		if err != nil {
+			dw.s.logger.Printf("DNS: could not resolve %s; keeping its last backends: %s", name, err)
+			continue
+		}
+
+		backends := make(map[string]*desiredBackend)
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			backends[target] = &desiredBackend{
+				Address: []string{net.JoinHostPort(target, strconv.Itoa(int(srv.Port)))},
+			}
+		}
+		dw.last[name] = backends
+	}
+
+	for _, hostport := range dw.hosts {
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return err
+		}
+
+		addrs, err := dw.lookupHost(ctx, host)
+		if err != nil {
+			dw.s.logger.Printf("DNS: could not resolve %s; keeping its last backends: %s", host, err)
+			continue
+		}
+		sort.Strings(addrs)
+
+		backends := make(map[string]*desiredBackend)
+		for _, addr := range addrs {
+			name := host
+			if len(addrs) > 1 {
+				name = host + "/" + addr
+			}
+			backends[name] = &desiredBackend{Address: []string{net.JoinHostPort(addr, port)}}
+		}
+		dw.last[hostport] = backends
+	}
+
+	discovered := make(map[string]*desiredBackend)
+	for _, backends := range dw.last {
+		for name, b := range backends {
+			discovered[name] = b
+		}
+	}
+
+	return dw.update(discovered)
+}
@@ -0,0 +1,68 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDNSWatcher(t *testing.T) {
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared:         &declaration{state: desiredState{Backends: map[string]*desiredBackend{}}},
+	}
+
+	c := &Config{}
+	c.DNS.SRV = []string{"_postgres._tcp.example.com"}
+	c.DNS.Host = []string{"db.example.com:5432"}
+	c.DNS.interval = time.Second
+	dw := newDNSWatcher(s, c)
+
+	srvs := []*net.SRV{{Target: "pg1.example.com.", Port: 5433}}
+	addrs := []string{"127.0.0.1"}
+	var hostErr error
+	dw.lookupSRV = func(context.Context, string) ([]*net.SRV, error) { return srvs, nil }
+	dw.lookupHost = func(context.Context, string) ([]string, error) { return addrs, hostErr }
+
+	backends := func() string {
+		var names []string
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			names = append(names, b.Name+"="+b.Addr)
+			return true
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	resolve := func(want string) {
+		t.Helper()
+		if err := dw.resolve(); err != nil {
+			t.Fatal(err)
+		}
+		if got := backends(); got != want {
+			t.Fatalf("Expected backends %s; instead got %s", want, got)
+		}
+	}
+
+	resolve("db.example.com=127.0.0.1:5432,pg1.example.com=pg1.example.com:5433")
+
+	// The host's address changes behind its name, and it's readdressed.
+	addrs = []string{"127.0.0.2"}
+	resolve("db.example.com=127.0.0.2:5432,pg1.example.com=pg1.example.com:5433")
+
+	// A name that fails to resolve keeps its backends.
+	hostErr = errors.New("server misbehaving")
+	resolve("db.example.com=127.0.0.2:5432,pg1.example.com=pg1.example.com:5433")
+
+	hostErr = nil
+	addrs = []string{"127.0.0.3", "127.0.0.4"}
+	srvs = nil
+	resolve("db.example.com/127.0.0.3=127.0.0.3:5432,db.example.com/127.0.0.4=127.0.0.4:5432")
+}
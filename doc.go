@@ -0,0 +1,11 @@
+// Package arbiter is the proxy that routes PostgreSQL clients to the primary or a
+// follower of a streaming replication cluster, as the arbiter command in cmd/arbiter
+// runs it; Main runs it with its flags and commands taken from the command line, for
+// programs that bundle it.
+//
+// The monitoring and routing behind it is the pool package, which Go services can
+// embed without the proxy: backends are added with Pool.Put, routed to with DialClass
+// or Acquire, and their state inspected with Backends and Subscribe.  Applications
+// behind a running arbiter can split reads from writes with the client package, or
+// balance their own connections with the resolver package.
+package arbiter
@@ -0,0 +1,70 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The default interval between summaries on /events.
+const defaultEventsInterval = 5 * time.Second
+
+// Serve /events, a stream of server-sent events for dashboards and bots: an "event" for
+// every state transition of a backend as it happens, including the report of a
+// failover, and a "summary" of the stats every interval, 5s by default.  Each carries
+// the same JSON document the reporter pushes.
+func (s *server) handleEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	interval := defaultEventsInterval
+	if v := req.FormValue("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			http.Error(w, "invalid interval", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	events := s.pool.Subscribe()
+	defer s.pool.Unsubscribe(events)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Start with a summary, so that clients needn't wait for the state.
+	st := s.stats()
+	rep := report{Kind: "summary", Stats: &st}
+	for {
+		rep.Time = time.Now()
+		rep.Labels = s.labels
+
+		b, err := json.Marshal(rep)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", rep.Kind, b); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			st := s.stats()
+			rep = report{Kind: "summary", Stats: &st}
+		case e := <-events:
+			rep = report{Kind: "event", Event: &e}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package arbiter
+
+import (
+	"bufio"
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	s := &server{pool: pool.New(context.Background(), pool.WithCheckInterval(10*time.Millisecond))}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream; instead got %s", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), "event: ") {
+				return strings.TrimPrefix(lines.Text(), "event: ")
+			}
+		}
+		t.Fatalf("Expected another event; instead got %v", lines.Err())
+		return ""
+	}
+
+	if kind := next(); kind != "summary" {
+		t.Fatalf("Expected to start with a summary; instead got %s", kind)
+	}
+
+	b := &queueBackend{}
+	b.promote()
+	s.pool.PutNamed("pg1", b)
+
+	if kind := next(); kind != "event" {
+		t.Fatalf("Expected the transition of pg1; instead got %s", kind)
+	}
+	if !lines.Scan() || !strings.Contains(lines.Text(), `"To":"READ_WRITE"`) {
+		t.Fatalf("Expected pg1 to become the primary; instead got %s", lines.Text())
+	}
+}
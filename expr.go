@@ -0,0 +1,392 @@
+package arbiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The types of the values of routing expressions.
+type exprType int
+
+const (
+	exprNumber exprType = iota
+	exprString
+	exprBool
+)
+
+func (t exprType) String() string {
+	return [...]string{"number", "string", "bool"}[t]
+}
+
+// exprVars are the values of the variables of routing expressions.
+type exprVars struct {
+	nums  map[string]float64
+	strs  map[string]string
+	bools map[string]bool
+}
+
+// expr is a compiled routing expression, such as lag < 2s && zone == local_zone.  It's
+// compiled once and evaluated for every backend at every routing decision, so it's
+// type checked up front and compiled to closures, with the function of its type set.
+//
+// Expressions combine numbers, durations such as 500ms (in seconds), 'strings', true
+// and false, and variables with || && ! == != < <= > >= + - * / and parentheses.
+type expr struct {
+	typ   exprType
+	num   func(v *exprVars) float64
+	str   func(v *exprVars) string
+	truth func(v *exprVars) bool
+}
+
+// Compile src, whose variables are typed by vars, into an expression.
+func compileExpr(src string, vars map[string]exprType) (*expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{toks: toks, vars: vars}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+	}
+
+	return e, nil
+}
+
+// The kinds of tokens of routing expressions.
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+// The operators of routing expressions, longest first.
+var exprOps = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "(", ")"}
+
+func lexExpr(src string) (toks []exprToken, err error) {
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case unicode.IsDigit(r) || r == '.':
+			// A number, or a duration such as 1m30s.
+			start := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || unicode.IsLetter(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			text := string(rs[start:i])
+			tok := exprToken{kind: tokNumber, text: text, pos: start}
+			if tok.num, err = strconv.ParseFloat(text, 64); err != nil {
+				d, derr := time.ParseDuration(text)
+				if derr != nil {
+					return nil, fmt.Errorf("invalid number '%s' at %d", text, start)
+				}
+				tok.num = d.Seconds()
+			}
+			toks = append(toks, tok)
+
+		case r == '\'' || r == '"':
+			start := i
+			i++
+			for i < len(rs) && rs[i] != r {
+				i++
+			}
+			if i == len(rs) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			toks = append(toks, exprToken{kind: tokString, text: string(rs[start+1 : i]), pos: start})
+			i++
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: string(rs[start:i]), pos: start})
+
+		default:
+			var op string
+			for _, o := range exprOps {
+				if strings.HasPrefix(string(rs[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c' at %d", r, i)
+			}
+			toks = append(toks, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(toks, exprToken{kind: tokEOF, text: "end", pos: len(rs)}), nil
+}
+
+// exprParser is a recursive descent parser of routing expressions; each method parses
+// an operator precedence level, lowest first.
+type exprParser struct {
+	toks []exprToken
+	vars map[string]exprType
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[0]
+}
+
+// Consume the next token if it's one of the operators ops.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.toks[0]
+	if t.kind != tokOp {
+		return "", false
+	}
+
+	for _, op := range ops {
+		if t.text == op {
+			p.toks = p.toks[1:]
+			return op, true
+		}
+	}
+
+	return "", false
+}
+
+// Check that e is of type want, for operator op.
+func checkType(e *expr, want exprType, op string) error {
+	if e.typ != want {
+		return fmt.Errorf("'%s' expects a %s, not a %s", op, want, e.typ)
+	}
+	return nil
+}
+
+func (p *exprParser) or() (*expr, error) {
+	l, err := p.and()
+	for err == nil {
+		if _, ok := p.accept("||"); !ok {
+			return l, nil
+		}
+
+		var r *expr
+		if r, err = p.and(); err != nil {
+			break
+		}
+		if err = checkType(l, exprBool, "||"); err == nil {
+			err = checkType(r, exprBool, "||")
+		}
+
+		lf, rf := l.truth, r.truth
+		l = &expr{typ: exprBool, truth: func(v *exprVars) bool { return lf(v) || rf(v) }}
+	}
+
+	return nil, err
+}
+
+func (p *exprParser) and() (*expr, error) {
+	l, err := p.comparison()
+	for err == nil {
+		if _, ok := p.accept("&&"); !ok {
+			return l, nil
+		}
+
+		var r *expr
+		if r, err = p.comparison(); err != nil {
+			break
+		}
+		if err = checkType(l, exprBool, "&&"); err == nil {
+			err = checkType(r, exprBool, "&&")
+		}
+
+		lf, rf := l.truth, r.truth
+		l = &expr{typ: exprBool, truth: func(v *exprVars) bool { return lf(v) && rf(v) }}
+	}
+
+	return nil, err
+}
+
+func (p *exprParser) comparison() (*expr, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return l, nil
+	}
+
+	r, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	if op != "==" && op != "!=" {
+		if err = checkType(l, exprNumber, op); err == nil {
+			err = checkType(r, exprNumber, op)
+		}
+	} else if l.typ != r.typ {
+		err = fmt.Errorf("'%s' compares a %s with a %s", op, l.typ, r.typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare as numbers, strings or bools; ne inverts == for !=.
+	ne := op == "!="
+	switch {
+	case l.typ == exprString:
+		lf, rf := l.str, r.str
+		return &expr{typ: exprBool, truth: func(v *exprVars) bool { return (lf(v) == rf(v)) != ne }}, nil
+	case l.typ == exprBool:
+		lf, rf := l.truth, r.truth
+		return &expr{typ: exprBool, truth: func(v *exprVars) bool { return (lf(v) == rf(v)) != ne }}, nil
+	}
+
+	lf, rf := l.num, r.num
+	cmp := map[string]func(a, b float64) bool{
+		"==": func(a, b float64) bool { return a == b },
+		"!=": func(a, b float64) bool { return a != b },
+		"<":  func(a, b float64) bool { return a < b },
+		"<=": func(a, b float64) bool { return a <= b },
+		">":  func(a, b float64) bool { return a > b },
+		">=": func(a, b float64) bool { return a >= b },
+	}[op]
+	return &expr{typ: exprBool, truth: func(v *exprVars) bool { return cmp(lf(v), rf(v)) }}, nil
+}
+
+func (p *exprParser) sum() (*expr, error) {
+	return p.arithmetic(p.term, "+", "-")
+}
+
+func (p *exprParser) term() (*expr, error) {
+	return p.arithmetic(p.unary, "*", "/")
+}
+
+// Parse operands with next, combined with the arithmetic operators ops.
+func (p *exprParser) arithmetic(next func() (*expr, error), ops ...string) (*expr, error) {
+	l, err := next()
+	for err == nil {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+
+		var r *expr
+		if r, err = next(); err != nil {
+			break
+		}
+		if err = checkType(l, exprNumber, op); err == nil {
+			err = checkType(r, exprNumber, op)
+		}
+
+		lf, rf := l.num, r.num
+		var f func(v *exprVars) float64
+		switch op {
+		case "+":
+			f = func(v *exprVars) float64 { return lf(v) + rf(v) }
+		case "-":
+			f = func(v *exprVars) float64 { return lf(v) - rf(v) }
+		case "*":
+			f = func(v *exprVars) float64 { return lf(v) * rf(v) }
+		case "/":
+			f = func(v *exprVars) float64 { return lf(v) / rf(v) }
+		}
+		l = &expr{typ: exprNumber, num: f}
+	}
+
+	return nil, err
+}
+
+func (p *exprParser) unary() (*expr, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.primary()
+	}
+
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "!" {
+		if err = checkType(e, exprBool, op); err != nil {
+			return nil, err
+		}
+		f := e.truth
+		return &expr{typ: exprBool, truth: func(v *exprVars) bool { return !f(v) }}, nil
+	}
+
+	if err = checkType(e, exprNumber, op); err != nil {
+		return nil, err
+	}
+	f := e.num
+	return &expr{typ: exprNumber, num: func(v *exprVars) float64 { return -f(v) }}, nil
+}
+
+func (p *exprParser) primary() (*expr, error) {
+	t := p.peek()
+	p.toks = p.toks[1:]
+
+	switch t.kind {
+	case tokNumber:
+		n := t.num
+		return &expr{typ: exprNumber, num: func(*exprVars) float64 { return n }}, nil
+
+	case tokString:
+		s := t.text
+		return &expr{typ: exprString, str: func(*exprVars) string { return s }}, nil
+
+	case tokIdent:
+		name := t.text
+		if name == "true" || name == "false" {
+			b := name == "true"
+			return &expr{typ: exprBool, truth: func(*exprVars) bool { return b }}, nil
+		}
+
+		typ, ok := p.vars[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable '%s' at %d", name, t.pos)
+		}
+
+		switch typ {
+		case exprString:
+			return &expr{typ: typ, str: func(v *exprVars) string { return v.strs[name] }}, nil
+		case exprBool:
+			return &expr{typ: typ, truth: func(v *exprVars) bool { return v.bools[name] }}, nil
+		}
+		return &expr{typ: typ, num: func(v *exprVars) float64 { return v.nums[name] }}, nil
+
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("expected ')' at %d", p.peek().pos)
+			}
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+}
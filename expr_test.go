@@ -0,0 +1,43 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/pool"
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	s := &exprScorer{zones: map[string]string{"pg1": "a"}, costs: map[string]float64{"pg1": 2}, localZone: "a"}
+	v := s.routeVars(pool.BackendInfo{Name: "pg1", State: pool.READ_ONLY, Lag: time.Second, Leases: 10})
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"lag < 2s", true},
+		{"lag < 500ms", false},
+		{"lag < 2s && zone == local_zone", true},
+		{"zone != 'a' || leases >= 10", true},
+		{"!primary && name == \"pg1\"", true},
+		{"leases * 2 - 1 == 19", true},
+		{"cost > 1 && -cost < 0", true},
+		{"-(leases) + 10 == 0 && !(lag > 1)", true},
+		{"true && false", false},
+	}
+	for _, test := range tests {
+		e, err := compileExpr(test.src, routeVarTypes)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.src, err)
+			continue
+		}
+		if got := e.truth(v); got != test.want {
+			t.Errorf("%s: expected %v, instead got %v", test.src, test.want, got)
+		}
+	}
+
+	for _, src := range []string{"lag <", "lag < 'a'", "foo > 1", "zone == 1", "(lag > 1", "lag > 1 1", "lag + primary", "'open"} {
+		if _, err := compileExpr(src, routeVarTypes); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package arbiter
+
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+// Return the number of open file descriptors and the limit on them; -1 if unknown.
+func descriptorUsage() (open, max int64) {
+	open, max = -1, -1
+
+	if fds, err := ioutil.ReadDir("/proc/self/fd"); err == nil {
+		open = int64(len(fds))
+	}
+
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err == nil {
+		max = int64(rlim.Cur)
+	}
+
+	return open, max
+}
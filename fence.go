@@ -0,0 +1,78 @@
+package arbiter
+
+import (
+	"context"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"time"
+)
+
+// fencePayload is the JSON document POSTed to the fence URL.
+type fencePayload struct {
+	Time   time.Time      `json:"time"`
+	Labels metrics.Labels `json:"labels,omitempty"`
+
+	// The primary to fence off, and the backend taking writes in its place.
+	PreviousPrimary string `json:"previous_primary"`
+	PreviousAddress string `json:"previous_address,omitempty"`
+	Primary         string `json:"primary"`
+	Address         string `json:"address"`
+}
+
+// fenceHook fences off the previous primary of [main] before writes are routed to the
+// backend taking writes in its place, such as by revoking its virtual IP or powering
+// it off: it POSTs a fencePayload to a URL, and runs a script with the backends in
+// ARBITER_* environment variables.  Both must succeed, within timeout, or writes are
+// held, and the fence retried on the backend's next check; see pool.WithFenceHook().
+type fenceHook struct {
+	url     string
+	token   string
+	script  string
+	timeout time.Duration
+	labels  metrics.Labels
+	client  *http.Client
+}
+
+func newFenceHook(c *Config) *fenceHook {
+	return &fenceHook{
+		url:     c.Fence.URL,
+		token:   c.Fence.Token,
+		script:  c.Fence.Exec,
+		timeout: c.Fence.timeout,
+		labels:  c.Metrics.labels,
+		client:  &http.Client{Timeout: c.Fence.timeout},
+	}
+}
+
+// Fence previous off for next; a pool.FenceHook.
+func (f *fenceHook) fence(ctx context.Context, previous, next pool.BackendInfo) error {
+	if f.url != "" {
+		payload := fencePayload{Time: time.Now(), Labels: f.labels, PreviousPrimary: previous.Name,
+			PreviousAddress: previous.Addr, Primary: next.Name, Address: next.Addr}
+		if err := postJSON(f.client, f.url, f.token, payload); err != nil {
+			return fmt.Errorf("%s: %w", f.url, err)
+		}
+	}
+	if f.script != "" {
+		if err := f.exec(ctx, previous, next); err != nil {
+			return fmt.Errorf("%s: %w", f.script, err)
+		}
+	}
+
+	return nil
+}
+
+// Run the script, killing it once it runs past the timeout.
+func (f *fenceHook) exec(ctx context.Context, previous, next pool.BackendInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	return runScript(ctx, f.script, []string{
+		"ARBITER_BACKEND=" + next.Name,
+		"ARBITER_ADDRESS=" + next.Addr,
+		"ARBITER_PREVIOUS_PRIMARY=" + previous.Name,
+		"ARBITER_PREVIOUS_ADDRESS=" + previous.Addr,
+	}, nil)
+}
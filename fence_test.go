@@ -0,0 +1,59 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFenceHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fence script is a shell script")
+	}
+
+	var got fencePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the token; instead got %q", req.Header.Get("Authorization"))
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "fence.sh")
+	body := "#!/bin/sh\necho \"$ARBITER_PREVIOUS_PRIMARY $ARBITER_PREVIOUS_ADDRESS $ARBITER_BACKEND $ARBITER_ADDRESS\" > " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	c.Fence.URL, c.Fence.Token, c.Fence.Exec, c.Fence.timeout = srv.URL, "secret", script, 5*time.Second
+	f := newFenceHook(c)
+	previous := pool.BackendInfo{Name: "pg1", Addr: "10.0.0.1:5432"}
+	next := pool.BackendInfo{Name: "pg2", Addr: "10.0.0.2:5432"}
+	if err := f.fence(context.Background(), previous, next); err != nil {
+		t.Fatal(err)
+	}
+	if got.PreviousPrimary != "pg1" || got.PreviousAddress != "10.0.0.1:5432" || got.Primary != "pg2" {
+		t.Errorf("Expected the backends to be POSTed; instead got %+v", got)
+	}
+	if b, err := os.ReadFile(out); err != nil || string(b) != "pg1 10.0.0.1:5432 pg2 10.0.0.2:5432\n" {
+		t.Errorf("Expected the backends in the environment; instead got %q, %v", b, err)
+	}
+
+	// A script that fails fails the fence, and holds writes.
+	os.WriteFile(script, []byte("#!/bin/sh\necho powered on >&2\nexit 1\n"), 0755)
+	if err := f.fence(context.Background(), previous, next); err == nil || !strings.Contains(err.Error(), "powered on") {
+		t.Errorf("Expected the fence to fail with what the script said; instead got %v", err)
+	}
+}
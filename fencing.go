@@ -0,0 +1,51 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// The parameter the sessions of write listeners are started with, set to the fencing
+// epoch of the primary; see [health] fencing-table.
+const epochParameter = "arbiter.epoch"
+
+// Whether sessions routed to class write to the primary, and are fenced with c.
+func fenced(class string, c *Config) bool {
+	if c.Health.FencingTable == "" {
+		return false
+	}
+
+	return primaryOnly(class, c)
+}
+
+// Start the session of the client on conn with epochParameter set to epoch, rewriting
+// its StartupMessage, and return its connection to proxy from.  Any epoch the client
+// set itself is dropped.  Sessions that don't start in the clear, such as those
+// encrypted end to end, are passed through as they are.
+func fenceStartup(conn net.Conn, epoch uint64) (net.Conn, error) {
+	packet, _, err := readStartup(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if epoch == 0 || binary.BigEndian.Uint32(packet[4:8]) != protocolVersion3 {
+		return newReplayConn(conn, packet), nil
+	}
+
+	rewritten := append([]byte(nil), packet[:8]...)
+	params := bytes.Split(packet[8:], []byte{0})
+	for i := 0; i+1 < len(params); i += 2 {
+		if len(params[i]) == 0 || string(params[i]) == epochParameter {
+			continue
+		}
+		rewritten = append(append(rewritten, params[i]...), 0)
+		rewritten = append(append(rewritten, params[i+1]...), 0)
+	}
+	rewritten = append(append(rewritten, epochParameter...), 0)
+	rewritten = append(append(rewritten, strconv.FormatUint(epoch, 10)...), 0, 0)
+	binary.BigEndian.PutUint32(rewritten, uint32(len(rewritten)))
+
+	return newReplayConn(conn, rewritten), nil
+}
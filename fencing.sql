@@ -0,0 +1,41 @@
+-- Fencing for arbiter: rejects the writes of sessions started with a stale
+-- arbiter.epoch, such as those still connected to a previous primary; see
+-- fencing-table in config.ini.  Rename arbiter_fencing below if the table is named
+-- otherwise, and attach the trigger to each table to protect:
+--
+--   create trigger arbiter_fence before insert or update or delete or truncate on orders
+--     for each statement execute function arbiter_fence();
+--
+-- or to every table of a schema, from psql:
+--
+--   select format('create trigger arbiter_fence before insert or update or delete or truncate
+--     on %s for each statement execute function arbiter_fence();', oid::regclass)
+--   from pg_class where relnamespace = 'public'::regnamespace and relkind in ('r', 'p') \gexec
+--
+-- Sessions that didn't come through a write listener of arbiter, which carry no epoch,
+-- are left alone.
+
+create table if not exists arbiter_fencing
+	(id int primary key, epoch bigint not null, primary_name text not null,
+	issued_at timestamptz not null);
+
+create or replace function arbiter_fence() returns trigger
+language plpgsql as $$
+declare
+	session_epoch bigint := nullif(current_setting('arbiter.epoch', true), '')::bigint;
+	issued bigint;
+begin
+	if session_epoch is null then
+		return null;
+	end if;
+
+	select epoch into issued from arbiter_fencing where id = 1;
+	if session_epoch < issued then
+		raise exception 'arbiter: stale fencing epoch % (the primary''s is %); reconnect',
+			session_epoch, issued
+			using errcode = 'read_only_sql_transaction';
+	end if;
+
+	return null;
+end;
+$$;
@@ -0,0 +1,39 @@
+package arbiter
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFenceStartup(t *testing.T) {
+	read := func(packet []byte, epoch uint64) []byte {
+		t.Helper()
+		client, server := net.Pipe()
+		defer server.Close()
+		go func() {
+			client.Write(packet)
+			client.Close()
+		}()
+
+		conn, err := fenceStartup(server, epoch)
+		if err != nil {
+			t.Fatalf("Expected the startup packet to be read; instead got %v", err)
+		}
+		got, _ := io.ReadAll(conn)
+		return got
+	}
+
+	want := startup("user", "app", "database", "app", "arbiter.epoch", "7")
+	if got := read(startup("user", "app", "arbiter.epoch", "1", "database", "app"), 7); !bytes.Equal(got, want) {
+		t.Errorf("Expected the epoch to be set, replacing the client's; instead got %q", got)
+	}
+
+	if got := read(sslRequest(), 7); !bytes.Equal(got, sslRequest()) {
+		t.Errorf("Expected an SSLRequest to be passed through; instead got %q", got)
+	}
+	if got := read(startup("user", "app"), 0); !bytes.Equal(got, startup("user", "app")) {
+		t.Errorf("Expected a session to be passed through before an epoch is issued; instead got %q", got)
+	}
+}
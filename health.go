@@ -0,0 +1,86 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"strings"
+)
+
+// The header the role of a backend is given in by handleBackendHealth.
+const roleHeader = "X-Arbiter-Role"
+
+// Return the role of a backend, as reported to load balancers.
+func role(b pool.BackendInfo) string {
+	switch b.State {
+	case pool.READ_WRITE:
+		return "primary"
+	case pool.READ_ONLY:
+		return "follower"
+	}
+	return "unavailable"
+}
+
+// Serve /backends/{name or addr}/health, for external load balancers that health
+// check over HTTP.  It answers 200 if arbiter would route to the backend, and 503
+// otherwise, with the backend's role in the X-Arbiter-Role header.  A role parameter,
+// such as ?role=primary, additionally requires the backend to have that role.
+func (s *server) handleBackendHealth(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/backends/")
+	if !strings.HasSuffix(key, "/health") {
+		http.NotFound(w, req)
+		return
+	}
+	key = strings.TrimSuffix(key, "/health")
+
+	var found *pool.BackendInfo
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		if b.Name == key || b.Addr == key {
+			found = &b
+		}
+		return found == nil
+	})
+
+	if found == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set(roleHeader, role(*found))
+
+	healthy := found.State != pool.UNAVAILABLE && !found.Diverged && !found.Draining && !found.Cordoned
+	if want := req.FormValue("role"); want != "" && want != role(*found) {
+		healthy = false
+	}
+
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintf(w, "%s\n", found.State)
+}
+
+// Serve /health, for service discovery that health checks arbiter's listeners, such as
+// Consul; see [consul] register.  It answers 200 if the class parameter, strong by
+// default, has backends to route to, and 503 otherwise, with their names.
+func (s *server) handleHealth(w http.ResponseWriter, req *http.Request) {
+	class := req.FormValue("class")
+	if class == "" {
+		class = "strong"
+	}
+
+	if !s.leading() {
+		http.Error(w, "standby", http.StatusServiceUnavailable)
+		return
+	}
+
+	names, err := s.pool.Candidates(class)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", class, err), http.StatusNotFound)
+		return
+	}
+
+	if len(names) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintf(w, "%s\n", strings.Join(names, " "))
+}
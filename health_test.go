@@ -0,0 +1,126 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackendHealth(t *testing.T) {
+	s := &server{pool: pool.New(context.Background(), pool.WithCheckInterval(10*time.Millisecond))}
+	b := &queueBackend{}
+	b.promote()
+	s.pool.PutNamed("pg1", b)
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, c := range []struct {
+		path string
+		code int
+		role string
+	}{
+		{"/backends/pg1/health", http.StatusOK, "primary"},
+		{"/backends/127.0.0.1:5432/health", http.StatusOK, "primary"},
+		{"/backends/pg1/health?role=follower", http.StatusServiceUnavailable, "primary"},
+		{"/backends/pg2/health", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		s.handleBackendHealth(w, httptest.NewRequest("GET", c.path, nil))
+
+		if w.Code != c.code || w.Header().Get(roleHeader) != c.role {
+			t.Errorf("%s: Expected %d with role %q; instead got %d with role %q",
+				c.path, c.code, c.role, w.Code, w.Header().Get(roleHeader))
+		}
+	}
+}
+
+func TestConnString(t *testing.T) {
+	s := &server{pool: pool.New(context.Background(), pool.WithCheckInterval(10*time.Millisecond))}
+
+	w := httptest.NewRecorder()
+	s.handleConnString(w, httptest.NewRequest("GET", "/connstring", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without backends; instead got %d", w.Code)
+	}
+
+	b := &queueBackend{}
+	b.promote()
+	s.pool.PutNamed("pg1", b)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, c := range []struct {
+		query string
+		want  string
+	}{
+		{"", "host=127.0.0.1 port=5432 target_session_attrs=read-write\n"},
+		{"?format=jdbc&target=prefer-standby&dbname=app", "jdbc:postgresql://127.0.0.1:5432/app?targetServerType=preferSecondary\n"},
+	} {
+		w := httptest.NewRecorder()
+		s.handleConnString(w, httptest.NewRequest("GET", "/connstring"+c.query, nil))
+		if w.Code != http.StatusOK || w.Body.String() != c.want {
+			t.Errorf("%s: Expected %q; instead got %d, %q", c.query, c.want, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.handleResolve(w, httptest.NewRequest("GET", "/resolve?class=strong", nil))
+	if want := `"addr": "127.0.0.1:5432"`; w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Expected the primary to be resolved; instead got %d, %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.handleResolve(w, httptest.NewRequest("GET", "/resolve?class=nonesuch", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown class not to be resolved; instead got %d", w.Code)
+	}
+}
+
+func TestRenderTopology(t *testing.T) {
+	backends := []pool.BackendInfo{
+		{Name: "pg1", Addr: "10.0.0.1:5432", State: pool.READ_WRITE, RTT: time.Millisecond},
+		{Name: "pg2", Addr: "10.0.0.2:5432", State: pool.READ_ONLY, Upstream: "10.0.0.1:5432", Lag: time.Second},
+		{Name: "pg3", Addr: "10.0.0.3:5432", State: pool.READ_ONLY, Upstream: "10.0.0.2:5432", LagBytes: 42},
+		{Name: "pg4", Addr: "10.0.0.4:5432", State: pool.UNAVAILABLE},
+	}
+
+	for _, c := range []struct {
+		format string
+		want   []string
+	}{
+		{"dot", []string{
+			"\tn0 [label=\"pg1\\n10.0.0.1:5432\\nprimary\", style=bold];\n",
+			"\tn3 [label=\"pg4\\n10.0.0.4:5432\\nunavailable\", style=dashed, color=gray];\n",
+			"\tn0 -> n1 [label=\"lag 1s\"];\n",
+			"\tn1 -> n2 [label=\"lag 42 bytes\"];\n",
+			"\tarbiter -> n0 [style=dotted, label=\"rtt 1ms\"];\n",
+		}},
+		{"mermaid", []string{
+			"\tn1[\"pg2<br/>10.0.0.2:5432<br/>follower\"]\n",
+			"\tn1 -->|\"lag 42 bytes\"| n2\n",
+			"\tarbiter -.->|\"rtt 1ms\"| n0\n",
+		}},
+	} {
+		graph, err := renderTopology(backends, c.format)
+		if err != nil {
+			t.Fatalf("%s: Expected a graph; instead got %v", c.format, err)
+		}
+
+		for _, want := range c.want {
+			if !strings.Contains(graph, want) {
+				t.Errorf("%s: Expected %q in the graph:\n%s", c.format, want, graph)
+			}
+		}
+
+		if strings.Contains(graph, "n3 -") || strings.Contains(graph, "-> n3") {
+			t.Errorf("%s: Expected no edges to an unavailable backend:\n%s", c.format, graph)
+		}
+	}
+
+	if _, err := renderTopology(backends, "svg"); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,248 @@
+package arbiter
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	_ "modernc.org/sqlite"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// The most samples a history query returns, unless it asks for fewer.
+const maxHistorySamples = 10000
+
+// history samples the state, latency and lag of every backend into an embedded SQLite
+// database, keeping them for the retention period, so that incidents can be looked
+// into after the fact without an external time-series database.
+type history struct {
+	db        *sql.DB
+	interval  time.Duration
+	retention time.Duration
+}
+
+// sample is the state of a backend at a point in time.  Durations are in seconds.
+type sample struct {
+	Time     time.Time `json:"time"`
+	Backend  string    `json:"backend"`
+	State    string    `json:"state"`
+	Latency  float64   `json:"latency"`
+	RTT      float64   `json:"rtt"`
+	LagBytes uint64    `json:"lag_bytes"`
+	Lag      float64   `json:"lag"`
+}
+
+// Open the history database at path, creating it if it doesn't exist.
+func openHistory(path string, interval, retention time.Duration) (*history, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer; the sampler is the only one.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`create table if not exists samples (
+			ts integer not null,
+			backend text not null,
+			state text not null,
+			latency real not null,
+			rtt real not null,
+			lag_bytes integer not null,
+			lag real not null);
+		create index if not exists samples_backend_ts on samples (backend, ts);
+		create index if not exists samples_ts on samples (ts);`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &history{db: db, interval: interval, retention: retention}, nil
+}
+
+// Sample backends every interval until the process exits.
+func (h *history) run(p *pool.Pool, logger pool.Logger) {
+	for now := range time.Tick(h.interval) {
+		if err := h.record(now, p.Backends()); err != nil {
+			logger.Printf("Could not record history: %s", err)
+		}
+	}
+}
+
+// Record the state of backends at now, and forget samples past the retention period.
+func (h *history) record(now time.Time, backends []pool.BackendInfo) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, b := range backends {
+		_, err = tx.Exec("insert into samples values (?, ?, ?, ?, ?, ?, ?);",
+			now.UnixNano(), b.Name, role(b), b.Latency.Seconds(), b.LastRTT.Seconds(),
+			int64(b.LagBytes), b.Lag.Seconds())
+		if err != nil {
+			return err
+		}
+	}
+
+	if h.retention > 0 {
+		if _, err = tx.Exec("delete from samples where ts < ?;", now.Add(-h.retention).UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Return the samples of backend, or of all backends if it's empty, taken between from
+// and to, oldest first, up to limit of them.
+func (h *history) query(backend string, from, to time.Time, limit int) ([]sample, error) {
+	rows, err := h.db.Query(`select ts, backend, state, latency, rtt, lag_bytes, lag from samples
+		where (? = '' or backend = ?) and ts between ? and ? order by ts, backend limit ?;`,
+		backend, backend, from.UnixNano(), to.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []sample{}
+	for rows.Next() {
+		var s sample
+		var ts, lagBytes int64
+		if err = rows.Scan(&ts, &s.Backend, &s.State, &s.Latency, &s.RTT, &lagBytes, &s.Lag); err != nil {
+			return nil, err
+		}
+		s.Time, s.LagBytes = time.Unix(0, ts), uint64(lagBytes)
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
+
+// The layouts times are accepted in by the history API, in the local time zone unless
+// they carry an offset.
+var historyTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Parse the bounds of a history query.  to defaults to now, and from to an hour
+// before to.
+func parseHistoryRange(fromStr, toStr string) (from, to time.Time, err error) {
+	parse := func(s string) (time.Time, error) {
+		for _, layout := range historyTimeLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time '%s'; expected e.g. 2006-01-02 15:04", s)
+	}
+
+	to = time.Now()
+	if toStr != "" {
+		if to, err = parse(toStr); err != nil {
+			return from, to, err
+		}
+	}
+
+	from = to.Add(-time.Hour)
+	if fromStr != "" {
+		if from, err = parse(fromStr); err != nil {
+			return from, to, err
+		}
+	}
+
+	if from.After(to) {
+		return from, to, fmt.Errorf("from is after to")
+	}
+
+	return from, to, nil
+}
+
+// Serve /history, the samples of the backend parameter, or of all backends if it's
+// left out, between from and to; see parseHistoryRange().  limit caps the number of
+// samples, 10000 by default.
+func (s *server) handleHistory(w http.ResponseWriter, req *http.Request) {
+	if s.history == nil {
+		http.Error(w, "history is off", http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseHistoryRange(req.FormValue("from"), req.FormValue("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := maxHistorySamples
+	if v := req.FormValue("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxHistorySamples {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	samples, err := s.history.query(req.FormValue("backend"), from, to, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, samples)
+}
+
+// Print samples as a table, for the -history flag.
+func printHistory(w io.Writer, samples []sample) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tBACKEND\tSTATE\tLATENCY\tRTT\tLAG BYTES\tLAG")
+	for _, s := range samples {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", s.Time.Format(time.RFC3339), s.Backend, s.State,
+			seconds(s.Latency), seconds(s.RTT), s.LagBytes, seconds(s.Lag))
+	}
+
+	return tw.Flush()
+}
+
+// Print the history of backend, or of all backends if it's "all", between from and
+// to, from the database configured in c.
+func queryHistory(c *Config, backend, from, to string) error {
+	if c.History.Path == "" {
+		return fmt.Errorf("no history path configured")
+	}
+
+	start, end, err := parseHistoryRange(from, to)
+	if err != nil {
+		return err
+	}
+
+	h, err := openHistory(c.History.Path, c.History.interval, c.History.retention)
+	if err != nil {
+		return err
+	}
+	defer h.db.Close()
+
+	if backend == "all" {
+		backend = ""
+	}
+
+	samples, err := h.query(backend, start, end, maxHistorySamples)
+	if err != nil {
+		return err
+	}
+
+	return printHistory(os.Stdout, samples)
+}
+
+// Convert s seconds to a Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
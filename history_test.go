@@ -0,0 +1,66 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	h, err := openHistory(filepath.Join(t.TempDir(), "history.db"), time.Second, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to open the history; instead got %v", err)
+	}
+	defer h.db.Close()
+
+	start := time.Date(2026, 3, 3, 2, 0, 0, 0, time.Local)
+	for i, lag := range []time.Duration{time.Second, 5 * time.Second, 2 * time.Second} {
+		err := h.record(start.Add(time.Duration(i)*30*time.Minute), []pool.BackendInfo{
+			{Name: "pg1", State: pool.READ_WRITE},
+			{Name: "pg2", State: pool.READ_ONLY, Lag: lag, LagBytes: uint64(lag / time.Millisecond)},
+		})
+		if err != nil {
+			t.Fatalf("Expected to record samples; instead got %v", err)
+		}
+	}
+
+	samples, err := h.query("pg2", start, start.Add(time.Hour), maxHistorySamples)
+	if err != nil || len(samples) != 3 {
+		t.Fatalf("Expected 3 samples of pg2; instead got %v, %v", samples, err)
+	}
+	if s := samples[1]; s.Lag != 5 || s.LagBytes != 5000 || s.State != "follower" || !s.Time.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("Expected pg2 5s behind at 02:30; instead got %+v", s)
+	}
+
+	// Recording an hour and a half later forgets the samples past the retention.
+	if err := h.record(start.Add(90*time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if samples, _ := h.query("", start, start.Add(2*time.Hour), maxHistorySamples); len(samples) != 4 {
+		t.Errorf("Expected the samples at 02:00 to be forgotten; instead got %v", samples)
+	}
+
+	s := &server{history: h}
+	w := httptest.NewRecorder()
+	s.handleHistory(w, httptest.NewRequest("GET", "/history?backend=pg2&from=2026-03-03+02:15&to=2026-03-03T03:00", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &samples); err != nil || len(samples) != 2 {
+		t.Errorf("Expected the 2 samples of pg2 after 02:15; instead got %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleHistory(w, httptest.NewRequest("GET", "/history?from=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid time to be rejected; instead got %d", w.Code)
+	}
+
+	var out strings.Builder
+	printHistory(&out, samples)
+	if !strings.Contains(out.String(), "LAG BYTES") || !strings.Contains(out.String(), "pg2") {
+		t.Errorf("Expected a table of samples; instead got:\n%s", out.String())
+	}
+}
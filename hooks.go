@@ -0,0 +1,209 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// The state changes hooks are fired on; see [hooks] on.
+const (
+	hookPrimaryChange = "primary-change"
+	hookDown          = "down"
+	hookRecovery      = "recovery"
+)
+
+var hookKinds = []string{hookPrimaryChange, hookDown, hookRecovery}
+
+// hookPayload is the JSON document POSTed to the hook URL, and written to the standard
+// input of the hook script.
+type hookPayload struct {
+	Hook   string         `json:"hook"`
+	Time   time.Time      `json:"time"`
+	Labels metrics.Labels `json:"labels,omitempty"`
+
+	// The primary before a primary change, if there was one.
+	PreviousPrimary string `json:"previous_primary,omitempty"`
+
+	Event pool.Event `json:"event"`
+}
+
+// hookRunner fires hooks on primary changes, backends going down and backends
+// recovering: it POSTs a hookPayload to a URL, and runs a script with the payload on
+// its standard input and the change in ARBITER_* environment variables, so that
+// on-call can be paged and application caches flushed.  Each attempt is given timeout,
+// and failed ones are retried up to retries times before the hook is given up on.
+type hookRunner struct {
+	s       *server
+	url     string
+	token   string
+	script  string
+	on      map[string]bool
+	timeout time.Duration
+	retries int
+	client  *http.Client
+
+	// The primary last seen, and the backends seen going down and not yet back, so
+	// that backends coming up for the first time aren't taken as recovering.
+	primary string
+	down    map[string]bool
+}
+
+func newHookRunner(s *server, c *Config) *hookRunner {
+	h := &hookRunner{
+		s:       s,
+		url:     c.Hooks.URL,
+		token:   c.Hooks.Token,
+		script:  c.Hooks.Exec,
+		on:      make(map[string]bool),
+		timeout: c.Hooks.timeout,
+		retries: c.Hooks.Retries,
+		client:  &http.Client{Timeout: c.Hooks.timeout},
+		down:    make(map[string]bool),
+	}
+	for _, kind := range c.Hooks.on {
+		h.on[kind] = true
+	}
+
+	return h
+}
+
+// Fire hooks until the process exits.  While a hook is retried, events queue up in the
+// pool's subscription buffer.
+func (h *hookRunner) run() {
+	for e := range h.s.pool.Subscribe() {
+		for _, payload := range h.match(e) {
+			h.fire(payload)
+		}
+	}
+}
+
+// Return the hooks e fires, and keep track of the primary and of the backends down.
+func (h *hookRunner) match(e pool.Event) []hookPayload {
+	if e.From == e.To {
+		// Not a transition, but a violation, anomaly or the like.
+		return nil
+	}
+
+	var kinds []string
+	previous := ""
+	switch {
+	case e.To == pool.UNAVAILABLE:
+		kinds = append(kinds, hookDown)
+		h.down[e.Name] = true
+	case e.From == pool.UNAVAILABLE && h.down[e.Name]:
+		kinds = append(kinds, hookRecovery)
+		delete(h.down, e.Name)
+	}
+	if e.To == pool.READ_WRITE {
+		if h.primary != "" && h.primary != e.Name {
+			kinds = append(kinds, hookPrimaryChange)
+			previous = h.primary
+		}
+		h.primary = e.Name
+	}
+
+	var ret []hookPayload
+	for _, kind := range kinds {
+		if !h.on[kind] {
+			continue
+		}
+		payload := hookPayload{Hook: kind, Time: time.Now(), Labels: h.s.labels, Event: e}
+		if kind == hookPrimaryChange {
+			payload.PreviousPrimary = previous
+		}
+		ret = append(ret, payload)
+	}
+
+	return ret
+}
+
+// Deliver payload to the URL and to the script, retrying each on its own.
+func (h *hookRunner) fire(payload hookPayload) {
+	if h.url != "" {
+		h.retry(payload, h.url, func() error { return postJSON(h.client, h.url, h.token, payload) })
+	}
+	if h.script != "" {
+		h.retry(payload, h.script, func() error { return h.exec(payload) })
+	}
+}
+
+func (h *hookRunner) retry(payload hookPayload, target string, attempt func() error) {
+	backoff := minReportBackoff
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil {
+			return
+		}
+		if i >= h.retries {
+			h.s.logger.Printf("Hook %s of %s failed at %s; giving up: %s", payload.Hook, payload.Event.Name, target, err)
+			return
+		}
+
+		h.s.logger.Printf("Hook %s of %s failed at %s; retrying in %s: %s", payload.Hook, payload.Event.Name,
+			target, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReportBackoff {
+			backoff = maxReportBackoff
+		}
+	}
+}
+
+// Run the script with payload on its standard input, killing it once it runs past the
+// timeout.
+func (h *hookRunner) exec(payload hookPayload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	e := payload.Event
+	return runScript(ctx, h.script, []string{
+		"ARBITER_HOOK=" + payload.Hook,
+		"ARBITER_BACKEND=" + e.Name,
+		"ARBITER_ADDRESS=" + e.Addr,
+		"ARBITER_FROM=" + e.From.String(),
+		"ARBITER_TO=" + e.To.String(),
+		"ARBITER_PREVIOUS_PRIMARY=" + payload.PreviousPrimary,
+		"ARBITER_TIME=" + e.Time.Format(time.RFC3339Nano),
+	}, b)
+}
+
+// Run the script at path with env added to arbiter's own and stdin on its standard
+// input, killing it once ctx is done.
+func runScript(ctx context.Context, path string, env []string, stdin []byte) error {
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(stdin)
+	// Don't wait on children of the script still holding its output once it's killed.
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(), env...)
+
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline).Round(time.Millisecond)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
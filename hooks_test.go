@@ -0,0 +1,112 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHookMatch(t *testing.T) {
+	h := newHookRunner(&server{}, &Config{})
+	h.on = map[string]bool{hookPrimaryChange: true, hookDown: true, hookRecovery: true}
+
+	for _, step := range []struct {
+		e    pool.Event
+		want []string
+	}{
+		// Backends coming up for the first time fire nothing.
+		{pool.Event{Name: "a", From: pool.UNAVAILABLE, To: pool.READ_WRITE}, nil},
+		{pool.Event{Name: "b", From: pool.UNAVAILABLE, To: pool.READ_ONLY}, nil},
+		{pool.Event{Name: "a", From: pool.READ_WRITE, To: pool.UNAVAILABLE}, []string{hookDown}},
+		{pool.Event{Name: "b", From: pool.READ_ONLY, To: pool.READ_WRITE}, []string{hookPrimaryChange}},
+		{pool.Event{Name: "a", From: pool.UNAVAILABLE, To: pool.READ_ONLY}, []string{hookRecovery}},
+		{pool.Event{Name: "a", From: pool.READ_ONLY, To: pool.READ_ONLY, Violation: "primary"}, nil},
+	} {
+		var got []string
+		for _, payload := range h.match(step.e) {
+			got = append(got, payload.Hook)
+			if payload.Hook == hookPrimaryChange && payload.PreviousPrimary != "a" {
+				t.Errorf("Expected a as the previous primary; instead got %q", payload.PreviousPrimary)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("Expected %v on %s %s -> %s; instead got %v", step.want, step.e.Name, step.e.From, step.e.To, got)
+		}
+	}
+
+	h.on = map[string]bool{hookDown: true}
+	if got := h.match(pool.Event{Name: "b", From: pool.READ_WRITE, To: pool.UNAVAILABLE}); len(got) != 1 {
+		t.Errorf("Expected only the down hook; instead got %v", got)
+	}
+	if got := h.match(pool.Event{Name: "b", From: pool.UNAVAILABLE, To: pool.READ_WRITE}); len(got) != 0 {
+		t.Errorf("Expected hooks left out of on not to fire; instead got %v", got)
+	}
+}
+
+func TestHookFire(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook script is a shell script")
+	}
+
+	var attempts int
+	var got hookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if attempts++; attempts == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the token; instead got %q", req.Header.Get("Authorization"))
+		}
+		json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$ARBITER_HOOK $ARBITER_BACKEND $ARBITER_PREVIOUS_PRIMARY $ARBITER_TO\" > " + out + "\ncat >> " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{}
+	c.Hooks.URL, c.Hooks.Token, c.Hooks.Exec = srv.URL, "secret", script
+	c.Hooks.timeout, c.Hooks.Retries = 5*time.Second, 1
+	h := newHookRunner(&server{logger: log.New(io.Discard, "", 0)}, c)
+
+	h.fire(hookPayload{Hook: hookPrimaryChange, PreviousPrimary: "a",
+		Event: pool.Event{Name: "b", From: pool.READ_ONLY, To: pool.READ_WRITE}})
+
+	if attempts != 2 || got.Hook != hookPrimaryChange || got.Event.Name != "b" {
+		t.Errorf("Expected the hook to be POSTed on the second attempt; instead got %d attempts, %+v", attempts, got)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(b), "\n", 2)
+	if lines[0] != "primary-change b a READ_WRITE" {
+		t.Errorf("Expected the change in the environment; instead got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], `"previous_primary":"a"`) {
+		t.Errorf("Expected the payload on the standard input; instead got %q", lines[1])
+	}
+
+	// A script that runs past the timeout is killed.
+	os.WriteFile(script, []byte("#!/bin/sh\nsleep 5\n"), 0755)
+	h.timeout = 100 * time.Millisecond
+	if err := h.exec(hookPayload{Hook: hookDown}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the script to time out; instead got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package arbiter
+
+import (
+	"context"
+	"fmt"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the namespace of the pod arbiter runs in is mounted.
+const podNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// How often the EndpointSlices are reconciled with the backends even if they didn't
+// change, which retries a reconciliation that failed.
+const kubernetesResync = time.Minute
+
+// kubernetesWatcher keeps the backends in sync with the ready endpoints of a
+// Kubernetes Service, as listed by its EndpointSlices, so that pods of a StatefulSet
+// are added and removed as they come and go, and followed as they're rescheduled.
+type kubernetesWatcher struct {
+	*discovery
+	client    kubernetes.Interface
+	namespace string
+	service   string
+	port      string
+}
+
+// Return a watcher of the Service of [kubernetes], with the credentials of the pod
+// arbiter runs in, or else those of the kubeconfig file.
+func newKubernetesWatcher(s *server, c *Config) (*kubernetesWatcher, error) {
+	var config *rest.Config
+	var err error
+	if c.Kubernetes.Kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", c.Kubernetes.Kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := c.Kubernetes.Namespace
+	if namespace == "" {
+		namespace = "default"
+		if b, err := os.ReadFile(podNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(b))
+		}
+	}
+
+	return &kubernetesWatcher{
+		discovery: newDiscovery(s, "Kubernetes"),
+		client:    client,
+		namespace: namespace,
+		service:   c.Kubernetes.Service,
+		port:      c.Kubernetes.Port,
+	}, nil
+}
+
+// Watch the EndpointSlices of the Service until ctx is done.
+func (kw *kubernetesWatcher) run(ctx context.Context) {
+	factory := informers.NewSharedInformerFactoryWithOptions(kw.client, kubernetesResync,
+		informers.WithNamespace(kw.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = discoveryv1.LabelServiceName + "=" + kw.service
+		}))
+	slices := factory.Discovery().V1().EndpointSlices()
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	slices.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+
+		list, err := slices.Lister().EndpointSlices(kw.namespace).List(labels.Everything())
+		if err == nil {
+			err = kw.sync(list)
+		}
+		if err != nil {
+			kw.s.logger.Printf("Kubernetes: could not sync with service %s/%s: %s", kw.namespace, kw.service, err)
+		}
+	}
+}
+
+// Reconcile the declared state with the ready endpoints of slices: each is a backend
+// named after its pod, or else its address, at the port named by [kubernetes] port,
+// or else the only one.
+func (kw *kubernetesWatcher) sync(slices []*discoveryv1.EndpointSlice) error {
+	discovered := make(map[string]*desiredBackend)
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+
+		port, err := kw.slicePort(slice)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range slice.Endpoints {
+			if len(e.Addresses) == 0 || e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+
+			name := e.Addresses[0]
+			if e.TargetRef != nil && e.TargetRef.Kind == "Pod" {
+				name = e.TargetRef.Name
+			}
+			discovered[name] = &desiredBackend{
+				Address: []string{net.JoinHostPort(e.Addresses[0], strconv.Itoa(int(port)))},
+			}
+		}
+	}
+
+	return kw.update(discovered)
+}
+
+// Return the port of slice that backends are at.
+func (kw *kubernetesWatcher) slicePort(slice *discoveryv1.EndpointSlice) (int32, error) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if kw.port == "" && len(slice.Ports) == 1 || p.Name != nil && *p.Name == kw.port {
+			return *p.Port, nil
+		}
+	}
+
+	if kw.port == "" {
+		return 0, fmt.Errorf("EndpointSlice %s has %d ports; set [kubernetes] port to the name of one",
+			slice.Name, len(slice.Ports))
+	}
+	return 0, fmt.Errorf("EndpointSlice %s has no port named %s", slice.Name, kw.port)
+}
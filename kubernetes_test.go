@@ -0,0 +1,87 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKubernetesWatcher(t *testing.T) {
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared:         &declaration{state: desiredState{Backends: map[string]*desiredBackend{}}},
+	}
+
+	ready, notReady := true, false
+	port, portName := int32(5432), "postgres"
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgres-abcde",
+			Namespace: "databases",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "postgres"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{"127.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+				TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "postgres-0"}},
+			{Addresses: []string{"127.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady},
+				TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "postgres-1"}},
+		},
+	}
+	other := slice.DeepCopy()
+	other.Name, other.Labels = "other-abcde", map[string]string{discoveryv1.LabelServiceName: "other"}
+	other.Endpoints[1].Conditions.Ready = &ready
+
+	client := fake.NewSimpleClientset(slice, other)
+	kw := &kubernetesWatcher{discovery: newDiscovery(s, "Kubernetes"), client: client,
+		namespace: "databases", service: "postgres", port: "postgres"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go kw.run(ctx)
+
+	backends := func() string {
+		var names []string
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			names = append(names, b.Name+"="+b.Addr)
+			return true
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	await := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); backends() != want; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected backends %s; instead got %s", want, backends())
+			}
+		}
+	}
+
+	// Only ready endpoints of the Service are routed to.
+	await("postgres-0=127.0.0.1:5432")
+
+	// postgres-0 is rescheduled, and postgres-1 becomes ready.
+	slice.Endpoints[0].Addresses = []string{"127.0.0.3"}
+	slice.Endpoints[1].Conditions.Ready = &ready
+	if _, err := client.DiscoveryV1().EndpointSlices("databases").Update(ctx, slice, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	await("postgres-0=127.0.0.3:5432,postgres-1=127.0.0.2:5432")
+
+	if err := client.DiscoveryV1().EndpointSlices("databases").Delete(ctx, slice.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	await("")
+}
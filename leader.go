@@ -0,0 +1,160 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// election elects one of several instances of arbiter, such as an HA pair, to serve
+// sessions, with a lock on a key of Consul held by a session of the instance.  The
+// leader renews its session every third of the TTL; should Consul stop hearing from
+// it, the session expires and the lock is released, for a standby to take.  A leader
+// that can't renew its session for the TTL steps down first, so that two never serve
+// at once.  Standbys keep monitoring the backends, so that they take over with a warm
+// view, but turn sessions away and fail /health, so that clients and load balancers
+// find the leader.
+type election struct {
+	cw  *consulWatcher
+	key string
+	ttl time.Duration
+
+	// What the instance is known as in the key's value, as the leader.
+	name string
+
+	// Guards the session of the instance, when it last renewed it, and whether it
+	// holds the lock.
+	mu      sync.Mutex
+	session string
+	renewed time.Time
+	leading bool
+
+	now func() time.Time
+}
+
+func newElection(cw *consulWatcher, c *Config) *election {
+	name := c.Consul.Advertise
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	return &election{cw: cw, key: c.Consul.LeaderKey, ttl: c.Consul.leaderTTL, name: name, now: time.Now}
+}
+
+// Whether the instance leads, and serves sessions.  An instance that isn't electing a
+// leader always does.
+func (s *server) leading() bool {
+	if s.election == nil {
+		return true
+	}
+
+	s.election.mu.Lock()
+	defer s.election.mu.Unlock()
+
+	return s.election.leading
+}
+
+// Turn the client on conn away while the instance is on standby, as a server that isn't
+// accepting connections, so that clients given several hosts try the next.
+func (s *server) standby(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeFatal(conn, sqlstateCannotConnectNow, "arbiter: on standby; another instance leads")
+	conn.Close()
+}
+
+// Take part in the election until the process exits.
+func (e *election) run() {
+	for {
+		e.campaign()
+		time.Sleep(e.ttl / 3)
+	}
+}
+
+// Renew the session of the instance, creating one if it has none or it expired, and
+// try to take the lock with it, or keep it if it holds it already.
+func (e *election) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+
+	e.mu.Lock()
+	session := e.session
+	e.mu.Unlock()
+
+	var err error
+	if session == "" {
+		session, err = e.createSession(ctx)
+	} else if _, err = e.cw.call(ctx, "PUT", "/v1/session/renew/"+session, nil); errors.Is(err, errConsulNotFound) {
+		e.cw.s.logger.Printf("Consul: the session of the leader election expired; creating another")
+		session, err = e.createSession(ctx)
+	}
+
+	var acquired bool
+	if err == nil {
+		acquired, err = e.acquire(ctx, session)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err != nil {
+		e.cw.s.logger.Printf("Consul: leader election: %s", err)
+		if e.leading && e.now().Sub(e.renewed) >= e.ttl {
+			e.cw.s.logger.Printf("Consul: stepping down as the leader; the lock on %s may have been lost", e.key)
+			e.leading = false
+			e.cw.s.sink.SetGauge("arbiter_leader", nil, 0)
+		}
+		e.session = session
+		return
+	}
+
+	e.session, e.renewed = session, e.now()
+	if acquired != e.leading {
+		if acquired {
+			e.cw.s.logger.Printf("Consul: leading, holding the lock on %s", e.key)
+		} else {
+			e.cw.s.logger.Printf("Consul: on standby; another instance holds the lock on %s", e.key)
+		}
+	}
+	e.leading = acquired
+	e.cw.s.sink.SetGauge("arbiter_leader", nil, boolToFloat(acquired))
+}
+
+// Create a session that releases the locks it holds when it expires.
+func (e *election) createSession(ctx context.Context) (string, error) {
+	return e.cw.createSession(ctx, "arbiter "+e.name, e.ttl, "release")
+}
+
+// Try to take the lock with session, returning whether it holds it.
+func (e *election) acquire(ctx context.Context, session string) (bool, error) {
+	resp, err := e.cw.call(ctx, "PUT", "/v1/kv/"+e.key+"?"+url.Values{"acquire": {session}}.Encode(), []byte(e.name))
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	err = json.Unmarshal(resp, &acquired)
+	return acquired, err
+}
+
+// Release the lock, if the instance holds it, by destroying its session, so that a
+// standby takes over right away rather than once the session expires.
+func (e *election) resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.session == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+	defer cancel()
+
+	if _, err := e.cw.call(ctx, "PUT", "/v1/session/destroy/"+e.session, nil); err != nil {
+		e.cw.s.logger.Printf("Consul: could not resign from the leader election: %s", err)
+	}
+	e.session, e.leading = "", false
+}
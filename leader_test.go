@@ -0,0 +1,154 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul holds locks with sessions, as Consul's session and KV APIs do.
+type fakeConsul struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	holder   string
+	next     int
+	down     bool
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	switch path := req.URL.Path; {
+	case path == "/v1/session/create":
+		f.next++
+		id := fmt.Sprintf("session-%d", f.next)
+		f.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		if !f.sessions[strings.TrimPrefix(path, "/v1/session/renew/")] {
+			http.NotFound(w, req)
+		}
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(path, "/v1/session/destroy/")
+		delete(f.sessions, id)
+		if f.holder == id {
+			f.holder = ""
+		}
+	case path == "/v1/kv/arbiter/leader":
+		io.Copy(io.Discard, req.Body)
+		id := req.FormValue("acquire")
+		if f.holder == "" && f.sessions[id] {
+			f.holder = id
+		}
+		json.NewEncoder(w).Encode(f.holder == id)
+	default:
+		http.Error(w, "unexpected request "+req.URL.String(), http.StatusBadRequest)
+	}
+}
+
+// Expire a session, as Consul does once it stops hearing from its holder.
+func (f *fakeConsul) expire(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.sessions, id)
+	if f.holder == id {
+		f.holder = ""
+	}
+}
+
+func TestElection(t *testing.T) {
+	consul := &fakeConsul{sessions: make(map[string]bool)}
+	api := httptest.NewServer(consul)
+	defer api.Close()
+
+	c := &Config{}
+	c.Consul.Address, c.Consul.LeaderKey, c.Consul.leaderTTL = api.URL, "arbiter/leader", 15*time.Second
+
+	now := time.Now()
+	instance := func(name string) *server {
+		s := &server{
+			pool:             pool.New(context.Background()),
+			perBackendLabels: newBackendLabels(),
+			logger:           log.Default(),
+			sink:             metrics.NewMemory(),
+		}
+		c.Consul.Advertise = name
+		s.election = newElection(newConsulWatcher(s, c), c)
+		s.election.now = func() time.Time { return now }
+		return s
+	}
+	a, b := instance("a"), instance("b")
+
+	a.election.campaign()
+	b.election.campaign()
+	if !a.leading() || b.leading() {
+		t.Fatalf("Expected a to lead, and b to stand by; instead got %v and %v", a.leading(), b.leading())
+	}
+
+	// The leader keeps the lock as it renews its session.
+	a.election.campaign()
+	b.election.campaign()
+	if !a.leading() || b.leading() {
+		t.Fatalf("Expected a to keep leading; instead got %v and %v", a.leading(), b.leading())
+	}
+
+	// Once a's session expires, b takes over, and a, whose session is gone, stands by
+	// with another.
+	consul.expire(a.election.session)
+	b.election.campaign()
+	a.election.campaign()
+	if a.leading() || !b.leading() {
+		t.Fatalf("Expected b to take over; instead got %v and %v", a.leading(), b.leading())
+	}
+
+	// A leader that can't reach Consul steps down once its session may have expired.
+	consul.mu.Lock()
+	consul.down = true
+	consul.mu.Unlock()
+	b.election.campaign()
+	if !b.leading() {
+		t.Fatalf("Expected b to keep leading within the TTL")
+	}
+	now = now.Add(15 * time.Second)
+	b.election.campaign()
+	if b.leading() {
+		t.Fatalf("Expected b to step down after the TTL")
+	}
+	consul.mu.Lock()
+	consul.down = false
+	consul.mu.Unlock()
+
+	// Resigning hands the lead over right away.
+	b.election.campaign()
+	b.election.resign()
+	a.election.campaign()
+	if !a.leading() || b.leading() {
+		t.Errorf("Expected a to lead once b resigned; instead got %v and %v", a.leading(), b.leading())
+	}
+
+	// A standby turns clients away.
+	client, conn := tcpPipe(t)
+	defer client.Close()
+	go b.standby(conn)
+	msg, err := io.ReadAll(client)
+	if err != nil || len(msg) == 0 || msg[0] != 'E' || !strings.Contains(string(msg), sqlstateCannotConnectNow) {
+		t.Errorf("Expected an ErrorResponse with %s; instead got %q, %v", sqlstateCannotConnectNow, msg, err)
+	}
+}
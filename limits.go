@@ -0,0 +1,59 @@
+package arbiter
+
+import (
+	"net"
+	"time"
+)
+
+// The size of each of the two buffers used to proxy a session.
+const proxyBufferSize = 4096
+
+// Limits caps the resources arbiter will use, so that it degrades predictably during
+// connection storms instead of running out of memory or descriptors.
+// Zero means unlimited.
+type Limits struct {
+	// Open client sessions.
+	MaxSessions int64
+
+	// Open connections to backends.
+	MaxBackendConns int64
+
+	// Bytes of proxy buffers allocated across all sessions.
+	MaxBufferedBytes int64
+
+	// How long a client may leave its session idle, or idle in a transaction, before
+	// it's closed and its backend connection released.
+	IdleTimeout              time.Duration
+	IdleInTransactionTimeout time.Duration
+
+	// How long a session may last before it's handed off at its next transaction
+	// boundary, as when its backend is drained.
+	MaxSessionLifetime time.Duration
+
+	// How often keepalives are sent to idle clients, to find dead ones; the default
+	// of the net package if zero.
+	ClientKeepAlive time.Duration
+
+	// How long the rest of a session is passed on after one side of it finishes
+	// sending; zero ends the session right away.  See proxy().
+	Linger time.Duration
+
+	// New clients admitted per second, overall and from each client address, in bursts
+	// of up to the burst, and the clients over the overall rate that may wait their turn;
+	// see connThrottle.
+	ConnectionRate        float64
+	ConnectionBurst       int
+	ClientConnectionRate  float64
+	ClientConnectionBurst int
+	AcceptQueue           int
+}
+
+// Turn away a client that would exceed a limit, telling it why.
+func (s *server) reject(conn net.Conn, reason string) {
+	s.rejected.Add(1)
+	s.logger.Printf("Rejecting client %s: %s", conn.RemoteAddr(), reason)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeFatal(conn, sqlstateTooManyConnections, "arbiter: "+reason)
+	conn.Close()
+}
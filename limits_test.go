@@ -0,0 +1,21 @@
+package arbiter
+
+import (
+	"testing"
+)
+
+func TestTryAdd(t *testing.T) {
+	var i AtomicInt
+
+	if !i.TryAdd(2, 3) || i.Get() != 2 {
+		t.Fatalf("Expected TryAdd to add below the limit; instead got %d", i.Get())
+	}
+
+	if i.TryAdd(2, 3) || i.Get() != 2 {
+		t.Fatalf("Expected TryAdd to refuse to exceed the limit; instead got %d", i.Get())
+	}
+
+	if !i.TryAdd(100, 0) || i.Get() != 102 {
+		t.Fatalf("Expected a limit of 0 to be unlimited; instead got %d", i.Get())
+	}
+}
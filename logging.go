@@ -0,0 +1,27 @@
+package arbiter
+
+import (
+	"io"
+	"log/slog"
+)
+
+// Formats of log records; see [main] log-format.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// Return the logger of records configured by [main] log-format, writing to w from level
+// up, or nil if neither log-format nor log-level is set and lines are logged to the
+// standard logger.  A log-level alone logs text.
+func newLogger(c *Config, w io.Writer, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	switch {
+	case c.Main.LogFormat == logJSON:
+		return slog.New(slog.NewJSONHandler(w, opts))
+	case c.Main.LogFormat == logText || c.Main.LogLevel != "":
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+
+	return nil
+}
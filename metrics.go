@@ -0,0 +1,129 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var labelNameRe = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Parse labels given as "key=value" strings.
+func parseLabels(defs []string) (metrics.Labels, error) {
+	l := make(metrics.Labels)
+	for _, def := range defs {
+		kv := strings.SplitN(def, "=", 2)
+		if len(kv) != 2 || !labelNameRe.MatchString(strings.TrimSpace(kv[0])) {
+			return nil, fmt.Errorf("invalid label '%s'; expected name=value", def)
+		}
+		l[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return l, nil
+}
+
+// labelingSink attaches the static labels from the configuration to measurements
+// before passing them on; the global labels to all of them, and those of a backend to
+// the measurements of that backend.
+type labelingSink struct {
+	metrics.Sink
+
+	labels     metrics.Labels
+	perBackend *backendLabels
+}
+
+func (s labelingSink) label(l metrics.Labels) metrics.Labels {
+	return s.labels.With(s.perBackend.get(l["backend"])).With(l)
+}
+
+func (s labelingSink) SetGauge(name string, labels metrics.Labels, value float64) {
+	s.Sink.SetGauge(name, s.label(labels), value)
+}
+
+func (s labelingSink) AddCounter(name string, labels metrics.Labels, delta float64) {
+	s.Sink.AddCounter(name, s.label(labels), delta)
+}
+
+func (s labelingSink) ObserveDuration(name string, labels metrics.Labels, d time.Duration) {
+	s.Sink.ObserveDuration(name, s.label(labels), d)
+}
+
+func (s labelingSink) Delete(match metrics.Labels) {
+	if d, ok := s.Sink.(metrics.Deleter); ok {
+		d.Delete(match)
+	}
+}
+
+// Return where measurements are reported before they're labeled: s.metrics, served on
+// /metrics, and [metrics] statsd, if set.
+func (s *server) measured() metrics.Sink {
+	if s.statsd == nil {
+		return s.metrics
+	}
+
+	return metrics.Tee{s.metrics, s.statsd}
+}
+
+// backendLabels are the static labels of each backend, by name.  They're set from the
+// configuration file, and may be changed through PUT /config while in use.
+type backendLabels struct {
+	sync.RWMutex
+	m map[string]metrics.Labels
+}
+
+func newBackendLabels() *backendLabels {
+	return &backendLabels{m: make(map[string]metrics.Labels)}
+}
+
+// Return the labels of the backend name, if any.  l may be nil.
+func (l *backendLabels) get(name string) metrics.Labels {
+	if l == nil {
+		return nil
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.m[name]
+}
+
+// Set the labels of the backend name, removing them if labels is empty.
+func (l *backendLabels) set(name string, labels metrics.Labels) {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(labels) == 0 {
+		delete(l.m, name)
+		return
+	}
+	l.m[name] = labels
+}
+
+// Serve metrics in the Prometheus text exposition format.
+func (s *server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// These are kept in atomics so that the proxy's data path never takes a lock.
+	metric := func(name, typ string, value int64) {
+		fmt.Fprintf(w, "# TYPE %s %s\n%s%s %d\n", name, typ, name, s.labels, value)
+	}
+
+	metric("arbiter_transferred_bytes_total", "counter", s.transferred.Get())
+	metric("arbiter_client_connections", "gauge", s.nconns.Get())
+	metric("arbiter_backend_connections", "gauge", s.nbackends.Get())
+	metric("arbiter_rejected_connections_total", "counter", s.rejected.Get())
+	metric("arbiter_queued_sessions", "gauge", s.queued.Get())
+	metric("arbiter_refused_connections_total", "counter", s.refused.Get())
+	metric("arbiter_mirrored_sessions_total", "counter", s.mirrored.Get())
+	metric("arbiter_mirror_failures_total", "counter", s.mirrorFailures.Get())
+	if open, max := descriptorUsage(); open >= 0 {
+		metric("arbiter_open_files", "gauge", open)
+		metric("arbiter_max_open_files", "gauge", max)
+	}
+
+	s.metrics.WritePrometheus(w)
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type kind int
+
+const (
+	gauge kind = iota
+	counter
+	summary
+)
+
+type series struct {
+	labels Labels
+	value  float64
+
+	// The number of durations observed by a summary; value holds their sum.
+	count int64
+}
+
+type metric struct {
+	kind   kind
+	series map[string]*series
+}
+
+// Memory is a Sink that keeps the latest value of every series in memory.  It's used
+// by tests to inspect measurements, and by arbiter to serve them in the Prometheus
+// text format.
+type Memory struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewMemory() *Memory {
+	return &Memory{metrics: make(map[string]*metric)}
+}
+
+// Return the series of name with labels, creating it if needed.  m must be locked.
+func (m *Memory) series(name string, k kind, labels Labels) *series {
+	mt, ok := m.metrics[name]
+	if !ok {
+		mt = &metric{kind: k, series: make(map[string]*series)}
+		m.metrics[name] = mt
+	}
+
+	key := labels.String()
+	s, ok := mt.series[key]
+	if !ok {
+		s = &series{labels: labels}
+		mt.series[key] = s
+	}
+
+	return s
+}
+
+func (m *Memory) SetGauge(name string, labels Labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, gauge, labels).value = value
+}
+
+func (m *Memory) AddCounter(name string, labels Labels, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series(name, counter, labels).value += delta
+}
+
+func (m *Memory) ObserveDuration(name string, labels Labels, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.series(name, summary, labels)
+	s.value += d.Seconds()
+	s.count++
+}
+
+// Get returns the value of a gauge or counter, or the sum of a summary in seconds,
+// and whether the series exists.
+func (m *Memory) Get(name string, labels Labels) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mt, ok := m.metrics[name]
+	if !ok {
+		return 0, false
+	}
+
+	s, ok := mt.series[labels.String()]
+	if !ok {
+		return 0, false
+	}
+
+	return s.value, true
+}
+
+// Delete removes all series of all metrics whose labels include match, e.g. those of a
+// backend that's no longer monitored.
+func (m *Memory) Delete(match Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, mt := range m.metrics {
+		for key, s := range mt.series {
+			if includes(s.labels, match) {
+				delete(mt.series, key)
+			}
+		}
+	}
+}
+
+func includes(labels, match Labels) bool {
+	for k, v := range match {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WritePrometheus writes all series in the Prometheus text exposition format.
+func (m *Memory) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mt := m.metrics[name]
+
+		keys := make([]string, 0, len(mt.series))
+		for key := range mt.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var err error
+		switch mt.kind {
+		case gauge:
+			_, err = fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		case counter:
+			_, err = fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		case summary:
+			_, err = fmt.Fprintf(w, "# TYPE %s summary\n", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			s := mt.series[key]
+			if mt.kind == summary {
+				fmt.Fprintf(w, "%s_sum%s %g\n", name, key, s.value)
+				_, err = fmt.Fprintf(w, "%s_count%s %d\n", name, key, s.count)
+			} else {
+				_, err = fmt.Fprintf(w, "%s%s %g\n", name, key, s.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	pg1 := Labels{"backend": "pg1"}
+
+	m.SetGauge("arbiter_backend_rtt_seconds", pg1, 0.5)
+	m.AddCounter("arbiter_transferred_bytes_total", nil, 10)
+	m.AddCounter("arbiter_transferred_bytes_total", nil, 5)
+	m.ObserveDuration("arbiter_check_duration_seconds", pg1, time.Second)
+
+	if v, ok := m.Get("arbiter_transferred_bytes_total", nil); !ok || v != 15 {
+		t.Errorf("Expected the counter to be 15, instead got %g", v)
+	}
+
+	var b bytes.Buffer
+	m.WritePrometheus(&b)
+	expected := `# TYPE arbiter_backend_rtt_seconds gauge
+arbiter_backend_rtt_seconds{backend="pg1"} 0.5
+# TYPE arbiter_check_duration_seconds summary
+arbiter_check_duration_seconds_sum{backend="pg1"} 1
+arbiter_check_duration_seconds_count{backend="pg1"} 1
+# TYPE arbiter_transferred_bytes_total counter
+arbiter_transferred_bytes_total 15
+`
+	if b.String() != expected {
+		t.Errorf("Expected:\n%s\ninstead got:\n%s", expected, b.String())
+	}
+
+	m.Delete(pg1)
+	if _, ok := m.Get("arbiter_backend_rtt_seconds", pg1); ok {
+		t.Errorf("Expected the series of pg1 to have been deleted")
+	}
+}
@@ -0,0 +1,104 @@
+// metrics defines the interface through which arbiter reports its measurements, so
+// that embedders can pipe them into their existing telemetry.
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sink receives measurements.  Implementations must be safe for concurrent use.
+type Sink interface {
+	// SetGauge sets the current value of a measurement.
+	SetGauge(name string, labels Labels, value float64)
+
+	// AddCounter adds delta to an ever-increasing count.
+	AddCounter(name string, labels Labels, delta float64)
+
+	// ObserveDuration records the duration of an operation.
+	ObserveDuration(name string, labels Labels, d time.Duration)
+}
+
+// Deleter may be implemented by a Sink that can forget series, such as those of a
+// backend removed from the pool, so that they aren't exported forever.
+type Deleter interface {
+	// Delete forgets all series whose labels include match.
+	Delete(match Labels)
+}
+
+// Nop is a Sink that discards all measurements.
+type Nop struct{}
+
+func (Nop) SetGauge(string, Labels, float64)              {}
+func (Nop) AddCounter(string, Labels, float64)            {}
+func (Nop) ObserveDuration(string, Labels, time.Duration) {}
+
+// Tee is a Sink that passes measurements on to each of its Sinks, such as a Memory
+// serving them to Prometheus and a Statsd pushing them.
+type Tee []Sink
+
+func (t Tee) SetGauge(name string, labels Labels, value float64) {
+	for _, s := range t {
+		s.SetGauge(name, labels, value)
+	}
+}
+
+func (t Tee) AddCounter(name string, labels Labels, delta float64) {
+	for _, s := range t {
+		s.AddCounter(name, labels, delta)
+	}
+}
+
+func (t Tee) ObserveDuration(name string, labels Labels, d time.Duration) {
+	for _, s := range t {
+		s.ObserveDuration(name, labels, d)
+	}
+}
+
+// Delete forgets the series of those Sinks that are Deleters.
+func (t Tee) Delete(match Labels) {
+	for _, s := range t {
+		if d, ok := s.(Deleter); ok {
+			d.Delete(match)
+		}
+	}
+}
+
+// Labels are key/value pairs that identify a series of measurements.
+type Labels map[string]string
+
+// With returns the union of l and other; other takes precedence.
+func (l Labels) With(other Labels) Labels {
+	ret := make(Labels, len(l)+len(other))
+	for k, v := range l {
+		ret[k] = v
+	}
+	for k, v := range other {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// String renders the labels in the Prometheus text format, sorted by name.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for k := range l {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, k := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, k, escaper.Replace(l[k]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
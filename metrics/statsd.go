@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The largest datagram Statsd sends, so that packets aren't fragmented on an Ethernet
+// MTU.
+const statsdMaxPacket = 1432
+
+// Replaces what the statsd protocol reserves in names and tags.
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+
+// Statsd is a Sink that pushes measurements to a statsd server over UDP, with their
+// labels as Datadog tags, e.g. arbiter_backend_rtt_seconds:0.5|g|#backend:pg1.
+// Gauges are sent as set, counters as their deltas and durations as timers in
+// milliseconds.  Lines are batched into packets sent every interval, or once full;
+// measurements are lost rather than held up if the server is unreachable.
+type Statsd struct {
+	conn   net.Conn
+	prefix string
+
+	mu  sync.Mutex
+	buf []byte
+
+	done chan struct{}
+}
+
+// NewStatsd returns a Statsd sending to addr, host:port, every interval, with prefix
+// prepended to the name of every metric.
+func NewStatsd(addr, prefix string, interval time.Duration) (*Statsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Statsd{conn: conn, prefix: prefix, done: make(chan struct{})}
+	go s.run(interval)
+
+	return s, nil
+}
+
+func (s *Statsd) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.mu.Lock()
+			s.flush()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close sends what's buffered and stops sending.
+func (s *Statsd) Close() error {
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+	return s.conn.Close()
+}
+
+func (s *Statsd) SetGauge(name string, labels Labels, value float64) {
+	s.add(name, strconv.FormatFloat(value, 'g', -1, 64), "g", labels)
+}
+
+func (s *Statsd) AddCounter(name string, labels Labels, delta float64) {
+	s.add(name, strconv.FormatFloat(delta, 'g', -1, 64), "c", labels)
+}
+
+func (s *Statsd) ObserveDuration(name string, labels Labels, d time.Duration) {
+	s.add(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'g', -1, 64), "ms", labels)
+}
+
+// Buffer a line, flushing first if it wouldn't fit in the packet.
+func (s *Statsd) add(name, value, typ string, labels Labels) {
+	line := s.line(name, value, typ, labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdMaxPacket {
+		s.flush()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// Render a line, its tags sorted by name.
+func (s *Statsd) line(name, value, typ string, labels Labels) string {
+	var b strings.Builder
+	b.WriteString(statsdEscaper.Replace(s.prefix + name))
+	b.WriteString(":" + value + "|" + typ)
+
+	if len(labels) > 0 {
+		names := make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for i, k := range names {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteString(",")
+			}
+			b.WriteString(statsdEscaper.Replace(k) + ":" + statsdEscaper.Replace(labels[k]))
+		}
+	}
+
+	return b.String()
+}
+
+// Send what's buffered.  s must be locked.
+func (s *Statsd) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewStatsd(conn.LocalAddr().String(), "prod.", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemory()
+	tee := Tee{mem, s}
+	tee.SetGauge("arbiter_backend_rtt_seconds", Labels{"zone": "eu", "backend": "pg1"}, 0.5)
+	tee.AddCounter("arbiter_backend_dial_errors_total", Labels{"backend": "pg|1"}, 1)
+	tee.ObserveDuration("arbiter_backend_check_duration_seconds", nil, 1500*time.Microsecond)
+	s.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, statsdMaxPacket)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"prod.arbiter_backend_rtt_seconds:0.5|g|#backend:pg1,zone:eu",
+		"prod.arbiter_backend_dial_errors_total:1|c|#backend:pg_1",
+		"prod.arbiter_backend_check_duration_seconds:1.5|ms",
+	}
+	if got := string(buf[:n]); got != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ninstead got:\n%s", strings.Join(expected, "\n"), got)
+	}
+	if v, ok := mem.Get("arbiter_backend_rtt_seconds", Labels{"zone": "eu", "backend": "pg1"}); !ok || v != 0.5 {
+		t.Errorf("Expected the Tee to pass measurements on to each sink; instead got %g", v)
+	}
+
+	// Lines are split into packets that aren't fragmented.
+	s, err = NewStatsd(conn.LocalAddr().String(), "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		s.AddCounter("arbiter_client_sessions_total", Labels{"listener": "primary"}, 1)
+	}
+	s.Close()
+
+	lines := 0
+	for lines < 100 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Expected 100 lines; instead got %d: %v", lines, err)
+		}
+		if n > statsdMaxPacket {
+			t.Errorf("Expected packets of at most %d bytes; instead got %d", statsdMaxPacket, n)
+		}
+		lines += strings.Count(string(buf[:n]), "\n") + 1
+	}
+}
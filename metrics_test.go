@@ -0,0 +1,21 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	l, err := parseLabels([]string{"cluster=main", " dc = eu\"1"})
+	if err != nil {
+		t.Fatalf("Expected the labels to be parsed; instead got %v", err)
+	}
+
+	if s := l.With(metrics.Labels{"backend": "pg1"}).String(); s != `{backend="pg1",cluster="main",dc="eu\"1"}` {
+		t.Errorf("Expected the labels to be sorted and escaped; instead got %s", s)
+	}
+
+	if _, err = parseLabels([]string{"1cluster=main"}); err == nil {
+		t.Errorf("Expected an invalid label name to be rejected")
+	}
+}
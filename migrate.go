@@ -0,0 +1,343 @@
+package arbiter
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importedConfig is what's carried over from the configuration of another proxy by
+// -import: its listeners, backends, limits and timeouts.
+type importedConfig struct {
+	source string
+
+	primary, follower string
+	backends          []importedBackend
+
+	// The database of the backends, checked as a routing database.
+	database string
+
+	connectTimeout, idleTimeout, idleInTransactionTimeout, queueTimeout time.Duration
+	maxSessions                                                         int64
+
+	// What couldn't be carried over, or should be reviewed.
+	notes []string
+}
+
+type importedBackend struct {
+	name, addr string
+}
+
+// Add a backend at addr, unless one is already there.
+func (c *importedConfig) addBackend(name, addr string) {
+	for _, b := range c.backends {
+		if b.addr == addr {
+			return
+		}
+	}
+
+	c.backends = append(c.backends, importedBackend{name, addr})
+}
+
+func (c *importedConfig) note(format string, args ...interface{}) {
+	c.notes = append(c.notes, fmt.Sprintf(format, args...))
+}
+
+// Translate the HAProxy or pgbouncer configuration at path into an arbiter
+// configuration, written to w.  pgbouncer configurations are told apart by their
+// [databases] section.
+func importConfig(path string, w io.Writer) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var c *importedConfig
+	if strings.Contains(string(b), "[databases]") {
+		c, err = importPgBouncer(string(b))
+	} else {
+		c, err = importHAProxy(string(b))
+	}
+	if err != nil {
+		return err
+	}
+
+	c.source = path
+	return c.write(w)
+}
+
+// Translate an HAProxy configuration.  Each listen or frontend section becomes a
+// listener: the follower one if its name or health check mentions replicas, and the
+// primary one otherwise.  Their servers become backends.
+func importHAProxy(src string) (*importedConfig, error) {
+	c := &importedConfig{}
+
+	var section, name, bind string
+	var replicas bool
+	endSection := func() {
+		if bind == "" || (section != "listen" && section != "frontend") {
+			return
+		}
+
+		switch {
+		case replicas && c.follower == "":
+			c.follower = bind
+		case !replicas && c.primary == "":
+			c.primary = bind
+		default:
+			c.note("listener %s at %s isn't carried over; bind it to a class with [listener]", name, bind)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "global", "defaults", "listen", "frontend", "backend":
+			endSection()
+			section, name, bind, replicas = fields[0], "", "", false
+			if len(fields) > 1 {
+				name = fields[1]
+				replicas = mentionsReplicas(name)
+			}
+			continue
+		}
+
+		switch {
+		case fields[0] == "bind" && len(fields) > 1:
+			bind = haproxyAddr(fields[1])
+
+		case fields[0] == "server" && len(fields) > 2:
+			c.addBackend(fields[1], fields[2])
+
+		case fields[0] == "option" && len(fields) > 1 && fields[1] == "httpchk":
+			replicas = replicas || mentionsReplicas(strings.Join(fields[2:], " "))
+
+		case fields[0] == "maxconn" && len(fields) > 1 && section == "global":
+			c.maxSessions, _ = strconv.ParseInt(fields[1], 10, 64)
+
+		case fields[0] == "timeout" && len(fields) > 2 && section == "defaults":
+			d, err := haproxyDuration(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("timeout %s: %s", fields[1], err)
+			}
+			switch fields[1] {
+			case "connect":
+				c.connectTimeout = d
+			case "client":
+				c.idleTimeout = d
+			case "queue":
+				c.queueTimeout = d
+			}
+		}
+	}
+	endSection()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.backends) == 0 {
+		return nil, fmt.Errorf("no servers found")
+	}
+
+	return c, nil
+}
+
+// Whether s, the name or health check of an HAProxy section, is about replicas.
+func mentionsReplicas(s string) bool {
+	s = strings.ToLower(s)
+	for _, word := range []string{"replica", "standby", "follower", "read", "slave"} {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Convert the address of an HAProxy bind line, such as *:5000, to host:port.
+func haproxyAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "*" || host == "" {
+		host = "0.0.0.0"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// Parse an HAProxy duration, in milliseconds unless it has a unit.
+func haproxyDuration(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		return time.Duration(days) * 24 * time.Hour, err
+	}
+
+	return time.ParseDuration(s)
+}
+
+// Translate a pgbouncer configuration.  The hosts of its databases become backends,
+// and its listen address the primary listener.
+func importPgBouncer(src string) (*importedConfig, error) {
+	c := &importedConfig{}
+
+	listenAddr, listenPort := "127.0.0.1", "6432"
+	var section string
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch section {
+		case "databases":
+			addrs, settings, err := pool.ParseConnString(value)
+			if err != nil {
+				c.note("database %s isn't carried over: %s", key, err)
+				continue
+			}
+			for i, addr := range addrs {
+				name := key
+				if len(addrs) > 1 {
+					name = fmt.Sprintf("%s-%d", key, i+1)
+				}
+				c.addBackend(name, addr)
+			}
+
+			if settings.Database == "" {
+				settings.Database = key
+			}
+			if c.database == "" {
+				c.database = settings.Database
+			} else if settings.Database != c.database {
+				c.note("database %s is also routed to; add it as a routing-database", settings.Database)
+			}
+
+		case "pgbouncer":
+			var err error
+			switch key {
+			case "listen_addr":
+				if listenAddr = strings.TrimSpace(strings.Split(value, ",")[0]); listenAddr == "*" {
+					listenAddr = "0.0.0.0"
+				}
+			case "listen_port":
+				listenPort = value
+			case "max_client_conn":
+				c.maxSessions, err = strconv.ParseInt(value, 10, 64)
+			case "server_connect_timeout":
+				c.connectTimeout, err = pgbouncerDuration(value)
+			case "client_idle_timeout":
+				c.idleTimeout, err = pgbouncerDuration(value)
+			case "idle_transaction_timeout":
+				c.idleInTransactionTimeout, err = pgbouncerDuration(value)
+			case "query_wait_timeout":
+				c.queueTimeout, err = pgbouncerDuration(value)
+			case "pool_mode":
+				if value != "session" {
+					c.note("pool_mode %s isn't supported; arbiter routes whole sessions", value)
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.backends) == 0 {
+		return nil, fmt.Errorf("no databases found")
+	}
+
+	c.primary = net.JoinHostPort(listenAddr, listenPort)
+	return c, nil
+}
+
+// Parse a pgbouncer duration, in seconds.
+func pgbouncerDuration(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	return time.Duration(secs * float64(time.Second)), err
+}
+
+// Write c as an arbiter configuration.  Listeners left unknown are given the next
+// ports, noted for review.
+func (c *importedConfig) write(w io.Writer) error {
+	if c.primary == "" {
+		c.primary = "127.0.0.1:5433"
+		c.note("no primary listener found; review primary")
+	}
+	if c.follower == "" {
+		host, port, _ := net.SplitHostPort(c.primary)
+		n, _ := strconv.Atoi(port)
+		c.follower = net.JoinHostPort(host, strconv.Itoa(n+1))
+		c.note("no follower listener found; review follower")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, ";; Imported from %s by arbiter -import.\n", c.source)
+	for _, n := range c.notes {
+		fmt.Fprintf(bw, ";; NOTE: %s\n", n)
+	}
+
+	fmt.Fprintf(bw, "\n[main]\nprimary = %s\nfollower = %s\n", c.primary, c.follower)
+	for _, b := range c.backends {
+		fmt.Fprintf(bw, "\n[backend %q]\naddress = %s\n", b.name, b.addr)
+	}
+
+	fmt.Fprintf(bw, "\n[health]\n;; A user that may connect to the database below.\nusername = arbiter\npassword = arbiter\n")
+	fmt.Fprintf(bw, "database = postgres\n")
+	if c.database != "" && c.database != "postgres" {
+		fmt.Fprintf(bw, "routing-database = %s\n", c.database)
+	}
+	if c.connectTimeout > 0 {
+		fmt.Fprintf(bw, "timeout = %s\n", c.connectTimeout)
+	}
+
+	fmt.Fprintf(bw, "\n[limits]\n")
+	if c.maxSessions > 0 {
+		fmt.Fprintf(bw, "max-sessions = %d\n", c.maxSessions)
+	}
+	if c.idleTimeout > 0 {
+		fmt.Fprintf(bw, "idle-timeout = %s\n", c.idleTimeout)
+	}
+	if c.idleInTransactionTimeout > 0 {
+		fmt.Fprintf(bw, "idle-in-transaction-timeout = %s\n", c.idleInTransactionTimeout)
+	}
+	if c.queueTimeout > 0 {
+		fmt.Fprintf(bw, "max-queued-sessions = 1000\nqueue-timeout = %s\n", c.queueTimeout)
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,95 @@
+package arbiter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Import src, named name, and load the result as a configuration.
+func importAndLoad(t *testing.T, name, src string) *Config {
+	dir := t.TempDir()
+	from := filepath.Join(dir, name)
+	if err := os.WriteFile(from, []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := importConfig(from, &out); err != nil {
+		t.Fatalf("Expected %s to be imported, instead got: %v", name, err)
+	}
+
+	to := filepath.Join(dir, "config.ini")
+	if err := os.WriteFile(to, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ConfigFromFile(to)
+	if err != nil {
+		t.Fatalf("Expected the imported configuration to load, instead got: %v\n%s", err, out.String())
+	}
+
+	return c
+}
+
+func TestImportHAProxy(t *testing.T) {
+	c := importAndLoad(t, "haproxy.cfg", `
+global
+    maxconn 500
+
+defaults
+    mode tcp
+    timeout connect 4s
+    timeout client 30m
+
+listen primary
+    bind *:5000
+    option httpchk OPTIONS /master
+    server pg1 10.0.0.1:5432 maxconn 100 check port 8008
+    server pg2 10.0.0.2:5432 maxconn 100 check port 8008
+
+listen standbys
+    bind *:5001
+    option httpchk OPTIONS /replica # Patroni
+    server pg2 10.0.0.2:5432 maxconn 100 check port 8008
+    server pg3 10.0.0.3:5432 maxconn 100 check port 8008
+`)
+
+	if c.Main.Primary != "0.0.0.0:5000" || c.Main.Follower != "0.0.0.0:5001" {
+		t.Errorf("Expected the listeners to be carried over, instead got %s and %s", c.Main.Primary, c.Main.Follower)
+	}
+	if len(c.Backend) != 3 || c.Backend["pg3"] == nil || c.Backend["pg3"].Address[0] != "10.0.0.3:5432" {
+		t.Errorf("Expected three backends, instead got %v", c.Backend)
+	}
+	if c.Limits.MaxSessions != 500 || c.Limits.idleTimeout != 30*time.Minute || c.Health.settings.ConnectTimeout != 4*time.Second {
+		t.Errorf("Expected the limits and timeouts to be carried over, instead got %+v", c.Limits)
+	}
+}
+
+func TestImportPgBouncer(t *testing.T) {
+	c := importAndLoad(t, "pgbouncer.ini", `
+[databases]
+app = host=10.0.0.1,10.0.0.2 port=5432 dbname=app_prod
+
+[pgbouncer]
+listen_addr = *
+listen_port = 6432
+max_client_conn = 2000
+client_idle_timeout = 600
+`)
+
+	if c.Main.Primary != "0.0.0.0:6432" {
+		t.Errorf("Expected the listener to be carried over, instead got %s", c.Main.Primary)
+	}
+	if len(c.Backend) != 2 || c.Backend["app-2"] == nil || c.Backend["app-2"].Address[0] != "10.0.0.2:5432" {
+		t.Errorf("Expected a backend for each host, instead got %v", c.Backend)
+	}
+	if c.Limits.MaxSessions != 2000 || c.Limits.idleTimeout != 10*time.Minute {
+		t.Errorf("Expected the limits to be carried over, instead got %+v", c.Limits)
+	}
+	if dbs := c.Health.RoutingDatabase; len(dbs) != 1 || dbs[0] != "app_prod" {
+		t.Errorf("Expected app_prod to be checked, instead got %v", dbs)
+	}
+}
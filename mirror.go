@@ -0,0 +1,202 @@
+package arbiter
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The chunks of what a client sent that may wait to be written to the shadow before
+// the session stops being mirrored, so that a shadow that falls behind never holds up
+// the session on the primary.
+const mirrorQueue = 256
+
+// How long arbiter waits to connect to the shadow, and to write each chunk to it.
+const mirrorTimeout = time.Second
+
+// errMirrorBehind stops mirroring a session whose shadow didn't keep up with it.
+var errMirrorBehind = errors.New("the shadow fell behind")
+
+// mirror duplicates what the client of a session on the primary sends to the shadow of
+// [shadow], and discards what the shadow answers, so that a candidate backend, such as
+// one of a new Postgres version or on new hardware, takes the production load without
+// clients depending on it.  What clients authenticate with answers the primary's
+// challenges rather than the shadow's, so it's left out, and the shadow must let them
+// in without, as trust in pg_hba.conf does.  A shadow that fails or falls behind stops
+// the session being mirrored, never the session itself, as does encrypting it end to
+// end.
+type mirror struct {
+	s         *server
+	out       chan []byte
+	stopped   atomic.Bool
+	closeOnce sync.Once
+
+	// Follows the message boundaries of what the client sends: whether messages lack
+	// the type byte, as during startup, the header of the current message and how much
+	// of it has been read, the body bytes left of it, and whether it's left out.
+	// Untyped messages are held in pending until they're complete.
+	untyped bool
+	hdr     [5]byte
+	nhdr    int
+	left    int
+	skip    bool
+	pending []byte
+}
+
+// Return the mirror of a session on backend to the shadow, or nil if it isn't
+// mirrored: unless [shadow] is set, the session is on the primary of [main], and it's
+// among the share of those mirrored.
+func (s *server) mirrorOf(backend net.Conn) *mirror {
+	l, ok := backend.(*pool.Lease)
+	if s.shadow == "" || !ok {
+		return nil
+	}
+	if primary, err := s.pool.GetForWrite(); err != nil || primary != l.Backend() {
+		return nil
+	}
+	if rand.Float64() >= s.shadowShare {
+		return nil
+	}
+
+	s.mirrored.Add(1)
+	return newMirror(s, s.shadow)
+}
+
+// Return a mirror of a session from its start to the shadow at addr, which it connects
+// to in the background.
+func newMirror(s *server, addr string) *mirror {
+	m := &mirror{s: s, out: make(chan []byte, mirrorQueue), untyped: true}
+	go m.run(addr)
+	return m
+}
+
+// Write what the client sent to the shadow, discarding what it answers, until the
+// session ends.
+func (m *mirror) run(addr string) {
+	conn, err := net.DialTimeout("tcp", addr, mirrorTimeout)
+	if err != nil {
+		m.fail(err)
+		return
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	for chunk := range m.out {
+		conn.SetWriteDeadline(time.Now().Add(mirrorTimeout))
+		if _, err := conn.Write(chunk); err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+// Stop mirroring the session because of err.
+func (m *mirror) fail(err error) {
+	if m.stopped.Swap(true) {
+		return
+	}
+
+	m.s.mirrorFailures.Add(1)
+	m.s.logger.Printf("Stopped mirroring a session to the shadow %s: %s", m.s.shadow, err)
+}
+
+// Stop mirroring the session once what was sent is written to the shadow.  m may be
+// nil.
+func (m *mirror) close() {
+	if m == nil {
+		return
+	}
+
+	m.closeOnce.Do(func() { close(m.out) })
+}
+
+// Queue b, which the client sent, to be written to the shadow, leaving out the
+// messages it authenticates with, and SSLRequest and GSSENCRequest.
+func (m *mirror) write(b []byte) {
+	if m.stopped.Load() {
+		return
+	}
+
+	var chunk []byte
+	for len(b) > 0 {
+		if m.left == 0 {
+			hdrlen := 5
+			if m.untyped {
+				hdrlen = 4
+			}
+
+			n := copy(m.hdr[m.nhdr:hdrlen], b)
+			m.nhdr += n
+			b = b[n:]
+			if m.nhdr < hdrlen {
+				break
+			}
+
+			m.nhdr = 0
+			if m.left = int(binary.BigEndian.Uint32(m.hdr[hdrlen-4:hdrlen])) - 4; m.left < 0 {
+				m.left = 0
+			}
+			if m.untyped {
+				m.pending = append(m.pending[:0], m.hdr[:4]...)
+			} else if m.skip = m.hdr[0] == 'p'; !m.skip {
+				chunk = append(chunk, m.hdr[:5]...)
+			}
+			if m.left == 0 {
+				chunk = m.ended(chunk)
+				continue
+			}
+		}
+
+		n := m.left
+		if n > len(b) {
+			n = len(b)
+		}
+		if m.untyped {
+			m.pending = append(m.pending, b[:n]...)
+		} else if !m.skip {
+			chunk = append(chunk, b[:n]...)
+		}
+		m.left -= n
+		b = b[n:]
+
+		if m.left == 0 {
+			chunk = m.ended(chunk)
+		}
+	}
+
+	if len(chunk) == 0 {
+		return
+	}
+	select {
+	case m.out <- chunk:
+	default:
+		m.fail(errMirrorBehind)
+	}
+}
+
+// Account for the end of a message, returning chunk with it if it was untyped and is
+// passed on.
+func (m *mirror) ended(chunk []byte) []byte {
+	if !m.untyped {
+		return chunk
+	}
+
+	code := uint32(0)
+	if len(m.pending) >= 8 {
+		code = binary.BigEndian.Uint32(m.pending[4:8])
+	}
+	switch code {
+	case sslRequestCode, gssencRequestCode:
+		return chunk
+	case protocolVersion3, cancelRequestCode:
+		m.untyped = false
+	}
+
+	return append(chunk, m.pending...)
+}
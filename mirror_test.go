@@ -0,0 +1,54 @@
+package arbiter
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	shadow, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+
+	s := &server{shadow: shadow.Addr().String(), logger: log.New(io.Discard, "", 0)}
+	m := newMirror(s, s.shadow)
+
+	query := msg('Q', cstr("insert into t values (1)"))
+	sent := bytes.Join([][]byte{sslRequest(), startup("user", "app"), msg('p', cstr("secret")), query,
+		msg('X')}, nil)
+	// The client's messages arrive in pieces that don't line up with them.
+	for _, n := range []int{3, 9, 1, 40, 7} {
+		m.write(sent[:n])
+		sent = sent[n:]
+	}
+	m.write(sent)
+	m.close()
+
+	conn, err := shadow.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, _ := io.ReadAll(conn)
+	if want := bytes.Join([][]byte{startup("user", "app"), query, msg('X')}, nil); !bytes.Equal(got, want) {
+		t.Errorf("Expected the shadow to be sent the startup and query alone; instead got %q", got)
+	}
+
+	// A shadow that can't be reached stops the session being mirrored.
+	shadow.Close()
+	m = newMirror(s, s.shadow)
+	m.close()
+	for deadline := time.Now().Add(5 * time.Second); s.mirrorFailures.Get() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.mirrorFailures.Get() != 1 {
+		t.Errorf("Expected the failure to be counted; instead got %d", s.mirrorFailures.Get())
+	}
+}
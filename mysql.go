@@ -0,0 +1,51 @@
+package arbiter
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// The MySQL error codes of clients turned away: ER_CON_COUNT_ERROR for those over a
+// limit, and ER_UNKNOWN_ERROR otherwise.
+const (
+	mysqlTooManyConnections = 1040
+	mysqlUnknownError       = 1105
+)
+
+// mysqlClient is a client of a listener bound to a MySQL cluster.  MySQL servers speak
+// first, so there's no startup packet to read: the session is passed on from the
+// start, opaque to us as one encrypted end to end is, and a client turned away is sent
+// a MySQL ERR packet rather than a Postgres ErrorResponse; see writeFatalHint().
+type mysqlClient struct {
+	net.Conn
+}
+
+func (c mysqlClient) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// Return conn, as a mysqlClient if r is of a MySQL cluster.
+func (r *route) client(conn net.Conn) net.Conn {
+	if r.mysql {
+		return mysqlClient{conn}
+	}
+	return conn
+}
+
+// Write a MySQL ERR packet of message to w, with the SQLSTATE code.  Clients accept one
+// in place of the server's handshake; it's how servers turn away clients over
+// max_connections.
+func writeMySQLError(w io.Writer, code, message string) error {
+	errno := uint16(mysqlUnknownError)
+	if code == sqlstateTooManyConnections {
+		errno = mysqlTooManyConnections
+	}
+
+	payload := binary.LittleEndian.AppendUint16([]byte{0xff}, errno)
+	payload = append(append(append(payload, '#'), code...), message...)
+	packet := append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), 0}, payload...)
+
+	_, err := w.Write(packet)
+	return err
+}
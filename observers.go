@@ -0,0 +1,162 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often an instance publishes what it sees of the backends, and reads what the
+// other instances see.
+const observeInterval = time.Second
+
+// observers shares what instances of arbiter observing the same backends, such as from
+// different networks, see of them, through a key of Consul per instance under a
+// prefix, held by a session of the instance, so that a backend changes state only once
+// a majority of the instances configured agree; see pool.WithObservers().  The key of
+// an instance Consul stops hearing from for the TTL is deleted along with its session,
+// and it stops counting.  An instance that can't reach Consul goes by what the others
+// saw last until that's older than the TTL, and then by nothing, so that, cut off, it
+// keeps the backends as they were rather than failing over alone.
+type observers struct {
+	cw     *consulWatcher
+	prefix string
+	ttl    time.Duration
+
+	// What the instance is known as, its key being <prefix>/<name>.
+	name string
+
+	// The session of the instance, and when it last renewed it; see sync().
+	session string
+	renewed time.Time
+
+	// Guards what the other instances last saw, by instance, then backend, and when
+	// it was read.
+	mu   sync.Mutex
+	seen map[string]map[string]pool.State
+	read time.Time
+
+	now func() time.Time
+}
+
+func newObservers(cw *consulWatcher, c *Config) *observers {
+	name := c.Consul.Advertise
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	return &observers{cw: cw, prefix: strings.TrimSuffix(c.Consul.ObserversPrefix, "/"), ttl: c.Consul.leaderTTL,
+		name: name, seen: make(map[string]map[string]pool.State), now: time.Now}
+}
+
+// Observations returns the states the other instances last saw the backend named name
+// in, unless they were read longer than the TTL ago; it's a pool.Observers.
+func (o *observers) Observations(name string) []pool.State {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.now().Sub(o.read) > o.ttl {
+		return nil
+	}
+
+	var states []pool.State
+	for _, seen := range o.seen {
+		if s, ok := seen[name]; ok {
+			states = append(states, s)
+		}
+	}
+	return states
+}
+
+// Share observations until the process exits.
+func (o *observers) run() {
+	for {
+		if err := o.sync(); err != nil {
+			o.cw.s.logger.Printf("Consul: sharing observations: %s", err)
+		}
+		time.Sleep(observeInterval)
+	}
+}
+
+// Renew the session of the instance every third of the TTL, creating one if it has
+// none or it expired, publish what the instance sees of the backends with it, and
+// read what the others see.
+func (o *observers) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.ttl/3)
+	defer cancel()
+
+	if o.session == "" || o.now().Sub(o.renewed) >= o.ttl/3 {
+		if err := o.renew(ctx); err != nil {
+			return err
+		}
+		o.renewed = o.now()
+	}
+
+	// Backends not checked yet have nothing to say.
+	observed := make(map[string]pool.State)
+	for _, b := range o.cw.s.pool.Backends() {
+		if b.FailStreak > 0 || b.SuccessStreak > 0 {
+			observed[b.Name] = b.Observed
+		}
+	}
+	body, err := json.Marshal(observed)
+	if err != nil {
+		return err
+	}
+	if _, err = o.cw.call(ctx, "PUT", "/v1/kv/"+o.prefix+"/"+o.name+"?"+url.Values{"acquire": {o.session}}.Encode(),
+		body); err != nil {
+		return err
+	}
+
+	resp, err := o.cw.call(ctx, "GET", "/v1/kv/"+o.prefix+"/?recurse=true", nil)
+	if err != nil {
+		return err
+	}
+	var keys []struct {
+		Key   string
+		Value []byte
+	}
+	if err = json.Unmarshal(resp, &keys); err != nil {
+		return err
+	}
+
+	seen := make(map[string]map[string]pool.State)
+	for _, k := range keys {
+		instance := strings.TrimPrefix(k.Key, o.prefix+"/")
+		if instance == o.name {
+			continue
+		}
+		var states map[string]pool.State
+		if err := json.Unmarshal(k.Value, &states); err != nil {
+			o.cw.s.logger.Printf("Consul: ignoring the observations of %s: %s", instance, err)
+			continue
+		}
+		seen[instance] = states
+	}
+
+	o.mu.Lock()
+	o.seen, o.read = seen, o.now()
+	o.mu.Unlock()
+	return nil
+}
+
+// Renew the session of the instance, creating one if it has none or it expired, which
+// deletes its key along with it.
+func (o *observers) renew(ctx context.Context) (err error) {
+	if o.session != "" {
+		_, err = o.cw.call(ctx, "PUT", "/v1/session/renew/"+o.session, nil)
+		if !errors.Is(err, errConsulNotFound) {
+			return err
+		}
+		o.cw.s.logger.Printf("Consul: the session sharing observations expired; creating another")
+	}
+
+	o.session, err = o.cw.createSession(ctx, "arbiter observer "+o.name, o.ttl, "delete")
+	return err
+}
@@ -0,0 +1,158 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKV holds keys with sessions, as Consul's KV API does, deleting them along with
+// their session.
+type fakeKV struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	keys     map[string][]byte
+	holders  map[string]string
+	next     int
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch path := req.URL.Path; {
+	case path == "/v1/session/create":
+		f.next++
+		id := fmt.Sprintf("session-%d", f.next)
+		f.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		if !f.sessions[strings.TrimPrefix(path, "/v1/session/renew/")] {
+			http.NotFound(w, req)
+		}
+	case req.Method == "PUT" && strings.HasPrefix(path, "/v1/kv/"):
+		key := strings.TrimPrefix(path, "/v1/kv/")
+		id := req.FormValue("acquire")
+		b, _ := io.ReadAll(req.Body)
+		if !f.sessions[id] {
+			json.NewEncoder(w).Encode(false)
+			return
+		}
+		f.keys[key], f.holders[key] = b, id
+		json.NewEncoder(w).Encode(true)
+	case req.Method == "GET" && strings.HasPrefix(path, "/v1/kv/"):
+		prefix := strings.TrimPrefix(path, "/v1/kv/")
+		var keys []map[string]interface{}
+		for k, v := range f.keys {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, map[string]interface{}{"Key": k, "Value": v})
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i]["Key"].(string) < keys[j]["Key"].(string) })
+		json.NewEncoder(w).Encode(keys)
+	default:
+		http.Error(w, "unexpected request "+req.URL.String(), http.StatusBadRequest)
+	}
+}
+
+// Expire a session, deleting the keys it holds.
+func (f *fakeKV) expire(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.sessions, id)
+	for k, holder := range f.holders {
+		if holder == id {
+			delete(f.keys, k)
+			delete(f.holders, k)
+		}
+	}
+}
+
+func TestObservers(t *testing.T) {
+	kv := &fakeKV{sessions: make(map[string]bool), keys: make(map[string][]byte), holders: make(map[string]string)}
+	api := httptest.NewServer(kv)
+	defer api.Close()
+
+	c := &Config{}
+	c.Consul.Address, c.Consul.ObserversPrefix, c.Consul.leaderTTL = api.URL, "arbiter/observers", 15*time.Second
+	c.Consul.Observers = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	instance := func(name string, primary bool) (*observers, *queueBackend) {
+		s := &server{perBackendLabels: newBackendLabels(), logger: log.Default(), sink: metrics.NewMemory()}
+		c.Consul.Advertise = name
+		o := newObservers(newConsulWatcher(s, c), c)
+		s.pool = pool.New(ctx, pool.WithManualChecks(time.Now), pool.WithObservers(o, c.Consul.Observers))
+		b := &queueBackend{primary: primary}
+		s.pool.PutNamed("pg1", b)
+		s.pool.CheckAll()
+		return o, b
+	}
+	a, _ := instance("a", true)
+	b, _ := instance("b", true)
+	cut, cutBackend := instance("cut", true)
+
+	// Alone, none of them is a majority; once they've all shared what they see, pg1 is
+	// the primary.
+	if info := a.cw.s.pool.Backends()[0]; info.State == pool.READ_WRITE || !info.Outvoted {
+		t.Fatalf("Expected pg1 to be held until a majority sees it; instead got %+v", info)
+	}
+	for i := 0; i < 2; i++ {
+		for _, o := range []*observers{a, b, cut} {
+			if err := o.sync(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, o := range []*observers{a, b, cut} {
+		o.cw.s.pool.CheckAll()
+		if info := o.cw.s.pool.Backends()[0]; info.State != pool.READ_WRITE {
+			t.Fatalf("Expected pg1 to be the primary once a majority sees it; instead got %+v", info)
+		}
+	}
+
+	// The instance cut off from pg1 is outvoted by the two that still see it.
+	if got := cut.Observations("pg1"); len(got) != 2 || got[0] != pool.READ_WRITE || got[1] != pool.READ_WRITE {
+		t.Fatalf("Expected the others' observations of pg1; instead got %v", got)
+	}
+	cutBackend.mu.Lock()
+	cutBackend.primary = false
+	cutBackend.mu.Unlock()
+	cut.cw.s.pool.CheckAll()
+	if info := cut.cw.s.pool.Backends()[0]; info.State != pool.READ_WRITE || !info.Outvoted {
+		t.Fatalf("Expected pg1 to stay the primary, outvoted; instead got %+v", info)
+	}
+	if err := cut.sync(); err != nil {
+		t.Fatal(err)
+	}
+	a.sync()
+	if got := a.Observations("pg1"); len(got) != 2 || got[0] == got[1] {
+		t.Errorf("Expected what b and the cut off instance observe; instead got %v", got)
+	}
+
+	// An instance whose session expires stops counting.
+	kv.expire(b.session)
+	a.sync()
+	if got := a.Observations("pg1"); len(got) != 1 {
+		t.Errorf("Expected b to stop counting once its session expired; instead got %v", got)
+	}
+
+	// What an instance that can't reach Consul read last expires with the TTL.
+	a.now = func() time.Time { return time.Now().Add(c.Consul.leaderTTL + time.Second) }
+	if got := a.Observations("pg1"); got != nil {
+		t.Errorf("Expected no observations older than the TTL; instead got %v", got)
+	}
+}
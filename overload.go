@@ -0,0 +1,118 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// How often arbiter's own resource usage is sampled.
+const overloadSampleInterval = time.Second
+
+// The resources whose exhaustion makes arbiter shed new clients.
+const (
+	shedCPU    = "cpu"
+	shedFiles  = "open_files"
+	shedMemory = "memory"
+)
+
+// OverloadLimits are thresholds on arbiter's own resource usage, beyond which new
+// clients are turned away as soon as they connect, so that the sessions already
+// established don't all degrade.  Zero disables a threshold.
+type OverloadLimits struct {
+	// CPU time used, as a percentage of all cores.
+	CPUPercent float64
+
+	// Open file descriptors, as a percentage of the limit on them.
+	OpenFilesPercent float64
+
+	// Bytes of memory obtained from the OS and not returned to it.
+	MemoryBytes int64
+}
+
+func (l OverloadLimits) enabled() bool {
+	return l.CPUPercent > 0 || l.OpenFilesPercent > 0 || l.MemoryBytes > 0
+}
+
+// overloadMonitor samples arbiter's resource usage, recording the resource that's
+// over its threshold, if any.
+type overloadMonitor struct {
+	limits OverloadLimits
+
+	// The resource over its threshold, or the empty string.
+	shedding atomic.Value
+
+	// The CPU time used at the last sample, and when it was taken.
+	cpu    time.Duration
+	sample time.Time
+}
+
+func newOverloadMonitor(limits OverloadLimits) *overloadMonitor {
+	m := &overloadMonitor{limits: limits}
+	m.shedding.Store("")
+
+	return m
+}
+
+// Sample resource usage until the process exits.
+func (m *overloadMonitor) run() {
+	m.cpu, m.sample = cpuTime(), time.Now()
+
+	for range time.Tick(overloadSampleInterval) {
+		m.shedding.Store(m.check())
+	}
+}
+
+// Return the resource over its threshold, or the empty string.
+func (m *overloadMonitor) check() string {
+	cpu, now := cpuTime(), time.Now()
+	used, elapsed := cpu-m.cpu, now.Sub(m.sample)
+	m.cpu, m.sample = cpu, now
+
+	if m.limits.CPUPercent > 0 && cpu >= 0 && elapsed > 0 {
+		percent := 100 * float64(used) / float64(elapsed) / float64(runtime.NumCPU())
+		if percent >= m.limits.CPUPercent {
+			return shedCPU
+		}
+	}
+
+	if m.limits.OpenFilesPercent > 0 {
+		if open, max := descriptorUsage(); open >= 0 && max > 0 {
+			if 100*float64(open)/float64(max) >= m.limits.OpenFilesPercent {
+				return shedFiles
+			}
+		}
+	}
+
+	if m.limits.MemoryBytes > 0 {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if int64(ms.Sys-ms.HeapReleased) >= m.limits.MemoryBytes {
+			return shedMemory
+		}
+	}
+
+	return ""
+}
+
+// The resource over its threshold, or the empty string if arbiter isn't overloaded.
+func (s *server) overloaded() string {
+	if s.overload == nil {
+		return ""
+	}
+
+	return s.overload.shedding.Load().(string)
+}
+
+// Turn away a client of listener because arbiter is overloaded.
+func (s *server) shed(conn net.Conn, listener, resource string) {
+	s.sink.AddCounter("arbiter_shed_connections_total",
+		metrics.Labels{"listener": listener, "resource": resource}, 1)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeFatal(conn, sqlstateTooManyConnections, fmt.Sprintf("arbiter: overloaded (%s)", resource))
+	conn.Close()
+}
@@ -0,0 +1,51 @@
+package arbiter
+
+import (
+	"bytes"
+	"github.com/solvip/arbiter/metrics"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestOverloadMonitor(t *testing.T) {
+	m := newOverloadMonitor(OverloadLimits{OpenFilesPercent: 100})
+	if resource := m.check(); resource != "" {
+		t.Fatalf("Expected no resource to be exhausted; instead got %s", resource)
+	}
+
+	// Any process has some descriptors open.
+	m = newOverloadMonitor(OverloadLimits{OpenFilesPercent: 1e-9})
+	if resource := m.check(); resource != shedFiles {
+		t.Fatalf("Expected to shed because of open files; instead got '%s'", resource)
+	}
+
+	m = newOverloadMonitor(OverloadLimits{MemoryBytes: 1})
+	if resource := m.check(); resource != shedMemory {
+		t.Fatalf("Expected to shed because of memory; instead got '%s'", resource)
+	}
+}
+
+func TestShed(t *testing.T) {
+	mem := metrics.NewMemory()
+	s := &server{metrics: mem, sink: mem}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.shed(conn, "primary", shedCPU)
+
+	var want bytes.Buffer
+	writeFatal(&want, sqlstateTooManyConnections, "arbiter: overloaded (cpu)")
+
+	got := make([]byte, want.Len())
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Expected the client to receive %q; instead got %q, %v", want.Bytes(), got, err)
+	}
+
+	var out strings.Builder
+	mem.WritePrometheus(&out)
+	if !strings.Contains(out.String(), `arbiter_shed_connections_total{listener="primary",resource="cpu"} 1`) {
+		t.Fatalf("Expected the shed connection to be counted; instead got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,206 @@
+package arbiter
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Configuration can be overridden from the environment and from the command line, in
+// that order of precedence: flags over environment over file.
+//
+// Every variable in the configuration file has an environment variable named
+// ARBITER_<SECTION>_<VARIABLE>, or ARBITER_<SECTION>_<SUBSECTION>_<VARIABLE> for
+// sections with subsections, in upper case and with dashes replaced by underscores.
+// Subsection names are lower-cased.  For example:
+//
+//	ARBITER_HEALTH_PASSWORD=secret
+//	ARBITER_BACKEND_PG3_INTERVAL=5s
+//
+// On the command line, the same variables are set with -set section.variable=value or
+// -set section.subsection.variable=value.
+//
+// Variables that may be given multiple times in the file, such as a backend's address,
+// take a comma separated list which replaces the values from the file.
+const envPrefix = "ARBITER_"
+
+// configVar describes a variable of the configuration schema.
+type configVar struct {
+	section    string
+	name       string
+	multi      bool
+	subsection bool
+}
+
+// The environment variable of v, with subsection in place for sections that have them.
+func (v configVar) env(subsection string) string {
+	parts := []string{v.section, v.name}
+	if v.subsection {
+		parts = []string{v.section, subsection, v.name}
+	}
+
+	return envPrefix + strings.ToUpper(strings.Replace(strings.Join(parts, "_"), "-", "_", -1))
+}
+
+// List the variables of the configuration schema.
+func configVars() (vars []configVar) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		sect := t.Field(i)
+		st := sect.Type
+		subsection := st.Kind() == reflect.Map
+		if subsection {
+			st = st.Elem().Elem()
+		}
+
+		for _, v := range structVars(st) {
+			v.section = strings.ToLower(sect.Name)
+			v.subsection = subsection
+			vars = append(vars, v)
+		}
+	}
+
+	return vars
+}
+
+// List the variables of a section, including those of embedded structs.
+func structVars(t reflect.Type) (vars []configVar) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch {
+		case f.Anonymous:
+			vars = append(vars, structVars(f.Type)...)
+		case f.PkgPath != "":
+			// Unexported; parsed from other variables.
+		default:
+			name := f.Tag.Get("gcfg")
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			vars = append(vars, configVar{name: name, multi: f.Type.Kind() == reflect.Slice})
+		}
+	}
+
+	return vars
+}
+
+// Return the documentation of all environment variables and flags.
+func configVarsUsage() string {
+	var b bytes.Buffer
+	for _, v := range configVars() {
+		if v.subsection {
+			fmt.Fprintf(&b, "%-36s -set %s.<name>.%s\n", v.env("<NAME>"), v.section, v.name)
+		} else {
+			fmt.Fprintf(&b, "%-36s -set %s.%s\n", v.env(""), v.section, v.name)
+		}
+	}
+
+	return b.String()
+}
+
+// An override of a single variable.
+type override struct {
+	v          configVar
+	subsection string
+	value      string
+}
+
+// Find the overrides among environ, which is in the form returned by os.Environ().
+func envOverrides(environ []string) (ret []override) {
+	vars := configVars()
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envPrefix) {
+			continue
+		}
+		key, value := parts[0], parts[1]
+
+		for _, v := range vars {
+			if !v.subsection {
+				if key == v.env("") {
+					ret = append(ret, override{v: v, value: value})
+				}
+				continue
+			}
+
+			prefix := envPrefix + strings.ToUpper(v.section) + "_"
+			suffix := "_" + strings.ToUpper(strings.Replace(v.name, "-", "_", -1))
+			if len(key) > len(prefix)+len(suffix) && strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) {
+				sub := strings.ToLower(key[len(prefix) : len(key)-len(suffix)])
+				ret = append(ret, override{v: v, subsection: sub, value: value})
+			}
+		}
+	}
+
+	// Apply in a stable order.
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].v.section < ret[j].v.section })
+	return ret
+}
+
+var setRe = regexp.MustCompile(`^([a-zA-Z0-9_-]+)\.(?:(.+)\.)?([a-zA-Z0-9_-]+)=(.*)$`)
+
+// Parse overrides given as section[.subsection].variable=value.
+func flagOverrides(sets []string) (ret []override, err error) {
+	vars := configVars()
+	for _, set := range sets {
+		m := setRe.FindStringSubmatch(set)
+		if m == nil {
+			return nil, fmt.Errorf("invalid override '%s'; expected section.variable=value", set)
+		}
+
+		found := false
+		for _, v := range vars {
+			if v.section == strings.ToLower(m[1]) && v.name == strings.ToLower(m[3]) && v.subsection == (m[2] != "") {
+				ret = append(ret, override{v: v, subsection: m[2], value: m[4]})
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown configuration variable in '%s'", set)
+		}
+	}
+
+	return ret, nil
+}
+
+// Render overrides as configuration file syntax, so that they're parsed exactly like
+// the file is.
+func renderOverrides(overrides []override) string {
+	var b bytes.Buffer
+	for _, o := range overrides {
+		if o.v.subsection {
+			fmt.Fprintf(&b, "[%s %q]\n", o.v.section, o.subsection)
+		} else {
+			fmt.Fprintf(&b, "[%s]\n", o.v.section)
+		}
+
+		if !o.v.multi {
+			fmt.Fprintf(&b, "%s = %q\n", o.v.name, o.value)
+			continue
+		}
+
+		// A blank value, without an equals sign, resets a multi-valued variable.
+		fmt.Fprintf(&b, "%s\n", o.v.name)
+		for _, val := range strings.Split(o.value, ",") {
+			fmt.Fprintf(&b, "%s = %q\n", o.v.name, strings.TrimSpace(val))
+		}
+	}
+
+	return b.String()
+}
+
+// stringsFlag collects the values of a flag given multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
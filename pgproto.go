@@ -0,0 +1,139 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"strings"
+)
+
+// SQLSTATE codes sent to clients that arbiter turns away or hands off.
+const (
+	sqlstateTooManyConnections = "53300"
+	sqlstateAdminShutdown      = "57P01"
+	sqlstateCannotConnectNow   = "57P03"
+	sqlstateUnableToConnect    = "08001"
+
+	// As Postgres turns away clients that no pg_hba.conf entry allows.
+	sqlstateInvalidAuthorization = "28000"
+
+	sqlstateIdleSessionTimeout       = "57P05"
+	sqlstateIdleInTransactionTimeout = "25P03"
+
+	sqlstateInvalidParameterValue = "22023"
+)
+
+// The startup parameter clients give the LSN of their last write in, to read it back
+// from a follower; see minLSN().
+const minLSNParameter = "arbiter.min_lsn"
+
+// The startup parameter clients give the W3C traceparent of the span they connect in,
+// to which arbiter.accept is a child.
+const traceparentParameter = "arbiter.traceparent"
+
+// Write a Postgres ErrorResponse with severity FATAL to w.
+// Clients that haven't completed startup accept an ErrorResponse in place of the
+// authentication request, so this can be used to reject a client before proxying.
+func writeFatal(w io.Writer, code, message string) error {
+	return writeFatalHint(w, code, message, "")
+}
+
+// writeFatal, with a hint for the user if it isn't empty.  A mysqlClient is sent a MySQL
+// ERR packet instead, without the hint.
+func writeFatalHint(w io.Writer, code, message, hint string) error {
+	if _, ok := w.(mysqlClient); ok {
+		return writeMySQLError(w, code, message)
+	}
+
+	var body []byte
+	for _, f := range []struct {
+		typ byte
+		val string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', code},
+		{'M', message},
+		{'H', hint},
+	} {
+		if f.val == "" {
+			continue
+		}
+		body = append(body, f.typ)
+		body = append(body, f.val...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+
+	_, err := w.Write(frame('E', body))
+	return err
+}
+
+// Return a ParameterStatus message.  Backends send these whenever a reported parameter
+// changes, so clients accept them at any point of a session.
+func parameterStatus(name, value string) []byte {
+	body := append(append([]byte(name), 0), value...)
+	return frame('S', append(body, 0))
+}
+
+// Frame body as a message of type typ.
+func frame(typ byte, body []byte) []byte {
+	hdr := make([]byte, 5)
+	hdr[0] = typ
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(body)+4))
+
+	return append(hdr, body...)
+}
+
+// Return the parameters of packet, a StartupMessage, such as user and database, or nil
+// if it's another first packet, such as SSLRequest or CancelRequest.  database
+// defaults to the user, as Postgres does.
+func startupParameters(packet []byte) map[string]string {
+	if len(packet) < 8 || binary.BigEndian.Uint32(packet[4:8]) != protocolVersion3 {
+		return nil
+	}
+
+	// The parameters are pairs of null-terminated names and values.
+	params := make(map[string]string)
+	fields := bytes.Split(packet[8:], []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if len(fields[i]) > 0 {
+			params[string(fields[i])] = string(fields[i+1])
+		}
+	}
+	if _, ok := params["database"]; !ok {
+		params["database"] = params["user"]
+	}
+
+	return params
+}
+
+// Return the LSN a client requires the follower it's routed to to have replayed, given
+// as the arbiter.min_lsn startup parameter; zero if it gives none.
+func minLSN(params map[string]string) (uint64, error) {
+	v := startupParameter(params, minLSNParameter)
+	if v == "" {
+		return 0, nil
+	}
+
+	return pool.ParseLSN(v)
+}
+
+// Return the startup parameter name of params, given as is or as -c name=X in options,
+// as libpq clients do with PGOPTIONS.
+func startupParameter(params map[string]string, name string) string {
+	v, ok := params[name]
+	for fields, i := strings.Fields(params["options"]), 0; !ok && i < len(fields); i++ {
+		opt := strings.TrimPrefix(fields[i], "--")
+		if fields[i] == "-c" && i+1 < len(fields) {
+			i++
+			opt = fields[i]
+		} else if opt == fields[i] {
+			opt = strings.TrimPrefix(opt, "-c")
+		}
+		v, ok = strings.CutPrefix(opt, name+"=")
+	}
+
+	return v
+}
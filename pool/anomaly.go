@@ -0,0 +1,144 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"math"
+	"sort"
+)
+
+// The series of each backend that are given to a Detector: the latency of its checks,
+// and, while it's a follower whose lag is known, its replication lag, in seconds.
+const (
+	SeriesLatency = "latency"
+	SeriesLag     = "lag"
+)
+
+// Detector finds anomalies in the series of measurements of the backends, so that
+// they're flagged before hard thresholds, such as the max lag of a class, trip.
+// Observe is given each measurement of series of the backend named backend, in order,
+// and returns the baseline it's compared to, how far it deviates from it, in standard
+// deviations or whatever unit the detector reports in, and whether that's anomalous.
+// It's called with the pool locked, so it mustn't call into the pool, and should be
+// quick.
+type Detector interface {
+	Observe(backend, series string, value float64) (baseline, deviation float64, anomalous bool)
+}
+
+// DetectorFunc adapts a function to a Detector.
+type DetectorFunc func(backend, series string, value float64) (baseline, deviation float64, anomalous bool)
+
+func (f DetectorFunc) Observe(backend, series string, value float64) (float64, float64, bool) {
+	return f(backend, series, value)
+}
+
+// Anomaly is a measurement a Detector found anomalous.
+type Anomaly struct {
+	Series    string
+	Value     float64
+	Baseline  float64
+	Deviation float64
+}
+
+// WithAnomalyDetector has the series of every backend checked for anomalies by d; see
+// Detector.  A backend going anomalous in a series is logged and announced as an Event,
+// and flagged in BackendInfo.Anomalies and arbiter_backend_anomalous until it's back
+// to normal.  Routing is unaffected.
+func WithAnomalyDetector(d Detector) Option {
+	return func(p *Pool) {
+		p.detector = d
+	}
+}
+
+// NewEWMADetector returns a Detector that keeps an exponentially weighted moving
+// average and variance of each series, weighing each measurement by alpha, and finds a
+// measurement anomalous when it's at least threshold standard deviations above the
+// average, once the series has at least warmup measurements.  The deviation is floored
+// at 1% of the average, so that a series that has barely moved doesn't flag noise.
+func NewEWMADetector(alpha, threshold float64, warmup int) Detector {
+	return &ewmaDetector{alpha: alpha, threshold: threshold, warmup: warmup, series: make(map[string]*ewma)}
+}
+
+type ewmaDetector struct {
+	alpha     float64
+	threshold float64
+	warmup    int
+	series    map[string]*ewma
+}
+
+// ewma is the moving average and variance of a series, over n measurements so far.
+type ewma struct {
+	mean, variance float64
+	n              int
+}
+
+func (d *ewmaDetector) Observe(backend, series string, value float64) (float64, float64, bool) {
+	key := backend + "\x00" + series
+	e := d.series[key]
+	if e == nil {
+		d.series[key] = &ewma{mean: value, n: 1}
+		return value, 0, false
+	}
+
+	baseline := e.mean
+	sd := math.Max(math.Sqrt(e.variance), math.Abs(e.mean)*0.01)
+	var deviation float64
+	if sd > 0 {
+		deviation = (value - e.mean) / sd
+	}
+	anomalous := e.n >= d.warmup && deviation >= d.threshold
+
+	diff := value - e.mean
+	e.mean += d.alpha * diff
+	e.variance = (1 - d.alpha) * (e.variance + d.alpha*diff*diff)
+	e.n++
+
+	return baseline, deviation, anomalous
+}
+
+// Give the series of m to the Detector after a successful check, flagging those that
+// went anomalous and clearing those back to normal.  p must be locked.
+func (p *Pool) detectAnomalies(m *member) {
+	if p.detector == nil {
+		return
+	}
+
+	observed := map[string]float64{SeriesLatency: m.lat.Seconds()}
+	if m.state == READ_ONLY && m.lagKnown {
+		observed[SeriesLag] = m.lag.Seconds()
+	}
+
+	for _, series := range []string{SeriesLatency, SeriesLag} {
+		value, ok := observed[series]
+		var a Anomaly
+		var anomalous bool
+		if ok {
+			a = Anomaly{Series: series, Value: value}
+			a.Baseline, a.Deviation, anomalous = p.detector.Observe(m.name, series, value)
+		}
+
+		l := metrics.Labels{"backend": m.name, "series": series}
+		switch {
+		case anomalous && !m.anomalies[series]:
+			if m.anomalies == nil {
+				m.anomalies = make(map[string]bool)
+			}
+			m.anomalies[series] = true
+			p.logFor(m).Warn("anomalous "+series, "value", value, "baseline", a.Baseline, "deviation", a.Deviation)
+			p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: m.state, Time: p.now(), Anomaly: &a})
+		case !anomalous && m.anomalies[series]:
+			delete(m.anomalies, series)
+			p.logFor(m).Info(series + " back to normal")
+		}
+		p.sink.SetGauge("arbiter_backend_anomalous", l, boolToFloat(m.anomalies[series]))
+	}
+}
+
+// Return the series m is anomalous in, sorted.  p must be at least read-locked.
+func (m *member) anomalous() []string {
+	var series []string
+	for s := range m.anomalies {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+	return series
+}
@@ -0,0 +1,53 @@
+package pool
+
+// WithWALArbitration has a backend observed taking writes while there's a primary
+// replace it only if its WAL is further ahead than the primary's, rather than as the
+// most recent writer.  The one that isn't is superseded: it's neither the primary nor
+// routed reads, so that an old primary resurrected with its stale data during a
+// failover isn't routed to.  A superseded backend takes over only once its WAL passes
+// the primary's, or the primary is gone; while either position isn't known, the
+// primary stays as it is.  Superseded backends are shown by Backends(), and exported
+// as arbiter_backend_superseded.  It has no effect with WithMultiWriter().
+func WithWALArbitration() Option {
+	return func(p *Pool) {
+		p.walArbitration = true
+	}
+}
+
+// Return whether m, which its check found taking writes with its WAL at wal, is to be
+// the primary rather than the one there is, marking the one that isn't superseded.
+// Without WithWALArbitration(), or without a primary, it always is.  p must be
+// locked.
+func (p *Pool) arbitrate(m *member, wal walSample) bool {
+	cur := p.primary
+	if !p.walArbitration || cur == nil || cur == m {
+		m.superseded = false
+		return true
+	}
+
+	var won bool
+	if wal.ok && cur.wal.ok {
+		won = wal.lsn > cur.wal.lsn
+	} else {
+		// Without both positions, keep things as they are: a backend that just started
+		// taking writes takes over as it would without arbitration, and a superseded
+		// one stays so.
+		won = !m.superseded
+	}
+
+	if won {
+		if m.superseded {
+			p.logFor(m).Info("WAL ahead of the primary's; taking over", "primary", cur.name)
+		}
+		m.superseded = false
+		cur.superseded = true
+		return true
+	}
+
+	if !m.superseded {
+		p.logFor(m).Warn("taking writes with WAL behind the primary's; not routed to",
+			"primary", cur.name, "lsn", wal.lsn, "primary_lsn", cur.wal.lsn)
+	}
+	m.superseded = true
+	return false
+}
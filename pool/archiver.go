@@ -0,0 +1,57 @@
+package pool
+
+import (
+	"time"
+)
+
+// ArchiverStatus describes the WAL archiver of a backend.
+type ArchiverStatus struct {
+	ArchivedCount int64
+	FailedCount   int64
+
+	// Zero if nothing has been archived, or failed to archive, yet.
+	LastArchived time.Time
+	LastFailed   time.Time
+
+	// The backend's clock at the time the status was read.
+	Now time.Time
+}
+
+// Whether archiving is currently failing; i.e. the last attempt failed.
+func (s ArchiverStatus) Failing() bool {
+	return s.LastFailed.After(s.LastArchived)
+}
+
+// CheckArchiver enables or disables checking the WAL archiver of the primary.
+// Broken archiving silently destroys point-in-time recovery, so failures are logged
+// and reported by Backends(); they don't affect routing.
+func (p *Pool) CheckArchiver(enabled bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.checkArchiver = enabled
+}
+
+// Update the archiver health of m.  p must be locked.
+func (p *Pool) updateArchiver(m *member, s ArchiverStatus, ok bool) {
+	if !ok {
+		m.archiveFailing, m.archiveFailures, m.archiveLag = false, 0, 0
+		return
+	}
+
+	failing := s.Failing()
+	switch {
+	case failing && !m.archiveFailing:
+		p.logFor(m).Warn("WAL archiving is failing", "failures", s.FailedCount, "last_archived", s.LastArchived)
+	case !failing && m.archiveFailing:
+		p.logFor(m).Info("WAL archiving recovered")
+	}
+
+	m.archiveFailing = failing
+	m.archiveFailures = s.FailedCount
+	m.archiveLag = 0
+	if !s.LastArchived.IsZero() {
+		// Both timestamps come from the backend's clock, so skew doesn't matter.
+		m.archiveLag = s.Now.Sub(s.LastArchived)
+	}
+}
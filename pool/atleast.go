@@ -0,0 +1,45 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+)
+
+type atLeastKey struct{}
+
+// AtLeast returns a context that has Acquire() and Route() route callers that
+// followers may serve only to followers that have replayed WAL up to lsn, such as
+// pg_current_wal_lsn() right after a write of theirs committed, so that they read their
+// own writes; or else to the primary, which always has, unless the class is
+// FollowersOnly.  Followers are known to have replayed up to the position sampled at
+// their last health check.  Zero leaves routing as it is.
+func AtLeast(ctx context.Context, lsn uint64) context.Context {
+	return context.WithValue(ctx, atLeastKey{}, lsn)
+}
+
+func minLSNOf(ctx context.Context) uint64 {
+	lsn, _ := ctx.Value(atLeastKey{}).(uint64)
+	return lsn
+}
+
+// DialAtLeast connects to the closest follower that has replayed WAL up to lsn, or to
+// the primary if none has, as DialClass() does with the "eventual" class; see
+// AtLeast().
+func (p *Pool) DialAtLeast(ctx context.Context, lsn uint64) (*Conn, error) {
+	return p.DialClass(AtLeast(ctx, lsn), "eventual")
+}
+
+// ParseLSN parses the textual representation of a pg_lsn, such as 16/B374D848.
+func ParseLSN(s string) (lsn uint64, err error) {
+	var hi, lo uint32
+	if _, err = fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return lsn, fmt.Errorf("invalid LSN %q: %s", s, err)
+	}
+
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+// FormatLSN returns the textual representation of a pg_lsn, as ParseLSN() parses it.
+func FormatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
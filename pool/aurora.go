@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"errors"
+)
+
+// AuroraReplica is an instance of an Amazon Aurora cluster, as reported by
+// aurora_replica_status().
+type AuroraReplica struct {
+	// The instance identifier, which its endpoint is named after.
+	ServerID string
+
+	// Whether the instance is the writer.
+	Writer bool
+}
+
+// AuroraReplicas returns the instances of the Aurora cluster the backend belongs to,
+// from aurora_replica_status() on any of them.  Readers that haven't reported in five
+// minutes, such as deleted ones, are left out.
+func (p *pg) AuroraReplicas() (replicas []AuroraReplica, err error) {
+	if p.db == nil {
+		return nil, errors.New("no monitoring connection")
+	}
+
+	rows, err := p.db.QueryContext(p.checkContext(), `select server_id, session_id = 'MASTER_SESSION_ID'
+		from aurora_replica_status()
+		where session_id = 'MASTER_SESSION_ID' or last_update_timestamp > now() - interval '5 minutes'
+		order by server_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r AuroraReplica
+		if err = rows.Scan(&r.ServerID, &r.Writer); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+
+	return replicas, rows.Err()
+}
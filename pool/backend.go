@@ -1,6 +1,8 @@
 package pool
 
 import (
+	"context"
+	"fmt"
 	"time"
 )
 
@@ -14,6 +16,20 @@ const (
 
 //go:generate stringer -type=State
 
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(b []byte) error {
+	for _, st := range []State{UNAVAILABLE, READ_ONLY, READ_WRITE} {
+		if string(b) == st.String() {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown state %q", b)
+}
+
 type Backend interface {
 	// Ping will be periodically called by pool in order to assess the health and state
 	// of a backend.
@@ -31,3 +47,162 @@ type Backend interface {
 	// that cannot write to or read from a connection previously returned by Connect().
 	Fail()
 }
+
+// A Backend that holds resources for health checking, such as a monitoring connection,
+// should implement io.Closer.  Pool calls Close() when it stops the backend's monitor;
+// a subsequent Ping() must reestablish whatever Close() released.
+
+// Logger is where the pool and its backends log; *log.Logger satisfies it, and other
+// logging libraries are easily adapted to it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// LoggerSetter may be implemented by a Backend that logs.  Pool gives it its own
+// Logger when the backend is put into the pool.
+type LoggerSetter interface {
+	SetLogger(l Logger)
+}
+
+// ContextPinger may be implemented by a Backend whose health check can be interrupted.
+// Pool calls PingContext() instead of Ping(), with a context that's canceled when the
+// backend's monitor is stopped or the pool's context is canceled.
+type ContextPinger interface {
+	PingContext(ctx context.Context) (State, error)
+}
+
+// ContextConnector may be implemented by a Backend whose connections can be bounded by
+// a context.  Pool calls ConnectContext() instead of Connect() with the context of
+// Acquire() and DialClass(), so that dials are abandoned when it's canceled, and the
+// values it carries, such as a trace, reach the backend's Dialer.
+type ContextConnector interface {
+	ConnectContext(ctx context.Context) (*Conn, error)
+}
+
+// RTTMeasurer may be implemented by a Backend that is able to measure the network
+// round-trip time separately from Ping().
+// Pool orders members by RTT when it's available, so that a backend that is slow to
+// answer the role query because it's busy isn't mistaken for one that is far away.
+type RTTMeasurer interface {
+	// RTT returns the time taken by a protocol-level round trip to the backend.
+	RTT() (time.Duration, error)
+}
+
+// WALReporter may be implemented by a Backend that is able to report its position in
+// the write-ahead log.  Pool uses it to compute the replication lag of followers.
+type WALReporter interface {
+	// WALPosition returns the current WAL position; the insert position on a primary
+	// and the replay position on a follower.  It also returns the backend's clock at the
+	// time the position was read.
+	WALPosition() (lsn uint64, clock time.Time, err error)
+}
+
+// LagReporter may be implemented by a Backend that can report how far behind its
+// primary it is in time, such as one of a system whose log positions can't be compared
+// across servers.  It's only consulted on followers, and its lag takes precedence over
+// that computed from WALPosition().
+type LagReporter interface {
+	Lag() (time.Duration, error)
+}
+
+// Readdresser may be implemented by a Backend whose address can change while it keeps
+// its identity, such as a cloud database that moves between IPs.
+type Readdresser interface {
+	SetAddr(address string)
+}
+
+// ArchiveReporter may be implemented by a Backend that is able to report the status of
+// its WAL archiver.  It's only consulted on the primary, if Pool.CheckArchiver is on.
+type ArchiveReporter interface {
+	ArchiverStatus() (ArchiverStatus, error)
+}
+
+// Checksummer may be implemented by a Backend that can run a query returning a single
+// value, used to detect followers that have silently diverged from the primary.
+type Checksummer interface {
+	Checksum(query string) (string, error)
+}
+
+// Heartbeater may be implemented by a Backend that can write a timestamp into a
+// heartbeat table on the primary, and read it back on followers.
+type Heartbeater interface {
+	// WriteHeartbeat stores ts in table, creating the table first if create is set.
+	WriteHeartbeat(table string, ts time.Time, create bool) error
+
+	// ReadHeartbeat returns the last timestamp stored in table.
+	ReadHeartbeat(table string) (time.Time, error)
+}
+
+// TopologyWriter may be implemented by a Backend that can store the pool's view of the
+// backends in a table, so that it can be queried from inside the database; see
+// Pool.PublishTopology().  It's only called on the primary.
+type TopologyWriter interface {
+	// WriteTopology replaces the contents of table with backends, creating the table
+	// first if create is set.
+	WriteTopology(table string, backends []BackendInfo, create bool) error
+}
+
+// Fencer may be implemented by a Backend that can keep a fencing epoch in a table, so
+// that sessions of a previous primary can be fenced off; see Pool.EnableFencing().  It's
+// only called on the primary.
+type Fencer interface {
+	// Fence advances the epoch in table, naming primary, unless it already names it,
+	// creating the table first if create is set.  Returns the epoch.
+	Fence(table, primary string, create bool) (epoch uint64, err error)
+}
+
+// ReceiveReporter may be implemented by a WALReporter that can also report how far a
+// follower has received WAL, so that WAL received but not yet replayed, such as while
+// replay is held up by conflicting queries, can be told apart from WAL not yet
+// streamed.  It's only consulted on followers.
+type ReceiveReporter interface {
+	// ReceivePosition returns the position of the last WAL received and flushed.
+	ReceivePosition() (lsn uint64, err error)
+}
+
+// UpstreamReporter may be implemented by a Backend that can report the address of the
+// server it replicates from, so that cascading replication can be told apart from
+// followers of the primary.  It's only consulted on followers.
+type UpstreamReporter interface {
+	// Upstream returns the host:port replicated from, or the empty string if unknown.
+	Upstream() (string, error)
+}
+
+// SyncReporter may be implemented by a Backend that can report how the primary it
+// reaches replicates synchronously, so that followers acknowledging commits can be
+// told apart; see Class.QuorumOnly.  It's only consulted on the primary.
+type SyncReporter interface {
+	// SyncStandbys returns the primary's synchronous_standby_names, and the standbys
+	// connected to it.
+	SyncStandbys() (SyncConfig, []SyncStandby, error)
+}
+
+// NodeIdentifier may be implemented by a Backend that can identify the server it
+// reaches, so that a server registered twice under different addresses, such as a
+// hostname and an IP address, is detected.
+type NodeIdentifier interface {
+	// NodeID returns an identifier that differs between servers, even those
+	// replicating from one another, and is stable until the server restarts.
+	NodeID() (string, error)
+}
+
+// CheckIntervaler may be implemented by a Backend that should be checked at a different
+// interval than the pool's default.  A zero interval means the default.
+type CheckIntervaler interface {
+	CheckInterval() time.Duration
+}
+
+// CheckTimeouter may be implemented by a ContextPinger whose health checks should be
+// abandoned after a different timeout than the pool's; see WithCheckTimeout().  A zero
+// timeout means the default.
+type CheckTimeouter interface {
+	CheckTimeout() time.Duration
+}
+
+// Promoter may be implemented by a Backend that can promote the follower it reaches to
+// primary; see Pool.Promote().  It's only called on followers.
+type Promoter interface {
+	// Promote promotes the follower, returning once it takes writes, or with an error
+	// if it doesn't before ctx is done.
+	Promote(ctx context.Context) error
+}
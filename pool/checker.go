@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// HealthChecker checks a backend of another kind than Postgres, such as MySQL with
+// group replication, a Redis deployment watched by Sentinel, or a mock in tests; see
+// NewCheckedBackend().  Each method is bounded by the context of the health check.  A
+// HealthChecker that's also an io.Closer is closed once its backend is no longer
+// monitored.
+type HealthChecker interface {
+	// CheckRole returns READ_WRITE if the backend takes writes, or READ_ONLY if it
+	// only serves reads; an error takes it out of routing.
+	CheckRole(ctx context.Context) (State, error)
+
+	// CheckLag returns how far behind its primary the backend is.  It's only called on
+	// followers.
+	CheckLag(ctx context.Context) (time.Duration, error)
+
+	// Latency returns the network round-trip time to the backend, apart from the time
+	// it takes to answer CheckRole(), so that a busy backend isn't taken for a distant
+	// one.
+	Latency(ctx context.Context) (time.Duration, error)
+}
+
+// checked is a Backend checked by a HealthChecker.
+type checked struct {
+	addr string
+	hc   HealthChecker
+	d    Dialer
+
+	// The context of the health check in progress.
+	checkCtx context.Context
+
+	// The connections returned by Connect() and not yet closed.
+	inflight connSet
+}
+
+// NewCheckedBackend returns a backend at addr, checked by hc rather than as a Postgres
+// server, whose connections are dialed over TCP with d, or a net.Dialer if it's nil.
+func NewCheckedBackend(addr string, hc HealthChecker, d Dialer) Backend {
+	if d == nil {
+		d = &net.Dialer{}
+	}
+
+	return &checked{addr: addr, hc: hc, d: d, checkCtx: context.Background()}
+}
+
+func (c *checked) Addr() string {
+	return c.addr
+}
+
+func (c *checked) Ping() (State, error) {
+	return c.PingContext(context.Background())
+}
+
+// PingContext checks the role of the backend, bounding the rest of the health check,
+// RTT() and Lag(), by ctx too.
+func (c *checked) PingContext(ctx context.Context) (State, error) {
+	c.checkCtx = ctx
+	return c.hc.CheckRole(ctx)
+}
+
+func (c *checked) RTT() (time.Duration, error) {
+	return c.hc.Latency(c.checkCtx)
+}
+
+func (c *checked) Lag() (time.Duration, error) {
+	return c.hc.CheckLag(c.checkCtx)
+}
+
+func (c *checked) Connect(t time.Duration) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t)
+	defer cancel()
+
+	return c.ConnectContext(ctx)
+}
+
+func (c *checked) ConnectContext(ctx context.Context) (*Conn, error) {
+	underlying, err := c.d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		c.Fail()
+		return nil, err
+	}
+
+	conn := &Conn{underlying: underlying}
+	c.inflight.add(conn)
+
+	return conn, nil
+}
+
+func (c *checked) Fail() {
+	c.inflight.closeAll()
+}
+
+// Close closes the HealthChecker, if it's an io.Closer.
+func (c *checked) Close() error {
+	if closer, ok := c.hc.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
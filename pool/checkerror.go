@@ -0,0 +1,104 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/solvip/arbiter/metrics"
+	"net"
+)
+
+// ErrorKind classifies why a health check failed, so that the kinds that don't mean a
+// backend is unreachable or broken can be handled apart; see WithDegradeOn().
+type ErrorKind string
+
+const (
+	// The backend rejected the monitoring credential.
+	AuthError ErrorKind = "auth"
+
+	// The monitoring user lacks a privilege a check query needs, as in "permission
+	// denied for function pg_is_in_recovery".
+	PermissionError ErrorKind = "permission"
+
+	// The check, or a query of it, took too long.
+	TimeoutError ErrorKind = "timeout"
+
+	// The backend couldn't be reached, or the connection to it broke.
+	NetworkError ErrorKind = "network"
+
+	// The backend answered with any other error.
+	ProtocolError ErrorKind = "protocol"
+)
+
+// ErrorKinds lists the kinds of check errors.
+var ErrorKinds = []ErrorKind{AuthError, PermissionError, TimeoutError, NetworkError, ProtocolError}
+
+// ClassifyError returns the kind of the check error err.  Errors the backend didn't
+// answer with are taken to be network errors.
+func ClassifyError(err error) ErrorKind {
+	var pqErr *pq.Error
+	var myErr *MySQLError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return TimeoutError
+	case errors.As(err, &myErr):
+		return classifyMySQLError(myErr)
+	case !errors.As(err, &pqErr):
+		return NetworkError
+	case pqErr.Code.Class() == "28":
+		return AuthError
+	case pqErr.Code == "42501":
+		return PermissionError
+	case pqErr.Code == "57014":
+		// query_canceled, as when the check's context expires mid-query.
+		return TimeoutError
+	}
+
+	return ProtocolError
+}
+
+// Return the kind of the check error a MySQL server answered with.
+func classifyMySQLError(err *MySQLError) ErrorKind {
+	switch err.Code {
+	case mysqlAccessDenied, mysqlDBAccessDenied:
+		return AuthError
+	case mysqlSpecificAccessDenied, mysqlTableAccessDenied:
+		// As SHOW REPLICA STATUS without the REPLICATION CLIENT privilege.
+		return PermissionError
+	case mysqlQueryInterrupted, mysqlExecutionTimeout:
+		return TimeoutError
+	}
+
+	return ProtocolError
+}
+
+// Return whether the check of m that failed with err is to be held, because err is of
+// a kind that degrades m rather than failing it; see WithDegradeOn().  Such a member
+// keeps its state and stays routed to, and an alert is raised until a check no longer
+// fails so.  Its failed checks don't count toward WithFlapThresholds(), and transient
+// states are left to holdTransient().  p must be locked.
+func (p *Pool) holdDegraded(m *member, err error) (held bool) {
+	var kind ErrorKind
+	if err != nil && m.state != UNAVAILABLE && m.transient == "" {
+		if k := ClassifyError(err); p.degradeOn[k] {
+			kind = k
+		}
+	}
+
+	if kind != m.degraded {
+		labels := metrics.Labels{"backend": m.name}
+		if kind == "" {
+			p.logFor(m).Info("no longer degraded")
+			p.sink.SetGauge("arbiter_backend_degraded", labels, 0)
+		} else {
+			p.logFor(m).Warn("degraded; still routing to it", "kind", kind, "error", err)
+			p.sink.SetGauge("arbiter_backend_degraded", labels, 1)
+			p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: m.state, Time: p.now(),
+				Degraded: string(kind)})
+		}
+		m.degraded = kind
+	}
+
+	return kind != ""
+}
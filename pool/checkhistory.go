@@ -0,0 +1,122 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The latency quantiles CheckWindow reports.
+var checkQuantiles = []float64{0.5, 0.95, 0.99}
+
+// checkSample is the outcome of a health check: when it completed, how long it took,
+// and whether it succeeded.
+type checkSample struct {
+	at  time.Time
+	lat time.Duration
+	ok  bool
+}
+
+// CheckWindow summarizes the health checks of a backend that completed within Window
+// of the last one: Checks of them, the share of which, from 0 to 1, succeeded, and the
+// 50th, 95th and 99th percentiles of the latency of those that did.  The percentiles
+// are zero if none did.  Checks falls short of the window's worth of checks while the
+// history kept doesn't go back that far; see WithCheckHistory().
+type CheckWindow struct {
+	Window time.Duration
+	Checks int
+	Uptime float64
+
+	P50, P95, P99 time.Duration
+}
+
+// WithCheckHistory keeps the outcomes of the last size health checks of each backend,
+// and summarizes them over each of windows, such as the last 5 minutes and the last
+// hour, in BackendInfo.CheckWindows and the arbiter_backend_uptime_ratio and
+// arbiter_backend_latency_quantile_seconds metrics, labelled with the window, so that
+// a backend's latency and availability can be told over time rather than by its last
+// check alone.  Windows longer than size checks take are summarized over the history
+// kept.  The default size of zero keeps none.
+func WithCheckHistory(size int, windows ...time.Duration) Option {
+	return func(p *Pool) {
+		p.historySize = size
+		p.historyWindows = windows
+	}
+}
+
+// Record the outcome of a check of m that completed at now.  p must be locked.
+func (p *Pool) recordCheck(m *member, now time.Time, lat time.Duration, ok bool) {
+	if p.historySize <= 0 {
+		return
+	}
+
+	s := checkSample{at: now, lat: lat, ok: ok}
+	if len(m.history) < p.historySize {
+		m.history = append(m.history, s)
+		return
+	}
+	m.history[m.historyNext] = s
+	m.historyNext = (m.historyNext + 1) % len(m.history)
+}
+
+// Return the summaries of the check history of m over each window of the pool, as of
+// its last check.  p must be at least read-locked.
+func (p *Pool) checkWindows(m *member) []CheckWindow {
+	if len(m.history) == 0 || len(p.historyWindows) == 0 {
+		return nil
+	}
+
+	last := m.history[(m.historyNext+len(m.history)-1)%len(m.history)].at
+	windows := make([]CheckWindow, 0, len(p.historyWindows))
+	for _, d := range p.historyWindows {
+		w := CheckWindow{Window: d}
+		var up int
+		var lats []time.Duration
+		for _, s := range m.history {
+			if last.Sub(s.at) >= d {
+				continue
+			}
+			w.Checks++
+			if s.ok {
+				up++
+				lats = append(lats, s.lat)
+			}
+		}
+
+		if w.Checks > 0 {
+			w.Uptime = float64(up) / float64(w.Checks)
+		}
+		if len(lats) > 0 {
+			w.P50, w.P95, w.P99 = percentile(lats, 0.5), percentile(lats, 0.95), percentile(lats, 0.99)
+		}
+		windows = append(windows, w)
+	}
+
+	return windows
+}
+
+// Report the summaries of the check history of m.  p must be locked.
+func (p *Pool) reportCheckWindows(m *member, l metrics.Labels) {
+	for _, w := range p.checkWindows(m) {
+		wl := l.With(metrics.Labels{"window": FormatWindow(w.Window)})
+		p.sink.SetGauge("arbiter_backend_uptime_ratio", wl, w.Uptime)
+		for i, d := range []time.Duration{w.P50, w.P95, w.P99} {
+			ql := wl.With(metrics.Labels{"quantile": strconv.FormatFloat(checkQuantiles[i], 'f', -1, 64)})
+			p.sink.SetGauge("arbiter_backend_latency_quantile_seconds", ql, d.Seconds())
+		}
+	}
+}
+
+// FormatWindow formats d as time.Duration does, but without zero minutes and seconds,
+// such as 5m or 1h rather than 5m0s or 1h0m0s.
+func FormatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,416 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrUnknownClass = errors.New("unknown consistency class")
+
+// The timeout used by DialClass when the context carries no deadline.
+const defaultDialTimeout = 5 * time.Second
+
+// Class describes the consistency guarantee a caller requires of the backend it's
+// routed to, so that callers can pick guarantees instead of raw roles.
+type Class struct {
+	// Only the primary satisfies the class.
+	PrimaryOnly bool
+
+	// Followers must be at most MaxLag behind the primary.  Followers whose lag isn't
+	// known are excluded.  Zero allows any follower.
+	MaxLag time.Duration
+
+	// Only followers satisfy the class, so that reads are kept off the primary.
+	FollowersOnly bool
+
+	// Only followers counting toward the synchronous quorum of the primary satisfy the
+	// class, besides the primary itself, so that reads only see commits once they're
+	// acknowledged as durable; see SyncReporter.
+	QuorumOnly bool
+
+	// Followers counting toward the synchronous quorum of the primary, and the primary
+	// itself, are preferred while any of them satisfies the class, so that reads see
+	// commits as soon as they're acknowledged without failing when no synchronous
+	// standby is available.
+	PreferQuorum bool
+
+	// How callers are spread among the backends that satisfy the class.
+	Balance Balance
+
+	// With WithMultiWriter(), the write group whose writer satisfies a PrimaryOnly
+	// class, rather than the primary, the writer of the default group.
+	WriteGroup string
+
+	// Followers must have replayed WAL up to minLSN, or else the primary is picked,
+	// unless the class is FollowersOnly; see AtLeast().
+	minLSN uint64
+}
+
+// Balance is a strategy for spreading callers among the backends that satisfy a class.
+type Balance int
+
+const (
+	// The pool's strategy: the latency band, least connections or a Scorer; see
+	// WithLatencyBand(), WithLeastConnections() and WithScorer().
+	BalanceDefault Balance = iota
+
+	// Each backend in turn, regardless of latency.
+	BalanceRoundRobin
+
+	// The closest backend.
+	BalanceLeastLatency
+
+	// The backend with the fewest outstanding leases, regardless of latency, as with
+	// WithLeastConnections(), so that long-lived sessions don't pile up on the closest.
+	BalanceLeastConnections
+)
+
+var (
+	// Strong is satisfied only by the primary.
+	Strong = Class{PrimaryOnly: true}
+
+	// Eventual is satisfied by any available backend.
+	Eventual = Class{}
+)
+
+// DefineClass registers a named consistency class, replacing any previous definition.
+// The classes "strong" and "eventual" are defined by New().
+func (p *Pool) DefineClass(name string, c Class) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.classes[name] = c
+}
+
+// GetForClass returns the closest backend that satisfies the named class.
+func (p *Pool) GetForClass(name string) (b Backend, err error) {
+	return p.getForClass(context.Background(), name)
+}
+
+// Return the closest backend that satisfies the named class, honoring AtLeast() in ctx.
+func (p *Pool) getForClass(ctx context.Context, name string) (b Backend, err error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	c, ok := p.classes[name]
+	if !ok {
+		return nil, ErrUnknownClass
+	}
+	c.minLSN = minLSNOf(ctx)
+
+	m := p.pick(c, "")
+	if m == nil {
+		return nil, ErrNoneAvailable
+	}
+
+	return m.b, nil
+}
+
+// Candidates returns the names of the backends a caller of the named class may be
+// routed to right now, closest first; see pick() for how they're chosen among.
+func (p *Pool) Candidates(class string) ([]string, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	c, ok := p.classes[class]
+	if !ok {
+		return nil, ErrUnknownClass
+	}
+
+	var names []string
+	members, _ := p.candidates(c, "")
+	for _, m := range members {
+		names = append(names, m.name)
+	}
+
+	return names, nil
+}
+
+// Followers returns how many followers may serve a caller of the named class right
+// now, leaving out the primary even if the class allows it, so that callers can hold
+// reads to a minimum of healthy followers.  Primary-only classes have none.
+func (p *Pool) Followers(class string) (int, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	c, ok := p.classes[class]
+	if !ok {
+		return 0, ErrUnknownClass
+	}
+
+	c.FollowersOnly = true
+	n := 0
+	for _, m := range p.avail {
+		if m.satisfies(c) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Route returns the name of the backend Acquire() would route a caller of the named
+// class with ctx to right now, without connecting to it, so that callers can tell
+// where sessions go; if no backend satisfies the class, a *DegradedError tells why.
+// Balancing counts it as routed, as Acquire() does.
+func (p *Pool) Route(ctx context.Context, class string) (string, error) {
+	return p.route(ctx, class, true)
+}
+
+// Peek returns the name of the backend Route() would route a caller of the named class
+// with ctx to right now, without counting it as routed, so that asking where callers
+// go doesn't move the next one along.
+func (p *Pool) Peek(ctx context.Context, class string) (string, error) {
+	return p.route(ctx, class, false)
+}
+
+// Return the name of the backend a caller of the named class with ctx is routed to, as
+// Route(), counting it as routed if routed is set.
+func (p *Pool) route(ctx context.Context, class string, routed bool) (string, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	c, ok := p.classes[class]
+	if !ok {
+		return "", ErrUnknownClass
+	}
+	c.minLSN = minLSNOf(ctx)
+
+	m := p.stuck(c, p.stickyKey(ctx, c, class))
+	if m == nil {
+		m = p.choose(c, zoneOf(ctx), routed)
+	}
+	if m == nil {
+		return "", degraded(c)
+	}
+
+	return m.name, nil
+}
+
+// Return the member that a caller requiring c should be routed to, or nil if none
+// satisfies it: the one with the fewest outstanding leases among the candidates if
+// WithLeastConnections() is on or c balances by BalanceLeastConnections, and
+// otherwise each candidate in turn, either way in proportion to their weights and
+// holding back those that are slow starting; see SetWeight() and SlowStart().  Those in
+// zone are preferred, if it isn't empty; see InZone().  If c has a minimum LSN no
+// follower has replayed, it's the primary, unless c is FollowersOnly.  p must be at
+// least read-locked.
+func (p *Pool) pick(c Class, zone string) *member {
+	return p.choose(c, zone, true)
+}
+
+// Return the member pick() does, moving the rotation among the candidates on to the
+// next one only if routed is set.  p must be at least read-locked.
+func (p *Pool) choose(c Class, zone string, routed bool) (best *member) {
+	candidates, balanced := p.candidates(c, zone)
+	switch {
+	case len(candidates) == 0 && c.minLSN != 0 && !c.PrimaryOnly && !c.FollowersOnly:
+		// No follower has replayed the caller's writes, but the primary has.
+		return p.choose(Class{PrimaryOnly: true, WriteGroup: c.WriteGroup}, zone, routed)
+	case len(candidates) == 0:
+		return nil
+	case !balanced:
+		return candidates[0]
+	}
+
+	if c.Balance == BalanceRoundRobin || c.Balance == BalanceDefault && !p.leastConns {
+		return p.rotate(candidates, routed)
+	}
+
+	now := p.now()
+	max := maxWeight(candidates)
+	var bestLoad float64
+	for _, m := range candidates {
+		// A member that's slow starting, or light, counts as more loaded than it is.
+		load := float64(atomic.LoadInt64(&m.leases)+1) / m.share(now, max)
+		if best == nil || load < bestLoad {
+			best, bestLoad = m, load
+		}
+	}
+
+	return best
+}
+
+// Return the members that a caller requiring c may be routed to, closest first, and
+// whether they're to be balanced among rather than the first picked.  They're the
+// members within the latency band of the closest one that satisfy c, or all of them
+// with WithLeastConnections() and no band; see WithLatencyBand().  A Scorer picks a
+// single one; see WithScorer().  The class's Balance overrides all of these.  Only
+// members of weight above 0 are considered if any of them satisfy c, then only those
+// in the quorum if c prefers them and any of them do, and then only those in zone if
+// any of them do; see SetWeight().  While there are no members, it's the fallback, if
+// any.  p must be at least read-locked.
+func (p *Pool) candidates(c Class, zone string) (members []*member, balanced bool) {
+	if len(p.members) == 0 && p.fallback != nil {
+		return []*member{p.fallback}, false
+	}
+
+	if c.PrimaryOnly && !p.distributed {
+		w := p.writerOf(c.WriteGroup)
+		if w == nil || w.draining || w.cordoned || w.transient != "" {
+			return nil, false
+		}
+		return []*member{w}, false
+	}
+
+	// Scorers weigh the members themselves.
+	avail := p.avail
+	if c.Balance != BalanceDefault || p.scorer == nil {
+		avail = weighted(c, avail)
+	}
+	avail = inZone(c, zone, preferQuorum(c, avail))
+
+	switch {
+	case c.Balance == BalanceRoundRobin, c.Balance == BalanceLeastConnections:
+		for _, m := range avail {
+			if m.satisfies(c) {
+				members = append(members, m)
+			}
+		}
+		return members, true
+
+	case c.Balance == BalanceLeastLatency:
+		// avail is ordered by latency.
+		for _, m := range avail {
+			if m.satisfies(c) {
+				return []*member{m}, false
+			}
+		}
+		return nil, false
+
+	case p.scorer != nil:
+		if m := p.pickScored(c, avail); m != nil {
+			return []*member{m}, false
+		}
+		return nil, false
+	}
+
+	// avail is ordered by latency, so the closest candidate comes first.
+	for _, m := range avail {
+		if !m.satisfies(c) {
+			continue
+		}
+
+		if len(members) > 0 && !p.inBand(members[0].rtt, m.rtt) {
+			break
+		}
+		members = append(members, m)
+	}
+
+	return members, true
+}
+
+// Whether a member rtt away is close enough to the closest candidate, best away, to be
+// a candidate too.  p must be at least read-locked.
+func (p *Pool) inBand(best, rtt time.Duration) bool {
+	if p.bandPercent == 0 && p.bandWidth == 0 {
+		// Without a band, least connections considers every member.
+		return p.leastConns || rtt == best
+	}
+
+	width := time.Duration(float64(best) * p.bandPercent / 100)
+	if p.bandWidth > width {
+		width = p.bandWidth
+	}
+
+	return rtt <= best+width
+}
+
+// DialClass connects to the closest backend that satisfies the named class, honoring
+// AtLeast() in ctx.
+// The dial is bounded by the deadline of ctx, or the default dial timeout if it has
+// none, and abandoned if ctx is canceled; see ContextConnector.  A backend that can't
+// be connected to isn't routed to again until its next successful health check, and
+// the next one that satisfies the class is tried instead, for what's left of the
+// deadline.  Once none is left, the last error is returned, or ErrNoneAvailable if
+// none satisfied the class to begin with.
+func (p *Pool) DialClass(ctx context.Context, name string) (*Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+	}
+
+	// Each backend that fails is taken out of routing, so there's no point in trying
+	// more often than there are backends.
+	p.RLock()
+	attempts := len(p.members)
+	p.RUnlock()
+
+	var last error
+	for tries := 0; tries <= attempts; tries++ {
+		b, err := p.getForClass(ctx, name)
+		switch {
+		case err == ErrNoneAvailable && last != nil:
+			return nil, last
+		case err != nil:
+			return nil, err
+		}
+
+		conn, err := p.connect(ctx, b)
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+
+		p.log.Warn("could not connect; trying another backend", "backend", b.Addr(), "error", err)
+		p.MarkUnavailable(b)
+		last = err
+	}
+
+	return nil, last
+}
+
+// Connect to b within the deadline of ctx, or the default dial timeout if it has none,
+// with ConnectContext() if b is a ContextConnector.  p mustn't be locked: a dial may
+// take up to its timeout, which neither routing nor health checks are to wait on.
+func (p *Pool) connect(ctx context.Context, b Backend) (conn *Conn, err error) {
+	ctx, span := p.tracer.Start(ctx, "arbiter.dial")
+	span.SetAttribute("server.address", b.Addr())
+	defer func() {
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+		deadline, _ = ctx.Deadline()
+	}
+
+	if cc, ok := b.(ContextConnector); ok {
+		return cc.ConnectContext(ctx)
+	}
+	return b.Connect(time.Until(deadline))
+}
+
+// Whether m may serve a caller requiring c.  p must be at least read-locked.
+func (m *member) satisfies(c Class) bool {
+	switch {
+	case m.draining || m.cordoned || m.duplicateOf != "" || m.transient != "" || m.superseded || m.unfenced:
+		return false
+	case m.state == READ_WRITE:
+		return !c.FollowersOnly
+	case m.state != READ_ONLY || c.PrimaryOnly || m.diverged:
+		return false
+	case c.QuorumOnly && !m.inQuorum():
+		return false
+	case c.minLSN != 0 && (!m.wal.ok || m.wal.lsn < c.minLSN):
+		return false
+	case c.MaxLag == 0:
+		return true
+	case !m.lagKnown:
+		return false
+	}
+
+	return m.lag <= c.MaxLag
+}
@@ -1,7 +1,9 @@
 package pool
 
 import (
+	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -20,6 +22,15 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	return c.underlying.Write(b)
 }
 
+// CloseWrite half-closes the connection, if the underlying connection supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.underlying.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return errors.New("half-close not supported")
+}
+
 func (c *Conn) Close() error {
 	for _, h := range c.closeHandlers {
 		h()
@@ -50,3 +61,41 @@ func (c *Conn) SetWriteDeadline(t time.Time) error {
 func (c *Conn) RegisterCloseHandler(f func()) {
 	c.closeHandlers = append(c.closeHandlers, f)
 }
+
+// connSet holds the connections a backend returned from Connect() that aren't closed
+// yet, so that Fail() can close them; sessions connect and close concurrently.
+type connSet struct {
+	mu    sync.Mutex
+	conns map[*Conn]bool
+}
+
+// Add c to s, which it leaves once it's closed.
+func (s *connSet) add(c *Conn) {
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*Conn]bool)
+	}
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	c.RegisterCloseHandler(func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	})
+}
+
+// Close the connections of s.
+func (s *connSet) closeAll() {
+	// Closing a connection runs its close handler, which takes mu.
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,88 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"sync/atomic"
+)
+
+// WithMaxConnections caps the outstanding leases of each backend at n, unless changed
+// with LimitConnections().  The default of zero is no cap.
+func WithMaxConnections(n int) Option {
+	return func(p *Pool) {
+		p.maxConns = n
+	}
+}
+
+// LimitConnections caps the outstanding leases of the backend named or addressed addr
+// at n, so that a small replica isn't overloaded.  Callers routed to it beyond that are
+// moved to the closest other candidate with a lease to spare, or, if there's none, get
+// a *DegradedError in SATURATED mode.  Zero restores the cap of WithMaxConnections(),
+// and a negative n lifts it.
+func (p *Pool) LimitConnections(addr string, n int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	switch {
+	case n == 0:
+		m.maxLeases = int64(p.maxConns)
+	case n < 0:
+		m.maxLeases = 0
+	default:
+		m.maxLeases = int64(n)
+	}
+	return nil
+}
+
+// Count a lease of m, unless it's at its cap; see LimitConnections().  p must be at
+// least read-locked.
+func (m *member) tryLease() bool {
+	for {
+		n := atomic.LoadInt64(&m.leases)
+		if m.maxLeases > 0 && n >= m.maxLeases {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&m.leases, n, n+1) {
+			return true
+		}
+	}
+}
+
+// Return the closest member other than full that satisfies c, with a lease to spare
+// and done slow starting, having counted the lease, or nil if there's none.  Those in
+// zone come first, if it isn't empty, then the others, and those of weight 0 last; see
+// InZone() and SetWeight().  Callers requiring the primary have no other candidate,
+// unless WithDistributed() makes every member one.  p must be at least read-locked.
+func (p *Pool) pickLeasable(c Class, full *member, zone string) *member {
+	if c.PrimaryOnly && !p.distributed {
+		return nil
+	}
+
+	now := p.now()
+	for _, preferred := range []func(m *member) bool{
+		func(m *member) bool { return m.weight > 0 && (zone == "" || m.promotion.Zone == zone) },
+		func(m *member) bool { return m.weight > 0 && zone != "" && m.promotion.Zone != zone },
+		func(m *member) bool { return m.weight == 0 },
+	} {
+		for _, m := range p.avail {
+			if m == full || !preferred(m) || !m.satisfies(c) || m.warmth(now) < 1 {
+				continue
+			}
+
+			if m.tryLease() {
+				return m
+			}
+		}
+	}
+
+	return nil
+}
+
+// Count a caller that found m at its cap of leases.
+func (p *Pool) saturated(m *member) {
+	p.sink.AddCounter("arbiter_backend_saturated_total", metrics.Labels{"backend": m.name}, 1)
+}
@@ -0,0 +1,327 @@
+package pool
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The port libpq connects to when none is given.
+const defaultPort = "5432"
+
+// ParseConnString parses a libpq connection string, either keyword/value pairs such as
+// "host=a,b port=5432 user=app dbname=app", or a URI such as
+// "postgresql://app@a:5432,b:5432/app", returning the address of each of its hosts, to
+// be registered as a backend each, and the settings to monitor them with.  This eases
+// moving from libpq's own failover between hosts to arbiter.  Only TCP hosts are
+// supported.
+func ParseConnString(s string) (addrs []string, settings PostgresSettings, err error) {
+	var params map[string]string
+	if strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://") {
+		params, err = parseConnURI(s)
+	} else {
+		params, err = parseConnKeywords(s)
+	}
+	if err != nil {
+		return nil, settings, err
+	}
+
+	hosts := splitList(params["host"])
+	if hostaddrs := splitList(params["hostaddr"]); len(hostaddrs) > 0 {
+		hosts = hostaddrs
+	}
+	if len(hosts) == 0 {
+		return nil, settings, fmt.Errorf("no host in connection string")
+	}
+
+	ports := splitList(params["port"])
+	if len(ports) > 1 && len(ports) != len(hosts) {
+		return nil, settings, fmt.Errorf("%d ports given for %d hosts", len(ports), len(hosts))
+	}
+
+	for i, host := range hosts {
+		if strings.HasPrefix(host, "/") {
+			return nil, settings, fmt.Errorf("unix socket host '%s' isn't supported", host)
+		}
+
+		port := defaultPort
+		switch {
+		case len(ports) == 1:
+			port = ports[0]
+		case len(ports) > 1:
+			port = ports[i]
+		}
+		if port == "" {
+			port = defaultPort
+		}
+
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+
+	settings = PostgresSettings{
+		User:     params["user"],
+		Password: params["password"],
+		Database: params["dbname"],
+		Passfile: params["passfile"],
+
+		SSLMode:     params["sslmode"],
+		SSLRootCert: params["sslrootcert"],
+		SSLCert:     params["sslcert"],
+		SSLKey:      params["sslkey"],
+	}
+
+	if t := params["connect_timeout"]; t != "" {
+		secs, err := strconv.Atoi(t)
+		if err != nil {
+			return nil, settings, fmt.Errorf("invalid connect_timeout '%s'", t)
+		}
+		settings.ConnectTimeout = time.Duration(secs) * time.Second
+	}
+
+	return addrs, settings, nil
+}
+
+// Split a comma-separated list, returning nil for the empty string.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	list := strings.Split(s, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+
+	return list
+}
+
+// Parse keyword/value pairs, whose values may be single-quoted, with backslash escapes.
+func parseConnKeywords(s string) (map[string]string, error) {
+	params := make(map[string]string)
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("missing '=' after '%s'", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t\n")
+
+		var val strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+
+		i := 0
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				val.WriteByte(s[i])
+				continue
+			}
+			if quoted && c == '\'' || !quoted && (c == ' ' || c == '\t' || c == '\n') {
+				break
+			}
+			val.WriteByte(c)
+		}
+
+		if quoted {
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated quoted value for '%s'", key)
+			}
+			i++
+		}
+
+		params[key] = val.String()
+		s = s[i:]
+	}
+
+	return params, nil
+}
+
+// Parse a URI, which may list several host[:port] pairs.
+func parseConnURI(s string) (map[string]string, error) {
+	params := make(map[string]string)
+
+	rest := s[strings.Index(s, "://")+3:]
+	if q := strings.IndexByte(rest, '?'); q >= 0 {
+		query, err := url.ParseQuery(rest[q+1:])
+		if err != nil {
+			return nil, err
+		}
+		for k := range query {
+			params[k] = query.Get(k)
+		}
+		rest = rest[:q]
+	}
+
+	if slash := strings.IndexByte(rest, '/'); slash >= 0 {
+		db, err := url.PathUnescape(rest[slash+1:])
+		if err != nil {
+			return nil, err
+		}
+		params["dbname"] = db
+		rest = rest[:slash]
+	}
+
+	if at := strings.LastIndexByte(rest, '@'); at >= 0 {
+		user, password := rest[:at], ""
+		if colon := strings.IndexByte(user, ':'); colon >= 0 {
+			user, password = user[:colon], user[colon+1:]
+		}
+
+		var err error
+		if params["user"], err = url.PathUnescape(user); err != nil {
+			return nil, err
+		}
+		if params["password"], err = url.PathUnescape(password); err != nil {
+			return nil, err
+		}
+		rest = rest[at+1:]
+	}
+
+	var hosts, ports []string
+	for _, hp := range splitList(rest) {
+		host, port := hp, ""
+		if end := strings.LastIndexByte(hp, ']'); strings.HasPrefix(hp, "[") && end > 0 {
+			host = hp[1:end]
+			port = strings.TrimPrefix(hp[end+1:], ":")
+		} else if colon := strings.LastIndexByte(hp, ':'); colon >= 0 {
+			host, port = hp[:colon], hp[colon+1:]
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+
+	// Query parameters take precedence over the authority, as with libpq.
+	if params["host"] == "" {
+		params["host"] = strings.Join(hosts, ",")
+	}
+	if params["port"] == "" {
+		params["port"] = strings.Join(ports, ",")
+	}
+
+	return params, nil
+}
+
+// LookupService returns the parameters of the named service in the libpq service file
+// at path, as a keyword/value connection string for ParseConnString().
+func LookupService(path, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var pairs []string
+	found, in := false, false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			in = line[1:len(line)-1] == name
+			found = found || in
+		case in:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return "", fmt.Errorf("%s: invalid line '%s'", path, line)
+			}
+			val := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(strings.TrimSpace(kv[1]))
+			pairs = append(pairs, fmt.Sprintf("%s='%s'", strings.TrimSpace(kv[0]), val))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", fmt.Errorf("%s: no service '%s'", path, name)
+	}
+
+	return strings.Join(pairs, " "), nil
+}
+
+// Topology returns the addresses of the backends that are routed to, the primary first
+// and then the followers by latency, e.g. to render with FormatConnString().
+func (p *Pool) Topology() []string {
+	p.RLock()
+	defer p.RUnlock()
+
+	var addrs []string
+	if p.primary != nil && p.primary.satisfies(Strong) {
+		addrs = append(addrs, p.primary.b.Addr())
+	}
+
+	for _, m := range p.avail {
+		if m != p.primary && m.satisfies(Eventual) {
+			addrs = append(addrs, m.b.Addr())
+		}
+	}
+
+	return addrs
+}
+
+// The formats of FormatConnString().
+const (
+	FormatKeywords = "libpq"
+	FormatURI      = "uri"
+	FormatJDBC     = "jdbc"
+)
+
+// The targetServerType of the JDBC driver for each target_session_attrs of libpq.
+var jdbcTargets = map[string]string{
+	"any":            "any",
+	"read-write":     "primary",
+	"primary":        "primary",
+	"read-only":      "secondary",
+	"standby":        "secondary",
+	"prefer-standby": "preferSecondary",
+}
+
+// FormatConnString renders addrs as a multi-host connection string in format, for
+// clients that fail over between hosts themselves.  target is a target_session_attrs of
+// libpq, such as read-write, translated for the JDBC driver; dbname may be empty.
+func FormatConnString(addrs []string, format, target, dbname string) (string, error) {
+	jdbcTarget, ok := jdbcTargets[target]
+	if !ok {
+		return "", fmt.Errorf("unknown target_session_attrs '%s'", target)
+	}
+
+	var hosts, ports []string
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return "", err
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+
+	switch format {
+	case FormatKeywords:
+		s := fmt.Sprintf("host=%s port=%s", strings.Join(hosts, ","), strings.Join(ports, ","))
+		if dbname != "" {
+			s += fmt.Sprintf(" dbname='%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(dbname))
+		}
+		return s + " target_session_attrs=" + target, nil
+
+	case FormatURI, FormatJDBC:
+		scheme, param, value := "postgresql", "target_session_attrs", target
+		if format == FormatJDBC {
+			scheme, param, value = "jdbc:postgresql", "targetServerType", jdbcTarget
+		}
+		return fmt.Sprintf("%s://%s/%s?%s=%s", scheme, strings.Join(addrs, ","),
+			url.PathEscape(dbname), param, value), nil
+	}
+
+	return "", fmt.Errorf("unknown format '%s'", format)
+}
@@ -0,0 +1,102 @@
+package pool
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConnString(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		addrs []string
+		s     PostgresSettings
+	}{
+		{
+			"host=a,b port=5433 user=app dbname=app connect_timeout=3",
+			[]string{"a:5433", "b:5433"},
+			PostgresSettings{User: "app", Database: "app", ConnectTimeout: 3 * time.Second},
+		},
+		{
+			"host=a,b,c port=1,,3 password='it\\'s secret'",
+			[]string{"a:1", "b:5432", "c:3"},
+			PostgresSettings{Password: "it's secret"},
+		},
+		{
+			"postgresql://app:pw@a:5433,[::1],b/db?connect_timeout=2",
+			[]string{"a:5433", "[::1]:5432", "b:5432"},
+			PostgresSettings{User: "app", Password: "pw", Database: "db", ConnectTimeout: 2 * time.Second},
+		},
+		{
+			"host=a sslmode=verify-full sslrootcert=/etc/ca.pem",
+			[]string{"a:5432"},
+			PostgresSettings{SSLMode: "verify-full", SSLRootCert: "/etc/ca.pem"},
+		},
+		{
+			"host=a user=app passfile=/etc/arbiter/pgpass",
+			[]string{"a:5432"},
+			PostgresSettings{User: "app", Passfile: "/etc/arbiter/pgpass"},
+		},
+	} {
+		addrs, s, err := ParseConnString(tc.in)
+		if err != nil || !reflect.DeepEqual(addrs, tc.addrs) || !reflect.DeepEqual(s, tc.s) {
+			t.Errorf("%s: Expected %v, %+v; instead got %v, %+v, %v", tc.in, tc.addrs, tc.s, addrs, s, err)
+		}
+	}
+
+	for _, in := range []string{"", "host=a,b port=1,2,3", "host=/tmp", "host='a"} {
+		if _, _, err := ParseConnString(in); err == nil {
+			t.Errorf("%s: Expected an error", in)
+		}
+	}
+}
+
+func TestLookupService(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_service.conf")
+	conf := "# Services\n[other]\nhost=x\n\n[main]\nhost=a,b\nuser=app\n"
+	if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LookupService(path, "main")
+	if err != nil {
+		t.Fatalf("Expected to find the service; instead got %v", err)
+	}
+
+	if addrs, ps, err := ParseConnString(s); err != nil || len(addrs) != 2 || ps.User != "app" {
+		t.Fatalf("Expected two hosts and a user; instead got %v, %+v, %v", addrs, ps, err)
+	}
+
+	if _, err := LookupService(path, "missing"); err == nil {
+		t.Fatalf("Expected an unknown service to be an error")
+	}
+}
+
+func TestFormatConnString(t *testing.T) {
+	addrs := []string{"a:5432", "[::1]:5433"}
+
+	for _, tc := range []struct {
+		format, target, dbname string
+		want                   string
+	}{
+		{FormatKeywords, "read-write", "it's", "host=a,::1 port=5432,5433 dbname='it\\'s' target_session_attrs=read-write"},
+		{FormatURI, "any", "app", "postgresql://a:5432,[::1]:5433/app?target_session_attrs=any"},
+		{FormatJDBC, "standby", "", "jdbc:postgresql://a:5432,[::1]:5433/?targetServerType=secondary"},
+	} {
+		if got, err := FormatConnString(addrs, tc.format, tc.target, tc.dbname); err != nil || got != tc.want {
+			t.Errorf("%s: Expected %q; instead got %q, %v", tc.format, tc.want, got, err)
+		}
+	}
+
+	if _, err := FormatConnString(addrs, FormatKeywords, "sometimes", ""); err == nil {
+		t.Errorf("Expected an unknown target to be rejected")
+	}
+
+	// What's rendered parses back.
+	cs, _ := FormatConnString(addrs, FormatURI, "any", "app")
+	if parsed, s, err := ParseConnString(cs); err != nil || !reflect.DeepEqual(parsed, addrs) || s.Database != "app" {
+		t.Errorf("Expected %s to parse back; instead got %v, %+v, %v", cs, parsed, s, err)
+	}
+}
@@ -0,0 +1,156 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Dialer connects to the addresses of backends, both for monitoring them and for the
+// connections handed out by Connect().  The default is a net.Dialer; see WithDialer()
+// for reaching backends through a bastion or a VPN.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// WithDialer makes the backend connect through d.
+func WithDialer(d Dialer) PostgresOption {
+	return func(s *PostgresSettings) {
+		s.Dialer = d
+	}
+}
+
+// pqDialer adapts a Dialer to lib/pq, which dials the monitoring connection.
+type pqDialer struct {
+	Dialer
+}
+
+func (d pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.DialContext(ctx, network, address)
+}
+
+// NewSOCKS5Dialer returns a Dialer that connects through the SOCKS5 proxy at addr,
+// authenticating as user if it isn't empty.
+func NewSOCKS5Dialer(addr, user, password string) (Dialer, error) {
+	var auth *proxy.Auth
+	if user != "" {
+		auth = &proxy.Auth{User: user, Password: password}
+	}
+
+	d, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("SOCKS5 dialer doesn't support contexts")
+	}
+
+	return cd, nil
+}
+
+// sshDialer connects through an SSH tunnel to a bastion.  The SSH connection is
+// established on the first dial, shared by the connections through it, and
+// reestablished if dialing through it fails.
+type sshDialer struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHDialer returns a Dialer that connects through the SSH server at addr.
+func NewSSHDialer(addr string, config *ssh.ClientConfig) Dialer {
+	return &sshDialer{addr: addr, config: config}
+}
+
+func (d *sshDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.DialContext(ctx, network, address)
+	if err != nil {
+		if ctx.Err() == nil {
+			// The tunnel may be dead; reestablish it on the next dial.
+			d.reset(client)
+		}
+		return nil, err
+	}
+
+	return deadlinePipe(conn), nil
+}
+
+// Return the SSH connection, establishing it if need be.
+func (d *sshDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.addr, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	d.client = ssh.NewClient(c, chans, reqs)
+	return d.client, nil
+}
+
+// Close client, unless it has already been replaced.
+func (d *sshDialer) reset(client *ssh.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.client == client {
+		d.client.Close()
+		d.client = nil
+	}
+}
+
+// Connections through an SSH tunnel don't support deadlines, which the proxy relies on,
+// so relay conn through a pipe that does.
+func deadlinePipe(conn net.Conn) net.Conn {
+	local, remote := net.Pipe()
+
+	go func() {
+		io.Copy(conn, remote)
+		conn.Close()
+	}()
+
+	go func() {
+		io.Copy(remote, conn)
+		remote.Close()
+	}()
+
+	return local
+}
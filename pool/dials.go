@@ -0,0 +1,83 @@
+package pool
+
+import (
+	"errors"
+	"github.com/solvip/arbiter/metrics"
+)
+
+// ErrDialsExhausted is returned by Acquire() when a caller waited for a dial to a
+// backend for as long as it would wait to connect; see LimitDials().
+var ErrDialsExhausted = errors.New("too many connections being established to the backend")
+
+// LimitDials caps the connections being established to the backend named or addressed
+// addr by Acquire() at n at a time, so that a backend accepting slowly isn't flooded
+// with connection attempts.  Callers routed to it beyond that are moved to the next
+// candidate with a dial to spare, or, if there's none, queue for one for as long as
+// they'd wait to connect.  Zero lifts the cap.  The default is set by WithMaxDials().
+func (p *Pool) LimitDials(addr string, n int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	m.dials = dialSlots(n)
+	return nil
+}
+
+// Return a semaphore of n dials, or nil if n is zero.
+func dialSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// Take a dial of m if it has one to spare, returning its semaphore, to be released
+// once connected.  It's nil if m's dials aren't capped.  p must be at least read-locked.
+func (m *member) tryDial() (slots chan struct{}, ok bool) {
+	if m.dials == nil {
+		return nil, true
+	}
+
+	select {
+	case m.dials <- struct{}{}:
+		return m.dials, true
+	default:
+		return nil, false
+	}
+}
+
+// Return the closest member other than busy that satisfies c, with a dial and a lease
+// to spare and done slow starting, having taken the dial and counted the lease, or nil
+// if there's none.  Unlike pick(), it looks past the latency band and the class's
+// balancing.  p must be at least read-locked.
+func (p *Pool) pickDialable(c Class, busy *member) (*member, chan struct{}) {
+	now := p.now()
+	for _, m := range p.avail {
+		if m == busy || !m.satisfies(c) || m.warmth(now) < 1 {
+			continue
+		}
+
+		slots, ok := m.tryDial()
+		if !ok {
+			continue
+		}
+		if !m.tryLease() {
+			if slots != nil {
+				<-slots
+			}
+			continue
+		}
+		return m, slots
+	}
+
+	return nil, nil
+}
+
+// Count a caller that found the dials of m used up.
+func (p *Pool) dialDeferred(m *member) {
+	p.sink.AddCounter("arbiter_backend_dials_deferred_total", metrics.Labels{"backend": m.name}, 1)
+}
@@ -0,0 +1,21 @@
+package pool
+
+// WithDistributed models distributed databases speaking the Postgres protocol, such as
+// CockroachDB and YugabyteDB, where every node takes writes and there's no primary:
+// every available node may serve any class but FollowersOnly ones, PrimaryOnly
+// classes and GetForWrite() included, and callers are balanced across them by latency
+// and connections alone.  Nodes aren't elected primary, so neither failovers nor
+// hand-offs happen, and WAL positions, synchronous standbys and server identities
+// aren't probed.  Postgres backends should be checked with a liveness query instead of
+// their role; see PostgresSettings.LivenessQuery.
+func WithDistributed() Option {
+	return func(p *Pool) {
+		p.distributed = true
+	}
+}
+
+// Whether a single backend is the primary, which a backend taking writes replaces,
+// rather than WithMultiWriter() or WithDistributed() letting several take them.
+func (p *Pool) singlePrimary() bool {
+	return !p.multiWriter && !p.distributed
+}
@@ -0,0 +1,73 @@
+package pool
+
+import (
+	"time"
+)
+
+// The number of consecutive mismatching checksums before a follower is considered
+// diverged.  Followers legitimately disagree with the primary while they catch up, so
+// a single mismatch proves nothing.
+const divergenceThreshold = 3
+
+// ProbeChecksums enables a low-frequency probe that runs query on the primary and on
+// every follower, and compares the results.  Followers whose result keeps differing
+// from the primary's are considered diverged and excluded from read routing until they
+// agree again.  The query should return a single column over data that changes rarely,
+// such as a checksum over old rows of a heartbeat table.  An empty query disables the
+// probe.
+func (p *Pool) ProbeChecksums(query string, interval time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.checksumQuery = query
+	p.checksumInterval = interval
+	p.primaryChecksum = ""
+}
+
+// Run the checksum probe against m, if it's due.
+func (p *Pool) probeChecksum(m *member, c Checksummer) (sum string, ok bool) {
+	p.RLock()
+	query, interval := p.checksumQuery, p.checksumInterval
+	p.RUnlock()
+
+	if query == "" || p.now().Sub(m.lastChecksum) < interval {
+		return sum, false
+	}
+	m.lastChecksum = p.now()
+
+	sum, err := c.Checksum(query)
+	if err != nil {
+		p.log.Warn("checksum probe failed", "backend", m.name, "error", err)
+		return sum, false
+	}
+
+	return sum, true
+}
+
+// Compare the checksum of m with the primary's.  p must be locked.
+func (p *Pool) updateChecksum(m *member, sum string) {
+	if m == p.primary {
+		p.primaryChecksum = sum
+		return
+	}
+
+	if p.primaryChecksum == "" || m.state != READ_ONLY {
+		return
+	}
+
+	if sum == p.primaryChecksum {
+		if m.diverged {
+			p.logFor(m).Info("checksum agrees with the primary again")
+		}
+		m.checksumMismatches = 0
+		m.diverged = false
+		return
+	}
+
+	m.checksumMismatches++
+	if m.checksumMismatches >= divergenceThreshold && !m.diverged {
+		p.logFor(m).Warn("diverged from the primary; removing from read routing",
+			"checksum", sum, "primary_checksum", p.primaryChecksum)
+		m.diverged = true
+	}
+}
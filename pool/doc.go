@@ -0,0 +1,14 @@
+// Package pool monitors the backends of a PostgreSQL streaming replication cluster and
+// routes to them; it's the core of arbiter, and can be embedded in other Go programs
+// without the proxy.
+//
+// A Pool is created with New, and backends are registered with Put or PutNamed,
+// typically as returned by NewPostgresBackend, or NewBackend for those whose Patroni
+// agent is asked for their role, or by NewCheckedBackend for backends of another kind,
+// checked by a HealthChecker, such as NewMySQLChecker for MySQL and MariaDB.  Each is health checked in
+// the background, first as soon as it's registered, until the pool's context is
+// canceled; WaitForChecks waits for those first checks, and Check and CheckAll check
+// them right away.  Callers are routed with
+// GetForWrite, GetForRead, GetForClass, DialClass or Acquire, and inspect the pool
+// with Backends, Subscribe and LastFailover.
+package pool
@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"time"
+)
+
+// Drain stops routing new sessions to the backend named or addressed addr, and
+// notifies the holders of its leases through Lease.Drained() so that they can move
+// their sessions elsewhere.  The backend is still monitored.
+func (p *Pool) Drain(addr string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	if !m.draining {
+		p.logFor(m).Info("draining")
+		m.draining = true
+		m.closeDrained()
+	}
+	p.electWriters()
+
+	return nil
+}
+
+// Cordon stops routing new sessions to the backend named or addressed addr, as for
+// maintenance, leaving the sessions it has alone; should grace not be zero, they're
+// drained once it runs out, as by Drain().  The backend is still monitored.
+func (p *Pool) Cordon(addr string, grace time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	if !m.cordoned {
+		p.logFor(m).Info("cordoning", "grace", grace)
+		m.cordoned = true
+	}
+	m.drainAt = time.Time{}
+	if grace > 0 && !m.draining {
+		m.drainAt = time.Now().Add(grace)
+		time.AfterFunc(grace, func() { p.drainCordoned(m) })
+	}
+
+	return nil
+}
+
+// Drain m, cordoned by Cordon(), if its grace has run out, unless it was resumed or
+// cordoned anew since.
+func (p *Pool) drainCordoned(m *member) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !m.cordoned || m.draining || m.drainAt.IsZero() || time.Now().Before(m.drainAt) {
+		return
+	}
+
+	p.logFor(m).Info("draining at the end of its cordon grace")
+	m.draining = true
+	m.closeDrained()
+	p.electWriters()
+}
+
+// Resume routes sessions to a backend drained with Drain(), or cordoned with Cordon(),
+// again.
+func (p *Pool) Resume(addr string) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	if m.draining || m.cordoned {
+		p.logFor(m).Info("resuming")
+	}
+	if m.draining {
+		m.draining = false
+		m.drained = make(chan struct{})
+	}
+	m.cordoned = false
+	m.drainAt = time.Time{}
+	p.electWriters()
+
+	return nil
+}
+
+// Close m.drained, notifying the holders of the leases taken so far.  p must be
+// locked.
+func (m *member) closeDrained() {
+	drained := m.drained
+	close(drained)
+	m.held.Range(func(k, _ any) bool {
+		if l := k.(*Lease); l.drained == drained {
+			l.handOff()
+		}
+		return true
+	})
+}
@@ -0,0 +1,40 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+)
+
+// Flag m as a duplicate if a member registered before it is the same server, or clear
+// the flag once it's not.  Duplicates aren't routed reads to, so that the server isn't
+// weighed twice.  p must be locked.
+func (p *Pool) checkDuplicate(m *member) {
+	var of string
+	if m.node != "" {
+		for _, o := range p.members {
+			if o == m {
+				break
+			}
+			if o.node == m.node {
+				of = o.name
+				break
+			}
+		}
+	}
+
+	if of == m.duplicateOf {
+		return
+	}
+	m.duplicateOf = of
+
+	labels := metrics.Labels{"backend": m.name}
+	if of == "" {
+		p.logFor(m).Info("no longer a duplicate")
+		p.sink.SetGauge("arbiter_backend_duplicate", labels, 0)
+		return
+	}
+
+	p.logFor(m).Warn("same server as another backend; not routing reads to it", "duplicate_of", of)
+	p.sink.SetGauge("arbiter_backend_duplicate", labels, 1)
+	p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: m.state, Time: p.now(),
+		DuplicateOf: of})
+}
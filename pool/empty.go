@@ -0,0 +1,78 @@
+package pool
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoBackends is returned by WaitForBackends() if no backend is registered in time.
+var ErrNoBackends = errors.New("no backends configured")
+
+// WithFallback routes every caller to b while no backend is registered, rather than
+// failing them.  b isn't monitored, and is assumed to be a primary.
+func WithFallback(b Backend) Option {
+	return func(p *Pool) {
+		p.fallback = &member{b: b, name: b.Addr(), state: READ_WRITE, drained: make(chan struct{})}
+	}
+}
+
+// WaitForBackends waits until a backend is registered, as when backends are discovered
+// after startup, returning ErrNoBackends if ctx is done first.  Programs that should
+// fail fast at startup instead can pass a context that's already done.
+func (p *Pool) WaitForBackends(ctx context.Context) error {
+	select {
+	case <-p.populated:
+		return nil
+	default:
+	}
+
+	select {
+	case <-p.populated:
+		return nil
+	case <-ctx.Done():
+		return ErrNoBackends
+	}
+}
+
+// WaitForCheck waits until the backend named or addressed addr has been health checked
+// once, for callers that register a backend to route to it right away, returning
+// ErrUnknownBackend if there's no such backend, and ctx.Err() if ctx is done first.
+func (p *Pool) WaitForCheck(ctx context.Context, addr string) error {
+	p.RLock()
+	m := p.find(addr)
+	p.RUnlock()
+
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	select {
+	case <-m.firstChecked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitForChecks waits until every backend registered has been health checked once, so
+// that callers aren't turned away while the pool starts, returning ctx.Err() if ctx is
+// done first.  Backends that are down are waited for only until their first check
+// fails.
+func (p *Pool) WaitForChecks(ctx context.Context) error {
+	p.RLock()
+	pending := make([]chan struct{}, 0, len(p.members))
+	for _, m := range p.members {
+		pending = append(pending, m.firstChecked)
+	}
+	p.RUnlock()
+
+	for _, c := range pending {
+		select {
+		case <-c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"time"
+)
+
+// The number of events buffered for each subscriber.  Events are dropped for a
+// subscriber that falls further behind, rather than stalling the monitors, and counted
+// in arbiter_events_dropped_total.
+const subscriberBuffer = 64
+
+// Event describes a state transition of a backend.
+type Event struct {
+	Name string
+	Addr string
+	From State
+	To   State
+	Time time.Time
+
+	// Set on the transition of a backend to primary that completes a failover.
+	Failover *FailoverReport `json:",omitempty"`
+
+	// Set on an event reporting that the role of a backend contradicts the one
+	// asserted with Expect(); From and To are then both its role.
+	Violation string `json:",omitempty"`
+
+	// Set on an event reporting that the backend is the same server as the one named
+	// DuplicateOf, registered under another address; From and To are then both its
+	// state.
+	DuplicateOf string `json:",omitempty"`
+
+	// Set on an event reporting that the backend's checks fail with an error of this
+	// kind, which doesn't take it out of routing; see WithDegradeOn().  From and To are
+	// then both its state.
+	Degraded string `json:",omitempty"`
+
+	// Set on an event reporting that a series of the backend went anomalous; see
+	// WithAnomalyDetector().  From and To are then both its state.
+	Anomaly *Anomaly `json:",omitempty"`
+
+	// Set on an event about the program embedding the pool rather than a backend, as
+	// announced with Announce(), such as arbiter's watchdog reporting that arbiter
+	// breached one of its own limits; Name is then the program's.
+	SelfHealth string `json:",omitempty"`
+}
+
+// Subscribe returns a channel that receives an Event for every state transition.
+func (p *Pool) Subscribe() <-chan Event {
+	p.Lock()
+	defer p.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	p.subscribers = append(p.subscribers, ch)
+	return ch
+}
+
+// SubscribeFunc calls f with every state transition, in order, from a goroutine of its
+// own, until the returned function is called, which waits for the call in progress, if
+// any, to return.  Transitions are dropped while f falls behind, as with Subscribe().
+func (p *Pool) SubscribeFunc(f func(Event)) (stop func()) {
+	ch := p.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range ch {
+			f(e)
+		}
+	}()
+
+	return func() {
+		p.Unsubscribe(ch)
+		<-done
+	}
+}
+
+// Unsubscribe stops delivering events to ch, a channel returned by Subscribe(), and
+// closes it.
+func (p *Pool) Unsubscribe(ch <-chan Event) {
+	p.Lock()
+	defer p.Unlock()
+
+	for i, sub := range p.subscribers {
+		if sub == ch {
+			p.subscribers = append(p.subscribers[:i], p.subscribers[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+// Announce delivers e to subscribers as the pool's own events are, for events about
+// the program embedding the pool, such as its own health.
+func (p *Pool) Announce(e Event) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.publish(e)
+}
+
+// Deliver e to all subscribers.  p must be locked.
+func (p *Pool) publish(e Event) {
+	for _, ch := range p.subscribers {
+		select {
+		case ch <- e:
+		default:
+			p.sink.AddCounter("arbiter_events_dropped_total", nil, 1)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pool_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"time"
+)
+
+func Example() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := pool.New(ctx, pool.WithCheckInterval(time.Second))
+	for _, addr := range []string{"10.0.0.1:5432", "10.0.0.2:5432"} {
+		p.Put(pool.NewPostgresBackend(addr, pool.WithCredentials("arbiter", "secret", "postgres")))
+	}
+
+	// Follow state transitions, as for invalidating caches on failover.
+	events := p.Subscribe()
+	go func() {
+		for e := range events {
+			fmt.Printf("%s: %s -> %s\n", e.Name, e.From, e.To)
+		}
+	}()
+
+	// Connect to the primary, waiting at most five seconds.
+	dialCtx, cancelDial := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelDial()
+	conn, err := p.DialClass(dialCtx, "strong")
+	if err != nil {
+		fmt.Println("no primary:", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, b := range p.Backends() {
+		fmt.Println(b.Name, b.State, b.Latency)
+	}
+}
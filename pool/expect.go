@@ -0,0 +1,76 @@
+package pool
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+)
+
+// Expectation asserts the role of a backend, so that misconfigured or rogue promotions
+// are caught; see Expect().
+type Expectation struct {
+	// READ_WRITE if the backend should be the primary, READ_ONLY if it should never
+	// be.  UNAVAILABLE asserts nothing.
+	Role State
+
+	// Don't route writes to the backend while it's a primary it should never be.
+	Enforce bool
+}
+
+// Expect asserts the role of the backend named or addressed addr.  Whenever the role
+// observed contradicts it, an alert is logged, arbiter_role_violations_total is
+// incremented and an Event with Violation set is published.
+func (p *Pool) Expect(addr string, e Expectation) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	m.expect = e
+	switch {
+	case p.primary == m && !m.mayBePrimary():
+		p.logFor(m).Info("no longer routing writes; it should never be primary")
+		p.primary = nil
+	case p.primary == nil && m.state == READ_WRITE && m.mayBePrimary() && p.singlePrimary():
+		p.primary = m
+	}
+	p.checkExpectation(m)
+	p.electWriters()
+
+	return nil
+}
+
+// Whether writes may be routed to m while it's a primary.
+func (m *member) mayBePrimary() bool {
+	return !m.expect.Enforce || m.expect.Role != READ_ONLY
+}
+
+// Raise or clear the violation of m's expectation, according to its role.  p must be
+// locked.
+func (p *Pool) checkExpectation(m *member) {
+	if m.expect.Role == UNAVAILABLE || m.state == UNAVAILABLE {
+		return
+	}
+
+	violated := m.state != m.expect.Role
+	if violated == m.violated {
+		return
+	}
+	m.violated = violated
+
+	labels := metrics.Labels{"backend": m.name}
+	if !violated {
+		p.logFor(m).Info("role is as expected again")
+		p.sink.SetGauge("arbiter_backend_role_violation", labels, 0)
+		return
+	}
+
+	violation := fmt.Sprintf("role is %s; expected %s", m.state, m.expect.Role)
+	p.logFor(m).Warn("role violation", "violation", violation)
+	p.sink.SetGauge("arbiter_backend_role_violation", labels, 1)
+	p.sink.AddCounter("arbiter_role_violations_total", labels, 1)
+	p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: m.state, Time: p.now(),
+		Violation: violation})
+}
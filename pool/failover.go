@@ -0,0 +1,71 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"time"
+)
+
+// FailoverReport describes the potential data loss of a failover: how far the promoted
+// backend was behind the last WAL position seen on the previous primary.  Writes
+// acknowledged in that window may be lost, which is what RPO accounting needs.
+type FailoverReport struct {
+	// The names of the previous primary and of the promoted backend.
+	From string
+	To   string
+	Time time.Time
+
+	// Whether the WAL positions of both were known; the loss is unknown otherwise.
+	Known bool
+
+	// The WAL bytes between the two positions, and the same converted to time using
+	// the WAL generation rate of the previous primary, zero if that's unknown.
+	LossBytes uint64
+	Loss      time.Duration
+}
+
+// LastFailover returns the report of the most recent failover, or nil if there was
+// none.
+func (p *Pool) LastFailover() *FailoverReport {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.lastFailover
+}
+
+// Report on the failover to m, which is becoming the primary, or return nil if it was
+// the primary last.  m.wal must still hold its last sample as a follower, since its
+// first as the primary may already include new writes.  p must be locked.
+func (p *Pool) failover(m *member) *FailoverReport {
+	prev := p.lastPrimary
+	if prev == "" || prev == m.name {
+		return nil
+	}
+
+	r := &FailoverReport{From: prev, To: m.name, Time: p.now()}
+	if m.wal.ok && p.lastPrimaryWAL.ok {
+		r.Known = true
+		if p.lastPrimaryWAL.lsn > m.wal.lsn {
+			r.LossBytes = p.lastPrimaryWAL.lsn - m.wal.lsn
+		}
+		if p.walRate > 0 {
+			r.Loss = time.Duration(float64(r.LossBytes) / p.walRate * float64(time.Second))
+		}
+	}
+
+	if r.Known {
+		p.log.Warn("failover", "from", r.From, "to", r.To, "loss_bytes", r.LossBytes, "loss", r.Loss)
+	} else {
+		p.log.Warn("failover; potential data loss unknown", "from", r.From, "to", r.To)
+	}
+
+	labels := metrics.Labels{"backend": m.name, "from": prev}
+	p.sink.AddCounter("arbiter_failovers_total", labels, 1)
+	if r.Known {
+		p.sink.SetGauge("arbiter_failover_loss_bytes", labels, float64(r.LossBytes))
+		p.sink.SetGauge("arbiter_failover_loss_seconds", labels, r.Loss.Seconds())
+	}
+
+	p.lastFailover = r
+	p.journalState()
+	return r
+}
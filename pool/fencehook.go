@@ -0,0 +1,85 @@
+package pool
+
+import (
+	"context"
+	"github.com/solvip/arbiter/metrics"
+)
+
+// FenceHook fences off previous, the primary last seen, before writes are routed to
+// next, which took writes in its place, such as by revoking its virtual IP or powering
+// it off; see WithFenceHook().  previous only has its Name set if it's no longer
+// registered.
+type FenceHook func(ctx context.Context, previous, next BackendInfo) error
+
+// WithFenceHook holds writes to a backend taking over from another primary until fence
+// succeeds in fencing the previous one off, so that the two never take the writes
+// routed through the pool at once.  Until then, the backend is unfenced: neither the
+// primary nor routed reads.  fence is called by the monitor of the backend after each
+// of its checks, and must return within a check interval or so.  Unfenced backends are
+// shown by Backends(), and exported as arbiter_backend_unfenced.  It has no effect with
+// WithMultiWriter() or WithDistributed().
+func WithFenceHook(fence FenceHook) Option {
+	return func(p *Pool) {
+		p.fenceHook = fence
+	}
+}
+
+// Return whether m, which its check found taking writes in place of the primary, may
+// become the primary, holding it unfenced until the previous primary is fenced off
+// unless it was the primary last, or there was none.  p must be locked.
+func (p *Pool) fenced(m *member) bool {
+	if p.fenceHook == nil || p.fencePrimary == "" || p.fencePrimary == m.name {
+		m.unfenced = false
+		return true
+	}
+
+	if !m.unfenced {
+		p.logFor(m).Warn("taking writes in place of the previous primary; holding writes until it's fenced off",
+			"previous", p.fencePrimary)
+		m.unfenced, m.unfencedWAL = true, m.wal
+	}
+	return false
+}
+
+// Fence the previous primary off for m, which is unfenced, and make m the primary if
+// that succeeded.  It's called by the monitor of m, with the pool unlocked.
+func (p *Pool) fencePrevious(ctx context.Context, m *member) {
+	p.RLock()
+	now := p.now()
+	previous := BackendInfo{Name: p.fencePrimary}
+	if o := p.find(p.fencePrimary); o != nil {
+		previous = o.info(now)
+	}
+	next := m.info(now)
+	p.RUnlock()
+
+	err := p.fenceHook(ctx, previous, next)
+
+	p.Lock()
+	defer p.Unlock()
+
+	labels := metrics.Labels{"backend": m.name, "previous": previous.Name}
+	if err != nil {
+		p.logFor(m).Error("fencing off the previous primary failed; holding writes", "previous", previous.Name,
+			"error", err)
+		p.sink.AddCounter("arbiter_fence_failures_total", labels, 1)
+		return
+	}
+	if !m.unfenced || m.state != READ_WRITE || p.fencePrimary != previous.Name || !contains(p.members, m) {
+		// The topology changed while fencing.
+		return
+	}
+
+	p.logFor(m).Warn("fenced off the previous primary; routing writes", "previous", previous.Name)
+	p.sink.AddCounter("arbiter_fences_total", labels, 1)
+	m.unfenced = false
+	wal := m.wal
+	m.wal = m.unfencedWAL
+	failover := p.failover(m)
+	m.wal = wal
+	p.replacePrimary(m)
+	p.fencePrimary = m.name
+	if failover != nil {
+		p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: m.state, Time: p.now(), Failover: failover})
+	}
+}
@@ -0,0 +1,53 @@
+package pool
+
+// EnableFencing makes the monitor issue a fencing epoch for each primary in table on
+// the primary: the epoch is advanced whenever the primary the table names is another
+// one, and adopted as is otherwise, so that a fleet of pools sharing the table, and a
+// pool restarting, agree on it.  Sessions handed the epoch can then be checked against
+// the table from inside the database, so that those of a previous primary are fenced
+// off; see Epoch().  If create is set, the table is created if it doesn't exist.  An
+// empty table disables fencing.
+func (p *Pool) EnableFencing(table string, create bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.fencingTable = table
+	p.fencingCreate = create
+}
+
+// Epoch returns the fencing epoch of the primary last checked, or zero if fencing is
+// disabled or no epoch was issued yet; see EnableFencing().
+func (p *Pool) Epoch() uint64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.epoch
+}
+
+// Issue the fencing epoch of m, which its check found to be the primary, as by
+// EnableFencing().
+func (p *Pool) fence(m *member, f Fencer) {
+	p.RLock()
+	table, create := p.fencingTable, p.fencingCreate
+	primary := p.primary == m
+	p.RUnlock()
+
+	if table == "" || !primary {
+		return
+	}
+
+	epoch, err := f.Fence(table, m.name, create)
+	if err != nil {
+		p.log.Warn("could not issue the fencing epoch", "backend", m.name, "error", err)
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if epoch != p.epoch {
+		p.logFor(m).Info("fencing epoch issued", "epoch", epoch)
+		p.epoch = epoch
+		p.sink.SetGauge("arbiter_fencing_epoch", nil, float64(epoch))
+	}
+}
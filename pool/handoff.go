@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"time"
+)
+
+// FailoverPolicy decides what becomes of the sessions on a primary once it no longer
+// is: once it's demoted, or another backend is observed as the primary, or, with
+// WithMultiWriter(), the writer of its write group is replaced.
+type FailoverPolicy struct {
+	// Drain the sessions, rather than severing them right away: the holders of their
+	// leases are notified through Lease.Drained() so that they can hand them off to
+	// the new primary between transactions, as with Drain(), while new sessions go to
+	// the new primary.
+	Drain bool
+
+	// How long drained sessions have to hand off before their connections are closed;
+	// see Lease.Severed().  Zero waits for them.
+	Grace time.Duration
+}
+
+// WithFailoverPolicy sets what becomes of the sessions on the previous primary when the
+// primary changes.  The default severs them, failing all connections to it so that
+// clients reconnect to the new primary.
+func WithFailoverPolicy(fp FailoverPolicy) Option {
+	return func(p *Pool) {
+		p.failoverPolicy = fp
+	}
+}
+
+// Make m the primary, handing the sessions of the previous primary off if it's still
+// up.  p must be locked.
+func (p *Pool) replacePrimary(m *member) {
+	if prev := p.primary; prev != nil && prev != m {
+		p.handOff(prev)
+	}
+	p.primary = m
+}
+
+// Sever or drain the sessions of m, which is no longer the primary, according to the
+// FailoverPolicy.  Drained sessions are those of the leases taken so far; m.drained is
+// replaced, so that sessions m is routed afterwards, as a follower, are left alone.  p
+// must be locked.
+func (p *Pool) handOff(m *member) {
+	fp := p.failoverPolicy
+	if !fp.Drain {
+		p.logFor(m).Info("no longer the primary; severing its sessions")
+		m.b.Fail()
+		return
+	}
+
+	p.logFor(m).Info("no longer the primary; draining its sessions", "grace", fp.Grace)
+	drained := m.drained
+	if !m.draining {
+		m.closeDrained()
+		m.drained = make(chan struct{})
+	}
+
+	if fp.Grace > 0 {
+		time.AfterFunc(fp.Grace, func() { p.sever(m, drained) })
+	}
+}
+
+// Close the connections of the leases of m that were notified through drained and are
+// still held, counting them in arbiter_failover_severed_sessions_total.
+func (p *Pool) sever(m *member, drained chan struct{}) {
+	n := 0
+	m.held.Range(func(k, _ any) bool {
+		if l := k.(*Lease); l.drained == drained {
+			l.severed.Store(true)
+			if l.Conn != nil {
+				l.Conn.Close()
+			}
+			n++
+		}
+		return true
+	})
+
+	if n > 0 {
+		p.log.Warn("severed sessions past the failover grace", "backend", m.name, "sessions", n)
+		p.sink.AddCounter("arbiter_failover_severed_sessions_total", metrics.Labels{"backend": m.name}, float64(n))
+	}
+}
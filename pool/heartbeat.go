@@ -0,0 +1,49 @@
+package pool
+
+import (
+	"time"
+)
+
+// EnableHeartbeat makes the monitor write the current time into table on the primary
+// on every check, and read it back on followers, producing an end-to-end apply delay
+// that works even where WAL position functions are restricted.  The time written is
+// taken from our clock and compared against our clock, so skew between the nodes
+// doesn't matter.  If create is set, the table is created if it doesn't exist.  An
+// empty table disables the heartbeat.
+func (p *Pool) EnableHeartbeat(table string, create bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.heartbeatTable = table
+	p.heartbeatCreate = create
+}
+
+// Write or read the heartbeat of m, depending on its state.  Returns the apply delay of
+// a follower, and whether it was measured.
+func (p *Pool) heartbeat(m *member, h Heartbeater, state State) (delay time.Duration, ok bool) {
+	p.RLock()
+	table, create := p.heartbeatTable, p.heartbeatCreate
+	p.RUnlock()
+
+	if table == "" {
+		return delay, false
+	}
+
+	switch state {
+	case READ_WRITE:
+		if err := h.WriteHeartbeat(table, time.Now(), create); err != nil {
+			p.log.Warn("could not write heartbeat", "backend", m.name, "error", err)
+		}
+		return 0, true
+
+	case READ_ONLY:
+		ts, err := h.ReadHeartbeat(table)
+		if err != nil {
+			p.log.Warn("could not read heartbeat", "backend", m.name, "error", err)
+			return delay, false
+		}
+		return time.Since(ts), true
+	}
+
+	return delay, false
+}
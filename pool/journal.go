@@ -0,0 +1,146 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalState is what the pool knows of the primary, from which failovers are
+// detected and their data loss is measured; see failover().
+type journalState struct {
+	Primary      string          `json:"primary"`
+	WALKnown     bool            `json:"wal_known"`
+	LSN          uint64          `json:"lsn"`
+	Sampled      time.Time       `json:"sampled"`
+	WALRate      float64         `json:"wal_rate"`
+	LastFailover *FailoverReport `json:"last_failover,omitempty"`
+}
+
+// journal writes the pool's journalState to a file as it changes, so that it survives
+// arbiter crashing or restarting.  Writes happen off the monitors, and only the latest
+// state is written should they fall behind.
+type journal struct {
+	path    string
+	pending chan journalState
+}
+
+// WithFailoverJournal keeps what the pool knows of the primary in a file at path,
+// written as it changes and read back by New().  Without it, an arbiter that crashes or
+// restarts while the primary fails over sees the promoted backend as the first primary,
+// and neither detects the failover nor reports its data loss window.  The directory of
+// path must be writable, since the file is replaced atomically.
+func WithFailoverJournal(path string) Option {
+	return func(p *Pool) {
+		p.journal = &journal{path: path, pending: make(chan journalState, 1)}
+	}
+}
+
+// Restore the state journaled at j.path into p, if any.  p must be locked, or not yet
+// shared.
+func (j *journal) restore(p *Pool) error {
+	b, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st journalState
+	if err = json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+
+	p.lastPrimary, p.fencePrimary = st.Primary, st.Primary
+	p.lastPrimaryWAL = walSample{ok: st.WALKnown, lsn: st.LSN, at: st.Sampled}
+	p.walRate = st.WALRate
+	p.lastFailover = st.LastFailover
+
+	return nil
+}
+
+// Write the states queued by save() until ctx is done.
+func (j *journal) run(ctx context.Context, logger Logger) {
+	for {
+		select {
+		case st := <-j.pending:
+			if err := j.write(st); err != nil {
+				logger.Printf("Could not write the failover journal %s: %s", j.path, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Queue st to be written, replacing any state not yet written.
+func (j *journal) save(st journalState) {
+	for {
+		select {
+		case j.pending <- st:
+			return
+		default:
+		}
+
+		select {
+		case <-j.pending:
+		default:
+		}
+	}
+}
+
+// Write st to a temporary file, sync it and move it over j.path, so that the journal
+// is either the previous state or st, even if the host crashes.
+func (j *journal) write(st journalState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+
+	// Sync the directory too, so that the rename itself is durable.
+	if dir, err := os.Open(filepath.Dir(j.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// Journal the pool's knowledge of the primary, if a journal is kept.  p must be locked.
+func (p *Pool) journalState() {
+	if p.journal == nil {
+		return
+	}
+
+	p.journal.save(journalState{
+		Primary:      p.lastPrimary,
+		WALKnown:     p.lastPrimaryWAL.ok,
+		LSN:          p.lastPrimaryWAL.lsn,
+		Sampled:      p.lastPrimaryWAL.at,
+		WALRate:      p.walRate,
+		LastFailover: p.lastFailover,
+	})
+}
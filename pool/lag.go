@@ -0,0 +1,99 @@
+package pool
+
+import (
+	"time"
+)
+
+// The weight given to the most recent sample when smoothing the WAL generation rate.
+const walRateSmoothing = 0.2
+
+// Clock offsets between a follower and the primary beyond this are reported as skew.
+const maxClockSkew = 500 * time.Millisecond
+
+// walSample is a WAL position observed on a backend.
+type walSample struct {
+	ok  bool
+	lsn uint64
+
+	// When the sample was taken, according to our clock.
+	at time.Time
+
+	// The backend's clock minus ours, corrected for the round trip.
+	offset time.Duration
+}
+
+// Sample the WAL position of w, timed by now.
+func sampleWAL(w WALReporter, now func() time.Time) (s walSample) {
+	start := now()
+	lsn, clock, err := w.WALPosition()
+	elapsed := now().Sub(start)
+	if err != nil {
+		return s
+	}
+
+	// Assume the backend read its clock halfway through the round trip.
+	s.at = start.Add(elapsed / 2)
+	s.offset = clock.Sub(s.at)
+	s.lsn = lsn
+	s.ok = true
+
+	return s
+}
+
+// Update the replication lag of m with a new WAL sample.  p must be locked.
+//
+// Lag is computed from the difference in WAL positions rather than from replay
+// timestamps, and converted to time using the WAL generation rate measured on the
+// primary, so that clock skew between the nodes can't distort it.
+func (p *Pool) updateLag(m *member, s walSample) {
+	prev := m.wal
+	m.wal = s
+	m.lagBytes, m.lag, m.skew = 0, 0, 0
+	m.lagKnown = false
+
+	if !s.ok || m.state == UNAVAILABLE {
+		m.skewed = false
+		return
+	}
+
+	if m == p.primary {
+		if prev.ok && s.lsn >= prev.lsn && s.at.After(prev.at) {
+			rate := float64(s.lsn-prev.lsn) / s.at.Sub(prev.at).Seconds()
+			if p.walRate == 0 {
+				p.walRate = rate
+			} else {
+				p.walRate = walRateSmoothing*rate + (1-walRateSmoothing)*p.walRate
+			}
+		}
+		m.lagKnown = true
+		m.skewed = false
+		p.lastPrimary, p.lastPrimaryWAL = m.name, s
+		p.journalState()
+		return
+	}
+
+	if p.primary == nil || !p.primary.wal.ok {
+		// Lag is unknown without a primary to compare against.
+		return
+	}
+
+	// The primary was sampled at a different point in time; extrapolate its position to
+	// when m was sampled.
+	pw := p.primary.wal
+	expected := float64(pw.lsn) + p.walRate*s.at.Sub(pw.at).Seconds()
+	if expected > float64(s.lsn) {
+		m.lagBytes = uint64(expected) - s.lsn
+	}
+
+	if p.walRate > 0 {
+		m.lag = time.Duration(float64(m.lagBytes) / p.walRate * float64(time.Second))
+	}
+	m.lagKnown = m.lagBytes == 0 || p.walRate > 0
+
+	m.skew = s.offset - pw.offset
+	skewed := m.skew > maxClockSkew || m.skew < -maxClockSkew
+	if skewed && !m.skewed {
+		p.logFor(m).Warn("clock skew detected relative to the primary", "skew", m.skew)
+	}
+	m.skewed = skewed
+}
@@ -0,0 +1,212 @@
+package pool
+
+import (
+	"context"
+	"github.com/solvip/arbiter/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lease is a connection to a backend handed out by Acquire().  It's counted against
+// the backend until it's released, which also closes the connection.
+type Lease struct {
+	*Conn
+
+	p *Pool
+	m *member
+
+	// The drained channel of m when the lease was taken, and when it was.
+	drained <-chan struct{}
+	since   time.Time
+
+	// Closed to hand the session off; see Drained().
+	handoff     chan struct{}
+	handoffOnce sync.Once
+
+	severed atomic.Bool
+	once    sync.Once
+}
+
+// Acquire connects to a backend that satisfies the named class, bounded by the
+// deadline of ctx, and returns the connection as a Lease.  The caller must Release()
+// it.  A backend that can't be connected to isn't routed to again until its next
+// successful health check, and the next one that satisfies the class is tried
+// instead.  If no backend satisfies the class, or none is left, a *DegradedError
+// tells why.  Backends in the zone ctx carries are preferred; see InZone().  Callers
+// with the same key ctx carries stick to the same follower; see StickTo().  Followers
+// behind the LSN ctx carries are passed over; see AtLeast().
+func (p *Pool) Acquire(ctx context.Context, class string) (*Lease, error) {
+	// Each backend that fails is taken out of routing, so there's no point in trying
+	// more often than there are backends.
+	p.RLock()
+	attempts := len(p.members)
+	p.RUnlock()
+
+	for tries := 1; ; tries++ {
+		lease, retry, err := p.acquire(ctx, class)
+		if !retry || tries > attempts {
+			return lease, err
+		}
+	}
+}
+
+// Connect to a backend that satisfies the named class, as Acquire().  retry is set if
+// connecting to the backend failed, which has been taken out of routing.
+//
+// Callers only read-lock the pool, so any number of them pick backends at once, and
+// health checks waiting to update it hold up new callers rather than wait for a lull;
+// see sync.RWMutex.  Concurrent callers take turns among equal candidates; see rotate().
+func (p *Pool) acquire(ctx context.Context, class string) (lease *Lease, retry bool, err error) {
+	p.RLock()
+	c, ok := p.classes[class]
+	if !ok {
+		p.RUnlock()
+		return nil, false, ErrUnknownClass
+	}
+
+	c.minLSN = minLSNOf(ctx)
+	zone := zoneOf(ctx)
+	key := p.stickyKey(ctx, c, class)
+	m := p.stuck(c, key)
+	if m == nil {
+		m = p.pick(c, zone)
+	}
+	if m == nil {
+		p.RUnlock()
+		return nil, false, degraded(c)
+	}
+
+	// Count the lease before dialing, so that concurrent callers are spread out, and
+	// move on to the next candidate if m is at its cap; see LimitConnections().
+	if !m.tryLease() {
+		p.saturated(m)
+		if m = p.pickLeasable(c, m, zone); m == nil {
+			p.RUnlock()
+			return nil, false, &DegradedError{SATURATED}
+		}
+	}
+
+	// Move on to the next candidate if m has no dials to spare, or else wait for one;
+	// see LimitDials().
+	slots, ok := m.tryDial()
+	if !ok {
+		p.dialDeferred(m)
+		if alt, altSlots := p.pickDialable(c, m); alt != nil {
+			atomic.AddInt64(&m.leases, -1)
+			m, slots, ok = alt, altSlots, true
+		} else {
+			slots = m.dials
+		}
+	}
+	drained := m.drained
+	stick := key != "" && m.state == READ_ONLY
+	p.RUnlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+	}
+
+	if !ok {
+		deadline, _ := ctx.Deadline()
+		t := time.NewTimer(time.Until(deadline))
+		select {
+		case slots <- struct{}{}:
+		case <-t.C:
+			err = ErrDialsExhausted
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		t.Stop()
+	}
+
+	var conn *Conn
+	if err == nil {
+		conn, err = p.connect(ctx, m.b)
+		if slots != nil {
+			<-slots
+		}
+		if err != nil {
+			p.sink.AddCounter("arbiter_backend_dial_errors_total", metrics.Labels{"backend": m.name}, 1)
+		}
+	}
+
+	if err != nil {
+		atomic.AddInt64(&m.leases, -1)
+
+		if ctx.Err() != nil || err == ErrDialsExhausted {
+			return nil, false, err
+		}
+
+		p.log.Warn("could not connect; trying another backend", "backend", m.name, "error", err)
+		p.MarkUnavailable(m.b)
+		return nil, true, err
+	}
+
+	lease = &Lease{Conn: conn, p: p, m: m, drained: drained, since: p.now(), handoff: make(chan struct{})}
+	if stick {
+		p.stick(key, m)
+	}
+	m.held.Store(lease, struct{}{})
+
+	// Should m have started draining while connecting, the lease may have been missed
+	// by closeDrained().
+	select {
+	case <-drained:
+		lease.handOff()
+	default:
+	}
+
+	return lease, false, nil
+}
+
+// Backend returns the backend the lease is a connection to.
+func (l *Lease) Backend() Backend {
+	return l.m.b
+}
+
+// Name returns the name of the backend the lease is a connection to.
+func (l *Lease) Name() string {
+	return l.m.name
+}
+
+// Drained returns a channel that's closed when the backend starts being drained, or
+// the session is to move elsewhere, as when the primary changes or sessions are
+// rebalanced; see WithFailoverPolicy() and WithRebalance().  Holders of long-lived
+// leases should then hand their sessions off to another backend at the first
+// opportunity.
+func (l *Lease) Drained() <-chan struct{} {
+	return l.handoff
+}
+
+// Notify the holder of the lease that it's to hand its session off.
+func (l *Lease) handOff() {
+	l.handoffOnce.Do(func() { close(l.handoff) })
+}
+
+// Severed returns whether the connection was closed by the pool because the session
+// outlasted the grace of a failover; see WithFailoverPolicy().
+func (l *Lease) Severed() bool {
+	return l.severed.Load()
+}
+
+// Release closes the connection and returns the lease to the pool.  err is the error,
+// if any, that ended the use of the connection; it's counted against the backend.
+// Releasing a lease more than once has no effect.
+func (l *Lease) Release(err error) {
+	l.once.Do(func() {
+		if l.Conn != nil {
+			l.Conn.Close()
+		}
+
+		l.m.held.Delete(l)
+		atomic.AddInt64(&l.m.leases, -1)
+		if err != nil {
+			atomic.AddInt64(&l.m.leaseErrors, 1)
+			l.p.sink.AddCounter("arbiter_backend_lease_errors_total",
+				metrics.Labels{"backend": l.m.name}, 1)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// printfHandler is a slog.Handler that formats records as lines for a Logger, as the
+// pool logged before it logged records: the backend the record is about, the level if
+// it's WARN or above, the message, and the other fields as key=value.  Records below
+// its level are dropped.
+type printfHandler struct {
+	l     Logger
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+// NewLineHandler returns a slog.Handler that logs records from level up to l as lines,
+// as WithLogger() does at INFO, so that the level of such a Logger can be changed with
+// a slog.LevelVar through WithStructuredLogger().
+func NewLineHandler(l Logger, level slog.Leveler) slog.Handler {
+	return &printfHandler{l: l, level: level}
+}
+
+func (h *printfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *printfHandler) Handle(_ context.Context, r slog.Record) error {
+	var prefix string
+	var b strings.Builder
+	if r.Level >= slog.LevelWarn {
+		b.WriteString(r.Level.String() + ": ")
+	}
+	b.WriteString(r.Message)
+
+	add := func(a slog.Attr) bool {
+		if a.Key == "backend" {
+			prefix = a.Value.String() + ": "
+		} else {
+			fmt.Fprintf(&b, " %s=%s", a.Key, a.Value)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	h.l.Printf("%s%s", prefix, b.String())
+	return nil
+}
+
+func (h *printfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &printfHandler{l: h.l, level: h.level, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+// Groups are flattened; the pool doesn't use them.
+func (h *printfHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Return the logger for records about m, which carry its name, address, state,
+// latency and round trip as fields.
+func (p *Pool) logFor(m *member) *slog.Logger {
+	return p.log.With("backend", m.name, "addr", m.b.Addr(), "state", m.state, "latency", m.lat, "rtt", m.rtt)
+}
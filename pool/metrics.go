@@ -0,0 +1,57 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// SetMetricsSink makes the pool report its measurements to sink.  The pool reports to
+// metrics.Nop{} unless it's given WithMetrics() or this is called.  Series are labelled with the backend's name,
+// which stays stable even if its address changes.
+func (p *Pool) SetMetricsSink(sink metrics.Sink) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.sink = sink
+}
+
+// Report the measurements of m after a health check.  p must be locked.
+func (p *Pool) reportMetrics(m *member, err error, took time.Duration) {
+	l := metrics.Labels{"backend": m.name}
+
+	p.sink.ObserveDuration("arbiter_backend_check_duration_seconds", l, took)
+	if err != nil {
+		p.sink.AddCounter("arbiter_backend_check_failures_total",
+			l.With(metrics.Labels{"kind": string(ClassifyError(err))}), 1)
+	}
+
+	for _, st := range []State{UNAVAILABLE, READ_ONLY, READ_WRITE} {
+		p.sink.SetGauge("arbiter_backend_state", l.With(metrics.Labels{"state": st.String()}),
+			boolToFloat(m.state == st))
+	}
+
+	p.sink.SetGauge("arbiter_backend_latency_seconds", l, m.lat.Seconds())
+	p.sink.SetGauge("arbiter_backend_rtt_seconds", l, m.rtt.Seconds())
+	p.sink.SetGauge("arbiter_backend_lag_bytes", l, float64(m.lagBytes))
+	p.sink.SetGauge("arbiter_backend_lag_seconds", l, m.lag.Seconds())
+	p.sink.SetGauge("arbiter_backend_clock_skew_seconds", l, m.skew.Seconds())
+	p.sink.SetGauge("arbiter_backend_apply_delay_seconds", l, m.applyDelay.Seconds())
+	p.sink.SetGauge("arbiter_backend_replay_backlog_bytes", l, float64(m.replayBacklog))
+	p.sink.SetGauge("arbiter_backend_archive_failures", l, float64(m.archiveFailures))
+	p.sink.SetGauge("arbiter_backend_archive_lag_seconds", l, m.archiveLag.Seconds())
+	p.sink.SetGauge("arbiter_backend_diverged", l, boolToFloat(m.diverged))
+	p.sink.SetGauge("arbiter_backend_superseded", l, boolToFloat(m.superseded))
+	p.sink.SetGauge("arbiter_backend_unfenced", l, boolToFloat(m.unfenced))
+	p.sink.SetGauge("arbiter_backend_outvoted", l, boolToFloat(m.outvoted))
+	p.sink.SetGauge("arbiter_backend_cordoned", l, boolToFloat(m.cordoned))
+	p.sink.SetGauge("arbiter_backend_leases", l, float64(atomic.LoadInt64(&m.leases)))
+	p.reportCheckWindows(m, l)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package pool
+
+// Mode is a degraded operating mode of the pool, which callers may want to handle
+// differently: queue sessions, fall back to weaker guarantees, or reject them.
+type Mode int
+
+const (
+	// HEALTHY means the pool isn't degraded.
+	HEALTHY Mode = iota
+
+	// NO_PRIMARY means no primary is routable, such as during a failover.
+	NO_PRIMARY
+
+	// NO_REPLICAS means no backend satisfies a class that followers may serve.
+	NO_REPLICAS
+
+	// STALE_VIEW means health checks aren't completing, so the pool's view of the
+	// backends can't be trusted.
+	STALE_VIEW
+
+	// SATURATED means every backend that satisfies a class is at its cap of
+	// connections; see Pool.LimitConnections().
+	SATURATED
+
+	// FEW_REPLICAS means fewer followers satisfy a class than the caller requires to
+	// route to them; see Pool.Followers().  The pool itself never reports it.
+	FEW_REPLICAS
+)
+
+//go:generate stringer -type=Mode
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// The number of check intervals a backend may go without a completed health check
+// before the pool's view is considered stale.
+const staleChecks = 3
+
+// DegradedError is returned by Acquire() when no backend satisfies the class.  It's
+// ErrNoneAvailable according to errors.Is.
+type DegradedError struct {
+	Mode Mode
+}
+
+func (e *DegradedError) Error() string {
+	return ErrNoneAvailable.Error() + " (" + e.Mode.String() + ")"
+}
+
+func (e *DegradedError) Is(target error) bool {
+	return target == ErrNoneAvailable
+}
+
+// Return the error for a caller requiring c finding no backend.
+func degraded(c Class) error {
+	if c.PrimaryOnly {
+		return &DegradedError{NO_PRIMARY}
+	}
+	return &DegradedError{NO_REPLICAS}
+}
+
+// Stale returns whether some backend hasn't completed a health check in the last
+// few of its check intervals, which means the pool's view can't be trusted.
+func (p *Pool) Stale() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	for _, m := range p.members {
+		if p.now().Sub(m.checked) > staleChecks*p.interval(m.b) {
+			return true
+		}
+	}
+
+	return false
+}
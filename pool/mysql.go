@@ -0,0 +1,690 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MySQLSettings configure how a MySQL or MariaDB backend is checked; see
+// NewMySQLChecker().
+type MySQLSettings struct {
+	User     string
+	Password string
+
+	// The timeout for establishing the monitoring connection; 5s if zero.
+	ConnectTimeout time.Duration
+
+	// How the backend is connected to; a net.Dialer if nil.
+	Dialer Dialer
+
+	// Where the credential to monitor with comes from, rather than User and Password,
+	// as PostgresSettings.Credentials.
+	Credentials CredentialProvider
+}
+
+// MySQLError is an error a MySQL server answered with.
+type MySQLError struct {
+	Code     uint16
+	SQLState string
+	Message  string
+}
+
+func (e *MySQLError) Error() string {
+	return fmt.Sprintf("mysql: error %d (%s): %s", e.Code, e.SQLState, e.Message)
+}
+
+// The codes of the MySQL errors that ClassifyError() tells apart.
+const (
+	mysqlDBAccessDenied       = 1044
+	mysqlAccessDenied         = 1045
+	mysqlParseError           = 1064
+	mysqlTableAccessDenied    = 1142
+	mysqlSpecificAccessDenied = 1227
+	mysqlQueryInterrupted     = 1317
+	mysqlExecutionTimeout     = 3024
+)
+
+// The capabilities the monitoring connection asks for: the 4.1 protocol, with auth
+// plugins.  Result sets are ended by EOF packets, as CLIENT_DEPRECATE_EOF isn't asked
+// for.
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+
+	mysqlCapabilities = mysqlClientLongPassword | mysqlClientProtocol41 | mysqlClientTransactions |
+		mysqlClientSecureConnection | mysqlClientPluginAuth
+)
+
+const (
+	mysqlComQuery = 0x03
+	mysqlComPing  = 0x0e
+
+	// utf8mb4_general_ci.
+	mysqlCharset = 45
+)
+
+// mysqlChecker checks a MySQL or MariaDB server over a monitoring connection of its
+// own, which speaks as much of the client protocol as the checks need.
+type mysqlChecker struct {
+	addr     string
+	settings MySQLSettings
+
+	// Guards everything below.
+	mu   sync.Mutex
+	conn *mysqlConn
+
+	// The credential conn authenticated with.
+	cred Credential
+
+	// Whether the server only knows SHOW SLAVE STATUS, as MySQL before 8.0.22 and
+	// MariaDB before 10.5.1 do.
+	legacy bool
+
+	// The replication status read by the last CheckRole(), nil if the server doesn't
+	// replicate from another.
+	status map[string]*string
+}
+
+// NewMySQLChecker returns a HealthChecker of the MySQL or MariaDB server at addr, for
+// NewCheckedBackend().  A server is READ_WRITE unless read_only is set or it replicates
+// from another, its lag is the Seconds_Behind_Source of SHOW REPLICA STATUS, and its
+// latency is the round trip of a COM_PING.  The monitoring connection is kept between
+// checks, and replaced once any fails.
+func NewMySQLChecker(addr string, s MySQLSettings) HealthChecker {
+	if s.ConnectTimeout == 0 {
+		s.ConnectTimeout = defaultConnectTimeout
+	}
+	if s.Dialer == nil {
+		s.Dialer = &net.Dialer{}
+	}
+
+	return &mysqlChecker{addr: addr, settings: s}
+}
+
+// CheckRole reads read_only and the replication status of the server.  A server whose
+// replication threads are both stopped, as after STOP REPLICA, is taken to have been
+// promoted, even if RESET REPLICA hasn't cleared its status yet.
+func (c *mysqlChecker) CheckRole(ctx context.Context) (s State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status = nil
+	err = c.withConn(ctx, func(conn *mysqlConn) error {
+		rows, err := conn.query("SELECT @@global.read_only")
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 || rows[0]["@@global.read_only"] == nil {
+			return errors.New("mysql: read_only isn't set")
+		}
+		readOnly := *rows[0]["@@global.read_only"]
+
+		if rows, err = c.replicaStatus(conn); err != nil {
+			return err
+		}
+		if len(rows) > 0 && (running(rows[0], "Replica_IO_Running", "Slave_IO_Running") ||
+			running(rows[0], "Replica_SQL_Running", "Slave_SQL_Running")) {
+			c.status = rows[0]
+		}
+
+		s = READ_WRITE
+		if readOnly != "0" && readOnly != "OFF" || c.status != nil {
+			s = READ_ONLY
+		}
+		return nil
+	})
+
+	return s, err
+}
+
+// Return SHOW REPLICA STATUS, or SHOW SLAVE STATUS if the server doesn't know the
+// former.
+func (c *mysqlChecker) replicaStatus(conn *mysqlConn) ([]map[string]*string, error) {
+	if !c.legacy {
+		rows, err := conn.query("SHOW REPLICA STATUS")
+		var myErr *MySQLError
+		if !errors.As(err, &myErr) || myErr.Code != mysqlParseError {
+			return rows, err
+		}
+		c.legacy = true
+	}
+
+	return conn.query("SHOW SLAVE STATUS")
+}
+
+// Return whether the replication thread that row gives the state of in either of the
+// columns named is running, or trying to.
+func running(row map[string]*string, columns ...string) bool {
+	for _, column := range columns {
+		if v := row[column]; v != nil {
+			return *v != "No"
+		}
+	}
+	return false
+}
+
+// CheckLag returns the Seconds_Behind_Source, or Seconds_Behind_Master, that the last
+// CheckRole() read.  It's an error if it's NULL, as while the SQL thread is stopped.
+func (c *mysqlChecker) CheckLag(ctx context.Context) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.status == nil {
+		return 0, errors.New("mysql: the server doesn't replicate from another")
+	}
+
+	for _, column := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		v, ok := c.status[column]
+		if !ok {
+			continue
+		}
+		if v == nil {
+			return 0, errors.New("mysql: replication isn't running")
+		}
+		seconds, err := strconv.ParseInt(*v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("mysql: invalid %s '%s'", column, *v)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.New("mysql: the replication status has no Seconds_Behind_Source")
+}
+
+// Latency times a COM_PING, which the server answers without running a query.
+func (c *mysqlChecker) Latency(ctx context.Context) (rtt time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.withConn(ctx, func(conn *mysqlConn) error {
+		start := time.Now()
+		if err := conn.command(mysqlComPing, nil); err != nil {
+			return err
+		}
+		if _, err := conn.readResult(); err != nil {
+			return err
+		}
+		rtt = time.Since(start)
+		return nil
+	})
+
+	return rtt, err
+}
+
+// Close closes the monitoring connection, if any.
+func (c *mysqlChecker) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Run f on the monitoring connection, bounded by ctx, connecting first if there's none
+// or the credential to monitor with has changed.  The connection is closed if f fails
+// other than with an error the server answered with, lest it be left mid-exchange.  c
+// must be locked.
+func (c *mysqlChecker) withConn(ctx context.Context, f func(conn *mysqlConn) error) error {
+	cred := Credential{User: c.settings.User, Password: c.settings.Password}
+	if c.settings.Credentials != nil {
+		var err error
+		if cred, err = c.settings.Credentials.Credential(ctx); err != nil {
+			return fmt.Errorf("mysql: couldn't get the credential to monitor with: %w", err)
+		}
+	}
+	if c.conn != nil && cred != c.cred {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	if c.conn == nil {
+		conn, err := c.connect(ctx, cred)
+		if err != nil {
+			return err
+		}
+		c.conn, c.cred = conn, cred
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Unix(1, 0))
+	})
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	err := f(c.conn)
+	if !stop() || err != nil && !isMySQLError(err) {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %s", ctx.Err(), err)
+	}
+
+	return err
+}
+
+func isMySQLError(err error) bool {
+	var myErr *MySQLError
+	return errors.As(err, &myErr)
+}
+
+// Connect to the server and authenticate with cred.
+func (c *mysqlChecker) connect(ctx context.Context, cred Credential) (*mysqlConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.settings.ConnectTimeout)
+	defer cancel()
+
+	nc, err := c.settings.Dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+
+	conn := &mysqlConn{Conn: nc, r: bufio.NewReader(nc)}
+	if err := conn.handshake(cred.User, cred.Password); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// mysqlConn is a connection to a MySQL server.
+type mysqlConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// The sequence ID of the next packet, which every command starts over.
+	seq byte
+}
+
+// Read a packet, returning its payload.
+func (c *mysqlConn) readPacket() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	c.seq = header[3] + 1
+
+	// The payload is read as it comes, rather than allocated up to 16MB at once on
+	// the word of the header.
+	payload, err := io.ReadAll(io.LimitReader(c.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return payload, nil
+}
+
+// Write a packet of payload.
+func (c *mysqlConn) writePacket(payload []byte) error {
+	packet := make([]byte, 4, 4+len(payload))
+	packet[0], packet[1], packet[2] = byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16)
+	packet[3] = c.seq
+	c.seq++
+
+	_, err := c.Write(append(packet, payload...))
+	return err
+}
+
+// Send the command cmd with its argument.
+func (c *mysqlConn) command(cmd byte, arg []byte) error {
+	c.seq = 0
+	return c.writePacket(append([]byte{cmd}, arg...))
+}
+
+// Read the handshake of the server and authenticate as user with password, with the
+// auth plugin it asks for: mysql_native_password, or caching_sha2_password, whose
+// full authentication encrypts the password with the server's RSA key.
+func (c *mysqlConn) handshake(user, password string) error {
+	packet, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if len(packet) > 0 && packet[0] == 0xff {
+		return parseMySQLError(packet)
+	}
+	scramble, plugin, err := parseHandshake(packet)
+	if err != nil {
+		return err
+	}
+
+	auth, err := scrambleWith(plugin, password, scramble)
+	if err != nil {
+		return err
+	}
+
+	response := binary.LittleEndian.AppendUint32(nil, mysqlCapabilities)
+	response = binary.LittleEndian.AppendUint32(response, 1<<24)
+	response = append(response, mysqlCharset)
+	response = append(response, make([]byte, 23)...)
+	response = append(append(response, user...), 0)
+	response = append(append(response, byte(len(auth))), auth...)
+	response = append(append(response, plugin...), 0)
+	if err := c.writePacket(response); err != nil {
+		return err
+	}
+
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(packet) == 0 {
+			return errors.New("mysql: empty authentication response")
+		}
+
+		switch {
+		case packet[0] == 0x00:
+			return nil
+		case packet[0] == 0xff:
+			return parseMySQLError(packet)
+		case packet[0] == 0xfe:
+			// An auth switch request, to another plugin and scramble.
+			name, data, _ := bytes.Cut(packet[1:], []byte{0})
+			plugin, scramble = string(name), bytes.TrimSuffix(data, []byte{0})
+			if auth, err = scrambleWith(plugin, password, scramble); err != nil {
+				return err
+			}
+			if err := c.writePacket(auth); err != nil {
+				return err
+			}
+		case packet[0] == 0x01 && plugin == "caching_sha2_password" && len(packet) == 2 && packet[1] == 0x03:
+			// The fast authentication succeeded; an OK packet follows.
+		case packet[0] == 0x01 && plugin == "caching_sha2_password" && len(packet) == 2 && packet[1] == 0x04:
+			// The server needs the password itself, so ask for its public key.
+			if err := c.writePacket([]byte{0x02}); err != nil {
+				return err
+			}
+		case packet[0] == 0x01 && plugin == "caching_sha2_password":
+			encrypted, err := encryptPassword(password, scramble, packet[1:])
+			if err != nil {
+				return err
+			}
+			if err := c.writePacket(encrypted); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("mysql: unexpected authentication response 0x%02x", packet[0])
+		}
+	}
+}
+
+// Return the scramble and auth plugin of a v10 handshake.
+func parseHandshake(packet []byte) (scramble []byte, plugin string, err error) {
+	if len(packet) == 0 || packet[0] != 10 {
+		return nil, "", errors.New("mysql: unsupported handshake")
+	}
+
+	// The server version and connection ID.
+	i := bytes.IndexByte(packet[1:], 0)
+	if i < 0 || len(packet) < 1+i+1+4+8+1+2 {
+		return nil, "", errors.New("mysql: truncated handshake")
+	}
+	rest := packet[1+i+1+4:]
+
+	scramble = append(scramble, rest[:8]...)
+	rest = rest[8+1:]
+	capabilities := uint32(binary.LittleEndian.Uint16(rest))
+	if capabilities&mysqlClientProtocol41 == 0 {
+		return nil, "", errors.New("mysql: the server doesn't speak the 4.1 protocol")
+	}
+	if len(rest) < 2+1+2+2+1+10 {
+		return scramble, "mysql_native_password", nil
+	}
+
+	capabilities |= uint32(binary.LittleEndian.Uint16(rest[5:])) << 16
+	authLen := int(rest[7])
+	rest = rest[18:]
+
+	if capabilities&mysqlClientSecureConnection != 0 {
+		n := max(13, authLen-8)
+		if len(rest) < n {
+			return nil, "", errors.New("mysql: truncated handshake")
+		}
+		scramble = append(scramble, bytes.TrimSuffix(rest[:n], []byte{0})...)
+		rest = rest[n:]
+	}
+
+	plugin = "mysql_native_password"
+	if capabilities&mysqlClientPluginAuth != 0 {
+		if name, _, _ := bytes.Cut(rest, []byte{0}); len(name) > 0 {
+			plugin = string(name)
+		}
+	}
+
+	return scramble, plugin, nil
+}
+
+// Return the auth response of password to scramble for plugin.
+func scrambleWith(plugin, password string, scramble []byte) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+
+	switch plugin {
+	case "mysql_native_password":
+		// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+		h1 := sha1.Sum([]byte(password))
+		h2 := sha1.Sum(h1[:])
+		h3 := sha1.Sum(append(append([]byte(nil), scramble...), h2[:]...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	case "caching_sha2_password":
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+		h1 := sha256.Sum256([]byte(password))
+		h2 := sha256.Sum256(h1[:])
+		h3 := sha256.Sum256(append(h2[:], scramble...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	}
+
+	return nil, fmt.Errorf("mysql: unsupported auth plugin %s", plugin)
+}
+
+// Encrypt password, XORed with scramble, with the server's RSA public key, given in
+// PEM, for the full authentication of caching_sha2_password.
+func encryptPassword(password string, scramble, key []byte) ([]byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("mysql: invalid public key of the server")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: invalid public key of the server: %s", err)
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("mysql: the public key of the server isn't an RSA key")
+	}
+	if len(scramble) == 0 {
+		return nil, errors.New("mysql: the server sent no scramble")
+	}
+
+	plain := append([]byte(password), 0)
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, rsaKey, plain, nil)
+}
+
+// Read the OK packet answering a command other than a query, returning it, or the
+// error of the ERR packet the server answered with instead.
+func (c *mysqlConn) readResult() ([]byte, error) {
+	packet, err := c.readPacket()
+	switch {
+	case err != nil:
+		return nil, err
+	case len(packet) > 0 && packet[0] == 0xff:
+		return nil, parseMySQLError(packet)
+	case len(packet) == 0 || packet[0] != 0x00:
+		return nil, errors.New("mysql: unexpected response")
+	}
+	return packet, nil
+}
+
+// Run query, returning its rows by column name; NULL values are nil.
+func (c *mysqlConn) query(query string) ([]map[string]*string, error) {
+	if err := c.command(mysqlComQuery, []byte(query)); err != nil {
+		return nil, err
+	}
+
+	packet, err := c.readPacket()
+	switch {
+	case err != nil:
+		return nil, err
+	case len(packet) > 0 && packet[0] == 0xff:
+		return nil, parseMySQLError(packet)
+	case len(packet) > 0 && packet[0] == 0x00:
+		// A statement without a result set.
+		return nil, nil
+	}
+
+	// The packet holds the column count alone.  Each column definition is a packet of
+	// its own, so the columns are only counted as they're read, rather than allocated
+	// up front for any count the server claims.
+	n, rest, ok := lenencInt(packet)
+	if !ok || len(rest) > 0 {
+		return nil, errors.New("mysql: invalid column count")
+	}
+
+	var columns []string
+	for ; n > 0; n-- {
+		if packet, err = c.readPacket(); err != nil {
+			return nil, err
+		}
+		// The catalog, schema, table and original table precede the name.
+		var name *string
+		for range 5 {
+			if name, packet, ok = lenencString(packet); !ok {
+				return nil, errors.New("mysql: invalid column definition")
+			}
+		}
+		var column string
+		if name != nil {
+			column = *name
+		}
+		columns = append(columns, column)
+	}
+	if packet, err = c.readPacket(); err != nil {
+		return nil, err
+	}
+	if !isEOF(packet) {
+		return nil, errors.New("mysql: expected EOF after the column definitions")
+	}
+
+	var rows []map[string]*string
+	for {
+		if packet, err = c.readPacket(); err != nil {
+			return nil, err
+		}
+		if isEOF(packet) {
+			return rows, nil
+		}
+		if len(packet) > 0 && packet[0] == 0xff {
+			return nil, parseMySQLError(packet)
+		}
+
+		row := make(map[string]*string, len(columns))
+		for _, column := range columns {
+			var v *string
+			if v, packet, ok = lenencString(packet); !ok {
+				return nil, errors.New("mysql: invalid row")
+			}
+			row[column] = v
+		}
+		rows = append(rows, row)
+	}
+}
+
+func isEOF(packet []byte) bool {
+	return len(packet) > 0 && len(packet) < 9 && packet[0] == 0xfe
+}
+
+// Decode the length-encoded integer b starts with, returning the rest of b.
+func lenencInt(b []byte) (n uint64, rest []byte, ok bool) {
+	if len(b) == 0 {
+		return 0, nil, false
+	}
+
+	size := 0
+	switch b[0] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(b[0]), b[1:], b[0] < 0xfb
+	}
+	if len(b) < 1+size {
+		return 0, nil, false
+	}
+	for i := size; i > 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	return n, b[1+size:], true
+}
+
+// Decode the length-encoded string b starts with, which is nil if it's NULL, returning
+// the rest of b.
+func lenencString(b []byte) (s *string, rest []byte, ok bool) {
+	if len(b) > 0 && b[0] == 0xfb {
+		return nil, b[1:], true
+	}
+
+	n, rest, ok := lenencInt(b)
+	if !ok || uint64(len(rest)) < n {
+		return nil, nil, false
+	}
+	v := string(rest[:n])
+	return &v, rest[n:], true
+}
+
+// Parse an ERR packet.
+func parseMySQLError(packet []byte) error {
+	if len(packet) < 3 {
+		return &MySQLError{Code: 0, SQLState: "HY000", Message: "malformed error"}
+	}
+
+	e := &MySQLError{Code: binary.LittleEndian.Uint16(packet[1:]), SQLState: "HY000"}
+	rest := packet[3:]
+	if len(rest) >= 6 && rest[0] == '#' {
+		e.SQLState, rest = string(rest[1:6]), rest[6:]
+	}
+	e.Message = string(rest)
+
+	return e
+}
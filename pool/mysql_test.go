@@ -0,0 +1,338 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mysqlServer is as much of a MySQL server as mysqlChecker talks to: it authenticates
+// with mysql_native_password, and answers queries from results, by query.
+type mysqlServer struct {
+	net.Listener
+	password string
+
+	// The columns and rows of each query, or the error code it fails with.
+	results map[string]mysqlResult
+}
+
+type mysqlResult struct {
+	columns []string
+	rows    [][]*string
+	code    uint16
+}
+
+func startMySQLServer(t *testing.T, password string, results map[string]mysqlResult) *mysqlServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &mysqlServer{Listener: l, password: password, results: results}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&mysqlConn{Conn: conn, r: bufio.NewReader(conn)})
+		}
+	}()
+
+	return s
+}
+
+func (s *mysqlServer) serve(c *mysqlConn) {
+	defer c.Close()
+
+	scramble := []byte("abcdefghijklmnopqrst")
+	c.seq = 0
+	if c.writePacket(mysqlHandshake(scramble, "mysql_native_password")) != nil {
+		return
+	}
+
+	response, err := c.readPacket()
+	if err != nil {
+		return
+	}
+	// The capabilities, maximum packet size, charset and filler precede the user.
+	_, rest, _ := bytes.Cut(response[32:], []byte{0})
+	auth := rest[1 : 1+int(rest[0])]
+	if want, _ := scrambleWith("mysql_native_password", s.password, scramble); !bytes.Equal(auth, want) {
+		c.writeError(mysqlAccessDenied, "28000", "Access denied")
+		return
+	}
+	c.writePacket([]byte{0, 0, 0, 2, 0, 0, 0})
+
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			return
+		}
+
+		switch packet[0] {
+		case mysqlComPing:
+			c.writePacket([]byte{0, 0, 0, 2, 0, 0, 0})
+		case mysqlComQuery:
+			result, ok := s.results[string(packet[1:])]
+			if !ok || result.code != 0 {
+				c.writeError(max(result.code, mysqlParseError), "42000", "You have an error in your SQL syntax")
+				continue
+			}
+			c.writeResult(result)
+		}
+	}
+}
+
+// Return a v10 handshake with the 20 bytes of scramble, asking for plugin.
+func mysqlHandshake(scramble []byte, plugin string) []byte {
+	handshake := append([]byte{10}, "8.0.36\x00"...)
+	handshake = binary.LittleEndian.AppendUint32(handshake, 1)
+	handshake = append(append(handshake, scramble[:8]...), 0)
+	handshake = binary.LittleEndian.AppendUint16(handshake, uint16(mysqlCapabilities&0xffff))
+	handshake = append(handshake, mysqlCharset, 2, 0)
+	handshake = binary.LittleEndian.AppendUint16(handshake, uint16(mysqlCapabilities>>16))
+	handshake = append(handshake, 21)
+	handshake = append(handshake, make([]byte, 10)...)
+	handshake = append(append(handshake, scramble[8:]...), 0)
+	return append(append(handshake, plugin...), 0)
+}
+
+func (c *mysqlConn) writeError(code uint16, state, message string) {
+	packet := binary.LittleEndian.AppendUint16([]byte{0xff}, code)
+	c.writePacket(append(append(packet, "#"+state...), message...))
+}
+
+func (c *mysqlConn) writeResult(result mysqlResult) {
+	lenenc := func(b []byte, s string) []byte {
+		return append(append(b, byte(len(s))), s...)
+	}
+	eof := []byte{0xfe, 0, 0, 2, 0}
+
+	c.writePacket([]byte{byte(len(result.columns))})
+	for _, column := range result.columns {
+		var def []byte
+		for _, s := range []string{"def", "", "", "", column, column} {
+			def = lenenc(def, s)
+		}
+		c.writePacket(append(def, 0x0c, 45, 0, 0, 0, 0, 0, 0xfd, 0, 0, 0, 0, 0))
+	}
+	c.writePacket(eof)
+	for _, row := range result.rows {
+		var packet []byte
+		for _, v := range row {
+			if v == nil {
+				packet = append(packet, 0xfb)
+			} else {
+				packet = lenenc(packet, *v)
+			}
+		}
+		c.writePacket(packet)
+	}
+	c.writePacket(eof)
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestMySQLChecker(t *testing.T) {
+	readOnly := func(v string) mysqlResult {
+		return mysqlResult{columns: []string{"@@global.read_only"}, rows: [][]*string{{str(v)}}}
+	}
+	status := []string{"Source_Host", "Replica_IO_Running", "Replica_SQL_Running", "Seconds_Behind_Source"}
+
+	tests := []struct {
+		name    string
+		results map[string]mysqlResult
+		state   State
+		lag     time.Duration
+		lagErr  string
+	}{
+		{
+			name: "primary",
+			results: map[string]mysqlResult{
+				"SELECT @@global.read_only": readOnly("0"),
+				"SHOW REPLICA STATUS":       {columns: status},
+			},
+			state: READ_WRITE,
+		},
+		{
+			name: "replica",
+			results: map[string]mysqlResult{
+				"SELECT @@global.read_only": readOnly("1"),
+				"SHOW REPLICA STATUS": {columns: status, rows: [][]*string{
+					{str("10.0.0.1"), str("Yes"), str("Yes"), str("7")},
+				}},
+			},
+			state: READ_ONLY,
+			lag:   7 * time.Second,
+		},
+		{
+			// A replica that isn't read-only still isn't written to.
+			name: "writable replica",
+			results: map[string]mysqlResult{
+				"SELECT @@global.read_only": readOnly("0"),
+				"SHOW REPLICA STATUS": {columns: status, rows: [][]*string{
+					{str("10.0.0.1"), str("Connecting"), str("Yes"), nil},
+				}},
+			},
+			state:  READ_ONLY,
+			lagErr: "replication isn't running",
+		},
+		{
+			// Once replication is stopped, the server's been promoted.
+			name: "promoted",
+			results: map[string]mysqlResult{
+				"SELECT @@global.read_only": readOnly("0"),
+				"SHOW REPLICA STATUS": {columns: status, rows: [][]*string{
+					{str("10.0.0.1"), str("No"), str("No"), nil},
+				}},
+			},
+			state: READ_WRITE,
+		},
+		{
+			name: "legacy replica",
+			results: map[string]mysqlResult{
+				"SELECT @@global.read_only": readOnly("1"),
+				"SHOW SLAVE STATUS": {columns: []string{"Slave_IO_Running", "Slave_SQL_Running", "Seconds_Behind_Master"},
+					rows: [][]*string{{str("Yes"), str("Yes"), str("0")}}},
+			},
+			state: READ_ONLY,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := startMySQLServer(t, "secret", test.results)
+			hc := NewMySQLChecker(s.Addr().String(), MySQLSettings{User: "arbiter", Password: "secret"})
+			defer hc.(*mysqlChecker).Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// The second check reuses the connection of the first.
+			for range 2 {
+				state, err := hc.CheckRole(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if state != test.state {
+					t.Fatalf("Expected %s; instead got %s", test.state, state)
+				}
+			}
+
+			if test.state == READ_ONLY {
+				lag, err := hc.CheckLag(ctx)
+				switch {
+				case test.lagErr != "" && (err == nil || !strings.Contains(err.Error(), test.lagErr)):
+					t.Fatalf("Expected the lag to fail with '%s'; instead got %v", test.lagErr, err)
+				case test.lagErr == "" && err != nil:
+					t.Fatal(err)
+				case lag != test.lag:
+					t.Fatalf("Expected a lag of %s; instead got %s", test.lag, lag)
+				}
+			}
+
+			if _, err := hc.Latency(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	s := startMySQLServer(t, "secret", nil)
+	hc := NewMySQLChecker(s.Addr().String(), MySQLSettings{User: "arbiter", Password: "wrong"})
+	if _, err := hc.CheckRole(context.Background()); ClassifyError(err) != AuthError {
+		t.Fatalf("Expected an auth error; instead got %v", err)
+	}
+}
+
+// writerConn is a net.Conn that only writes, to w.
+type writerConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c writerConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+func FuzzParseHandshake(f *testing.F) {
+	scramble := []byte("abcdefghijklmnopqrst")
+	f.Add(mysqlHandshake(scramble, "mysql_native_password"))
+	f.Add(mysqlHandshake(scramble, "caching_sha2_password"))
+	f.Add(mysqlHandshake(scramble, "")[:40])
+	f.Add([]byte("\x0a5.5.5\x00\x01\x00\x00\x00abcdefgh\x00\x00\x02"))
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		scramble, plugin, err := parseHandshake(packet)
+		if err != nil {
+			return
+		}
+		if plugin == "" || len(scramble) > len(packet) {
+			t.Fatalf("Expected a plugin and a scramble out of %q; instead got %q, %q", packet, plugin, scramble)
+		}
+		if !bytes.Contains(packet, scramble[:8]) {
+			t.Fatalf("Expected the scramble %q to be read from %q", scramble, packet)
+		}
+	})
+}
+
+func FuzzMySQLQuery(f *testing.F) {
+	for _, result := range []mysqlResult{
+		{columns: []string{"@@global.read_only"}, rows: [][]*string{{str("1")}}},
+		{columns: []string{"Source_Host", "Seconds_Behind_Source"}, rows: [][]*string{{str("10.0.0.1"), nil}}},
+		{columns: []string{"Source_Host"}},
+	} {
+		var b bytes.Buffer
+		(&mysqlConn{Conn: writerConn{w: &b}}).writeResult(result)
+		f.Add(b.Bytes())
+	}
+	var b bytes.Buffer
+	(&mysqlConn{Conn: writerConn{w: &b}}).writeError(mysqlParseError, "42000", "You have an error in your SQL syntax")
+	f.Add(b.Bytes())
+	f.Add([]byte("\x01\x00\x00\x01\xfe\xff\xff\xff\xff\xff\xff\xff\xff"))
+
+	f.Fuzz(func(t *testing.T, response []byte) {
+		c := &mysqlConn{Conn: writerConn{w: io.Discard}, r: bufio.NewReader(bytes.NewReader(response))}
+		rows, err := c.query("SHOW REPLICA STATUS")
+		if err != nil {
+			return
+		}
+		for _, row := range rows {
+			if len(row) != len(rows[0]) {
+				t.Fatalf("Expected every row to have the columns of the first; instead got %v", rows)
+			}
+		}
+	})
+}
+
+func TestEncryptPasswordWithoutScramble(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	if _, err := encryptPassword("secret", []byte("abcdefghijklmnopqrst"), pub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryptPassword("secret", nil, pub); err == nil {
+		t.Fatal("Expected a password not to be encrypted without a scramble")
+	}
+}
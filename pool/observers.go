@@ -0,0 +1,59 @@
+package pool
+
+// Observers shares what several observers of the backends, such as instances of arbiter
+// in different networks, see of them; see WithObservers().
+type Observers interface {
+	// Observations returns the states the other observers last saw the backend named
+	// name in.  It's called with the pool locked, so it mustn't block or call back
+	// into the pool.
+	Observations(name string) []State
+}
+
+// WithObservers has a backend change state only once a majority of the instances
+// observers, counting the pool, saw it in its new state, so that an observer cut off
+// from the backends by a network partition doesn't declare the primary dead on its own,
+// nor fail over.  The majority is of the instances configured rather than of those
+// heard from, so that an observer cut off from the others, too, holds the backends as
+// they are.  What the pool's own checks saw is shown as Observed by Backends(), for the
+// other observers, and backends held in their state are shown as Outvoted, and exported
+// as arbiter_backend_outvoted.
+func WithObservers(o Observers, instances int) Option {
+	return func(p *Pool) {
+		p.observers = o
+		p.instances = instances
+	}
+}
+
+// Record what the check of m saw, its state unless it failed with err, and return
+// whether m is to be held in its state because no majority of the observers agrees.
+// p must be locked.
+func (p *Pool) outvoted(m *member, err error, observed State) bool {
+	if err != nil {
+		observed = UNAVAILABLE
+	}
+	m.observed = observed
+	if p.observers == nil || observed == m.state {
+		m.outvoted = false
+		return false
+	}
+
+	others := p.observers.Observations(m.name)
+	agree := 1
+	for _, s := range others {
+		if s == observed {
+			agree++
+		}
+	}
+	voters := len(others) + 1
+	if 2*agree > max(voters, p.instances) {
+		m.outvoted = false
+		return false
+	}
+
+	if !m.outvoted {
+		p.logFor(m).Warn("not transitioning; no majority of the observers agrees", "observed", observed,
+			"agree", agree, "voters", voters, "instances", p.instances)
+	}
+	m.outvoted = true
+	return true
+}
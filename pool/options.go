@@ -0,0 +1,213 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/tracing"
+	"log/slog"
+	"time"
+)
+
+// Option configures a Pool; see New().
+type Option func(*Pool)
+
+// WithCheckInterval sets how often backends are checked, unless they're
+// CheckIntervalers.  The default is one second.
+func WithCheckInterval(d time.Duration) Option {
+	return func(p *Pool) {
+		p.checkInterval = d
+	}
+}
+
+// WithCheckTimeout abandons the queries of a health check that take longer than d,
+// failing the check, for backends that are ContextPingers, unless they're
+// CheckTimeouters.  Connecting is bounded by the backend's own timeout.  The default of
+// zero waits as long as the backend does.
+func WithCheckTimeout(d time.Duration) Option {
+	return func(p *Pool) {
+		p.checkTimeout = d
+	}
+}
+
+// WithCheckConcurrency runs at most n health checks at once, however many backends
+// there are; the checks of others wait their turn past their interval.  The default is
+// 32.
+func WithCheckConcurrency(n int) Option {
+	return func(p *Pool) {
+		if n > 0 {
+			p.checkWorkers = n
+		}
+	}
+}
+
+// WithCheckJitter delays each health check by a random duration of up to d, so that
+// many pools checking the same backends spread their checks out rather than hitting
+// them in lockstep.  The default is no jitter.
+func WithCheckJitter(d time.Duration) Option {
+	return func(p *Pool) {
+		p.checkJitter = d
+	}
+}
+
+// WithCheckBackoff spaces out the checks of a backend that's down, doubling its
+// interval with every check in a row that fails, up to max, so that a host that's gone
+// isn't reconnected to at every interval for as long as it's gone.  The first check
+// that succeeds brings the interval back.  The default of zero doesn't back off.
+func WithCheckBackoff(max time.Duration) Option {
+	return func(p *Pool) {
+		p.checkBackoff = max
+	}
+}
+
+// WithFlapThresholds holds a backend in its state until health checks agree it
+// changed: it's only taken out of routing after down consecutive failed checks, and
+// only put back after up consecutive successful ones, so that a flaky network doesn't
+// churn connections.  A backend's first check counts regardless, and the role of an
+// available backend follows every check.  The default of 1 for both reacts to every
+// check.
+func WithFlapThresholds(down, up int) Option {
+	return func(p *Pool) {
+		p.downAfter, p.upAfter = down, up
+	}
+}
+
+// WithTransientGrace gives backends that report a transitional state, such as starting
+// up or recovering from a crash, up to d to come out of it before they're failed; see
+// TransientError.  Meanwhile they keep their state, and their connections, but aren't
+// routed to, so that callers requiring the primary are handled as in NO_PRIMARY mode.
+// The default of zero fails them right away.
+func WithTransientGrace(d time.Duration) Option {
+	return func(p *Pool) {
+		p.transientGrace = d
+	}
+}
+
+// WithDegradeOn keeps backends whose health checks fail with errors of the given kinds
+// in their state and in routing, rather than failing them: such errors, like the
+// monitoring user losing a privilege, are a problem for the operator rather than a sign
+// that the backend can't serve clients.  Meanwhile the backend is flagged as Degraded,
+// an alert is logged and published as an Event, and arbiter_backend_degraded is set.  A
+// backend that isn't available yet isn't put in routing by such a check.  By default
+// every kind of error fails backends; see ClassifyError().
+func WithDegradeOn(kinds ...ErrorKind) Option {
+	return func(p *Pool) {
+		p.degradeOn = make(map[ErrorKind]bool, len(kinds))
+		for _, k := range kinds {
+			p.degradeOn[k] = true
+		}
+	}
+}
+
+// WithMultiWriter models clusters where several backends legitimately accept writes,
+// such as with bi-directional replication, rather than taking a second writer for a
+// new primary.  Backends are put in write groups, each a conflict domain whose writes
+// go to a single writer at a time, with SetPromotionInfo(); a writer is replaced only
+// once it can no longer take writes, by another writer of its group; see
+// electWriters().  The writer of defaultGroup is the primary, which GetForWrite() and
+// PrimaryOnly classes route to, unless a class names another group.  The writers of
+// groups are listed by WriteGroups(), and arbiter_write_group_healthy says whether
+// each group has one.
+func WithMultiWriter(defaultGroup string) Option {
+	return func(p *Pool) {
+		p.multiWriter = true
+		p.defaultWriteGroup = defaultGroup
+		p.writers = make(map[string]*member)
+	}
+}
+
+// WithMaxDials caps the connections being established to each backend by Acquire()
+// at n at a time, unless changed with LimitDials().  The default of zero is no cap.
+func WithMaxDials(n int) Option {
+	return func(p *Pool) {
+		p.maxDials = n
+	}
+}
+
+// WithManualChecks makes the pool check backends only when Check() is called, and
+// read the time from now rather than from the system clock, so that a recorded history
+// can be replayed through it at any speed.
+func WithManualChecks(now func() time.Time) Option {
+	return func(p *Pool) {
+		p.now = now
+		p.manual = true
+	}
+}
+
+// WithLagThreshold excludes followers more than d behind the primary from GetForRead()
+// and the "eventual" class.  Followers whose lag isn't known are excluded too.  The
+// default of zero allows any follower.
+func WithLagThreshold(d time.Duration) Option {
+	return func(p *Pool) {
+		p.lagThreshold = d
+	}
+}
+
+// WithLeastConnections routes leases to the backend with the fewest outstanding
+// leases among those that satisfy the class, rather than to the closest one; ties go
+// to the closest.  See Acquire().
+func WithLeastConnections() Option {
+	return func(p *Pool) {
+		p.leastConns = true
+	}
+}
+
+// WithLatencyBand makes the backends within percent, or width, of the closest one,
+// whichever is wider, equal candidates for routing, rather than always routing to the
+// closest.  This keeps a marginally closer follower from taking all the reads.
+func WithLatencyBand(percent float64, width time.Duration) Option {
+	return func(p *Pool) {
+		p.bandPercent = percent
+		p.bandWidth = width
+	}
+}
+
+// WithLatencySmoothing routes by an exponentially weighted moving average of each
+// backend's round trips, in which every check weighs alpha, between 0 and 1, rather than
+// by the round trip of its last check alone, so that a single slow check, such as one
+// caught in a GC pause, doesn't reorder the backends.  With a window, backends are routed
+// by the 95th percentile of their last window round trips instead.  By default, and with
+// an alpha of 1, backends are routed by their last round trip.
+func WithLatencySmoothing(alpha float64, window int) Option {
+	return func(p *Pool) {
+		p.rttAlpha, p.rttWindow = alpha, window
+	}
+}
+
+// WithLogger makes the pool, and the backends put into it that are LoggerSetters, log
+// to l instead of the standard logger.  Records below INFO aren't logged; see
+// WithStructuredLogger().
+func WithLogger(l Logger) Option {
+	return func(p *Pool) {
+		p.logger = l
+		p.log = slog.New(NewLineHandler(l, slog.LevelInfo))
+	}
+}
+
+// WithStructuredLogger makes the pool log records to l instead of lines to a Logger,
+// with levels, and with fields such as the backend, its state and latency, and the
+// error, so that they can be shipped as JSON and filtered.  The outcome of every health
+// check is logged at DEBUG.  Backends put into the pool that are LoggerSetters log to l
+// at INFO.
+func WithStructuredLogger(l *slog.Logger) Option {
+	return func(p *Pool) {
+		p.logger = slog.NewLogLogger(l.Handler(), slog.LevelInfo)
+		p.log = l
+	}
+}
+
+// WithMetrics makes the pool report its measurements to sink; see SetMetricsSink().
+func WithMetrics(sink metrics.Sink) Option {
+	return func(p *Pool) {
+		p.sink = sink
+	}
+}
+
+// WithTracer reports spans of health checks and dials to t: arbiter.check, with the
+// backend, the state it was found in and any error, and arbiter.dial, with the backend
+// dialed.  Dials are children of the span the context of Acquire() or DialClass()
+// carries, so that they show in the trace of what connected.  The default discards
+// them.
+func WithTracer(t tracing.Tracer) Option {
+	return func(p *Pool) {
+		p.tracer = t
+	}
+}
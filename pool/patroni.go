@@ -0,0 +1,257 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The port the REST API of Patroni listens on by default.
+const patroniPort = "8008"
+
+// patroniStatus is the status of a node that the REST API of Patroni answers its health
+// endpoints, such as /primary and /replica, with.
+type patroniStatus struct {
+	State string `json:"state"`
+	Role  string `json:"role"`
+	XLog  struct {
+		// The insert position on a primary.
+		Location uint64 `json:"location"`
+
+		// The positions received and replayed on a replica.
+		ReceivedLocation uint64 `json:"received_location"`
+		ReplayedLocation uint64 `json:"replayed_location"`
+	} `json:"xlog"`
+}
+
+// patroni is a Postgres server managed by Patroni, checked through the REST API of its
+// Patroni agent rather than with SQL; see PostgresSettings.Patroni.
+type patroni struct {
+	settings PostgresSettings
+	client   *http.Client
+
+	// Guards addr, and api, the base URL of the REST API, which is at the host of addr
+	// unless PostgresSettings.Patroni names another.
+	mu   sync.Mutex
+	addr string
+	api  string
+
+	// The context of the health check in progress, and the status it read.
+	checkCtx context.Context
+	status   patroniStatus
+	sampled  time.Time
+
+	// The connections returned by Connect() and not yet closed.
+	inflight connSet
+}
+
+// NewBackend returns a backend reachable at addrs, in order of preference, monitored
+// according to s: through the REST API of its Patroni agent if s.Patroni is set, at
+// the first of addrs, and with SQL as NewPostgresBackendWithSettings() does otherwise.
+func NewBackend(addrs []string, s PostgresSettings) Backend {
+	if s.Patroni == "" {
+		return NewPostgresBackendWithSettings(addrs, s)
+	}
+
+	if s.ConnectTimeout == 0 {
+		s.ConnectTimeout = defaultConnectTimeout
+	}
+	if s.Dialer == nil {
+		s.Dialer = &net.Dialer{}
+	}
+
+	p := &patroni{settings: s, checkCtx: context.Background()}
+	p.client = &http.Client{
+		Timeout:   s.ConnectTimeout,
+		Transport: &http.Transport{DialContext: s.Dialer.DialContext},
+	}
+	p.SetAddr(addrs[0])
+
+	return p
+}
+
+// Return the base URL of the REST API of the Patroni agent at api, whose host is that
+// of addr if api has none, e.g. http://10.0.0.1:8008 for http://:8008, and whose port
+// is patroniPort if it has none.
+func patroniURL(api, addr string) (string, error) {
+	u, err := url.Parse(api)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("expected an http or https URL; got '%s'", api)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		if host, _, err = net.SplitHostPort(addr); err != nil {
+			return "", err
+		}
+	}
+	if port == "" {
+		port = patroniPort
+	}
+	u.Host = net.JoinHostPort(host, port)
+
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+func (p *patroni) Addr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.addr
+}
+
+// SetAddr moves the backend to address, and its REST API along with it unless
+// PostgresSettings.Patroni names its host.
+func (p *patroni) SetAddr(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addr = address
+	p.api, _ = patroniURL(p.settings.Patroni, address)
+}
+
+// CheckInterval returns how often the backend should be checked.
+func (p *patroni) CheckInterval() time.Duration {
+	return p.settings.CheckInterval
+}
+
+// CheckTimeout returns how long the requests of a check may take.
+func (p *patroni) CheckTimeout() time.Duration {
+	return p.settings.QueryTimeout
+}
+
+func (p *patroni) Ping() (State, error) {
+	return p.PingContext(context.Background())
+}
+
+// PingContext asks Patroni whether the node is the primary, and if it isn't, whether
+// it's a replica that's running, keeping the status it answers with for
+// WALPosition() and ReceivePosition().  The rest of the health check is bounded by ctx
+// too.
+func (p *patroni) PingContext(ctx context.Context) (s State, err error) {
+	p.checkCtx = ctx
+	p.status, p.sampled = patroniStatus{}, time.Time{}
+
+	var status patroniStatus
+	for _, endpoint := range []string{"primary", "replica"} {
+		var code int
+		if status, code, err = p.get(ctx, endpoint); err != nil {
+			return s, err
+		}
+		if code != http.StatusOK {
+			continue
+		}
+
+		p.status, p.sampled = status, time.Now()
+		if endpoint == "primary" {
+			return READ_WRITE, nil
+		}
+		return READ_ONLY, nil
+	}
+
+	return s, fmt.Errorf("Patroni reports the node as %s %s, neither a primary nor a replica", status.State, status.Role)
+}
+
+// Get the status of the node from endpoint, e.g. primary for /primary, and the HTTP
+// status code it's answered with.
+func (p *patroni) get(ctx context.Context, endpoint string) (status patroniStatus, code int, err error) {
+	p.mu.Lock()
+	api := p.api
+	p.mu.Unlock()
+	if api == "" {
+		return status, 0, fmt.Errorf("invalid Patroni REST API '%s'", p.settings.Patroni)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api+"/"+endpoint, nil)
+	if err != nil {
+		return status, 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return status, 0, err
+	}
+	defer resp.Body.Close()
+
+	// Patroni answers with the status whether the node has the role asked about or not,
+	// though not if it's down altogether.
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil && resp.StatusCode == http.StatusOK {
+		return status, 0, fmt.Errorf("invalid status from Patroni: %s", err)
+	}
+
+	return status, resp.StatusCode, nil
+}
+
+// RTT measures the round trip to the Patroni agent with its /liveness endpoint, which
+// answers without querying Postgres.
+func (p *patroni) RTT() (rtt time.Duration, err error) {
+	start := time.Now()
+	if _, _, err = p.get(p.checkCtx, "liveness"); err != nil {
+		return rtt, err
+	}
+
+	return time.Since(start), nil
+}
+
+// WALPosition returns the insert position of a primary, or the replay position of a
+// replica, as of the last check.
+func (p *patroni) WALPosition() (lsn uint64, clock time.Time, err error) {
+	lsn = p.status.XLog.Location
+	if lsn == 0 {
+		lsn = p.status.XLog.ReplayedLocation
+	}
+	if lsn == 0 {
+		return lsn, clock, errors.New("Patroni reports no WAL position")
+	}
+
+	return lsn, p.sampled, nil
+}
+
+// ReceivePosition returns the position of the last WAL a replica received, as of the
+// last check.
+func (p *patroni) ReceivePosition() (lsn uint64, err error) {
+	if p.status.XLog.ReceivedLocation == 0 {
+		return 0, errors.New("Patroni reports no received WAL position")
+	}
+
+	return p.status.XLog.ReceivedLocation, nil
+}
+
+// Close closes the idle connections to the REST API.
+func (p *patroni) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+func (p *patroni) Connect(t time.Duration) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t)
+	defer cancel()
+
+	return p.ConnectContext(ctx)
+}
+
+func (p *patroni) ConnectContext(ctx context.Context) (*Conn, error) {
+	underlying, err := p.settings.Dialer.DialContext(ctx, "tcp", p.Addr())
+	if err != nil {
+		p.Fail()
+		return nil, err
+	}
+
+	conn := &Conn{underlying: underlying}
+	p.inflight.add(conn)
+
+	return conn, nil
+}
+
+func (p *patroni) Fail() {
+	p.inflight.closeAll()
+}
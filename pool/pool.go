@@ -2,52 +2,787 @@
 package pool
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/tracing"
 	"log"
+	"log/slog"
+	"math/rand"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// How often backends are checked by default, unless they're CheckIntervalers.
+const defaultCheckInterval = time.Second
+
 var ErrNoneAvailable = errors.New("no backend available")
+var ErrUnknownBackend = errors.New("no such backend")
+var ErrNotReaddressable = errors.New("backend address can't be changed")
+var ErrDuplicateBackend = errors.New("backend already registered")
 
 type member struct {
 	b     Backend
 	state State
-	lat   time.Duration
+
+	// The stable, logical name of the member; its address may change.
+	name string
+
+	// The time taken by the last Ping(), including the role query.
+	lat time.Duration
+
+	// The network round-trip time, smoothed over the last checks, that the member is
+	// routed by, and that of the last check alone; see WithLatencySmoothing().  Equal to
+	// lat if the backend isn't an RTTMeasurer.
+	rtt        time.Duration
+	lastRTT    time.Duration
+	rttSamples []time.Duration
+
+	// The last WAL position observed, and the replication lag derived from it.
+	wal      walSample
+	lagBytes uint64
+	lag      time.Duration
+	lagKnown bool
+
+	// The offset between this member's clock and the primary's.
+	skew   time.Duration
+	skewed bool
+
+	// The health of the WAL archiver, if checked.
+	archiveFailing  bool
+	archiveFailures int64
+	archiveLag      time.Duration
+
+	// The apply delay measured with the heartbeat table, if enabled.
+	applyDelay time.Duration
+
+	// The address a follower replicates from, if known; see UpstreamReporter.
+	upstream string
+
+	// The WAL a follower received but hasn't replayed, in bytes; see ReceiveReporter.
+	replayBacklog uint64
+
+	// The checksum probe; see ProbeChecksums().
+	lastChecksum       time.Time
+	checksumMismatches int
+	diverged           bool
+
+	// Whether the member takes writes without being the primary, its WAL behind the
+	// primary's; see WithWALArbitration().
+	superseded bool
+
+	// Whether the member takes writes in place of the primary last seen, which isn't
+	// fenced off yet, and its last WAL sample as a follower, for the report of the
+	// failover to it; see WithFenceHook().
+	unfenced    bool
+	unfencedWAL walSample
+
+	// What the last check of the member saw, and whether a majority of the observers
+	// disagree, keeping the member in its state; see WithObservers().
+	observed State
+	outvoted bool
+
+	// Outstanding leases, and leases released with an error; see Acquire().  They're
+	// updated atomically, so that acquiring and releasing leases only read-locks the
+	// pool and never holds up health checks.
+	leases      int64
+	leaseErrors int64
+
+	// The cap of leases, if any; see LimitConnections().
+	maxLeases int64
+
+	// The share of callers balanced onto the member; see SetWeight().
+	weight float64
+
+	// Limits the dials in flight to the member, if set; see LimitDials().
+	dials chan struct{}
+
+	// How long the member slow starts for, and when it last became available; see
+	// SlowStart().
+	slowStart   time.Duration
+	availableAt time.Time
+
+	// Whether sessions were rebalanced onto the member since it became available; see
+	// WithRebalance().
+	rebalanced bool
+
+	// The series the member is anomalous in; see WithAnomalyDetector().
+	anomalies map[string]bool
+
+	// The outcomes of the last checks, a ring whose oldest is at historyNext once it's
+	// full; see WithCheckHistory().
+	history     []checkSample
+	historyNext int
+
+	// Whether the member is being drained; drained is closed when it starts.
+	draining bool
+	drained  chan struct{}
+
+	// Whether the member is cordoned, and when it's to be drained, if ever; see
+	// Cordon().
+	cordoned bool
+	drainAt  time.Time
+
+	// The outstanding leases, so that those handed off by a failover can be severed
+	// once its grace runs out; see handOff().
+	held sync.Map
+
+	// When the last health check completed, or the member was registered.
+	checked time.Time
+
+	// Closed once the first health check of the member completes; see WaitForChecks().
+	firstChecked chan struct{}
+
+	// The health checks in a row that failed, and that succeeded; see
+	// WithFlapThresholds().
+	failStreak    int
+	successStreak int
+
+	// The transitional state the member reported, and since when; see
+	// WithTransientGrace().
+	transient      string
+	transientSince time.Time
+
+	// The kind of error degrading the member, if any; see WithDegradeOn().
+	degraded ErrorKind
+
+	// The error of the last health check that failed, and when it did.
+	lastError   string
+	lastErrorAt time.Time
+
+	// The sync_state of a follower on the primary, if known; see SyncReporter.
+	syncState string
+
+	// See SetPromotionInfo().
+	promotion PromotionInfo
+
+	// The role asserted with Expect(), and whether the role observed contradicts it.
+	expect   Expectation
+	violated bool
+
+	// The identity of the server, if the backend is a NodeIdentifier, and the name of
+	// the member registered earlier for the same server, if any; see checkDuplicate().
+	node        string
+	duplicateOf string
+
+	// Schedules the health checks of the member; see startMonitor().  Guarded by
+	// Pool.monitorMu.
+	mon *monitor
+}
+
+func (m *member) String() string {
+	return fmt.Sprintf("member[name: %s, addr: %s, state = %s, latency = %s, rtt = %s]",
+		m.name, m.b.Addr(), m.state, m.lat, m.rtt)
 }
 
-func (m member) String() string {
-	return fmt.Sprintf("member[addr: %s, state = %s, latency = %s]", m.b.Addr(), m.state, m.lat)
+// BackendInfo is a point-in-time snapshot of a backend registered to a pool.
+type BackendInfo struct {
+	Name  string
+	Addr  string
+	State State
+
+	// Latency is the time taken by the last health check, including the role query.
+	Latency time.Duration
+
+	// RTT is the network round-trip time the backend is routed by: that of the last
+	// health check, or smoothed over the last checks; see WithLatencySmoothing().
+	// LastRTT is that of the last health check alone.
+	RTT     time.Duration
+	LastRTT time.Duration
+
+	// LSN is the WAL position sampled at the last health check: the current one on the
+	// primary, and the one replayed up to on followers; zero if it isn't known.
+	LSN uint64
+
+	// LagBytes is how far behind the primary a follower is, in bytes of WAL.
+	LagBytes uint64
+
+	// Lag is LagBytes converted to time using the primary's WAL generation rate.
+	// It's zero if the rate isn't known yet.
+	Lag time.Duration
+
+	// ClockSkew is the offset between the backend's clock and the primary's.
+	// ClockSkewDetected is set if it's large enough to distort timestamp-based lag.
+	ClockSkew         time.Duration
+	ClockSkewDetected bool
+
+	// The health of the WAL archiver of the primary, if Pool.CheckArchiver is on.
+	// ArchiveLag is the time since a WAL segment was last archived.
+	ArchiveFailing  bool
+	ArchiveFailures int64
+	ArchiveLag      time.Duration
+
+	// ApplyDelay is the time since the last heartbeat written on the primary was
+	// applied on the follower, if the heartbeat is enabled.
+	ApplyDelay time.Duration
+
+	// Upstream is the address the follower replicates from, if the backend reports it.
+	Upstream string
+
+	// ReplayBacklogBytes is how much of the WAL a follower received it has yet to
+	// replay, if the backend reports it; it's part of LagBytes.
+	ReplayBacklogBytes uint64
+
+	// Diverged is set if the follower's checksum keeps disagreeing with the primary's.
+	// Diverged followers aren't routed to.
+	Diverged bool
+
+	// Superseded is set if the backend takes writes without being the primary, its WAL
+	// behind the primary's; see WithWALArbitration().  Superseded backends aren't
+	// routed to.
+	Superseded bool
+
+	// Unfenced is set if the backend takes writes in place of the primary last seen,
+	// which isn't fenced off yet; see WithFenceHook().  Unfenced backends aren't routed
+	// to.
+	Unfenced bool
+
+	// Observed is the state the last check of the backend saw, which it's only moved to
+	// once a majority of the observers agrees with WithObservers(); until then, it's
+	// Outvoted.
+	Observed State
+	Outvoted bool
+
+	// Leases is the number of outstanding leases; LeaseErrors counts the leases that
+	// were released with an error.
+	Leases      int64
+	LeaseErrors int64
+
+	// MaxConnections caps Leases, if it isn't zero; see Pool.LimitConnections().
+	MaxConnections int64
+
+	// Weight is the share of callers balanced onto the backend; see Pool.SetWeight().
+	Weight float64
+
+	// Draining is set while the backend is being drained; see Pool.Drain().
+	Draining bool
+
+	// Cordoned is set while the backend takes no new sessions, its own left alone; see
+	// Pool.Cordon().
+	Cordoned bool
+
+	// Warmth is the share of its callers the backend takes while it's slow starting,
+	// from 0 to 1; see Pool.SlowStart().
+	Warmth float64
+
+	// RoleViolation is set while the backend's role contradicts the one asserted with
+	// Pool.Expect().
+	RoleViolation bool
+
+	// DuplicateOf is the name of another backend found to be the same server under a
+	// different address.  Reads aren't routed to the duplicate, so as not to weigh the
+	// server twice.
+	DuplicateOf string
+
+	// The health checks in a row that failed, and that succeeded, up to the last one;
+	// see WithFlapThresholds().
+	FailStreak    int
+	SuccessStreak int
+
+	// Transient is the transitional state the backend is held in, out of routing, if
+	// any; see WithTransientGrace().
+	Transient string
+
+	// Degraded is the kind of error the backend's checks fail with that it's still
+	// routed to despite, if any; see WithDegradeOn().
+	Degraded ErrorKind
+
+	// Anomalies are the series, such as SeriesLatency, that the backend is anomalous
+	// in; see WithAnomalyDetector().
+	Anomalies []string
+
+	// CheckWindows summarize the backend's recent checks; see WithCheckHistory().
+	CheckWindows []CheckWindow
+
+	// WriteGroup is the write group of the backend, and Writer is set if writes of
+	// the group are routed to it; see WithMultiWriter().
+	WriteGroup string
+	Writer     bool
+
+	// LastError is the error of the last health check that failed, even if later ones
+	// succeeded, and LastErrorAt when it did.  They're zero if none has.
+	LastError   string
+	LastErrorAt time.Time
+
+	// LastCheckAt is when the last health check completed, or when the backend was
+	// registered if none has yet.
+	LastCheckAt time.Time
+
+	// SyncState is how the follower replicates from the primary: sync or quorum if it
+	// counts toward acknowledging commits, potential or async otherwise.  It's empty
+	// for the primary, and if the primary isn't a SyncReporter.
+	SyncState string
 }
 
 type Pool struct {
 	sync.RWMutex
 
+	// The root context; canceling it stops all monitors and their health checks.
+	ctx context.Context
+
+	// Tracks the running monitors, the scheduler and its workers, and counts the
+	// monitors; see Wait() and Monitors().
+	monitors  sync.WaitGroup
+	nmonitors atomic.Int64
+
+	// Runs the health checks of all members; see schedule().
+	sched scheduler
+
+	// Set by options; see New().
+	checkInterval  time.Duration
+	checkTimeout   time.Duration
+	checkJitter    time.Duration
+	checkBackoff   time.Duration
+	checkWorkers   int
+	downAfter      int
+	upAfter        int
+	transientGrace time.Duration
+	degradeOn      map[ErrorKind]bool
+	failoverPolicy FailoverPolicy
+	maxDials       int
+	maxConns       int
+	lagThreshold   time.Duration
+	logger         Logger
+	log            *slog.Logger
+	leastConns     bool
+	bandPercent    float64
+	bandWidth      time.Duration
+	rttAlpha       float64
+	rttWindow      int
+	scorer         Scorer
+	detector       Detector
+	historySize    int
+	historyWindows []time.Duration
+
+	// The share of the sessions of followers to rebalance onto a new one, and at what
+	// rate; see WithRebalance().
+	rebalancePercent float64
+	rebalanceRate    float64
+
+	// How long callers stick to the follower they were last routed to, and whom they
+	// stick to, by class and key; see WithStickiness().  sticky is guarded by stickyMu
+	// rather than the pool's lock, which callers only read-lock.
+	stickyTTL    time.Duration
+	stickyMu     sync.Mutex
+	sticky       map[string]stickyRoute
+	stickyPruned time.Time
+
+	// The clock, and whether backends are only checked by Check(); see
+	// WithManualChecks().
+	now    func() time.Time
+	manual bool
+
+	// The name of the member last seen as the primary, its last WAL sample as such,
+	// and the report of the last failover; see failover().  Kept in journal, if set.
+	lastPrimary    string
+	lastPrimaryWAL walSample
+	lastFailover   *FailoverReport
+	journal        *journal
+
+	// The synchronous replication setting of the primary; see SyncConfig().
+	syncConfig SyncConfig
+
+	// Rotates between equal candidates; see pick().
+	rotation uint64
+
+	// Routed to while there are no members, if set; see WithFallback().  populated is
+	// closed when the first member is registered.
+	fallback  *member
+	populated chan struct{}
+
+	// Ranks followers for PromotionCandidates().
+	promotion PromotionPolicy
+
 	// all members registered to this pool.
-	members []member
+	members []*member
 
 	// all available members; always ordered by latency.
 	avail []*member
 
 	// Always points to the primary member.
 	primary *member
+
+	// Whether several members may take writes, and the writer of each write group, the
+	// default one's being the primary; see WithMultiWriter().
+	multiWriter       bool
+	defaultWriteGroup string
+	writers           map[string]*member
+
+	// Whether every member takes writes, with none of them the primary; see
+	// WithDistributed().
+	distributed bool
+
+	// Whether a writer replaces the primary only if its WAL is ahead; see
+	// WithWALArbitration().
+	walArbitration bool
+
+	// Fences off the previous primary before writes are routed to another, the name of
+	// the member writes were last routed to, restored from journal, if set; see
+	// WithFenceHook().
+	fenceHook    FenceHook
+	fencePrimary string
+
+	// Shares what the pool sees of the backends with other observers, of which there
+	// are instances, counting the pool; see WithObservers().
+	observers Observers
+	instances int
+
+	// The rate at which the primary generates WAL, in bytes per second.
+	walRate float64
+
+	// Named consistency classes.
+	classes map[string]Class
+
+	// Whether to check the WAL archiver of the primary.
+	checkArchiver bool
+
+	// The heartbeat table; see EnableHeartbeat().
+	heartbeatTable  string
+	heartbeatCreate bool
+
+	// The fencing table, and the epoch last issued in it; see EnableFencing().
+	fencingTable  string
+	fencingCreate bool
+	epoch         uint64
+
+	// The table the topology is published to, and the view last published there; see
+	// PublishTopology().
+	topologyTable     string
+	topologyCreate    bool
+	topologyPublished string
+
+	// The checksum probe; see ProbeChecksums().
+	checksumQuery    string
+	checksumInterval time.Duration
+	primaryChecksum  string
+
+	// Where measurements are reported; see SetMetricsSink().
+	sink metrics.Sink
+
+	// Where spans of checks and dials are reported; see WithTracer().
+	tracer tracing.Tracer
+
+	// Receivers of state transitions; see Subscribe().
+	subscribers []chan Event
+
+	// Serializes starting and stopping monitors.
+	monitorMu sync.Mutex
+}
+
+// Return a new pool whose lifetime is bound to ctx, configured by opts.  Once ctx is
+// canceled, backends are no longer monitored and in-flight health checks are abandoned;
+// use Wait() to wait for the monitors to wind down.
+func New(ctx context.Context, opts ...Option) *Pool {
+	p := &Pool{
+		ctx:           ctx,
+		sink:          metrics.Nop{},
+		tracer:        tracing.Nop{},
+		checkInterval: defaultCheckInterval,
+		checkWorkers:  defaultCheckConcurrency,
+		logger:        log.Default(),
+		log:           slog.New(NewLineHandler(log.Default(), slog.LevelInfo)),
+		populated:     make(chan struct{}),
+		promotion:     DefaultPromotionPolicy{},
+		now:           time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.journal != nil {
+		if err := p.journal.restore(p); err != nil {
+			p.log.Error("could not read the failover journal", "path", p.journal.path, "error", err)
+		}
+		go p.journal.run(ctx, p.logger)
+	}
+
+	p.classes = map[string]Class{
+		"strong":   Strong,
+		"eventual": Class{MaxLag: p.lagThreshold},
+	}
+
+	p.sched.wake = make(chan struct{}, 1)
+	p.sched.due = make(chan *monitor)
+	p.monitors.Add(1)
+	go p.schedule()
+	if !p.manual {
+		p.monitors.Add(p.checkWorkers)
+		for i := 0; i < p.checkWorkers; i++ {
+			go p.work()
+		}
+	}
+
+	return p
+}
+
+// Put registers a backend, named after its address.
+func (p *Pool) Put(backend Backend) error {
+	return p.PutNamed(backend.Addr(), backend)
+}
+
+// PutNamed registers a backend under a stable name, which identifies it even if its
+// address is changed with UpdateAddress().  It's unavailable until its first health
+// check, which starts right away; see WaitForCheck().  It returns ErrDuplicateBackend,
+// leaving the pool untouched, if a backend is already registered under the name or the
+// address.
+func (p *Pool) PutNamed(name string, backend Backend) error {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+	p.Lock()
+	defer p.Unlock()
+
+	for _, o := range p.members {
+		if o.name == name || o.b.Addr() == backend.Addr() {
+			p.log.Warn("not registering a backend already registered",
+				"backend", name, "addr", backend.Addr(), "registered", o.name, "registered_addr", o.b.Addr())
+			return ErrDuplicateBackend
+		}
+	}
+
+	m := &member{
+		b:            backend,
+		name:         name,
+		drained:      make(chan struct{}),
+		checked:      p.now(),
+		firstChecked: make(chan struct{}),
+		promotion:    PromotionInfo{Priority: 1},
+		dials:        dialSlots(p.maxDials),
+		maxLeases:    int64(p.maxConns),
+		weight:       1,
+	}
+	if ls, ok := backend.(LoggerSetter); ok {
+		ls.SetLogger(p.logger)
+	}
+
+	p.members = append(p.members, m)
+	// Backends may be removed and registered again; populated is only closed once.
+	select {
+	case <-p.populated:
+	default:
+		close(p.populated)
+	}
+	p.startMonitor(m)
+
+	return nil
+}
+
+// RestartMonitor tears down and recreates the monitor of the backend named or addressed
+// addr, abandoning a check in progress and closing its monitoring resources if it's an
+// io.Closer.  The backend's routing state is left untouched until the new monitor has
+// fresh results.
+func (p *Pool) RestartMonitor(addr string) error {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+
+	p.RLock()
+	m := p.find(addr)
+	p.RUnlock()
+
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	p.stopMonitor(m)
+	p.startMonitor(m)
+
+	return nil
 }
 
-// Return a new pool
-func New() *Pool {
-	return &Pool{}
+// UpdateAddress changes the address of the backend with the given name, preserving its
+// state and history.  The backend must be a Readdresser.  Its monitor is restarted to
+// connect to the new address; routing state is kept until fresh results arrive.
+func (p *Pool) UpdateAddress(name, addr string) error {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+
+	p.RLock()
+	m := p.find(name)
+	p.RUnlock()
+
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	r, ok := m.b.(Readdresser)
+	if !ok {
+		return ErrNotReaddressable
+	}
+
+	p.RLock()
+	for _, o := range p.members {
+		if o != m && o.b.Addr() == addr {
+			p.RUnlock()
+			return ErrDuplicateBackend
+		}
+	}
+	p.RUnlock()
+
+	p.stopMonitor(m)
+	p.log.Info("changing address", "backend", m.name, "to", addr)
+	r.SetAddr(addr)
+	p.startMonitor(m)
+
+	return nil
 }
 
-func (p *Pool) Put(backend Backend) {
+// Remove stops monitoring the backend named or addressed addr, releasing its monitoring
+// resources if it's an io.Closer, and takes it out of the pool.  Its series are deleted
+// from the metrics sink, if it's a metrics.Deleter.  The holders of its leases are
+// notified as by Drain(), and may release them as usual.
+func (p *Pool) Remove(addr string) error {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+
+	p.RLock()
+	m := p.find(addr)
+	p.RUnlock()
+
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	p.stopMonitor(m)
+
 	p.Lock()
 	defer p.Unlock()
 
-	b := member{b: backend}
+	p.logFor(m).Info("removing")
+	p.members = remove(p.members, m)
+	p.avail = remove(p.avail, m)
+	if p.primary == m {
+		p.primary = nil
+	}
+	if !m.draining {
+		m.draining = true
+		m.closeDrained()
+	}
+	p.transition(m, UNAVAILABLE, nil)
+	p.electWriters()
+
+	if d, ok := p.sink.(metrics.Deleter); ok {
+		d.Delete(metrics.Labels{"backend": m.name})
+	}
+
+	return nil
+}
+
+// Return the member named or addressed addr, or nil.  p must be at least read-locked.
+func (p *Pool) find(addr string) *member {
+	for _, m := range p.members {
+		if m.name == addr {
+			return m
+		}
+	}
+
+	for _, m := range p.members {
+		if m.b.Addr() == addr {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// Wait blocks until the monitors of all backends have returned, which they do once the
+// pool's context is canceled.  It must not be called concurrently with Put().
+func (p *Pool) Wait() {
+	p.monitors.Wait()
+}
+
+// Monitors returns how many monitors are running.  Once those of removed and replaced
+// backends have wound down, there's one per backend; any more have leaked.
+func (p *Pool) Monitors() int {
+	return int(p.nmonitors.Load())
+}
+
+// Backends returns a snapshot of all backends registered to this pool, in order of
+// registration.  The snapshot is the caller's own: it doesn't change as the pool does,
+// and changing it doesn't change the pool.
+func (p *Pool) Backends() []BackendInfo {
+	p.RLock()
+	defer p.RUnlock()
+
+	ret := make([]BackendInfo, 0, len(p.members))
+	for _, m := range p.members {
+		info := m.info(p.now())
+		info.CheckWindows = p.checkWindows(m)
+		info.Writer = p.multiWriter && p.writers[m.promotion.WriteGroup] == m
+		ret = append(ret, info)
+	}
+
+	return ret
+}
+
+// ForEach calls f with each backend registered to this pool, in order of registration,
+// until f returns false.  The backends are taken from a single consistent snapshot,
+// and the pool isn't locked while f runs, so f may call back into the pool.
+func (p *Pool) ForEach(f func(BackendInfo) bool) {
+	for _, info := range p.Backends() {
+		if !f(info) {
+			return
+		}
+	}
+}
+
+// Return a snapshot of m as of now.  The pool must be at least read-locked.
+func (m *member) info(now time.Time) BackendInfo {
+	return BackendInfo{
+		Name:    m.name,
+		Addr:    m.b.Addr(),
+		State:   m.state,
+		Latency: m.lat,
+		RTT:     m.rtt,
+		LastRTT: m.lastRTT,
+
+		LSN:               m.wal.lsn,
+		LagBytes:          m.lagBytes,
+		Lag:               m.lag,
+		ClockSkew:         m.skew,
+		ClockSkewDetected: m.skewed,
+
+		ArchiveFailing:  m.archiveFailing,
+		ArchiveFailures: m.archiveFailures,
+		ArchiveLag:      m.archiveLag,
+
+		ApplyDelay: m.applyDelay,
+		Upstream:   m.upstream,
+		Diverged:   m.diverged,
+		Superseded: m.superseded,
+		Unfenced:   m.unfenced,
+		Observed:   m.observed,
+		Outvoted:   m.outvoted,
+
+		ReplayBacklogBytes: m.replayBacklog,
+
+		Leases:         atomic.LoadInt64(&m.leases),
+		LeaseErrors:    atomic.LoadInt64(&m.leaseErrors),
+		MaxConnections: m.maxLeases,
+		Weight:         m.weight,
+
+		Draining: m.draining,
+		Cordoned: m.cordoned,
+		Warmth:   m.warmth(now),
+
+		RoleViolation: m.violated,
+		DuplicateOf:   m.duplicateOf,
 
-	p.members = append(p.members, b)
-	go p.monitor(&b)
+		FailStreak:    m.failStreak,
+		SuccessStreak: m.successStreak,
+		Transient:     m.transient,
+		Degraded:      m.degraded,
+		Anomalies:     m.anomalous(),
+
+		WriteGroup: m.promotion.WriteGroup,
+
+		LastError:   m.lastError,
+		LastErrorAt: m.lastErrorAt,
+		LastCheckAt: m.checked,
+		SyncState:   m.syncState,
+	}
 }
 
 // Get a member; can return any - including the primary.
@@ -55,90 +790,426 @@ func (p *Pool) GetForRead() (b Backend, err error) {
 	p.RLock()
 	defer p.RUnlock()
 
-	if len(p.avail) == 0 {
+	m := p.pick(Class{MaxLag: p.lagThreshold}, "")
+	if m == nil {
 		return nil, ErrNoneAvailable
 	}
 
-	return p.avail[0].b, nil
+	return m.b, nil
 }
 
-// Get a member that's available for writes; 'always' the primary.
+// Get a member that's available for writes; 'always' the primary, or with
+// WithDistributed(), the closest node.
 func (p *Pool) GetForWrite() (b Backend, err error) {
 	p.RLock()
 	defer p.RUnlock()
 
-	if p.primary == nil {
+	if len(p.members) == 0 && p.fallback != nil {
+		return p.fallback.b, nil
+	}
+
+	if p.distributed {
+		m := p.pick(Strong, "")
+		if m == nil {
+			return nil, ErrNoneAvailable
+		}
+		return m.b, nil
+	}
+
+	if p.primary == nil || p.primary.draining || p.primary.cordoned || p.primary.transient != "" {
 		return nil, ErrNoneAvailable
 	}
 
 	return p.primary.b, nil
 }
 
-// Monitor a member
-func (p *Pool) monitor(m *member) {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-
-	for _ = range ticker.C {
-		start := time.Now()
-		newstate, err := m.b.Ping()
-		lat := time.Since(start)
-
-		p.Lock()
-
-		switch {
-		case err != nil && m.state != UNAVAILABLE:
-			// We must be going down
-			newstate = UNAVAILABLE
-			p.avail = remove(p.avail, m)
-			if m.state == READ_WRITE {
-				p.primary = nil
-			}
-			m.b.Fail()
-
-		case err != nil && m.state == UNAVAILABLE:
-			newstate = UNAVAILABLE
-			// Nothing to do.  Still down.
-
-		case err == nil && m.state == newstate:
-			// Nothing changed.
-
-		case err == nil && m.state == UNAVAILABLE:
-			// Going from unavailable to available
-			p.avail = append(p.avail, m)
-			if newstate == READ_WRITE {
-				p.primary = m
-			}
-
-		case err == nil && m.state == READ_WRITE && newstate == READ_ONLY:
-			// The member transition from primary to follower; fail all connections and
-			// let client applications reconnect.
-			// We could be smarter here and only fail read-write connections.
+// Return how often b is checked.
+func (p *Pool) interval(b Backend) time.Duration {
+	if ci, ok := b.(CheckIntervaler); ok && ci.CheckInterval() > 0 {
+		return ci.CheckInterval()
+	}
+
+	return p.checkInterval
+}
+
+// Return how long the queries of a check of b may take.
+func (p *Pool) timeout(b Backend) time.Duration {
+	if ct, ok := b.(CheckTimeouter); ok && ct.CheckTimeout() > 0 {
+		return ct.CheckTimeout()
+	}
+
+	return p.checkTimeout
+}
+
+// Return how long to wait before the next check of m: its interval, backed off while
+// it's down, plus a random share of the jitter, so that arbiters started together
+// don't check in lockstep.
+func (p *Pool) nextCheck(m *member) time.Duration {
+	d := p.interval(m.b)
+	if p.checkBackoff > d {
+		p.RLock()
+		failed := 0
+		if m.state == UNAVAILABLE {
+			failed = m.failStreak
+		}
+		p.RUnlock()
+
+		for ; failed > 1 && d < p.checkBackoff; failed-- {
+			d *= 2
+		}
+		if d > p.checkBackoff {
+			d = p.checkBackoff
+		}
+	}
+	if p.checkJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.checkJitter)))
+	}
+
+	return d
+}
+
+// Check checks the health of the backend named or addressed addr now, rather than at
+// its next scheduled check, and updates the pool accordingly, returning once it has.
+// The check waits for one in progress, so that the backend is never checked twice at
+// once, and its next scheduled check is an interval after.  It returns ErrUnknownBackend if
+// there's no such backend.
+func (p *Pool) Check(addr string) error {
+	return p.onTurn(context.Background(), addr, func(mon *monitor) error {
+		p.check(mon.ctx, mon.m)
+		p.reschedule(mon)
+		return nil
+	})
+}
+
+// CheckAll checks the health of every backend now, as Check() does, concurrently, and
+// returns once all are checked.  It's meant for callers that know the topology just
+// changed, as after a failover they ran, so that the pool needn't take up to an
+// interval to notice.
+func (p *Pool) CheckAll() {
+	var wg sync.WaitGroup
+	for _, b := range p.Backends() {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			p.Check(name)
+		}(b.Name)
+	}
+	wg.Wait()
+}
+
+// Check the health of a member, updating the pool accordingly.  A check interrupted by
+// ctx being canceled is discarded, so that stopping a monitor doesn't fail the member.
+func (p *Pool) check(ctx context.Context, m *member) {
+	ctx, span := p.tracer.Start(ctx, "arbiter.check")
+	defer span.End()
+	span.SetAttribute("arbiter.backend", m.name)
+	span.SetAttribute("server.address", m.b.Addr())
+
+	start := p.now()
+	var newstate State
+	var err error
+	if cp, ok := m.b.(ContextPinger); ok {
+		pingCtx := ctx
+		if d := p.timeout(m.b); d > 0 {
+			var cancel context.CancelFunc
+			pingCtx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		newstate, err = cp.PingContext(pingCtx)
+	} else {
+		newstate, err = m.b.Ping()
+	}
+	lat := p.now().Sub(start)
+
+	// Measure the round trip separately from the role query, so that a backend under
+	// load isn't misread as being far away.
+	rtt := lat
+	if r, ok := m.b.(RTTMeasurer); ok && err == nil {
+		rtt, err = r.RTT()
+	}
+
+	var wal walSample
+	if w, ok := m.b.(WALReporter); ok && err == nil && !p.distributed {
+		wal = sampleWAL(w, p.now)
+	}
+
+	p.RLock()
+	checkArchiver := p.checkArchiver
+	identify := m.node == "" || m.state == UNAVAILABLE
+	p.RUnlock()
+
+	var archiver ArchiverStatus
+	var archiverOK bool
+	if a, ok := m.b.(ArchiveReporter); ok && checkArchiver && err == nil && newstate == READ_WRITE {
+		archiver, err = a.ArchiverStatus()
+		if archiverOK = err == nil; !archiverOK {
+			p.log.Warn("could not check the WAL archiver", "backend", m.name, "error", err)
+			err = nil
+		}
+	}
+
+	var delay time.Duration
+	if h, ok := m.b.(Heartbeater); ok && err == nil {
+		delay, _ = p.heartbeat(m, h, newstate)
+	}
+
+	if w, ok := m.b.(TopologyWriter); ok && err == nil && newstate == READ_WRITE {
+		p.publishTopology(m, w)
+	}
+
+	var backlog uint64
+	if r, ok := m.b.(ReceiveReporter); ok && err == nil && newstate == READ_ONLY && wal.ok {
+		if received, rerr := r.ReceivePosition(); rerr != nil {
+			p.log.Warn("could not read the received WAL position", "backend", m.name, "error", rerr)
+		} else if received > wal.lsn {
+			backlog = received - wal.lsn
+		}
+	}
+
+	var syncConfig SyncConfig
+	var standbys []SyncStandby
+	var syncOK bool
+	if r, ok := m.b.(SyncReporter); ok && err == nil && newstate == READ_WRITE && !p.distributed {
+		if syncConfig, standbys, err = r.SyncStandbys(); err != nil {
+			p.log.Warn("could not read the synchronous standbys", "backend", m.name, "error", err)
+			err = nil
+		} else {
+			syncOK = true
+		}
+	}
+
+	var lag time.Duration
+	var lagOK bool
+	if r, ok := m.b.(LagReporter); ok && err == nil && newstate == READ_ONLY {
+		if lag, err = r.Lag(); err != nil {
+			p.log.Warn("could not read the lag", "backend", m.name, "error", err)
+			err = nil
+		} else {
+			lagOK = true
+		}
+	}
+
+	var upstream string
+	if u, ok := m.b.(UpstreamReporter); ok && err == nil && newstate == READ_ONLY {
+		if upstream, err = u.Upstream(); err != nil {
+			p.log.Warn("could not read the upstream", "backend", m.name, "error", err)
+			err = nil
+		}
+	}
+
+	// The identity of a server only changes when it restarts.
+	var node string
+	if n, ok := m.b.(NodeIdentifier); ok && err == nil && identify && !p.distributed {
+		if node, err = n.NodeID(); err != nil {
+			p.log.Warn("could not identify the server", "backend", m.name, "error", err)
+			err = nil
+		}
+	}
+
+	var sum string
+	var sumOK bool
+	if c, ok := m.b.(Checksummer); ok && err == nil {
+		sum, sumOK = p.probeChecksum(m, c)
+	}
+
+	if ctx.Err() != nil {
+		span.SetError(ctx.Err())
+		return
+	}
+	if err != nil {
+		span.SetError(err)
+	}
+
+	p.Lock()
+
+	var failover *FailoverReport
+	transient := p.holdTransient(m, err)
+	degraded := p.holdDegraded(m, err)
+	held := degraded || p.streak(m, err) || transient
+	held = p.outvoted(m, err, newstate) || held
+	switch {
+	case held:
+		// Not enough checks in a row agree yet, the member is given time to come out
+		// of a transitional state, its checks fail in a way that doesn't take it out
+		// of routing, or the other observers disagree; see WithFlapThresholds(),
+		// WithTransientGrace(), WithDegradeOn() and WithObservers().
+		newstate = m.state
+
+	case err != nil && m.state != UNAVAILABLE:
+		// We must be going down
+		newstate = UNAVAILABLE
+		p.avail = remove(p.avail, m)
+		if p.primary == m {
 			p.primary = nil
-			m.b.Fail()
+		}
+		m.b.Fail()
+
+	case err != nil && m.state == UNAVAILABLE:
+		newstate = UNAVAILABLE
+		// Nothing to do.  Still down.
 
-		case err == nil && m.state == READ_ONLY && newstate == READ_WRITE:
-			// The member transitioned from follower to primary
-			p.primary = m
+	case err == nil && m.state == READ_WRITE && newstate == READ_WRITE && m.superseded && p.singlePrimary():
+		// A writer passed over for its WAL being behind the primary's; it takes over
+		// once it's ahead.  See WithWALArbitration().
+		if p.arbitrate(m, wal) && p.fenced(m) {
+			failover = p.failover(m)
+			p.replacePrimary(m)
 		}
 
-		m.lat = lat
-		if m.state != newstate {
-			log.Printf("%s: transitioning to %s", m, newstate)
+	case err == nil && m.state == newstate:
+		// Nothing changed.
+
+	case err == nil && m.state == UNAVAILABLE:
+		// Going from unavailable to available
+		p.avail = append(p.avail, m)
+		m.availableAt = p.now()
+		if newstate == READ_WRITE && m.mayBePrimary() && p.singlePrimary() && p.arbitrate(m, wal) && p.fenced(m) {
+			failover = p.failover(m)
+			p.replacePrimary(m)
+		}
+
+	case err == nil && m.state == READ_WRITE && newstate == READ_ONLY:
+		// The member transitioned from primary to follower; its sessions are severed
+		// or drained according to the FailoverPolicy.  With multi-writer, that's up
+		// to the election of its replacement.
+		if p.primary == m {
+			p.primary = nil
+		}
+		if p.singlePrimary() {
+			p.handOff(m)
 		}
 
-		m.state = newstate
-		sort.Sort(byLatency(p.avail))
+	case err == nil && m.state == READ_ONLY && newstate == READ_WRITE && m.mayBePrimary() && p.singlePrimary():
+		// The member transitioned from follower to primary, unless its WAL is behind
+		// the primary's; see WithWALArbitration(), and until the previous primary is
+		// fenced off; see WithFenceHook().
+		if p.arbitrate(m, wal) && p.fenced(m) {
+			failover = p.failover(m)
+			p.replacePrimary(m)
+		}
+	}
+
+	switch {
+	case err == nil:
+		m.lat = lat
+		p.observeRTT(m, rtt)
+	case !held:
+		// A failed check that's held isn't taken as a measure of a member still routed
+		// to, and others don't count toward its round trip once it's back.
+		m.lat = lat
+		p.resetRTT(m, rtt)
+	}
+	m.checked = p.now()
+	p.recordCheck(m, m.checked, lat, err == nil)
+	if err != nil {
+		m.lastError, m.lastErrorAt = err.Error(), m.checked
+	}
+	if newstate != READ_WRITE {
+		m.superseded, m.unfenced = false, false
+	}
+	p.transition(m, newstate, failover)
+	span.SetAttribute("arbiter.state", m.state.String())
+	p.checkExpectation(m)
+	p.electWriters()
+	if p.primary != nil {
+		p.fencePrimary = p.primary.name
+	}
+	p.checkRebalance(m)
+	if node != "" {
+		m.node = node
+	}
+	p.checkDuplicate(m)
+	p.updateLag(m, wal)
+	if lagOK && m.state == READ_ONLY {
+		m.lag, m.lagKnown = lag, true
+	}
+	if err == nil {
+		p.detectAnomalies(m)
+	}
+	p.updateArchiver(m, archiver, archiverOK)
+	m.applyDelay = delay
+	m.upstream = upstream
+	m.replayBacklog = backlog
+	p.updateQuorum(m, syncConfig, standbys, syncOK)
+	p.reportMetrics(m, err, p.now().Sub(start))
+	if p.log.Enabled(p.ctx, slog.LevelDebug) {
+		p.logFor(m).Debug("checked", "took", p.now().Sub(start), "error", err)
+	}
+	if sumOK {
+		p.updateChecksum(m, sum)
+	}
+	sort.Sort(byLatency(p.avail))
+	select {
+	case <-m.firstChecked:
+	default:
+		close(m.firstChecked)
+	}
+	unfenced := m.unfenced && p.singlePrimary()
+
+	p.Unlock()
+
+	if unfenced {
+		p.fencePrevious(ctx, m)
+	}
+	if f, ok := m.b.(Fencer); ok && err == nil && newstate == READ_WRITE {
+		p.fence(m, f)
+	}
+}
+
+// Count the check of m that failed with err, or succeeded, in its streaks, and return
+// whether m is to be held in its state because the streak is too short to move it in or
+// out of routing.  p must be locked.
+func (p *Pool) streak(m *member, err error) (held bool) {
+	first := m.failStreak == 0 && m.successStreak == 0
+	if err != nil {
+		m.failStreak, m.successStreak = m.failStreak+1, 0
+		return !first && m.state != UNAVAILABLE && m.failStreak < p.downAfter
+	}
 
-		p.Unlock()
+	m.failStreak, m.successStreak = 0, m.successStreak+1
+	return !first && m.state == UNAVAILABLE && m.successStreak < p.upAfter
+}
+
+// Move m to state s, announcing the transition if it's one, along with the failover it
+// completes, if any.  p must be locked.
+func (p *Pool) transition(m *member, s State, failover *FailoverReport) {
+	if m.state != s {
+		p.logFor(m).Info("transitioning", "to", s)
+		p.publish(Event{Name: m.name, Addr: m.b.Addr(), From: m.state, To: s, Time: p.now(), Failover: failover})
+		p.sink.AddCounter("arbiter_backend_transitions_total",
+			metrics.Labels{"backend": m.name, "from": m.state.String(), "to": s.String()}, 1)
+	}
+
+	m.state = s
+}
+
+// MarkUnavailable takes b out of routing until its next successful health check.  It's
+// meant for callers that failed to connect to a backend returned by the pool; DialClass
+// calls it on their behalf.
+func (p *Pool) MarkUnavailable(b Backend) {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, m := range p.members {
+		if m.b != b || m.state == UNAVAILABLE {
+			continue
+		}
+
+		// remove() preserves the order of avail, which stays sorted by latency.
+		p.avail = remove(p.avail, m)
+		if m == p.primary {
+			p.primary = nil
+		}
+		p.transition(m, UNAVAILABLE, nil)
 	}
+	p.electWriters()
 }
 
 type byLatency []*member
 
 func (coll byLatency) Len() int           { return len(coll) }
 func (coll byLatency) Swap(i, j int)      { coll[i], coll[j] = coll[j], coll[i] }
-func (coll byLatency) Less(i, j int) bool { return coll[i].lat < coll[j].lat }
+func (coll byLatency) Less(i, j int) bool { return coll[i].rtt < coll[j].rtt }
 
 // Opposite of append.  Remove it from s, returning s - it.
 func remove(s []*member, it *member) (ret []*member) {
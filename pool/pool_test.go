@@ -1,13 +1,30 @@
 package pool
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/tracing"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestEmptyPool(t *testing.T) {
-	emptyPool := New()
+	emptyPool := New(context.Background())
 
 	b, err := emptyPool.GetForRead()
 	if b != nil || err != ErrNoneAvailable {
@@ -20,8 +37,130 @@ func TestEmptyPool(t *testing.T) {
 	}
 }
 
+func TestFallback(t *testing.T) {
+	p := New(context.Background(), WithFallback(&mockend{id: "fallback"}))
+
+	if it, err := p.GetForWrite(); err != nil || it.(*mockend).id != "fallback" {
+		t.Fatalf("Expected the fallback to be routed to, instead got: %v, %v", it, err)
+	}
+
+	if it, err := p.GetForClass("eventual"); err != nil || it.(*mockend).id != "fallback" {
+		t.Fatalf("Expected the fallback to be routed to, instead got: %v, %v", it, err)
+	}
+
+	// Not once there are backends.
+	p.Put(&mockend{id: "a", state: UNAVAILABLE, err: errors.New("down")})
+	if it, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected ErrNoneAvailable, instead got: %v, %v", it, err)
+	}
+}
+
+func TestWaitForBackends(t *testing.T) {
+	p := New(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.WaitForBackends(ctx); err != ErrNoBackends {
+		t.Fatalf("Expected ErrNoBackends, instead got: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Put(&mockend{id: "a"})
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.WaitForBackends(ctx); err != nil {
+		t.Fatalf("Expected a backend to be registered, instead got: %v", err)
+	}
+}
+
+func TestWaitForChecks(t *testing.T) {
+	// The first check is right away, not an interval in.
+	p := New(context.Background(), WithCheckInterval(time.Hour))
+	p.Put(&mockend{state: READ_WRITE, id: "a"})
+	p.Put(&mockend{state: UNAVAILABLE, id: "b", err: errors.New("down")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.WaitForChecks(ctx); err != nil {
+		t.Fatalf("Expected every backend to be checked, instead got: %v", err)
+	}
+	if b, err := p.GetForWrite(); err != nil || b.Addr() != "a" {
+		t.Errorf("Expected a to be routable once checked, instead got: %v, %v", b, err)
+	}
+
+	// Backends registered later are waited for as well, or alone.
+	p.Put(&mockend{state: READ_ONLY, id: "c"})
+	if err := p.WaitForCheck(ctx, "c"); err != nil {
+		t.Fatalf("Expected c to be checked, instead got: %v", err)
+	}
+	if b := p.Backends()[2]; b.State != READ_ONLY {
+		t.Errorf("Expected c to be a follower once checked, instead got: %v", b.State)
+	}
+	if err := p.WaitForCheck(ctx, "d"); err != ErrUnknownBackend {
+		t.Errorf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+
+	manual := New(context.Background(), WithManualChecks(time.Now))
+	manual.Put(&mockend{state: READ_ONLY, id: "a"})
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := manual.WaitForChecks(expired); err != context.Canceled {
+		t.Errorf("Expected context.Canceled before the first check, instead got: %v", err)
+	}
+}
+
+func TestMonitors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx, WithManualChecks(time.Now))
+	p.Put(&mockend{state: READ_WRITE, id: "a"})
+	p.Put(&mockend{state: READ_ONLY, id: "b"})
+	if n := p.Monitors(); n != 2 {
+		t.Fatalf("Expected a monitor per backend; instead got %d", n)
+	}
+
+	p.Remove("a")
+	if n := p.Monitors(); n != 1 {
+		t.Errorf("Expected the monitor of a removed backend to stop; instead got %d", n)
+	}
+
+	cancel()
+	p.Wait()
+	if n := p.Monitors(); n != 0 {
+		t.Errorf("Expected no monitors once the pool is done; instead got %d", n)
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	p := New(context.Background(), WithCheckInterval(time.Hour))
+	a := &mockend{state: READ_WRITE, id: "a"}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	p.Put(a)
+	p.Put(b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.WaitForChecks(ctx); err != nil {
+		t.Fatalf("Expected every backend to be checked, instead got: %v", err)
+	}
+
+	// A failover the pool wouldn't notice for an hour is noticed right away.
+	a.set(READ_ONLY, nil)
+	b.set(READ_WRITE, nil)
+	p.CheckAll()
+	if w, err := p.GetForWrite(); err != nil || w.Addr() != "b" {
+		t.Errorf("Expected b to be the primary once checked, instead got: %v, %v", w, err)
+	}
+
+	if err := p.Check("nonexistent"); err != ErrUnknownBackend {
+		t.Errorf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
 func TestGet(t *testing.T) {
-	p := New()
+	p := New(context.Background())
 
 	a := &mockend{state: READ_ONLY, id: "a"}
 	b := &mockend{state: READ_WRITE, id: "b"}
@@ -49,7 +188,7 @@ func TestGet(t *testing.T) {
 }
 
 func TestFail(t *testing.T) {
-	p := New()
+	p := New(context.Background())
 
 	// Since the initial state of all backends in pool is unavailable, allow one health
 	// check to succeed.
@@ -58,14 +197,17 @@ func TestFail(t *testing.T) {
 
 	time.Sleep(1001 * time.Millisecond)
 
-	a.err = errors.New("Kill")
+	a.set(READ_WRITE, errors.New("Kill"))
 
 	time.Sleep(1001 * time.Millisecond)
 
-	if !a.fail {
-		t.Fatalf("Expected a.Fail() to have been called; a = %#v, %s", a)
+	if !a.failed() {
+		t.Fatalf("Expected a.Fail() to have been called; a = %#v", a)
 	}
 
+	p.RLock()
+	defer p.RUnlock()
+
 	if len(p.avail) != 0 {
 		t.Fatalf("Expected the pool to have no available backends")
 	}
@@ -75,25 +217,2578 @@ func TestFail(t *testing.T) {
 	}
 }
 
-type mockend struct {
-	id    string
-	err   error
-	state State
-	fail  bool
+func TestRTTOrdering(t *testing.T) {
+	p := New(context.Background())
+
+	// a answers the role query as fast as b, but is further away.
+	a := &rttmockend{mockend{state: READ_ONLY, id: "a"}, 50 * time.Millisecond}
+	b := &rttmockend{mockend{state: READ_ONLY, id: "b"}, 1 * time.Millisecond}
+
+	p.Put(a)
+	p.Put(b)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	it, err := p.GetForRead()
+	if err != nil || it.(*rttmockend).id != b.id {
+		t.Fatalf("Expected to get the backend with the lowest RTT, instead got: %v, %v", it, err)
+	}
+
+	for _, info := range p.Backends() {
+		if info.RTT != a.rtt && info.RTT != b.rtt {
+			t.Errorf("Expected Backends() to report the measured RTT, instead got %s", info.RTT)
+		}
+	}
 }
 
-func (m *mockend) Ping() (State, error) {
-	return m.state, m.err
+func TestBackendsSnapshot(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := New(context.Background(), WithManualChecks(func() time.Time { return now }))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+
+	now = now.Add(time.Minute)
+	a.set(UNAVAILABLE, errors.New("connection refused"))
+	p.Check("pg1")
+
+	snapshot := p.Backends()
+	if b := snapshot[0]; b.Name != "pg1" || b.Addr != "a" || b.LastError != "connection refused" ||
+		!b.LastErrorAt.Equal(now) || !b.LastCheckAt.Equal(now) {
+		t.Errorf("Expected the last check and its error; instead got %+v", b)
+	}
+
+	now = now.Add(time.Minute)
+	a.set(READ_WRITE, nil)
+	p.Check("pg1")
+
+	if b := snapshot[0]; !b.LastCheckAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected the snapshot not to change with the pool; instead got %+v", b)
+	}
+	if b := p.Backends()[0]; !b.LastCheckAt.Equal(now) || b.LastError != "connection refused" {
+		t.Errorf("Expected a new snapshot to show the last check, and the last error; instead got %+v", b)
+	}
 }
 
-func (m *mockend) Fail() {
-	m.fail = true
+// fakeChecker is a HealthChecker of a backend that isn't Postgres.
+type fakeChecker struct {
+	role State
+	lag  time.Duration
+	rtt  time.Duration
 }
 
-func (m *mockend) Addr() string {
-	return "foo"
+func (f *fakeChecker) CheckRole(ctx context.Context) (State, error)        { return f.role, nil }
+func (f *fakeChecker) CheckLag(ctx context.Context) (time.Duration, error) { return f.lag, nil }
+func (f *fakeChecker) Latency(ctx context.Context) (time.Duration, error)  { return f.rtt, nil }
+
+func TestCheckedBackend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	p := New(context.Background(), WithManualChecks(time.Now))
+	p.PutNamed("primary", NewCheckedBackend(ln.Addr().String(), &fakeChecker{role: READ_WRITE, rtt: time.Millisecond}, nil))
+	p.PutNamed("follower", NewCheckedBackend("127.0.0.1:1", &fakeChecker{role: READ_ONLY, lag: 3 * time.Second, rtt: 2 * time.Millisecond}, nil))
+	p.Check("primary")
+	p.Check("follower")
+
+	for _, b := range p.Backends() {
+		if b.Name == "follower" && (b.State != READ_ONLY || b.Lag != 3*time.Second || b.RTT != 2*time.Millisecond) {
+			t.Errorf("Expected the follower's role, lag and round trip from its checker; instead got %+v", b)
+		}
+	}
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil || lease.Name() != "primary" {
+		t.Fatalf("Expected to connect to the primary; instead got %v", err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Failing the backend closes its connections.
+	lease.Backend().Fail()
+	if _, err := lease.Write([]byte("x")); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	lease.Release(nil)
 }
 
-func (m *mockend) Connect(t time.Duration) (c *Conn, err error) {
-	return c, err
+func TestPatroniBackend(t *testing.T) {
+	// The node is a replica until it's promoted.
+	var mu sync.Mutex
+	role := "replica"
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		status := `{"state": "running", "role": "replica", "xlog": {"received_location": 2000, "replayed_location": 1500}}`
+		if role == "primary" {
+			status = `{"state": "running", "role": "primary", "xlog": {"location": 3000}}`
+		}
+		if req.URL.Path != "/liveness" && req.URL.Path != "/"+role {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprint(w, status)
+	}))
+	defer api.Close()
+	_, port, _ := net.SplitHostPort(api.Listener.Addr().String())
+
+	b := NewBackend([]string{"127.0.0.1:5432"}, PostgresSettings{Patroni: "http://:" + port})
+	if s, err := b.Ping(); s != READ_ONLY || err != nil {
+		t.Fatalf("Expected a replica; instead got %s, %v", s, err)
+	}
+	if lsn, _, err := b.(WALReporter).WALPosition(); lsn != 1500 || err != nil {
+		t.Errorf("Expected the replayed position 1500; instead got %d, %v", lsn, err)
+	}
+	if lsn, err := b.(ReceiveReporter).ReceivePosition(); lsn != 2000 || err != nil {
+		t.Errorf("Expected the received position 2000; instead got %d, %v", lsn, err)
+	}
+	if _, err := b.(RTTMeasurer).RTT(); err != nil {
+		t.Errorf("Expected the round trip to be measured; instead got %v", err)
+	}
+
+	mu.Lock()
+	role = "primary"
+	mu.Unlock()
+	if s, err := b.Ping(); s != READ_WRITE || err != nil {
+		t.Fatalf("Expected a primary; instead got %s, %v", s, err)
+	}
+	if lsn, _, err := b.(WALReporter).WALPosition(); lsn != 3000 || err != nil {
+		t.Errorf("Expected the insert position 3000; instead got %d, %v", lsn, err)
+	}
+
+	// A node that's neither, such as one whose Postgres is starting, is unavailable.
+	mu.Lock()
+	role = "starting"
+	mu.Unlock()
+	if _, err := b.Ping(); err == nil {
+		t.Errorf("Expected a node that's neither primary nor replica to fail its check")
+	}
+
+	// Without Patroni, the backend is checked with SQL.
+	if _, ok := NewBackend([]string{"127.0.0.1:5432"}, PostgresSettings{}).(*pg); !ok {
+		t.Errorf("Expected a Postgres backend without Patroni")
+	}
+}
+
+func TestLag(t *testing.T) {
+	p := New(context.Background())
+
+	primary := &member{b: &mockend{id: "a"}, state: READ_WRITE}
+	follower := &member{b: &mockend{id: "b"}, state: READ_ONLY}
+	p.primary = primary
+
+	// The primary generates 1000 bytes of WAL per second, and its clock is 2s ahead of
+	// the follower's.
+	now := time.Now()
+	p.updateLag(primary, walSample{ok: true, lsn: 10000, at: now, offset: time.Second})
+	p.updateLag(primary, walSample{ok: true, lsn: 11000, at: now.Add(time.Second), offset: time.Second})
+	p.updateLag(follower, walSample{ok: true, lsn: 10500, at: now.Add(1500 * time.Millisecond), offset: -time.Second})
+
+	if follower.lagBytes != 1000 {
+		t.Errorf("Expected the follower to be 1000 bytes behind, instead got %d", follower.lagBytes)
+	}
+
+	if follower.lag != time.Second {
+		t.Errorf("Expected the follower to be 1s behind, instead got %s", follower.lag)
+	}
+
+	if !follower.skewed || follower.skew != -2*time.Second {
+		t.Errorf("Expected a clock skew of -2s to be detected, instead got %s", follower.skew)
+	}
+}
+
+// walmockend reports fixed WAL positions.
+type walmockend struct {
+	mockend
+	replayed, received uint64
+}
+
+func (m *walmockend) WALPosition() (uint64, time.Time, error) {
+	return m.replayed, time.Now(), nil
+}
+
+func (m *walmockend) ReceivePosition() (uint64, error) {
+	return m.received, nil
+}
+
+func TestReplayBacklog(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithLagThreshold(time.Second))
+
+	primary := &walmockend{mockend{state: READ_WRITE, id: "a"}, 10000, 0}
+	follower := &walmockend{mockend{state: READ_ONLY, id: "b"}, 9000, 9500}
+	p.Put(primary)
+	p.Put(follower)
+	p.Check("a")
+	p.Check("b")
+
+	for _, b := range p.Backends() {
+		if b.Name == "b" && (b.LagBytes < 1000 || b.ReplayBacklogBytes != 500) {
+			t.Errorf("Expected b to have replayed 500 of the 1000 bytes it's behind; instead got %+v", b)
+		}
+		if b.Name == "a" && b.ReplayBacklogBytes != 0 {
+			t.Errorf("Expected the primary to have no replay backlog; instead got %d", b.ReplayBacklogBytes)
+		}
+	}
+
+	// The follower's lag isn't known without the primary's WAL rate.
+	if it, err := p.GetForRead(); err != nil || it != primary {
+		t.Errorf("Expected the follower of unknown lag to be excluded; instead got %v, %v", it, err)
+	}
+}
+
+func TestParseSyncStandbyNames(t *testing.T) {
+	for s, want := range map[string]string{
+		"":                         "",
+		"pg2":                      "FIRST 1 (pg2)",
+		"pg2, pg3":                 "FIRST 1 (pg2, pg3)",
+		"2 (pg2, pg3)":             "FIRST 2 (pg2, pg3)",
+		`ANY 2 (pg2, "Pg 3", pg4)`: "ANY 2 (pg2, Pg 3, pg4)",
+		"first 1 (*)":              "FIRST 1 (*)",
+	} {
+		c, err := ParseSyncStandbyNames(s)
+		if err != nil || c.String() != want {
+			t.Errorf("%q: Expected %q; instead got %q, %v", s, want, c, err)
+		}
+	}
+
+	for _, s := range []string{"ANY (pg2)", "SOME 1 (pg2)", "ANY 0 (pg2)", "ANY 1 (pg2", "ANY 1 (pg2,)"} {
+		if _, err := ParseSyncStandbyNames(s); err == nil {
+			t.Errorf("%q: Expected to be rejected", s)
+		}
+	}
+}
+
+type syncmockend struct {
+	mockend
+	names    string
+	standbys []SyncStandby
+}
+
+func (m *syncmockend) SyncStandbys() (SyncConfig, []SyncStandby, error) {
+	c, err := ParseSyncStandbyNames(m.names)
+	return c, m.standbys, err
+}
+
+func TestQuorumOnly(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now))
+	p.DefineClass("durable", Class{FollowersOnly: true, QuorumOnly: true, Balance: BalanceRoundRobin})
+
+	primary := &syncmockend{mockend: mockend{state: READ_WRITE, id: "a"}, names: "ANY 1 (b, c)",
+		standbys: []SyncStandby{{Name: "b", State: "quorum"}, {Name: "c", State: "potential"}}}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	c := &mockend{state: READ_ONLY, id: "c"}
+	for _, m := range []Backend{primary, b, c} {
+		p.Put(m)
+	}
+	for _, id := range []string{"b", "c", "a"} {
+		p.Check(id)
+	}
+
+	if got := p.SyncConfig().String(); got != "ANY 1 (b, c)" {
+		t.Errorf("Expected the primary's quorum; instead got %q", got)
+	}
+	for i := 0; i < 4; i++ {
+		if it, err := p.GetForClass("durable"); err != nil || it != b {
+			t.Fatalf("Expected only b, in the quorum, to be routed to; instead got %v, %v", it, err)
+		}
+	}
+
+	// Once c catches up to count toward the quorum too, it's routed to as well.
+	primary.standbys[1].State = "quorum"
+	p.Check("a")
+	for _, info := range p.Backends() {
+		if info.Name == "c" && info.SyncState != "quorum" {
+			t.Errorf("Expected c to count toward the quorum; instead got %+v", info)
+		}
+	}
+	if names, _ := p.Candidates("durable"); len(names) != 2 {
+		t.Errorf("Expected b and c to be candidates; instead got %v", names)
+	}
+	if n, _ := p.Followers("durable"); n != 2 {
+		t.Errorf("Expected b and c to be counted as followers; instead got %d", n)
+	}
+	if n, _ := p.Followers("strong"); n != 0 {
+		t.Errorf("Expected no followers of strong; instead got %d", n)
+	}
+}
+
+func TestPreferQuorum(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now))
+	p.DefineClass("fresh", Class{FollowersOnly: true, PreferQuorum: true, Balance: BalanceRoundRobin})
+
+	primary := &syncmockend{mockend: mockend{state: READ_WRITE, id: "a"}, names: "FIRST 1 (b, c)",
+		standbys: []SyncStandby{{Name: "b", State: "sync"}, {Name: "c", State: "potential"}}}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	c := &mockend{state: READ_ONLY, id: "c"}
+	for _, m := range []Backend{primary, b, c} {
+		p.Put(m)
+	}
+	for _, id := range []string{"b", "c", "a"} {
+		p.Check(id)
+	}
+
+	for i := 0; i < 4; i++ {
+		if it, err := p.GetForClass("fresh"); err != nil || it != b {
+			t.Fatalf("Expected b, the synchronous standby, to be preferred; instead got %v, %v", it, err)
+		}
+	}
+
+	// Once b is gone, the asynchronous c is routed to rather than failing.
+	b.state = UNAVAILABLE
+	p.Check("b")
+	if it, err := p.GetForClass("fresh"); err != nil || it != c {
+		t.Fatalf("Expected c to be routed to without a synchronous standby; instead got %v, %v", it, err)
+	}
+}
+
+func TestWALArbitration(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithWALArbitration())
+
+	a := &walmockend{mockend: mockend{state: READ_WRITE, id: "a"}, replayed: 10000}
+	b := &walmockend{mockend: mockend{state: READ_ONLY, id: "b"}, replayed: 9000}
+	p.Put(a)
+	p.Put(b)
+	p.Check("a")
+	p.Check("b")
+
+	// a goes away and b is promoted, writing past a.
+	a.state = UNAVAILABLE
+	p.Check("a")
+	b.state, b.replayed = READ_WRITE, 10500
+	p.Check("b")
+
+	// a comes back as a primary, with the WAL it had.
+	a.state = READ_WRITE
+	p.Check("a")
+	if it, err := p.GetForWrite(); err != nil || it != b {
+		t.Fatalf("Expected b, whose WAL is ahead, to stay the primary; instead got %v, %v", it, err)
+	}
+	for _, info := range p.Backends() {
+		if want := info.Name == "a"; info.Superseded != want || info.Routable() == want {
+			t.Errorf("Expected only a to be superseded and not routable; got %+v", info)
+		}
+	}
+
+	// Once a is ahead, it takes over.
+	a.replayed = 11000
+	p.Check("a")
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected a, now ahead, to take over; instead got %v, %v", it, err)
+	}
+	for _, info := range p.Backends() {
+		if want := info.Name == "b"; info.Superseded != want {
+			t.Errorf("Expected only b to be superseded; got %+v", info)
+		}
+	}
+}
+
+func TestFenceHook(t *testing.T) {
+	var mu sync.Mutex
+	var fenced []string
+	fenceErr := errors.New("no answer from the PDU")
+	fence := func(ctx context.Context, previous, next BackendInfo) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fenced = append(fenced, previous.Name+"/"+previous.Addr+">"+next.Name)
+		return fenceErr
+	}
+	p := New(context.Background(), WithManualChecks(time.Now), WithFenceHook(fence))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	p.Put(a)
+	p.Put(b)
+	p.CheckAll()
+	if len(fenced) != 0 {
+		t.Fatalf("Expected the first primary not to be fenced; instead got %v", fenced)
+	}
+
+	// a goes away and b is promoted, but a can't be fenced off.
+	a.set(UNAVAILABLE, errors.New("down"))
+	p.Check("a")
+	b.set(READ_WRITE, nil)
+	p.Check("b")
+	if it, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected writes to be held until a is fenced off; instead got %v, %v", it, err)
+	}
+	for _, info := range p.Backends() {
+		if want := info.Name == "b"; info.Unfenced != want || want && info.Routable() {
+			t.Errorf("Expected only b to be unfenced and not routable; got %+v", info)
+		}
+	}
+
+	mu.Lock()
+	fenceErr = nil
+	mu.Unlock()
+	p.Check("b")
+	if it, err := p.GetForWrite(); err != nil || it != b {
+		t.Fatalf("Expected writes to be routed to b once a is fenced off; instead got %v, %v", it, err)
+	}
+	if want := []string{"a/a>b", "a/a>b"}; !reflect.DeepEqual(fenced, want) {
+		t.Errorf("Expected a to be fenced off for b until it succeeded; instead got %v", fenced)
+	}
+
+	// b stays the primary without being fenced again.
+	p.Check("b")
+	if len(fenced) != 2 {
+		t.Errorf("Expected b not to fence again; instead got %v", fenced)
+	}
+}
+
+// staticObservers are other observers that saw backends as told.
+type staticObservers struct {
+	mu     sync.Mutex
+	states map[string][]State
+}
+
+func (o *staticObservers) Observations(name string) []State {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.states[name]
+}
+
+func TestObservers(t *testing.T) {
+	others := &staticObservers{states: map[string][]State{"a": {READ_WRITE, READ_WRITE}, "b": {READ_ONLY}}}
+	p := New(context.Background(), WithManualChecks(time.Now), WithObservers(others, 3))
+
+	// A majority of the three instances sees a as the primary, and b as a follower.
+	a := &mockend{state: READ_WRITE, id: "a"}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	p.Put(a)
+	p.Put(b)
+	p.CheckAll()
+
+	// Only the pool loses a.
+	a.set(UNAVAILABLE, errors.New("no route to host"))
+	p.Check("a")
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected a to stay the primary while the others see it; instead got %v, %v", it, err)
+	}
+	for _, info := range p.Backends() {
+		if want := info.Name == "a"; info.Outvoted != want || want && info.Observed != UNAVAILABLE {
+			t.Errorf("Expected only a to be outvoted, observed unavailable; got %+v", info)
+		}
+	}
+
+	// Once another observer loses it too, a majority agrees.
+	others.mu.Lock()
+	others.states["a"] = []State{UNAVAILABLE, READ_WRITE}
+	others.mu.Unlock()
+	p.Check("a")
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected a to be down once a majority agrees; instead got %v", err)
+	}
+	if info := p.Backends()[0]; info.State != UNAVAILABLE || info.Outvoted {
+		t.Errorf("Expected a to be unavailable, and no longer outvoted; got %+v", info)
+	}
+
+	// Cut off from the others, the pool is no majority of the instances on its own.
+	others.mu.Lock()
+	others.states = nil
+	others.mu.Unlock()
+	b.set(UNAVAILABLE, errors.New("no route to host"))
+	p.Check("b")
+	if info := p.Backends()[1]; info.State != READ_ONLY || !info.Outvoted {
+		t.Errorf("Expected b to be held a follower without a quorum; got %+v", info)
+	}
+}
+
+type topomockend struct {
+	mockend
+	writes [][]BackendInfo
+}
+
+func (m *topomockend) WriteTopology(table string, backends []BackendInfo, create bool) error {
+	m.writes = append(m.writes, backends)
+	return nil
+}
+
+func TestPublishTopology(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now))
+	p.PublishTopology("arbiter_topology", true)
+
+	primary := &topomockend{mockend: mockend{state: READ_WRITE, id: "a"}}
+	follower := &mockend{state: READ_ONLY, id: "b"}
+	p.Put(primary)
+	p.Put(follower)
+	p.Check("b")
+	p.Check("a")
+	p.Check("a")
+
+	if len(primary.writes) != 1 || len(primary.writes[0]) != 2 || !primary.writes[0][1].Routable() {
+		t.Fatalf("Expected the topology to be written once, with b routable; instead got %+v", primary.writes)
+	}
+
+	// b going down is published on the primary's next check.
+	follower.set(READ_ONLY, errors.New("connection refused"))
+	p.Check("b")
+	p.Check("a")
+	if len(primary.writes) != 2 || primary.writes[1][1].Routable() {
+		t.Fatalf("Expected b to be published as not routable; instead got %+v", primary.writes)
+	}
+}
+
+func TestGetForClass(t *testing.T) {
+	p := New(context.Background())
+	p.DefineClass("bounded", Class{MaxLag: time.Second})
+
+	primary := &member{b: &mockend{id: "a"}, state: READ_WRITE, rtt: 10 * time.Millisecond}
+	near := &member{b: &mockend{id: "b"}, state: READ_ONLY, lagKnown: true, lag: 5 * time.Second}
+	far := &member{b: &mockend{id: "c"}, state: READ_ONLY, lagKnown: true, rtt: time.Millisecond}
+	p.primary = primary
+	p.avail = []*member{near, far, primary}
+
+	for class, id := range map[string]string{"strong": "a", "eventual": "b", "bounded": "c"} {
+		it, err := p.GetForClass(class)
+		if err != nil || it.(*mockend).id != id {
+			t.Errorf("Expected class %s to get backend %s, instead got: %v, %v", class, id, it, err)
+		}
+	}
+
+	if _, err := p.GetForClass("nonexisting"); err != ErrUnknownClass {
+		t.Errorf("Expected ErrUnknownClass, instead got %v", err)
+	}
+}
+
+func TestRestartMonitor(t *testing.T) {
+	p := New(context.Background())
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.Put(a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if err := p.RestartMonitor("a"); err != nil {
+		t.Fatalf("Expected to restart the monitor, instead got error: %v", err)
+	}
+
+	if !a.closed {
+		t.Fatalf("Expected the backend to have been closed")
+	}
+
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected the backend to stay routable, instead got: %v, %v", it, err)
+	}
+
+	if err := p.RestartMonitor("bar"); err != ErrUnknownBackend {
+		t.Fatalf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
+func TestUpdateAddress(t *testing.T) {
+	p := New(context.Background())
+
+	a := &addrmockend{mockend{state: READ_WRITE, id: "a"}, "10.0.0.1:5432"}
+	p.PutNamed("pg1", a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if err := p.UpdateAddress("pg1", "10.0.0.2:5432"); err != nil {
+		t.Fatalf("Expected to update the address, instead got error: %v", err)
+	}
+
+	info := p.Backends()[0]
+	if info.Name != "pg1" || info.Addr != "10.0.0.2:5432" || info.State != READ_WRITE {
+		t.Fatalf("Expected the backend to keep its name and state, instead got: %+v", info)
+	}
+
+	p.Put(&mockend{id: "b"})
+	if err := p.UpdateAddress("b", "10.0.0.3:5432"); err != ErrNotReaddressable {
+		t.Fatalf("Expected ErrNotReaddressable, instead got: %v", err)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	p := New(context.Background())
+
+	primary := &member{b: &mockend{id: "a"}, state: READ_WRITE}
+	follower := &member{b: &mockend{id: "b"}, state: READ_ONLY}
+	p.primary = primary
+	p.avail = []*member{follower, primary}
+
+	p.updateChecksum(primary, "abc")
+	for i := 0; i < divergenceThreshold; i++ {
+		p.updateChecksum(follower, "abd")
+	}
+
+	if it, err := p.GetForRead(); err != nil || it.(*mockend).id != "a" {
+		t.Fatalf("Expected a diverged follower not to be routed to, instead got: %v, %v", it, err)
+	}
+
+	p.updateChecksum(follower, "abc")
+	if it, err := p.GetForRead(); err != nil || it.(*mockend).id != "b" {
+		t.Fatalf("Expected the follower to be routed to again, instead got: %v, %v", it, err)
+	}
+}
+
+func TestLagThreshold(t *testing.T) {
+	p := New(context.Background(), WithLagThreshold(time.Second))
+
+	primary := &member{b: &mockend{id: "a"}, state: READ_WRITE}
+	follower := &member{b: &mockend{id: "b"}, state: READ_ONLY, lag: 2 * time.Second, lagKnown: true}
+	p.primary = primary
+	p.avail = []*member{follower, primary}
+
+	if it, err := p.GetForRead(); err != nil || it.(*mockend).id != "a" {
+		t.Fatalf("Expected a lagging follower not to be routed to, instead got: %v, %v", it, err)
+	}
+
+	follower.lag = 500 * time.Millisecond
+	if it, err := p.GetForClass("eventual"); err != nil || it.(*mockend).id != "b" {
+		t.Fatalf("Expected the follower to be routed to, instead got: %v, %v", it, err)
+	}
+}
+
+func TestLatencyBand(t *testing.T) {
+	p := New(context.Background(), WithLatencyBand(20, time.Millisecond))
+
+	a := &member{b: &mockend{id: "a"}, state: READ_ONLY, rtt: 10 * time.Millisecond}
+	b := &member{b: &mockend{id: "b"}, state: READ_ONLY, rtt: 11 * time.Millisecond}
+	c := &member{b: &mockend{id: "c"}, state: READ_ONLY, rtt: 20 * time.Millisecond}
+	p.avail = []*member{a, b, c}
+
+	seen := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		it, err := p.GetForRead()
+		if err != nil {
+			t.Fatalf("Expected a backend, instead got: %v", err)
+		}
+		seen[it.(*mockend).id]++
+	}
+
+	if seen["a"] != 5 || seen["b"] != 5 || seen["c"] != 0 {
+		t.Fatalf("Expected reads to be split between a and b, instead got %v", seen)
+	}
+
+	// Without a band, the closest takes them all.
+	p.bandPercent, p.bandWidth = 0, 0
+	for i := 0; i < 2; i++ {
+		if it, _ := p.GetForRead(); it.(*mockend).id != "a" {
+			t.Fatalf("Expected a to be routed to, instead got %v", it)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	p := New(context.Background())
+
+	a := &member{b: &mockend{id: "a"}, name: "a", state: READ_WRITE, rtt: time.Millisecond}
+	b := &member{b: &mockend{id: "b"}, name: "b", state: READ_ONLY, rtt: 2 * time.Millisecond}
+	c := &member{b: &mockend{id: "c"}, name: "c", state: READ_ONLY, rtt: 30 * time.Millisecond}
+	p.primary, p.avail = a, []*member{a, b, c}
+
+	p.DefineClass("rr", Class{FollowersOnly: true, Balance: BalanceRoundRobin})
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		it, err := p.GetForClass("rr")
+		if err != nil {
+			t.Fatalf("Expected a follower, instead got: %v", err)
+		}
+		seen[it.(*mockend).id]++
+	}
+	if seen["a"] != 0 || seen["b"] != 2 || seen["c"] != 2 {
+		t.Fatalf("Expected reads to be split between the followers, instead got %v", seen)
+	}
+
+	// Peeking at where the next caller goes doesn't take its turn.
+	ctx := context.Background()
+	next, err := p.Peek(ctx, "rr")
+	if again, _ := p.Peek(ctx, "rr"); err != nil || again != next {
+		t.Fatalf("Expected peeking twice to give the same follower, instead got %s and %s, %v", next, again, err)
+	}
+	if routed, _ := p.Route(ctx, "rr"); routed != next {
+		t.Fatalf("Expected to be routed to %s, as peeked, instead got %s", next, routed)
+	}
+	if after, _ := p.Peek(ctx, "rr"); after == next {
+		t.Fatalf("Expected routing to take %s's turn", next)
+	}
+
+	p.DefineClass("closest", Class{FollowersOnly: true, Balance: BalanceLeastLatency})
+	if it, err := p.GetForClass("closest"); err != nil || it != b.b {
+		t.Fatalf("Expected the closest follower, instead got: %v, %v", it, err)
+	}
+
+	b.leases = 2
+	p.DefineClass("least", Class{FollowersOnly: true, Balance: BalanceLeastConnections})
+	b.weight, c.weight = 1, 1
+	if it, err := p.GetForClass("least"); err != nil || it != c.b {
+		t.Fatalf("Expected the least loaded follower, instead got: %v, %v", it, err)
+	}
+	b.leases = 0
+
+	p.avail = []*member{a}
+	if _, err := p.Acquire(context.Background(), "rr"); !errors.Is(err, ErrNoneAvailable) {
+		t.Fatalf("Expected no follower to be available, instead got: %v", err)
+	}
+}
+
+func TestScorer(t *testing.T) {
+	// Prefer the backend with the fewest leases, never routing to c.
+	p := New(context.Background(), WithScorer(ScorerFunc(func(b BackendInfo) (float64, bool) {
+		return -float64(b.Leases), b.Name != "c"
+	})))
+
+	a := &member{b: &mockend{id: "a"}, name: "a", state: READ_ONLY, rtt: time.Millisecond, leases: 3}
+	b := &member{b: &mockend{id: "b"}, name: "b", state: READ_ONLY, rtt: 2 * time.Millisecond, leases: 1}
+	c := &member{b: &mockend{id: "c"}, name: "c", state: READ_ONLY, rtt: 3 * time.Millisecond}
+	p.avail = []*member{a, b, c}
+
+	if it, err := p.GetForRead(); err != nil || it != b.b {
+		t.Fatalf("Expected b to be routed to, instead got: %v, %v", it, err)
+	}
+
+	p.avail = []*member{c}
+	if it, err := p.GetForRead(); err != ErrNoneAvailable {
+		t.Fatalf("Expected no eligible backend, instead got: %v, %v", it, err)
+	}
+}
+
+func TestFailoverReport(t *testing.T) {
+	p := New(context.Background())
+
+	old := &member{b: &mockend{id: "a"}, name: "a"}
+	promoted := &member{b: &mockend{id: "b"}, name: "b", wal: walSample{ok: true, lsn: 900}}
+	p.lastPrimary, p.lastPrimaryWAL = old.name, walSample{ok: true, lsn: 1000}
+	p.walRate = 100
+
+	if r := p.failover(old); r != nil {
+		t.Fatalf("Expected no report when the primary returns, instead got %+v", r)
+	}
+
+	r := p.failover(promoted)
+	if r == nil || !r.Known || r.From != "a" || r.To != "b" || r.LossBytes != 100 || r.Loss != time.Second {
+		t.Fatalf("Expected a potential loss of 100 bytes, or 1s, instead got %+v", r)
+	}
+
+	if p.LastFailover() != r {
+		t.Fatalf("Expected the report to be kept, instead got %+v", p.LastFailover())
+	}
+
+	promoted.wal.ok = false
+	if r := p.failover(promoted); r == nil || r.Known {
+		t.Fatalf("Expected an unknown loss without the promoted backend's position, instead got %+v", r)
+	}
+}
+
+func TestPromotionCandidates(t *testing.T) {
+	p := New(context.Background())
+
+	follower := func(id string, lsn uint64, info PromotionInfo) *member {
+		return &member{b: &mockend{id: id}, name: id, state: READ_ONLY, wal: walSample{ok: true, lsn: lsn}, promotion: info}
+	}
+
+	primary := &member{b: &mockend{id: "p"}, state: READ_WRITE, promotion: PromotionInfo{Priority: 1, Zone: "a"}}
+	behind := follower("behind", 90, PromotionInfo{Priority: 3, Synchronous: true})
+	never := follower("never", 100, PromotionInfo{Priority: 0})
+	far := follower("far", 100, PromotionInfo{Priority: 2, Zone: "b"})
+	near := follower("near", 100, PromotionInfo{Priority: 2, Zone: "a"})
+	synced := follower("sync", 100, PromotionInfo{Priority: 1, Synchronous: true})
+	p.primary = primary
+	p.avail = []*member{primary, behind, never, far, near, synced}
+
+	var names []string
+	for _, c := range p.PromotionCandidates() {
+		names = append(names, c.Name)
+	}
+
+	if want := []string{"sync", "near", "far", "behind"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Expected the candidates to be ranked %v, instead got %v", want, names)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := New(ctx, WithManualChecks(time.Now))
+	primary := &promotermockend{mockend: mockend{id: "a", state: READ_WRITE}}
+	follower := &promotermockend{mockend: mockend{id: "b", state: READ_ONLY}}
+	p.PutNamed("a", primary)
+	p.PutNamed("b", follower)
+	p.PutNamed("c", &mockend{id: "c", state: READ_ONLY})
+	p.CheckAll()
+
+	for addr, want := range map[string]error{"d": ErrUnknownBackend, "c": ErrNotPromotable, "a": ErrNotFollower} {
+		if err := p.Promote(ctx, addr); err != want {
+			t.Errorf("Expected promoting %s to fail with %v, instead got %v", addr, want, err)
+		}
+	}
+
+	primary.set(UNAVAILABLE, errors.New("down"))
+	p.Check("a")
+	if err := p.Promote(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := p.GetForWrite(); err != nil || b != follower {
+		t.Fatalf("Expected writes to be routed to b once promoted, instead got %v, %v", b, err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	p := New(context.Background())
+	p.PutNamed("a", &mockend{id: "a"})
+	p.PutNamed("b", &mockend{id: "b"})
+	p.PutNamed("c", &mockend{id: "c"})
+
+	var names []string
+	p.ForEach(func(info BackendInfo) bool {
+		// The pool mustn't be locked while iterating.
+		p.DefineClass(info.Name, Eventual)
+
+		names = append(names, info.Name)
+		return info.Name != "b"
+	})
+
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("Expected to iterate over a and b, instead got %v", names)
+	}
+}
+
+func TestMarkUnavailable(t *testing.T) {
+	p := New(context.Background())
+
+	a := &mockend{id: "a"}
+	b := &mockend{id: "b"}
+	primary := &member{b: a, state: READ_WRITE}
+	follower := &member{b: b, state: READ_ONLY}
+	p.members = []*member{primary, follower}
+	p.primary = primary
+	p.avail = []*member{follower, primary}
+
+	p.MarkUnavailable(a)
+
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected no primary, instead got: %v", err)
+	}
+
+	if it, err := p.GetForRead(); err != nil || it != b {
+		t.Fatalf("Expected the follower to stay routable, instead got: %v, %v", it, err)
+	}
+
+	if primary.state != UNAVAILABLE {
+		t.Fatalf("Expected the primary to be UNAVAILABLE, instead got %s", primary.state)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	p := New(context.Background(), WithLeastConnections())
+
+	near := &member{b: &mockend{id: "a"}, state: READ_ONLY, rtt: time.Millisecond}
+	far := &member{b: &mockend{id: "b"}, state: READ_ONLY, rtt: 50 * time.Millisecond}
+	p.members = []*member{near, far}
+	p.avail = []*member{near, far}
+
+	first, err := p.Acquire(context.Background(), "eventual")
+	if err != nil || first.Backend() != near.b {
+		t.Fatalf("Expected to lease the closest backend, instead got: %v, %v", first, err)
+	}
+
+	second, err := p.Acquire(context.Background(), "eventual")
+	if err != nil || second.Backend() != far.b {
+		t.Fatalf("Expected to lease the least loaded backend, instead got: %v, %v", second, err)
+	}
+
+	first.Release(errors.New("broken pipe"))
+	first.Release(nil)
+	second.Release(nil)
+
+	info := p.Backends()
+	if info[0].Leases != 0 || info[0].LeaseErrors != 1 || info[1].Leases != 0 || info[1].LeaseErrors != 0 {
+		t.Fatalf("Expected the leases to be returned and the error counted, instead got: %+v", info)
+	}
+}
+
+// hangend hangs connecting until it's let go.
+type hangend struct {
+	mockend
+	dialing chan struct{}
+	letGo   chan struct{}
+}
+
+func (h *hangend) Connect(time.Duration) (*Conn, error) {
+	close(h.dialing)
+	<-h.letGo
+	return nil, nil
+}
+
+func TestDialUnlocked(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now))
+	b := &hangend{mockend: mockend{id: "a", state: READ_WRITE}, dialing: make(chan struct{}), letGo: make(chan struct{})}
+	p.Put(b)
+	p.CheckAll()
+
+	leased := make(chan error)
+	go func() {
+		lease, err := p.Acquire(context.Background(), "strong")
+		if err == nil {
+			lease.Release(nil)
+		}
+		leased <- err
+	}()
+	<-b.dialing
+
+	// Routing and health checks go on while the dial hangs.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Check("a")
+		p.GetForWrite()
+		p.Backends()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected routing and checks not to wait on a dial in progress")
+	}
+
+	close(b.letGo)
+	if err := <-leased; err != nil {
+		t.Errorf("Expected the dial to complete once let go; instead got %v", err)
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	p := New(context.Background())
+
+	primary := &member{b: &mockend{id: "a"}, name: "a", state: READ_WRITE, rtt: 5 * time.Millisecond, wal: walSample{ok: true, lsn: 100}}
+	near := &member{b: &mockend{id: "b"}, name: "b", state: READ_ONLY, rtt: time.Millisecond, wal: walSample{ok: true, lsn: 50}}
+	far := &member{b: &mockend{id: "c"}, name: "c", state: READ_ONLY, rtt: 2 * time.Millisecond, wal: walSample{ok: true, lsn: 90}}
+	p.members = []*member{primary, near, far}
+	p.avail = []*member{near, far, primary}
+	p.primary = primary
+
+	for _, test := range []struct {
+		lsn  uint64
+		want string
+	}{
+		{0, "b"},
+		{50, "b"},
+		{80, "c"},
+		// Writes no follower has replayed are read from the primary.
+		{95, "a"},
+	} {
+		if got, err := p.Route(AtLeast(context.Background(), test.lsn), "eventual"); err != nil || got != test.want {
+			t.Errorf("At least %d: expected to be routed to %s; instead got %s, %v", test.lsn, test.want, got, err)
+		}
+	}
+
+	if b, err := p.GetForClass("eventual"); err != nil || b != near.b {
+		t.Errorf("Expected routing without an LSN to be unchanged; instead got %v, %v", b, err)
+	}
+
+	// Classes that keep reads off the primary don't fall back to it.
+	p.DefineClass("follower", Class{FollowersOnly: true})
+	if got, err := p.Route(AtLeast(context.Background(), 80), "follower"); err != nil || got != "c" {
+		t.Errorf("Expected to be routed to c; instead got %s, %v", got, err)
+	}
+	_, err := p.Route(AtLeast(context.Background(), 95), "follower")
+	if de, ok := err.(*DegradedError); !ok || de.Mode != NO_REPLICAS {
+		t.Errorf("Expected no follower to have replayed the writes; instead got %v", err)
+	}
+
+	for s, want := range map[string]uint64{"0/0": 0, "16/B374D848": 0x16B374D848} {
+		if lsn, err := ParseLSN(s); err != nil || lsn != want || FormatLSN(lsn) != s {
+			t.Errorf("Expected %s to parse as %X and back; instead got %X, %v", s, want, lsn, err)
+		}
+	}
+}
+
+func TestDialClassRetry(t *testing.T) {
+	p := New(context.Background())
+
+	near := &member{b: &deadmockend{mockend{id: "a"}}, name: "a", state: READ_ONLY, rtt: time.Millisecond}
+	far := &member{b: &mockend{id: "b"}, name: "b", state: READ_ONLY, rtt: 50 * time.Millisecond}
+	p.members = []*member{near, far}
+	p.avail = []*member{near, far}
+
+	if _, err := p.DialClass(context.Background(), "eventual"); err != nil {
+		t.Fatalf("Expected to connect to the backend that can be connected to, instead got: %v", err)
+	}
+	if near.state != UNAVAILABLE || far.state != READ_ONLY {
+		t.Fatalf("Expected only the backend that can't be connected to to be unavailable, instead got %s and %s", near, far)
+	}
+
+	// With none left, the last error is returned rather than ErrNoneAvailable.
+	far.b = &deadmockend{mockend{id: "b"}}
+	if _, err := p.DialClass(context.Background(), "eventual"); err == nil || err == ErrNoneAvailable {
+		t.Fatalf("Expected the error connecting to b, instead got %v", err)
+	}
+	if _, err := p.DialClass(context.Background(), "eventual"); err != ErrNoneAvailable {
+		t.Fatalf("Expected no backend to be available, instead got %v", err)
+	}
+}
+
+func TestInZone(t *testing.T) {
+	p := New(context.Background())
+
+	primary := &member{b: &mockend{id: "p"}, state: READ_WRITE, rtt: time.Millisecond, promotion: PromotionInfo{Zone: "us"}}
+	near := &member{b: &mockend{id: "a"}, state: READ_ONLY, rtt: 2 * time.Millisecond, promotion: PromotionInfo{Zone: "us"}}
+	far := &member{b: &mockend{id: "b"}, state: READ_ONLY, rtt: 80 * time.Millisecond, promotion: PromotionInfo{Zone: "eu"}}
+	p.primary, p.members = primary, []*member{primary, near, far}
+	p.avail = []*member{primary, near, far}
+
+	acquire := func(ctx context.Context, class string) Backend {
+		lease, err := p.Acquire(ctx, class)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lease.Release(nil)
+		return lease.Backend()
+	}
+
+	eu := InZone(context.Background(), "eu")
+	if b := acquire(eu, "eventual"); b != far.b {
+		t.Errorf("Expected the backend in the client's zone; instead got %v", b)
+	}
+	if b := acquire(eu, "strong"); b != primary.b {
+		t.Errorf("Expected the primary regardless of zone; instead got %v", b)
+	}
+	if b := acquire(InZone(context.Background(), "ap"), "eventual"); b != primary.b {
+		t.Errorf("Expected the closest backend with none in the client's zone; instead got %v", b)
+	}
+
+	// With the follower in the client's zone lagging, the others are routed to.
+	p.DefineClass("fresh", Class{FollowersOnly: true, MaxLag: time.Second})
+	near.lagKnown, far.lagKnown, far.lag = true, true, time.Minute
+	if b := acquire(eu, "fresh"); b != near.b {
+		t.Errorf("Expected a follower outside the client's zone; instead got %v", b)
+	}
+}
+
+func TestStickiness(t *testing.T) {
+	now := time.Now()
+	p := New(context.Background(), WithManualChecks(func() time.Time { return now }), WithStickiness(time.Minute))
+	p.DefineClass("spread", Class{Balance: BalanceRoundRobin})
+
+	primary := &member{name: "p", b: &mockend{id: "p"}, state: READ_WRITE, weight: 1}
+	a := &member{name: "a", b: &mockend{id: "a"}, state: READ_ONLY, weight: 1}
+	b := &member{name: "b", b: &mockend{id: "b"}, state: READ_ONLY, weight: 1}
+	p.primary, p.members = primary, []*member{primary, a, b}
+	p.avail = []*member{primary, a, b}
+
+	acquire := func(ctx context.Context, class string) Backend {
+		lease, err := p.Acquire(ctx, class)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lease.Release(nil)
+		return lease.Backend()
+	}
+
+	client := StickTo(context.Background(), "10.0.0.1")
+	var stuck Backend
+	for stuck == nil || stuck == primary.b {
+		stuck = acquire(client, "spread")
+	}
+	for i := 0; i < 4; i++ {
+		acquire(context.Background(), "spread")
+		if got := acquire(client, "spread"); got != stuck {
+			t.Fatalf("Expected the client to stick to %v; instead got %v", stuck, got)
+		}
+	}
+	if got := acquire(client, "strong"); got != primary.b {
+		t.Errorf("Expected the primary regardless of stickiness; instead got %v", got)
+	}
+
+	// Once the follower can't serve the client, it moves on, and sticks to the next.
+	drained, other := a, b
+	if stuck == b.b {
+		drained, other = b, a
+	}
+	drained.draining = true
+	var moved Backend
+	for moved == nil || moved == primary.b {
+		moved = acquire(client, "spread")
+	}
+	if moved != other.b {
+		t.Fatalf("Expected the client to move to %v; instead got %v", other.b, moved)
+	}
+	drained.draining = false
+	for i := 0; i < 4; i++ {
+		acquire(context.Background(), "spread")
+		if got := acquire(client, "spread"); got != other.b {
+			t.Fatalf("Expected the client to stick to %v; instead got %v", other.b, got)
+		}
+	}
+	if name, err := p.Route(client, "spread"); err != nil || name != other.name {
+		t.Errorf("Expected the client to be routed to %s; instead got %q, %v", other.name, name, err)
+	}
+
+	// Until the TTL passes.
+	now = now.Add(2 * time.Minute)
+	if m := p.stuck(Class{Balance: BalanceRoundRobin}, p.stickyKey(client, Class{}, "spread")); m != nil {
+		t.Errorf("Expected the client not to stick past the TTL; instead got %v", m.b)
+	}
+}
+
+func TestAcquireRetry(t *testing.T) {
+	sink := metrics.NewMemory()
+	p := New(context.Background(), WithMetrics(sink))
+
+	near := &member{b: &deadmockend{mockend{id: "a"}}, name: "a", state: READ_ONLY, rtt: time.Millisecond}
+	far := &member{b: &mockend{id: "b"}, name: "b", state: READ_ONLY, rtt: 50 * time.Millisecond}
+	p.members = []*member{near, far}
+	p.avail = []*member{near, far}
+
+	lease, err := p.Acquire(context.Background(), "eventual")
+	if err != nil || lease.Backend() != far.b {
+		t.Fatalf("Expected to lease the backend that can be connected to, instead got: %v, %v", lease, err)
+	}
+	lease.Release(nil)
+
+	if near.state != UNAVAILABLE || near.leases != 0 {
+		t.Fatalf("Expected the backend that can't be connected to to be unavailable, instead got %s", near)
+	}
+	if v, _ := sink.Get("arbiter_backend_dial_errors_total", metrics.Labels{"backend": "a"}); v != 1 {
+		t.Errorf("Expected a dial error to be counted; instead got %g", v)
+	}
+
+	// With none left, the caller is told the pool is degraded.
+	far.b = &deadmockend{mockend{id: "b"}}
+	_, err = p.Acquire(context.Background(), "eventual")
+	if de, ok := err.(*DegradedError); !ok || de.Mode != NO_REPLICAS {
+		t.Fatalf("Expected a NO_REPLICAS error, instead got: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	p := New(context.Background())
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil {
+		t.Fatalf("Expected to lease the primary, instead got error: %v", err)
+	}
+	defer lease.Release(nil)
+
+	if err := p.Drain("pg1"); err != nil {
+		t.Fatalf("Expected to drain the backend, instead got error: %v", err)
+	}
+
+	select {
+	case <-lease.Drained():
+	default:
+		t.Fatalf("Expected the lease to be notified of the drain")
+	}
+
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected a draining backend not to be routed to, instead got: %v", err)
+	}
+
+	if err := p.Resume("pg1"); err != nil {
+		t.Fatalf("Expected to resume the backend, instead got error: %v", err)
+	}
+
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected the backend to be routed to again, instead got: %v, %v", it, err)
+	}
+
+	if err := p.Drain("foo2"); err != ErrUnknownBackend {
+		t.Fatalf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
+func TestCheckBackoff(t *testing.T) {
+	p := New(context.Background(), WithCheckInterval(time.Second), WithCheckBackoff(10*time.Second))
+	m := &member{b: &mockend{id: "a"}, state: READ_ONLY}
+
+	for _, c := range []struct {
+		state  State
+		failed int
+		next   time.Duration
+	}{
+		{READ_ONLY, 0, time.Second},
+		// Failures don't back off before the backend is down.
+		{READ_ONLY, 2, time.Second},
+		{UNAVAILABLE, 1, time.Second},
+		{UNAVAILABLE, 2, 2 * time.Second},
+		{UNAVAILABLE, 4, 8 * time.Second},
+		{UNAVAILABLE, 20, 10 * time.Second},
+	} {
+		m.state, m.failStreak = c.state, c.failed
+		if next := p.nextCheck(m); next != c.next {
+			t.Errorf("%s after %d failures: expected the next check in %s; instead got %s", c.state, c.failed, c.next, next)
+		}
+	}
+}
+
+func TestCordon(t *testing.T) {
+	p := New(context.Background())
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil {
+		t.Fatalf("Expected to lease the primary, instead got error: %v", err)
+	}
+	defer lease.Release(nil)
+
+	if err := p.Cordon("pg1", 0); err != nil {
+		t.Fatalf("Expected to cordon the backend, instead got error: %v", err)
+	}
+	if _, err := p.Acquire(context.Background(), "strong"); err == nil {
+		t.Fatalf("Expected a cordoned backend not to be routed to")
+	}
+	select {
+	case <-lease.Drained():
+		t.Fatalf("Expected the sessions of a cordoned backend to be left alone")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := p.Resume("pg1"); err != nil {
+		t.Fatalf("Expected to uncordon the backend, instead got error: %v", err)
+	}
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected the backend to be routed to again, instead got: %v, %v", it, err)
+	}
+
+	// With a grace, the sessions are drained once it runs out.
+	if err := p.Cordon("pg1", 50*time.Millisecond); err != nil {
+		t.Fatalf("Expected to cordon the backend, instead got error: %v", err)
+	}
+	select {
+	case <-lease.Drained():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the lease to be drained at the end of the grace")
+	}
+
+	var info BackendInfo
+	p.ForEach(func(b BackendInfo) bool { info = b; return true })
+	if !info.Cordoned || !info.Draining || info.Routable() {
+		t.Errorf("Expected the backend to be cordoned and draining; instead got %+v", info)
+	}
+
+	if err := p.Cordon("foo2", 0); err != ErrUnknownBackend {
+		t.Fatalf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
+func TestFailoverJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx, WithFailoverJournal(path))
+	p.Lock()
+	p.lastPrimary, p.lastPrimaryWAL, p.walRate = "a", walSample{ok: true, lsn: 1000}, 100
+	p.journalState()
+	p.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the journal to be written, instead got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	// A restarted pool must detect the failover that happened meanwhile.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	p = New(ctx, WithFailoverJournal(path))
+	promoted := &member{b: &mockend{id: "b"}, name: "b", wal: walSample{ok: true, lsn: 900}}
+	p.Lock()
+	r := p.failover(promoted)
+	p.Unlock()
+	if r == nil || !r.Known || r.From != "a" || r.LossBytes != 100 || r.Loss != time.Second {
+		t.Fatalf("Expected a failover from a with a loss of 100 bytes, instead got %+v", r)
+	}
+
+	// Wait for the report to be journaled, so that no write is left behind.
+	for {
+		if b, _ := os.ReadFile(path); strings.Contains(string(b), "last_failover") {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the failover report to be journaled, instead got %s", b)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// slowend takes a while to answer its checks, and counts them, and those of all
+// slowends sharing checks in progress.
+type slowend struct {
+	mockend
+	checks   *checkCounter
+	nchecked atomic.Int64
+}
+
+type checkCounter struct {
+	mu      sync.Mutex
+	running int
+	most    int
+}
+
+func (s *slowend) Ping() (State, error) {
+	s.checks.mu.Lock()
+	s.checks.running++
+	if s.checks.running > s.checks.most {
+		s.checks.most = s.checks.running
+	}
+	s.checks.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+	s.nchecked.Add(1)
+
+	s.checks.mu.Lock()
+	s.checks.running--
+	s.checks.mu.Unlock()
+	return s.mockend.Ping()
+}
+
+func TestCheckConcurrency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := New(ctx, WithCheckInterval(10*time.Millisecond), WithCheckConcurrency(2))
+
+	checks := &checkCounter{}
+	var backends []*slowend
+	for i := 0; i < 6; i++ {
+		b := &slowend{mockend: mockend{id: fmt.Sprintf("pg%d", i), state: READ_ONLY}, checks: checks}
+		backends = append(backends, b)
+		p.Put(b)
+	}
+	if err := p.WaitForChecks(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The checks of a removed backend stop, while the others' go on.
+	p.Remove("pg0")
+	removed := backends[0].nchecked.Load()
+	before := backends[1].nchecked.Load()
+	time.Sleep(100 * time.Millisecond)
+	if n := backends[0].nchecked.Load(); n != removed {
+		t.Errorf("Expected a removed backend not to be checked; instead it was checked %d more times", n-removed)
+	}
+	if n := backends[1].nchecked.Load(); n <= before {
+		t.Errorf("Expected the other backends to be checked still")
+	}
+
+	checks.mu.Lock()
+	defer checks.mu.Unlock()
+	if checks.most > 2 {
+		t.Errorf("Expected at most 2 checks at once; instead got %d", checks.most)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	sink := metrics.NewMemory()
+	p := New(context.Background(), WithMetrics(sink))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil {
+		t.Fatalf("Expected to lease the primary, instead got error: %v", err)
+	}
+
+	if err := p.Remove("pg1"); err != nil {
+		t.Fatalf("Expected to remove the backend, instead got error: %v", err)
+	}
+
+	select {
+	case <-lease.Drained():
+	default:
+		t.Fatalf("Expected the lease to be notified of the removal")
+	}
+	lease.Release(nil)
+
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected a removed backend not to be routed to, instead got: %v", err)
+	}
+
+	if bs := p.Backends(); len(bs) != 0 {
+		t.Fatalf("Expected no backends, instead got %v", bs)
+	}
+
+	if !a.closed {
+		t.Errorf("Expected the monitor of the backend to be closed")
+	}
+	if _, ok := sink.Get("arbiter_backend_rtt_seconds", metrics.Labels{"backend": "pg1"}); ok {
+		t.Errorf("Expected the series of the backend to be deleted")
+	}
+
+	if err := p.Remove("pg1"); err != ErrUnknownBackend {
+		t.Fatalf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
+func TestDegradedError(t *testing.T) {
+	p := New(context.Background())
+	p.avail = []*member{{b: &mockend{id: "a"}, state: READ_ONLY}}
+
+	_, err := p.Acquire(context.Background(), "strong")
+	if de, ok := err.(*DegradedError); !ok || de.Mode != NO_PRIMARY || !errors.Is(err, ErrNoneAvailable) {
+		t.Fatalf("Expected a NO_PRIMARY error, instead got: %v", err)
+	}
+
+	p.avail = nil
+	_, err = p.Acquire(context.Background(), "eventual")
+	if de, ok := err.(*DegradedError); !ok || de.Mode != NO_REPLICAS {
+		t.Fatalf("Expected a NO_REPLICAS error, instead got: %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	p := New(context.Background())
+	events := p.Subscribe()
+
+	p.Put(&mockend{state: READ_WRITE, id: "a"})
+
+	select {
+	case e := <-events:
+		if e.Addr != "a" || e.From != UNAVAILABLE || e.To != READ_WRITE {
+			t.Fatalf("Expected a transition from UNAVAILABLE to READ_WRITE, instead got %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected to receive an event")
+	}
+
+	got := make(chan Event, 1)
+	stop := p.SubscribeFunc(func(e Event) { got <- e })
+	p.Put(&mockend{state: READ_ONLY, id: "b"})
+	select {
+	case e := <-got:
+		if e.Addr != "b" || e.To != READ_ONLY {
+			t.Fatalf("Expected a transition of b to READ_ONLY, instead got %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the callback to be called")
+	}
+	stop()
+}
+
+func TestExpect(t *testing.T) {
+	p := New(context.Background())
+	events := p.Subscribe()
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+	if err := p.Expect("pg1", Expectation{Role: READ_ONLY, Enforce: true}); err != nil {
+		t.Fatalf("Expected to assert the role of pg1, instead got error: %v", err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for violation := ""; violation == ""; {
+		select {
+		case e := <-events:
+			violation = e.Violation
+		case <-deadline:
+			t.Fatalf("Expected a violation event")
+		}
+	}
+
+	if b := p.Backends()[0]; !b.RoleViolation || b.State != READ_WRITE {
+		t.Fatalf("Expected pg1 to be a primary in violation, instead got %+v", b)
+	}
+
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Fatalf("Expected no writes to be routed to pg1, instead got: %v", err)
+	}
+
+	p.Expect("pg1", Expectation{Role: READ_ONLY})
+	if b, err := p.GetForWrite(); err != nil || b != a {
+		t.Fatalf("Expected writes to be routed to pg1 once not enforced, instead got: %v, %v", b, err)
+	}
+
+	if err := p.Expect("foo2", Expectation{}); err != ErrUnknownBackend {
+		t.Fatalf("Expected ErrUnknownBackend, instead got: %v", err)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	p := New(context.Background())
+	events := p.Subscribe()
+
+	a := &nodemockend{mockend{state: READ_ONLY, id: "10.0.0.1:5432"}, "node1"}
+	if err := p.PutNamed("pg1", a); err != nil {
+		t.Fatalf("Expected to register pg1, instead got error: %v", err)
+	}
+
+	if err := p.PutNamed("pg1", &mockend{id: "10.0.0.2:5432"}); err != ErrDuplicateBackend {
+		t.Fatalf("Expected a name registered twice to be rejected, instead got: %v", err)
+	}
+	if err := p.Put(&mockend{id: "10.0.0.1:5432"}); err != ErrDuplicateBackend {
+		t.Fatalf("Expected an address registered twice to be rejected, instead got: %v", err)
+	}
+
+	// The same server under another address.
+	b := &nodemockend{mockend{state: READ_ONLY, id: "pg1.example.com:5432"}, "node1"}
+	if err := p.PutNamed("pg1-dns", b); err != nil {
+		t.Fatalf("Expected to register pg1-dns, instead got error: %v", err)
+	}
+
+	deadline := time.After(3 * time.Second)
+	for of := ""; of == ""; {
+		select {
+		case e := <-events:
+			if of = e.DuplicateOf; of != "" && (of != "pg1" || e.Name != "pg1-dns") {
+				t.Fatalf("Expected pg1-dns to be reported as a duplicate of pg1, instead got %+v", e)
+			}
+		case <-deadline:
+			t.Fatalf("Expected a duplicate event")
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if it, err := p.GetForRead(); err != nil || it != a {
+			t.Fatalf("Expected reads to be routed to pg1 alone, instead got: %v, %v", it, err)
+		}
+	}
+}
+
+// gatedmockend connects once gate is closed.
+type gatedmockend struct {
+	rttmockend
+	gate chan struct{}
+}
+
+func (m *gatedmockend) Connect(t time.Duration) (*Conn, error) {
+	<-m.gate
+	return nil, nil
+}
+
+func TestLimitDials(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithMaxDials(1))
+	p.DefineClass("closest", Class{FollowersOnly: true, Balance: BalanceLeastLatency})
+
+	gate := make(chan struct{})
+	a := &gatedmockend{rttmockend{mockend{state: READ_ONLY, id: "a"}, time.Millisecond}, gate}
+	b := &gatedmockend{rttmockend{mockend{state: READ_ONLY, id: "b"}, 2 * time.Millisecond}, gate}
+	for _, m := range []*gatedmockend{a, b} {
+		p.Put(m)
+		p.Check(m.id)
+	}
+	if err := p.LimitDials("c", 1); err != ErrUnknownBackend {
+		t.Errorf("Expected an unknown backend; instead got %v", err)
+	}
+
+	leases := make(chan *Lease, 2)
+	acquire := func() {
+		l, err := p.Acquire(context.Background(), "closest")
+		if err != nil {
+			t.Error(err)
+		}
+		leases <- l
+	}
+
+	// The first dial goes to a, the closest; the second finds a busy and goes to b.
+	go acquire()
+	for atomic.LoadInt64(&p.members[0].leases) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go acquire()
+	for atomic.LoadInt64(&p.members[1].leases) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With both busy, the third waits for as long as it would to connect.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx, "closest"); err != ErrDialsExhausted {
+		t.Errorf("Expected the dials to be exhausted; instead got %v", err)
+	}
+
+	close(gate)
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		l := <-leases
+		seen[l.Name()] = true
+		l.Release(nil)
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("Expected a and b to be dialed; instead got %v", seen)
+	}
+
+	// The dials were given back.
+	if l, err := p.Acquire(context.Background(), "closest"); err != nil || l.Name() != "a" {
+		t.Errorf("Expected to dial a again; instead got %v, %v", l, err)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	now := time.Now()
+	p := New(context.Background(), WithManualChecks(func() time.Time { return now }))
+
+	a, b := &mockend{id: "a", state: READ_ONLY}, &mockend{id: "b", state: READ_ONLY}
+	p.Put(a)
+	p.Put(b)
+	p.SlowStart("b", 10*time.Second)
+	p.Check("a")
+	p.Check("b")
+
+	share := func() float64 {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if it, _ := p.GetForRead(); it == b {
+				n++
+			}
+		}
+		return float64(n) / 1000
+	}
+
+	if s := share(); s != 0 {
+		t.Errorf("Expected b to take no reads as it becomes available; instead it took %.2f", s)
+	}
+
+	now = now.Add(5 * time.Second)
+	if s := share(); s < 0.2 || s > 0.4 {
+		t.Errorf("Expected b to take about a third of the reads halfway through; instead it took %.2f", s)
+	}
+
+	now = now.Add(5 * time.Second)
+	if s := share(); s != 0.5 {
+		t.Errorf("Expected b to take half of the reads once warm; instead it took %.2f", s)
+	}
+
+	// Callers aren't held back when there's nothing else to route them to.
+	a.set(UNAVAILABLE, errors.New("down"))
+	b.set(UNAVAILABLE, errors.New("down"))
+	p.Check("b")
+	b.set(READ_ONLY, nil)
+	p.Check("a")
+	p.Check("b")
+	if it, err := p.GetForRead(); it != b {
+		t.Errorf("Expected b to be routed to while it's the only backend; instead got %v, %v", it, err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	now := time.Now()
+	p := New(context.Background(), WithManualChecks(func() time.Time { return now }), WithRebalance(50, 0))
+
+	a := &mockend{id: "a", state: READ_ONLY}
+	p.Put(a)
+	p.Check("a")
+
+	var leases []*Lease
+	for i := 0; i < 4; i++ {
+		lease, err := p.Acquire(context.Background(), "eventual")
+		if err != nil {
+			t.Fatalf("Expected to lease a, instead got error: %v", err)
+		}
+		defer lease.Release(nil)
+		leases = append(leases, lease)
+		now = now.Add(time.Second)
+	}
+
+	b := &mockend{id: "b", state: READ_ONLY}
+	p.Put(b)
+	p.SlowStart("b", 10*time.Second)
+	p.Check("b")
+
+	handedOff := func() (n int) {
+		for _, l := range leases {
+			select {
+			case <-l.Drained():
+				n++
+			default:
+			}
+		}
+		return n
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if n := handedOff(); n != 0 {
+		t.Fatalf("Expected no session to be rebalanced while b is slow starting; instead %d were", n)
+	}
+
+	now = now.Add(10 * time.Second)
+	p.Check("b")
+	p.Check("b")
+	time.Sleep(10 * time.Millisecond)
+	if n := handedOff(); n != 2 {
+		t.Fatalf("Expected half of the sessions of a to be rebalanced once; instead %d were", n)
+	}
+	for _, l := range leases[:2] {
+		select {
+		case <-l.Drained():
+		default:
+			t.Errorf("Expected the oldest sessions to be rebalanced first")
+		}
+	}
+}
+
+func TestLimitConnections(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithMaxConnections(2))
+
+	a := &mockend{id: "a", state: READ_WRITE}
+	b := &mockend{id: "b", state: READ_ONLY}
+	p.Put(a)
+	p.Put(b)
+	p.Check("a")
+	p.Check("b")
+	if err := p.LimitConnections("a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil {
+		t.Fatalf("Expected to lease a, instead got error: %v", err)
+	}
+	defer lease.Release(nil)
+
+	var derr *DegradedError
+	if _, err := p.Acquire(context.Background(), "strong"); !errors.As(err, &derr) || derr.Mode != SATURATED {
+		t.Fatalf("Expected the primary at its cap to be saturated; instead got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		lease, err := p.Acquire(context.Background(), "eventual")
+		if err != nil {
+			t.Fatalf("Expected to lease b, instead got error: %v", err)
+		}
+		defer lease.Release(nil)
+		if lease.Backend() != b {
+			t.Fatalf("Expected the follower to be leased, with the primary at its cap")
+		}
+	}
+	if _, err := p.Acquire(context.Background(), "eventual"); !errors.As(err, &derr) || derr.Mode != SATURATED {
+		t.Fatalf("Expected every backend at its cap to be saturated; instead got %v", err)
+	}
+
+	p.LimitConnections("b", -1)
+	lease, err = p.Acquire(context.Background(), "eventual")
+	if err != nil {
+		t.Fatalf("Expected the cap of b to be lifted, instead got error: %v", err)
+	}
+	lease.Release(nil)
+
+	if info := p.Backends(); info[0].Leases != 1 || info[0].MaxConnections != 1 {
+		t.Errorf("Expected a to have 1 lease of at most 1; instead %+v", info[0])
+	}
+}
+
+func TestWeights(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithMaxConnections(1000))
+
+	a := &mockend{id: "a", state: READ_ONLY}
+	b := &mockend{id: "b", state: READ_ONLY}
+	c := &mockend{id: "c", state: READ_ONLY}
+	for _, m := range []*mockend{a, b, c} {
+		p.Put(m)
+		p.Check(m.id)
+	}
+	p.SetWeight("a", 3)
+	p.SetWeight("c", 0)
+	if err := p.SetWeight("b", -1); err != ErrInvalidWeight {
+		t.Fatalf("Expected a negative weight to be rejected; instead got %v", err)
+	}
+
+	p.DefineClass("rr", Class{Balance: BalanceRoundRobin})
+	if b, err := p.GetForClass("eventual"); err != nil || b == c {
+		t.Fatalf("Expected a backend of weight 0 not to be routed to; instead got %v, %v", b, err)
+	}
+
+	seen := make(map[Backend]int)
+	for i := 0; i < 4000; i++ {
+		b, err := p.GetForClass("rr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[b]++
+	}
+	if seen[c] != 0 || seen[a] < 2*seen[b] || seen[a] > 4*seen[b] {
+		t.Fatalf("Expected a to take three times the callers of b, and c none; instead got a=%d b=%d c=%d",
+			seen[a], seen[b], seen[c])
+	}
+
+	p.LimitConnections("a", 1)
+	p.LimitConnections("b", 1)
+	var leased []Backend
+	for i := 0; i < 3; i++ {
+		lease, err := p.Acquire(context.Background(), "eventual")
+		if err != nil {
+			t.Fatalf("Expected to lease a backend, instead got error: %v", err)
+		}
+		defer lease.Release(nil)
+		leased = append(leased, lease.Backend())
+	}
+	if leased[2] != c {
+		t.Errorf("Expected c to be leased once the others are saturated; instead got %v", leased)
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	p := New(context.Background(), WithAnomalyDetector(NewEWMADetector(0.1, 6, 10)))
+	events := p.Subscribe()
+
+	m := &member{b: &mockend{id: "a"}, name: "a", state: READ_ONLY, lagKnown: true}
+	for i := 0; i < 20; i++ {
+		m.lat = time.Duration(10+i%3) * time.Millisecond
+		p.detectAnomalies(m)
+	}
+	if a := m.anomalous(); len(a) != 0 {
+		t.Fatalf("Expected no anomaly around the baseline; instead got %v", a)
+	}
+
+	m.lat = 100 * time.Millisecond
+	p.detectAnomalies(m)
+	if a := m.anomalous(); len(a) != 1 || a[0] != SeriesLatency {
+		t.Fatalf("Expected latency to be anomalous; instead got %v", a)
+	}
+
+	select {
+	case e := <-events:
+		if e.Anomaly == nil || e.Anomaly.Series != SeriesLatency || e.Anomaly.Deviation < 6 || e.To != READ_ONLY {
+			t.Errorf("Expected an anomaly event for latency; instead got %+v", e)
+		}
+	default:
+		t.Errorf("Expected an anomaly event")
+	}
+
+	m.lat = 11 * time.Millisecond
+	p.detectAnomalies(m)
+	if a := m.anomalous(); len(a) != 0 {
+		t.Errorf("Expected latency to be back to normal; instead got %v", a)
+	}
+}
+
+func TestCheckHistory(t *testing.T) {
+	sink := metrics.NewMemory()
+	p := New(context.Background(), WithMetrics(sink), WithCheckHistory(4, time.Minute, time.Hour))
+	m := &member{b: &mockend{id: "a"}, name: "a"}
+
+	now := time.Now()
+	p.recordCheck(m, now.Add(-30*time.Minute), 50*time.Millisecond, true)
+	for i, lat := range []time.Duration{10, 20, 30} {
+		p.recordCheck(m, now.Add(time.Duration(i-2)*time.Second), lat*time.Millisecond, i != 1)
+	}
+	windows := p.checkWindows(m)
+	if len(windows) != 2 {
+		t.Fatalf("Expected a summary for each window; instead got %+v", windows)
+	}
+	if w := windows[0]; w.Checks != 3 || w.Uptime != 2.0/3 || w.P50 != 10*time.Millisecond || w.P99 != 30*time.Millisecond {
+		t.Errorf("Expected the last 3 checks to be summarized over the minute; instead got %+v", w)
+	}
+	if w := windows[1]; w.Checks != 4 || w.Uptime != 0.75 || w.P99 != 50*time.Millisecond {
+		t.Errorf("Expected all 4 checks to be summarized over the hour; instead got %+v", w)
+	}
+
+	// The oldest check is overwritten once the history is full.
+	p.recordCheck(m, now.Add(time.Second), 40*time.Millisecond, true)
+	if w := p.checkWindows(m)[1]; w.Checks != 4 || w.Uptime != 0.75 || w.P99 != 40*time.Millisecond {
+		t.Errorf("Expected the oldest check to be forgotten; instead got %+v", w)
+	}
+
+	p.reportCheckWindows(m, metrics.Labels{"backend": "a"})
+	if v, _ := sink.Get("arbiter_backend_uptime_ratio", metrics.Labels{"backend": "a", "window": "1h"}); v != 0.75 {
+		t.Errorf("Expected the uptime over the hour to be exported; instead got %v", v)
+	}
+	if v, _ := sink.Get("arbiter_backend_latency_quantile_seconds",
+		metrics.Labels{"backend": "a", "window": "1m", "quantile": "0.95"}); v != 0.04 {
+		t.Errorf("Expected the 95th percentile over the minute to be exported; instead got %v", v)
+	}
+
+	for d, want := range map[time.Duration]string{time.Hour: "1h", 5 * time.Minute: "5m", 90 * time.Second: "1m30s", 36 * time.Hour: "36h"} {
+		if got := FormatWindow(d); got != want {
+			t.Errorf("Expected %s to be formatted as %s; instead got %s", d, want, got)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx)
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.Put(a)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	cancel()
+	p.Wait()
+
+	if !a.closed {
+		t.Fatalf("Expected the backend to have been closed")
+	}
+
+	if it, err := p.GetForWrite(); err != nil || it != a {
+		t.Fatalf("Expected the backend to keep its last known state, instead got: %v, %v", it, err)
+	}
+}
+
+// hangingend is a primary whose pings hang until abandoned.
+type hangingend struct {
+	mockend
+}
+
+func (h *hangingend) PingContext(ctx context.Context) (State, error) {
+	<-ctx.Done()
+	return UNAVAILABLE, ctx.Err()
+}
+
+func TestCheckTimeout(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithCheckTimeout(10*time.Millisecond),
+		WithCheckJitter(time.Second))
+
+	a := &hangingend{mockend{state: READ_WRITE, id: "a"}}
+	p.Put(a)
+	if err := p.Check("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Errorf("Expected the hanging check to time out; instead got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := p.nextCheck(&member{b: a}); d < time.Second || d >= 2*time.Second {
+			t.Fatalf("Expected the next check within a second of the interval; instead got %s", d)
+		}
+	}
+
+	// A backend's own timeout applies even if the pool has none.
+	p = New(context.Background(), WithManualChecks(time.Now))
+	p.Put(&timedend{hangingend{mockend{state: READ_WRITE, id: "b"}}, 10 * time.Millisecond})
+	if err := p.Check("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Errorf("Expected the hanging check to time out; instead got %v", err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	p := New(context.Background(), WithManualChecks(time.Now), WithTracer(tracer))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.PutNamed("pg1", a)
+	if err := p.Check("pg1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, accept := tracer.Start(context.Background(), "arbiter.accept")
+	if _, err := p.DialClass(ctx, "strong"); err != nil {
+		t.Fatal(err)
+	}
+	accept.End()
+
+	a.set(UNAVAILABLE, errors.New("connection refused"))
+	p.Check("pg1")
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.spans) != 4 {
+		t.Fatalf("Expected 2 checks, a dial and an accept; instead got %+v", tracer.spans)
+	}
+	check, dial, failed := tracer.spans[0], tracer.spans[1], tracer.spans[3]
+	if check.name != "arbiter.check" || check.attrs["arbiter.backend"] != "pg1" || check.attrs["arbiter.state"] != "READ_WRITE" {
+		t.Errorf("Expected the check of pg1 to find it READ_WRITE; instead got %+v", check)
+	}
+	if dial.name != "arbiter.dial" || dial.parent != "arbiter.accept" || dial.attrs["server.address"] != "a" {
+		t.Errorf("Expected the dial of a within the accept; instead got %+v", dial)
+	}
+	if failed.err == nil || failed.attrs["arbiter.state"] != "UNAVAILABLE" {
+		t.Errorf("Expected the failed check to be recorded; instead got %+v", failed)
+	}
+}
+
+// recordingTracer records the spans that end.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent string
+	attrs  map[string]any
+	err    error
+}
+
+type recordedSpanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	s := &recordedSpan{t: r, name: name, attrs: make(map[string]any)}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *recordedSpan) SetError(err error)                 { s.err = err }
+
+func (s *recordedSpan) End() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+
+	s.t.spans = append(s.t.spans, s)
+}
+
+// timedend is a hangingend with a timeout of its own.
+type timedend struct {
+	hangingend
+	timeout time.Duration
+}
+
+func (t *timedend) CheckTimeout() time.Duration {
+	return t.timeout
+}
+
+func TestFlapThresholds(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithFlapThresholds(3, 2))
+
+	a := &mockend{state: READ_ONLY, id: "a"}
+	p.Put(a)
+	check := func(err error, want State) {
+		t.Helper()
+		a.set(READ_ONLY, err)
+		p.Check("a")
+		if got := p.Backends()[0].State; got != want {
+			t.Fatalf("Expected %s; instead got %s", want, got)
+		}
+	}
+
+	// The first check counts regardless.
+	check(nil, READ_ONLY)
+
+	// Two failures in a row are ridden out, and a success resets the streak.
+	check(errors.New("timeout"), READ_ONLY)
+	check(errors.New("timeout"), READ_ONLY)
+	check(nil, READ_ONLY)
+	check(errors.New("timeout"), READ_ONLY)
+	check(errors.New("timeout"), READ_ONLY)
+	if info := p.Backends()[0]; info.FailStreak != 2 || info.SuccessStreak != 0 {
+		t.Errorf("Expected a streak of 2 failures; instead got %+v", info)
+	}
+	check(errors.New("timeout"), UNAVAILABLE)
+
+	// It takes two successes in a row to come back.
+	check(nil, UNAVAILABLE)
+	check(errors.New("timeout"), UNAVAILABLE)
+	check(nil, UNAVAILABLE)
+	check(nil, READ_ONLY)
+}
+
+func TestTransientGrace(t *testing.T) {
+	now := time.Now()
+	p := New(context.Background(), WithManualChecks(func() time.Time { return now }), WithTransientGrace(time.Minute))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.Put(a)
+	p.Check("a")
+
+	// Starting up, the primary keeps its state but isn't routed to.
+	a.set(READ_WRITE, &TransientError{Phase: "starting up", Err: errors.New("pq: the database system is starting up")})
+	p.Check("a")
+	if info := p.Backends()[0]; info.State != READ_WRITE || info.Transient != "starting up" {
+		t.Errorf("Expected the primary to be held; instead got %+v", info)
+	}
+	var de *DegradedError
+	if _, err := p.Acquire(context.Background(), "strong"); !errors.As(err, &de) || de.Mode != NO_PRIMARY {
+		t.Errorf("Expected no primary to be routable; instead got %v", err)
+	}
+
+	a.set(READ_WRITE, nil)
+	p.Check("a")
+	if _, err := p.GetForWrite(); err != nil {
+		t.Errorf("Expected the primary to be routed to again; instead got %v", err)
+	}
+
+	// Once the grace runs out, it's failed.
+	a.set(READ_WRITE, &TransientError{Phase: "shutting down", Err: errors.New("pq: the database system is shutting down")})
+	p.Check("a")
+	now = now.Add(time.Minute)
+	p.Check("a")
+	if info := p.Backends()[0]; info.State != UNAVAILABLE || info.Transient != "" {
+		t.Errorf("Expected the primary to be failed; instead got %+v", info)
+	}
+}
+
+func TestDegradeOn(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithDegradeOn(PermissionError))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	p.Put(a)
+	p.Check("a")
+
+	// Losing a privilege leaves the primary routed to, but degraded.
+	a.set(READ_WRITE, &pq.Error{Code: "42501", Message: "permission denied for function pg_is_in_recovery"})
+	p.Check("a")
+	if info := p.Backends()[0]; info.State != READ_WRITE || info.Degraded != PermissionError {
+		t.Errorf("Expected the primary to be degraded; instead got %+v", info)
+	}
+	if _, err := p.GetForWrite(); err != nil || a.failed() {
+		t.Errorf("Expected the primary to be routed to still; instead got %v", err)
+	}
+
+	// A network error still fails it.
+	a.set(READ_WRITE, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	p.Check("a")
+	if info := p.Backends()[0]; info.State != UNAVAILABLE || info.Degraded != "" {
+		t.Errorf("Expected the primary to be failed; instead got %+v", info)
+	}
+}
+
+func TestStructuredLogger(t *testing.T) {
+	var out strings.Builder
+	l := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	p := New(context.Background(), WithManualChecks(time.Now), WithStructuredLogger(l))
+
+	p.Put(&mockend{state: READ_WRITE, id: "a"})
+	p.Check("a")
+
+	for _, want := range []string{
+		`"level":"INFO","msg":"transitioning","backend":"a","addr":"a","state":"UNAVAILABLE"`,
+		`"to":"READ_WRITE"`,
+		`"level":"DEBUG","msg":"checked","backend":"a"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the log to contain %s; instead got\n%s", want, &out)
+		}
+	}
+
+	// Logged as lines, the per-check records are left out.
+	var lines strings.Builder
+	p = New(context.Background(), WithManualChecks(time.Now), WithLogger(log.New(&lines, "", 0)))
+	p.Put(&mockend{state: READ_WRITE, id: "a"})
+	p.Check("a")
+	if got := lines.String(); !strings.HasPrefix(got, "a: transitioning addr=a state=UNAVAILABLE") || strings.Contains(got, "checked") {
+		t.Errorf("Expected only the transition to be logged; instead got\n%s", got)
+	}
+}
+
+func TestLatencySmoothing(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithLatencySmoothing(0.5, 0))
+	a := &rttmockend{mockend{state: READ_ONLY, id: "a"}, 10 * time.Millisecond}
+	b := &rttmockend{mockend{state: READ_ONLY, id: "b"}, 20 * time.Millisecond}
+	p.Put(a)
+	p.Put(b)
+	p.Check("a")
+	p.Check("b")
+
+	// One slow check of a doesn't move it behind b.
+	a.mu.Lock()
+	a.rtt = 28 * time.Millisecond
+	a.mu.Unlock()
+	p.Check("a")
+	if info := p.Backends()[0]; info.RTT != 19*time.Millisecond || info.LastRTT != 28*time.Millisecond {
+		t.Errorf("Expected a's round trip to be smoothed; instead got %+v", info)
+	}
+	if got, _ := p.GetForRead(); got != a {
+		t.Errorf("Expected a to remain the closest; instead got %s", got.Addr())
+	}
+
+	// By percentile, the slow check dominates a window this small.
+	p = New(context.Background(), WithManualChecks(time.Now), WithLatencySmoothing(1, 4))
+	p.Put(a)
+	for _, rtt := range []time.Duration{10, 30, 10, 10, 20} {
+		a.mu.Lock()
+		a.rtt = rtt * time.Millisecond
+		a.mu.Unlock()
+		p.Check("a")
+	}
+	if info := p.Backends()[0]; info.RTT != 30*time.Millisecond {
+		t.Errorf("Expected the 95th percentile of the last 4 round trips; instead got %+v", info)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want ErrorKind
+	}{
+		{&pq.Error{Code: "28P01"}, AuthError},
+		{fmt.Errorf("check: %w", &pq.Error{Code: "42501"}), PermissionError},
+		{&pq.Error{Code: "57014"}, TimeoutError},
+		{context.DeadlineExceeded, TimeoutError},
+		{&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, NetworkError},
+		{&pq.Error{Code: "42P01"}, ProtocolError},
+	} {
+		if got := ClassifyError(c.err); got != c.want {
+			t.Errorf("%v: Expected %s; instead got %s", c.err, c.want, got)
+		}
+	}
+}
+
+type mockend struct {
+	// Guards the fields below, which tests change while the backend is monitored.
+	mu sync.Mutex
+
+	id     string
+	err    error
+	state  State
+	fail   bool
+	closed bool
+}
+
+// Change the outcome of subsequent pings.
+func (m *mockend) set(state State, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = state
+	m.err = err
+}
+
+// Whether Fail() has been called.
+func (m *mockend) failed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.fail
+}
+
+func (m *mockend) Ping() (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state, m.err
+}
+
+func (m *mockend) Fail() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fail = true
+}
+
+func (m *mockend) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+func (m *mockend) Addr() string {
+	return m.id
+}
+
+func (m *mockend) Connect(t time.Duration) (c *Conn, err error) {
+	return c, err
+}
+
+type rttmockend struct {
+	mockend
+	rtt time.Duration
+}
+
+func (m *rttmockend) RTT() (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rtt, m.err
+}
+
+// deadmockend can't be connected to.
+type deadmockend struct {
+	mockend
+}
+
+func (m *deadmockend) Connect(t time.Duration) (*Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+// promotermockend takes writes once promoted.
+type promotermockend struct {
+	mockend
+}
+
+func (m *promotermockend) Promote(ctx context.Context) error {
+	m.set(READ_WRITE, nil)
+	return nil
+}
+
+// nodemockend reports the identity of the server it reaches.
+type nodemockend struct {
+	mockend
+	node string
+}
+
+func (m *nodemockend) NodeID() (string, error) {
+	return m.node, nil
+}
+
+type addrmockend struct {
+	mockend
+	addr string
+}
+
+func (m *addrmockend) Addr() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addr
+}
+
+func (m *addrmockend) SetAddr(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addr = addr
+}
+
+// pipemockend hands out one end of a pipe per connection, keeping the other.
+type pipemockend struct {
+	mockend
+	peers []net.Conn
+}
+
+func (m *pipemockend) Connect(t time.Duration) (*Conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, peer := net.Pipe()
+	m.peers = append(m.peers, peer)
+	return &Conn{underlying: c}, nil
+}
+
+func TestFailoverPolicy(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now),
+		WithFailoverPolicy(FailoverPolicy{Drain: true, Grace: 50 * time.Millisecond}))
+	p.DefineClass("followers", Class{FollowersOnly: true})
+
+	a := &pipemockend{mockend: mockend{state: READ_WRITE, id: "a"}}
+	b := &mockend{state: READ_ONLY, id: "b"}
+	p.Put(a)
+	p.Put(b)
+	p.Check("a")
+	p.Check("b")
+
+	lease, err := p.Acquire(context.Background(), "strong")
+	if err != nil || lease.Backend() != a {
+		t.Fatalf("Expected to lease the primary, instead got: %v, %v", lease, err)
+	}
+	defer lease.Release(nil)
+
+	// b is promoted, and a demoted.
+	a.set(READ_ONLY, nil)
+	b.set(READ_WRITE, nil)
+	p.Check("a")
+	p.Check("b")
+
+	if a.failed() {
+		t.Fatalf("Expected the sessions of the previous primary to be drained, not severed")
+	}
+	select {
+	case <-lease.Drained():
+	default:
+		t.Fatalf("Expected the lease on the previous primary to be handed off")
+	}
+
+	// Sessions routed to it as a follower are left alone.
+	later, err := p.Acquire(context.Background(), "followers")
+	if err != nil || later.Backend() != a {
+		t.Fatalf("Expected to lease the previous primary as a follower, instead got: %v, %v", later, err)
+	}
+	defer later.Release(nil)
+	select {
+	case <-later.Drained():
+		t.Fatalf("Expected the lease taken after the failover not to be handed off")
+	default:
+	}
+
+	// Past the grace, the session still there is severed.
+	time.Sleep(100 * time.Millisecond)
+	if !lease.Severed() || later.Severed() {
+		t.Fatalf("Expected only the session handed off to be severed; instead got %t, %t",
+			lease.Severed(), later.Severed())
+	}
+	if _, err := a.peers[0].Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the severed connection to be closed; instead got %v", err)
+	}
+
+	// By default, the sessions of a primary replaced while it's still up are severed.
+	p = New(context.Background(), WithManualChecks(time.Now))
+	c := &mockend{state: READ_WRITE, id: "c"}
+	d := &mockend{state: READ_ONLY, id: "d"}
+	p.Put(c)
+	p.Put(d)
+	p.Check("c")
+	p.Check("d")
+	d.set(READ_WRITE, nil)
+	p.Check("d")
+	if !c.failed() || d.failed() {
+		t.Fatalf("Expected the sessions of the previous primary to be severed")
+	}
+	if it, err := p.GetForWrite(); err != nil || it != d {
+		t.Fatalf("Expected writes to go to the new primary, instead got: %v, %v", it, err)
+	}
+}
+
+// fencingTable is the fencing table of the backends of a cluster; see fencemockend.
+type fencingTable struct {
+	mu      sync.Mutex
+	epoch   uint64
+	primary string
+}
+
+// fencemockend keeps its fencing epoch in a table shared with the rest of its cluster.
+type fencemockend struct {
+	mockend
+	table *fencingTable
+}
+
+func (m *fencemockend) Fence(table, primary string, create bool) (uint64, error) {
+	m.table.mu.Lock()
+	defer m.table.mu.Unlock()
+
+	if m.table.primary != primary {
+		m.table.epoch++
+		m.table.primary = primary
+	}
+	return m.table.epoch, nil
+}
+
+func TestFencing(t *testing.T) {
+	table := &fencingTable{}
+	a := &fencemockend{mockend: mockend{state: READ_WRITE, id: "a"}, table: table}
+	b := &fencemockend{mockend: mockend{state: READ_ONLY, id: "b"}, table: table}
+
+	p := New(context.Background(), WithManualChecks(time.Now))
+	p.Put(a)
+	p.Put(b)
+	p.Check("a")
+	p.Check("b")
+	if e := p.Epoch(); e != 0 {
+		t.Fatalf("Expected no epoch while fencing is disabled; instead got %d", e)
+	}
+
+	p.EnableFencing("arbiter_fencing", true)
+	p.Check("a")
+	p.Check("b")
+	p.Check("a")
+	if e := p.Epoch(); e != 1 {
+		t.Fatalf("Expected the first primary to be issued epoch 1; instead got %d", e)
+	}
+
+	a.set(READ_ONLY, nil)
+	b.set(READ_WRITE, nil)
+	p.Check("a")
+	p.Check("b")
+	if e := p.Epoch(); e != 2 {
+		t.Fatalf("Expected the promoted primary to be issued epoch 2; instead got %d", e)
+	}
+
+	// Another pool adopts the epoch of the same primary.
+	q := New(context.Background(), WithManualChecks(time.Now))
+	q.EnableFencing("arbiter_fencing", true)
+	q.Put(b)
+	q.Check("b")
+	if e := q.Epoch(); e != 2 || table.epoch != 2 {
+		t.Fatalf("Expected the epoch of the primary to be adopted; instead got %d", e)
+	}
+}
+
+func TestMultiWriter(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithMultiWriter("eu"))
+	p.DefineClass("us", Class{PrimaryOnly: true, WriteGroup: "us"})
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	b := &mockend{state: READ_WRITE, id: "b"}
+	c := &mockend{state: READ_WRITE, id: "c"}
+	for _, m := range []*mockend{a, b, c} {
+		p.Put(m)
+	}
+	p.SetPromotionInfo("a", PromotionInfo{Priority: 1, WriteGroup: "eu"})
+	p.SetPromotionInfo("b", PromotionInfo{Priority: 2, WriteGroup: "eu"})
+	p.SetPromotionInfo("c", PromotionInfo{Priority: 1, WriteGroup: "us"})
+	for _, name := range []string{"a", "b", "c"} {
+		p.Check(name)
+	}
+
+	// a became writable first, and keeps the writes of its group; a second writer
+	// isn't a new primary.
+	if w, _ := p.GetForWrite(); w != a {
+		t.Fatalf("Expected writes to go to a; instead got %v", w)
+	}
+	if w, _ := p.GetForClass("us"); w != c {
+		t.Fatalf("Expected writes of group us to go to c; instead got %v", w)
+	}
+	if f := p.LastFailover(); f != nil {
+		t.Errorf("Expected no failover; instead got %+v", f)
+	}
+
+	// Once a fails, b takes over the writes of its group, and us is left alone.
+	a.set(UNAVAILABLE, errors.New("down"))
+	p.Check("a")
+	if w, _ := p.GetForWrite(); w != b {
+		t.Fatalf("Expected writes to go to b; instead got %v", w)
+	}
+	if w, _ := p.GetForClass("us"); w != c {
+		t.Fatalf("Expected writes of group us to go to c still; instead got %v", w)
+	}
+
+	c.set(UNAVAILABLE, errors.New("down"))
+	p.Check("c")
+	if _, err := p.GetForClass("us"); err != ErrNoneAvailable {
+		t.Fatalf("Expected group us to have no writer; instead got %v", err)
+	}
+
+	groups := p.WriteGroups()
+	if len(groups) != 2 || groups[0].Name != "eu" || groups[0].Writer != "b" ||
+		len(groups[0].Writers) != 1 || groups[1].Name != "us" || groups[1].Writer != "" {
+		t.Errorf("Expected eu written to through b, and us unhealthy; instead got %+v", groups)
+	}
+}
+
+func TestDistributed(t *testing.T) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithDistributed(), WithLatencyBand(0, time.Second))
+
+	a := &mockend{state: READ_WRITE, id: "a"}
+	b := &mockend{state: READ_WRITE, id: "b"}
+	for _, m := range []*mockend{a, b} {
+		p.Put(m)
+	}
+	for i := 0; i < 2; i++ {
+		p.Check("a")
+		p.Check("b")
+	}
+
+	// Every node takes writes, and none of them is a new primary superseding another;
+	// writes are balanced across those in the band.
+	if f := p.LastFailover(); f != nil {
+		t.Errorf("Expected no failover; instead got %+v", f)
+	}
+	seen := make(map[Backend]bool)
+	for i := 0; i < 4; i++ {
+		w, err := p.GetForClass("strong")
+		if err != nil {
+			t.Fatalf("Expected a node for writes; instead got %v", err)
+		}
+		seen[w] = true
+	}
+	if !seen[a] || !seen[b] {
+		t.Errorf("Expected writes balanced across a and b; instead got %v", seen)
+	}
+
+	// Losing a node leaves writes to the others.
+	a.set(UNAVAILABLE, errors.New("down"))
+	p.Check("a")
+	if w, err := p.GetForWrite(); w != b || err != nil {
+		t.Fatalf("Expected writes to go to b; instead got %v, %v", w, err)
+	}
+	if f := p.LastFailover(); f != nil {
+		t.Errorf("Expected no failover; instead got %+v", f)
+	}
+
+	b.set(UNAVAILABLE, errors.New("down"))
+	p.Check("b")
+	if _, err := p.GetForWrite(); err != ErrNoneAvailable {
+		t.Errorf("Expected no node for writes; instead got %v", err)
+	}
 }
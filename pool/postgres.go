@@ -1,54 +1,479 @@
 package pool
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"log"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// The default timeout for establishing monitoring connections.
+const defaultConnectTimeout = 5 * time.Second
+
+// PostgresSettings configure how a Postgres backend is monitored.
+type PostgresSettings struct {
+	User     string
+	Password string
+	Database string
+
+	// The timeout for establishing the monitoring connection; 5s if zero.
+	ConnectTimeout time.Duration
+
+	// How often the backend is checked; the pool's default if zero.
+	CheckInterval time.Duration
+
+	// How long the queries of a check may take before it's abandoned and failed, so
+	// that a hung server is taken out of routing; the pool's default if zero.  See
+	// WithCheckTimeout().
+	QueryTimeout time.Duration
+
+	// How long the monitoring connection is used before it's replaced by a new one,
+	// so that server-side state, such as a backend process bloated by cached plans or
+	// stuck on an old configuration, doesn't go stale; forever if zero.
+	ConnLifetime time.Duration
+
+	// How the backend is connected to; a net.Dialer if nil.
+	Dialer Dialer
+
+	// The databases clients are routed to, when Database is a dedicated one for
+	// monitoring, such as postgres.  They're checked to exist and accept connections
+	// without connecting to them.
+	RoutingDatabases []string
+
+	// A query checking that the backend is live, for distributed databases such as
+	// CockroachDB and YugabyteDB, whose nodes all take writes and have no
+	// pg_is_in_recovery(); see WithDistributed().  If it's set, it's run rather than
+	// asking the backend whether it's in recovery, and the backend is READ_WRITE unless
+	// the query fails or returns false.
+	LivenessQuery string
+
+	// The REST API of the Patroni agent managing the backend, as http://host:port or
+	// https://host:port, where the host is that of the backend if it's left out, as in
+	// http://:8008.  If it's set, NewBackend() checks the backend's role and WAL
+	// positions there rather than with SQL, which follows switchovers as soon as
+	// Patroni does, and keeps working while the server's connections are exhausted;
+	// the settings of the monitoring connection are then unused.
+	Patroni string
+
+	// Further queries checked on every health check, such as of a sentinel table of the
+	// application; the backend is unavailable while any of them fails.
+	Checks []CheckQuery
+
+	// The libpq password file, such as ~/.pgpass, the password is looked up in if it
+	// isn't given; that of $PGPASSFILE, or else ~/.pgpass, if empty.  See
+	// https://www.postgresql.org/docs/current/libpq-pgpass.html.
+	Passfile string
+
+	// A second credential to monitor with, such as the next one during a password
+	// rotation.  Whenever authenticating with the credential in use fails, the other
+	// one is tried, so that monitoring carries on while both are valid at some point.
+	// SecondaryUser defaults to User.
+	SecondaryUser     string
+	SecondaryPassword string
+
+	// Further parameters of the monitoring connection, such as application_name, and
+	// server settings, such as statement_timeout, which libpq would take as
+	// keywords or in options.  They're not to give what the settings above do, such as
+	// the user or sslmode.
+	Options map[string]string
+
+	// Where the credential to monitor with comes from, such as a secrets manager
+	// issuing short-lived ones, rather than User and Password and the secondary
+	// credential.  It's asked before every check, and whenever the credential it
+	// supplies changes, the monitoring connection is replaced by one authenticating
+	// with the new one.  See VaultCredentials.
+	Credentials CredentialProvider
+
+	// TLS on the monitoring connection, as libpq's sslmode, sslrootcert, sslcert and
+	// sslkey; verify-full checks the server's certificate against the host of its
+	// address.  SSLMode is disable if empty.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+}
+
+// Credential is a user and password to authenticate with.
+type Credential struct {
+	User     string
+	Password string
+}
+
+// CredentialProvider supplies the credential of monitoring connections; see
+// PostgresSettings.Credentials.  Implementations must be safe for concurrent use, as
+// backends share them.
+type CredentialProvider interface {
+	// Credential returns the credential to authenticate with now, renewing or
+	// replacing the one it returned before as needed.
+	Credential(ctx context.Context) (Credential, error)
+}
+
+// CheckQuery is a query a health check runs on a backend, which fails unless it
+// returns a row, and unless the first column of its first row is Expect, if it isn't
+// empty.  Values are compared as text, booleans as true or false.
+type CheckQuery struct {
+	Query  string
+	Expect string
+}
+
 // pg is the Postgres implementation of a Backend
 type pg struct {
-	db         *sql.DB
-	address    string
-	connstring string
-	inflight   map[*Conn]bool
+	db       *sql.DB
+	settings PostgresSettings
+
+	// The connections returned by Connect() and not yet closed.
+	inflight connSet
+
+	// Whether the heartbeat, fencing and topology tables are known to exist.
+	heartbeatCreated bool
+	fencingCreated   bool
+	topologyCreated  bool
+
+	// Whether the secondary credential is in use; see PostgresSettings.
+	secondary bool
+
+	// The credential of PostgresSettings.Credentials the monitoring connection
+	// authenticates with.
+	provided Credential
+
+	// The context of the health check in progress, which bounds the queries of the
+	// rest of it; see PingContext().
+	checkCtx context.Context
+
+	logger Logger
+
+	// Guards addrs and cur, which may change while the backend is in use.
+	mu sync.Mutex
+
+	// The addresses of the backend in order of preference, and the index of the one
+	// currently in use.
+	addrs []string
+	cur   int
+}
+
+// PostgresOption configures a Postgres backend.
+type PostgresOption func(*PostgresSettings)
+
+// WithCredentials sets the user, password and database used to monitor the backend.
+func WithCredentials(user, pass, database string) PostgresOption {
+	return func(s *PostgresSettings) {
+		s.User = user
+		s.Password = pass
+		s.Database = database
+	}
+}
+
+// WithSecondaryCredentials sets a second credential to monitor the backend with,
+// should the first stop working; see PostgresSettings.
+func WithSecondaryCredentials(user, pass string) PostgresOption {
+	return func(s *PostgresSettings) {
+		s.SecondaryUser = user
+		s.SecondaryPassword = pass
+	}
 }
 
-func NewPostgresBackend(address, user, pass, database string) *pg {
-	connstring := fmt.Sprintf("postgres://%s:%s@%s/%s?connect_timeout=5&sslmode=disable",
-		user, pass, address, database)
+// WithRoutingDatabases sets the databases clients are routed to, checked alongside the
+// one monitored; see PostgresSettings.
+func WithRoutingDatabases(databases ...string) PostgresOption {
+	return func(s *PostgresSettings) {
+		s.RoutingDatabases = databases
+	}
+}
+
+func NewPostgresBackend(address string, opts ...PostgresOption) *pg {
+	return NewMultiAddressPostgresBackend([]string{address}, opts...)
+}
+
+// NewMultiAddressPostgresBackend returns a backend reachable at several addresses, such
+// as a private and a public one, in order of preference.  When the address in use
+// fails, the next one is tried; the backend still counts as a single node.
+func NewMultiAddressPostgresBackend(addrs []string, opts ...PostgresOption) *pg {
+	var s PostgresSettings
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return NewPostgresBackendWithSettings(addrs, s)
+}
+
+// NewPostgresBackendWithSettings returns a backend reachable at addrs, in order of
+// preference, monitored according to s.
+func NewPostgresBackendWithSettings(addrs []string, s PostgresSettings) *pg {
+	if s.ConnectTimeout == 0 {
+		s.ConnectTimeout = defaultConnectTimeout
+	}
+	if s.Dialer == nil {
+		s.Dialer = &net.Dialer{}
+	}
+
 	return &pg{
-		inflight:   make(map[*Conn]bool),
-		address:    address,
-		connstring: connstring,
+		addrs:    addrs,
+		settings: s,
+		logger:   log.Default(),
+	}
+}
+
+// SetLogger makes the backend log to l, and passes l on to its Credentials if they're
+// a LoggerSetter.
+func (p *pg) SetLogger(l Logger) {
+	p.logger = l
+	if ls, ok := p.settings.Credentials.(LoggerSetter); ok {
+		ls.SetLogger(l)
 	}
 }
 
+// CheckInterval returns how often the backend should be checked.
+func (p *pg) CheckInterval() time.Duration {
+	return p.settings.CheckInterval
+}
+
+// CheckTimeout returns how long the queries of a check may take.
+func (p *pg) CheckTimeout() time.Duration {
+	return p.settings.QueryTimeout
+}
+
+// Addr returns the address currently in use.
 func (p *pg) Addr() string {
-	return p.address
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.addrs[p.cur]
+}
+
+// SetAddr replaces the addresses of the backend with address.  The monitoring
+// connection isn't affected until it's reopened.
+func (p *pg) SetAddr(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addrs = []string{address}
+	p.cur = 0
+}
+
+// Return the addresses to try, starting with the one in use and followed by the others
+// in order of preference.
+func (p *pg) failoverOrder() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	order := []string{p.addrs[p.cur]}
+	for i, addr := range p.addrs {
+		if i != p.cur {
+			order = append(order, addr)
+		}
+	}
+
+	return order
+}
+
+func (p *pg) use(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.addrs {
+		if p.addrs[i] == addr {
+			p.cur = i
+		}
+	}
+}
+
+func (p *pg) connstring() string {
+	user, password := p.credential()
+
+	// connect_timeout is in whole seconds.
+	timeout := int((p.settings.ConnectTimeout + time.Second - 1) / time.Second)
+	params := url.Values{}
+	for key, value := range p.settings.Options {
+		params.Set(key, value)
+	}
+	params.Set("connect_timeout", strconv.Itoa(timeout))
+	params.Set("sslmode", "disable")
+	if p.settings.SSLMode != "" {
+		params.Set("sslmode", p.settings.SSLMode)
+	}
+	for key, value := range map[string]string{
+		"passfile":    p.settings.Passfile,
+		"sslrootcert": p.settings.SSLRootCert,
+		"sslcert":     p.settings.SSLCert,
+		"sslkey":      p.settings.SSLKey,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	// Without a password, libpq's password file is looked up.
+	userinfo := url.User(user)
+	if password != "" {
+		userinfo = url.UserPassword(user, password)
+	}
+	u := url.URL{Scheme: "postgres", User: userinfo, Host: p.Addr(),
+		Path: "/" + p.settings.Database, RawQuery: params.Encode()}
+	return u.String()
+}
+
+// Return the user and password to monitor with.
+func (p *pg) credential() (user, password string) {
+	if p.settings.Credentials != nil {
+		return p.provided.User, p.provided.Password
+	}
+	if !p.secondary {
+		return p.settings.User, p.settings.Password
+	}
+
+	if user = p.settings.SecondaryUser; user == "" {
+		user = p.settings.User
+	}
+	return user, p.settings.SecondaryPassword
+}
+
+// Whether the backend has a secondary credential to switch to.
+func (p *pg) hasSecondary() bool {
+	if p.settings.Credentials != nil {
+		return false
+	}
+	return p.settings.SecondaryUser != "" || p.settings.SecondaryPassword != ""
+}
+
+// Whether err is Postgres rejecting the credential.
+func isAuthFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code == "28P01" || pqErr.Code == "28000"
+}
+
+// Return err as a *TransientError if it's the server refusing connections because
+// it's starting up, shutting down or recovering (cannot_connect_now), and otherwise err.
+func transient(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "57P03" {
+		return err
+	}
+
+	return &TransientError{Phase: strings.TrimPrefix(pqErr.Message, "the database system is "), Err: err}
 }
 
+// Ping checks the address in use, failing over to the other addresses of the backend
+// if it's unreachable.
 func (p *pg) Ping() (s State, err error) {
-	// Ensure that the monitoring connection is alive
-	if p.db == nil {
-		p.db, err = sql.Open("postgres", p.connstring)
+	return p.PingContext(context.Background())
+}
+
+// Return the context of the health check in progress.
+func (p *pg) checkContext() context.Context {
+	if p.checkCtx == nil {
+		return context.Background()
+	}
+	return p.checkCtx
+}
+
+// PingContext is Ping, abandoned when ctx is canceled.  The queries of the rest of the
+// health check, such as RTT() and WALPosition(), are bounded by ctx too.
+func (p *pg) PingContext(ctx context.Context) (s State, err error) {
+	p.checkCtx = ctx
+	order := p.failoverOrder()
+	for i, addr := range order {
+		if ctx.Err() != nil {
+			return s, ctx.Err()
+		}
+
+		if i > 0 {
+			p.Close()
+			p.use(addr)
+		}
+
+		if s, err = p.ping(ctx); err == nil {
+			if i > 0 {
+				p.logger.Printf("%s: monitoring failed over to %s", order[0], addr)
+			}
+			return s, nil
+		}
+	}
+
+	return s, err
+}
+
+func (p *pg) ping(ctx context.Context) (s State, err error) {
+	if cp := p.settings.Credentials; cp != nil {
+		cred, err := cp.Credential(ctx)
 		if err != nil {
-			return s, err
+			return s, fmt.Errorf("could not get a credential: %w", err)
+		}
+		if cred != p.provided {
+			if p.db != nil {
+				p.logger.Printf("%s: reconnecting as %s", p.Addr(), cred.User)
+			}
+			p.Close()
+			p.provided = cred
 		}
 	}
 
-	p.db.SetMaxOpenConns(1)
+	for switched := false; ; switched = true {
+		// Ensure that the monitoring connection is alive
+		if p.db == nil {
+			connector, err := pq.NewConnector(p.connstring())
+			if err != nil {
+				return s, err
+			}
+			connector.Dialer(pqDialer{p.settings.Dialer})
+			p.db = sql.OpenDB(connector)
+		}
 
-	if err = p.db.Ping(); err != nil {
-		return s, err
+		p.db.SetMaxOpenConns(1)
+		p.db.SetConnMaxLifetime(p.settings.ConnLifetime)
+
+		err = p.db.PingContext(ctx)
+		if switched || !isAuthFailure(err) || !p.hasSecondary() {
+			break
+		}
+
+		// The credential in use may have been rotated out; try the other one.
+		next := "secondary"
+		if p.secondary {
+			next = "primary"
+		}
+		user, _ := p.credential()
+		p.logger.Printf("%s: authenticating as %s failed, trying the %s credential: %s", p.Addr(), user, next, err)
+		p.Close()
+		p.secondary = !p.secondary
+	}
+	if err != nil {
+		return s, transient(err)
 	}
 
-	// Check if we're a primary or a follower
+	// Check if we're a primary or a follower, or only whether we're live
 	var inRecovery bool
-	row := p.db.QueryRow("select pg_is_in_recovery();")
-	if err = row.Scan(&inRecovery); err != nil {
+	if q := p.settings.LivenessQuery; q != "" {
+		live, err := p.queryFirst(ctx, q)
+		switch {
+		case err != nil:
+			return s, fmt.Errorf("liveness query %q failed: %w", q, err)
+		case live == "false":
+			return s, fmt.Errorf("liveness query %q returned false", q)
+		}
+	} else {
+		row := p.db.QueryRowContext(ctx, "select pg_is_in_recovery();")
+		if err = row.Scan(&inRecovery); err != nil {
+			return s, err
+		}
+	}
+
+	if err = p.checkRoutingDatabases(ctx); err != nil {
+		return s, err
+	}
+
+	if err = p.runChecks(ctx); err != nil {
 		return s, err
 	}
 
@@ -59,25 +484,421 @@ func (p *pg) Ping() (s State, err error) {
 	}
 }
 
+// Check that the databases clients are routed to exist and accept connections, so that
+// a backend monitored through another database isn't routed to while they don't.
+func (p *pg) checkRoutingDatabases(ctx context.Context) error {
+	if len(p.settings.RoutingDatabases) == 0 {
+		return nil
+	}
+
+	rows, err := p.db.QueryContext(ctx, `select datname from pg_database
+		where datname = any($1) and datallowconn;`, pq.Array(p.settings.RoutingDatabases))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	open := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return err
+		}
+		open[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, db := range p.settings.RoutingDatabases {
+		if !open[db] {
+			return fmt.Errorf("database %s doesn't exist or doesn't accept connections", db)
+		}
+	}
+
+	return nil
+}
+
+// Run the CheckQueries of the backend, returning why the first that fails does.
+func (p *pg) runChecks(ctx context.Context) error {
+	for _, c := range p.settings.Checks {
+		got, err := p.queryFirst(ctx, c.Query)
+		switch {
+		case err != nil:
+			return fmt.Errorf("check %q failed: %w", c.Query, err)
+		case c.Expect != "" && got != c.Expect:
+			return fmt.Errorf("check %q returned %q; expected %q", c.Query, got, c.Expect)
+		}
+	}
+
+	return nil
+}
+
+// Return the first column of the first row query returns, as text.
+func (p *pg) queryFirst(ctx context.Context, query string) (string, error) {
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = errors.New("no rows")
+		}
+		return "", err
+	}
+	if len(cols) == 0 {
+		return "", nil
+	}
+
+	var first sql.NullString
+	dest := make([]interface{}, len(cols))
+	dest[0] = &first
+	for i := 1; i < len(dest); i++ {
+		dest[i] = new(sql.RawBytes)
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return "", err
+	}
+
+	return first.String, rows.Close()
+}
+
+// RTT measures the network round-trip time to the backend by issuing an empty query.
+// Postgres answers it with an EmptyQueryResponse without involving the planner or the
+// executor, so it isn't skewed by server-side load the way the role query is.
+func (p *pg) RTT() (rtt time.Duration, err error) {
+	if p.db == nil {
+		return rtt, errors.New("no monitoring connection")
+	}
+
+	start := time.Now()
+	if _, err = p.db.ExecContext(p.checkContext(), ""); err != nil {
+		return rtt, err
+	}
+
+	return time.Since(start), nil
+}
+
+// WALPosition returns the insert position on a primary, or the replay position on a
+// follower, along with the server's clock.
+func (p *pg) WALPosition() (lsn uint64, clock time.Time, err error) {
+	if p.db == nil {
+		return lsn, clock, errors.New("no monitoring connection")
+	}
+
+	var pos sql.NullString
+	row := p.db.QueryRowContext(p.checkContext(), `select case when pg_is_in_recovery()
+		then pg_last_wal_replay_lsn() else pg_current_wal_lsn() end, clock_timestamp();`)
+	if err = row.Scan(&pos, &clock); err != nil {
+		return lsn, clock, err
+	}
+
+	if !pos.Valid {
+		return lsn, clock, errors.New("no WAL replayed yet")
+	}
+
+	lsn, err = ParseLSN(pos.String)
+	return lsn, clock, err
+}
+
+// ReceivePosition returns the position of the last WAL a follower received and flushed.
+func (p *pg) ReceivePosition() (lsn uint64, err error) {
+	if p.db == nil {
+		return lsn, errors.New("no monitoring connection")
+	}
+
+	var pos sql.NullString
+	if err = p.db.QueryRowContext(p.checkContext(), "select pg_last_wal_receive_lsn();").Scan(&pos); err != nil {
+		return lsn, err
+	}
+
+	// Followers restoring from an archive rather than streaming receive nothing.
+	if !pos.Valid {
+		return lsn, nil
+	}
+
+	return ParseLSN(pos.String)
+}
+
+// Upstream returns the address of the server a follower streams WAL from, from
+// pg_stat_wal_receiver.
+func (p *pg) Upstream() (addr string, err error) {
+	if p.db == nil {
+		return addr, errors.New("no monitoring connection")
+	}
+
+	var host sql.NullString
+	var port sql.NullInt64
+	err = p.db.QueryRowContext(p.checkContext(), "select sender_host, sender_port from pg_stat_wal_receiver;").Scan(&host, &port)
+	if err == sql.ErrNoRows || !host.Valid {
+		return "", nil
+	}
+	if err != nil {
+		return addr, err
+	}
+
+	return net.JoinHostPort(host.String, strconv.FormatInt(port.Int64, 10)), nil
+}
+
+// SyncStandbys reads synchronous_standby_names and pg_stat_replication on the primary.
+func (p *pg) SyncStandbys() (c SyncConfig, standbys []SyncStandby, err error) {
+	if p.db == nil {
+		return c, nil, errors.New("no monitoring connection")
+	}
+
+	var names string
+	if err = p.db.QueryRowContext(p.checkContext(), "show synchronous_standby_names;").Scan(&names); err != nil {
+		return c, nil, err
+	}
+	if c, err = ParseSyncStandbyNames(names); err != nil {
+		return c, nil, err
+	}
+
+	rows, err := p.db.QueryContext(p.checkContext(), `select application_name, coalesce(host(client_addr), ''),
+		sync_state from pg_stat_replication;`)
+	if err != nil {
+		return c, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s SyncStandby
+		if err = rows.Scan(&s.Name, &s.Addr, &s.State); err != nil {
+			return c, nil, err
+		}
+		standbys = append(standbys, s)
+	}
+
+	return c, standbys, rows.Err()
+}
+
+// NodeID identifies the server by its system identifier, port and start time.  The
+// system identifier alone is shared by the primary and its followers.
+func (p *pg) NodeID() (id string, err error) {
+	if p.db == nil {
+		return id, errors.New("no monitoring connection")
+	}
+
+	var sysid int64
+	var port string
+	var started time.Time
+	err = p.db.QueryRowContext(p.checkContext(), `select system_identifier, current_setting('port'), pg_postmaster_start_time()
+		from pg_control_system();`).Scan(&sysid, &port, &started)
+	if err != nil {
+		return id, err
+	}
+
+	return fmt.Sprintf("%d:%s:%d", sysid, port, started.UnixNano()), nil
+}
+
+// ArchiverStatus reads pg_stat_archiver.
+func (p *pg) ArchiverStatus() (s ArchiverStatus, err error) {
+	if p.db == nil {
+		return s, errors.New("no monitoring connection")
+	}
+
+	var lastArchived, lastFailed sql.NullTime
+	row := p.db.QueryRowContext(p.checkContext(), `select archived_count, failed_count, last_archived_time,
+		last_failed_time, clock_timestamp() from pg_stat_archiver;`)
+	err = row.Scan(&s.ArchivedCount, &s.FailedCount, &lastArchived, &lastFailed, &s.Now)
+	if err != nil {
+		return s, err
+	}
+
+	s.LastArchived = lastArchived.Time
+	s.LastFailed = lastFailed.Time
+	return s, nil
+}
+
+// Checksum runs query, which must return a single value, and returns its result.
+func (p *pg) Checksum(query string) (sum string, err error) {
+	if p.db == nil {
+		return sum, errors.New("no monitoring connection")
+	}
+
+	err = p.db.QueryRowContext(p.checkContext(), query).Scan(&sum)
+	return sum, err
+}
+
+// WriteHeartbeat upserts ts into the single row of table.
+func (p *pg) WriteHeartbeat(table string, ts time.Time, create bool) (err error) {
+	if p.db == nil {
+		return errors.New("no monitoring connection")
+	}
+
+	if create && !p.heartbeatCreated {
+		_, err = p.db.ExecContext(p.checkContext(), fmt.Sprintf(`create table if not exists %s
+			(id int primary key, ts timestamptz not null);`, table))
+		if err != nil {
+			return err
+		}
+		p.heartbeatCreated = true
+	}
+
+	_, err = p.db.ExecContext(p.checkContext(), fmt.Sprintf(`insert into %s (id, ts) values (1, $1)
+		on conflict (id) do update set ts = excluded.ts;`, table), ts)
+	return err
+}
+
+// ReadHeartbeat reads the timestamp last written to table.
+func (p *pg) ReadHeartbeat(table string) (ts time.Time, err error) {
+	if p.db == nil {
+		return ts, errors.New("no monitoring connection")
+	}
+
+	err = p.db.QueryRowContext(p.checkContext(), fmt.Sprintf("select ts from %s where id = 1;", table)).Scan(&ts)
+	return ts, err
+}
+
+// Fence advances the epoch in the single row of table, naming primary, unless it
+// already names it, and returns it.  The update is conditional, so that concurrent
+// callers naming the same primary advance it once.
+func (p *pg) Fence(table, primary string, create bool) (epoch uint64, err error) {
+	if p.db == nil {
+		return epoch, errors.New("no monitoring connection")
+	}
+
+	if create && !p.fencingCreated {
+		_, err = p.db.ExecContext(p.checkContext(), fmt.Sprintf(`create table if not exists %s
+			(id int primary key, epoch bigint not null, primary_name text not null,
+			issued_at timestamptz not null);`, table))
+		if err != nil {
+			return epoch, err
+		}
+		p.fencingCreated = true
+	}
+
+	_, err = p.db.ExecContext(p.checkContext(), fmt.Sprintf(`insert into %[1]s (id, epoch, primary_name, issued_at)
+		values (1, 1, $1, now())
+		on conflict (id) do update set epoch = %[1]s.epoch + 1, primary_name = excluded.primary_name,
+			issued_at = excluded.issued_at
+		where %[1]s.primary_name <> excluded.primary_name;`, table), primary)
+	if err != nil {
+		return epoch, err
+	}
+
+	err = p.db.QueryRowContext(p.checkContext(), fmt.Sprintf("select epoch from %s where id = 1;", table)).Scan(&epoch)
+	return epoch, err
+}
+
+// Promote promotes the follower with pg_promote(), waiting until the deadline of ctx,
+// if any, or else pg_promote()'s default of a minute, for it to leave recovery.
+func (p *pg) Promote(ctx context.Context) error {
+	if p.db == nil {
+		return errors.New("no monitoring connection")
+	}
+
+	wait := 60
+	if deadline, ok := ctx.Deadline(); ok {
+		if wait = int(time.Until(deadline) / time.Second); wait < 1 {
+			wait = 1
+		}
+	}
+
+	var promoted bool
+	if err := p.db.QueryRowContext(ctx, "select pg_promote(true, $1);", wait).Scan(&promoted); err != nil {
+		return err
+	}
+	if !promoted {
+		return fmt.Errorf("not promoted within %ds", wait)
+	}
+	return nil
+}
+
+// WriteTopology replaces the contents of table with backends in a transaction, so that
+// readers never see it partly written.
+func (p *pg) WriteTopology(table string, backends []BackendInfo, create bool) (err error) {
+	if p.db == nil {
+		return errors.New("no monitoring connection")
+	}
+
+	ctx := p.checkContext()
+	if create && !p.topologyCreated {
+		_, err = p.db.ExecContext(ctx, fmt.Sprintf(`create table if not exists %s
+			(name text primary key, addr text not null, state text not null, routable bool not null,
+			upstream text, sync_state text, updated_at timestamptz not null);`, table))
+		if err != nil {
+			return err
+		}
+		p.topologyCreated = true
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("delete from %s;", table)); err != nil {
+		return err
+	}
+	for _, b := range backends {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`insert into %s
+			(name, addr, state, routable, upstream, sync_state, updated_at)
+			values ($1, $2, $3, $4, nullif($5, ''), nullif($6, ''), now());`, table),
+			b.Name, b.Addr, b.State.String(), b.Routable(), b.Upstream, b.SyncState)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the monitoring connection; the next Ping() reopens it.
+func (p *pg) Close() error {
+	if p.db == nil {
+		return nil
+	}
+
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
 func (p *pg) Connect(t time.Duration) (conn *Conn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t)
+	defer cancel()
+
+	return p.ConnectContext(ctx)
+}
+
+// ConnectContext connects to the backend within the deadline of ctx, trying its
+// addresses in turn, and passes ctx on to the Dialer.
+func (p *pg) ConnectContext(ctx context.Context) (conn *Conn, err error) {
 	conn = new(Conn)
-	conn.underlying, err = net.DialTimeout("tcp", p.Addr(), t)
+
+	// Divide what's left of the deadline between the addresses left to try.
+	deadline, hasDeadline := ctx.Deadline()
+	order := p.failoverOrder()
+	for i, addr := range order {
+		dctx, cancel := ctx, context.CancelFunc(func() {})
+		if hasDeadline {
+			dctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(len(order)-i))
+		}
+		conn.underlying, err = p.settings.Dialer.DialContext(dctx, "tcp", addr)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+
 	if err != nil {
 		p.Fail()
 		return conn, err
 	}
 
-	p.inflight[conn] = true
-	closeHandler := func() {
-		delete(p.inflight, conn)
-	}
-	conn.RegisterCloseHandler(closeHandler)
+	p.inflight.add(conn)
 
 	return conn, nil
 }
 
 func (p *pg) Fail() {
-	for k := range p.inflight {
-		k.Close()
-	}
+	p.inflight.closeAll()
 }
@@ -0,0 +1,286 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectFailover(t *testing.T) {
+	// An address nothing listens on.
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.Close()
+
+	live, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	p := NewMultiAddressPostgresBackend([]string{dead.Addr().String(), live.Addr().String()})
+	conn, err := p.Connect(time.Second)
+	if err != nil {
+		t.Fatalf("Expected to connect to the second address, instead got: %v", err)
+	}
+	defer conn.Close()
+
+	if conn.RemoteAddr().String() != live.Addr().String() {
+		t.Fatalf("Expected to be connected to %s, instead got %s", live.Addr(), conn.RemoteAddr())
+	}
+}
+
+// recordingDialer dials directly, recording the addresses dialed.
+type recordingDialer struct {
+	net.Dialer
+	dialed []string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dialed = append(d.dialed, address)
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestConnectDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	d := &recordingDialer{}
+	p := NewPostgresBackend(l.Addr().String(), WithDialer(d))
+	conn, err := p.Connect(time.Second)
+	if err != nil {
+		t.Fatalf("Expected to connect, instead got: %v", err)
+	}
+	defer conn.Close()
+
+	if len(d.dialed) != 1 || d.dialed[0] != l.Addr().String() {
+		t.Fatalf("Expected the connection to go through the dialer, instead got %v", d.dialed)
+	}
+}
+
+type traceKey struct{}
+
+// contextDialer fails every dial, recording the trace each carried.
+type contextDialer struct {
+	traces []interface{}
+}
+
+func (d *contextDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.traces = append(d.traces, ctx.Value(traceKey{}))
+	return nil, errors.New("connection refused")
+}
+
+func TestConnectContext(t *testing.T) {
+	d := &contextDialer{}
+	p := NewMultiAddressPostgresBackend([]string{"10.0.0.1:5432", "10.0.0.2:5432"}, WithDialer(d))
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	if _, err := p.ConnectContext(ctx); err == nil {
+		t.Fatalf("Expected the dials to fail")
+	}
+	if len(d.traces) != 2 || d.traces[0] != "trace-1" || d.traces[1] != "trace-1" {
+		t.Errorf("Expected both addresses to be dialed with the trace; instead got %v", d.traces)
+	}
+
+	// Once the caller gives up, the remaining addresses aren't tried.
+	d.traces = nil
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	p.ConnectContext(ctx)
+	if len(d.traces) != 1 {
+		t.Errorf("Expected a single dial; instead got %v", d.traces)
+	}
+}
+
+// Serve a minimal Postgres server on l that accepts the password want and answers any
+// query with true.
+func serveAuth(l net.Listener, want string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			msg := func(typ byte, payload []byte) []byte {
+				b := []byte{typ, 0, 0, 0, 0}
+				binary.BigEndian.PutUint32(b[1:], uint32(len(payload)+4))
+				return append(b, payload...)
+			}
+			read := func(typed bool) (byte, []byte, error) {
+				var typ [1]byte
+				if typed {
+					if _, err := io.ReadFull(conn, typ[:]); err != nil {
+						return 0, nil, err
+					}
+				}
+				var n [4]byte
+				if _, err := io.ReadFull(conn, n[:]); err != nil {
+					return 0, nil, err
+				}
+				b := make([]byte, binary.BigEndian.Uint32(n[:])-4)
+				_, err := io.ReadFull(conn, b)
+				return typ[0], b, err
+			}
+
+			if _, _, err := read(false); err != nil {
+				return
+			}
+			conn.Write(msg('R', []byte{0, 0, 0, 3}))
+			if _, password, err := read(true); err != nil || string(bytes.TrimRight(password, "\x00")) != want {
+				conn.Write(msg('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")))
+				return
+			}
+			conn.Write(append(msg('R', []byte{0, 0, 0, 0}), msg('Z', []byte("I"))...))
+
+			for {
+				typ, query, err := read(true)
+				if err != nil || typ != 'Q' {
+					return
+				}
+				if string(query) == ";\x00" {
+					conn.Write(append(msg('I', nil), msg('Z', []byte("I"))...))
+					continue
+				}
+
+				field := append([]byte("b\x00"), 0, 0, 0, 0, 0, 0, 0, 0, 0, 16, 0, 1, 0xff, 0xff, 0xff, 0xff, 0, 0)
+				var out []byte
+				out = append(out, msg('T', append([]byte{0, 1}, field...))...)
+				out = append(out, msg('D', []byte{0, 1, 0, 0, 0, 1, 't'})...)
+				out = append(out, msg('C', []byte("SELECT 1\x00"))...)
+				out = append(out, msg('Z', []byte("I"))...)
+				conn.Write(out)
+			}
+		}(conn)
+	}
+}
+
+func TestSecondaryCredentials(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveAuth(l, "new")
+
+	p := NewPostgresBackend(l.Addr().String(), WithCredentials("arbiter", "old", "postgres"))
+	defer p.Close()
+	if _, err = p.Ping(); err == nil {
+		t.Fatalf("Expected the rotated-out password to be rejected")
+	}
+
+	p = NewPostgresBackend(l.Addr().String(), WithCredentials("arbiter", "old", "postgres"),
+		WithSecondaryCredentials("", "new"))
+	defer p.Close()
+	if s, err := p.Ping(); err != nil || s != READ_ONLY {
+		t.Fatalf("Expected to switch to the secondary credential, instead got: %v, %v", s, err)
+	}
+	if user, password := p.credential(); user != "arbiter" || password != "new" {
+		t.Fatalf("Expected to keep using the secondary credential, instead got %s:%s", user, password)
+	}
+}
+
+func TestCheckQueries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveAuth(l, "secret")
+
+	// Every query is answered with true.
+	p := NewPostgresBackendWithSettings([]string{l.Addr().String()}, PostgresSettings{User: "arbiter",
+		Password: "secret", Checks: []CheckQuery{{Query: "select 1 from sentinel"}, {Query: "select ok", Expect: "true"}}})
+	defer p.Close()
+	if s, err := p.Ping(); err != nil || s != READ_ONLY {
+		t.Fatalf("Expected the checks to pass; instead got %v, %v", s, err)
+	}
+
+	p.settings.Checks = append(p.settings.Checks, CheckQuery{Query: "show default_transaction_read_only", Expect: "off"})
+	if _, err := p.Ping(); err == nil || !strings.Contains(err.Error(), "default_transaction_read_only") {
+		t.Fatalf("Expected the failing check to fail the ping; instead got %v", err)
+	}
+}
+
+func TestPingStartingUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			var n [4]byte
+			if _, err := io.ReadFull(conn, n[:]); err == nil {
+				io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(n[:])-4))
+			}
+			payload := []byte("SFATAL\x00C57P03\x00Mthe database system is starting up\x00\x00")
+			b := []byte{'E', 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(len(payload)+4))
+			conn.Write(append(b, payload...))
+			conn.Close()
+		}
+	}()
+
+	p := NewPostgresBackend(l.Addr().String())
+	defer p.Close()
+
+	var te *TransientError
+	if _, err := p.Ping(); !errors.As(err, &te) || te.Phase != "starting up" {
+		t.Fatalf("Expected the backend to be reported as starting up; instead got %v", err)
+	}
+}
+
+func TestConnStringTLS(t *testing.T) {
+	p := NewPostgresBackend("db.example.com:5432", WithCredentials("arbiter", "p@ss/word", "postgres"))
+	if got := p.connstring(); !strings.Contains(got, "sslmode=disable") || !strings.Contains(got, "arbiter:p%40ss%2Fword@") {
+		t.Errorf("Expected TLS to be disabled and the password escaped; instead got %s", got)
+	}
+
+	p.settings.SSLMode, p.settings.SSLRootCert = "verify-full", "/etc/arbiter/ca.pem"
+	u, err := url.Parse(p.connstring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("sslmode") != "verify-full" || q.Get("sslrootcert") != "/etc/arbiter/ca.pem" || q.Has("sslcert") {
+		t.Errorf("Expected the server certificate to be verified; instead got %s", u)
+	}
+
+	p.settings.Options = map[string]string{"application_name": "arbiter", "statement_timeout": "5s"}
+	if u, err = url.Parse(p.connstring()); err != nil {
+		t.Fatal(err)
+	}
+	if q := u.Query(); q.Get("application_name") != "arbiter" || q.Get("statement_timeout") != "5s" || q.Get("sslmode") != "verify-full" {
+		t.Errorf("Expected the options alongside the settings; instead got %s", u)
+	}
+
+	// Without a password, the password file is looked up.
+	p = NewPostgresBackendWithSettings([]string{"db.example.com:5432"},
+		PostgresSettings{User: "arbiter", Database: "postgres", Passfile: "/etc/arbiter/pgpass"})
+	if u, err = url.Parse(p.connstring()); err != nil {
+		t.Fatal(err)
+	}
+	if _, set := u.User.Password(); set || u.Query().Get("passfile") != "/etc/arbiter/pgpass" {
+		t.Errorf("Expected no password, and the password file; instead got %s", u)
+	}
+}
@@ -0,0 +1,177 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrNotPromotable = errors.New("backend can't be promoted")
+var ErrNotFollower = errors.New("backend isn't an available follower")
+
+// PromotionInfo is what's known about a backend beyond its health checks that bears on
+// whether it should be promoted; see SetPromotionInfo().
+type PromotionInfo struct {
+	// Higher tiers are preferred; backends in tier 0 or below are never candidates.
+	Priority int
+
+	// The availability zone of the backend.  Backends in the zone of the primary are
+	// preferred, so that a failover doesn't move writes away from the applications.
+	Zone string
+
+	// Whether the backend is a synchronous standby, and so has every committed write.
+	Synchronous bool
+
+	// The write group of the backend, with WithMultiWriter(): the conflict domain it
+	// takes writes for alongside the other writers of the group.
+	WriteGroup string
+}
+
+// Candidate is a follower considered for promotion.
+type Candidate struct {
+	Name string
+	Addr string
+	PromotionInfo
+
+	// The WAL position last replayed by the follower, if known, and how far it's behind.
+	LSN      uint64
+	LSNKnown bool
+	Lag      time.Duration
+}
+
+// PromotionPolicy decides which follower to promote should the primary fail.  Rank is
+// given the candidates and the zone of the primary, and returns them in order of
+// preference, leaving out those that mustn't be promoted.
+type PromotionPolicy interface {
+	Rank(candidates []Candidate, primaryZone string) []Candidate
+}
+
+// DefaultPromotionPolicy ranks candidates by replayed WAL position, then synchronous
+// standbys first, then by priority tier, then those in the zone of the primary first.
+// Candidates whose position is unknown come last.
+type DefaultPromotionPolicy struct{}
+
+func (DefaultPromotionPolicy) Rank(candidates []Candidate, primaryZone string) []Candidate {
+	ranked := make([]Candidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c.Priority > 0 {
+			ranked = append(ranked, c)
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		switch {
+		case a.LSNKnown != b.LSNKnown:
+			return a.LSNKnown
+		case a.LSN != b.LSN:
+			return a.LSN > b.LSN
+		case a.Synchronous != b.Synchronous:
+			return a.Synchronous
+		case a.Priority != b.Priority:
+			return a.Priority > b.Priority
+		}
+		return a.Zone == primaryZone && b.Zone != primaryZone
+	})
+
+	return ranked
+}
+
+// WithPromotionPolicy makes PromotionCandidates() rank followers with pp rather than
+// DefaultPromotionPolicy.
+func WithPromotionPolicy(pp PromotionPolicy) Option {
+	return func(p *Pool) {
+		p.promotion = pp
+	}
+}
+
+// SetPromotionInfo sets what's known about the backend named or addressed addr beyond
+// its health checks.  Backends default to priority 1 in no zone.
+func (p *Pool) SetPromotionInfo(addr string, info PromotionInfo) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	m.promotion = info
+	p.electWriters()
+	return nil
+}
+
+// PromotionCandidates returns the available followers in the order they should be
+// promoted in should the primary fail, according to the pool's PromotionPolicy, for
+// Promote(), the tooling that promotes backends itself, and operators to check the
+// ranking before an incident.
+func (p *Pool) PromotionCandidates() []Candidate {
+	p.RLock()
+	var candidates []Candidate
+	for _, m := range p.avail {
+		if m.state != READ_ONLY || m.diverged {
+			continue
+		}
+
+		candidates = append(candidates, Candidate{
+			Name:          m.name,
+			Addr:          m.b.Addr(),
+			PromotionInfo: m.promotion,
+			LSN:           m.wal.lsn,
+			LSNKnown:      m.wal.ok,
+			Lag:           m.lag,
+		})
+	}
+
+	zone := ""
+	if p.primary != nil {
+		zone = p.primary.promotion.Zone
+	}
+	policy := p.promotion
+	p.RUnlock()
+
+	return policy.Rank(candidates, zone)
+}
+
+// Promote promotes the follower named or addressed addr to primary, and checks it right
+// after, so that writes are routed to it as soon as it takes them.  Like Check(), it
+// waits for a check in progress, so that the backend isn't checked while being
+// promoted.  It returns ErrUnknownBackend if there's no such backend, ErrNotPromotable
+// unless it's a Promoter, and ErrNotFollower unless it's an available follower.  Promote doesn't
+// fence off the previous primary; that's up to the caller.
+func (p *Pool) Promote(ctx context.Context, addr string) error {
+	return p.onTurn(ctx, addr, func(mon *monitor) error {
+		if _, ok := mon.m.b.(Promoter); !ok {
+			return ErrNotPromotable
+		}
+
+		defer p.reschedule(mon)
+		return p.promoteMember(mon.ctx, ctx, mon.m)
+	})
+}
+
+// Promote m, on its turn, then check it.
+func (p *Pool) promoteMember(monitorCtx, ctx context.Context, m *member) error {
+	p.RLock()
+	follower := m.state == READ_ONLY && !m.diverged
+	p.RUnlock()
+	if !follower {
+		return ErrNotFollower
+	}
+
+	p.log.Warn("promoting backend", "backend", m.name, "addr", m.b.Addr())
+	err := m.b.(Promoter).Promote(ctx)
+	p.check(monitorCtx, m)
+	if err != nil {
+		return err
+	}
+
+	p.RLock()
+	promoted := m.state == READ_WRITE
+	p.RUnlock()
+	if !promoted {
+		return errors.New("backend still isn't primary after promotion")
+	}
+	return nil
+}
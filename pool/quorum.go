@@ -0,0 +1,143 @@
+package pool
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SyncConfig is a parsed synchronous_standby_names: commits wait for Num of Names to
+// acknowledge them, either the first Num listed that are connected (FIRST), or any Num
+// of them (ANY, quorum commit).  Num is zero if replication is asynchronous.
+type SyncConfig struct {
+	Method string
+	Num    int
+	Names  []string
+}
+
+func (c SyncConfig) String() string {
+	if c.Num == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %d (%s)", c.Method, c.Num, strings.Join(c.Names, ", "))
+}
+
+// ParseSyncStandbyNames parses a synchronous_standby_names setting, such as
+// "ANY 2 (pg2, pg3, pg4)", "FIRST 1 (pg2, pg3)", "2 (pg2, pg3)" or "pg2, pg3", the last
+// two being FIRST.  Names may be double-quoted, and * matches any standby.
+func ParseSyncStandbyNames(s string) (c SyncConfig, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return c, nil
+	}
+
+	c.Method, c.Num = "FIRST", 1
+	list := s
+	if open := strings.IndexByte(s, '('); open >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return c, fmt.Errorf("%q: unbalanced parenthesis", s)
+		}
+		list = s[open+1 : len(s)-1]
+
+		head := strings.Fields(s[:open])
+		if len(head) == 2 {
+			c.Method = strings.ToUpper(head[0])
+			head = head[1:]
+		}
+		if len(head) != 1 || (c.Method != "FIRST" && c.Method != "ANY") {
+			return c, fmt.Errorf("%q: expected [FIRST|ANY] num (names)", s)
+		}
+		if c.Num, err = strconv.Atoi(head[0]); err != nil || c.Num < 1 {
+			return c, fmt.Errorf("%q: invalid number of standbys %q", s, head[0])
+		}
+	}
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, `"`) {
+			name = unquoted
+		}
+		if name == "" {
+			return c, fmt.Errorf("%q: empty standby name", s)
+		}
+		c.Names = append(c.Names, name)
+	}
+
+	return c, nil
+}
+
+// SyncStandby is a standby connected to the primary, as in pg_stat_replication.
+type SyncStandby struct {
+	// The standby's application_name and address.
+	Name string
+	Addr string
+
+	// Its sync_state: sync or quorum if it counts toward acknowledging commits, and
+	// potential or async otherwise.
+	State string
+}
+
+// SyncConfig returns the synchronous replication setting of the primary, as last
+// checked, or a zero one if it isn't known.
+func (p *Pool) SyncConfig() SyncConfig {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.syncConfig
+}
+
+// Update which members count toward the synchronous quorum of the primary m from the
+// standbys it reports.  A standby is a member whose name is its application_name, or
+// else whose host is its address.  Other members are async.  p must be locked.
+func (p *Pool) updateQuorum(m *member, c SyncConfig, standbys []SyncStandby, ok bool) {
+	if m != p.primary || !ok {
+		return
+	}
+
+	p.syncConfig = c
+	for _, f := range p.members {
+		if f == m {
+			f.syncState = ""
+			continue
+		}
+
+		f.syncState = "async"
+		host, _, _ := net.SplitHostPort(f.b.Addr())
+		for _, s := range standbys {
+			if s.Name == f.name || (s.Addr != "" && s.Addr == host) {
+				f.syncState = s.State
+				break
+			}
+		}
+	}
+}
+
+// Return members without those outside the synchronous quorum of the primary if c
+// prefers the quorum and any of the others satisfies c.  The primary counts as in it.
+// p must be at least read-locked.
+func preferQuorum(c Class, members []*member) []*member {
+	if !c.PreferQuorum {
+		return members
+	}
+
+	var ret []*member
+	found := false
+	for _, m := range members {
+		if m.state == READ_WRITE || m.inQuorum() {
+			ret = append(ret, m)
+			found = found || m.satisfies(c)
+		}
+	}
+	if !found {
+		return members
+	}
+
+	return ret
+}
+
+// Whether m acknowledges commits on the primary, counting toward its synchronous
+// quorum.  p must be at least read-locked.
+func (m *member) inQuorum() bool {
+	return m.syncState == "sync" || m.syncState == "quorum"
+}
@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"math"
+	"sort"
+	"time"
+)
+
+// WithRebalance recycles percent of the sessions on the other followers each time a
+// follower becomes available and is done slow starting, so that load shifts to the new
+// capacity rather than waiting for clients to reconnect of their own accord; see
+// SlowStart().  The oldest sessions are handed off first, through Lease.Drained(), at
+// up to rate a second, or all at once if rate is zero.  Sessions on the primary are
+// left alone.  The default of zero percent doesn't rebalance.
+func WithRebalance(percent, rate float64) Option {
+	return func(p *Pool) {
+		p.rebalancePercent, p.rebalanceRate = percent, rate
+	}
+}
+
+// Rebalance sessions onto m once it's an available follower done slow starting, once
+// each time it becomes available.  p must be locked.
+func (p *Pool) checkRebalance(m *member) {
+	if m.state != READ_ONLY || !contains(p.avail, m) {
+		m.rebalanced = false
+		return
+	}
+	if p.rebalancePercent <= 0 || m.rebalanced || m.warmth(p.now()) < 1 {
+		return
+	}
+	m.rebalanced = true
+
+	var leases []*Lease
+	for _, o := range p.avail {
+		if o == m || o.state != READ_ONLY {
+			continue
+		}
+		o.held.Range(func(k, _ any) bool {
+			leases = append(leases, k.(*Lease))
+			return true
+		})
+	}
+
+	n := int(math.Ceil(float64(len(leases)) * p.rebalancePercent / 100))
+	if n == 0 {
+		return
+	}
+	if n > len(leases) {
+		n = len(leases)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].since.Before(leases[j].since) })
+
+	p.logFor(m).Info("rebalancing sessions of other followers onto it", "sessions", n)
+	go p.recycle(leases[:n])
+}
+
+// Hand off the sessions of leases still held, at up to the rate of WithRebalance(),
+// counting them in arbiter_rebalanced_sessions_total.
+func (p *Pool) recycle(leases []*Lease) {
+	var interval time.Duration
+	if p.rebalanceRate > 0 {
+		interval = time.Duration(float64(time.Second) / p.rebalanceRate)
+	}
+
+	for _, l := range leases {
+		if _, held := l.m.held.Load(l); !held {
+			continue
+		}
+
+		l.handOff()
+		p.sink.AddCounter("arbiter_rebalanced_sessions_total", metrics.Labels{"backend": l.m.name}, 1)
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,41 @@
+package pool
+
+import (
+	"errors"
+)
+
+// Downstream is a server streaming WAL from a backend, as pg_stat_replication lists it.
+type Downstream struct {
+	// Its application_name, and the address it connected from.
+	Name string
+	Host string
+}
+
+// Downstreams returns the servers streaming WAL from the backend, from
+// pg_stat_replication: its followers, or, on a follower, its cascading standbys.
+// Logical replication and base backups, whose walsenders are connected to a database,
+// are left out.
+func (p *pg) Downstreams() (downstreams []Downstream, err error) {
+	if p.db == nil {
+		return nil, errors.New("no monitoring connection")
+	}
+
+	rows, err := p.db.QueryContext(p.checkContext(), `select r.application_name, host(r.client_addr)
+		from pg_stat_replication r join pg_stat_activity a using (pid)
+		where r.client_addr is not null and a.datname is null and r.application_name <> 'pg_basebackup'
+		order by 2, 1;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d Downstream
+		if err = rows.Scan(&d.Name, &d.Host); err != nil {
+			return nil, err
+		}
+		downstreams = append(downstreams, d)
+	}
+
+	return downstreams, rows.Err()
+}
@@ -0,0 +1,317 @@
+package pool
+
+import (
+	"container/heap"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// How many health checks run at once by default; see WithCheckConcurrency().
+const defaultCheckConcurrency = 32
+
+// monitor schedules the health checks of a member, from startMonitor() until
+// stopMonitor(); restarting it gives the member a new one.
+type monitor struct {
+	m *member
+
+	// Bounds the checks of the member; canceled once the monitor is stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Held while the member is checked or promoted, so that it never is twice at once,
+	// and for good once the monitor is stopped, when done is closed.
+	turn chan struct{}
+	done chan struct{}
+
+	// When the member is next checked, and its index in the schedule, or -1 while it's
+	// out of it, being checked or stopped.  Guarded by scheduler.mu.
+	next  time.Time
+	index int
+}
+
+// Acquire the turn of mon, unless it's stopped first.
+func (mon *monitor) acquire() bool {
+	select {
+	case mon.turn <- struct{}{}:
+		return true
+	case <-mon.done:
+		return false
+	}
+}
+
+func (mon *monitor) release() {
+	<-mon.turn
+}
+
+// scheduler hands the monitors of a pool to its workers as their checks fall due, so
+// that a pool checks any number of backends with one timer and at most
+// WithCheckConcurrency() checks at once.
+type scheduler struct {
+	mu    sync.Mutex
+	queue schedule
+
+	// Wakes the scheduler up when a check is scheduled, as it may be due sooner than
+	// the one it waits for.
+	wake chan struct{}
+
+	// The checks that are due, for the workers to run.
+	due chan *monitor
+
+	// Set once the pool's context is canceled, and its monitors stopped; guarded by
+	// Pool.monitorMu.
+	closed bool
+}
+
+// Schedule the next check of mon at at, unless it's stopped.
+func (s *scheduler) add(mon *monitor, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mon.ctx.Err() != nil {
+		return
+	}
+
+	mon.next = at
+	if mon.index >= 0 {
+		heap.Fix(&s.queue, mon.index)
+	} else {
+		heap.Push(&s.queue, mon)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Take mon out of the schedule.
+func (s *scheduler) drop(mon *monitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mon.index >= 0 {
+		heap.Remove(&s.queue, mon.index)
+	}
+}
+
+// Return the monitor whose check is due by now, taking it out of the schedule, or else
+// how long until the next one is.
+func (s *scheduler) pop(now time.Time) (*monitor, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil, time.Hour
+	}
+	if wait := s.queue[0].next.Sub(now); wait > 0 {
+		return nil, wait
+	}
+
+	return heap.Pop(&s.queue).(*monitor), 0
+}
+
+// Return when mon is next checked.
+func (s *scheduler) nextOf(mon *monitor) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return mon.next
+}
+
+// schedule is a heap of monitors, the next due first.
+type schedule []*monitor
+
+func (q schedule) Len() int           { return len(q) }
+func (q schedule) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q schedule) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *schedule) Push(x interface{}) {
+	mon := x.(*monitor)
+	mon.index = len(*q)
+	*q = append(*q, mon)
+}
+
+func (q *schedule) Pop() interface{} {
+	old := *q
+	mon := old[len(old)-1]
+	old[len(old)-1] = nil
+	mon.index = -1
+	*q = old[:len(old)-1]
+	return mon
+}
+
+// Hand checks to the workers as they fall due until the pool's context is canceled,
+// then stop the monitors.  With manual checks, there's nothing to hand out.
+func (p *Pool) schedule() {
+	defer p.monitors.Done()
+	defer p.stopMonitors()
+
+	if p.manual {
+		<-p.ctx.Done()
+		return
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		mon, wait := p.sched.pop(p.now())
+		if mon != nil {
+			select {
+			case p.sched.due <- mon:
+			case <-p.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-p.sched.wake:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop the monitors of all members, and those of members registered later as they
+// are, once the pool's context is canceled.
+func (p *Pool) stopMonitors() {
+	p.monitorMu.Lock()
+	defer p.monitorMu.Unlock()
+
+	p.sched.closed = true
+	p.RLock()
+	members := append([]*member(nil), p.members...)
+	p.RUnlock()
+	for _, m := range members {
+		p.stopMonitor(m)
+	}
+}
+
+// Run the checks handed out by the scheduler until the pool's context is canceled.
+func (p *Pool) work() {
+	defer p.monitors.Done()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case mon := <-p.sched.due:
+			if !mon.acquire() {
+				continue
+			}
+			// Check() may have checked the member while it waited for a worker, in
+			// which case it's already scheduled after.
+			if next := p.sched.nextOf(mon); p.now().Before(next) {
+				p.sched.add(mon, next)
+			} else {
+				p.check(mon.ctx, mon.m)
+				p.reschedule(mon)
+			}
+			mon.release()
+		}
+	}
+}
+
+// Schedule the next check of mon, as it was just checked, unless checks are manual.
+func (p *Pool) reschedule(mon *monitor) {
+	if !p.manual {
+		p.sched.add(mon, p.now().Add(p.nextCheck(mon.m)))
+	}
+}
+
+// Start monitoring m, checking it right away.  p.monitorMu must be held.
+func (p *Pool) startMonitor(m *member) {
+	mon := &monitor{m: m, turn: make(chan struct{}, 1), done: make(chan struct{}), index: -1}
+	mon.ctx, mon.cancel = context.WithCancel(p.ctx)
+	m.mon = mon
+
+	p.monitors.Add(1)
+	p.nmonitors.Add(1)
+	if p.sched.closed {
+		p.stopMonitor(m)
+		return
+	}
+
+	// A member is unavailable until it's checked, so the first check is right away
+	// rather than an interval in, for the pool to be routable as soon as the backends
+	// answer.
+	if !p.manual {
+		p.sched.add(mon, p.now())
+	}
+}
+
+// Stop the monitor of m, waiting for a check in progress to be abandoned, and release
+// its monitoring resources.  p.monitorMu must be held.
+func (p *Pool) stopMonitor(m *member) {
+	mon := m.mon
+	select {
+	case <-mon.done:
+		return
+	default:
+	}
+
+	mon.cancel()
+	mon.turn <- struct{}{}
+	p.sched.drop(mon)
+
+	if c, ok := m.b.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			p.log.Warn("error closing monitor", "backend", m.name, "error", err)
+		}
+	}
+
+	close(mon.done)
+	p.nmonitors.Add(-1)
+	p.monitors.Done()
+}
+
+// Run f on the member named or addressed addr, on its turn, so that it isn't checked
+// in the meantime, and with the context of its monitor.  If the monitor is restarted
+// first, f is run on the new one.  It returns ErrUnknownBackend if there's no such
+// backend.
+func (p *Pool) onTurn(ctx context.Context, addr string, f func(mon *monitor) error) error {
+	for {
+		p.monitorMu.Lock()
+		p.RLock()
+		m := p.find(addr)
+		p.RUnlock()
+		var mon *monitor
+		if m != nil {
+			mon = m.mon
+		}
+		p.monitorMu.Unlock()
+
+		if m == nil {
+			return ErrUnknownBackend
+		}
+
+		select {
+		case mon.turn <- struct{}{}:
+			if mon.ctx.Err() == nil {
+				defer mon.release()
+				return f(mon)
+			}
+			// Stopping, but not stopped yet.
+			mon.release()
+			<-mon.done
+		case <-mon.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// The monitor was restarted, or the backend removed, in the meantime.
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
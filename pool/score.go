@@ -0,0 +1,44 @@
+package pool
+
+// Scorer replaces the pool's choice of backend for callers that followers may serve,
+// for policies the latency band and least connections can't express.  Score is given
+// a snapshot of each backend that satisfies the caller's class, and returns whether
+// it's eligible and its score; the eligible backend with the highest score is routed
+// to, ties going to the closest.  It's called with the pool locked, so it mustn't call
+// into the pool, and should be quick.
+type Scorer interface {
+	Score(b BackendInfo) (score float64, eligible bool)
+}
+
+// ScorerFunc adapts a function to a Scorer.
+type ScorerFunc func(b BackendInfo) (score float64, eligible bool)
+
+func (f ScorerFunc) Score(b BackendInfo) (float64, bool) {
+	return f(b)
+}
+
+// WithScorer routes callers that followers may serve according to s rather than by
+// latency; see Scorer.  Callers requiring the primary are unaffected.
+func WithScorer(s Scorer) Option {
+	return func(p *Pool) {
+		p.scorer = s
+	}
+}
+
+// Return the member of avail satisfying c that p.scorer scores highest, or nil if none
+// is eligible.  p must be at least read-locked.
+func (p *Pool) pickScored(c Class, avail []*member) (best *member) {
+	var bestScore float64
+	for _, m := range avail {
+		if !m.satisfies(c) {
+			continue
+		}
+
+		score, eligible := p.scorer.Score(m.info(p.now()))
+		if eligible && (best == nil || score > bestScore) {
+			best, bestScore = m, score
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,74 @@
+package pool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SlowStart makes the backend named or addressed addr take a growing share of the
+// callers balanced onto it each time it becomes available, from none to its full share
+// d later, so that a fresh replica warms its caches before taking its full load.  A
+// zero d turns slow start off.  Callers a single backend is picked for, such as those
+// requiring the primary, aren't held back.
+func (p *Pool) SlowStart(addr string, d time.Duration) error {
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	m.slowStart = d
+	return nil
+}
+
+// Return the share of its callers m takes at now, from 0 when it has just become
+// available to 1 once it's done slow starting.  The pool must be at least read-locked.
+func (m *member) warmth(now time.Time) float64 {
+	if m.slowStart <= 0 || m.availableAt.IsZero() {
+		return 1
+	}
+
+	w := float64(now.Sub(m.availableAt)) / float64(m.slowStart)
+	if w > 1 {
+		return 1
+	}
+	return w
+}
+
+// Whether the nth turn of a rotation may go to m, which takes the share w of its
+// callers.  Turns are admitted by spreading n over [0, 1), so that a member halfway
+// warm takes every other turn it's given, give or take.
+func admitted(n uint64, w float64) bool {
+	// Knuth's multiplicative hash spreads consecutive turns apart.
+	return w >= 1 || float64(uint32(n*2654435761))/(1<<32) < w
+}
+
+// Return each of candidates in turn, skipping members that are slow starting, or
+// lighter than the heaviest, in proportion to how far they have left to go, unless all
+// of them are.  The turn is only taken if advance is set; otherwise the member whose
+// turn is next is returned.  p must be at least read-locked.
+func (p *Pool) rotate(candidates []*member, advance bool) *member {
+	now := p.now()
+	max := maxWeight(candidates)
+	cold := 0
+	for _, m := range candidates {
+		if m.share(now, max) < 1 {
+			cold++
+		}
+	}
+
+	n := atomic.LoadUint64(&p.rotation)
+	for {
+		if advance {
+			n = atomic.AddUint64(&p.rotation, 1)
+		} else {
+			n++
+		}
+		m := candidates[n%uint64(len(candidates))]
+		if cold == len(candidates) || admitted(n, m.share(now, max)) {
+			return m
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package pool
+
+import (
+	"sort"
+	"time"
+)
+
+// Fold the round trip of a successful check of m into the figure it's routed by: the
+// moving average of its round trips, or their 95th percentile over the last checks;
+// see WithLatencySmoothing().  p must be locked.
+func (p *Pool) observeRTT(m *member, rtt time.Duration) {
+	m.lastRTT = rtt
+
+	if p.rttWindow > 0 {
+		if len(m.rttSamples) == p.rttWindow {
+			copy(m.rttSamples, m.rttSamples[1:])
+			m.rttSamples = m.rttSamples[:p.rttWindow-1]
+		}
+		m.rttSamples = append(m.rttSamples, rtt)
+		m.rtt = percentile(m.rttSamples, 0.95)
+		return
+	}
+
+	if m.rtt == 0 || p.rttAlpha <= 0 || p.rttAlpha >= 1 {
+		m.rtt = rtt
+		return
+	}
+	m.rtt = time.Duration(p.rttAlpha*float64(rtt) + (1-p.rttAlpha)*float64(m.rtt))
+}
+
+// Forget the round trips of m, so that it's routed by fresh ones once it's available
+// again after a check that failed with a round trip of rtt.  p must be locked.
+func (p *Pool) resetRTT(m *member, rtt time.Duration) {
+	m.rtt, m.lastRTT = rtt, rtt
+	m.rttSamples = m.rttSamples[:0]
+}
+
+// Return the q quantile of samples, by the nearest rank.
+func percentile(samples []time.Duration, q float64) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+
+	return sorted[rank]
+}
@@ -0,0 +1,89 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+type stickyKey struct{}
+
+// StickTo returns a context that has Acquire() route callers that followers may serve,
+// and that carry the same key, such as the address of a client, to the follower the
+// last of them was routed to, for as long as it satisfies their class, so that its
+// caches serve them again; see WithStickiness().  Callers requiring the primary are
+// unaffected.  An empty key sticks to none.
+func StickTo(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, stickyKey{}, key)
+}
+
+// WithStickiness has callers that StickTo() the same key stick to a follower until ttl
+// has passed since the last of them was routed to it.  The default ttl of zero sticks
+// to none.
+func WithStickiness(ttl time.Duration) Option {
+	return func(p *Pool) {
+		p.stickyTTL = ttl
+	}
+}
+
+// stickyRoute is the follower the callers of a key stick to, until when.
+type stickyRoute struct {
+	m     *member
+	until time.Time
+}
+
+// Return the key the callers of ctx requiring c, the class of name, stick by, or "" if
+// they don't.
+func (p *Pool) stickyKey(ctx context.Context, c Class, name string) string {
+	key, _ := ctx.Value(stickyKey{}).(string)
+	if key == "" || p.stickyTTL <= 0 || c.PrimaryOnly {
+		return ""
+	}
+
+	return name + "\x00" + key
+}
+
+// Return the member the callers of key stick to, if it still satisfies c, or nil.  p
+// must be at least read-locked.
+func (p *Pool) stuck(c Class, key string) *member {
+	if key == "" {
+		return nil
+	}
+
+	p.stickyMu.Lock()
+	r, ok := p.sticky[key]
+	p.stickyMu.Unlock()
+	if !ok || p.now().After(r.until) || r.m.weight == 0 || !r.m.satisfies(c) {
+		return nil
+	}
+	for _, m := range p.avail {
+		if m == r.m {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// Stick the callers of key to m for the pool's TTL from now, and forget those whose
+// TTL has passed, at most once per TTL.
+func (p *Pool) stick(key string, m *member) {
+	now := p.now()
+
+	p.stickyMu.Lock()
+	defer p.stickyMu.Unlock()
+
+	if p.sticky == nil {
+		p.sticky = make(map[string]stickyRoute)
+	}
+	p.sticky[key] = stickyRoute{m: m, until: now.Add(p.stickyTTL)}
+
+	if now.Sub(p.stickyPruned) < p.stickyTTL {
+		return
+	}
+	p.stickyPruned = now
+	for k, r := range p.sticky {
+		if now.After(r.until) {
+			delete(p.sticky, k)
+		}
+	}
+}
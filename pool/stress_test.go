@@ -0,0 +1,309 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The number of simulated backends in TestStress.
+const stressBackends = 200
+
+// flapmockend changes role or goes down on every other health check, and fails every
+// third dial.
+type flapmockend struct {
+	addr   string
+	pings  int64
+	dials  int64
+	closed int64
+}
+
+func (m *flapmockend) Ping() (State, error) {
+	switch atomic.AddInt64(&m.pings, 1) % 4 {
+	case 0:
+		return READ_ONLY, nil
+	case 1:
+		return UNAVAILABLE, errors.New("down")
+	case 2:
+		return READ_WRITE, nil
+	}
+	return READ_ONLY, nil
+}
+
+func (m *flapmockend) RTT() (time.Duration, error) {
+	return time.Duration(rand.Intn(1000)) * time.Microsecond, nil
+}
+
+func (m *flapmockend) Addr() string {
+	return m.addr
+}
+
+func (m *flapmockend) Connect(t time.Duration) (*Conn, error) {
+	if atomic.AddInt64(&m.dials, 1)%3 == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return nil, nil
+}
+
+func (m *flapmockend) Fail() {}
+
+func (m *flapmockend) Close() error {
+	atomic.AddInt64(&m.closed, 1)
+	return nil
+}
+
+// Check the invariants the routing relies on.  p must be at least read-locked.
+func (p *Pool) checkInvariants() error {
+	if !sort.IsSorted(byLatency(p.avail)) {
+		return errors.New("available members aren't sorted by latency")
+	}
+
+	seen := make(map[*member]bool)
+	for _, m := range p.avail {
+		if seen[m] {
+			return fmt.Errorf("%s is available twice", m)
+		}
+		seen[m] = true
+
+		if m.state == UNAVAILABLE {
+			return fmt.Errorf("%s is available but UNAVAILABLE", m)
+		}
+	}
+
+	if p.primary != nil && (p.primary.state != READ_WRITE || !seen[p.primary]) {
+		return fmt.Errorf("the primary %s isn't an available READ_WRITE member", p.primary)
+	}
+
+	return nil
+}
+
+// TestStress registers hundreds of flapping backends while routing, dialing, leasing,
+// marking backends unavailable, restarting monitors and taking snapshots concurrently.
+// It's meant to be run with -race.
+func TestStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the stress test in short mode")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := New(ctx, WithCheckInterval(5*time.Millisecond), WithLogger(log.New(io.Discard, "", 0)))
+	p.DefineClass("bounded", Class{MaxLag: time.Second})
+	events := p.Subscribe()
+
+	backends := make([]*flapmockend, stressBackends)
+	for i := range backends {
+		backends[i] = &flapmockend{addr: fmt.Sprintf("10.0.%d.%d:5432", i/256, i%256)}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var wg sync.WaitGroup
+	worker := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; time.Now().Before(deadline); i++ {
+				f(i)
+			}
+		}()
+	}
+
+	// Register the backends from several goroutines.
+	for w := 0; w < 4; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(backends); i += 4 {
+				p.PutNamed(fmt.Sprintf("pg%d", i), backends[i])
+			}
+		}()
+	}
+
+	worker(func(i int) { p.GetForRead() })
+	worker(func(i int) { p.GetForWrite() })
+	worker(func(i int) { p.GetForClass("bounded") })
+	worker(func(i int) {
+		dctx, dcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		p.DialClass(dctx, "eventual")
+		dcancel()
+	})
+	worker(func(i int) {
+		if l, err := p.Acquire(ctx, "bounded"); err == nil {
+			l.Release(nil)
+		}
+	})
+	worker(func(i int) {
+		p.MarkUnavailable(backends[i%len(backends)])
+	})
+	worker(func(i int) {
+		if err := p.RestartMonitor(fmt.Sprintf("pg%d", i%len(backends))); err != nil && err != ErrUnknownBackend {
+			t.Errorf("Expected to restart the monitor, instead got error: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	})
+	worker(func(i int) { p.Backends() })
+	worker(func(i int) {
+		p.ForEach(func(info BackendInfo) bool { return info.State != READ_WRITE })
+	})
+	worker(func(i int) {
+		select {
+		case <-events:
+		case <-time.After(time.Millisecond):
+		}
+	})
+	worker(func(i int) {
+		p.RLock()
+		err := p.checkInvariants()
+		p.RUnlock()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	wg.Wait()
+	cancel()
+	p.Wait()
+
+	if n := len(p.Backends()); n != stressBackends {
+		t.Fatalf("Expected %d backends, instead got %d", stressBackends, n)
+	}
+
+	for _, b := range backends {
+		if atomic.LoadInt64(&b.closed) == 0 {
+			t.Fatalf("Expected %s to have been closed", b.addr)
+		}
+	}
+}
+
+// Return a pool of n equally close followers and a primary, checked manually, with a
+// class rr balancing round robin between the followers.
+func contendedPool(n int) (*Pool, []*rttmockend) {
+	p := New(context.Background(), WithManualChecks(time.Now), WithLogger(log.New(io.Discard, "", 0)))
+	p.DefineClass("rr", Class{FollowersOnly: true, Balance: BalanceRoundRobin})
+
+	backends := []*rttmockend{{mockend{state: READ_WRITE, id: "primary"}, time.Millisecond}}
+	for i := 0; i < n; i++ {
+		backends = append(backends, &rttmockend{mockend{state: READ_ONLY, id: fmt.Sprintf("f%d", i)}, time.Millisecond})
+	}
+	for _, b := range backends {
+		p.PutNamed(b.id, b)
+		p.Check(b.id)
+	}
+
+	return p, backends[1:]
+}
+
+// TestFairness acquires leases from thousands of goroutines at once, while the
+// backends are health checked, and expects the followers to take exactly equal turns
+// and the checks not to be held up by the callers.
+func TestFairness(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the contention test in short mode")
+	}
+
+	const callers, perCaller = 2000, 20
+	p, followers := contendedPool(4)
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			mine := make(map[string]int)
+			for j := 0; j < perCaller; j++ {
+				l, err := p.Acquire(context.Background(), "rr")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mine[l.Name()]++
+				l.Release(nil)
+			}
+
+			mu.Lock()
+			for name, n := range mine {
+				seen[name] += n
+			}
+			mu.Unlock()
+		}()
+	}
+
+	close(start)
+	var slowest time.Duration
+	for i := 0; i < 20; i++ {
+		began := time.Now()
+		p.Check(followers[i%len(followers)].id)
+		if d := time.Since(began); d > slowest {
+			slowest = d
+		}
+	}
+	wg.Wait()
+
+	for _, f := range followers {
+		if want := callers * perCaller / len(followers); seen[f.id] != want {
+			t.Errorf("Expected %s to take %d turns; instead got %v", f.id, want, seen)
+		}
+	}
+
+	if slowest > time.Second {
+		t.Errorf("Expected health checks not to be starved by callers; instead one took %s", slowest)
+	}
+}
+
+// BenchmarkAcquireContended acquires leases from 10k goroutines at once, reporting the
+// 99th percentile of the time taken to pick a backend and lease it.
+func BenchmarkAcquireContended(b *testing.B) {
+	const callers = 10000
+	p, _ := contendedPool(4)
+
+	latencies := make([]time.Duration, b.N)
+	var next int64 = -1
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			for {
+				n := atomic.AddInt64(&next, 1)
+				if n >= int64(len(latencies)) {
+					return
+				}
+
+				began := time.Now()
+				l, err := p.Acquire(context.Background(), "rr")
+				latencies[n] = time.Since(began)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				l.Release(nil)
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	close(start)
+	wg.Wait()
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+}
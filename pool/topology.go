@@ -0,0 +1,66 @@
+package pool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PublishTopology makes the monitor write the pool's view of the backends into table
+// on the primary, whenever it changes, so that it replicates to the followers and can
+// be queried from inside the database by any session, such as from psql or a stored
+// procedure; see TopologyWriter.  If create is set, the table is created if it doesn't
+// exist.  An empty table disables it.
+func (p *Pool) PublishTopology(table string, create bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.topologyTable = table
+	p.topologyCreate = create
+	p.topologyPublished = ""
+}
+
+// Routable returns whether the pool routes callers to the backend at all, regardless
+// of their class.
+func (b BackendInfo) Routable() bool {
+	return b.State != UNAVAILABLE && !b.Draining && !b.Cordoned && !b.Diverged && !b.Superseded && !b.Unfenced && b.DuplicateOf == "" && b.Transient == ""
+}
+
+// Write the view of the backends through the primary m, which its check in progress
+// found to be one, if it changed since it was last written, as by PublishTopology().
+func (p *Pool) publishTopology(m *member, w TopologyWriter) {
+	p.RLock()
+	table, create := p.topologyTable, p.topologyCreate
+	published := p.topologyPublished
+	var backends []BackendInfo
+	for _, b := range p.members {
+		info := b.info(p.now())
+		if b == m {
+			info.State, info.Transient = READ_WRITE, ""
+		}
+		backends = append(backends, info)
+	}
+	p.RUnlock()
+
+	if table == "" {
+		return
+	}
+
+	// Measurements change on every check; only what routing depends on is compared.
+	var view strings.Builder
+	fmt.Fprintf(&view, "%s;", m.name)
+	for _, b := range backends {
+		fmt.Fprintf(&view, "%s,%s,%s,%t,%s,%s;", b.Name, b.Addr, b.State, b.Routable(), b.Upstream, b.SyncState)
+	}
+	if view.String() == published {
+		return
+	}
+
+	if err := w.WriteTopology(table, backends, create); err != nil {
+		p.log.Warn("could not publish the topology", "backend", m.name, "error", err)
+		return
+	}
+
+	p.Lock()
+	p.topologyPublished = view.String()
+	p.Unlock()
+}
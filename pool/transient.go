@@ -0,0 +1,48 @@
+package pool
+
+import (
+	"errors"
+	"time"
+)
+
+// TransientError is returned by the Ping() of a backend in a transitional state, such
+// as starting up, shutting down or recovering from a crash, in which it can't serve
+// clients but isn't broken either; see WithTransientGrace().
+type TransientError struct {
+	// What the backend is doing, such as "starting up".
+	Phase string
+	Err   error
+}
+
+func (e *TransientError) Error() string {
+	return e.Phase + ": " + e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Return whether the check of m that failed with err is to be held, because m reported
+// a transitional state it's still given time to come out of; see WithTransientGrace().
+// Such a member keeps its state but isn't routed to.  p must be locked.
+func (p *Pool) holdTransient(m *member, err error) (held bool) {
+	var te *TransientError
+	if !errors.As(err, &te) || p.transientGrace <= 0 || m.state == UNAVAILABLE {
+		m.transient, m.transientSince = "", time.Time{}
+		return false
+	}
+
+	now := p.now()
+	if m.transient == "" {
+		m.transientSince = now
+		p.logFor(m).Info("holding it out of routing", "phase", te.Phase, "grace", p.transientGrace)
+	}
+	if now.Sub(m.transientSince) >= p.transientGrace {
+		p.logFor(m).Warn("still in a transitional state after the grace", "phase", te.Phase, "grace", p.transientGrace)
+		m.transient, m.transientSince = "", time.Time{}
+		return false
+	}
+
+	m.transient = te.Phase
+	return true
+}
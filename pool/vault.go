@@ -0,0 +1,234 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long VaultCredentials waits to retry renewing a lease that failed to renew.
+const vaultRetryInterval = 10 * time.Second
+
+// The timeout of requests to Vault.
+const vaultTimeout = 10 * time.Second
+
+// vaultSecret is what Vault answers issuing credentials and renewing their lease with.
+type vaultSecret struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+	Data          struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"data"`
+}
+
+// VaultCredentials is a CredentialProvider of dynamic credentials issued by the
+// database secrets engine of HashiCorp Vault, such as from database/creds/arbiter.
+// Their lease is renewed once two thirds of it have passed, and once it's in its last
+// sixth, as when it can't be renewed past the role's maximum TTL, new credentials are
+// issued; monitors reconnect with them at their next check.  The leases of credentials
+// replaced are left to expire, rather than revoked while monitors still use them.
+type VaultCredentials struct {
+	addr   string
+	path   string
+	token  func() (string, error)
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	logger Logger
+	cred   Credential
+
+	// Closed once the request to Vault in flight, if any, has been answered.  Vault is
+	// asked without holding mu, so that other monitors are meanwhile given cred.
+	refreshing chan struct{}
+
+	// The lease of cred, the duration it was issued for, which renewals ask for, and
+	// when it's to be renewed, replaced, and expires; never if zero.
+	leaseID   string
+	ttl       time.Duration
+	renewable bool
+	renewAt   time.Time
+	rotateAt  time.Time
+	expires   time.Time
+}
+
+// NewVaultCredentials returns VaultCredentials issued by the Vault server at addr, such
+// as https://vault.example.com:8200, from path, authenticated with the token that token
+// returns.  It's asked for on every request, so that a token renewed by Vault Agent is
+// picked up.
+func NewVaultCredentials(addr, path string, token func() (string, error)) *VaultCredentials {
+	return &VaultCredentials{
+		addr:   strings.TrimSuffix(addr, "/"),
+		path:   strings.Trim(path, "/"),
+		token:  token,
+		client: &http.Client{Timeout: vaultTimeout},
+		now:    time.Now,
+		logger: log.Default(),
+	}
+}
+
+// SetLogger makes the credentials log to l.  Backends pass on the Logger Pool gives
+// them.
+func (v *VaultCredentials) SetLogger(l Logger) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.logger = l
+}
+
+// Credential returns the credentials issued, issuing new ones first if there are none
+// yet or their lease is running out, and renewing it if it's due.  Until their lease
+// expires, credentials are returned even if Vault can't be reached, and new ones are
+// issued at the next call.  Only one call at a time asks Vault; the others are given
+// the credentials meanwhile, or wait for it if there are none that haven't expired.
+func (v *VaultCredentials) Credential(ctx context.Context) (Credential, error) {
+	v.mu.Lock()
+	var now time.Time
+	var valid, rotate bool
+	for {
+		now = v.now()
+		valid = v.cred != (Credential{}) && (v.expires.IsZero() || now.Before(v.expires))
+		rotate = v.cred == (Credential{}) || !v.rotateAt.IsZero() && !now.Before(v.rotateAt)
+		renew := !rotate && v.renewable && !now.Before(v.renewAt)
+		if !rotate && !renew || v.refreshing != nil && valid {
+			cred := v.cred
+			v.mu.Unlock()
+			return cred, nil
+		}
+		if v.refreshing == nil {
+			break
+		}
+
+		refreshing := v.refreshing
+		v.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return Credential{}, ctx.Err()
+		}
+		v.mu.Lock()
+	}
+
+	refreshing := make(chan struct{})
+	v.refreshing = refreshing
+	leaseID, ttl := v.leaseID, v.ttl
+	v.mu.Unlock()
+
+	var secret vaultSecret
+	var err error
+	if rotate {
+		secret, err = v.issue(ctx)
+	} else {
+		secret, err = v.renew(ctx, leaseID, ttl)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.refreshing = nil
+	close(refreshing)
+
+	switch {
+	case rotate && err != nil:
+		if !valid {
+			return Credential{}, err
+		}
+		v.logger.Printf("Could not replace the credentials of %s, which expire in %s: %s", v.path,
+			v.expires.Sub(now).Round(time.Second), err)
+	case rotate:
+		v.cred = Credential{User: secret.Data.Username, Password: secret.Data.Password}
+		v.leaseID = secret.LeaseID
+		v.ttl = time.Duration(secret.LeaseDuration) * time.Second
+		v.lease(now, secret)
+	case err != nil:
+		v.logger.Printf("Could not renew the lease of the credentials of %s: %s", v.path, err)
+		v.renewAt = now.Add(vaultRetryInterval)
+	default:
+		v.lease(now, secret)
+	}
+
+	return v.cred, nil
+}
+
+// Ask Vault to issue new credentials.
+func (v *VaultCredentials) issue(ctx context.Context) (vaultSecret, error) {
+	var secret vaultSecret
+	if err := v.do(ctx, http.MethodGet, v.path, nil, &secret); err != nil {
+		return secret, err
+	}
+	if secret.Data.Username == "" {
+		return secret, fmt.Errorf("%s issued no username", v.path)
+	}
+	return secret, nil
+}
+
+// Ask Vault to renew the lease leaseID for another ttl.
+func (v *VaultCredentials) renew(ctx context.Context, leaseID string, ttl time.Duration) (vaultSecret, error) {
+	var secret vaultSecret
+	req := map[string]any{"lease_id": leaseID, "increment": int64(ttl / time.Second)}
+	err := v.do(ctx, http.MethodPut, "sys/leases/renew", req, &secret)
+	return secret, err
+}
+
+// Schedule the renewal and replacement of the credentials, leased at now as secret
+// says.  Credentials that don't expire are never renewed or replaced.
+func (v *VaultCredentials) lease(now time.Time, secret vaultSecret) {
+	d := time.Duration(secret.LeaseDuration) * time.Second
+	if d <= 0 {
+		v.renewable = false
+		v.renewAt, v.rotateAt, v.expires = time.Time{}, time.Time{}, time.Time{}
+		return
+	}
+
+	v.renewable = secret.Renewable
+	v.expires = now.Add(d)
+	v.renewAt = now.Add(d * 2 / 3)
+	v.rotateAt = now.Add(d * 5 / 6)
+}
+
+// Send a request to the API at path, with body as JSON if it isn't nil, decoding the
+// response into out.
+func (v *VaultCredentials) do(ctx context.Context, method, path string, body, out any) error {
+	token, err := v.token()
+	if err != nil {
+		return fmt.Errorf("could not read the Vault token: %w", err)
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,155 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVaultCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var issued, renewed int
+	var renewDuration int64 = 60
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if req.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/v1/database/creds/arbiter":
+			issued++
+			fmt.Fprintf(w, `{"lease_id":"database/creds/arbiter/%d","lease_duration":60,"renewable":true,
+				"data":{"username":"v-arbiter-%d","password":"secret-%d"}}`, issued, issued, issued)
+		case req.Method == http.MethodPut && req.URL.Path == "/v1/sys/leases/renew":
+			var body struct {
+				LeaseID   string `json:"lease_id"`
+				Increment int64  `json:"increment"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			if body.LeaseID != fmt.Sprintf("database/creds/arbiter/%d", issued) || body.Increment != 60 {
+				http.Error(w, "unknown lease", http.StatusBadRequest)
+				return
+			}
+			renewed++
+			fmt.Fprintf(w, `{"lease_id":"%s","lease_duration":%d,"renewable":true}`, body.LeaseID, renewDuration)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer vault.Close()
+
+	now := time.Now()
+	v := NewVaultCredentials(vault.URL+"/", "/database/creds/arbiter", func() (string, error) { return "s.token", nil })
+	v.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cred, err := v.Credential(ctx)
+	if err != nil || cred != (Credential{User: "v-arbiter-1", Password: "secret-1"}) {
+		t.Fatalf("Expected the credentials issued; instead got %+v, %v", cred, err)
+	}
+
+	// The lease is renewed two thirds in.
+	now = now.Add(30 * time.Second)
+	v.Credential(ctx)
+	now = now.Add(15 * time.Second)
+	cred, err = v.Credential(ctx)
+	mu.Lock()
+	n := renewed
+	mu.Unlock()
+	if err != nil || cred.User != "v-arbiter-1" || n != 1 {
+		t.Fatalf("Expected the lease to be renewed; instead got %+v, %v, %d renewals", cred, err, n)
+	}
+
+	// Once the lease can't be extended, new credentials are issued in its last sixth.
+	mu.Lock()
+	renewDuration = 12
+	mu.Unlock()
+	now = now.Add(40 * time.Second)
+	v.Credential(ctx)
+	now = now.Add(10 * time.Second)
+	if cred, err = v.Credential(ctx); err != nil || cred.User != "v-arbiter-2" {
+		t.Fatalf("Expected new credentials; instead got %+v, %v", cred, err)
+	}
+
+	// Until they expire, credentials outlive Vault being unreachable.
+	vault.Close()
+	now = now.Add(55 * time.Second)
+	if cred, err = v.Credential(ctx); err != nil || cred.User != "v-arbiter-2" {
+		t.Errorf("Expected the credentials to be kept until they expire; instead got %+v, %v", cred, err)
+	}
+	now = now.Add(5 * time.Second)
+	if _, err = v.Credential(ctx); err == nil {
+		t.Errorf("Expected expired credentials not to be returned")
+	}
+
+	denied := NewVaultCredentials(vault.URL, "database/creds/arbiter", func() (string, error) { return "s.wrong", nil })
+	if _, err := denied.Credential(ctx); err == nil {
+		t.Errorf("Expected a denied request to fail")
+	}
+}
+
+func TestVaultCredentialsRenewing(t *testing.T) {
+	renewing, release := make(chan struct{}), make(chan struct{})
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/database/creds/arbiter":
+			fmt.Fprint(w, `{"lease_id":"database/creds/arbiter/1","lease_duration":60,"renewable":true,
+				"data":{"username":"v-arbiter-1","password":"secret-1"}}`)
+		case "/v1/sys/leases/renew":
+			close(renewing)
+			<-release
+			http.Error(w, `{"errors":["lease not found"]}`, http.StatusBadRequest)
+		}
+	}))
+	defer vault.Close()
+
+	start := time.Now()
+	var mu sync.Mutex
+	now := start
+	v := NewVaultCredentials(vault.URL, "database/creds/arbiter", func() (string, error) { return "s.token", nil })
+	v.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	var logged bytes.Buffer
+	v.SetLogger(log.New(&logged, "", 0))
+	ctx := context.Background()
+	if _, err := v.Credential(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// While the lease is being renewed, the credentials are given to other monitors.
+	mu.Lock()
+	now = start.Add(45 * time.Second)
+	mu.Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := v.Credential(ctx)
+		done <- err
+	}()
+	<-renewing
+	if cred, err := v.Credential(ctx); err != nil || cred.User != "v-arbiter-1" {
+		t.Errorf("Expected the credentials while their lease is renewed; instead got %+v, %v", cred, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the credentials though their lease couldn't be renewed; instead got %v", err)
+	}
+
+	if !strings.Contains(logged.String(), "Could not renew the lease of the credentials of database/creds/arbiter") {
+		t.Errorf("Expected the failed renewal to be logged; instead got %q", logged.String())
+	}
+}
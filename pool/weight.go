@@ -0,0 +1,67 @@
+package pool
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidWeight = errors.New("weight can't be negative")
+
+// SetWeight sets the share of callers balanced onto the backend named or addressed
+// addr relative to the others: one of weight 2 takes twice the callers of one of
+// weight 1, the default.  A backend of weight 0 is only routed to while none of a
+// caller's other candidates is, as a last resort.  Callers requiring the primary, and
+// those routed by a Scorer, aren't affected; see WithScorer().
+func (p *Pool) SetWeight(addr string, w float64) error {
+	if w < 0 {
+		return ErrInvalidWeight
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	m := p.find(addr)
+	if m == nil {
+		return ErrUnknownBackend
+	}
+
+	m.weight = w
+	return nil
+}
+
+// Return members without those of weight 0, unless none of the others satisfies c.
+func weighted(c Class, members []*member) []*member {
+	var ret []*member
+	found := false
+	for _, m := range members {
+		if m.weight > 0 {
+			ret = append(ret, m)
+			found = found || m.satisfies(c)
+		}
+	}
+	if !found {
+		return members
+	}
+
+	return ret
+}
+
+// Return the share of its callers m takes at now, among members whose heaviest weight
+// is max: its warmth, scaled by its weight relative to max.  The pool must be at least
+// read-locked.
+func (m *member) share(now time.Time, max float64) float64 {
+	if max <= 0 {
+		return m.warmth(now)
+	}
+	return m.warmth(now) * m.weight / max
+}
+
+// Return the heaviest weight of members.
+func maxWeight(members []*member) (max float64) {
+	for _, m := range members {
+		if m.weight > max {
+			max = m.weight
+		}
+	}
+	return max
+}
@@ -0,0 +1,152 @@
+package pool
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"sort"
+)
+
+// WriteGroup is the state of a group of backends that accept writes for the same
+// conflict domain; see WithMultiWriter().
+type WriteGroup struct {
+	Name string
+
+	// The backend writes of the group are routed to, empty if none can take them, in
+	// which case the group is unhealthy.
+	Writer string
+
+	// The backends of the group that accept writes, and all of its backends.
+	Writers []string
+	Members []string
+}
+
+// WriteGroups returns the write groups of the pool, by name, if WithMultiWriter() is
+// on; nil otherwise.
+func (p *Pool) WriteGroups() []WriteGroup {
+	p.RLock()
+	defer p.RUnlock()
+
+	if !p.multiWriter {
+		return nil
+	}
+
+	groups := make(map[string]*WriteGroup)
+	group := func(name string) *WriteGroup {
+		if groups[name] == nil {
+			groups[name] = &WriteGroup{Name: name}
+			if w := p.writers[name]; w != nil {
+				groups[name].Writer = w.name
+			}
+		}
+		return groups[name]
+	}
+	group(p.defaultWriteGroup)
+
+	for _, m := range p.members {
+		g := group(m.promotion.WriteGroup)
+		g.Members = append(g.Members, m.name)
+		if p.mayWrite(m) {
+			g.Writers = append(g.Writers, m.name)
+		}
+	}
+
+	ret := make([]WriteGroup, 0, len(groups))
+	for _, g := range groups {
+		ret = append(ret, *g)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	return ret
+}
+
+// Whether m accepts writes, and can be routed them.  p must be at least read-locked.
+func (p *Pool) mayWrite(m *member) bool {
+	return m.state == READ_WRITE && m.mayBePrimary() && !m.draining && m.transient == "" && contains(p.avail, m)
+}
+
+// Elect the writer of each write group with WithMultiWriter(): the writer stays as long
+// as it can take writes, so that the writes of a conflict domain keep going to one
+// backend, and is otherwise replaced by the writable backend of its group with the
+// highest priority, the closest first.  The writer of the default group is the primary,
+// and its replacement is reported as a failover.  p must be locked.
+func (p *Pool) electWriters() {
+	if !p.multiWriter {
+		return
+	}
+
+	groups := map[string]bool{p.defaultWriteGroup: true}
+	for _, m := range p.members {
+		groups[m.promotion.WriteGroup] = true
+	}
+
+	for group := range groups {
+		prev := p.writers[group]
+		if prev != nil && p.mayWrite(prev) && prev.promotion.WriteGroup == group {
+			continue
+		}
+
+		// avail is ordered by latency.
+		var next *member
+		for _, m := range p.avail {
+			if m.promotion.WriteGroup == group && p.mayWrite(m) &&
+				(next == nil || m.promotion.Priority > next.promotion.Priority) {
+				next = m
+			}
+		}
+
+		if prev != nil && prev != next && prev.state != UNAVAILABLE && !prev.draining {
+			p.handOff(prev)
+		}
+
+		labels := metrics.Labels{"group": group}
+		switch {
+		case next == nil:
+			if prev != nil {
+				p.log.Warn("write group has no writer", "group", group, "previous", prev.name)
+			}
+			delete(p.writers, group)
+			p.sink.SetGauge("arbiter_write_group_healthy", labels, 0)
+		default:
+			p.logFor(next).Info("elected the writer of its write group", "group", group)
+			p.writers[group] = next
+			p.sink.SetGauge("arbiter_write_group_healthy", labels, 1)
+			if prev != nil {
+				p.sink.AddCounter("arbiter_write_group_failovers_total", labels, 1)
+			}
+		}
+
+		if group != p.defaultWriteGroup {
+			continue
+		}
+		p.primary = next
+		if next != nil {
+			if r := p.failover(next); r != nil {
+				p.publish(Event{Name: next.name, Addr: next.b.Addr(), From: next.state, To: next.state,
+					Time: p.now(), Failover: r})
+			}
+		}
+	}
+
+	for group := range p.writers {
+		if !groups[group] {
+			delete(p.writers, group)
+		}
+	}
+}
+
+// Return the writer of the named group, or the primary if it's empty.  p must be at
+// least read-locked.
+func (p *Pool) writerOf(group string) *member {
+	if group == "" || !p.multiWriter {
+		return p.primary
+	}
+	return p.writers[group]
+}
+
+func contains(members []*member, m *member) bool {
+	for _, o := range members {
+		if o == m {
+			return true
+		}
+	}
+	return false
+}
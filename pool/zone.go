@@ -0,0 +1,40 @@
+package pool
+
+import (
+	"context"
+)
+
+type zoneKey struct{}
+
+// InZone returns a context that has Acquire() prefer the backends in zone, as set by
+// SetPromotionInfo(), for callers that followers may serve: they're routed among
+// those in zone that satisfy their class, and only to the others if there are none.
+// Callers requiring the primary are unaffected.  An empty zone prefers none.
+func InZone(ctx context.Context, zone string) context.Context {
+	return context.WithValue(ctx, zoneKey{}, zone)
+}
+
+func zoneOf(ctx context.Context) string {
+	zone, _ := ctx.Value(zoneKey{}).(string)
+	return zone
+}
+
+// Return the members of avail in zone if any of them satisfy c, and otherwise all of
+// them.  The pool must be at least read-locked.
+func inZone(c Class, zone string, avail []*member) []*member {
+	if zone == "" {
+		return avail
+	}
+
+	var members []*member
+	for _, m := range avail {
+		if m.promotion.Zone == zone && m.satisfies(c) {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return avail
+	}
+
+	return members
+}
@@ -0,0 +1,232 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"time"
+)
+
+// How often the promoter looks at the pool of [main] between state transitions, so
+// that the confirmation window runs out without one.
+const promoteInterval = time.Second
+
+// candidateStats describes a follower considered for promotion on /promotion.
+type candidateStats struct {
+	Name        string `json:"name"`
+	Addr        string `json:"addr"`
+	Priority    int    `json:"priority"`
+	Zone        string `json:"zone,omitempty"`
+	Synchronous bool   `json:"synchronous"`
+	LSN         uint64 `json:"lsn"`
+	LSNKnown    bool   `json:"lsn_known"`
+	Lag         string `json:"lag"`
+}
+
+// Serve /promotion, a dry run of the promotion policy: the followers in the order they
+// should be promoted in were the primary to fail now, so that operators can check the
+// ranking before an incident.
+func (s *server) handlePromotion(w http.ResponseWriter, req *http.Request) {
+	ranked := []candidateStats{}
+	for _, c := range s.pool.PromotionCandidates() {
+		ranked = append(ranked, candidateStats{
+			Name:        c.Name,
+			Addr:        c.Addr,
+			Priority:    c.Priority,
+			Zone:        c.Zone,
+			Synchronous: c.Synchronous,
+			LSN:         c.LSN,
+			LSNKnown:    c.LSNKnown,
+			Lag:         c.Lag.String(),
+		})
+	}
+
+	b, err := json.MarshalIndent(ranked, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Write(b)
+}
+
+// promoter promotes the best follower of [main], as PromotionCandidates() ranks them,
+// once its primary is confirmed down: unreachable, rather than demoted, on every check
+// for the confirmation window, with no other backend taking writes, as judged by the
+// instance leading the election, if any, so that arbiters watching the same backends
+// don't promote two.  The previous primary is fenced off first by the fence command,
+// if any, and the promotion isn't attempted unless it succeeds.  Once a promotion was
+// attempted, no other is until the cooldown ran out, so that a cluster failing over
+// repeatedly waits for an operator.  A pool that never had a primary is left alone.
+type promoter struct {
+	s        *server
+	mode     string
+	command  string
+	fence    string
+	confirm  time.Duration
+	timeout  time.Duration
+	cooldown time.Duration
+	now      func() time.Time
+
+	// The last primary seen, when it was first seen down, if it is, when a promotion
+	// was last attempted, and why the promoter last held back, so that it's logged once.
+	primary   pool.BackendInfo
+	downSince time.Time
+	attempted time.Time
+	holding   string
+}
+
+func newPromoter(s *server, c *Config) *promoter {
+	return &promoter{
+		s:        s,
+		mode:     c.Promotion.Mode,
+		command:  c.Promotion.Command,
+		fence:    c.Promotion.Fence,
+		confirm:  c.Promotion.confirm,
+		timeout:  c.Promotion.timeout,
+		cooldown: c.Promotion.cooldown,
+		now:      time.Now,
+	}
+}
+
+// Evaluate the pool on every state transition, and every promoteInterval, until the
+// process exits.
+func (pr *promoter) run() {
+	changed := make(chan struct{}, 1)
+	pr.s.pool.SubscribeFunc(func(pool.Event) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	t := time.NewTicker(promoteInterval)
+	defer t.Stop()
+	for {
+		pr.evaluate()
+
+		select {
+		case <-changed:
+		case <-t.C:
+		}
+	}
+}
+
+// Promote a follower if the primary is confirmed down, and the interlocks allow it.
+func (pr *promoter) evaluate() {
+	now := pr.now()
+	var previous *pool.BackendInfo
+	for _, b := range pr.s.pool.Backends() {
+		switch {
+		case b.State == pool.READ_WRITE:
+			pr.primary, pr.downSince, pr.holding = b, time.Time{}, ""
+			return
+		case b.Name == pr.primary.Name:
+			b := b
+			previous = &b
+		}
+	}
+	if previous == nil {
+		// Never seen, or removed since.
+		pr.primary, pr.downSince = pool.BackendInfo{}, time.Time{}
+		return
+	}
+	if previous.State != pool.UNAVAILABLE {
+		// Demoted rather than down, as during a switchover someone else runs.
+		pr.hold("the primary %s is a follower now", previous.Name)
+		pr.downSince = time.Time{}
+		return
+	}
+
+	if pr.downSince.IsZero() {
+		pr.downSince = now
+		pr.s.logger.Printf("Primary %s is down; promoting a follower unless it's back within %s", previous.Name, pr.confirm)
+	}
+	switch {
+	case now.Sub(pr.downSince) < pr.confirm:
+		return
+	case !pr.s.leading():
+		pr.hold("on standby; the leading instance promotes")
+		return
+	case !pr.attempted.IsZero() && now.Sub(pr.attempted) < pr.cooldown:
+		pr.hold("a promotion was attempted at %s, within the cooldown of %s", pr.attempted.Format(time.RFC3339), pr.cooldown)
+		return
+	}
+
+	candidates := pr.s.pool.PromotionCandidates()
+	if len(candidates) == 0 {
+		pr.hold("no follower can be promoted")
+		return
+	}
+	cand := candidates[0]
+
+	if pr.fence != "" {
+		if err := pr.exec(pr.fence, *previous, cand); err != nil {
+			pr.s.logger.Printf("Not promoting %s: fencing off %s failed: %s", cand.Name, previous.Name, err)
+			pr.s.sink.AddCounter("arbiter_promotions_total", metrics.Labels{"backend": cand.Name, "result": "fence_failed"}, 1)
+			// Confirm the primary is still down all over again before retrying.
+			pr.downSince = time.Time{}
+			return
+		}
+	}
+
+	pr.attempted = now
+	pr.s.logger.Printf("Promoting %s in place of %s, down since %s", cand.Name, previous.Name, pr.downSince.Format(time.RFC3339))
+	if err := pr.promote(*previous, cand); err != nil {
+		pr.s.logger.Printf("Promoting %s failed: %s", cand.Name, err)
+		pr.s.sink.AddCounter("arbiter_promotions_total", metrics.Labels{"backend": cand.Name, "result": "failed"}, 1)
+		return
+	}
+
+	pr.s.logger.Printf("Promoted %s; routing writes to it", cand.Name)
+	pr.s.sink.AddCounter("arbiter_promotions_total", metrics.Labels{"backend": cand.Name, "result": "promoted"}, 1)
+}
+
+// Log why the promoter holds back, unless it was the last reason logged.
+func (pr *promoter) hold(format string, args ...interface{}) {
+	reason := fmt.Sprintf(format, args...)
+	if reason == pr.holding {
+		return
+	}
+
+	pr.holding = reason
+	pr.s.logger.Printf("Not promoting a follower in place of %s: %s", pr.primary.Name, reason)
+}
+
+// Promote cand in place of previous, as the mode says, returning once it takes writes.
+func (pr *promoter) promote(previous pool.BackendInfo, cand pool.Candidate) error {
+	if pr.mode == promotionPgPromote {
+		ctx, cancel := context.WithTimeout(context.Background(), pr.timeout)
+		defer cancel()
+		return pr.s.pool.Promote(ctx, cand.Name)
+	}
+
+	if err := pr.exec(pr.command, previous, cand); err != nil {
+		return err
+	}
+	if err := pr.s.pool.Check(cand.Name); err != nil {
+		return err
+	}
+	for _, b := range pr.s.pool.Backends() {
+		if b.Name == cand.Name && b.State == pool.READ_WRITE {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s still isn't primary after %s", cand.Name, pr.command)
+}
+
+// Run script with the previous primary and the follower promoted in its place in the
+// environment, killing it once it runs past the timeout.
+func (pr *promoter) exec(script string, previous pool.BackendInfo, cand pool.Candidate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pr.timeout)
+	defer cancel()
+
+	return runScript(ctx, script, []string{
+		"ARBITER_BACKEND=" + cand.Name,
+		"ARBITER_ADDRESS=" + cand.Addr,
+		"ARBITER_PREVIOUS_PRIMARY=" + previous.Name,
+		"ARBITER_PREVIOUS_ADDRESS=" + previous.Addr,
+	}, nil)
+}
@@ -0,0 +1,121 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// promotableBackend is a follower until promoted, unless it's down.
+type promotableBackend struct {
+	mu       sync.Mutex
+	promoted bool
+	down     bool
+}
+
+func (b *promotableBackend) Promote(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.promoted = true
+	return nil
+}
+
+func (b *promotableBackend) Ping() (pool.State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.down {
+		return pool.UNAVAILABLE, net.ErrClosed
+	}
+	if b.promoted {
+		return pool.READ_WRITE, nil
+	}
+	return pool.READ_ONLY, nil
+}
+
+func (b *promotableBackend) Addr() string                              { return "127.0.0.1:5433" }
+func (b *promotableBackend) Connect(time.Duration) (*pool.Conn, error) { return nil, nil }
+func (b *promotableBackend) Fail()                                     {}
+
+func TestPromoter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+	pg1, pg2 := &queueBackend{}, &promotableBackend{}
+	p.PutNamed("pg1", pg1)
+	p.PutNamed("pg2", pg2)
+	pg1.promote()
+	p.CheckAll()
+
+	mem := metrics.NewMemory()
+	var logs strings.Builder
+	now := time.Now()
+	pr := &promoter{
+		s:        &server{pool: p, sink: mem, logger: log.New(&logs, "", 0)},
+		mode:     promotionPgPromote,
+		fence:    "false",
+		confirm:  30 * time.Second,
+		timeout:  5 * time.Second,
+		cooldown: 10 * time.Minute,
+		now:      func() time.Time { return now },
+	}
+	pr.evaluate()
+
+	pg1.mu.Lock()
+	pg1.primary = false
+	pg1.mu.Unlock()
+	p.CheckAll()
+	for _, wait := range []time.Duration{0, 29 * time.Second} {
+		now = now.Add(wait)
+		pr.evaluate()
+		if pg2.promoted {
+			t.Fatalf("Expected pg2 not to be promoted within the confirmation window")
+		}
+	}
+
+	// pg2 isn't promoted unless pg1 is fenced off.
+	now = now.Add(time.Second)
+	pr.evaluate()
+	if v, _ := mem.Get("arbiter_promotions_total", metrics.Labels{"backend": "pg2", "result": "fence_failed"}); pg2.promoted || v != 1 {
+		t.Fatalf("Expected pg2 not to be promoted without fencing pg1 off; instead got %v in %s", v, logs.String())
+	}
+
+	pr.fence = "true"
+	pr.evaluate()
+	if pg2.promoted {
+		t.Fatalf("Expected pg1 to be confirmed down all over again after fencing failed")
+	}
+	now = now.Add(30 * time.Second)
+	pr.evaluate()
+	if v, _ := mem.Get("arbiter_promotions_total", metrics.Labels{"backend": "pg2", "result": "promoted"}); !pg2.promoted || v != 1 {
+		t.Fatalf("Expected pg2 to be promoted; instead got %v in %s", v, logs.String())
+	}
+	if b, err := p.GetForWrite(); err != nil || b != pg2 {
+		t.Errorf("Expected writes to be routed to pg2; instead got %v, %v", b, err)
+	}
+
+	// Within the cooldown, pg2 going down too waits for an operator.
+	pr.evaluate()
+	pg2.mu.Lock()
+	pg2.down = true
+	pg2.mu.Unlock()
+	p.CheckAll()
+	now = now.Add(time.Minute)
+	pr.evaluate()
+	now = now.Add(time.Minute)
+	pr.evaluate()
+	if !strings.Contains(logs.String(), "within the cooldown of 10m0s") {
+		t.Errorf("Expected the promoter to hold back within the cooldown; instead got %s", logs.String())
+	}
+
+	if _, err := LoadConfig("./config.ini", nil, []string{"promotion.mode=command"}); err == nil {
+		t.Errorf("Expected mode = command to require the command")
+	}
+}
@@ -0,0 +1,310 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/solvip/arbiter/arbitertest"
+	"github.com/solvip/arbiter/pool"
+	"github.com/solvip/arbiter/tracing"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Start arbiter in front of backends, routing sessions by class, and return the address
+// it listens on, and its pool.
+func startArbiter(t *testing.T, class string, backends ...*arbitertest.Server) (string, *pool.Pool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p := pool.New(ctx, pool.WithManualChecks(time.Now))
+	for _, b := range backends {
+		p.Put(b.Backend())
+	}
+	p.CheckAll()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &server{pool: p, logger: log.New(io.Discard, "", 0), tracer: tracing.Nop{}, limits: Limits{Linger: time.Second}}
+	go s.serve(ln, &route{listener: "primary", class: class, pool: p})
+
+	return ln.Addr().String(), p
+}
+
+// Return the backend of backends that ran query last.
+func ranOn(query string, backends ...*arbitertest.Server) *arbitertest.Server {
+	for _, b := range backends {
+		if slices.Contains(b.Queries(), query) {
+			return b
+		}
+	}
+	return nil
+}
+
+// TestProxyConformance connects lib/pq through the proxy, and checks that it starts
+// sessions, cancels their queries, and is handed off to another backend when the one
+// it's on is drained.
+func TestProxyConformance(t *testing.T) {
+	primary := arbitertest.NewServer()
+	defer primary.Close()
+	follower := arbitertest.NewFollower(primary)
+	defer follower.Close()
+	addr, p := startArbiter(t, "eventual", primary, follower)
+
+	db, err := sql.Open("postgres", "postgres://app@"+addr+"/app?sslmode=disable&application_name=conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// Startup.
+	primary.Answer("select 'startup'", []string{"answer"}, []string{"ok"})
+	follower.Answer("select 'startup'", []string{"answer"}, []string{"ok"})
+	var answer string
+	if err := db.QueryRow("select 'startup'").Scan(&answer); err != nil || answer != "ok" {
+		t.Fatalf("Expected the session to start and answer; instead got %q, %v", answer, err)
+	}
+	first := ranOn("select 'startup'", primary, follower)
+	if first == nil {
+		t.Fatalf("Expected the query to reach a backend")
+	}
+
+	// Cancel: the cancel request is sent on a connection of its own, which arbiter
+	// forwards to the session's backend.
+	first.SetDelay(10 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	start := time.Now()
+	_, err = db.ExecContext(ctx, "select pg_sleep(10)")
+	cancel()
+	first.SetDelay(0)
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "57014" {
+		t.Errorf("Expected the query to be canceled; instead got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected the query to end once canceled; instead it took %s", d)
+	}
+
+	// Drain handoff: the session is told to reconnect, which lib/pq reads as the FATAL
+	// error it is, rather than as a reset, and reports as a bad connection so that
+	// database/sql retries on a new one, which lands on the other backend.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "select 'before'"); err != nil {
+		t.Fatalf("Expected the query to run; instead got %v", err)
+	}
+	on := ranOn("select 'before'", primary, follower)
+	if err := p.Drain(on.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(context.Background(), "select 'handed off'"); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("Expected the session to be told to reconnect; instead got %v", err)
+	}
+	conn.Close()
+
+	if _, err := db.Exec("select 'after'"); err != nil {
+		t.Fatalf("Expected a new session; instead got %v", err)
+	}
+	if after := ranOn("select 'after'", primary, follower); after == nil || after == on {
+		t.Errorf("Expected the new session to land on the backend not drained; instead got %v", after)
+	}
+}
+
+// Return both ends of a loopback TCP connection, which, unlike net.Pipe(), can be
+// half-closed.
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, b
+}
+
+func TestHalfClose(t *testing.T) {
+	client, frontend := tcpPipe(t)
+	backendConn, backend := tcpPipe(t)
+	defer client.Close()
+	defer backend.Close()
+
+	done := make(chan error, 1)
+	s := &server{limits: Limits{Linger: time.Second}}
+	go func() {
+		done <- s.proxy(frontend, backendConn, nil, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	// The client finishes sending; the backend sees the end of it, and still answers.
+	roundTrip(t, "frontend", client, backend, msg('X'))
+	client.(*net.TCPConn).CloseWrite()
+
+	backend.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := backend.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected the client's half-close to be passed on; instead got %v", err)
+	}
+
+	notice := msg('A', u32(1), cstr("jobs"), cstr("done"))
+	roundTrip(t, "backend", backend, client, notice)
+	backend.Close()
+
+	if err := <-done; err != io.EOF {
+		t.Errorf("Expected the proxy to end with io.EOF; instead got %v", err)
+	}
+}
+
+func TestMySQLPassThrough(t *testing.T) {
+	client, frontend := net.Pipe()
+	backendConn, backend := net.Pipe()
+	defer client.Close()
+
+	drained := make(chan struct{})
+	done := make(chan error, 1)
+	s := &server{}
+	go func() {
+		done <- s.proxy(mysqlClient{frontend}, backendConn, drained, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	// The server speaks first, and the session isn't handed off once drained, as its
+	// transactions aren't seen.
+	roundTrip(t, "handshake", backend, client, append([]byte{10, 0, 0, 0, 10}, "8.0.36\x00"...))
+	close(drained)
+	roundTrip(t, "query", client, backend, append([]byte{9, 0, 0, 0, 3}, "select 1"...))
+
+	backend.Close()
+	if err := <-done; err != io.EOF {
+		t.Errorf("Expected the proxy to end with io.EOF; instead got %v", err)
+	}
+
+	// Clients turned away get a MySQL ERR packet.
+	rejected, conn := net.Pipe()
+	defer rejected.Close()
+	go func() {
+		writeFatal(mysqlClient{conn}, sqlstateTooManyConnections, "arbiter: too many client sessions")
+		conn.Close()
+	}()
+	packet, _ := io.ReadAll(rejected)
+	if len(packet) < 7 || int(packet[0]) != len(packet)-4 || packet[4] != 0xff ||
+		binary.LittleEndian.Uint16(packet[5:]) != mysqlTooManyConnections || !bytes.Contains(packet, []byte("#53300arbiter:")) {
+		t.Errorf("Expected a MySQL ERR packet; instead got %q", packet)
+	}
+}
+
+func FuzzProxy(f *testing.F) {
+	f.Add(sslRequest())
+	f.Add(startup("user", "app", "database", "app", "application_name", "psql"))
+	f.Add(msg('Q', cstr("select 1;")))
+	f.Add(msg('P', cstr(""), cstr("select $1::int"), u16(0)))
+	f.Add(msg('B', cstr(""), cstr(""), u16(0), u16(1), u32(1), []byte("1"), u16(0)))
+	f.Add(msg('d', []byte("1\t2\n")))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		client, backend, done := startProxy(t, nil)
+		roundTrip(t, "frontend", client, backend, data)
+		roundTrip(t, "backend", backend, client, data)
+
+		backend.Close()
+		<-done
+		client.Close()
+	})
+}
+
+// Start proxying between two in-memory connections, handing the session off once
+// drained is closed.  Returns the client's end, the backend's end, and a channel that
+// receives the result of proxy().
+func startProxy(t testing.TB, drained <-chan struct{}) (client, backend net.Conn, done chan error) {
+	client, frontend := net.Pipe()
+	backendConn, backend := net.Pipe()
+
+	done = make(chan error, 1)
+	s := &server{}
+	go func() {
+		done <- s.proxy(frontend, backendConn, drained, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	return client, backend, done
+}
+
+// Write b to from and ensure that it arrives unmodified at to.
+func roundTrip(t testing.TB, name string, from, to net.Conn, b []byte) {
+	go from.Write(b)
+
+	to.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(b))
+	if _, err := io.ReadFull(to, got); err != nil {
+		t.Fatalf("%s: Expected to read %d bytes; instead got %v", name, len(b), err)
+	}
+
+	if !bytes.Equal(got, b) {
+		t.Fatalf("%s: Expected the proxy to pass %q through; instead got %q", name, b, got)
+	}
+}
+
+// Helpers for encoding protocol messages.
+
+func msg(typ byte, fields ...[]byte) []byte {
+	body := bytes.Join(fields, nil)
+	return append(append([]byte{typ}, u32(uint32(len(body)+4))...), body...)
+}
+
+func startup(params ...string) []byte {
+	body := u32(196608) // Protocol 3.0
+	for _, p := range params {
+		body = append(body, cstr(p)...)
+	}
+	body = append(body, 0)
+
+	return append(u32(uint32(len(body)+4)), body...)
+}
+
+func sslRequest() []byte {
+	return append(u32(8), u32(80877103)...)
+}
+
+func cstr(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func u16(n uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, n)
+	return b
+}
+
+func u32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
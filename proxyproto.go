@@ -0,0 +1,268 @@
+package arbiter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How listeners take the PROXY protocol header from load balancers, and which version
+// of it is sent to backends; see [main] proxy-protocol and send-proxy-protocol.
+const (
+	proxyNone   = "none"
+	proxyAccept = "accept"
+	proxyV1     = "v1"
+	proxyV2     = "v2"
+)
+
+// The longest header of version 1 of the PROXY protocol, CRLF included.
+const proxyV1MaxLength = 107
+
+// What headers of version 2 of the PROXY protocol start with.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// ProxySettings say whether a listener takes the PROXY protocol header from a load
+// balancer in front of it, and which version of the header is sent to the backends
+// its sessions are routed to, so that they see the client's address rather than
+// arbiter's.
+type ProxySettings struct {
+	ProxyProtocol     string `gcfg:"proxy-protocol"`
+	SendProxyProtocol string `gcfg:"send-proxy-protocol"`
+}
+
+// proxyConfig is what ProxySettings resolve to.
+type proxyConfig struct {
+	accept bool
+
+	// proxyV1, proxyV2, or empty not to send the header.
+	send string
+}
+
+// Return s with the settings it leaves out taken from defaults.
+func (s ProxySettings) inherit(defaults ProxySettings) ProxySettings {
+	if s.ProxyProtocol == "" {
+		s.ProxyProtocol = defaults.ProxyProtocol
+	}
+	if s.SendProxyProtocol == "" {
+		s.SendProxyProtocol = defaults.SendProxyProtocol
+	}
+
+	return s
+}
+
+// Resolve s, checking that it's valid.
+func (s ProxySettings) config() (pc proxyConfig, err error) {
+	switch s.ProxyProtocol {
+	case "", proxyNone:
+	case proxyAccept:
+		pc.accept = true
+	default:
+		return pc, fmt.Errorf("invalid proxy-protocol '%s'; expected %s or %s", s.ProxyProtocol, proxyNone, proxyAccept)
+	}
+
+	switch s.SendProxyProtocol {
+	case "", proxyNone:
+	case proxyV1, proxyV2:
+		pc.send = s.SendProxyProtocol
+	default:
+		return pc, fmt.Errorf("invalid send-proxy-protocol '%s'; expected %s, %s or %s",
+			s.SendProxyProtocol, proxyNone, proxyV1, proxyV2)
+	}
+
+	return pc, nil
+}
+
+// proxiedConn is the connection of a client through a load balancer that announced it
+// with the PROXY protocol header: its addresses are those of the header, and what
+// was read past the header is read first.
+type proxiedConn struct {
+	net.Conn
+	r             *bufio.Reader
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *proxiedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// Read the PROXY protocol header, of version 1 or 2, that a load balancer starts conn
+// with, returning the connection to proxy from, whose addresses are the client's and
+// the one it connected to.  Those of headers that don't proxy a TCP connection, as of
+// the load balancer's own health checks, are left as they are.
+func acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+	pc := &proxiedConn{Conn: conn, r: r, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+
+	// No more than is sure to be sent is peeked, so that clients that don't send the
+	// header, such as with an 8-byte SSLRequest, are turned away right away.
+	start, err := r.Peek(6)
+	if err != nil {
+		return nil, err
+	}
+
+	var src, dst *net.TCPAddr
+	switch {
+	case bytes.Equal(start, proxyV2Signature[:6]):
+		src, dst, err = readProxyV2(r)
+	case bytes.Equal(start, []byte("PROXY ")):
+		src, dst, err = readProxyV1(r)
+	default:
+		err = fmt.Errorf("%w: expected one from the load balancer", errProxyHeader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if src != nil {
+		pc.remote, pc.local = src, dst
+	}
+
+	return pc, nil
+}
+
+// Read a header of version 1, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 51234 6432\r\n",
+// returning nil addresses for PROXY UNKNOWN.
+func readProxyV1(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		if line = append(line, b); len(line) > proxyV1MaxLength {
+			return nil, nil, fmt.Errorf("%w: longer than %d bytes", errProxyHeader, proxyV1MaxLength)
+		}
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+	}
+
+	addr := func(host, port string) *net.TCPAddr {
+		ip := net.ParseIP(host)
+		p, err := strconv.ParseUint(port, 10, 16)
+		if ip == nil || err != nil {
+			return nil
+		}
+		return &net.TCPAddr{IP: ip, Port: int(p)}
+	}
+	if src, dst = addr(fields[2], fields[4]), addr(fields[3], fields[5]); src == nil || dst == nil {
+		return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+	}
+
+	return src, dst, nil
+}
+
+// Read a header of version 2, returning nil addresses unless it proxies TCP over IPv4
+// or IPv6.  TLVs are skipped.
+func readProxyV2(r *bufio.Reader) (src, dst *net.TCPAddr, err error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, nil, fmt.Errorf("%w: bad signature", errProxyHeader)
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: version %d", errProxyHeader, hdr[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL connections are the load balancer's own.
+	if hdr[12]&0xf == 0 {
+		return nil, nil, nil
+	}
+
+	var size int
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: addresses truncated", errProxyHeader)
+	}
+
+	src = &net.TCPAddr{IP: net.IP(body[:size]), Port: int(binary.BigEndian.Uint16(body[2*size:]))}
+	dst = &net.TCPAddr{IP: net.IP(body[size : 2*size]), Port: int(binary.BigEndian.Uint16(body[2*size+2:]))}
+
+	return src, dst, nil
+}
+
+// Write the PROXY protocol header of version to w, announcing a connection from src to
+// dst.  Unless both are TCP addresses, the header announces an unknown, or local,
+// connection, which backends take as coming from arbiter.
+func writeProxyHeader(w io.Writer, version string, src, dst net.Addr) error {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	known := sok && dok
+	v4 := known && s.IP.To4() != nil && d.IP.To4() != nil
+
+	if version == proxyV1 {
+		line := "PROXY UNKNOWN\r\n"
+		switch {
+		case v4:
+			line = fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", s.IP.To4(), d.IP.To4(), s.Port, d.Port)
+		case known:
+			line = fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", s.IP.To16(), d.IP.To16(), s.Port, d.Port)
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	}
+
+	b := append([]byte{}, proxyV2Signature...)
+	var body []byte
+	switch {
+	case v4:
+		b = append(b, 0x21, 0x11)
+		body = append(append(body, s.IP.To4()...), d.IP.To4()...)
+	case known:
+		b = append(b, 0x21, 0x21)
+		body = append(append(body, s.IP.To16()...), d.IP.To16()...)
+	default:
+		b = append(b, 0x20, 0x00)
+	}
+	if known {
+		body = binary.BigEndian.AppendUint16(body, uint16(s.Port))
+		body = binary.BigEndian.AppendUint16(body, uint16(d.Port))
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+
+	_, err := w.Write(append(b, body...))
+	return err
+}
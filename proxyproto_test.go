@@ -0,0 +1,156 @@
+package arbiter
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 6432}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 6432}
+	unix := &net.UnixAddr{Name: "/tmp/.s.PGSQL.6432", Net: "unix"}
+
+	for _, c := range []struct {
+		version  string
+		src, dst net.Addr
+		proxied  bool
+	}{
+		{proxyV1, src, dst, true},
+		{proxyV1, src6, dst6, true},
+		{proxyV1, unix, unix, false},
+		{proxyV2, src, dst, true},
+		{proxyV2, src6, dst6, true},
+		{proxyV2, unix, unix, false},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			writeProxyHeader(client, c.version, c.src, c.dst)
+			client.Write([]byte("startup"))
+		}()
+
+		conn, err := acceptProxyHeader(server)
+		if err != nil {
+			t.Fatalf("%s from %s: expected the header to be read; instead got %v", c.version, c.src, err)
+		}
+
+		remote, local := conn.RemoteAddr(), conn.LocalAddr()
+		if !c.proxied {
+			// The load balancer's own connection keeps its addresses.
+			remote, local = c.src, c.dst
+		}
+		if remote.String() != c.src.String() || local.String() != c.dst.String() {
+			t.Errorf("%s: expected %s -> %s; instead got %s -> %s", c.version, c.src, c.dst, remote, local)
+		}
+
+		b := make([]byte, len("startup"))
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "startup" {
+			t.Errorf("%s: expected what follows the header to be read; instead got %q, %v", c.version, b, err)
+		}
+		client.Close()
+		server.Close()
+	}
+
+	// Clients that don't come through a load balancer are turned away.
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("\x00\x00\x00\x08\x04\xd2\x16\x2f"))
+	if _, err := acceptProxyHeader(server); !errors.Is(err, errProxyHeader) {
+		t.Errorf("Expected a startup packet without the header to be rejected; instead got %v", err)
+	}
+}
+
+func TestProxySettings(t *testing.T) {
+	pc, err := ProxySettings{SendProxyProtocol: proxyV1}.inherit(ProxySettings{ProxyProtocol: proxyAccept, SendProxyProtocol: proxyV2}).config()
+	if err != nil || pc != (proxyConfig{accept: true, send: proxyV1}) {
+		t.Errorf("Expected the header to be accepted, and v1 sent; instead got %+v, %v", pc, err)
+	}
+
+	if pc, err = (ProxySettings{SendProxyProtocol: proxyNone}).config(); err != nil || pc != (proxyConfig{}) {
+		t.Errorf("Expected the header neither taken nor sent; instead got %+v, %v", pc, err)
+	}
+
+	for _, s := range []ProxySettings{{ProxyProtocol: "v2"}, {SendProxyProtocol: "v3"}} {
+		if _, err := s.config(); err == nil {
+			t.Errorf("Expected %+v to be rejected", s)
+		}
+	}
+}
+
+func FuzzReadProxyV1(f *testing.F) {
+	f.Add([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 51234 6432\r\n"))
+	f.Add([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 6432\r\n"))
+	f.Add([]byte("PROXY UNKNOWN\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		src, dst, err := readProxyV1(bufio.NewReader(bytes.NewReader(data)))
+		checkProxyHeader(t, proxyV1, src, dst, err)
+	})
+}
+
+func FuzzReadProxyV2(f *testing.F) {
+	for _, addrs := range [][2]net.Addr{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 6432}},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51234}, &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 6432}},
+		{&net.UnixAddr{Name: "/tmp/.s.PGSQL.6432", Net: "unix"}, nil},
+	} {
+		var b bytes.Buffer
+		writeProxyHeader(&b, proxyV2, addrs[0], addrs[1])
+		f.Add(b.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		src, dst, err := readProxyV2(bufio.NewReader(bytes.NewReader(data)))
+		checkProxyHeader(t, proxyV2, src, dst, err)
+	})
+}
+
+// Check that the addresses read from a header of version survive being written in one
+// again.
+func checkProxyHeader(t *testing.T, version string, src, dst *net.TCPAddr, err error) {
+	if err != nil || src == nil {
+		return
+	}
+
+	var b bytes.Buffer
+	if err := writeProxyHeader(&b, version, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := acceptProxyHeader(&bufferConn{Reader: &b})
+	if err != nil {
+		t.Fatalf("Expected %q to be read again; instead got %v", b.Bytes(), err)
+	}
+
+	remote, local := conn.RemoteAddr().(*net.TCPAddr), conn.LocalAddr().(*net.TCPAddr)
+	if !remote.IP.Equal(src.IP) || remote.Port != src.Port || !local.IP.Equal(dst.IP) || local.Port != dst.Port {
+		t.Fatalf("Expected %s -> %s once written again; instead got %s -> %s", src, dst, remote, local)
+	}
+}
+
+// bufferConn is a net.Conn reading from a buffer, for acceptProxyHeader().
+type bufferConn struct {
+	net.Conn
+	io.Reader
+}
+
+func (c *bufferConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
+
+func (c *bufferConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (c *bufferConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *bufferConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
@@ -0,0 +1,234 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errQueueFull = errors.New("session queue is full")
+
+// How often queued sessions are retried in case a state transition was missed.
+const queueRetryInterval = 250 * time.Millisecond
+
+// sessionQueue holds sessions that arrive while no backend satisfies their class, such
+// as the primary during a failover, and hands them leases once one does.  Sessions
+// are served FIFO for each user, and round-robin between users, so that a single busy
+// application can't starve the others when the queue drains.
+type sessionQueue struct {
+	pool    *pool.Pool
+	class   string
+	max     int64
+	timeout time.Duration
+
+	mu sync.Mutex
+
+	// Waiters by user, and the users with waiters in the order they're served.
+	waiters map[string][]*waiter
+	users   []string
+	n       int64
+
+	// Wakes the dispatcher when a session is queued.
+	kick chan struct{}
+}
+
+type waiter struct {
+	lease chan *pool.Lease
+}
+
+func newSessionQueue(p *pool.Pool, class string, max int64, timeout time.Duration) *sessionQueue {
+	return &sessionQueue{
+		pool:    p,
+		class:   class,
+		max:     max,
+		timeout: timeout,
+		waiters: make(map[string][]*waiter),
+		kick:    make(chan struct{}, 1),
+	}
+}
+
+// Queue a session of user, returning nil if the queue is full.
+func (q *sessionQueue) enqueue(user string) *waiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.n >= q.max {
+		return nil
+	}
+
+	w := &waiter{lease: make(chan *pool.Lease, 1)}
+	if len(q.waiters[user]) == 0 {
+		q.users = append(q.users, user)
+	}
+	q.waiters[user] = append(q.waiters[user], w)
+	q.n++
+
+	return w
+}
+
+// Return the waiter to serve next, or nil.  q must be locked.
+func (q *sessionQueue) next() *waiter {
+	if len(q.users) == 0 {
+		return nil
+	}
+
+	return q.waiters[q.users[0]][0]
+}
+
+// Take w out of the queue, returning whether it was in it.  If it's being served, its
+// user goes to the back of the line.  q must be locked.
+func (q *sessionQueue) remove(w *waiter, served bool) bool {
+	for i, user := range q.users {
+		ws := q.waiters[user]
+		for j := range ws {
+			if ws[j] != w {
+				continue
+			}
+
+			q.n--
+			if ws = append(ws[:j], ws[j+1:]...); len(ws) == 0 {
+				delete(q.waiters, user)
+				q.users = append(q.users[:i], q.users[i+1:]...)
+			} else {
+				q.waiters[user] = ws
+				if served {
+					q.users = append(append(q.users[:i], q.users[i+1:]...), user)
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wait for a lease for a session of user.  Returns errQueueFull if the queue is full,
+// and pool.ErrNoneAvailable if none was to be had within the queue timeout.
+func (q *sessionQueue) wait(user string) (*pool.Lease, error) {
+	w := q.enqueue(user)
+	if w == nil {
+		return nil, errQueueFull
+	}
+
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	select {
+	case l := <-w.lease:
+		return l, nil
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The dispatcher may have served us in the meantime.
+	if !q.remove(w, false) {
+		return <-w.lease, nil
+	}
+
+	return nil, pool.ErrNoneAvailable
+}
+
+// Hand out leases to queued sessions whenever a backend may have become routable,
+// until the process exits.
+func (q *sessionQueue) run() {
+	events := q.pool.Subscribe()
+	ticker := time.NewTicker(queueRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-events:
+		case <-ticker.C:
+		case <-q.kick:
+		}
+
+		q.dispatch()
+	}
+}
+
+// Serve queued sessions until none are left or no lease is to be had.
+func (q *sessionQueue) dispatch() {
+	for {
+		q.mu.Lock()
+		w := q.next()
+		q.mu.Unlock()
+
+		if w == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		l, err := q.pool.Acquire(ctx, q.class)
+		cancel()
+		if err != nil {
+			return
+		}
+
+		q.mu.Lock()
+		if q.remove(w, true) {
+			w.lease <- l
+		} else {
+			// The session gave up while we were connecting.
+			l.Release(nil)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Read the first packet a client sends, returning it along with the user it names if
+// it's a StartupMessage.  The packet must be replayed to the backend; see replayConn.
+func readStartup(conn net.Conn) (packet []byte, user string, err error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	hdr := make([]byte, 4)
+	if _, err = io.ReadFull(conn, hdr); err != nil {
+		return nil, "", err
+	}
+
+	length := binary.BigEndian.Uint32(hdr)
+	if length < 8 || length > 10000 {
+		return nil, "", errors.New("invalid startup packet length")
+	}
+
+	packet = make([]byte, length)
+	copy(packet, hdr)
+	if _, err = io.ReadFull(conn, packet[4:]); err != nil {
+		return nil, "", err
+	}
+
+	return packet, startupParameters(packet)["user"], nil
+}
+
+// replayConn is a net.Conn that returns the bytes already read from it before reading
+// further.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newReplayConn(conn net.Conn, read []byte) *replayConn {
+	return &replayConn{conn, io.MultiReader(bytes.NewReader(read), conn)}
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *replayConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
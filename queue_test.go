@@ -0,0 +1,124 @@
+package arbiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionQueueFairness(t *testing.T) {
+	q := newSessionQueue(nil, "strong", 4, time.Second)
+
+	a1, a2, b1 := q.enqueue("a"), q.enqueue("a"), q.enqueue("b")
+	c1 := q.enqueue("c")
+	if q.enqueue("d") != nil {
+		t.Fatalf("Expected the queue to be full")
+	}
+
+	// c gives up before being served.
+	q.remove(c1, false)
+
+	var order []*waiter
+	for w := q.next(); w != nil; w = q.next() {
+		order = append(order, w)
+		q.remove(w, true)
+	}
+
+	if len(order) != 3 || order[0] != a1 || order[1] != b1 || order[2] != a2 {
+		t.Fatalf("Expected the users to take turns; instead got %v", order)
+	}
+}
+
+func TestSessionQueueWait(t *testing.T) {
+	p := pool.New(context.Background(), pool.WithCheckInterval(10*time.Millisecond))
+	q := newSessionQueue(p, "strong", 10, 2*time.Second)
+	go q.run()
+
+	b := &queueBackend{}
+	p.Put(b)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := q.wait("app")
+			if err != nil {
+				t.Errorf("Expected to be handed a lease; instead got %v", err)
+				return
+			}
+			l.Release(nil)
+		}()
+	}
+
+	// Let the sessions queue up before the primary appears.
+	time.Sleep(100 * time.Millisecond)
+	b.promote()
+	wg.Wait()
+
+	q.timeout = 10 * time.Millisecond
+	p.Drain(b.Addr())
+	if _, err := q.wait("app"); err != pool.ErrNoneAvailable {
+		t.Fatalf("Expected the wait to time out; instead got %v", err)
+	}
+}
+
+// queueBackend is unavailable until it's promoted to primary.
+type queueBackend struct {
+	mu      sync.Mutex
+	primary bool
+}
+
+func (b *queueBackend) promote() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.primary = true
+}
+
+func (b *queueBackend) Ping() (pool.State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.primary {
+		return pool.UNAVAILABLE, net.ErrClosed
+	}
+	return pool.READ_WRITE, nil
+}
+
+func (b *queueBackend) Addr() string                              { return "127.0.0.1:5432" }
+func (b *queueBackend) Connect(time.Duration) (*pool.Conn, error) { return nil, nil }
+func (b *queueBackend) Fail()                                     {}
+
+// FuzzReadStartup checks that readStartup returns whole packets, and fails on anything
+// else without panicking.
+func FuzzReadStartup(f *testing.F) {
+	f.Add(startup("user", "app", "database", "app"))
+	f.Add(sslRequest())
+	f.Add(u32(0xffffffff))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		client, conn := net.Pipe()
+		defer conn.Close()
+		go func() {
+			client.Write(data)
+			client.Close()
+		}()
+
+		packet, user, err := readStartup(conn)
+		if err != nil {
+			return
+		}
+		if !bytes.HasPrefix(data, packet) || int(binary.BigEndian.Uint32(packet)) != len(packet) {
+			t.Fatalf("Expected a whole packet from the start of %q; instead got %q", data, packet)
+		}
+		if user != "" && !bytes.Contains(packet, append([]byte("user\x00"), user...)) {
+			t.Fatalf("Expected the user of %q; instead got %q", packet, user)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package arbiter
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+)
+
+// Reload the configuration file at path, with the overrides in sets, whenever arbiter
+// is sent SIGHUP.  c is the configuration arbiter was started with.  A file that
+// doesn't load is logged and left alone.
+func (s *server) reloadOnHangup(path string, sets []string, c *Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		next, err := LoadConfig(path, os.Environ(), sets)
+		if err != nil {
+			s.logger.Printf("Not reloading %s: %s", path, err)
+			continue
+		}
+
+		changes, err := s.reload(c, next)
+		if err != nil {
+			s.logger.Printf("Could not reload %s: %s", path, err)
+			continue
+		}
+
+		s.logger.Printf("Reloaded %s: %d changes", path, len(changes))
+		c = next
+	}
+}
+
+// Reconcile the pool with the configuration c, which replaces prev, as PUT /config
+// would: backends are added, removed, readdressed, relabeled, and replaced if their
+// health check settings changed, and classes are defined.  Changes to the rest of the
+// configuration, such as listeners and limits, take effect on restart, and are
+// logged as such.
+func (s *server) reload(prev, c *Config) ([]change, error) {
+	d := newDeclaration(c)
+	for _, discovered := range s.discoveries {
+		discovered.keep(d.state)
+	}
+	changes, err := s.apply(d.state)
+	if err != nil {
+		return changes, err
+	}
+
+	s.declared.Lock()
+	s.declared.settings = d.settings
+	s.declared.Unlock()
+
+	for name, b := range c.Backend {
+		s.pool.Expect(name, b.expect)
+	}
+
+	for _, what := range restartRequired(prev, c) {
+		s.logger.Printf("Config: %s changed; it takes effect on restart", what)
+	}
+
+	return changes, nil
+}
+
+// Return what changed from prev to c that isn't reloaded.
+func restartRequired(prev, c *Config) (changed []string) {
+	if prev.Main.Primary != c.Main.Primary {
+		changed = append(changed, "primary")
+	}
+	if prev.Main.Follower != c.Main.Follower {
+		changed = append(changed, "follower")
+	}
+	if prev.Admin.GRPCAddress != c.Admin.GRPCAddress {
+		changed = append(changed, "grpc-address")
+	}
+	if prev.Shadow != c.Shadow {
+		changed = append(changed, "[shadow]")
+	}
+	if prev.Fence != c.Fence {
+		changed = append(changed, "[fence]")
+	}
+	if prev.Promotion != c.Promotion {
+		changed = append(changed, "[promotion]")
+	}
+	if prev.Main.StickyReads != c.Main.StickyReads || prev.Main.stickyTTL != c.Main.stickyTTL {
+		changed = append(changed, "sticky-reads and sticky-ttl")
+	}
+
+	for name, l := range c.Listener {
+		if p := prev.Listener[name]; p == nil || p.Address != l.Address || p.Class != l.Class || p.Cluster != l.Cluster ||
+			p.TLSSettings != l.TLSSettings || p.ProxySettings != l.ProxySettings {
+			changed = append(changed, "listener "+name)
+		}
+	}
+	for name := range prev.Listener {
+		if c.Listener[name] == nil {
+			changed = append(changed, "listener "+name)
+		}
+	}
+
+	for name, cl := range c.Cluster {
+		if p := prev.Cluster[name]; p == nil || !reflect.DeepEqual(p.Backend, cl.Backend) ||
+			!reflect.DeepEqual(p.CheckSettings, cl.CheckSettings) || p.failover != cl.failover ||
+			p.PrimaryArbitration != cl.PrimaryArbitration || p.ClusterMode != cl.ClusterMode ||
+			p.FailoverJournal != cl.FailoverJournal || p.Engine != cl.Engine {
+			changed = append(changed, "cluster "+name)
+		}
+	}
+	for name := range prev.Cluster {
+		if c.Cluster[name] == nil {
+			changed = append(changed, "cluster "+name)
+		}
+	}
+
+	for name, r := range c.Rule {
+		if p := prev.Rule[name]; p == nil || *p != *r {
+			changed = append(changed, "rule "+name)
+		}
+	}
+	for name := range prev.Rule {
+		if c.Rule[name] == nil {
+			changed = append(changed, "rule "+name)
+		}
+	}
+
+	for name, a := range c.ACL {
+		if p := prev.ACL[name]; p == nil || !reflect.DeepEqual(p, a) {
+			changed = append(changed, "acl "+name)
+		}
+	}
+	for name := range prev.ACL {
+		if c.ACL[name] == nil {
+			changed = append(changed, "acl "+name)
+		}
+	}
+
+	if prev.Health.queryTimeout != c.Health.queryTimeout || prev.Health.jitter != c.Health.jitter {
+		changed = append(changed, "[health] query-timeout and jitter")
+	}
+	if prev.Health.checkBackoff != c.Health.checkBackoff {
+		changed = append(changed, "[health] check-backoff")
+	}
+	if prev.Health.CheckConcurrency != c.Health.CheckConcurrency {
+		changed = append(changed, "[health] check-concurrency")
+	}
+	if prev.Health.DownAfter != c.Health.DownAfter || prev.Health.UpAfter != c.Health.UpAfter {
+		changed = append(changed, "[health] down-after and up-after")
+	}
+	if prev.Health.FencingTable != c.Health.FencingTable || prev.Health.FencingCreate != c.Health.FencingCreate {
+		changed = append(changed, "[health] fencing-table and fencing-create")
+	}
+	if prev.Health.transientGrace != c.Health.transientGrace {
+		changed = append(changed, "[health] transient-grace")
+	}
+	if !reflect.DeepEqual(prev.Health.degradeOn, c.Health.degradeOn) {
+		changed = append(changed, "[health] degrade-on")
+	}
+	if prev.Health.AnomalyThreshold != c.Health.AnomalyThreshold || prev.Health.AnomalyWarmup != c.Health.AnomalyWarmup {
+		changed = append(changed, "[health] anomaly-threshold and anomaly-warmup")
+	}
+	if prev.Health.CheckHistory != c.Health.CheckHistory || !reflect.DeepEqual(prev.Health.checkWindows, c.Health.checkWindows) {
+		changed = append(changed, "[health] check-history and check-window")
+	}
+	if prev.Limits.MaxConnectionsPerBackend != c.Limits.MaxConnectionsPerBackend {
+		changed = append(changed, "[limits] max-connections-per-backend")
+	}
+	if prev.Autoscale.RebalancePercent != c.Autoscale.RebalancePercent ||
+		prev.Autoscale.RebalanceRate != c.Autoscale.RebalanceRate {
+		changed = append(changed, "[autoscale] rebalance-percent and rebalance-rate")
+	}
+	if prev.Main.failover != c.Main.failover {
+		changed = append(changed, "failover and failover-grace")
+	}
+	if prev.Main.PrimaryArbitration != c.Main.PrimaryArbitration {
+		changed = append(changed, "primary-arbitration")
+	}
+	if prev.Main.ClusterMode != c.Main.ClusterMode {
+		changed = append(changed, "cluster-mode")
+	}
+	if prev.Main.TLSSettings != c.Main.TLSSettings {
+		changed = append(changed, "tls, tls-cert and tls-key")
+	}
+	if prev.Main.ProxySettings != c.Main.ProxySettings {
+		changed = append(changed, "proxy-protocol and send-proxy-protocol")
+	}
+	if prev.Main.LogFormat != c.Main.LogFormat || prev.Main.logLevel != c.Main.logLevel {
+		changed = append(changed, "log-format and log-level")
+	}
+	if !reflect.DeepEqual(prev.Main.ClientZone, c.Main.ClientZone) || prev.Main.Zone != c.Main.Zone {
+		changed = append(changed, "client-zone and zone")
+	}
+	if !reflect.DeepEqual(prev.Limits, c.Limits) {
+		changed = append(changed, "[limits]")
+	}
+	if prev.Consul != c.Consul {
+		changed = append(changed, "[consul]")
+	}
+	if !reflect.DeepEqual(prev.DNS, c.DNS) {
+		changed = append(changed, "[dns]")
+	}
+	if prev.Aurora != c.Aurora {
+		changed = append(changed, "[aurora]")
+	}
+	if !reflect.DeepEqual(prev.Replication, c.Replication) {
+		changed = append(changed, "[replication]")
+	}
+	if prev.Kubernetes != c.Kubernetes {
+		changed = append(changed, "[kubernetes]")
+	}
+	if prev.Metrics.Statsd != c.Metrics.Statsd || prev.Metrics.StatsdPrefix != c.Metrics.StatsdPrefix ||
+		prev.Metrics.statsdInterval != c.Metrics.statsdInterval {
+		changed = append(changed, "[metrics] statsd")
+	}
+	if prev.Tracing != c.Tracing {
+		changed = append(changed, "[tracing]")
+	}
+	if prev.Vault.Address != c.Vault.Address || prev.Vault.TokenFile != c.Vault.TokenFile {
+		changed = append(changed, "[vault]")
+	}
+	if prev.Admin.Debug != c.Admin.Debug {
+		changed = append(changed, "[admin] debug")
+	}
+	if !reflect.DeepEqual(prev.Hooks, c.Hooks) {
+		changed = append(changed, "[hooks]")
+	}
+	if !reflect.DeepEqual(prev.Alerts, c.Alerts) {
+		changed = append(changed, "[alerts]")
+	}
+	if prev.Watchdog != c.Watchdog {
+		changed = append(changed, "[watchdog]")
+	}
+	if prev.Daemon != c.Daemon {
+		changed = append(changed, "[daemon]")
+	}
+
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,92 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared:         &declaration{},
+	}
+
+	actions := func(changes []change) string {
+		var ret []string
+		for _, c := range changes {
+			ret = append(ret, c.Action+" "+c.Target)
+		}
+		return strings.Join(ret, ",")
+	}
+
+	changes, err := s.reload(c, c)
+	if got, want := actions(changes), "add pg1:5432,add pg2:5432,add pg3,label pg3,promotion pg3,define bounded-1s"; err != nil || got != want {
+		t.Fatalf("Expected the first load to make %s; instead got %s, %v", want, got, err)
+	}
+
+	changes, err = s.reload(c, c)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Expected reloading an unchanged file to change nothing; instead got %s, %v", actions(changes), err)
+	}
+
+	next, err := LoadConfig("./config.ini", nil, []string{
+		"main.backends=pg1:5432", "backend.pg3.interval=10s", "listener.bounded.address=127.0.0.1:5436"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err = s.reload(c, next)
+	if got, want := actions(changes), "remove pg2:5432,reconfigure pg3"; err != nil || got != want {
+		t.Fatalf("Expected the reload to make %s; instead got %s, %v", want, got, err)
+	}
+
+	if b := s.declared.state.Backends["pg3"]; b.settings.CheckInterval != 10*time.Second {
+		t.Errorf("Expected pg3 to be checked every 10s; instead got %s", b.settings.CheckInterval)
+	}
+
+	// A password read from the environment is reread, and rotating it replaces the
+	// backends checked with it.
+	t.Setenv("ARBITER_TEST_PASSWORD", "old")
+	sets := []string{"main.backends=pg1:5432", "backend.pg3.interval=10s", "listener.bounded.address=127.0.0.1:5436",
+		"health.password=", "health.password-env=ARBITER_TEST_PASSWORD"}
+	env, err := LoadConfig("./config.ini", nil, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, err = s.reload(next, env); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ARBITER_TEST_PASSWORD", "rotated")
+	rotated, err := LoadConfig("./config.ini", nil, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = s.reload(env, rotated)
+	if got, want := actions(changes), "reconfigure pg1:5432,reconfigure pg3"; err != nil || got != want {
+		t.Errorf("Expected the rotated password to make %s; instead got %s, %v", want, got, err)
+	}
+
+	if got, want := strings.Join(restartRequired(c, next), ","), "listener bounded"; got != want {
+		t.Errorf("Expected %s to require a restart; instead got %s", want, got)
+	}
+
+	clustered, err := LoadConfig("./config.ini", nil, []string{"cluster.billing.backend=10.1.0.1:5432"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Cluster = clustered.Cluster
+	if got, want := strings.Join(restartRequired(c, next), ","), "cluster billing,listener bounded"; got != want {
+		t.Errorf("Expected %s to require a restart; instead got %s", want, got)
+	}
+}
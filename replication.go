@@ -0,0 +1,148 @@
+package arbiter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"time"
+)
+
+// replicationNode is what a server of the replication topology says of itself.
+type replicationNode struct {
+	state pool.State
+
+	// The servers streaming WAL from it, and, if it's a follower, the address of the
+	// server it streams from.
+	downstreams []pool.Downstream
+	upstream    string
+}
+
+// replicationWatcher keeps the backends in sync with the replication topology, given
+// the address of the primary, or of any server streaming from it: the primary is
+// found by following pg_stat_wal_receiver up from the seeds, or from the servers last
+// discovered, and its followers, and theirs in turn, by following pg_stat_replication
+// down.  Each server is a backend named after its address, at the port of the seeds,
+// so that its name survives failovers.  While no primary can be found, as between a
+// primary failing and a follower being promoted, the servers last discovered are kept.
+type replicationWatcher struct {
+	*discovery
+	seeds    []string
+	port     string
+	interval time.Duration
+
+	survey func(addr string) (replicationNode, error)
+}
+
+func newReplicationWatcher(s *server, c *Config) *replicationWatcher {
+	_, port, _ := net.SplitHostPort(c.Replication.Seed[0])
+	return &replicationWatcher{
+		discovery: newDiscovery(s, "Replication"),
+		seeds:     c.Replication.Seed,
+		port:      port,
+		interval:  c.Replication.interval,
+		survey: func(addr string) (n replicationNode, err error) {
+			b := pool.NewPostgresBackendWithSettings([]string{addr}, c.Health.settings)
+			defer b.Close()
+
+			if n.state, err = b.Ping(); err != nil {
+				return n, err
+			}
+			if n.downstreams, err = b.Downstreams(); err != nil {
+				return n, err
+			}
+			if n.state == pool.READ_ONLY {
+				n.upstream, err = b.Upstream()
+			}
+			return n, err
+		},
+	}
+}
+
+// Discover the topology every interval until the process exits.
+func (rw *replicationWatcher) run() {
+	for {
+		if err := rw.discover(); err != nil {
+			rw.s.logger.Printf("Replication: could not sync: %s", err)
+		}
+		time.Sleep(rw.interval)
+	}
+}
+
+// Walk the topology from the primary, and reconcile the declared state with it.
+func (rw *replicationWatcher) discover() error {
+	addrs := rw.seeds
+	rw.mu.Lock()
+	for _, b := range rw.discovered {
+		addrs = append(addrs[:len(addrs):len(addrs)], b.Address[0])
+	}
+	rw.mu.Unlock()
+
+	primary, node, err := rw.findPrimary(addrs)
+	if err != nil {
+		rw.s.logger.Printf("Replication: could not find the primary; keeping the servers last discovered: %s", err)
+		return nil
+	}
+
+	discovered := map[string]*desiredBackend{primary: {Address: []string{primary}}}
+	queue := []replicationNode{node}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for _, d := range n.downstreams {
+			addr := net.JoinHostPort(d.Host, rw.port)
+			if discovered[addr] != nil {
+				continue
+			}
+			discovered[addr] = &desiredBackend{Address: []string{addr}}
+
+			// A follower that can't be surveyed is still a backend, just one whose
+			// cascading standbys aren't known.
+			if n, err := rw.survey(addr); err == nil {
+				queue = append(queue, n)
+			} else {
+				rw.s.logger.Printf("Replication: could not list the standbys of %s: %s", addr, err)
+			}
+		}
+	}
+
+	return rw.update(discovered)
+}
+
+// Return the address of the primary, and what it says of itself: the first of addrs
+// that's the primary, so that it keeps the name it was discovered under, or else the
+// one the followers among them stream from, directly or through cascading standbys.
+func (rw *replicationWatcher) findPrimary(addrs []string) (string, replicationNode, error) {
+	lastErr := errors.New("none of the servers streams from a primary")
+	var followers []replicationNode
+	for _, addr := range addrs {
+		n, err := rw.survey(addr)
+		switch {
+		case err != nil:
+			lastErr = fmt.Errorf("%s: %w", addr, err)
+		case n.state == pool.READ_WRITE:
+			return addr, n, nil
+		case n.upstream != "":
+			followers = append(followers, n)
+		}
+	}
+
+	for _, n := range followers {
+		seen := make(map[string]bool)
+		for addr := n.upstream; addr != "" && !seen[addr]; {
+			seen[addr] = true
+			up, err := rw.survey(addr)
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", addr, err)
+				break
+			}
+			if up.state == pool.READ_WRITE {
+				return addr, up, nil
+			}
+			addr = up.upstream
+		}
+	}
+
+	return "", replicationNode{}, lastErr
+}
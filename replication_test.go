@@ -0,0 +1,79 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicationWatcher(t *testing.T) {
+	s := &server{
+		pool:             pool.New(context.Background()),
+		perBackendLabels: newBackendLabels(),
+		logger:           log.Default(),
+		declared:         &declaration{state: desiredState{Backends: map[string]*desiredBackend{}}},
+	}
+
+	c := &Config{}
+	c.Replication.Seed = []string{"10.0.0.1:5432"}
+	c.Replication.interval = time.Second
+	rw := newReplicationWatcher(s, c)
+
+	// pg1 is the primary, pg2 follows it, and pg3 follows pg2.
+	nodes := map[string]replicationNode{
+		"10.0.0.1:5432": {state: pool.READ_WRITE, downstreams: []pool.Downstream{{Name: "pg2", Host: "10.0.0.2"}}},
+		"10.0.0.2:5432": {state: pool.READ_ONLY, upstream: "10.0.0.1:5432", downstreams: []pool.Downstream{{Name: "pg3", Host: "10.0.0.3"}}},
+		"10.0.0.3:5432": {state: pool.READ_ONLY, upstream: "10.0.0.2:5432"},
+	}
+	rw.survey = func(addr string) (replicationNode, error) {
+		n, ok := nodes[addr]
+		if !ok {
+			return n, errors.New("connection refused")
+		}
+		return n, nil
+	}
+
+	backends := func() string {
+		var names []string
+		s.pool.ForEach(func(b pool.BackendInfo) bool {
+			names = append(names, b.Name)
+			return true
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+	discover := func(want string) {
+		t.Helper()
+		if err := rw.discover(); err != nil {
+			t.Fatal(err)
+		}
+		if got := backends(); got != want {
+			t.Fatalf("Expected backends %s; instead got %s", want, got)
+		}
+	}
+
+	discover("10.0.0.1:5432,10.0.0.2:5432,10.0.0.3:5432")
+
+	// While pg1 is down and nothing has been promoted, the servers are kept.
+	delete(nodes, "10.0.0.1:5432")
+	nodes["10.0.0.2:5432"] = replicationNode{state: pool.READ_ONLY, downstreams: nodes["10.0.0.2:5432"].downstreams}
+	discover("10.0.0.1:5432,10.0.0.2:5432,10.0.0.3:5432")
+
+	// Once pg2 is promoted, pg1 is dropped, and pg2 keeps its name.
+	nodes["10.0.0.2:5432"] = replicationNode{state: pool.READ_WRITE, downstreams: nodes["10.0.0.2:5432"].downstreams}
+	discover("10.0.0.2:5432,10.0.0.3:5432")
+
+	// A seed that's a follower leads to the primary through its upstream.
+	rw.discovered = nil
+	rw.seeds = []string{"10.0.0.3:5432"}
+	discover("10.0.0.2:5432,10.0.0.3:5432")
+
+	if _, err := LoadConfig("./config.ini", nil, []string{"replication.seed=10.0.0.1"}); err == nil {
+		t.Errorf("Expected a seed without a port to be rejected")
+	}
+}
@@ -0,0 +1,122 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"time"
+)
+
+// Bounds of the delay between attempts to reach the collector after a failure.
+const (
+	minReportBackoff = time.Second
+	maxReportBackoff = time.Minute
+)
+
+// reporter pushes periodic summaries of the state of arbiter, and every state
+// transition as it happens, to a central collector over HTTP.  This gives fleets of
+// arbiters a single pane of glass without the collector having to scrape each one.
+type reporter struct {
+	s        *server
+	url      string
+	token    string
+	interval time.Duration
+	client   *http.Client
+}
+
+// report is the JSON document POSTed to the collector.  Kind is either "summary", in
+// which case Stats is set, or "event", in which case Event is set.
+type report struct {
+	Kind   string         `json:"kind"`
+	Time   time.Time      `json:"time"`
+	Labels metrics.Labels `json:"labels,omitempty"`
+	Stats  *stats         `json:"stats,omitempty"`
+	Event  *pool.Event    `json:"event,omitempty"`
+}
+
+func newReporter(s *server, url, token string, interval time.Duration) *reporter {
+	return &reporter{
+		s:        s,
+		url:      url,
+		token:    token,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Push reports until the process exits.  While the collector is unreachable, summaries
+// are skipped and events queue up in the pool's subscription buffer.
+func (r *reporter) run() {
+	events := r.s.pool.Subscribe()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	backoff := minReportBackoff
+	for {
+		var rep report
+		select {
+		case <-ticker.C:
+			st := r.s.stats()
+			rep = report{Kind: "summary", Stats: &st}
+		case e := <-events:
+			rep = report{Kind: "event", Event: &e}
+		}
+		rep.Time = time.Now()
+		rep.Labels = r.s.labels
+
+		for {
+			err := r.send(rep)
+			if err == nil {
+				backoff = minReportBackoff
+				break
+			}
+
+			r.s.logger.Printf("Could not report to %s; retrying in %s: %s", r.url, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxReportBackoff {
+				backoff = maxReportBackoff
+			}
+
+			if rep.Kind == "summary" {
+				// A fresh summary will be sent on the next tick.
+				break
+			}
+		}
+	}
+}
+
+func (r *reporter) send(rep report) error {
+	return postJSON(r.client, r.url, r.token, rep)
+}
+
+// POST v as JSON to url, with token as a bearer token unless it's empty.
+func postJSON(client *http.Client, url, token string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("responded with %s", resp.Status)
+	}
+
+	return nil
+}
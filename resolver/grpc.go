@@ -0,0 +1,147 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	grpcresolver "google.golang.org/grpc/resolver"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the scheme of the gRPC targets Builder resolves.
+const Scheme = "arbiter"
+
+// Builder resolves gRPC targets of the form arbiter:///class to the backends arbiter
+// would route the class to, polling it every Interval, so that gRPC balances over the
+// backends that are healthy right now:
+//
+//	grpcresolver.Register(&resolver.Builder{Resolver: resolver.New("http://arbiter:8080")})
+//	conn, err := grpc.Dial("arbiter:///eventual", grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin": {}}]}`))
+//
+// A target of the form arbiter://host:port/class asks the arbiter at host:port instead.
+type Builder struct {
+	Resolver *Resolver
+
+	// How often backends are resolved; 5s if zero.
+	Interval time.Duration
+}
+
+// Scheme implements grpc/resolver.Builder.
+func (b *Builder) Scheme() string {
+	return Scheme
+}
+
+// Build implements grpc/resolver.Builder.
+func (b *Builder) Build(target grpcresolver.Target, cc grpcresolver.ClientConn, opts grpcresolver.BuildOptions) (grpcresolver.Resolver, error) {
+	class := strings.TrimPrefix(target.URL.Path, "/")
+	if class == "" {
+		return nil, fmt.Errorf("arbiter: no class in target %s", target.URL.String())
+	}
+
+	r := b.Resolver
+	if target.URL.Host != "" {
+		r = &Resolver{URL: (&url.URL{Scheme: "http", Host: target.URL.Host}).String()}
+		if b.Resolver != nil {
+			r.Client = b.Resolver.Client
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("arbiter: no arbiter to resolve %s against", target.URL.String())
+	}
+
+	interval := b.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{
+		r:        r,
+		class:    class,
+		cc:       cc,
+		interval: interval,
+		now:      make(chan struct{}, 1),
+		cancel:   cancel,
+	}
+	w.wg.Add(1)
+	go w.watch(ctx)
+
+	return w, nil
+}
+
+// watcher resolves a class every interval, and whenever gRPC asks it to, updating the
+// client connection when its backends change.
+type watcher struct {
+	r        *Resolver
+	class    string
+	cc       grpcresolver.ClientConn
+	interval time.Duration
+
+	now    chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (w *watcher) watch(ctx context.Context) {
+	defer w.wg.Done()
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+
+	var last []string
+	for {
+		addrs, err := w.r.LookupClass(ctx, w.class)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			w.cc.ReportError(err)
+		case len(addrs) == 0:
+			// Keep the backends last resolved rather than failing every call.
+			w.cc.ReportError(fmt.Errorf("arbiter: no backends available for %s", w.class))
+		case !equal(addrs, last):
+			state := grpcresolver.State{Addresses: make([]grpcresolver.Address, len(addrs))}
+			for i, addr := range addrs {
+				state.Addresses[i] = grpcresolver.Address{Addr: addr}
+			}
+			if err := w.cc.UpdateState(state); err == nil {
+				last = addrs
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-w.now:
+		}
+	}
+}
+
+// ResolveNow implements grpc/resolver.Resolver.
+func (w *watcher) ResolveNow(grpcresolver.ResolveNowOptions) {
+	select {
+	case w.now <- struct{}{}:
+	default:
+	}
+}
+
+// Close implements grpc/resolver.Resolver.
+func (w *watcher) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+// resolver resolves the backends arbiter would route a class of callers to, so that
+// client applications can balance their connections themselves, informed by arbiter's
+// health checks, rather than proxying their traffic through it.
+//
+// Lookups ask arbiter's HTTP API:
+//
+//	r := resolver.New("http://arbiter:8080")
+//	addrs, err := r.LookupClass(ctx, "eventual")
+//
+// gRPC clients can resolve targets of the arbiter scheme instead; see Builder.
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Backend is a backend a class resolves to.
+type Backend struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// Resolver looks up classes in the arbiter at URL.
+type Resolver struct {
+	// The base URL of arbiter's HTTP API, such as http://arbiter:8080.
+	URL string
+
+	Client *http.Client
+}
+
+// New returns a Resolver of the arbiter at url.
+func New(url string) *Resolver {
+	return &Resolver{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Lookup returns the backends a caller of class may be routed to right now, closest
+// first.  None are returned when none are available.
+func (r *Resolver) Lookup(ctx context.Context, class string) ([]Backend, error) {
+	u := strings.TrimRight(r.URL, "/") + "/resolve?class=" + url.QueryEscape(class)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("resolving %s: %s: %s", class, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var v struct {
+		Backends []Backend `json:"backends"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("resolving %s: %s", class, err)
+	}
+
+	return v.Backends, nil
+}
+
+// LookupClass returns the addresses of the backends a caller of class may be routed
+// to right now, closest first, like net.Resolver's lookups.
+func (r *Resolver) LookupClass(ctx context.Context, class string) ([]string, error) {
+	backends, err := r.Lookup(ctx, class)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(backends))
+	for i, b := range backends {
+		addrs[i] = b.Addr
+	}
+
+	return addrs, nil
+}
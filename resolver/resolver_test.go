@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	grpcresolver "google.golang.org/grpc/resolver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// arbiter serves /resolve from backends, keyed by class.
+type arbiter struct {
+	sync.Mutex
+	backends map[string][]Backend
+}
+
+func (a *arbiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+
+	class := req.FormValue("class")
+	backends, ok := a.backends[class]
+	if !ok {
+		http.Error(w, class+": no such class", http.StatusNotFound)
+		return
+	}
+
+	fmt.Fprintf(w, `{"class": %q, "backends": [`, class)
+	for i, b := range backends {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprintf(w, `{"name": %q, "addr": %q}`, b.Name, b.Addr)
+	}
+	fmt.Fprint(w, "]}")
+}
+
+func (a *arbiter) set(class string, backends ...Backend) {
+	a.Lock()
+	defer a.Unlock()
+	a.backends[class] = backends
+}
+
+func TestLookupClass(t *testing.T) {
+	a := &arbiter{backends: make(map[string][]Backend)}
+	a.set("eventual", Backend{"pg2", "10.0.0.2:5432"}, Backend{"pg1", "10.0.0.1:5432"})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	r := New(srv.URL)
+	addrs, err := r.LookupClass(context.Background(), "eventual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(addrs, []string{"10.0.0.2:5432", "10.0.0.1:5432"}) {
+		t.Errorf("Expected pg2 and pg1, closest first; instead got %v", addrs)
+	}
+
+	if _, err := r.LookupClass(context.Background(), "nonesuch"); err == nil {
+		t.Errorf("Expected an unknown class not to be resolved")
+	}
+}
+
+// clientConn records the states and errors a resolver reports.
+type clientConn struct {
+	grpcresolver.ClientConn
+
+	states chan grpcresolver.State
+	errs   chan error
+}
+
+func (cc *clientConn) UpdateState(s grpcresolver.State) error {
+	cc.states <- s
+	return nil
+}
+
+func (cc *clientConn) ReportError(err error) {
+	cc.errs <- err
+}
+
+func TestBuilder(t *testing.T) {
+	a := &arbiter{backends: make(map[string][]Backend)}
+	a.set("eventual", Backend{"pg1", "10.0.0.1:5432"})
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	cc := &clientConn{states: make(chan grpcresolver.State, 10), errs: make(chan error, 10)}
+	b := &Builder{Resolver: New(srv.URL), Interval: time.Hour}
+	target := grpcresolver.Target{URL: url.URL{Scheme: Scheme, Path: "/eventual"}}
+	r, err := b.Build(target, cc, grpcresolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	addrs := func(s grpcresolver.State) []string {
+		var addrs []string
+		for _, a := range s.Addresses {
+			addrs = append(addrs, a.Addr)
+		}
+		return addrs
+	}
+
+	select {
+	case s := <-cc.states:
+		if !equal(addrs(s), []string{"10.0.0.1:5432"}) {
+			t.Errorf("Expected to resolve pg1; instead got %v", addrs(s))
+		}
+	case err := <-cc.errs:
+		t.Fatalf("Expected to resolve; instead got %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected to resolve on build")
+	}
+
+	// pg2 comes online, which is resolved when gRPC asks.
+	a.set("eventual", Backend{"pg1", "10.0.0.1:5432"}, Backend{"pg2", "10.0.0.2:5432"})
+	r.ResolveNow(grpcresolver.ResolveNowOptions{})
+	select {
+	case s := <-cc.states:
+		if !equal(addrs(s), []string{"10.0.0.1:5432", "10.0.0.2:5432"}) {
+			t.Errorf("Expected to resolve pg1 and pg2; instead got %v", addrs(s))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected to resolve again when asked")
+	}
+
+	// With no backends available, those last resolved are kept.
+	a.set("eventual")
+	r.ResolveNow(grpcresolver.ResolveNowOptions{})
+	select {
+	case <-cc.errs:
+	case s := <-cc.states:
+		t.Errorf("Expected an error; instead got %v", addrs(s))
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an error when no backends are available")
+	}
+
+	if _, err := b.Build(grpcresolver.Target{URL: url.URL{Scheme: Scheme}}, cc, grpcresolver.BuildOptions{}); err == nil {
+		t.Errorf("Expected a target without a class not to be built")
+	}
+}
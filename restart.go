@@ -0,0 +1,121 @@
+package arbiter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Set in the environment of the arbiter started to take over from this one: the names
+// of the listeners whose sockets it's handed, colon-separated, in the order of the
+// file descriptors they're at from 3 on, followed by the pipe it closes once serving.
+const takeoverEnv = "ARBITER_LISTEN_FDS"
+
+// How long arbiter waits for the arbiter it hands over to to serve, which it does once
+// it has checked its backends, before giving up and carrying on.
+const handoverTimeout = startupCheckTimeout + 30*time.Second
+
+// Start the executable exe with args to take over from arbiter, handing it the
+// sockets of the HTTP interface, of the Control service, if it's served, and of the
+// listeners, and return its process once it's serving clients on them, when arbiter
+// can stop accepting clients and let its sessions finish; both accept clients in the
+// meantime.  The new arbiter is killed if
+// it doesn't serve within handoverTimeout.
+func (s *server) handOver(exe string, args []string) (*os.Process, error) {
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	handed := append([]namedListener{{"http", s.httpListener}}, s.listeners...)
+	if s.grpcListener != nil {
+		handed = append(handed, namedListener{"grpc", s.grpcListener})
+	}
+	for _, l := range handed {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("the socket of the %s listener can't be handed over", l.name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, l.name)
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(handoverEnviron(), takeoverEnv+"="+strings.Join(names, ":"))
+	cmd.ExtraFiles = append(files, w)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.SetReadDeadline(time.Now().Add(handoverTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err == io.EOF {
+			return nil, fmt.Errorf("process %d exited before serving", cmd.Process.Pid)
+		}
+		return nil, fmt.Errorf("process %d did not serve within %s", cmd.Process.Pid, handoverTimeout)
+	}
+
+	return cmd.Process, nil
+}
+
+// Return arbiter's environment without $WATCHDOG_PID, so that the arbiter it hands over
+// to, which is to be systemd's main process, pings the watchdog.
+func handoverEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// takeOver returns the sockets handed over by the arbiter that started this one to take
+// over from it, from the file descriptor first on, and the pipe to tell it on once
+// serving; none unless it did.  $ARBITER_LISTEN_FDS is unset, so that this arbiter
+// hands over in turn.
+func takeOver(first int) ([]namedListener, *os.File, error) {
+	v, ok := os.LookupEnv(takeoverEnv)
+	if !ok {
+		return nil, nil, nil
+	}
+	os.Unsetenv(takeoverEnv)
+
+	names := strings.Split(v, ":")
+	listeners, err := fileListeners(first, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listeners, os.NewFile(uintptr(first+len(names)), "takeover"), nil
+}
+
+// Tell the arbiter this one took over from, if any, that it's serving clients.
+func (s *server) tookOver() {
+	if s.takeover == nil {
+		return
+	}
+
+	s.takeover.Write([]byte{1})
+	s.takeover.Close()
+	s.takeover = nil
+}
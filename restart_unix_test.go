@@ -0,0 +1,74 @@
+//go:build !windows
+// +build !windows
+
+package arbiter
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Run as the arbiter handed over to by TestHandOver: serve "taken over" on the primary
+// listener.
+func TestTakeOverHelper(t *testing.T) {
+	if os.Getenv("ARBITER_TEST_TAKEOVER") == "" {
+		t.Skip("run by TestHandOver")
+	}
+
+	s := &server{}
+	var err error
+	if s.inherited, s.takeover, err = takeOver(listenFDsStart); err != nil || len(s.inherited) != 2 {
+		t.Fatalf("Expected the HTTP and primary sockets; instead got %v, %v", s.inherited, err)
+	}
+	if _, ok := os.LookupEnv(takeoverEnv); ok {
+		t.Fatalf("Expected %s to be unset", takeoverEnv)
+	}
+	primary := s.inherit("primary", "")
+	s.tookOver()
+
+	conn, err := primary.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("taken over"))
+	conn.Close()
+}
+
+func TestHandOver(t *testing.T) {
+	http, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer http.Close()
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{httpListener: http, listeners: []namedListener{{"primary", primary}}}
+
+	t.Setenv("ARBITER_TEST_TAKEOVER", "1")
+	p, err := s.handOver(os.Args[0], []string{"-test.run=^TestTakeOverHelper$"})
+	if err != nil {
+		t.Fatalf("Expected the new arbiter to take over; instead got %v", err)
+	}
+	defer p.Wait()
+
+	// Clients reach the new arbiter once this one stops accepting them.
+	primary.Close()
+	conn, err := net.Dial("tcp", primary.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected the primary listener to be served by the new arbiter; instead got %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if b, _ := io.ReadAll(conn); string(b) != "taken over" {
+		t.Errorf("Expected the new arbiter to serve the client; instead got %q", b)
+	}
+
+	if _, err := s.handOver(os.Args[0], []string{"-test.run=^TestNothing$"}); err == nil {
+		t.Errorf("Expected a new arbiter that exits without serving to fail the hand over")
+	}
+}
@@ -0,0 +1,60 @@
+package arbiter
+
+import (
+	"sort"
+)
+
+// routingRule routes the sessions of a user, of a database, or of both, as route
+// rather than as their listener would; see [rule].
+type routingRule struct {
+	name     string
+	user     string
+	database string
+	route    *route
+}
+
+// Return the rules of c for the listener routing to class of cluster as r does, tried
+// in order of their names: each routes to its own class or cluster, or to those of
+// the listener it leaves out.
+func (s *server) routingRules(r *route, class, cluster string, c *Config) []routingRule {
+	names := make([]string, 0, len(c.Rule))
+	for name := range c.Rule {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]routingRule, 0, len(names))
+	for _, name := range names {
+		rule := c.Rule[name]
+		ruleClass, ruleCluster := class, cluster
+		if rule.Class != "" {
+			ruleClass = rule.Class
+		}
+		if rule.Cluster != "" {
+			ruleCluster = rule.Cluster
+		}
+
+		rules = append(rules, routingRule{
+			name:     name,
+			user:     rule.User,
+			database: rule.Database,
+			route:    s.routeTo(r.listener, ruleClass, ruleCluster, r.degradedPolicy, r.tls, r.proxy, c),
+		})
+	}
+
+	return rules
+}
+
+// Return the route of the first rule of r matching the parameters of a StartupMessage,
+// or r if none does.  Sessions that don't start in the clear, such as those encrypted
+// end to end, have no parameters, and are routed as r.
+func (r *route) match(params map[string]string) *route {
+	for _, rule := range r.rules {
+		if (rule.user == "" || rule.user == params["user"]) &&
+			(rule.database == "" || rule.database == params["database"]) {
+			return rule.route
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,112 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"testing"
+)
+
+func TestRoutingRules(t *testing.T) {
+	c, err := LoadConfig("./config.ini", nil, []string{
+		"cluster.billing.backend=10.1.0.1:5432",
+		"rule.reporting.user=reporting", "rule.reporting.class=eventual",
+		"rule.app.database=app", "rule.app.cluster=billing",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	billing := pool.New(context.Background())
+	s := &server{pool: pool.New(context.Background()), clusters: map[string]*pool.Pool{"billing": billing}}
+	r := s.newRoute("primary", "strong", "", c.Main.degraded, nil, proxyConfig{}, c)
+
+	for _, test := range []struct {
+		packet []byte
+		class  string
+		pool   *pool.Pool
+	}{
+		{startup("user", "reporting", "database", "reports"), "eventual", s.pool},
+		// Rules are tried in order of their names.
+		{startup("user", "reporting", "database", "app"), "strong", billing},
+		// The database defaults to the user.
+		{startup("user", "app"), "strong", billing},
+		{startup("user", "web", "database", "web"), "strong", s.pool},
+		{sslRequest(), "strong", s.pool},
+	} {
+		route := r.match(startupParameters(test.packet))
+		if route.class != test.class || route.pool != test.pool || route.listener != "primary" {
+			t.Errorf("%q: expected class %s of the expected pool; instead got %s", test.packet, test.class, route.class)
+		}
+	}
+
+	if _, err = LoadConfig("./config.ini", nil, []string{"rule.reporting.user=reporting"}); err == nil {
+		t.Errorf("Expected a rule routing nowhere else to be rejected")
+	}
+	if _, err = LoadConfig("./config.ini", nil, []string{"rule.reporting.user=reporting", "rule.reporting.class=fresh"}); err == nil {
+		t.Errorf("Expected a rule of an unknown class to be rejected")
+	}
+}
+
+func TestMinLSN(t *testing.T) {
+	for _, test := range []struct {
+		packet []byte
+		lsn    uint64
+		ok     bool
+	}{
+		{startup("user", "app"), 0, true},
+		{startup("user", "app", "arbiter.min_lsn", "16/B374D848"), 0x16B374D848, true},
+		{startup("user", "app", "options", "-c search_path=app -c arbiter.min_lsn=0/10"), 0x10, true},
+		{startup("user", "app", "options", "-carbiter.min_lsn=1/0"), 1 << 32, true},
+		{startup("user", "app", "options", "--arbiter.min_lsn=0/20"), 0x20, true},
+		{startup("user", "app", "arbiter.min_lsn", "latest"), 0, false},
+	} {
+		lsn, err := minLSN(startupParameters(test.packet))
+		if lsn != test.lsn || (err == nil) != test.ok {
+			t.Errorf("%q: expected %X, %v; instead got %X, %v", test.packet, test.lsn, test.ok, lsn, err)
+		}
+	}
+}
+
+func TestTraceparentParameter(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	for _, packet := range [][]byte{
+		startup("user", "app", "arbiter.traceparent", traceparent),
+		startup("user", "app", "options", "-c arbiter.min_lsn=0/10 -c arbiter.traceparent="+traceparent),
+	} {
+		if got := startupParameter(startupParameters(packet), traceparentParameter); got != traceparent {
+			t.Errorf("%q: expected %s; instead got %q", packet, traceparent, got)
+		}
+	}
+}
+
+// FuzzStartupParameters checks that the parameters of a StartupMessage survive being
+// encoded again, and that anything else has none.
+func FuzzStartupParameters(f *testing.F) {
+	f.Add(startup("user", "app", "options", "-c arbiter.min_lsn=0/16B3748"))
+	f.Add(startup("user"))
+	f.Add(sslRequest())
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		params := startupParameters(packet)
+		if params == nil {
+			return
+		}
+		if _, ok := params["database"]; !ok {
+			t.Fatalf("Expected the parameters of %q to have a database", packet)
+		}
+
+		var pairs []string
+		for name, value := range params {
+			pairs = append(pairs, name, value)
+		}
+		again := startupParameters(startup(pairs...))
+		if len(again) != len(params) {
+			t.Fatalf("Expected %v once encoded again; instead got %v", params, again)
+		}
+		for name, value := range params {
+			if again[name] != value {
+				t.Fatalf("Expected %v once encoded again; instead got %v", params, again)
+			}
+		}
+	})
+}
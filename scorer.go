@@ -0,0 +1,301 @@
+package arbiter
+
+import (
+	"context"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"math"
+	"os"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pluginScoreFunc is the signature of the Score function a scoring plugin exports.  It
+// is given the name of a backend and its measurements, keyed as in scoreInputs(), and
+// returns its score and whether it's eligible; see pool.Scorer.  It only uses builtin
+// types, so that plugins needn't import arbiter and keep working across its versions,
+// though Go plugins must still be built with the same toolchain as arbiter.
+type pluginScoreFunc = func(backend string, metrics map[string]float64) (score float64, eligible bool)
+
+// Return the options of the pool that decide which backend callers are routed to: the
+// latency band, and the scorer of [main], if any.
+func routingOptions(c *Config) ([]pool.Option, error) {
+	opts := []pool.Option{pool.WithLatencyBand(c.Main.LatencyBandPercent, c.Main.latencyBand),
+		pool.WithLagThreshold(c.Main.maxLag),
+		pool.WithLatencySmoothing(c.Main.LatencySmoothing, c.Main.LatencyWindow)}
+
+	if c.Main.MultiWriter {
+		opts = append(opts, pool.WithMultiWriter(c.Main.WriteGroup))
+	}
+
+	if c.Main.Scorer != "" {
+		scorer, err := loadScorer(c.Main.Scorer)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, pool.WithScorer(scorer))
+	}
+	if scorer := newExprScorer(c); scorer != nil {
+		opts = append(opts, pool.WithScorer(scorer))
+	}
+
+	return opts, nil
+}
+
+// Return the options of a pool of backends in mode, those of [main] or of a [cluster],
+// that decide which of those taking writes is the primary, if any.
+func primaryOptions(mode, arbitration string) []pool.Option {
+	switch {
+	case mode == clusterDistributed:
+		return []pool.Option{pool.WithDistributed()}
+	case arbitration == arbitrationWAL:
+		return []pool.Option{pool.WithWALArbitration()}
+	}
+
+	return nil
+}
+
+// Load the scorer at path: a WASM module if it ends in .wasm, and a Go plugin otherwise.
+func loadScorer(path string) (pool.Scorer, error) {
+	if strings.HasSuffix(path, ".wasm") {
+		return loadWASMScorer(path)
+	}
+
+	return loadPluginScorer(path)
+}
+
+// Load the Score function of the Go plugin at path as a pool.Scorer.
+func loadPluginScorer(path string) (pool.Scorer, error) {
+	plug, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := plug.Lookup("Score")
+	if err != nil {
+		return nil, err
+	}
+
+	score, ok := sym.(pluginScoreFunc)
+	if !ok {
+		return nil, fmt.Errorf("%s: Score is a %T; expected a %T", path, sym, score)
+	}
+
+	return pool.ScorerFunc(func(b pool.BackendInfo) (float64, bool) {
+		return score(b.Name, scoreInputs(b))
+	}), nil
+}
+
+// How long a WASM scorer may take to score a backend before it's deemed ineligible.
+const wasmScoreTimeout = 100 * time.Millisecond
+
+// wasmScorer scores backends with a WASM module, which, unlike a Go plugin, may be
+// written in any language and built by any toolchain.  The module exports
+//
+//	score() f64
+//
+// returning the score of the backend being scored, or NaN if it's ineligible, and may
+// import from the module arbiter
+//
+//	metric(name_ptr, name_len i32) f64
+//	backend(buf_ptr, buf_len i32) i32
+//
+// the first returning the measurement named by the string at name_ptr, keyed as in
+// scoreInputs(), or NaN if there's no such measurement, and the second copying as much
+// of the name of the backend as fits into the buffer at buf_ptr and returning its full
+// length.  WASI is available, and a reactor's _initialize is called once loaded.
+type wasmScorer struct {
+	// Held while scoring, as modules are single-threaded; the backend being scored and
+	// its measurements are what the imports return.
+	mu      sync.Mutex
+	score   api.Function
+	backend string
+	inputs  map[string]float64
+}
+
+// Load the WASM module at path as a pool.Scorer.
+func loadWASMScorer(path string) (pool.Scorer, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	s := &wasmScorer{}
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return nil, err
+	}
+	if _, err := r.NewHostModuleBuilder("arbiter").
+		NewFunctionBuilder().WithFunc(s.metric).Export("metric").
+		NewFunctionBuilder().WithFunc(s.backendName).Export("backend").
+		Instantiate(ctx); err != nil {
+		return nil, err
+	}
+
+	mod, err := r.InstantiateWithConfig(ctx, code, wazero.NewModuleConfig().WithStartFunctions("_initialize"))
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	s.score = mod.ExportedFunction("score")
+	if s.score == nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s: score isn't exported", path)
+	}
+	if def := s.score.Definition(); len(def.ParamTypes()) != 0 || len(def.ResultTypes()) != 1 ||
+		def.ResultTypes()[0] != api.ValueTypeF64 {
+		r.Close(ctx)
+		return nil, fmt.Errorf("%s: score is a %s; expected score() f64", path, def.DebugName())
+	}
+
+	return s, nil
+}
+
+// Score b, deeming it ineligible if the module traps or takes longer than
+// wasmScoreTimeout, which leaves the module unusable.
+func (s *wasmScorer) Score(b pool.BackendInfo) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.backend, s.inputs = b.Name, scoreInputs(b)
+	ctx, cancel := context.WithTimeout(context.Background(), wasmScoreTimeout)
+	defer cancel()
+
+	results, err := s.score.Call(ctx)
+	if err != nil {
+		return 0, false
+	}
+
+	score := api.DecodeF64(results[0])
+	return score, !math.IsNaN(score)
+}
+
+// The metric import: the measurement of the backend being scored named by the string
+// at ptr.
+func (s *wasmScorer) metric(ctx context.Context, m api.Module, ptr, n uint32) float64 {
+	name, ok := m.Memory().Read(ptr, n)
+	if !ok {
+		return math.NaN()
+	}
+
+	v, ok := s.inputs[string(name)]
+	if !ok {
+		return math.NaN()
+	}
+
+	return v
+}
+
+// The backend import: copy the name of the backend being scored into the buffer at ptr,
+// returning its length.
+func (s *wasmScorer) backendName(ctx context.Context, m api.Module, ptr, n uint32) uint32 {
+	name := s.backend
+	if uint32(len(name)) > n {
+		name = name[:n]
+	}
+	m.Memory().WriteString(ptr, name)
+
+	return uint32(len(s.backend))
+}
+
+// Return the measurements of b given to scoring plugins.  Durations are in seconds and
+// flags are 0 or 1.  Keys are only ever added.
+func scoreInputs(b pool.BackendInfo) map[string]float64 {
+	return map[string]float64{
+		"primary":             boolToFloat(b.State == pool.READ_WRITE),
+		"latency_seconds":     b.Latency.Seconds(),
+		"rtt_seconds":         b.RTT.Seconds(),
+		"lag_bytes":           float64(b.LagBytes),
+		"lag_seconds":         b.Lag.Seconds(),
+		"clock_skew_seconds":  b.ClockSkew.Seconds(),
+		"apply_delay_seconds": b.ApplyDelay.Seconds(),
+		"archive_lag_seconds": b.ArchiveLag.Seconds(),
+		"leases":              float64(b.Leases),
+		"lease_errors":        float64(b.LeaseErrors),
+		"weight":              b.Weight,
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// The variables of routing expressions; see routeVars().
+var routeVarTypes = map[string]exprType{
+	"name": exprString, "addr": exprString, "zone": exprString, "local_zone": exprString,
+	"primary": exprBool, "quorum": exprBool, "cost": exprNumber,
+	"latency": exprNumber, "rtt": exprNumber, "lag": exprNumber, "lag_bytes": exprNumber,
+	"clock_skew": exprNumber, "apply_delay": exprNumber, "archive_lag": exprNumber,
+	"leases": exprNumber, "lease_errors": exprNumber, "weight": exprNumber,
+}
+
+// exprScorer routes by the route-if and route-score expressions of [main].
+type exprScorer struct {
+	eligible, score *expr
+
+	// The zone and cost of each named backend, and the zone arbiter runs in.
+	zones     map[string]string
+	costs     map[string]float64
+	localZone string
+}
+
+// Return a scorer for the routing expressions of c, or nil if it has none.
+func newExprScorer(c *Config) *exprScorer {
+	if c.Main.routeIf == nil && c.Main.routeScore == nil {
+		return nil
+	}
+
+	zones, costs := make(map[string]string), make(map[string]float64)
+	for name, b := range c.Backend {
+		zones[name], costs[name] = b.Zone, b.Cost
+	}
+
+	return &exprScorer{eligible: c.Main.routeIf, score: c.Main.routeScore, zones: zones, costs: costs, localZone: c.Main.Zone}
+}
+
+func (s *exprScorer) Score(b pool.BackendInfo) (score float64, eligible bool) {
+	v := s.routeVars(b)
+	if s.eligible != nil && !s.eligible.truth(v) {
+		return 0, false
+	}
+	if s.score != nil {
+		score = s.score.num(v)
+	}
+
+	return score, true
+}
+
+// Return the values of the variables of routing expressions for b.  Durations are in
+// seconds, and backends that aren't named cost nothing.
+func (s *exprScorer) routeVars(b pool.BackendInfo) *exprVars {
+	return &exprVars{
+		strs: map[string]string{
+			"name": b.Name, "addr": b.Addr, "zone": s.zones[b.Name], "local_zone": s.localZone,
+		},
+		bools: map[string]bool{"primary": b.State == pool.READ_WRITE,
+			"quorum": b.SyncState == "sync" || b.SyncState == "quorum"},
+		nums: map[string]float64{
+			"cost":         s.costs[b.Name],
+			"latency":      b.Latency.Seconds(),
+			"rtt":          b.RTT.Seconds(),
+			"lag":          b.Lag.Seconds(),
+			"lag_bytes":    float64(b.LagBytes),
+			"clock_skew":   b.ClockSkew.Seconds(),
+			"apply_delay":  b.ApplyDelay.Seconds(),
+			"archive_lag":  b.ArchiveLag.Seconds(),
+			"leases":       float64(b.Leases),
+			"lease_errors": float64(b.LeaseErrors),
+			"weight":       b.Weight,
+		},
+	}
+}
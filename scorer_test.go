@@ -0,0 +1,85 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/pool"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A scoring WASM module preferring the closest follower whose name doesn't start with
+// x, and trapping on backends with over 100 leases.
+var testScorerWASM = []byte{
+	// magic and version
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// types: (i32, i32) f64, (i32, i32) i32, () f64
+	0x01, 0x11,
+	0x03, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7c, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x01, 0x7c,
+	// imports: arbiter.metric, arbiter.backend
+	0x02, 0x24,
+	0x02, 0x07, 0x61, 0x72, 0x62, 0x69, 0x74, 0x65, 0x72, 0x06, 0x6d, 0x65, 0x74, 0x72, 0x69, 0x63, 0x00, 0x00, 0x07, 0x61, 0x72, 0x62, 0x69, 0x74, 0x65, 0x72, 0x07, 0x62, 0x61, 0x63, 0x6b, 0x65, 0x6e, 0x64, 0x00, 0x01,
+	// functions: score
+	0x03, 0x02,
+	0x01, 0x02,
+	// memory: one page
+	0x05, 0x03,
+	0x01, 0x00, 0x01,
+	// exports: memory, score
+	0x07, 0x12,
+	0x02, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x00, 0x02,
+	// code: score, with no locals
+	0x0a, 0x5b, 0x01, 0x59, 0x00,
+	// if metric("primary") == 1 { return NaN }
+	0x41, 0x00, 0x41, 0x07, 0x10, 0x00, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, 0x61, 0x04, 0x40, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f, 0x0f, 0x0b,
+	// backend(64, 8)
+	0x41, 0xc0, 0x00, 0x41, 0x08, 0x10, 0x01, 0x1a,
+	// if name[0] == 'x' { return NaN }
+	0x41, 0xc0, 0x00, 0x2d, 0x00, 0x00, 0x41, 0xf8, 0x00, 0x46, 0x04, 0x40, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f, 0x0f, 0x0b,
+	// if metric("leases") > 100 { unreachable }
+	0x41, 0x16, 0x41, 0x06, 0x10, 0x00, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x59, 0x40, 0x64, 0x04, 0x40, 0x00, 0x0b,
+	// return -metric("latency_seconds")
+	0x41, 0x07, 0x41, 0x0f, 0x10, 0x00, 0x9a, 0x0b,
+	// data: "primary", "latency_seconds" and "leases" at 0
+	0x0b, 0x22,
+	0x01, 0x00, 0x41, 0x00, 0x0b, 0x1c,
+	'p', 'r', 'i', 'm', 'a', 'r', 'y',
+	'l', 'a', 't', 'e', 'n', 'c', 'y', '_', 's', 'e', 'c', 'o', 'n', 'd', 's',
+	'l', 'e', 'a', 's', 'e', 's',
+}
+
+func TestWASMScorer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scorer.wasm")
+	if err := os.WriteFile(path, testScorerWASM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	scorer, err := loadScorer(path)
+	if err != nil {
+		t.Fatalf("Expected to load the scorer; instead got %v", err)
+	}
+
+	for _, c := range []struct {
+		b        pool.BackendInfo
+		score    float64
+		eligible bool
+	}{
+		{pool.BackendInfo{Name: "pg1", State: pool.READ_ONLY, Latency: 2 * time.Second}, -2, true},
+		{pool.BackendInfo{Name: "pg2", State: pool.READ_WRITE}, 0, false},
+		{pool.BackendInfo{Name: "xpg3", State: pool.READ_ONLY}, 0, false},
+		{pool.BackendInfo{Name: "pg4", State: pool.READ_ONLY, Leases: 101}, 0, false},
+		{pool.BackendInfo{Name: "pg5", State: pool.READ_ONLY, Latency: time.Second}, -1, true},
+	} {
+		score, eligible := scorer.Score(c.b)
+		if eligible != c.eligible || (eligible && score != c.score) {
+			t.Errorf("%s: Expected %v, %v; instead got %v, %v", c.b.Name, c.score, c.eligible, score, eligible)
+		}
+	}
+
+	if err := os.WriteFile(path, testScorerWASM[:8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadScorer(path); err == nil {
+		t.Errorf("Expected a module without score to be rejected")
+	}
+}
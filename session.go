@@ -0,0 +1,470 @@
+package arbiter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errDrained ends a session that was handed off because its backend is being drained.
+var errDrained = errors.New("backend is draining")
+
+// errIdleTimeout ends a session that its client left idle for too long.
+var errIdleTimeout = errors.New("client idle timeout")
+
+// errClientGone ends a session whose client connection failed, such as when keepalives
+// find the client dead.
+var errClientGone = errors.New("client connection lost")
+
+// errHalfClosed ends one direction of a session whose sender finished sending, which
+// was passed on to the receiver as a half-close; see proxy().
+var errHalfClosed = errors.New("half-closed")
+
+// Protocol codes of the untyped messages a frontend may send during startup.
+const (
+	protocolVersion3  = 196608
+	cancelRequestCode = 80877102
+	sslRequestCode    = 80877103
+	gssencRequestCode = 80877104
+)
+
+// The bytes of a ParameterStatus body kept, enough for the settings followed.
+const paramStatusLimit = 64
+
+// msgScanner follows the message boundaries of one direction of a Postgres session,
+// as the bytes pass through the proxy.
+type msgScanner struct {
+	// Whether messages lack the type byte, as during frontend startup.
+	untyped bool
+
+	// The number of single-byte replies to SSLRequest or GSSENCRequest expected.
+	bare int
+
+	// The header of the current message, and how much of it has been read.
+	hdr  [5]byte
+	nhdr int
+
+	// The type of the current message, the body bytes left of it, and its first bytes.
+	inBody  bool
+	typ     byte
+	left    int
+	prefix  [8]byte
+	nprefix int
+
+	// The length of the current message's body, and up to keep bytes of it, while the
+	// session is captured; see capture.  ParameterStatus bodies are always kept, to
+	// track standard_conforming_strings.
+	size int
+	keep int
+	kept []byte
+}
+
+// feed scans b, calling msg at the end of each message with its type and the first
+// bytes of its body.  Untyped messages and single-byte replies have type 0, and the
+// reply as their body.  If msg returns true, feed stops and returns the offset just
+// past that message; otherwise it returns len(b).
+func (s *msgScanner) feed(b []byte, msg func(typ byte, prefix []byte) bool) int {
+	for i := 0; i < len(b); {
+		if !s.inBody {
+			if s.nhdr == 0 && s.bare > 0 {
+				s.bare--
+				i++
+				if msg(0, b[i-1:i]) {
+					return i
+				}
+				continue
+			}
+
+			hdrlen := 5
+			if s.untyped {
+				hdrlen = 4
+			}
+
+			n := copy(s.hdr[s.nhdr:hdrlen], b[i:])
+			s.nhdr += n
+			i += n
+			if s.nhdr < hdrlen {
+				break
+			}
+
+			if s.untyped {
+				s.typ = 0
+				s.left = int(binary.BigEndian.Uint32(s.hdr[0:4])) - 4
+			} else {
+				s.typ = s.hdr[0]
+				s.left = int(binary.BigEndian.Uint32(s.hdr[1:5])) - 4
+			}
+			if s.left < 0 {
+				s.left = 0
+			}
+
+			s.nhdr = 0
+			s.nprefix = 0
+			s.size = s.left
+			s.kept = s.kept[:0]
+			s.inBody = true
+		}
+
+		n := s.left
+		if n > len(b)-i {
+			n = len(b) - i
+		}
+		s.nprefix += copy(s.prefix[s.nprefix:], b[i:i+n])
+		keep := s.keep
+		if s.typ == 'S' && keep < paramStatusLimit {
+			keep = paramStatusLimit
+		}
+		if k := keep - len(s.kept); k > 0 {
+			if k > n {
+				k = n
+			}
+			s.kept = append(s.kept, b[i:i+k]...)
+		}
+		s.left -= n
+		i += n
+
+		if s.left == 0 {
+			s.inBody = false
+			if msg(s.typ, s.prefix[:s.nprefix]) {
+				return i
+			}
+		}
+	}
+
+	return len(b)
+}
+
+// Whether the scanner is between messages.
+func (s *msgScanner) atBoundary() bool {
+	return !s.inBody && s.nhdr == 0
+}
+
+// session tracks a proxied session closely enough to tell when it's between
+// transactions, so that it can be handed off when its backend is drained: the client
+// is sent a FATAL admin_shutdown error, which drivers and pools treat as a cue to
+// reconnect, rather than having a query fail midway.
+type session struct {
+	frontend, backend net.Conn
+
+	// The bytes the frontend sent and was sent, the reads they took, and when either
+	// side last sent anything, in Unix nanoseconds; see count().
+	bytesIn, bytesOut     AtomicInt
+	packetsIn, packetsOut AtomicInt
+	lastActive            atomic.Int64
+
+	// Guards everything below, and serializes writes to the frontend.
+	mu sync.Mutex
+
+	fscan, bscan msgScanner
+
+	// The number of queries, syncs and startups sent that no ReadyForQuery has
+	// answered yet, and whether the last ReadyForQuery left the session idle, or in a
+	// transaction.
+	pending int
+	idle    bool
+	inTx    bool
+
+	// How long the client may leave the session idle, or idle in a transaction, before
+	// it's closed; zero for no limit.  idleCode is the SQLSTATE to close it with while
+	// the client's read deadline is one of these, and empty otherwise.
+	idleTimeout     time.Duration
+	idleInTxTimeout time.Duration
+	idleCode        string
+
+	// Set if the session is encrypted end to end, or of a MySQL cluster, which leaves it
+	// opaque to us.
+	opaque bool
+
+	// Whether the backend reported standard_conforming_strings off, which makes a
+	// backslash escape the next character in any string literal; see scrubSQL.
+	backslashQuote bool
+
+	// The process ID and secret key of the backend's BackendKeyData, which the client
+	// cancels queries with; see forwardCancel().
+	key []byte
+
+	// Counts the simple queries, extended protocol syncs and function calls sent, if
+	// set; see readLoad.
+	queries *AtomicInt
+
+	draining  bool
+	handedOff bool
+
+	// Set once the session outlived the maximum session lifetime; see expire().
+	expired bool
+
+	// A message for the client, sent right after the backend first reports it's ready
+	// for a query, when announceDue is set; see proxy().
+	announce    []byte
+	announceDue bool
+
+	// Records the session, if set; see handleCapture().
+	capture *capture
+
+	// Duplicates what the frontend sends to the shadow, if set; see mirror.
+	mirror *mirror
+}
+
+func newSession(frontend, backend net.Conn, limits Limits) *session {
+	_, opaque := frontend.(mysqlClient)
+	return &session{
+		frontend:        frontend,
+		backend:         backend,
+		opaque:          opaque,
+		fscan:           msgScanner{untyped: true},
+		idleTimeout:     limits.IdleTimeout,
+		idleInTxTimeout: limits.IdleInTransactionTimeout,
+	}
+}
+
+// Count a read of n bytes from one side of the session into bytes and packets.
+func (s *session) count(bytes, packets *AtomicInt, n int) {
+	bytes.Add(int64(n))
+	packets.Add(1)
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// Account for a message sent by the frontend.  s must be locked.
+func (s *session) frontendMsg(typ byte, prefix []byte) bool {
+	s.record("F>", typ, &s.fscan)
+
+	switch typ {
+	case 0:
+		if len(prefix) < 4 {
+			break
+		}
+
+		switch binary.BigEndian.Uint32(prefix) {
+		case sslRequestCode, gssencRequestCode:
+			s.bscan.bare++
+		case protocolVersion3:
+			s.fscan.untyped = false
+			s.pending++
+		case cancelRequestCode:
+			s.fscan.untyped = false
+		}
+
+	case 'Q', 'S', 'F':
+		if s.queries != nil {
+			s.queries.Add(1)
+		}
+		s.pending++
+		s.idle = false
+		s.armIdle()
+	}
+
+	return false
+}
+
+// Account for a message sent by the backend, returning true if the session should be
+// handed off right after it.  s must be locked.
+func (s *session) backendMsg(typ byte, prefix []byte) bool {
+	s.record("B>", typ, &s.bscan)
+
+	switch typ {
+	case 0:
+		// The backend agreed to encrypt the session.
+		if len(prefix) == 1 && (prefix[0] == 'S' || prefix[0] == 'G') {
+			s.opaque = true
+			if s.capture != nil {
+				s.capture.note("The session is now encrypted end to end; nothing further can be captured.")
+			}
+			return false
+		}
+
+	case 'S':
+		if f := bytes.Split(s.bscan.kept, []byte{0}); len(f) > 1 && string(f[0]) == "standard_conforming_strings" {
+			s.backslashQuote = string(f[1]) == "off"
+		}
+
+	case 'K':
+		if len(prefix) == 8 {
+			s.key = append(s.key[:0], prefix...)
+		}
+
+	case 'Z':
+		if s.pending > 0 {
+			s.pending--
+		}
+		s.idle = s.pending == 0 && len(prefix) == 1 && prefix[0] == 'I'
+		s.inTx = s.pending == 0 && len(prefix) == 1 && (prefix[0] == 'T' || prefix[0] == 'E')
+		s.armIdle()
+		s.announceDue = s.announce != nil
+		return s.idle && s.draining || s.announceDue
+	}
+
+	return false
+}
+
+// Pass b, which the frontend sent, on to the backend, unless the session is handed
+// off instead.
+func (s *session) fromFrontend(b []byte) error {
+	s.mu.Lock()
+	if !s.opaque {
+		if s.draining && s.idle && s.bscan.atBoundary() {
+			s.handoff()
+			s.mu.Unlock()
+			return errDrained
+		}
+		s.fscan.feed(b, s.frontendMsg)
+	}
+	if s.mirror != nil && s.opaque {
+		s.mirror.close()
+		s.mirror = nil
+	}
+	if s.mirror != nil {
+		s.mirror.write(b)
+	}
+	s.mu.Unlock()
+
+	s.backend.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	_, err := s.backend.Write(b)
+	return err
+}
+
+// Pass b, which the backend sent, on to the frontend, handing the session off if it
+// reaches a transaction boundary while its backend is being drained.
+func (s *session) fromBackend(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(b) > 0 {
+		n := len(b)
+		if !s.opaque {
+			n = s.bscan.feed(b, s.backendMsg)
+		}
+
+		s.frontend.SetWriteDeadline(time.Now().Add(1 * time.Second))
+		if _, err := s.frontend.Write(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+
+		if s.draining && s.idle && s.bscan.atBoundary() {
+			s.handoff()
+			return errDrained
+		}
+
+		if s.announceDue {
+			if _, err := s.frontend.Write(s.announce); err != nil {
+				return err
+			}
+			s.announce = nil
+			s.announceDue = false
+		}
+	}
+
+	return nil
+}
+
+// Note that the backend is being drained, handing the session off right away if it's
+// idle.
+func (s *session) drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.draining = true
+	if !s.opaque && s.idle && s.bscan.atBoundary() {
+		s.handoff()
+	}
+}
+
+// Note that the session outlived the maximum session lifetime, handing it off as
+// drain() does, so that the client reconnects at its next transaction boundary.
+func (s *session) expire() {
+	s.mu.Lock()
+	s.expired = true
+	s.mu.Unlock()
+
+	s.drain()
+}
+
+// Set the client's read deadline to the idle timeout that applies to the session, if
+// any.  s must be locked.
+func (s *session) armIdle() {
+	var timeout time.Duration
+	code := ""
+	switch {
+	case s.handedOff:
+		return
+	case s.idle:
+		timeout, code = s.idleTimeout, sqlstateIdleSessionTimeout
+	case s.inTx:
+		timeout, code = s.idleInTxTimeout, sqlstateIdleInTransactionTimeout
+	}
+
+	if timeout == 0 {
+		if s.idleCode != "" {
+			s.idleCode = ""
+			s.frontend.SetReadDeadline(time.Time{})
+		}
+		return
+	}
+
+	s.idleCode = code
+	s.frontend.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// Close the session if reading from the client failed because it was idle for too
+// long, telling it why, and returning errIdleTimeout; otherwise return err as the
+// client's failure.
+func (s *session) clientFailed(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == io.EOF {
+		return err
+	}
+
+	var ne net.Error
+	if s.idleCode == "" || s.handedOff || !errors.As(err, &ne) || !ne.Timeout() {
+		return fmt.Errorf("%w: %s", errClientGone, err)
+	}
+
+	s.frontend.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	writeFatal(s.frontend, s.idleCode, "arbiter: terminating connection due to idle timeout")
+
+	return errIdleTimeout
+}
+
+// Tell the client to reconnect, and unblock the proxy.  s must be locked.
+func (s *session) handoff() {
+	if s.handedOff {
+		return
+	}
+	s.handedOff = true
+
+	reason := "backend is draining"
+	if s.expired {
+		reason = "session reached its maximum lifetime"
+	}
+	s.frontend.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	writeFatal(s.frontend, sqlstateAdminShutdown, "arbiter: "+reason+"; reconnect")
+
+	s.frontend.SetReadDeadline(time.Now())
+	s.backend.SetReadDeadline(time.Now())
+}
+
+// Half-close c, telling its peer nothing more will be sent while still reading what it
+// sends, if c supports it, as TCP and TLS connections do.
+func closeWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return errors.New("half-close not supported")
+}
+
+// Whether the session was handed off.
+func (s *session) wasHandedOff() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handedOff
+}
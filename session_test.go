@@ -0,0 +1,325 @@
+package arbiter
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMsgScanner(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		sslRequest(),
+		startup("user", "app"),
+		msg('Q', cstr("select 1;")),
+		msg('S'),
+	}, nil)
+
+	// Feed the stream a byte at a time, which splits every header and body.
+	s := msgScanner{untyped: true}
+	var types []byte
+	for i := range stream {
+		s.feed(stream[i:i+1], func(typ byte, prefix []byte) bool {
+			types = append(types, typ)
+			if typ == 0 && len(types) == 2 {
+				s.untyped = false
+			}
+			return false
+		})
+	}
+
+	if !bytes.Equal(types, []byte{0, 0, 'Q', 'S'}) || !s.atBoundary() {
+		t.Fatalf("Expected to scan two untyped messages, Q and S; instead got %q", types)
+	}
+}
+
+func TestDrainIdleSession(t *testing.T) {
+	drained := make(chan struct{})
+	client, backend, done := startProxy(t, drained)
+	defer client.Close()
+	defer backend.Close()
+
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+	roundTrip(t, "ready", backend, client, msg('Z', []byte("I")))
+
+	close(drained)
+	expectHandoff(t, client, done)
+}
+
+func TestDrainAtTransactionBoundary(t *testing.T) {
+	drained := make(chan struct{})
+	client, backend, done := startProxy(t, drained)
+	defer client.Close()
+	defer backend.Close()
+
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+	roundTrip(t, "ready", backend, client, msg('Z', []byte("I")))
+	roundTrip(t, "begin", client, backend, msg('Q', cstr("begin;")))
+	roundTrip(t, "in transaction", backend, client, append(msg('C', cstr("BEGIN")), msg('Z', []byte("T"))...))
+
+	// The session mustn't be handed off inside a transaction.
+	close(drained)
+	roundTrip(t, "insert", client, backend, msg('Q', cstr("insert into t values (1);")))
+	roundTrip(t, "inserted", backend, client, append(msg('C', cstr("INSERT 0 1")), msg('Z', []byte("T"))...))
+	roundTrip(t, "commit", client, backend, msg('Q', cstr("commit;")))
+	roundTrip(t, "committed", backend, client, append(msg('C', cstr("COMMIT")), msg('Z', []byte("I"))...))
+
+	expectHandoff(t, client, done)
+}
+
+func TestGSSAPIPassthrough(t *testing.T) {
+	drained := make(chan struct{})
+	client, backend, done := startProxy(t, drained)
+	defer client.Close()
+	defer backend.Close()
+
+	// AuthenticationGSS, then GSSResponse and AuthenticationGSSContinue until the
+	// backend is satisfied.
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+	roundTrip(t, "gss", backend, client, msg('R', u32(7)))
+	roundTrip(t, "token", client, backend, msg('p', []byte("client token")))
+	roundTrip(t, "continue", backend, client, msg('R', u32(8), []byte("server token")))
+	roundTrip(t, "token", client, backend, msg('p', []byte("last token")))
+	roundTrip(t, "ready", backend, client, append(msg('R', u32(0)), msg('Z', []byte("I"))...))
+
+	close(drained)
+	expectHandoff(t, client, done)
+}
+
+func TestTerminateTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	// A client asking for GSSAPI encryption is declined, and then asks for TLS.
+	client, frontend := tcpPipe(t)
+	defer client.Close()
+	defer frontend.Close()
+
+	go func() {
+		client.Write(append(u32(8), u32(gssencRequestCode)...))
+		reply := make([]byte, 1)
+		if io.ReadFull(client, reply); reply[0] != 'N' {
+			t.Errorf("Expected GSSENCRequest to be declined; instead got %q", reply)
+		}
+
+		client.Write(sslRequest())
+		if io.ReadFull(client, reply); reply[0] != 'S' {
+			t.Errorf("Expected SSLRequest to be accepted; instead got %q", reply)
+		}
+		tlsClient := tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+		tlsClient.Write(startup("user", "app"))
+	}()
+
+	conn, err := terminateTLS(frontend, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(startup("user", "app")))
+	if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, startup("user", "app")) {
+		t.Errorf("Expected the startup packet in the clear; instead got %q, %v", got, err)
+	}
+
+	// A client that doesn't ask for TLS is proxied as is.
+	client, frontend = tcpPipe(t)
+	defer client.Close()
+	defer frontend.Close()
+
+	go client.Write(startup("user", "app"))
+	if conn, err = terminateTLS(frontend, config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, startup("user", "app")) {
+		t.Errorf("Expected the startup packet to be replayed; instead got %q, %v", got, err)
+	}
+}
+
+func TestAnnounceMode(t *testing.T) {
+	client, frontend := net.Pipe()
+	backendConn, backend := net.Pipe()
+	defer client.Close()
+	defer backend.Close()
+
+	s := &server{}
+	go func() {
+		s.proxy(frontend, backendConn, nil, pool.NO_PRIMARY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+
+	ready := msg('Z', []byte("I"))
+	go backend.Write(append(msg('R', u32(0)), ready...))
+
+	want := append(append(msg('R', u32(0)), ready...), parameterStatus(modeParameter, "NO_PRIMARY")...)
+	got := make([]byte, len(want))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("Expected the client to be told of the mode; instead got %q, %v", got, err)
+	}
+
+	// Only once.
+	roundTrip(t, "query", client, backend, msg('Q', cstr("select 1;")))
+	roundTrip(t, "ready", backend, client, ready)
+}
+
+func TestIdleInTransactionTimeout(t *testing.T) {
+	client, frontend := net.Pipe()
+	backendConn, backend := net.Pipe()
+	defer client.Close()
+	defer backend.Close()
+
+	done := make(chan error, 1)
+	s := &server{limits: Limits{IdleInTransactionTimeout: 100 * time.Millisecond}}
+	go func() {
+		done <- s.proxy(frontend, backendConn, nil, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+	roundTrip(t, "ready", backend, client, msg('Z', []byte("I")))
+
+	// Idle outside a transaction is fine.
+	time.Sleep(200 * time.Millisecond)
+	roundTrip(t, "begin", client, backend, msg('Q', cstr("begin;")))
+	roundTrip(t, "in transaction", backend, client, append(msg('C', cstr("BEGIN")), msg('Z', []byte("T"))...))
+
+	var want bytes.Buffer
+	writeFatal(&want, sqlstateIdleInTransactionTimeout, "arbiter: terminating connection due to idle timeout")
+
+	got := make([]byte, want.Len())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Expected the client to receive %q; instead got %q, %v", want.Bytes(), got, err)
+	}
+
+	if err := <-done; err != errIdleTimeout {
+		t.Fatalf("Expected the proxy to end with errIdleTimeout; instead got %v", err)
+	}
+}
+
+func TestMaxSessionLifetime(t *testing.T) {
+	client, frontend := net.Pipe()
+	backendConn, backend := net.Pipe()
+	defer client.Close()
+	defer backend.Close()
+
+	done := make(chan error, 1)
+	s := &server{limits: Limits{MaxSessionLifetime: 100 * time.Millisecond}}
+	go func() {
+		done <- s.proxy(frontend, backendConn, nil, pool.HEALTHY)
+		frontend.Close()
+		backendConn.Close()
+	}()
+
+	roundTrip(t, "startup", client, backend, startup("user", "app"))
+	roundTrip(t, "ready", backend, client, msg('Z', []byte("I")))
+	roundTrip(t, "begin", client, backend, msg('Q', cstr("begin;")))
+	roundTrip(t, "in transaction", backend, client, append(msg('C', cstr("BEGIN")), msg('Z', []byte("T"))...))
+
+	l := s.live.list()
+	if len(l) != 1 || l[0].BytesIn != int64(len(startup("user", "app"))+len(msg('Q', cstr("begin;")))) ||
+		l[0].PacketsIn != 2 || l[0].PacketsOut != 2 || l[0].LastActivity.Before(l[0].Started) {
+		t.Errorf("Expected what the session passed on to be counted; instead got %+v", l)
+	}
+
+	// An expired session isn't handed off inside a transaction.
+	time.Sleep(200 * time.Millisecond)
+	roundTrip(t, "commit", client, backend, msg('Q', cstr("commit;")))
+	roundTrip(t, "committed", backend, client, append(msg('C', cstr("COMMIT")), msg('Z', []byte("I"))...))
+
+	var want bytes.Buffer
+	writeFatal(&want, sqlstateAdminShutdown, "arbiter: session reached its maximum lifetime; reconnect")
+	got := make([]byte, want.Len())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Expected the client to receive %q; instead got %q, %v", want.Bytes(), got, err)
+	}
+
+	if err := <-done; err != errDrained {
+		t.Fatalf("Expected the proxy to end with errDrained; instead got %v", err)
+	}
+}
+
+// Expect the client to be told to reconnect, and the proxy to end with errDrained.
+func expectHandoff(t *testing.T, client io.Reader, done chan error) {
+	var want bytes.Buffer
+	writeFatal(&want, sqlstateAdminShutdown, "arbiter: backend is draining; reconnect")
+
+	got := make([]byte, want.Len())
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Expected the client to receive %q; instead got %q, %v", want.Bytes(), got, err)
+	}
+
+	select {
+	case err := <-done:
+		if err != errDrained {
+			t.Fatalf("Expected the proxy to end with errDrained; instead got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the proxy to end")
+	}
+}
+
+// FuzzMsgScanner checks that the messages scanned from a stream don't depend on how it's
+// split into reads.
+func FuzzMsgScanner(f *testing.F) {
+	f.Add(bytes.Join([][]byte{sslRequest(), startup("user", "app"), msg('Q', cstr("select 1;"))}, nil), true, uint8(1))
+	f.Add(bytes.Join([][]byte{
+		msg('R', u32(0)),
+		msg('S', cstr("server_version"), cstr("16.0")),
+		msg('K', u32(1234), u32(5678)),
+		msg('Z', []byte("I")),
+		msg('T', u16(1), cstr("?column?"), u32(0), u16(0), u32(23), u16(4), u32(0xffffffff), u16(0)),
+		msg('D', u16(1), u32(1), []byte("1")),
+		msg('C', cstr("SELECT 1")),
+		msg('Z', []byte("I")),
+	}, nil), false, uint8(3))
+
+	f.Fuzz(func(t *testing.T, data []byte, untyped bool, split uint8) {
+		scan := func(chunk int) (msgs [][]byte) {
+			s := msgScanner{untyped: untyped}
+			for i := 0; i < len(data); i += chunk {
+				b := data[i:min(i+chunk, len(data))]
+				for len(b) > 0 {
+					n := s.feed(b, func(typ byte, prefix []byte) bool {
+						msgs = append(msgs, append([]byte{typ}, prefix...))
+						return true
+					})
+					if n <= 0 || n > len(b) {
+						t.Fatalf("feed returned %d of %d bytes", n, len(b))
+					}
+					b = b[n:]
+				}
+			}
+			return msgs
+		}
+
+		whole, chunked := scan(len(data)+1), scan(int(split)+1)
+		if len(whole) != len(chunked) {
+			t.Fatalf("Expected %d messages however the stream is split; instead got %d", len(whole), len(chunked))
+		}
+		for i := range whole {
+			if !bytes.Equal(whole[i], chunked[i]) {
+				t.Fatalf("Expected message %d to be %q; instead got %q", i, whole[i], chunked[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package arbiter
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// How often shutdown() looks for sessions still being proxied.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Shut arbiter down gracefully once it's sent SIGINT or SIGTERM, or stop is otherwise
+// sent to, as by the Windows service control manager, and exit; see shutdown().
+func (s *server) stopOnSignal(stop chan os.Signal, timeout time.Duration) {
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-stop
+	s.systemd.notify("STOPPING=1")
+	s.logger.Printf("Stopping on %s; waiting up to %s for %d sessions to finish", sig, timeout, s.nconns.Get())
+	if s.shutdown(timeout) {
+		s.logger.Printf("Stopped")
+	} else {
+		s.logger.Printf("Stopped with %d sessions still open", s.nconns.Get())
+	}
+
+	exitDaemon(exitStopped)
+}
+
+// Stop accepting clients, hand the lead over to a standby, if any, stop monitoring the
+// backends, closing their monitoring connections, and wait up to timeout for the sessions being proxied to finish,
+// returning whether they all did.  Sessions keep their backends until they end.
+func (s *server) shutdown(timeout time.Duration) bool {
+	for _, l := range s.listeners {
+		l.ln.Close()
+	}
+	if s.election != nil {
+		s.election.resign()
+	}
+
+	if s.stopPool != nil {
+		s.stopPool()
+		s.pool.Wait()
+		for _, p := range s.clusters {
+			p.Wait()
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for s.nconns.Get() > 0 {
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+
+	if s.statsd != nil {
+		s.statsd.Close()
+	}
+	if s.otlp != nil {
+		s.otlp.Close()
+	}
+
+	return s.nconns.Get() == 0
+}
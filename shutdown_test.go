@@ -0,0 +1,57 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &server{pool: pool.New(ctx), listeners: []namedListener{{"primary", ln}}, stopPool: cancel}
+
+	served := make(chan struct{})
+	go func() {
+		s.serve(ln, nil)
+		close(served)
+	}()
+
+	// A session finishing within the timeout is waited for.
+	s.nconns.Add(1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		s.nconns.Add(-1)
+	}()
+
+	if !s.shutdown(5 * time.Second) {
+		t.Fatalf("Expected the session to be waited for")
+	}
+	if s.nconns.Get() != 0 {
+		t.Fatalf("Expected shutdown to return once the session finished; instead %d are open", s.nconns.Get())
+	}
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the listener to stop accepting clients")
+	}
+	if ctx.Err() == nil {
+		t.Fatalf("Expected the monitors to be stopped")
+	}
+
+	// One that doesn't is given up on at the timeout.
+	s.nconns.Add(1)
+	start := time.Now()
+	if s.shutdown(100 * time.Millisecond) {
+		t.Fatalf("Expected a session outliving the timeout to be given up on")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Expected shutdown to give up after 100ms; instead it took %s", d)
+	}
+}
@@ -0,0 +1,216 @@
+package arbiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errNotRecorded = errors.New("not recorded")
+
+// replayBackend is a backend whose health checks answer with the samples recorded in
+// the history, rather than reaching a server; see simulate().
+type replayBackend struct {
+	name string
+
+	// The sample of the current point in time, nil if none was recorded, and the WAL
+	// position to report, derived from it.
+	sample *sample
+	lsn    uint64
+	now    func() time.Time
+}
+
+func (b *replayBackend) Ping() (pool.State, error) {
+	if b.sample == nil {
+		return pool.UNAVAILABLE, errNotRecorded
+	}
+
+	switch b.sample.State {
+	case "primary":
+		return pool.READ_WRITE, nil
+	case "follower":
+		return pool.READ_ONLY, nil
+	}
+	return pool.UNAVAILABLE, fmt.Errorf("recorded as %s", b.sample.State)
+}
+
+func (b *replayBackend) RTT() (time.Duration, error) {
+	return seconds(b.sample.RTT), nil
+}
+
+func (b *replayBackend) WALPosition() (uint64, time.Time, error) {
+	return b.lsn, b.now(), nil
+}
+
+func (b *replayBackend) Addr() string {
+	return b.name
+}
+
+func (b *replayBackend) Connect(time.Duration) (*pool.Conn, error) {
+	return nil, errors.New("simulated backends can't be connected to")
+}
+
+func (b *replayBackend) Fail() {}
+
+// simLogger prefixes what the pool logs with the simulated time.
+type simLogger struct {
+	w   io.Writer
+	now func() time.Time
+}
+
+func (l simLogger) Printf(format string, v ...interface{}) {
+	fmt.Fprintf(l.w, "%s  %s\n", l.now().Format(time.RFC3339), fmt.Sprintf(format, v...))
+}
+
+// Replay the history recorded between from and to, from the database configured in c,
+// through a pool configured by c, as fast as it can be checked, writing to w what the
+// pool logs, such as transitions and failovers, and the backends each class would have
+// been routed to whenever they change.  Changes to the routing configuration, such as
+// classes, the latency band or routing expressions, can so be tried against past
+// incidents.
+//
+// The recorded lag is replayed as WAL positions, at the WAL rate it implies, so the lag
+// the pool computes from them approximates the one recorded.
+func simulate(c *Config, from, to string, w io.Writer) error {
+	if c.History.Path == "" {
+		return fmt.Errorf("no history path configured")
+	}
+
+	start, end, err := parseHistoryRange(from, to)
+	if err != nil {
+		return err
+	}
+
+	h, err := openHistory(c.History.Path, c.History.interval, c.History.retention)
+	if err != nil {
+		return err
+	}
+	defer h.db.Close()
+
+	// A negative limit is none to SQLite.
+	samples, err := h.query("", start, end, -1)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no history recorded between %s and %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	var now time.Time
+	clock := func() time.Time { return now }
+
+	opts, err := routingOptions(c)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, pool.WithManualChecks(clock), pool.WithLogger(simLogger{w, clock}))
+	opts = append(opts, primaryOptions(c.Main.ClusterMode, c.Main.PrimaryArbitration)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := pool.New(ctx, opts...)
+
+	classes := []string{"strong", "eventual"}
+	for name, class := range c.Class {
+		p.DefineClass(name, pool.Class{PrimaryOnly: class.PrimaryOnly, MaxLag: class.maxLag,
+			FollowersOnly: class.FollowersOnly, QuorumOnly: class.QuorumOnly, PreferQuorum: class.PreferQuorum,
+			Balance: class.balance, WriteGroup: class.WriteGroup})
+		classes = append(classes, name)
+	}
+	sort.Strings(classes[2:])
+
+	backends := make(map[string]*replayBackend)
+	var names []string
+	for _, s := range samples {
+		if backends[s.Backend] != nil {
+			continue
+		}
+
+		backends[s.Backend] = &replayBackend{name: s.Backend, now: clock}
+		names = append(names, s.Backend)
+	}
+	sort.Strings(names)
+
+	now = samples[0].Time
+	for _, name := range names {
+		if err := p.PutNamed(name, backends[name]); err != nil {
+			return err
+		}
+		if b := c.Backend[name]; b != nil {
+			p.SetPromotionInfo(name, b.promotion)
+			p.Expect(name, b.expect)
+		}
+	}
+
+	// The WAL position of the primary advances at the rate implied by the recorded
+	// lag of followers, and those of followers trail it by their recorded lag.  It
+	// starts far enough along for them to.
+	lsn := uint64(1 << 40)
+	var rate float64
+	routes := make(map[string]string)
+	for len(samples) > 0 {
+		n := 1
+		for n < len(samples) && samples[n].Time.Equal(samples[0].Time) {
+			n++
+		}
+		round := samples[:n]
+		samples = samples[n:]
+
+		lsn += uint64(rate * round[0].Time.Sub(now).Seconds())
+		now = round[0].Time
+
+		for _, b := range backends {
+			b.sample = nil
+		}
+		for i := range round {
+			s := &round[i]
+			if s.State == "follower" && s.Lag > 0 {
+				rate = float64(s.LagBytes) / s.Lag
+			}
+			backends[s.Backend].sample = s
+		}
+
+		// Check the primary first, which the lag of followers is computed against.
+		var primaries, others []string
+		for _, name := range names {
+			b := backends[name]
+			switch {
+			case b.sample != nil && b.sample.State == "primary":
+				b.lsn = lsn
+				primaries = append(primaries, name)
+			case b.sample != nil && b.sample.LagBytes < lsn:
+				b.lsn = lsn - b.sample.LagBytes
+				others = append(others, name)
+			default:
+				b.lsn = 0
+				others = append(others, name)
+			}
+		}
+		for _, name := range append(primaries, others...) {
+			p.Check(name)
+		}
+
+		for _, class := range classes {
+			candidates, err := p.Candidates(class)
+			if err != nil {
+				return err
+			}
+
+			route := "none"
+			if len(candidates) > 0 {
+				route = strings.Join(candidates, ", ")
+			}
+			if routes[class] != route {
+				fmt.Fprintf(w, "%s  %s: routed to %s\n", now.Format(time.RFC3339), class, route)
+				routes[class] = route
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package arbiter
+
+import (
+	"bytes"
+	"github.com/solvip/arbiter/pool"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	h, err := openHistory(path, 10*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pg2 falls behind, and then takes over from pg1.
+	start := time.Date(2026, 3, 3, 2, 0, 0, 0, time.Local)
+	for i, lag := range []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 5 * time.Second, 0} {
+		pg1 := pool.BackendInfo{Name: "pg1", State: pool.READ_WRITE, RTT: time.Millisecond}
+		pg2 := pool.BackendInfo{Name: "pg2", State: pool.READ_ONLY, RTT: 2 * time.Millisecond,
+			Lag: lag, LagBytes: uint64(lag / time.Millisecond)}
+		if lag == 0 {
+			pg1.State, pg2.State = pool.UNAVAILABLE, pool.READ_WRITE
+		}
+
+		if err := h.record(start.Add(time.Duration(i)*10*time.Second), []pool.BackendInfo{pg1, pg2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.db.Close()
+
+	c, err := LoadConfig("./config.ini", nil, []string{"history.path=" + path, "main.latency-band=5ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := simulate(c, "2026-03-03 02:00", "2026-03-03 03:00", &out); err != nil {
+		t.Fatalf("Expected to simulate; instead got %v", err)
+	}
+
+	var routes []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if i := strings.Index(line, "  "); i >= 0 && strings.Contains(line, "routed to") {
+			routes = append(routes, line[i+2:])
+		}
+	}
+
+	want := []string{
+		// The lag of pg2 is only known once the WAL rate is.
+		"strong: routed to pg1",
+		"eventual: routed to pg1, pg2",
+		"bounded-1s: routed to pg1",
+		"bounded-1s: routed to pg1, pg2",
+		"bounded-1s: routed to pg1",
+		"strong: routed to pg2",
+		"eventual: routed to pg2",
+		"bounded-1s: routed to pg2",
+	}
+	if got := strings.Join(routes, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Expected the routes\n%s\ninstead got\n%s\nfrom\n%s", strings.Join(want, "\n"), got, &out)
+	}
+
+	if !strings.Contains(out.String(), "failover from=pg1 to=pg2") {
+		t.Errorf("Expected the failover to be reported; instead got\n%s", &out)
+	}
+}
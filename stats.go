@@ -0,0 +1,246 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"time"
+)
+
+// backendStats describes a single backend in stats.
+type backendStats struct {
+	Labels  metrics.Labels `json:"labels,omitempty"`
+	Name    string         `json:"name"`
+	Addr    string         `json:"addr"`
+	State   string         `json:"state"`
+	Latency string         `json:"latency"`
+	RTT     string         `json:"rtt"`
+	LastRTT string         `json:"last_rtt"`
+
+	LSN               string `json:"lsn,omitempty"`
+	LagBytes          uint64 `json:"lag_bytes"`
+	Lag               string `json:"lag"`
+	ClockSkew         string `json:"clock_skew"`
+	ClockSkewDetected bool   `json:"clock_skew_detected"`
+
+	ArchiveFailing  bool   `json:"archive_failing"`
+	ArchiveFailures int64  `json:"archive_failures"`
+	ArchiveLag      string `json:"archive_lag"`
+
+	ApplyDelay         string `json:"apply_delay"`
+	ReplayBacklogBytes uint64 `json:"replay_backlog_bytes"`
+	Upstream           string `json:"upstream,omitempty"`
+	Diverged           bool   `json:"diverged"`
+	Superseded         bool   `json:"superseded"`
+	Unfenced           bool   `json:"unfenced"`
+	Outvoted           bool   `json:"outvoted"`
+
+	Leases         int64   `json:"leases"`
+	LeaseErrors    int64   `json:"lease_errors"`
+	MaxConnections int64   `json:"max_connections,omitempty"`
+	Weight         float64 `json:"weight"`
+	Draining       bool    `json:"draining"`
+	Cordoned       bool    `json:"cordoned"`
+
+	RoleViolation bool   `json:"role_violation"`
+	DuplicateOf   string `json:"duplicate_of,omitempty"`
+
+	FailStreak    int      `json:"fail_streak"`
+	SuccessStreak int      `json:"success_streak"`
+	Transient     string   `json:"transient,omitempty"`
+	Degraded      string   `json:"degraded,omitempty"`
+	Anomalies     []string `json:"anomalies,omitempty"`
+
+	CheckWindows []checkWindowStats `json:"check_windows,omitempty"`
+
+	WriteGroup string `json:"write_group,omitempty"`
+	Writer     bool   `json:"writer,omitempty"`
+
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt string `json:"last_error_at,omitempty"`
+	LastCheckAt string `json:"last_check_at"`
+	SyncState   string `json:"sync_state,omitempty"`
+}
+
+// checkWindowStats summarizes the recent checks of a backend in backendStats; see
+// [health] check-window.
+type checkWindowStats struct {
+	Window        string  `json:"window"`
+	Checks        int     `json:"checks"`
+	UptimePercent float64 `json:"uptime_percent"`
+	P50           string  `json:"p50"`
+	P95           string  `json:"p95"`
+	P99           string  `json:"p99"`
+}
+
+// failoverStats describes the potential data loss of the last failover in stats.
+type failoverStats struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Time      string `json:"time"`
+	Known     bool   `json:"known"`
+	LossBytes uint64 `json:"loss_bytes"`
+	Loss      string `json:"loss"`
+}
+
+// writeGroupStats describes a write group in stats; see [main] multi-writer.
+type writeGroupStats struct {
+	Name    string   `json:"name"`
+	Writer  string   `json:"writer,omitempty"`
+	Healthy bool     `json:"healthy"`
+	Writers []string `json:"writers"`
+	Members []string `json:"members"`
+}
+
+// stats is a summary of the state of arbiter, served on /stats.
+type stats struct {
+	Labels              metrics.Labels    `json:"labels,omitempty"`
+	TransferredBytes    int64             `json:"transferred_bytes"`
+	NumberOfConnections int64             `json:"connections"`
+	BackendConnections  int64             `json:"backend_connections"`
+	BufferedBytes       int64             `json:"buffered_bytes"`
+	RejectedConnections int64             `json:"rejected_connections"`
+	QueuedSessions      int64             `json:"queued_sessions"`
+	RefusedConnections  int64             `json:"refused_connections"`
+	StaleView           bool              `json:"stale_view"`
+	Shedding            string            `json:"shedding,omitempty"`
+	LastFailover        *failoverStats    `json:"last_failover,omitempty"`
+	SyncStandbyNames    string            `json:"synchronous_standby_names,omitempty"`
+	FencingEpoch        uint64            `json:"fencing_epoch,omitempty"`
+	OpenFiles           int64             `json:"open_files"`
+	MaxOpenFiles        int64             `json:"max_open_files"`
+	WriteGroups         []writeGroupStats `json:"write_groups,omitempty"`
+	Backends            []backendStats    `json:"backends"`
+
+	// The backends of each [cluster], by name.
+	Clusters map[string][]backendStats `json:"clusters,omitempty"`
+}
+
+func (s *server) stats() stats {
+	curStats := stats{
+		Labels:              s.labels,
+		TransferredBytes:    s.transferred.Get(),
+		NumberOfConnections: s.nconns.Get(),
+		BackendConnections:  s.nbackends.Get(),
+		BufferedBytes:       s.buffered.Get(),
+		RejectedConnections: s.rejected.Get(),
+		QueuedSessions:      s.queued.Get(),
+		RefusedConnections:  s.refused.Get(),
+		StaleView:           s.pool.Stale(),
+		Shedding:            s.overloaded(),
+		SyncStandbyNames:    s.pool.SyncConfig().String(),
+		FencingEpoch:        s.pool.Epoch(),
+	}
+	curStats.OpenFiles, curStats.MaxOpenFiles = descriptorUsage()
+
+	if f := s.pool.LastFailover(); f != nil {
+		curStats.LastFailover = &failoverStats{
+			From:      f.From,
+			To:        f.To,
+			Time:      f.Time.Format(time.RFC3339),
+			Known:     f.Known,
+			LossBytes: f.LossBytes,
+			Loss:      f.Loss.String(),
+		}
+	}
+
+	for _, g := range s.pool.WriteGroups() {
+		curStats.WriteGroups = append(curStats.WriteGroups, writeGroupStats{
+			Name:    g.Name,
+			Writer:  g.Writer,
+			Healthy: g.Writer != "",
+			Writers: g.Writers,
+			Members: g.Members,
+		})
+	}
+
+	curStats.Backends = s.backendStats(s.pool.Backends())
+	for name, p := range s.clusters {
+		if curStats.Clusters == nil {
+			curStats.Clusters = make(map[string][]backendStats)
+		}
+		curStats.Clusters[name] = s.backendStats(p.Backends())
+	}
+
+	return curStats
+}
+
+// Describe backends in stats.
+func (s *server) backendStats(backends []pool.BackendInfo) (ret []backendStats) {
+	for _, b := range backends {
+		var lastErrorAt string
+		if !b.LastErrorAt.IsZero() {
+			lastErrorAt = b.LastErrorAt.Format(time.RFC3339)
+		}
+		var lsn string
+		if b.LSN != 0 {
+			lsn = pool.FormatLSN(b.LSN)
+		}
+
+		var windows []checkWindowStats
+		for _, w := range b.CheckWindows {
+			windows = append(windows, checkWindowStats{
+				Window:        pool.FormatWindow(w.Window),
+				Checks:        w.Checks,
+				UptimePercent: w.Uptime * 100,
+				P50:           w.P50.String(),
+				P95:           w.P95.String(),
+				P99:           w.P99.String(),
+			})
+		}
+
+		ret = append(ret, backendStats{
+			Labels:  s.perBackendLabels.get(b.Name),
+			Name:    b.Name,
+			Addr:    b.Addr,
+			State:   b.State.String(),
+			Latency: b.Latency.String(),
+			RTT:     b.RTT.String(),
+			LastRTT: b.LastRTT.String(),
+
+			LSN:               lsn,
+			LagBytes:          b.LagBytes,
+			Lag:               b.Lag.String(),
+			ClockSkew:         b.ClockSkew.String(),
+			ClockSkewDetected: b.ClockSkewDetected,
+
+			ArchiveFailing:  b.ArchiveFailing,
+			ArchiveFailures: b.ArchiveFailures,
+			ArchiveLag:      b.ArchiveLag.String(),
+
+			ApplyDelay:         b.ApplyDelay.String(),
+			ReplayBacklogBytes: b.ReplayBacklogBytes,
+			Upstream:           b.Upstream,
+			Diverged:           b.Diverged,
+			Superseded:         b.Superseded,
+			Unfenced:           b.Unfenced,
+			Outvoted:           b.Outvoted,
+
+			Leases:         b.Leases,
+			LeaseErrors:    b.LeaseErrors,
+			MaxConnections: b.MaxConnections,
+			Weight:         b.Weight,
+			Draining:       b.Draining,
+			Cordoned:       b.Cordoned,
+
+			RoleViolation: b.RoleViolation,
+			DuplicateOf:   b.DuplicateOf,
+
+			FailStreak:    b.FailStreak,
+			SuccessStreak: b.SuccessStreak,
+			Transient:     b.Transient,
+			Degraded:      string(b.Degraded),
+			Anomalies:     b.Anomalies,
+			CheckWindows:  windows,
+
+			WriteGroup: b.WriteGroup,
+			Writer:     b.Writer,
+
+			LastError:   b.LastError,
+			LastErrorAt: lastErrorAt,
+			LastCheckAt: b.LastCheckAt.Format(time.RFC3339),
+			SyncState:   b.SyncState,
+		})
+	}
+
+	return ret
+}
@@ -0,0 +1,197 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The failovers kept for the status page.
+const statusFailovers = 10
+
+// statusPage is the summary served on /, for on-call engineers to take in at a glance
+// rather than for programs, which have /stats: the primary of each cluster, the lag and
+// sessions of its backends, and the last failovers.
+type statusPage struct {
+	Time        string           `json:"time"`
+	Labels      metrics.Labels   `json:"labels,omitempty"`
+	Connections int64            `json:"connections"`
+	Queued      int64            `json:"queued_sessions"`
+	StaleView   bool             `json:"stale_view"`
+	Clusters    []clusterStatus  `json:"clusters"`
+	Failovers   []failoverStatus `json:"failovers"`
+}
+
+// clusterStatus describes a cluster on the status page.  Its name is empty for the
+// backends in [main], and its primary if it has none.
+type clusterStatus struct {
+	Name     string          `json:"name"`
+	Primary  string          `json:"primary"`
+	Backends []backendStatus `json:"backends"`
+}
+
+type backendStatus struct {
+	Name      string `json:"name"`
+	Addr      string `json:"addr"`
+	State     string `json:"state"`
+	LagBytes  uint64 `json:"lag_bytes"`
+	Lag       string `json:"lag"`
+	Sessions  int64  `json:"sessions"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// failoverStatus describes a failover of a cluster on the status page, newest first.
+type failoverStatus struct {
+	Cluster string `json:"cluster"`
+	failoverStats
+}
+
+// failoverLog keeps the last failovers of the pools for the status page, since a pool
+// only keeps its last one.
+type failoverLog struct {
+	mu        sync.Mutex
+	failovers []failoverStatus
+}
+
+// Keep the failovers of p, the pool of the named cluster, until the process exits.
+func (l *failoverLog) record(cluster string, p *pool.Pool) {
+	p.SubscribeFunc(func(e pool.Event) {
+		if e.Failover != nil {
+			l.add(cluster, e.Failover)
+		}
+	})
+}
+
+func (l *failoverLog) add(cluster string, f *pool.FailoverReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failovers = append(l.failovers, failoverStatus{cluster, failoverStats{
+		From:      f.From,
+		To:        f.To,
+		Time:      f.Time.Format(time.RFC3339),
+		Known:     f.Known,
+		LossBytes: f.LossBytes,
+		Loss:      f.Loss.String(),
+	}})
+	if len(l.failovers) > statusFailovers {
+		l.failovers = l.failovers[len(l.failovers)-statusFailovers:]
+	}
+}
+
+// Return the failovers kept, newest first.
+func (l *failoverLog) list() []failoverStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ret := make([]failoverStatus, len(l.failovers))
+	for i, f := range l.failovers {
+		ret[len(ret)-1-i] = f
+	}
+	return ret
+}
+
+func (s *server) status() statusPage {
+	page := statusPage{
+		Time:        time.Now().Format(time.RFC3339),
+		Labels:      s.labels,
+		Connections: s.nconns.Get(),
+		Queued:      s.queued.Get(),
+		StaleView:   s.pool.Stale(),
+		Clusters:    []clusterStatus{clusterStatusOf("", s.pool)},
+		Failovers:   s.failovers.list(),
+	}
+
+	for name, p := range s.clusters {
+		page.Clusters = append(page.Clusters, clusterStatusOf(name, p))
+	}
+	sort.Slice(page.Clusters, func(i, j int) bool { return page.Clusters[i].Name < page.Clusters[j].Name })
+
+	return page
+}
+
+func clusterStatusOf(name string, p *pool.Pool) clusterStatus {
+	cs := clusterStatus{Name: name, Backends: []backendStatus{}}
+	for _, b := range p.Backends() {
+		if b.State == pool.READ_WRITE {
+			cs.Primary = b.Name
+		}
+		cs.Backends = append(cs.Backends, backendStatus{
+			Name:      b.Name,
+			Addr:      b.Addr,
+			State:     b.State.String(),
+			LagBytes:  b.LagBytes,
+			Lag:       b.Lag.String(),
+			Sessions:  b.Leases,
+			LastError: b.LastError,
+		})
+	}
+
+	return cs
+}
+
+// Serve the status page on /, as HTML for browsers, refreshing itself, or as JSON with
+// ?format=json or to clients that accept it rather than HTML.
+func (s *server) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+
+	format := req.FormValue("format")
+	if format == "" {
+		format = "html"
+		if accept := req.Header.Get("Accept"); strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html") {
+			format = "json"
+		}
+	}
+
+	switch format {
+	case "json":
+		writeJSON(w, http.StatusOK, s.status())
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, s.status()); err != nil {
+			s.logger.Printf("Could not render the status page: %s", err)
+		}
+	default:
+		http.Error(w, "unknown format '"+format+"'", http.StatusBadRequest)
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>arbiter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+.READ_WRITE { color: green; } .READ_ONLY { color: navy; } .UNAVAILABLE, .none { color: red; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>arbiter{{range $k, $v := .Labels}} {{$k}}={{$v}}{{end}}</h1>
+<p>{{.Time}}: {{.Connections}} connections, {{.Queued}} queued{{if .StaleView}}; <span class="none">the view of the backends is stale</span>{{end}}</p>
+{{range .Clusters}}
+<h2>{{if .Name}}Cluster {{.Name}}{{else}}[main]{{end}}: primary {{if .Primary}}{{.Primary}}{{else}}<span class="none">none</span>{{end}}</h2>
+<table>
+<tr><th>Backend</th><th>Address</th><th>State</th><th>Lag</th><th>Lag bytes</th><th>Sessions</th><th>Last error</th></tr>
+{{range .Backends}}<tr><td>{{.Name}}</td><td>{{.Addr}}</td><td class="{{.State}}">{{.State}}</td><td>{{.Lag}}</td><td>{{.LagBytes}}</td><td>{{.Sessions}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Recent failovers</h2>
+{{if .Failovers}}<table>
+<tr><th>Time</th><th>Cluster</th><th>From</th><th>To</th><th>Potential loss</th></tr>
+{{range .Failovers}}<tr><td>{{.Time}}</td><td>{{if .Cluster}}{{.Cluster}}{{else}}[main]{{end}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{if .Known}}{{.LossBytes}} bytes, {{.Loss}}{{else}}unknown{{end}}</td></tr>
+{{end}}</table>{{else}}<p>None since arbiter started.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,62 @@
+package arbiter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/solvip/arbiter/pool"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusPage(t *testing.T) {
+	s := &server{pool: pool.New(context.Background(), pool.WithCheckInterval(10*time.Millisecond))}
+	b := &queueBackend{}
+	b.promote()
+	s.pool.PutNamed("pg1", b)
+	for i := range statusFailovers + 1 {
+		s.failovers.add("", &pool.FailoverReport{From: "pg2", To: "pg1", Time: time.Unix(int64(i), 0), Known: true, LossBytes: 100})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	s.handleStatus(w, req)
+
+	var page statusPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected JSON; instead got %q: %s", w.Body.String(), err)
+	}
+	if len(page.Clusters) != 1 || page.Clusters[0].Primary != "pg1" || len(page.Clusters[0].Backends) != 1 {
+		t.Errorf("Expected pg1 as the primary of [main]; instead got %+v", page.Clusters)
+	}
+	if len(page.Failovers) != statusFailovers || page.Failovers[0].Time != time.Unix(statusFailovers, 0).Format(time.RFC3339) {
+		t.Errorf("Expected the last %d failovers, newest first; instead got %+v", statusFailovers, page.Failovers)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleStatus(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "[main]: primary pg1") || !strings.Contains(body, "100 bytes") {
+		t.Errorf("Expected an HTML page; instead got %q", body)
+	}
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{"/?format=json", http.StatusOK},
+		{"/?format=xml", http.StatusBadRequest},
+		{"/nope", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		s.handleStatus(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: Expected %d; instead got %d", c.path, c.code, w.Code)
+		}
+	}
+}
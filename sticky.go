@@ -0,0 +1,36 @@
+package arbiter
+
+import (
+	"net"
+)
+
+// What sessions that followers may serve stick to a follower by; see [main]
+// sticky-reads.
+const (
+	stickyClient = "client"
+	stickyUser   = "user"
+)
+
+// Return the key the session of the client at addr, started with params, sticks to a
+// follower by, as by says, or "" if it doesn't; see pool.StickTo().  Sessions that
+// don't start in the clear, such as those encrypted end to end, have no user to stick
+// by, as clients of no known address have no address to.
+func stickyKey(by string, addr net.Addr, params map[string]string) string {
+	switch by {
+	case stickyClient:
+		if addr == nil {
+			return ""
+		}
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			return host
+		}
+		return addr.String()
+	case stickyUser:
+		if params["user"] == "" {
+			return ""
+		}
+		return params["user"] + "\x00" + params["database"]
+	}
+
+	return ""
+}
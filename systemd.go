@@ -0,0 +1,223 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The first file descriptor systemd passes by socket activation, after stdin, stdout
+// and stderr.
+const listenFDsStart = 3
+
+// namedListener is a listener's socket and its name: for one systemd passed, the name
+// given to it with FileDescriptorName=, or else that of its socket unit.
+type namedListener struct {
+	name string
+	ln   net.Listener
+}
+
+// systemd is how arbiter tells systemd about itself when started by it with
+// Type=notify, per sd_notify(3): that it's ready, what it's doing, that it's alive for
+// WatchdogSec=, and that it's stopping.  Its methods do nothing if it's nil.
+type systemd struct {
+	socket string
+
+	// How often systemd expects to hear that arbiter is alive; zero if it doesn't.
+	watchdog time.Duration
+}
+
+// newSystemd returns the systemd that started arbiter with $NOTIFY_SOCKET, or nil if it
+// wasn't.
+func newSystemd() *systemd {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	sd := &systemd{socket: socket}
+	pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID"))
+	if err == nil && pid != os.Getpid() {
+		return sd
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		sd.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return sd
+}
+
+// Send states, such as READY=1 and STATUS=..., to systemd.  The socket may be in the
+// abstract namespace, prefixed with @.
+func (sd *systemd) notify(states ...string) error {
+	if sd == nil {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sd.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(states, "\n")))
+	return err
+}
+
+// Tell systemd that arbiter is ready once the pool has a primary, since clients of the
+// primary listener are refused until it does: at once if the first checks found one,
+// or else at the first transition that makes one.  Units ordered after arbiter, and
+// systemctl start, wait until then, up to TimeoutStartSec=.
+func (s *server) notifyReady() {
+	if s.systemd == nil {
+		return
+	}
+
+	changed := make(chan struct{}, 1)
+	stop := s.pool.SubscribeFunc(func(pool.Event) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	for {
+		b, err := s.pool.GetForWrite()
+		if err == nil {
+			if err := s.systemd.notify("READY=1", "STATUS=Routing writes to "+b.Addr()); err != nil {
+				log.Printf("Could not notify systemd: %s", err)
+			}
+			return
+		}
+		<-changed
+	}
+}
+
+// Tell systemd that arbiter is alive every half of WatchdogSec=, for as long as the
+// pool can be read from, so that systemd restarts arbiter if it deadlocks.
+func (s *server) pingWatchdog() {
+	if s.systemd == nil || s.systemd.watchdog == 0 {
+		return
+	}
+
+	t := time.NewTicker(s.systemd.watchdog / 2)
+	defer t.Stop()
+	for range t.C {
+		s.pool.Backends()
+		if err := s.systemd.notify("WATCHDOG=1"); err != nil {
+			log.Printf("Could not notify the systemd watchdog: %s", err)
+		}
+	}
+}
+
+// inheritListeners returns the sockets systemd passed arbiter by socket activation,
+// starting at the file descriptor first, and unsets $LISTEN_PID, $LISTEN_FDS and
+// $LISTEN_FDNAMES, so that the processes arbiter starts, such as hooks, don't take
+// them for theirs.  There are none unless $LISTEN_PID is arbiter's, as it isn't for
+// the process arbiter -daemon detaches.
+func inheritListeners(first int) ([]namedListener, error) {
+	pid, n := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", n)
+	}
+	for len(names) < count {
+		names = append(names, "")
+	}
+
+	return fileListeners(first, names[:count])
+}
+
+// Return the listeners on the sockets at the file descriptors from first on, one for
+// each of names.
+func fileListeners(first int, names []string) ([]namedListener, error) {
+	var listeners []namedListener
+	for i, name := range names {
+		f := os.NewFile(uintptr(first+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %d: %w", first+i, err)
+		}
+		listeners = append(listeners, namedListener{name: name, ln: ln})
+	}
+
+	return listeners, nil
+}
+
+// Take the socket inherited for the listener name, on addr: the one named name, or
+// else the one bound to addr.  Returns nil if there's none.
+func (s *server) inherit(name, addr string) net.Listener {
+	for _, byName := range []bool{true, false} {
+		for i, l := range s.inherited {
+			if byName && l.name == name || !byName && boundTo(l.ln, addr) {
+				s.inherited = append(s.inherited[:i], s.inherited[i+1:]...)
+				log.Printf("Listening for the %s listener on the inherited socket %s", name, l.ln.Addr())
+				return l.ln
+			}
+		}
+	}
+	return nil
+}
+
+// Close the sockets inherited that no listener took.
+func (s *server) closeInherited() {
+	for _, l := range s.inherited {
+		log.Printf("Closing the socket %s inherited as %q, which no listener is on", l.ln.Addr(), l.name)
+		l.ln.Close()
+	}
+	s.inherited = nil
+}
+
+// Return whether ln is bound to addr.  One bound to an unspecified address, as by
+// ListenStream=5432, is bound to any listen address on its port that is.
+func boundTo(ln net.Listener, addr string) bool {
+	bound, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || a.Port != bound.Port {
+		return false
+	}
+
+	if len(a.IP) == 0 || a.IP.IsUnspecified() {
+		return bound.IP.IsUnspecified()
+	}
+	return a.IP.Equal(bound.IP)
+}
+
+// keepAliveListener sets the keep-alive period of the clients accepted on an inherited
+// socket, as net.ListenConfig does for those arbiter binds.
+type keepAliveListener struct {
+	*net.TCPListener
+	period time.Duration
+}
+
+func (l keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case l.period < 0:
+		conn.SetKeepAlive(false)
+	case l.period > 0:
+		conn.SetKeepAlivePeriod(l.period)
+	}
+	return conn, nil
+}
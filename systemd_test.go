@@ -0,0 +1,99 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Listen on a notification socket as systemd does, returning its path.
+func listenNotify(t *testing.T) (*net.UnixConn, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd is Unix only")
+	}
+
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+func readNotify(conn *net.UnixConn, timeout time.Duration) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _ := conn.Read(buf)
+	return string(buf[:n])
+}
+
+func TestNotifyReady(t *testing.T) {
+	conn, path := listenNotify(t)
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &queueBackend{}
+	s := &server{systemd: newSystemd(), pool: pool.New(ctx, pool.WithManualChecks(time.Now))}
+	s.pool.Put(b)
+	s.pool.CheckAll()
+	if s.systemd.watchdog != 30*time.Second {
+		t.Errorf("Expected a watchdog of 30s; instead got %s", s.systemd.watchdog)
+	}
+
+	go s.notifyReady()
+	if got := readNotify(conn, 100*time.Millisecond); got != "" {
+		t.Fatalf("Expected no notification without a primary; instead got %q", got)
+	}
+
+	b.promote()
+	s.pool.CheckAll()
+	if got := readNotify(conn, 5*time.Second); got != "READY=1\nSTATUS=Routing writes to 127.0.0.1:5432" {
+		t.Errorf("Expected READY=1 once there's a primary; instead got %q", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if sd := newSystemd(); sd.watchdog != 0 {
+		t.Errorf("Expected no watchdog for another process; instead got %s", sd.watchdog)
+	}
+}
+
+func TestBoundTo(t *testing.T) {
+	for _, test := range []struct {
+		bound, addr string
+		want        bool
+	}{
+		{"127.0.0.1:5432", "127.0.0.1:5432", true},
+		{"127.0.0.1:5432", "127.0.0.1:5433", false},
+		{"127.0.0.1:5432", "10.0.0.1:5432", false},
+		{"[::]:5432", ":5432", true},
+		{"[::]:5432", "0.0.0.0:5432", true},
+		{"127.0.0.1:5432", ":5432", false},
+	} {
+		a, _ := net.ResolveTCPAddr("tcp", test.bound)
+		if got := boundTo(fakeListener{a}, test.addr); got != test.want {
+			t.Errorf("Expected a socket bound to %s to be bound to %s: %t; instead got %t", test.bound, test.addr, test.want, got)
+		}
+	}
+}
+
+type fakeListener struct{ addr net.Addr }
+
+func (l fakeListener) Accept() (net.Conn, error) { return nil, net.ErrClosed }
+func (l fakeListener) Close() error              { return nil }
+func (l fakeListener) Addr() net.Addr            { return l.addr }
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package arbiter
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInheritListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "arbiter.socket")
+	inherited, err := inheritListeners(fd)
+	if err != nil || len(inherited) != 1 || inherited[0].name != "arbiter.socket" {
+		t.Fatalf("Expected the socket passed; instead got %v, %v", inherited, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Errorf("Expected LISTEN_FDS to be unset")
+	}
+
+	s := &server{inherited: inherited, limits: Limits{ClientKeepAlive: time.Minute}}
+	if got := s.inherit("follower", "127.0.0.1:1"); got != nil {
+		t.Errorf("Expected no socket for another address; instead got %s", got.Addr())
+	}
+	got, err := s.listen("primary", ln.Addr().String())
+	if err != nil || got.Addr().String() != ln.Addr().String() || len(s.inherited) != 0 {
+		t.Fatalf("Expected the primary listener on the socket passed; instead got %v, %v", got, err)
+	}
+	got.Close()
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if inherited, err := inheritListeners(fd); err != nil || len(inherited) != 0 {
+		t.Errorf("Expected no sockets passed to another process; instead got %v, %v", inherited, err)
+	}
+}
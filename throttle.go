@@ -0,0 +1,159 @@
+package arbiter
+
+import (
+	"github.com/solvip/arbiter/metrics"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// How often the buckets of clients that haven't connected for a while are forgotten.
+const throttleSweepInterval = time.Minute
+
+// What a throttled client exceeded.
+const (
+	throttleOverall = "overall"
+	throttleClient  = "client"
+)
+
+// tokenBucket admits events at rate per second on average, in bursts of up to burst.
+type tokenBucket struct {
+	rate, burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// Add the tokens earned since the bucket was last refilled.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// Take a token for an event, returning how long the event must wait for it, and false
+// if there's none to spare.  An event may wait for tokens yet to be earned, as long as
+// no more than queue others already do.
+func (b *tokenBucket) reserve(now time.Time, queue int) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens-1 < -float64(queue) {
+		return 0, false
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// Whether the bucket is full, as it is for a client that hasn't connected for a while.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// connThrottle limits the rate at which new clients are admitted, overall and from each
+// client address, so that a client reconnecting in a loop, or a stampede of them, can't
+// exhaust the sessions and backend connections that the rest need.  Clients over the
+// overall rate wait their turn in the accept queue, up to its size, and are turned away
+// beyond it; those over their own rate are turned away right away.
+type connThrottle struct {
+	mu sync.Mutex
+
+	// The bucket of all clients, nil if unlimited, and how many may wait on it.
+	overall *tokenBucket
+	queue   int
+
+	// The rate and burst of each client address, and their buckets, if limited.
+	clientRate  float64
+	clientBurst float64
+	clients     map[string]*tokenBucket
+	swept       time.Time
+
+	now func() time.Time
+}
+
+// Return a throttle enforcing the connection rates of l, or nil if there are none.
+func newConnThrottle(l Limits) *connThrottle {
+	if l.ConnectionRate <= 0 && l.ClientConnectionRate <= 0 {
+		return nil
+	}
+
+	t := &connThrottle{
+		queue:       l.AcceptQueue,
+		clientRate:  l.ClientConnectionRate,
+		clientBurst: float64(l.ClientConnectionBurst),
+		clients:     make(map[string]*tokenBucket),
+		now:         time.Now,
+	}
+	t.swept = t.now()
+	if l.ConnectionRate > 0 {
+		t.overall = newTokenBucket(l.ConnectionRate, float64(l.ConnectionBurst), t.now())
+	}
+
+	return t
+}
+
+// Admit a new client within the overall rate, returning how long it must wait in the
+// accept queue first, and false if it's to be turned away.
+func (t *connThrottle) admit() (time.Duration, bool) {
+	if t == nil || t.overall == nil {
+		return 0, true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.overall.reserve(t.now(), t.queue)
+}
+
+// Whether a new client from addr is within the rate of its address.
+func (t *connThrottle) admitClient(addr net.Addr) bool {
+	if t == nil || t.clientRate <= 0 {
+		return true
+	}
+
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if now.Sub(t.swept) >= throttleSweepInterval {
+		for h, b := range t.clients {
+			if b.full(now) {
+				delete(t.clients, h)
+			}
+		}
+		t.swept = now
+	}
+
+	b := t.clients[host]
+	if b == nil {
+		b = newTokenBucket(t.clientRate, t.clientBurst, now)
+		t.clients[host] = b
+	}
+
+	_, ok := b.reserve(now, 0)
+	return ok
+}
+
+// Turn away a client of listener that exceeded the connection rate of scope.
+func (s *server) throttled(conn net.Conn, listener, scope string) {
+	s.sink.AddCounter("arbiter_throttled_connections_total",
+		metrics.Labels{"listener": listener, "scope": scope}, 1)
+
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	writeFatal(conn, sqlstateTooManyConnections, "arbiter: connection rate exceeded")
+	conn.Close()
+}
@@ -0,0 +1,84 @@
+package arbiter
+
+import (
+	"bytes"
+	"github.com/solvip/arbiter/metrics"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnThrottle(t *testing.T) {
+	if newConnThrottle(Limits{}) != nil {
+		t.Fatalf("Expected no throttle without a rate")
+	}
+
+	now := time.Now()
+	th := newConnThrottle(Limits{ConnectionRate: 1, ConnectionBurst: 2, AcceptQueue: 1,
+		ClientConnectionRate: 1, ClientConnectionBurst: 1})
+	th.now = func() time.Time { return now }
+	th.overall.last, th.swept = now, now
+
+	// A burst is admitted right away, and the next client waits its turn in the queue,
+	// which is then full.
+	for i := 0; i < 2; i++ {
+		if wait, ok := th.admit(); !ok || wait != 0 {
+			t.Fatalf("Expected client %d to be admitted right away; instead got %s, %v", i, wait, ok)
+		}
+	}
+	if wait, ok := th.admit(); !ok || wait != time.Second {
+		t.Fatalf("Expected the third client to wait a second; instead got %s, %v", wait, ok)
+	}
+	if _, ok := th.admit(); ok {
+		t.Fatalf("Expected the fourth client to be turned away with the queue full")
+	}
+	now = now.Add(2 * time.Second)
+	if wait, ok := th.admit(); !ok || wait != 0 {
+		t.Fatalf("Expected a client to be admitted once the rate allows; instead got %s, %v", wait, ok)
+	}
+
+	// Each client address has a rate of its own.
+	a := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+	b := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+	if !th.admitClient(a) || !th.admitClient(b) {
+		t.Fatalf("Expected each client's first connection to be admitted")
+	}
+	if th.admitClient(&net.TCPAddr{IP: a.IP, Port: 5001}) {
+		t.Errorf("Expected a's second connection to be turned away, whatever its port")
+	}
+	now = now.Add(time.Second)
+	if !th.admitClient(a) {
+		t.Errorf("Expected a to be admitted once its rate allows")
+	}
+
+	// Clients that haven't connected for a while are forgotten.
+	now = now.Add(throttleSweepInterval)
+	th.admitClient(b)
+	if _, ok := th.clients[a.IP.String()]; ok || len(th.clients) != 1 {
+		t.Errorf("Expected only b to be remembered; instead got %v", th.clients)
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	mem := metrics.NewMemory()
+	s := &server{metrics: mem, sink: mem}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.throttled(conn, "primary", throttleClient)
+
+	var want bytes.Buffer
+	writeFatal(&want, sqlstateTooManyConnections, "arbiter: connection rate exceeded")
+	got := make([]byte, want.Len())
+	if _, err := io.ReadFull(client, got); err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Expected the client to receive %q; instead got %q, %v", want.Bytes(), got, err)
+	}
+
+	var out strings.Builder
+	mem.WritePrometheus(&out)
+	if !strings.Contains(out.String(), `arbiter_throttled_connections_total{listener="primary",scope="client"} 1`) {
+		t.Fatalf("Expected the throttled connection to be counted; instead got:\n%s", out.String())
+	}
+}
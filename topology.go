@@ -0,0 +1,234 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Serve /connstring, the live topology as a multi-host connection string, primary
+// first, for clients not yet routed through arbiter.  The format parameter is libpq
+// (the default), uri or jdbc; target is a target_session_attrs, read-write by default;
+// dbname is included if given.
+func (s *server) handleConnString(w http.ResponseWriter, req *http.Request) {
+	format := req.FormValue("format")
+	if format == "" {
+		format = pool.FormatKeywords
+	}
+
+	target := req.FormValue("target")
+	if target == "" {
+		target = "read-write"
+	}
+
+	addrs := s.pool.Topology()
+	if len(addrs) == 0 {
+		http.Error(w, "no backend available", http.StatusServiceUnavailable)
+		return
+	}
+
+	cs, err := pool.FormatConnString(addrs, format, target, req.FormValue("dbname"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(cs + "\n"))
+}
+
+// resolved is the answer of /resolve.
+type resolved struct {
+	Class    string            `json:"class"`
+	Backends []resolvedBackend `json:"backends"`
+}
+
+type resolvedBackend struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// Serve /resolve, the backends a caller of the class parameter, eventual by default,
+// may be routed to right now, closest first, for clients that balance their
+// connections themselves; see the resolver package.
+func (s *server) handleResolve(w http.ResponseWriter, req *http.Request) {
+	class := req.FormValue("class")
+	if class == "" {
+		class = "eventual"
+	}
+
+	names, err := s.pool.Candidates(class)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %s", class, err), http.StatusNotFound)
+		return
+	}
+
+	addrs := make(map[string]string)
+	s.pool.ForEach(func(b pool.BackendInfo) bool {
+		addrs[b.Name] = b.Addr
+		return true
+	})
+
+	r := resolved{Class: class, Backends: []resolvedBackend{}}
+	for _, name := range names {
+		if addr, ok := addrs[name]; ok {
+			r.Backends = append(r.Backends, resolvedBackend{Name: name, Addr: addr})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, r)
+}
+
+// The formats of /topology.
+const (
+	graphDOT     = "dot"
+	graphMermaid = "mermaid"
+)
+
+// Serve /topology, the observed replication topology as a graph for incidents and
+// runbooks.  The format parameter is dot (the default), for Graphviz, or mermaid.
+func (s *server) handleTopology(w http.ResponseWriter, req *http.Request) {
+	format := req.FormValue("format")
+	if format == "" {
+		format = graphDOT
+	}
+
+	graph, err := renderTopology(s.pool.Backends(), format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(graph))
+}
+
+// Render backends as a graph in format: an edge from each follower's upstream to it,
+// labeled with its lag, and a dotted edge from arbiter, observing them, to each
+// backend, labeled with its round-trip time.  Followers whose upstream isn't known, or
+// isn't one of the backends, are drawn replicating from the primary.
+func renderTopology(backends []pool.BackendInfo, format string) (string, error) {
+	if format != graphDOT && format != graphMermaid {
+		return "", fmt.Errorf("unknown format '%s'", format)
+	}
+
+	primary := -1
+	for i, b := range backends {
+		if b.State == pool.READ_WRITE {
+			primary = i
+		}
+	}
+
+	var g strings.Builder
+	node, edge, observe := dotNode, dotEdge, dotObserve
+	if format == graphDOT {
+		g.WriteString("digraph topology {\n\trankdir=LR;\n\tarbiter [shape=box];\n")
+	} else {
+		g.WriteString("graph LR\n\tclassDef unavailable stroke-dasharray: 5 5, color: gray\n\tarbiter[[arbiter]]\n")
+		node, edge, observe = mermaidNode, mermaidEdge, mermaidObserve
+	}
+
+	for i, b := range backends {
+		node(&g, i, b)
+	}
+
+	for i, b := range backends {
+		if b.State == pool.UNAVAILABLE {
+			continue
+		}
+		observe(&g, i, "rtt "+b.RTT.String())
+
+		if b.State != pool.READ_ONLY {
+			continue
+		}
+		if from := upstreamOf(backends, b, primary); from >= 0 {
+			lag := fmt.Sprintf("lag %s", b.Lag)
+			if b.Lag == 0 {
+				lag = fmt.Sprintf("lag %d bytes", b.LagBytes)
+			}
+			edge(&g, from, i, lag)
+		}
+	}
+
+	if format == graphDOT {
+		g.WriteString("}\n")
+	}
+
+	return g.String(), nil
+}
+
+// Return the index of the backend b replicates from; the one at its upstream address,
+// or failing that the one on its upstream host, or failing that the primary.
+func upstreamOf(backends []pool.BackendInfo, b pool.BackendInfo, primary int) int {
+	if b.Upstream == "" {
+		return primary
+	}
+
+	host, _, _ := net.SplitHostPort(b.Upstream)
+	onHost := -1
+	for i, u := range backends {
+		if u.Name == b.Name {
+			continue
+		}
+		if u.Addr == b.Upstream {
+			return i
+		}
+		if uhost, _, _ := net.SplitHostPort(u.Addr); uhost == host && onHost < 0 {
+			onHost = i
+		}
+	}
+
+	if onHost >= 0 {
+		return onHost
+	}
+
+	return primary
+}
+
+func dotNode(g *strings.Builder, i int, b pool.BackendInfo) {
+	style := ""
+	switch b.State {
+	case pool.READ_WRITE:
+		style = ", style=bold"
+	case pool.UNAVAILABLE:
+		style = ", style=dashed, color=gray"
+	}
+	fmt.Fprintf(g, "\tn%d [label=\"%s\"%s];\n", i, dotEscape(b.Name+"\n"+b.Addr+"\n"+role(b)), style)
+}
+
+func dotEdge(g *strings.Builder, from, to int, label string) {
+	fmt.Fprintf(g, "\tn%d -> n%d [label=\"%s\"];\n", from, to, dotEscape(label))
+}
+
+func dotObserve(g *strings.Builder, to int, label string) {
+	fmt.Fprintf(g, "\tarbiter -> n%d [style=dotted, label=\"%s\"];\n", to, dotEscape(label))
+}
+
+// Escape s for a quoted DOT string, keeping newlines as line breaks.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func mermaidNode(g *strings.Builder, i int, b pool.BackendInfo) {
+	label := mermaidEscape(b.Name) + "<br/>" + mermaidEscape(b.Addr) + "<br/>" + role(b)
+	if b.State == pool.UNAVAILABLE {
+		fmt.Fprintf(g, "\tn%d[\"%s\"]:::unavailable\n", i, label)
+		return
+	}
+	fmt.Fprintf(g, "\tn%d[\"%s\"]\n", i, label)
+}
+
+func mermaidEdge(g *strings.Builder, from, to int, label string) {
+	fmt.Fprintf(g, "\tn%d -->|\"%s\"| n%d\n", from, mermaidEscape(label), to)
+}
+
+func mermaidObserve(g *strings.Builder, to int, label string) {
+	fmt.Fprintf(g, "\tarbiter -.->|\"%s\"| n%d\n", mermaidEscape(label), to)
+}
+
+// Escape s for a quoted Mermaid label.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// OTel adapts an OpenTelemetry trace.Tracer, such as one of the embedder's
+// TracerProvider, to a Tracer.  Spans are started as children of the span the context
+// carries, be it local or remote, as set by WithTraceparent.
+func OTel(t trace.Tracer) Tracer {
+	return otelTracer{t}
+}
+
+type otelTracer struct {
+	t trace.Tracer
+}
+
+func (o otelTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, s := o.t.Start(ctx, name)
+	return ctx, otelSpan{s}
+}
+
+type otelSpan struct {
+	s trace.Span
+}
+
+func (o otelSpan) SetAttribute(key string, value any) {
+	o.s.SetAttributes(keyValue(key, value))
+}
+
+func (o otelSpan) SetError(err error) {
+	if err != nil {
+		o.s.RecordError(err)
+		o.s.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (o otelSpan) End() {
+	o.s.End()
+}
+
+// Return key and value as an attribute, with durations as their string.
+func keyValue(key string, value any) attribute.KeyValue {
+	switch value := value.(type) {
+	case string:
+		return attribute.String(key, value)
+	case bool:
+		return attribute.Bool(key, value)
+	case int:
+		return attribute.Int(key, value)
+	case int64:
+		return attribute.Int64(key, value)
+	case float64:
+		return attribute.Float64(key, value)
+	case time.Duration:
+		return attribute.String(key, value.String())
+	default:
+		return attribute.String(key, fmt.Sprint(value))
+	}
+}
+
+// WithTraceparent returns ctx carrying the remote span that traceparent, a W3C Trace
+// Context traceparent header, identifies, so that the spans started with it continue
+// the client's trace; or ctx if traceparent isn't valid.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
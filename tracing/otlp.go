@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"net/url"
+	"time"
+)
+
+// How many ended spans OTLP holds between exports; those beyond are dropped.
+const otlpMaxQueued = 4096
+
+// How long OTLP waits for the collector to accept an export.
+const otlpTimeout = 10 * time.Second
+
+// OTLP is a Tracer that exports spans to an OpenTelemetry collector with OTLP over
+// HTTP, through the OpenTelemetry SDK.  Traces are sampled as they start, at the sample
+// rate unless they continue a remote trace whose sampling decision they follow, and
+// spans are sampled with the trace they're part of.  Ended spans are batched and sent
+// every interval; they're dropped rather than held if the collector is unreachable.
+type OTLP struct {
+	Tracer
+	provider *sdktrace.TracerProvider
+}
+
+// NewOTLP returns an OTLP exporting spans to endpoint every interval, as service,
+// sampling rate of traces, between 0 and 1.  endpoint is the base URL of the collector,
+// e.g. http://localhost:4318, to which /v1/traces is appended, or the URL of its traces
+// endpoint if it has a path.
+func NewOTLP(endpoint, service string, rate float64, interval time.Duration) (*OTLP, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http or https URL; got '%s'", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithTimeout(otlpTimeout),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{Enabled: false}))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter,
+			sdktrace.WithBatchTimeout(interval),
+			sdktrace.WithMaxQueueSize(otlpMaxQueued),
+			sdktrace.WithMaxExportBatchSize(otlpMaxQueued)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))))
+
+	return &OTLP{Tracer: OTel(provider.Tracer("github.com/solvip/arbiter")), provider: provider}, nil
+}
+
+// Close exports what's queued and stops exporting.
+func (o *OTLP) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+
+	return o.provider.Shutdown(ctx)
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Return a collector that records the spans exported to it.
+func newTestCollector(t *testing.T) (*httptest.Server, func() []*tracepb.ResourceSpans) {
+	var mu sync.Mutex
+	var got []*tracepb.ResourceSpans
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req collectorpb.ExportTraceServiceRequest
+		if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		got = append(got, req.ResourceSpans...)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []*tracepb.ResourceSpans {
+		mu.Lock()
+		defer mu.Unlock()
+		return got
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	srv, exported := newTestCollector(t)
+	o, err := NewOTLP(srv.URL, "arbiter", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected the exporter to start; instead got %v", err)
+	}
+
+	ctx, accept := o.Start(context.Background(), "arbiter.accept")
+	accept.SetAttribute("arbiter.listener", "primary")
+	_, dial := o.Start(ctx, "arbiter.dial")
+	dial.SetAttribute("arbiter.tries", 2)
+	dial.SetError(errors.New("connection refused"))
+	dial.End()
+	accept.End()
+
+	if err := o.Close(); err != nil {
+		t.Fatalf("Expected the spans to be exported; instead got %v", err)
+	}
+
+	got := exported()
+	if len(got) != 1 || len(got[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected a single export; instead got %+v", got)
+	}
+	if name := got[0].Resource.Attributes[0]; name.Key != "service.name" || name.Value.GetStringValue() != "arbiter" {
+		t.Errorf("Expected the service to be named arbiter; instead got %+v", name)
+	}
+
+	spans := got[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans; instead got %+v", spans)
+	}
+	d, a := spans[0], spans[1]
+	if d.Name != "arbiter.dial" || a.Name != "arbiter.accept" {
+		t.Fatalf("Expected the dial, then the accept; instead got %s, %s", d.Name, a.Name)
+	}
+	if string(d.TraceId) != string(a.TraceId) || string(d.ParentSpanId) != string(a.SpanId) || len(a.ParentSpanId) != 0 {
+		t.Errorf("Expected the dial to be a child of the accept; instead got %+v, %+v", d, a)
+	}
+	if d.Status.Code != tracepb.Status_STATUS_CODE_ERROR || d.Status.Message != "connection refused" ||
+		a.Status.Code != tracepb.Status_STATUS_CODE_UNSET {
+		t.Errorf("Expected only the dial to have failed; instead got %+v, %+v", d.Status, a.Status)
+	}
+	if len(d.Attributes) != 1 || d.Attributes[0].Value.GetIntValue() != 2 {
+		t.Errorf("Expected the dial's tries to be an integer; instead got %+v", d.Attributes)
+	}
+}
+
+func TestOTLPTraceparent(t *testing.T) {
+	srv, exported := newTestCollector(t)
+	o, err := NewOTLP(srv.URL, "arbiter", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unsampled traces record nothing, nor do their children.
+	ctx, root := o.Start(context.Background(), "arbiter.accept")
+	_, child := o.Start(ctx, "arbiter.dial")
+	child.End()
+	root.End()
+
+	// Those of clients follow the client's decision to sample them.
+	ctx = WithTraceparent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, accept := o.Start(ctx, "arbiter.accept")
+	accept.End()
+
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := exported()
+	if len(got) != 1 || len(got[0].ScopeSpans) != 1 || len(got[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("Expected only the client's span to be exported; instead got %+v", got)
+	}
+	a := got[0].ScopeSpans[0].Spans[0]
+	if hex.EncodeToString(a.TraceId) != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		hex.EncodeToString(a.ParentSpanId) != "00f067aa0ba902b7" {
+		t.Errorf("Expected the accept to be a child of the client's span; instead got %+v", a)
+	}
+}
@@ -0,0 +1,42 @@
+// tracing defines the interface through which arbiter reports spans of its work, such
+// as health checks and connecting clients to backends, so that embedders can see its
+// part in their distributed traces, e.g. by adapting an OpenTelemetry trace.Tracer
+// with OTel.
+package tracing
+
+import (
+	"context"
+)
+
+// Tracer starts spans.  Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span named name, a child of the span ctx carries if any, and
+	// returns a context carrying the new span, to be passed to the work it covers.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation being traced.  Spans are used by one goroutine at a time.
+type Span interface {
+	// SetAttribute annotates the span with key; value is a string, bool, int, int64,
+	// float64 or time.Duration.
+	SetAttribute(key string, value any)
+
+	// SetError marks the span as failed with err.
+	SetError(err error)
+
+	// End ends the span.  Nothing is to be done with it afterwards.
+	End()
+}
+
+// Nop is a Tracer that discards all spans.
+type Nop struct{}
+
+func (Nop) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, any) {}
+func (nopSpan) SetError(error)           {}
+func (nopSpan) End()                     {}
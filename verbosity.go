@@ -0,0 +1,214 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long verbosity raised through /verbosity lasts, unless the ttl parameter says
+// otherwise.
+const defaultVerbosityTTL = 15 * time.Minute
+
+// verbosity is what's logged and measured beyond what the configuration asks for, as
+// raised through /verbosity for deep debugging during an incident, until it reverts.
+type verbosity struct {
+	// The level records are logged from, and the one configured, reverted to.
+	level slog.LevelVar
+	base  slog.Level
+
+	// Whether the routing decision of every session is logged, and whether sessions are
+	// counted by client address in arbiter_client_sessions_total.
+	routing  atomic.Bool
+	detailed atomic.Bool
+
+	// Guards everything below.
+	mu sync.Mutex
+
+	// The clients counted, whose series are deleted on revert.
+	clients map[string]bool
+
+	// Who raised the verbosity, and until when; zero unless it's raised.
+	operator string
+	until    time.Time
+	revert   *time.Timer
+}
+
+func newVerbosity(base slog.Level) *verbosity {
+	v := &verbosity{base: base}
+	v.level.Set(base)
+	return v
+}
+
+// verbosityState is the JSON document served on /verbosity.
+type verbosityState struct {
+	Level           string `json:"level"`
+	RoutingLogs     bool   `json:"routing_logs"`
+	DetailedMetrics bool   `json:"detailed_metrics"`
+	Operator        string `json:"operator,omitempty"`
+	Until           string `json:"until,omitempty"`
+}
+
+func (v *verbosity) state() verbosityState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	st := verbosityState{
+		Level:           strings.ToLower(v.level.Level().String()),
+		RoutingLogs:     v.routing.Load(),
+		DetailedMetrics: v.detailed.Load(),
+		Operator:        v.operator,
+	}
+	if !v.until.IsZero() {
+		st.Until = v.until.Format(time.RFC3339)
+	}
+
+	return st
+}
+
+// Serve /verbosity.  GET shows the verbosity in effect.  POST raises it for the ttl
+// parameter, 15 minutes by default, after which it reverts to the configuration: level
+// sets the level records are logged from, routing=true logs the routing decision of
+// every session, and metrics=true counts sessions by client address.  DELETE reverts
+// it right away.  The operator must name themselves in the X-Arbiter-Operator header
+// to change it, and changes are logged for audit.
+func (s *server) handleVerbosity(w http.ResponseWriter, req *http.Request) {
+	v := s.verbosity
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, v.state())
+		return
+	case http.MethodPost, http.MethodDelete:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator := strings.TrimSpace(req.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		s.logger.Printf("Audit: %s reverted the verbosity", operator)
+		s.revertVerbosity(time.Time{})
+		writeJSON(w, http.StatusOK, v.state())
+		return
+	}
+
+	level := v.base
+	if l := req.FormValue("level"); l != "" {
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid level '%s': %s", l, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var flags [2]bool
+	for i, name := range []string{"routing", "metrics"} {
+		if f := req.FormValue(name); f != "" {
+			var err error
+			if flags[i], err = strconv.ParseBool(f); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s '%s'", name, f), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	ttl := defaultVerbosityTTL
+	if t := req.FormValue("ttl"); t != "" {
+		var err error
+		if ttl, err = time.ParseDuration(t); err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl '%s'", t), http.StatusBadRequest)
+			return
+		}
+	}
+
+	v.mu.Lock()
+	if v.revert != nil {
+		v.revert.Stop()
+	}
+	v.level.Set(level)
+	v.routing.Store(flags[0])
+	v.detailed.Store(flags[1])
+	until := time.Now().Add(ttl)
+	v.operator, v.until = operator, until
+	v.revert = time.AfterFunc(ttl, func() { s.revertVerbosity(until) })
+	v.mu.Unlock()
+
+	s.logger.Printf("Audit: %s set the verbosity to level %s, routing logs %t and detailed metrics %t for %s",
+		operator, level, flags[0], flags[1], ttl)
+	writeJSON(w, http.StatusOK, v.state())
+}
+
+// Revert the verbosity to the configuration, dropping the series of detailed metrics,
+// unless until isn't zero and the verbosity was since raised to last until another time.
+func (s *server) revertVerbosity(until time.Time) {
+	v := s.verbosity
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !until.IsZero() && !until.Equal(v.until) {
+		return
+	}
+	if v.revert != nil {
+		v.revert.Stop()
+		v.revert = nil
+	}
+	if v.operator != "" {
+		s.logger.Printf("Verbosity raised by %s reverted", v.operator)
+	}
+
+	v.level.Set(v.base)
+	v.routing.Store(false)
+	v.detailed.Store(false)
+	v.operator, v.until = "", time.Time{}
+
+	if d, ok := s.sink.(metrics.Deleter); ok {
+		for client := range v.clients {
+			d.Delete(metrics.Labels{"client": client})
+		}
+	}
+	v.clients = nil
+}
+
+// Note that the client on conn was routed to the backend by r in mode, logging and
+// measuring it as the verbosity asks.
+func (s *server) routed(conn net.Conn, r *route, backend string, mode pool.Mode) {
+	v := s.verbosity
+	if v == nil {
+		return
+	}
+
+	if v.routing.Load() {
+		s.logger.Printf("Routed %s from listener %s with class %s to %s in %s mode",
+			conn.RemoteAddr(), r.listener, r.class, backend, mode)
+	}
+
+	if v.detailed.Load() {
+		client, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+		// Checked again, so that no series is left behind by a revert.
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if !v.detailed.Load() {
+			return
+		}
+		if v.clients == nil {
+			v.clients = make(map[string]bool)
+		}
+		v.clients[client] = true
+		s.sink.AddCounter("arbiter_client_sessions_total",
+			metrics.Labels{"client": client, "listener": r.listener, "backend": backend}, 1)
+	}
+}
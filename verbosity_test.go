@@ -0,0 +1,73 @@
+package arbiter
+
+import (
+	"encoding/json"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerbosity(t *testing.T) {
+	m := metrics.NewMemory()
+	s := &server{
+		logger:    log.New(io.Discard, "", 0),
+		metrics:   m,
+		sink:      labelingSink{Sink: m},
+		verbosity: newVerbosity(slog.LevelInfo),
+	}
+
+	set := func(method, query, operator string) (int, verbosityState) {
+		req := httptest.NewRequest(method, "/verbosity"+query, nil)
+		if operator != "" {
+			req.Header.Set(operatorHeader, operator)
+		}
+		w := httptest.NewRecorder()
+		s.handleVerbosity(w, req)
+
+		var st verbosityState
+		json.Unmarshal(w.Body.Bytes(), &st)
+		return w.Code, st
+	}
+
+	if code, _ := set("POST", "?level=debug", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected the operator to be required; instead got %d", code)
+	}
+	if code, _ := set("POST", "?level=loud", "alice"); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid level to be rejected; instead got %d", code)
+	}
+
+	code, st := set("POST", "?level=debug&routing=true&metrics=true&ttl=50ms", "alice")
+	if code != http.StatusOK || st.Level != "debug" || !st.RoutingLogs || !st.DetailedMetrics || st.Operator != "alice" {
+		t.Fatalf("Expected the verbosity to be raised; instead got %d %+v", code, st)
+	}
+	if s.verbosity.level.Level() != slog.LevelDebug {
+		t.Errorf("Expected records to be logged from DEBUG; instead got %s", s.verbosity.level.Level())
+	}
+
+	client, frontend := tcpPipe(t)
+	defer client.Close()
+	defer frontend.Close()
+	series := metrics.Labels{"client": "127.0.0.1", "listener": "primary", "backend": "pg1"}
+	s.routed(frontend, &route{listener: "primary", class: "strong"}, "pg1", pool.HEALTHY)
+	if n, _ := m.Get("arbiter_client_sessions_total", series); n != 1 {
+		t.Errorf("Expected the session to be counted by client; instead got %v", n)
+	}
+
+	// Once the TTL runs out, the verbosity reverts, and the detailed series are dropped.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.verbosity.state().Operator != "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, st := set("GET", "", ""); st.Level != "info" || st.RoutingLogs || st.DetailedMetrics || st.Operator != "" {
+		t.Errorf("Expected the verbosity to revert; instead got %+v", st)
+	}
+	if _, ok := m.Get("arbiter_client_sessions_total", series); ok {
+		t.Errorf("Expected the detailed series to be dropped")
+	}
+}
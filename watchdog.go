@@ -0,0 +1,156 @@
+package arbiter
+
+import (
+	"fmt"
+	"github.com/solvip/arbiter/pool"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How often the scheduler latency is probed: how late a goroutine sleeping this long
+// wakes up.
+const schedulerProbeInterval = 100 * time.Millisecond
+
+// watchdogLimits are limits on arbiter's own resource usage; zero disables a limit.
+type watchdogLimits struct {
+	// How often usage is measured.
+	interval time.Duration
+
+	goroutines       int
+	heapBytes        int64
+	openFilesPercent float64
+	schedulerLatency time.Duration
+
+	// Whether memory is returned to the OS when the heap limit is breached, and how long
+	// a limit stays breached before arbiter exits, if ever.
+	freeMemory bool
+	exitAfter  time.Duration
+}
+
+// watchdog measures arbiter's own resource usage, exporting it as metrics, and raises
+// an alarm while it's past its limits, so that arbiter doesn't silently become the
+// unhealthy part of the cluster.
+type watchdog struct {
+	s      *server
+	limits watchdogLimits
+
+	// The worst scheduler latency seen since the last measurement, in nanoseconds.
+	latency atomic.Int64
+
+	// The limits breached at the last measurement, and since when they've been.
+	breached []string
+	since    time.Time
+}
+
+func newWatchdog(s *server, limits watchdogLimits) *watchdog {
+	return &watchdog{s: s, limits: limits}
+}
+
+// Measure until the process exits.
+func (w *watchdog) run() {
+	go w.probe()
+
+	for range time.Tick(w.limits.interval) {
+		w.check(time.Now())
+	}
+}
+
+// Track how late a sleeping goroutine wakes up, which is how late any goroutine of
+// arbiter gets to run, such as those proxying sessions.
+func (w *watchdog) probe() {
+	for {
+		start := time.Now()
+		time.Sleep(schedulerProbeInterval)
+		late := int64(time.Since(start) - schedulerProbeInterval)
+		for {
+			worst := w.latency.Load()
+			if late <= worst || w.latency.CompareAndSwap(worst, late) {
+				break
+			}
+		}
+	}
+}
+
+// Measure resource usage at now, exporting it, and alarm about the limits it's past,
+// returning them.
+func (w *watchdog) check(now time.Time) []string {
+	goroutines := runtime.NumGoroutine()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	latency := time.Duration(w.latency.Swap(0))
+
+	w.s.sink.SetGauge("arbiter_goroutines", nil, float64(goroutines))
+	w.s.sink.SetGauge("arbiter_heap_bytes", nil, float64(ms.HeapAlloc))
+	w.s.sink.SetGauge("arbiter_scheduler_latency_seconds", nil, latency.Seconds())
+
+	var breached []string
+	if l := w.limits.goroutines; l > 0 && goroutines >= l {
+		breached = append(breached, fmt.Sprintf("goroutines %d over %d", goroutines, l))
+	}
+	if l := w.limits.heapBytes; l > 0 && int64(ms.HeapAlloc) >= l {
+		breached = append(breached, fmt.Sprintf("heap %d bytes over %d", ms.HeapAlloc, l))
+		if w.limits.freeMemory {
+			debug.FreeOSMemory()
+		}
+	}
+	if l := w.limits.openFilesPercent; l > 0 {
+		if open, max := descriptorUsage(); open >= 0 && max > 0 && 100*float64(open)/float64(max) >= l {
+			breached = append(breached, fmt.Sprintf("open files %d of %d over %g%%", open, max, l))
+		}
+	}
+	if l := w.limits.schedulerLatency; l > 0 && latency >= l {
+		breached = append(breached, fmt.Sprintf("scheduler latency %s over %s", latency, l))
+	}
+
+	w.alarm(now, breached)
+	return breached
+}
+
+// Raise or clear the alarm as the limits breached at now changed, exiting if they've
+// been breached for too long.
+func (w *watchdog) alarm(now time.Time, breached []string) {
+	switch {
+	case len(breached) > 0 && len(w.breached) == 0:
+		w.since = now
+		fallthrough
+	case len(breached) > 0 && !sameLimits(breached, w.breached):
+		w.s.logger.Printf("Watchdog: past limits: %s", strings.Join(breached, "; "))
+		w.s.sink.SetGauge("arbiter_watchdog_breached", nil, 1)
+		w.announce(now, strings.Join(breached, "; "))
+	case len(breached) == 0 && len(w.breached) > 0:
+		w.s.logger.Printf("Watchdog: back within limits after %s", now.Sub(w.since).Round(time.Second))
+		w.s.sink.SetGauge("arbiter_watchdog_breached", nil, 0)
+		w.announce(now, "recovered")
+	}
+	w.breached = breached
+
+	if len(breached) > 0 && w.limits.exitAfter > 0 && now.Sub(w.since) >= w.limits.exitAfter {
+		w.s.logger.Printf("Watchdog: limits breached for %s; exiting to be restarted", now.Sub(w.since).Round(time.Second))
+		w.s.crashed("watchdog: "+strings.Join(breached, "; "), exitWatchdog)
+	}
+}
+
+// Publish an event about arbiter's health to subscribers, such as /events and the bus.
+func (w *watchdog) announce(now time.Time, health string) {
+	if w.s.pool == nil {
+		return
+	}
+
+	w.s.pool.Announce(pool.Event{Name: "arbiter", Time: now, SelfHealth: health})
+}
+
+// Whether a and b breach the same limits, whatever the figures.
+func sameLimits(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.Fields(a[i])[0] != strings.Fields(b[i])[0] {
+			return false
+		}
+	}
+	return true
+}
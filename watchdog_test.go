@@ -0,0 +1,59 @@
+package arbiter
+
+import (
+	"context"
+	"github.com/solvip/arbiter/metrics"
+	"github.com/solvip/arbiter/pool"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	m := metrics.NewMemory()
+	s := &server{pool: pool.New(context.Background()), sink: m, logger: log.Default()}
+	events := s.pool.Subscribe()
+
+	w := newWatchdog(s, watchdogLimits{interval: time.Second, goroutines: 1, heapBytes: 1 << 50})
+	now := time.Now()
+
+	breached := w.check(now)
+	if len(breached) != 1 || !strings.HasPrefix(breached[0], "goroutines ") {
+		t.Fatalf("Expected the goroutine limit to be breached; instead got %v", breached)
+	}
+	if v, _ := m.Get("arbiter_goroutines", nil); v < 1 {
+		t.Errorf("Expected arbiter_goroutines to be exported; instead got %g", v)
+	}
+	if v, _ := m.Get("arbiter_watchdog_breached", nil); v != 1 {
+		t.Errorf("Expected arbiter_watchdog_breached to be 1; instead got %g", v)
+	}
+
+	select {
+	case e := <-events:
+		if e.Name != "arbiter" || !strings.HasPrefix(e.SelfHealth, "goroutines ") {
+			t.Fatalf("Expected a self-health event; instead got %+v", e)
+		}
+	default:
+		t.Fatalf("Expected a self-health event")
+	}
+
+	// Still breached, which isn't announced again.
+	w.check(now.Add(time.Second))
+	select {
+	case e := <-events:
+		t.Fatalf("Expected no event while the same limit stays breached; instead got %+v", e)
+	default:
+	}
+
+	w.limits.goroutines = 1 << 20
+	if breached := w.check(now.Add(2 * time.Second)); len(breached) != 0 {
+		t.Fatalf("Expected no limit to be breached; instead got %v", breached)
+	}
+	if e := <-events; e.SelfHealth != "recovered" {
+		t.Fatalf("Expected a recovery event; instead got %+v", e)
+	}
+	if v, _ := m.Get("arbiter_watchdog_breached", nil); v != 0 {
+		t.Errorf("Expected arbiter_watchdog_breached to be 0; instead got %g", v)
+	}
+}
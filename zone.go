@@ -0,0 +1,51 @@
+package arbiter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// clientZones maps client addresses to the zones they're in; see [main] client-zone.
+type clientZones []clientZone
+
+type clientZone struct {
+	net  *net.IPNet
+	zone string
+}
+
+// Parse client-zone settings of the form "10.1.0.0/16 eu-west-1".
+func parseClientZones(settings []string) (zones clientZones, err error) {
+	for _, setting := range settings {
+		fields := strings.Fields(setting)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q: expected a CIDR block and a zone", setting)
+		}
+
+		_, n, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, clientZone{net: n, zone: fields[1]})
+	}
+
+	return zones, nil
+}
+
+// Return the zone of the client at addr: that of the narrowest block containing it, or
+// "" if none does.
+func (zs clientZones) lookup(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+
+	zone, best := "", -1
+	for _, z := range zs {
+		if ones, _ := z.net.Mask.Size(); ones > best && z.net.Contains(tcp.IP) {
+			zone, best = z.zone, ones
+		}
+	}
+
+	return zone
+}